@@ -0,0 +1,1088 @@
+# Code generated by universalbridge -openapi. DO NOT EDIT.
+"""Universal Bridge HTTP API client; see openapi.json."""
+
+from __future__ import annotations
+
+import json
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Dict, List, Optional, TypedDict
+
+
+class AISpend(TypedDict, total=False):
+    provider: str
+    model: str
+    requests: int
+    input_tokens: int
+    output_tokens: int
+    cost: float
+
+
+class AccountSnapshot(TypedDict, total=False):
+    taken_at: str
+    products: List["ProductSnapshot"]
+    subscribers: int
+    balance: List["Money"]
+    balance_since: str
+
+
+class Approval(TypedDict, total=False):
+    id: str
+    action: str
+    summary: str
+    args: Dict[str, Any]
+    created_at: str
+    link: str
+
+
+class Automation(TypedDict, total=False):
+    id: str
+    description: str
+    rules: str
+    problems: List[str]
+    created_at: str
+    activated_at: Optional[str]
+
+
+class CampaignStats(TypedDict, total=False):
+    campaign: str
+    codes: int
+    active: int
+    uses: int
+    revenue: Dict[str, int]
+
+
+class CheckoutStats(TypedDict, total=False):
+    product: str
+    visitors: int
+    views: int
+    converted: int
+    recovered: int
+    abandoned: int
+    conversion_rate: float
+
+
+class CustomerProfile(TypedDict, total=False):
+    email: str
+    name: str
+    locale: str
+    first_seen: str
+    last_seen: str
+    purchases: List["Sale"]
+    spend: Dict[str, int]
+    subscriptions: List["Subscription"]
+    support_messages: List["SupportMessage"]
+    lists: List[str]
+    opted_out: bool
+
+
+class DaySales(TypedDict, total=False):
+    date: str
+    sales: int
+    refunds: int
+    gross: List["Money"]
+    refunded: List["Money"]
+    net_revenue: List["Money"]
+    average_order: List["Money"]
+    buyers: int
+
+
+class DeadLetter(TypedDict, total=False):
+    message: "UniversalMessage"
+    channel: str
+    status: str
+    attempts: int
+    last_error: str
+    first_failed: str
+    last_failed: str
+    next_retry: Optional[str]
+
+
+class EntitlementGrant(TypedDict, total=False):
+    email: str
+    features: List[str]
+    memberships: List["Membership"]
+
+
+class ErrorBody(TypedDict, total=False):
+    error: str
+
+
+class Expense(TypedDict, total=False):
+    id: str
+    description: str
+    category: str
+    amount: "Money"
+    date: str
+    recurring: str
+    until: str
+    ai: "AISpend"
+
+
+class ExpenseCategory(TypedDict, total=False):
+    category: str
+    amount: List["Money"]
+
+
+class GetProductPricingResponse(TypedDict, total=False):
+    product: str
+    currencies: List["PriceStats"]
+
+
+class GetSegmentResponse(TypedDict, total=False):
+    segment: str
+    members: List["SegmentMember"]
+
+
+class GraphQLError(TypedDict, total=False):
+    message: str
+    path: List[Any]
+
+
+class GraphQLRequest(TypedDict, total=False):
+    query: str
+    variables: Dict[str, Any]
+    operationName: str
+
+
+class GraphQLResult(TypedDict, total=False):
+    data: Any
+    errors: List["GraphQLError"]
+
+
+class GumroadPingResponse(TypedDict, total=False):
+    id: str
+
+
+class HeldPurchase(TypedDict, total=False):
+    id: str
+    email: str
+    product: str
+    price: Any
+    reasons: List[str]
+    held_at: str
+    message: "UniversalMessage"
+
+
+class Job(TypedDict, total=False):
+    id: str
+    handler: str
+    schedule: str
+    run_at: Optional[str]
+    args: Dict[str, Any]
+    paused: bool
+    next_run: Optional[str]
+    last_run: Optional[str]
+    last_error: str
+    runs: int
+
+
+class LaunchHour(TypedDict, total=False):
+    start: str
+    sales: int
+
+
+class LaunchMilestone(TypedDict, total=False):
+    kind: str
+    at: str
+    sales: int
+    revenue: "Money"
+    text: str
+
+
+class LaunchProduct(TypedDict, total=False):
+    product: str
+    product_name: str
+    sales: int
+    refunds: int
+    revenue: List["Money"]
+
+
+class LaunchReport(TypedDict, total=False):
+    name: str
+    started_at: str
+    ended_at: Optional[str]
+    duration: str
+    sales: int
+    refunds: int
+    revenue: List["Money"]
+    products: List["LaunchProduct"]
+    offer_codes: Dict[str, int]
+    best_hour: "LaunchHour"
+    milestones: List["LaunchMilestone"]
+
+
+class LaunchStatus(TypedDict, total=False):
+    active: bool
+    report: "LaunchReport"
+
+
+class LicenseCheck(TypedDict, total=False):
+    product_id: str
+    valid: bool
+    reason: str
+    uses: int
+    product_name: str
+    quantity: int
+    subscribed: bool
+    checked_at: str
+    cached: bool
+    stale: bool
+
+
+class ListAccountSnapshotsResponse(TypedDict, total=False):
+    snapshots: List["AccountSnapshot"]
+
+
+class ListApprovalsResponse(TypedDict, total=False):
+    approvals: List["Approval"]
+
+
+class ListAutomationsResponse(TypedDict, total=False):
+    automations: List["Automation"]
+
+
+class ListCheckoutMetricsResponse(TypedDict, total=False):
+    products: List["CheckoutStats"]
+
+
+class ListCustomersResponse(TypedDict, total=False):
+    customers: List[Dict[str, Any]]
+
+
+ListDailySalesResponse = TypedDict(
+    "ListDailySalesResponse",
+    {
+        "from": str,
+        "to": str,
+        "days": List["DaySales"],
+    },
+    total=False,
+)
+
+
+class ListDeadLettersResponse(TypedDict, total=False):
+    dead_letters: List["DeadLetter"]
+
+
+class ListExpensesResponse(TypedDict, total=False):
+    expenses: List["Expense"]
+
+
+class ListHeldPurchasesResponse(TypedDict, total=False):
+    held: List["HeldPurchase"]
+
+
+class ListJobsResponse(TypedDict, total=False):
+    jobs: List["Job"]
+
+
+class ListLaunchReportsResponse(TypedDict, total=False):
+    reports: List["LaunchReport"]
+
+
+class ListMessageMetricsResponse(TypedDict, total=False):
+    messages: List["MessageStats"]
+
+
+class ListOfferCodesResponse(TypedDict, total=False):
+    campaigns: List["CampaignStats"]
+    codes: List["OfferCode"]
+
+
+class ListPriceWatchResponse(TypedDict, total=False):
+    pages: List["PageState"]
+
+
+class ListPricingResponse(TypedDict, total=False):
+    products: List["PriceStats"]
+
+
+class ListRecentSalesResponse(TypedDict, total=False):
+    sales: List["Sale"]
+
+
+ListSalesByProductResponse = TypedDict(
+    "ListSalesByProductResponse",
+    {
+        "from": str,
+        "to": str,
+        "products": List["ProductSales"],
+    },
+    total=False,
+)
+
+
+class ListSeasonalSalesResponse(TypedDict, total=False):
+    sales: List["SeasonalSale"]
+
+
+class ListSegmentsResponse(TypedDict, total=False):
+    segments: List["SegmentSummary"]
+
+
+class ListSinkMetricsResponse(TypedDict, total=False):
+    sinks: List["SinkStats"]
+
+
+class ListSplitStatementsResponse(TypedDict, total=False):
+    month: str
+    statements: List["SplitStatement"]
+
+
+class ListStreamSubscribersResponse(TypedDict, total=False):
+    events: int
+    subscribers: List["SubscriberStats"]
+
+
+class ListTrialMetricsResponse(TypedDict, total=False):
+    products: List["TrialStats"]
+
+
+class ListUsageMetricsResponse(TypedDict, total=False):
+    products: List["UsageStats"]
+
+
+class ListWaitlistsResponse(TypedDict, total=False):
+    waitlists: List["WaitlistStats"]
+
+
+class ListWinBackMetricsResponse(TypedDict, total=False):
+    campaigns: List["WinBackStats"]
+
+
+class LiveEvent(TypedDict, total=False):
+    type: str
+    at: str
+    sale: "LiveSale"
+    refund: "LiveRefund"
+    metrics: "LiveMetrics"
+    milestone: "LaunchMilestone"
+
+
+class LiveMetrics(TypedDict, total=False):
+    today: "LiveTotals"
+    last_hour: "LiveTotals"
+    all_time: "LiveTotals"
+
+
+class LiveRefund(TypedDict, total=False):
+    sale_id: str
+    product: str
+    product_name: str
+    amount: "Money"
+    display: str
+    partial: bool
+
+
+class LiveSale(TypedDict, total=False):
+    sale_id: str
+    product: str
+    product_name: str
+    price: "Money"
+    display: str
+    buyer: str
+    offer_code: str
+    gift: bool
+
+
+class LiveTotals(TypedDict, total=False):
+    sales: int
+    refunds: int
+    revenue: List["Money"]
+
+
+class Membership(TypedDict, total=False):
+    subscription_id: str
+    email: str
+    product: str
+    tier: str
+    status: str
+    updated_at: str
+
+
+class MessageStats(TypedDict, total=False):
+    message_type: str
+    sent: int
+    send_failed: int
+    received: int
+    expired: int
+    handled: int
+    failed: int
+    handler_ms: float
+
+
+class Money(TypedDict, total=False):
+    amount: int
+    currency: str
+
+
+class OfferCode(TypedDict, total=False):
+    id: str
+    product: str
+    code: str
+    campaign: str
+    amount_off: float
+    offer_type: str
+    max_uses: int
+    expires_at: Optional[str]
+    on_retire: str
+    status: str
+    uses: int
+    revenue: Dict[str, int]
+    created_at: str
+    retired_at: Optional[str]
+    retire_error: str
+
+
+class PageState(TypedDict, total=False):
+    name: str
+    url: str
+    own: bool
+    title: str
+    price: "Money"
+    description: str
+    checked_at: str
+    changed_at: str
+    error: str
+
+
+PriceBucket = TypedDict(
+    "PriceBucket",
+    {
+        "from": "Money",
+        "to": "Money",
+        "sales": int,
+    },
+    total=False,
+)
+
+
+class PriceStats(TypedDict, total=False):
+    product: str
+    product_name: str
+    currency: str
+    sales: int
+    free: int
+    revenue: "Money"
+    min: "Money"
+    max: "Money"
+    mean: "Money"
+    p10: "Money"
+    p25: "Money"
+    median: "Money"
+    p75: "Money"
+    p90: "Money"
+    suggested_minimum: "Money"
+    distribution: List["PriceBucket"]
+
+
+class ProductSales(TypedDict, total=False):
+    product: str
+    product_name: str
+    sales: int
+    refunds: int
+    gross: List["Money"]
+    refunded: List["Money"]
+    net_revenue: List["Money"]
+    average_order: List["Money"]
+    buyers: int
+
+
+class ProductSnapshot(TypedDict, total=False):
+    id: str
+    name: str
+    permalink: str
+    published: bool
+    price: "Money"
+    sales_count: int
+    subscribers: int
+    membership: bool
+
+
+ProfitReport = TypedDict(
+    "ProfitReport",
+    {
+        "from": str,
+        "to": str,
+        "sales": int,
+        "refunds": int,
+        "gross": List["Money"],
+        "refunded": List["Money"],
+        "revenue": List["Money"],
+        "expenses": List["Money"],
+        "net_profit": List["Money"],
+        "categories": List["ExpenseCategory"],
+    },
+    total=False,
+)
+
+
+class QueryMessagesResponse(TypedDict, total=False):
+    messages: List["StoredMessage"]
+
+
+class Sale(TypedDict, total=False):
+    sale_id: str
+    product: str
+    product_permalink: str
+    product_name: str
+    email: str
+    full_name: str
+    price: "Money"
+    offer_code: str
+    subscription_id: str
+    pay_what_you_want: bool
+    refunded: bool
+    gift: bool
+    gifter_email: str
+    bundle_sale_id: str
+    bundle_components: List[str]
+    created_at: str
+
+
+SalesSummary = TypedDict(
+    "SalesSummary",
+    {
+        "from": str,
+        "to": str,
+        "sales": int,
+        "refunds": int,
+        "gross": List["Money"],
+        "refunded": List["Money"],
+        "net_revenue": List["Money"],
+        "average_order": List["Money"],
+        "buyers": int,
+    },
+    total=False,
+)
+
+
+class SeasonalSale(TypedDict, total=False):
+    id: str
+    name: str
+    products: List[str]
+    code: str
+    amount_off: float
+    offer_type: str
+    starts_at: str
+    ends_at: str
+    announce: List[str]
+    status: str
+    descriptions: Dict[str, str]
+    errors: List[str]
+
+
+class SegmentMember(TypedDict, total=False):
+    email: str
+    joined_at: str
+
+
+class SegmentSummary(TypedDict, total=False):
+    name: str
+    description: str
+    members: int
+
+
+class SinkStats(TypedDict, total=False):
+    Name: str
+    State: str
+    Queued: int
+    Delivered: int
+    Failed: int
+    Dropped: int
+    Rejected: int
+
+
+class SplitLine(TypedDict, total=False):
+    sale_id: str
+    kind: str
+    at: str
+    product: str
+    product_name: str
+    gross: "Money"
+    fees: "Money"
+    net: "Money"
+    percent: float
+    share: "Money"
+
+
+class SplitStatement(TypedDict, total=False):
+    collaborator: str
+    name: str
+    month: str
+    sales: int
+    refunds: int
+    gross: List["Money"]
+    fees: List["Money"]
+    net: List["Money"]
+    share: List["Money"]
+    lines: List["SplitLine"]
+
+
+class StoredMessage(TypedDict, total=False):
+    id: str
+    direction: str
+    message_type: str
+    source_language: str
+    target_language: str
+    channel: str
+    correlation_id: str
+    status: str
+    attempts: int
+    error: str
+    timestamp: str
+    payload: Dict[str, Any]
+    first_seen: str
+    last_seen: str
+
+
+class SubscriberStats(TypedDict, total=False):
+    name: str
+    cursor: int
+    lag: int
+    delivered: int
+    failures: int
+    last_error: str
+
+
+class Subscription(TypedDict, total=False):
+    id: str
+    product: str
+    email: str
+    tier: str
+    status: str
+    updated_at: str
+
+
+class SupportMessage(TypedDict, total=False):
+    id: str
+    channel: str
+    subject: str
+    text: str
+    received_at: str
+
+
+class TrialStats(TypedDict, total=False):
+    product: str
+    trials: int
+    converted: int
+    lapsed: int
+    active: int
+    conversion_rate: float
+    median_days_to_purchase: float
+    converted_by_source: Dict[str, int]
+    trials_by_source: Dict[str, int]
+
+
+class UniversalMessage(TypedDict, total=False):
+    id: str
+    timestamp: str
+    message_type: str
+    source_language: str
+    target_language: str
+    payload: Dict[str, Any]
+    response_channel: str
+    checksum: str
+    sequence: int
+    headers: Dict[str, str]
+    content_encoding: str
+    expires_at: str
+    priority: str
+    idempotency_key: str
+    deliver_at: str
+
+
+class UsageStats(TypedDict, total=False):
+    product: str
+    licenses: int
+    activated: int
+    activation_rate: float
+    median_days_to_activate: float
+    installs: int
+    daily_active: int
+    weekly_active: int
+    monthly_active: int
+    stickiness: float
+    average_days_active: float
+    pings: int
+    events: Dict[str, int]
+    versions: Dict[str, int]
+
+
+class WaitlistStats(TypedDict, total=False):
+    product: str
+    signups: int
+    notified: int
+    converted: int
+    used_code: int
+    conversion_rate: float
+    launched_at: Optional[str]
+
+
+class WinBackStats(TypedDict, total=False):
+    campaign: str
+    enrolled: int
+    messaged: int
+    won: int
+    returned: int
+    active: int
+    lost: int
+    conversion_rate: float
+    revenue: Dict[str, int]
+
+
+class BridgeAPIError(Exception):
+    """An error response from the bridge"""
+
+    def __init__(self, status: int, message: str) -> None:
+        super().__init__(f"{status}: {message}")
+        self.status = status
+        self.message = message
+
+
+def _encode(params: Dict[str, Any]) -> str:
+    values = {}
+    for name, value in params.items():
+        if value is None:
+            continue
+        if isinstance(value, bool):
+            value = "true" if value else "false"
+        values[name] = str(value)
+    return urllib.parse.urlencode(values)
+
+
+class _Client:
+    def __init__(self, base_url: str, token: Optional[str] = None, timeout: float = 30.0) -> None:
+        self._base_url = base_url.rstrip("/")
+        self._token = token
+        self._timeout = timeout
+
+    def _authorize(self, query: Dict[str, Any], headers: Dict[str, str]) -> None:
+        if self._token:
+            headers["Authorization"] = f"Bearer {self._token}"
+
+    def _request(
+        self,
+        method: str,
+        path: str,
+        query: Optional[Dict[str, Any]] = None,
+        form: Optional[Dict[str, Any]] = None,
+        body: Any = None,
+        response: Optional[str] = None,
+    ) -> Any:
+        query = dict(query or {})
+        headers: Dict[str, str] = {}
+        self._authorize(query, headers)
+        url = self._base_url + path
+        encoded = _encode(query)
+        if encoded:
+            url += "?" + encoded
+
+        data = None
+        if form is not None:
+            headers["Content-Type"] = "application/x-www-form-urlencoded"
+            data = _encode(form).encode("utf-8")
+        elif body is not None:
+            headers["Content-Type"] = "application/json"
+            data = json.dumps(body).encode("utf-8")
+
+        request = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(request, timeout=self._timeout) as reply:
+                content = reply.read()
+        except urllib.error.HTTPError as err:
+            text = err.read().decode("utf-8", "replace")
+            message = text.strip() or str(err.reason)
+            try:
+                message = json.loads(text).get("error", message)
+            except (ValueError, AttributeError):
+                pass  # a plain text error
+            raise BridgeAPIError(err.code, message) from None
+
+        if response == "json":
+            return json.loads(content)
+        if response == "text":
+            return content.decode("utf-8")
+        return None
+
+
+class ApiClient(_Client):
+    """Seller API, on -api"""
+
+    def get_metrics(self) -> str:
+        """Prometheus metrics for bridge throughput and latency"""
+        return self._request("GET", "/metrics", response="text")
+
+    def list_sink_metrics(self) -> ListSinkMetricsResponse:
+        """Each sink's queue and delivery counts"""
+        return self._request("GET", "/metrics/sinks", response="json")
+
+    def list_message_metrics(self) -> ListMessageMetricsResponse:
+        """Each message type's send, receive and handler counts"""
+        return self._request("GET", "/metrics/messages", response="json")
+
+    def list_pricing(self) -> ListPricingResponse:
+        """Paid price distribution of every pay-what-you-want product"""
+        return self._request("GET", "/metrics/pricing", response="json")
+
+    def get_product_pricing(self, product: str) -> GetProductPricingResponse:
+        """Paid price distribution of one product, per currency"""
+        return self._request("GET", f"/metrics/pricing/{urllib.parse.quote(product, safe='')}", response="json")
+
+    def list_offer_codes(self) -> ListOfferCodesResponse:
+        """Tracked offer codes and their campaigns"""
+        return self._request("GET", "/metrics/offer-codes", response="json")
+
+    def list_waitlists(self) -> ListWaitlistsResponse:
+        """Signups and conversions of each waitlist"""
+        return self._request("GET", "/metrics/waitlists", response="json")
+
+    def list_checkout_metrics(self) -> ListCheckoutMetricsResponse:
+        """How each product's tracked views converted"""
+        return self._request("GET", "/metrics/checkouts", response="json")
+
+    def list_win_back_metrics(self) -> ListWinBackMetricsResponse:
+        """Enrollments and conversions of each win-back campaign"""
+        return self._request("GET", "/metrics/winback", response="json")
+
+    def list_split_statements(self, *, month: Optional[str] = None) -> ListSplitStatementsResponse:
+        """Every collaborator's revenue split statement for a month"""
+        return self._request("GET", "/splits/statements", query={"month": month}, response="json")
+
+    def get_split_statement(self, collaborator: str, *, month: Optional[str] = None, format: Optional[str] = None) -> SplitStatement:
+        """One collaborator's revenue split statement for a month, as JSON or CSV"""
+        return self._request("GET", f"/splits/statements/{urllib.parse.quote(collaborator, safe='')}", query={"month": month, "format": format}, response="json")
+
+    def list_usage_metrics(self) -> ListUsageMetricsResponse:
+        """Activation and engagement of each licensed product"""
+        return self._request("GET", "/metrics/usage", response="json")
+
+    def list_trial_metrics(self) -> ListTrialMetricsResponse:
+        """How each product's trials converted"""
+        return self._request("GET", "/metrics/trials", response="json")
+
+    def get_profit(self, *, from: Optional[str] = None, to: Optional[str] = None) -> ProfitReport:
+        """Revenue, expenses and net profit over a range of days"""
+        return self._request("GET", "/metrics/profit", query={"from": from, "to": to}, response="json")
+
+    def list_account_snapshots(self, *, from: Optional[str] = None, to: Optional[str] = None) -> ListAccountSnapshotsResponse:
+        """Account snapshots taken over a range of days: products, sales counts, subscribers and the estimated balance"""
+        return self._request("GET", "/metrics/snapshots", query={"from": from, "to": to}, response="json")
+
+    def list_price_watch(self) -> ListPriceWatchResponse:
+        """Each watched product page's last price, description and check"""
+        return self._request("GET", "/metrics/price-watch", response="json")
+
+    def get_sales_summary(self, *, from: Optional[str] = None, to: Optional[str] = None, product: Optional[str] = None) -> SalesSummary:
+        """Sales, refunds, net revenue and average order over a range of days"""
+        return self._request("GET", "/sales/summary", query={"from": from, "to": to, "product": product}, response="json")
+
+    def list_sales_by_product(self, *, from: Optional[str] = None, to: Optional[str] = None) -> ListSalesByProductResponse:
+        """Each product's sales, refunds and net revenue over a range of days"""
+        return self._request("GET", "/sales/by-product", query={"from": from, "to": to}, response="json")
+
+    def list_daily_sales(self, *, from: Optional[str] = None, to: Optional[str] = None, product: Optional[str] = None) -> ListDailySalesResponse:
+        """Sales, refunds and net revenue of every day in a range of up to a year"""
+        return self._request("GET", "/sales/daily", query={"from": from, "to": to, "product": product}, response="json")
+
+    def list_recent_sales(self, *, limit: Optional[str] = None, product: Optional[str] = None) -> ListRecentSalesResponse:
+        """The latest sales, newest first"""
+        return self._request("GET", "/sales/recent", query={"limit": limit, "product": product}, response="json")
+
+    def list_expenses(self) -> ListExpensesResponse:
+        """Logged expenses, with the AI spend recorded per day"""
+        return self._request("GET", "/expenses", response="json")
+
+    def add_expense(self, body: Expense) -> Expense:
+        """Log a one-off, monthly or yearly expense"""
+        return self._request("POST", "/expenses", body=body, response="json")
+
+    def delete_expense(self, id: str) -> None:
+        """Remove an expense"""
+        return self._request("DELETE", f"/expenses/{urllib.parse.quote(id, safe='')}")
+
+    def get_launch(self) -> LaunchStatus:
+        """Whether launch mode is on and the running launch's report so far"""
+        return self._request("GET", "/launch", response="json")
+
+    def start_launch(self, body: Dict[str, Any]) -> LaunchReport:
+        """Turn on launch mode"""
+        return self._request("POST", "/launch/start", body=body, response="json")
+
+    def stop_launch(self) -> LaunchReport:
+        """Turn off launch mode and write the post-launch report"""
+        return self._request("POST", "/launch/stop", response="json")
+
+    def list_launch_reports(self) -> ListLaunchReportsResponse:
+        """Reports of finished launches"""
+        return self._request("GET", "/launch/reports", response="json")
+
+    def list_customers(self) -> ListCustomersResponse:
+        """A summary of every customer"""
+        return self._request("GET", "/customers", response="json")
+
+    def get_customer(self, email: str) -> CustomerProfile:
+        """A customer's full profile"""
+        return self._request("GET", f"/customers/{urllib.parse.quote(email, safe='')}", response="json")
+
+    def list_segments(self) -> ListSegmentsResponse:
+        """Every customer segment and its size"""
+        return self._request("GET", "/segments", response="json")
+
+    def get_segment(self, name: str) -> GetSegmentResponse:
+        """The customers in a segment"""
+        return self._request("GET", f"/segments/{urllib.parse.quote(name, safe='')}", response="json")
+
+    def list_seasonal_sales(self) -> ListSeasonalSalesResponse:
+        """Scheduled, running and finished seasonal sales"""
+        return self._request("GET", "/seasonal-sales", response="json")
+
+    def schedule_seasonal_sale(self, body: SeasonalSale) -> SeasonalSale:
+        """Schedule a seasonal sale"""
+        return self._request("POST", "/seasonal-sales", body=body, response="json")
+
+    def list_stream_subscribers(self) -> ListStreamSubscribersResponse:
+        """The sale event stream's length and each subscriber's cursor"""
+        return self._request("GET", "/stream/subscribers", response="json")
+
+    def get_calendar(self) -> str:
+        """Launches, seasonal sales and payout dates as an iCalendar feed"""
+        return self._request("GET", "/calendar.ics", response="text")
+
+    def list_dead_letters(self, *, status: Optional[str] = None) -> ListDeadLettersResponse:
+        """Messages whose handler failed"""
+        return self._request("GET", "/dead-letters", query={"status": status}, response="json")
+
+    def requeue_dead_letter(self, id: str) -> DeadLetter:
+        """Retry a dead letter now"""
+        return self._request("POST", f"/dead-letters/{urllib.parse.quote(id, safe='')}/requeue", response="json")
+
+    def verify_license(self, product_id: str, license_key: str) -> LicenseCheck:
+        """Check a license key"""
+        return self._request("GET", "/licenses/verify", query={"product_id": product_id, "license_key": license_key}, response="json")
+
+    def activate_license(self, product_id: str, license_key: str, *, increment_uses_count: Optional[bool] = None) -> LicenseCheck:
+        """Check a license key, counting an activation with increment_uses_count"""
+        return self._request("POST", "/licenses/verify", form={"product_id": product_id, "license_key": license_key, "increment_uses_count": increment_uses_count}, response="json")
+
+    def list_jobs(self) -> ListJobsResponse:
+        """Scheduled jobs"""
+        return self._request("GET", "/jobs", response="json")
+
+    def pause_job(self, id: str) -> Job:
+        """Stop a job running on schedule"""
+        return self._request("POST", f"/jobs/{urllib.parse.quote(id, safe='')}/pause", response="json")
+
+    def resume_job(self, id: str) -> Job:
+        """Put a paused job back on schedule"""
+        return self._request("POST", f"/jobs/{urllib.parse.quote(id, safe='')}/resume", response="json")
+
+    def trigger_job(self, id: str) -> Job:
+        """Run a job now"""
+        return self._request("POST", f"/jobs/{urllib.parse.quote(id, safe='')}/trigger", response="json")
+
+    def list_automations(self) -> ListAutomationsResponse:
+        """Drafted and approved automations"""
+        return self._request("GET", "/automations", response="json")
+
+    def draft_automation(self, body: Dict[str, Any]) -> Automation:
+        """Have the AI provider draft the rules of an automation described in plain English"""
+        return self._request("POST", "/automations", body=body, response="json")
+
+    def edit_automation(self, id: str, body: Dict[str, Any]) -> Automation:
+        """Replace a draft's rules"""
+        return self._request("PUT", f"/automations/{urllib.parse.quote(id, safe='')}", body=body, response="json")
+
+    def approve_automation(self, id: str) -> Automation:
+        """Put a draft's rules in force"""
+        return self._request("POST", f"/automations/{urllib.parse.quote(id, safe='')}/approve", response="json")
+
+    def delete_automation(self, id: str) -> None:
+        """Remove an automation, taking its rules out of force"""
+        return self._request("DELETE", f"/automations/{urllib.parse.quote(id, safe='')}")
+
+    def list_held_purchases(self) -> ListHeldPurchasesResponse:
+        """Suspicious sales held back from fulfilment for review"""
+        return self._request("GET", "/fraud/held", response="json")
+
+    def release_held_purchase(self, id: str) -> HeldPurchase:
+        """Pass a held sale on to be recorded and fulfilled"""
+        return self._request("POST", f"/fraud/held/{urllib.parse.quote(id, safe='')}/release", response="json")
+
+    def reject_held_purchase(self, id: str) -> HeldPurchase:
+        """Drop a held sale without fulfilling it"""
+        return self._request("POST", f"/fraud/held/{urllib.parse.quote(id, safe='')}/reject", response="json")
+
+    def list_approvals(self) -> ListApprovalsResponse:
+        """Actions waiting for a person to approve them, oldest first"""
+        return self._request("GET", "/approvals", response="json")
+
+    def approve_approval(self, id: str) -> Approval:
+        """Run a waiting action; one that fails stays waiting"""
+        return self._request("POST", f"/approvals/{urllib.parse.quote(id, safe='')}/approve", response="json")
+
+    def reject_approval(self, id: str) -> Approval:
+        """Drop a waiting action without running it"""
+        return self._request("POST", f"/approvals/{urllib.parse.quote(id, safe='')}/reject", response="json")
+
+    def query_messages(self, *, type: Optional[str] = None, direction: Optional[str] = None, status: Optional[str] = None, correlation_id: Optional[str] = None, since: Optional[str] = None, until: Optional[str] = None, limit: Optional[int] = None) -> QueryMessagesResponse:
+        """Sent and received messages from the message store"""
+        return self._request("GET", "/messages", query={"type": type, "direction": direction, "status": status, "correlation_id": correlation_id, "since": since, "until": until, "limit": limit}, response="json")
+
+    def get_graphql_schema(self) -> str:
+        """The GraphQL schema, in SDL; with a query parameter it runs the query instead"""
+        return self._request("GET", "/graphql", response="text")
+
+    def run_graphql_query(self, body: GraphQLRequest) -> GraphQLResult:
+        """Run a GraphQL query over sales, customers, products and messages"""
+        return self._request("POST", "/graphql", body=body, response="json")
+
+    def get_entitlements(self, email: str) -> EntitlementGrant:
+        """The features a member's tier grants"""
+        return self._request("GET", f"/entitlements/{urllib.parse.quote(email, safe='')}", response="json")
+
+    def get_open_api_spec(self) -> Dict[str, Any]:
+        """This specification"""
+        return self._request("GET", "/openapi.json", response="json")
+
+
+class PortalClient(_Client):
+    """Customer portal and the public endpoints sold apps call, on -portal"""
+
+    def track_checkout_view(self, email: str, product: str, *, source: Optional[str] = None) -> None:
+        """Record a product view for abandoned checkout follow-ups"""
+        return self._request("POST", "/track", form={"email": email, "product": product, "source": source})
+
+    def check_license(self, product_id: str, license_key: str) -> LicenseCheck:
+        """Check a license key from a sold app"""
+        return self._request("GET", "/licenses/verify", query={"product_id": product_id, "license_key": license_key}, response="json")
+
+    def activate_app_license(self, product_id: str, license_key: str, *, increment_uses_count: Optional[bool] = None) -> LicenseCheck:
+        """Check a license key from a sold app, counting an activation with increment_uses_count"""
+        return self._request("POST", "/licenses/verify", form={"product_id": product_id, "license_key": license_key, "increment_uses_count": increment_uses_count}, response="json")
+
+    def report_usage(self, product_id: str, install_id: str, *, license_key: Optional[str] = None, event: Optional[str] = None, version: Optional[str] = None) -> None:
+        """Report an anonymous usage ping; without a license_key it starts the install's trial"""
+        return self._request("POST", "/usage", form={"product_id": product_id, "license_key": license_key, "install_id": install_id, "event": event, "version": version})
+
+
+class WebhookClient(_Client):
+    """Gumroad ping receiver, on -webhook"""
+
+    def _authorize(self, query: Dict[str, Any], headers: Dict[str, str]) -> None:
+        if self._token:
+            query["secret"] = self._token
+
+    def gumroad_ping(self, fields: Dict[str, str], *, resource_name: Optional[str] = None) -> GumroadPingResponse:
+        """A Gumroad ping, as the form Gumroad posts"""
+        return self._request("POST", "/webhook/gumroad", form={"resource_name": resource_name, **fields}, response="json")
+
+
+class PeerClient(_Client):
+    """Inbound messages from peer bridges, on -http"""
+
+    def post_message(self, body: UniversalMessage) -> None:
+        """Deliver a message from a peer bridge; answers once it is handled"""
+        return self._request("POST", "/messages", body=body)
@@ -0,0 +1,1133 @@
+// Code generated by universalbridge -openapi. DO NOT EDIT.
+// Universal Bridge HTTP API client; see openapi.json.
+
+export interface AISpend {
+    provider: string;
+    model: string;
+    requests: number;
+    input_tokens: number;
+    output_tokens: number;
+    cost: number;
+}
+
+export interface AccountSnapshot {
+    taken_at: string;
+    products: ProductSnapshot[];
+    subscribers: number;
+    balance: Money[];
+    balance_since: string;
+}
+
+export interface Approval {
+    id: string;
+    action: string;
+    summary: string;
+    args: Record<string, unknown>;
+    created_at: string;
+    link?: string;
+}
+
+export interface Automation {
+    id: string;
+    description: string;
+    rules: string;
+    problems?: string[];
+    created_at: string;
+    activated_at?: string | null;
+}
+
+export interface CampaignStats {
+    campaign: string;
+    codes: number;
+    active: number;
+    uses: number;
+    revenue: Record<string, number>;
+}
+
+export interface CheckoutStats {
+    product: string;
+    visitors: number;
+    views: number;
+    converted: number;
+    recovered: number;
+    abandoned: number;
+    conversion_rate: number;
+}
+
+export interface CustomerProfile {
+    email: string;
+    name?: string;
+    locale?: string;
+    first_seen: string;
+    last_seen: string;
+    purchases: Sale[];
+    spend: Record<string, number>;
+    subscriptions: Subscription[];
+    support_messages: SupportMessage[];
+    lists: string[];
+    opted_out: boolean;
+}
+
+export interface DaySales {
+    date: string;
+    sales: number;
+    refunds: number;
+    gross: Money[];
+    refunded: Money[];
+    net_revenue: Money[];
+    average_order: Money[];
+    buyers: number;
+}
+
+export interface DeadLetter {
+    message: UniversalMessage;
+    channel: string;
+    status: string;
+    attempts: number;
+    last_error: string;
+    first_failed: string;
+    last_failed: string;
+    next_retry?: string | null;
+}
+
+export interface EntitlementGrant {
+    email: string;
+    features: string[];
+    memberships: Membership[];
+}
+
+export interface ErrorBody {
+    error: string;
+}
+
+export interface Expense {
+    id: string;
+    description: string;
+    category: string;
+    amount: Money;
+    date: string;
+    recurring?: string;
+    until?: string;
+    ai?: AISpend;
+}
+
+export interface ExpenseCategory {
+    category: string;
+    amount: Money[];
+}
+
+export interface GetProductPricingResponse {
+    product: string;
+    currencies: PriceStats[];
+}
+
+export interface GetSegmentResponse {
+    segment: string;
+    members: SegmentMember[];
+}
+
+export interface GraphQLError {
+    message: string;
+    path?: unknown[];
+}
+
+export interface GraphQLRequest {
+    query: string;
+    variables?: Record<string, unknown>;
+    operationName?: string;
+}
+
+export interface GraphQLResult {
+    data?: unknown;
+    errors?: GraphQLError[];
+}
+
+export interface GumroadPingResponse {
+    id: string;
+}
+
+export interface HeldPurchase {
+    id: string;
+    email: string;
+    product: string;
+    price?: unknown;
+    reasons: string[];
+    held_at: string;
+    message: UniversalMessage;
+}
+
+export interface Job {
+    id: string;
+    handler: string;
+    schedule?: string;
+    run_at?: string | null;
+    args?: Record<string, unknown>;
+    paused: boolean;
+    next_run?: string | null;
+    last_run?: string | null;
+    last_error?: string;
+    runs: number;
+}
+
+export interface LaunchHour {
+    start: string;
+    sales: number;
+}
+
+export interface LaunchMilestone {
+    kind: string;
+    at: string;
+    sales: number;
+    revenue?: Money;
+    text: string;
+}
+
+export interface LaunchProduct {
+    product: string;
+    product_name?: string;
+    sales: number;
+    refunds: number;
+    revenue: Money[];
+}
+
+export interface LaunchReport {
+    name: string;
+    started_at: string;
+    ended_at?: string | null;
+    duration: string;
+    sales: number;
+    refunds: number;
+    revenue: Money[];
+    products: LaunchProduct[];
+    offer_codes?: Record<string, number>;
+    best_hour?: LaunchHour;
+    milestones: LaunchMilestone[];
+}
+
+export interface LaunchStatus {
+    active: boolean;
+    report?: LaunchReport;
+}
+
+export interface LicenseCheck {
+    product_id: string;
+    valid: boolean;
+    reason?: string;
+    uses: number;
+    product_name?: string;
+    quantity?: number;
+    subscribed: boolean;
+    checked_at: string;
+    cached: boolean;
+    stale?: boolean;
+}
+
+export interface ListAccountSnapshotsResponse {
+    snapshots: AccountSnapshot[];
+}
+
+export interface ListApprovalsResponse {
+    approvals: Approval[];
+}
+
+export interface ListAutomationsResponse {
+    automations: Automation[];
+}
+
+export interface ListCheckoutMetricsResponse {
+    products: CheckoutStats[];
+}
+
+export interface ListCustomersResponse {
+    customers: Record<string, unknown>[];
+}
+
+export interface ListDailySalesResponse {
+    from: string;
+    to: string;
+    days: DaySales[];
+}
+
+export interface ListDeadLettersResponse {
+    dead_letters: DeadLetter[];
+}
+
+export interface ListExpensesResponse {
+    expenses: Expense[];
+}
+
+export interface ListHeldPurchasesResponse {
+    held: HeldPurchase[];
+}
+
+export interface ListJobsResponse {
+    jobs: Job[];
+}
+
+export interface ListLaunchReportsResponse {
+    reports: LaunchReport[];
+}
+
+export interface ListMessageMetricsResponse {
+    messages: MessageStats[];
+}
+
+export interface ListOfferCodesResponse {
+    campaigns: CampaignStats[];
+    codes: OfferCode[];
+}
+
+export interface ListPriceWatchResponse {
+    pages: PageState[];
+}
+
+export interface ListPricingResponse {
+    products: PriceStats[];
+}
+
+export interface ListRecentSalesResponse {
+    sales: Sale[];
+}
+
+export interface ListSalesByProductResponse {
+    from: string;
+    to: string;
+    products: ProductSales[];
+}
+
+export interface ListSeasonalSalesResponse {
+    sales: SeasonalSale[];
+}
+
+export interface ListSegmentsResponse {
+    segments: SegmentSummary[];
+}
+
+export interface ListSinkMetricsResponse {
+    sinks: SinkStats[];
+}
+
+export interface ListSplitStatementsResponse {
+    month: string;
+    statements: SplitStatement[];
+}
+
+export interface ListStreamSubscribersResponse {
+    events: number;
+    subscribers: SubscriberStats[];
+}
+
+export interface ListTrialMetricsResponse {
+    products: TrialStats[];
+}
+
+export interface ListUsageMetricsResponse {
+    products: UsageStats[];
+}
+
+export interface ListWaitlistsResponse {
+    waitlists: WaitlistStats[];
+}
+
+export interface ListWinBackMetricsResponse {
+    campaigns: WinBackStats[];
+}
+
+export interface LiveEvent {
+    type: string;
+    at: string;
+    sale?: LiveSale;
+    refund?: LiveRefund;
+    metrics?: LiveMetrics;
+    milestone?: LaunchMilestone;
+}
+
+export interface LiveMetrics {
+    today: LiveTotals;
+    last_hour: LiveTotals;
+    all_time: LiveTotals;
+}
+
+export interface LiveRefund {
+    sale_id: string;
+    product: string;
+    product_name?: string;
+    amount: Money;
+    display: string;
+    partial?: boolean;
+}
+
+export interface LiveSale {
+    sale_id: string;
+    product: string;
+    product_name?: string;
+    price: Money;
+    display: string;
+    buyer?: string;
+    offer_code?: string;
+    gift?: boolean;
+}
+
+export interface LiveTotals {
+    sales: number;
+    refunds: number;
+    revenue: Money[];
+}
+
+export interface Membership {
+    subscription_id: string;
+    email: string;
+    product: string;
+    tier: string;
+    status: string;
+    updated_at: string;
+}
+
+export interface MessageStats {
+    message_type: string;
+    sent: number;
+    send_failed: number;
+    received: number;
+    expired: number;
+    handled: number;
+    failed: number;
+    handler_ms: number;
+}
+
+export interface Money {
+    amount: number;
+    currency: string;
+}
+
+export interface OfferCode {
+    id: string;
+    product: string;
+    code: string;
+    campaign?: string;
+    amount_off: number;
+    offer_type: string;
+    max_uses?: number;
+    expires_at?: string | null;
+    on_retire: string;
+    status: string;
+    uses: number;
+    revenue: Record<string, number>;
+    created_at: string;
+    retired_at?: string | null;
+    retire_error?: string;
+}
+
+export interface PageState {
+    name: string;
+    url: string;
+    own: boolean;
+    title?: string;
+    price?: Money;
+    description?: string;
+    checked_at: string;
+    changed_at: string;
+    error?: string;
+}
+
+export interface PriceBucket {
+    from: Money;
+    to: Money;
+    sales: number;
+}
+
+export interface PriceStats {
+    product: string;
+    product_name: string;
+    currency: string;
+    sales: number;
+    free: number;
+    revenue: Money;
+    min: Money;
+    max: Money;
+    mean: Money;
+    p10: Money;
+    p25: Money;
+    median: Money;
+    p75: Money;
+    p90: Money;
+    suggested_minimum?: Money;
+    distribution: PriceBucket[];
+}
+
+export interface ProductSales {
+    product: string;
+    product_name?: string;
+    sales: number;
+    refunds: number;
+    gross: Money[];
+    refunded: Money[];
+    net_revenue: Money[];
+    average_order: Money[];
+    buyers: number;
+}
+
+export interface ProductSnapshot {
+    id: string;
+    name: string;
+    permalink?: string;
+    published: boolean;
+    price: Money;
+    sales_count: number;
+    subscribers: number;
+    membership: boolean;
+}
+
+export interface ProfitReport {
+    from: string;
+    to: string;
+    sales: number;
+    refunds: number;
+    gross: Money[];
+    refunded: Money[];
+    revenue: Money[];
+    expenses: Money[];
+    net_profit: Money[];
+    categories: ExpenseCategory[];
+}
+
+export interface QueryMessagesResponse {
+    messages: StoredMessage[];
+}
+
+export interface Sale {
+    sale_id: string;
+    product: string;
+    product_permalink?: string;
+    product_name?: string;
+    email?: string;
+    full_name?: string;
+    price: Money;
+    offer_code?: string;
+    subscription_id?: string;
+    pay_what_you_want?: boolean;
+    refunded?: boolean;
+    gift?: boolean;
+    gifter_email?: string;
+    bundle_sale_id?: string;
+    bundle_components?: string[];
+    created_at: string;
+}
+
+export interface SalesSummary {
+    from: string;
+    to: string;
+    sales: number;
+    refunds: number;
+    gross: Money[];
+    refunded: Money[];
+    net_revenue: Money[];
+    average_order: Money[];
+    buyers: number;
+}
+
+export interface SeasonalSale {
+    id: string;
+    name: string;
+    products: string[];
+    code: string;
+    amount_off: number;
+    offer_type: string;
+    starts_at: string;
+    ends_at: string;
+    announce?: string[];
+    status: string;
+    descriptions?: Record<string, string>;
+    errors?: string[];
+}
+
+export interface SegmentMember {
+    email: string;
+    joined_at: string;
+}
+
+export interface SegmentSummary {
+    name: string;
+    description?: string;
+    members: number;
+}
+
+export interface SinkStats {
+    Name: string;
+    State: string;
+    Queued: number;
+    Delivered: number;
+    Failed: number;
+    Dropped: number;
+    Rejected: number;
+}
+
+export interface SplitLine {
+    sale_id: string;
+    kind: string;
+    at: string;
+    product: string;
+    product_name?: string;
+    gross: Money;
+    fees: Money;
+    net: Money;
+    percent: number;
+    share: Money;
+}
+
+export interface SplitStatement {
+    collaborator: string;
+    name?: string;
+    month: string;
+    sales: number;
+    refunds: number;
+    gross: Money[];
+    fees: Money[];
+    net: Money[];
+    share: Money[];
+    lines: SplitLine[];
+}
+
+export interface StoredMessage {
+    id: string;
+    direction: string;
+    message_type: string;
+    source_language: string;
+    target_language: string;
+    channel: string;
+    correlation_id: string;
+    status: string;
+    attempts: number;
+    error?: string;
+    timestamp: string;
+    payload: Record<string, unknown>;
+    first_seen: string;
+    last_seen: string;
+}
+
+export interface SubscriberStats {
+    name: string;
+    cursor: number;
+    lag: number;
+    delivered: number;
+    failures: number;
+    last_error?: string;
+}
+
+export interface Subscription {
+    id: string;
+    product: string;
+    email?: string;
+    tier?: string;
+    status: string;
+    updated_at: string;
+}
+
+export interface SupportMessage {
+    id?: string;
+    channel?: string;
+    subject?: string;
+    text: string;
+    received_at: string;
+}
+
+export interface TrialStats {
+    product: string;
+    trials: number;
+    converted: number;
+    lapsed: number;
+    active: number;
+    conversion_rate: number;
+    median_days_to_purchase: number;
+    converted_by_source?: Record<string, number>;
+    trials_by_source?: Record<string, number>;
+}
+
+export interface UniversalMessage {
+    id: string;
+    timestamp: string;
+    message_type: string;
+    source_language: string;
+    target_language: string;
+    payload: Record<string, unknown>;
+    response_channel: string;
+    checksum: string;
+    sequence?: number;
+    headers?: Record<string, string>;
+    content_encoding?: string;
+    expires_at?: string;
+    priority?: string;
+    idempotency_key?: string;
+    deliver_at?: string;
+}
+
+export interface UsageStats {
+    product: string;
+    licenses: number;
+    activated: number;
+    activation_rate: number;
+    median_days_to_activate: number;
+    installs: number;
+    daily_active: number;
+    weekly_active: number;
+    monthly_active: number;
+    stickiness: number;
+    average_days_active: number;
+    pings: number;
+    events?: Record<string, number>;
+    versions?: Record<string, number>;
+}
+
+export interface WaitlistStats {
+    product: string;
+    signups: number;
+    notified: number;
+    converted: number;
+    used_code: number;
+    conversion_rate: number;
+    launched_at?: string | null;
+}
+
+export interface WinBackStats {
+    campaign: string;
+    enrolled: number;
+    messaged: number;
+    won: number;
+    returned: number;
+    active: number;
+    lost: number;
+    conversion_rate: number;
+    revenue: Record<string, number>;
+}
+
+export interface ClientOptions {
+    /** Bearer token; for WebhookClient, the ping secret */
+    token?: string;
+    /** A fetch implementation, for runtimes without a global one */
+    fetch?: typeof fetch;
+    headers?: Record<string, string>;
+}
+
+type Params = Record<string, string | number | boolean | undefined>;
+
+interface RequestOptions {
+    query?: Params;
+    form?: Params;
+    body?: unknown;
+    response?: 'json' | 'text';
+}
+
+export class BridgeAPIError extends Error {
+    readonly status: number;
+
+    constructor(status: number, message: string) {
+        super(`${status}: ${message}`);
+        this.name = 'BridgeAPIError';
+        this.status = status;
+    }
+}
+
+function defined(params: Params): Record<string, string> {
+    const values: Record<string, string> = {};
+    for (const [name, value] of Object.entries(params)) {
+        if (value !== undefined) {
+            values[name] = String(value);
+        }
+    }
+    return values;
+}
+
+abstract class BaseClient {
+    protected readonly baseUrl: string;
+    protected readonly options: ClientOptions;
+
+    constructor(baseUrl: string, options: ClientOptions = {}) {
+        this.baseUrl = baseUrl.replace(/\/+$/, '');
+        this.options = options;
+    }
+
+    protected authorize(url: URL, headers: Record<string, string>): void {
+        if (this.options.token) {
+            headers['Authorization'] = `Bearer ${this.options.token}`;
+        }
+    }
+
+    protected async request<T>(method: string, path: string, options: RequestOptions = {}): Promise<T> {
+        const url = new URL(this.baseUrl + path);
+        for (const [name, value] of Object.entries(defined(options.query ?? {}))) {
+            url.searchParams.set(name, value);
+        }
+        const headers: Record<string, string> = { ...this.options.headers };
+        this.authorize(url, headers);
+
+        let body: string | undefined;
+        if (options.form) {
+            headers['Content-Type'] = 'application/x-www-form-urlencoded';
+            body = new URLSearchParams(defined(options.form)).toString();
+        } else if (options.body !== undefined) {
+            headers['Content-Type'] = 'application/json';
+            body = JSON.stringify(options.body);
+        }
+
+        const response = await (this.options.fetch ?? fetch)(url.toString(), { method, headers, body });
+        if (!response.ok) {
+            const text = await response.text();
+            let message = text.trim() || response.statusText;
+            try {
+                message = JSON.parse(text).error ?? message;
+            } catch {
+                // a plain text error
+            }
+            throw new BridgeAPIError(response.status, message);
+        }
+        if (options.response === 'json') {
+            return (await response.json()) as T;
+        }
+        if (options.response === 'text') {
+            return (await response.text()) as unknown as T;
+        }
+        return undefined as unknown as T;
+    }
+}
+
+/** Seller API, on -api */
+export class ApiClient extends BaseClient {
+    /** Prometheus metrics for bridge throughput and latency */
+    getMetrics(): Promise<string> {
+        return this.request<string>('GET', '/metrics', { response: 'text' });
+    }
+
+    /** Each sink's queue and delivery counts */
+    listSinkMetrics(): Promise<ListSinkMetricsResponse> {
+        return this.request<ListSinkMetricsResponse>('GET', '/metrics/sinks', { response: 'json' });
+    }
+
+    /** Each message type's send, receive and handler counts */
+    listMessageMetrics(): Promise<ListMessageMetricsResponse> {
+        return this.request<ListMessageMetricsResponse>('GET', '/metrics/messages', { response: 'json' });
+    }
+
+    /** Paid price distribution of every pay-what-you-want product */
+    listPricing(): Promise<ListPricingResponse> {
+        return this.request<ListPricingResponse>('GET', '/metrics/pricing', { response: 'json' });
+    }
+
+    /** Paid price distribution of one product, per currency */
+    getProductPricing(product: string): Promise<GetProductPricingResponse> {
+        return this.request<GetProductPricingResponse>('GET', `/metrics/pricing/${encodeURIComponent(product)}`, { response: 'json' });
+    }
+
+    /** Tracked offer codes and their campaigns */
+    listOfferCodes(): Promise<ListOfferCodesResponse> {
+        return this.request<ListOfferCodesResponse>('GET', '/metrics/offer-codes', { response: 'json' });
+    }
+
+    /** Signups and conversions of each waitlist */
+    listWaitlists(): Promise<ListWaitlistsResponse> {
+        return this.request<ListWaitlistsResponse>('GET', '/metrics/waitlists', { response: 'json' });
+    }
+
+    /** How each product's tracked views converted */
+    listCheckoutMetrics(): Promise<ListCheckoutMetricsResponse> {
+        return this.request<ListCheckoutMetricsResponse>('GET', '/metrics/checkouts', { response: 'json' });
+    }
+
+    /** Enrollments and conversions of each win-back campaign */
+    listWinBackMetrics(): Promise<ListWinBackMetricsResponse> {
+        return this.request<ListWinBackMetricsResponse>('GET', '/metrics/winback', { response: 'json' });
+    }
+
+    /** Every collaborator's revenue split statement for a month */
+    listSplitStatements(params: { month?: string } = {}): Promise<ListSplitStatementsResponse> {
+        return this.request<ListSplitStatementsResponse>('GET', '/splits/statements', { query: params, response: 'json' });
+    }
+
+    /** One collaborator's revenue split statement for a month, as JSON or CSV */
+    getSplitStatement(collaborator: string, params: { month?: string; format?: string } = {}): Promise<SplitStatement> {
+        return this.request<SplitStatement>('GET', `/splits/statements/${encodeURIComponent(collaborator)}`, { query: params, response: 'json' });
+    }
+
+    /** Activation and engagement of each licensed product */
+    listUsageMetrics(): Promise<ListUsageMetricsResponse> {
+        return this.request<ListUsageMetricsResponse>('GET', '/metrics/usage', { response: 'json' });
+    }
+
+    /** How each product's trials converted */
+    listTrialMetrics(): Promise<ListTrialMetricsResponse> {
+        return this.request<ListTrialMetricsResponse>('GET', '/metrics/trials', { response: 'json' });
+    }
+
+    /** Revenue, expenses and net profit over a range of days */
+    getProfit(params: { from?: string; to?: string } = {}): Promise<ProfitReport> {
+        return this.request<ProfitReport>('GET', '/metrics/profit', { query: params, response: 'json' });
+    }
+
+    /** Account snapshots taken over a range of days: products, sales counts, subscribers and the estimated balance */
+    listAccountSnapshots(params: { from?: string; to?: string } = {}): Promise<ListAccountSnapshotsResponse> {
+        return this.request<ListAccountSnapshotsResponse>('GET', '/metrics/snapshots', { query: params, response: 'json' });
+    }
+
+    /** Each watched product page's last price, description and check */
+    listPriceWatch(): Promise<ListPriceWatchResponse> {
+        return this.request<ListPriceWatchResponse>('GET', '/metrics/price-watch', { response: 'json' });
+    }
+
+    /** Sales, refunds, net revenue and average order over a range of days */
+    getSalesSummary(params: { from?: string; to?: string; product?: string } = {}): Promise<SalesSummary> {
+        return this.request<SalesSummary>('GET', '/sales/summary', { query: params, response: 'json' });
+    }
+
+    /** Each product's sales, refunds and net revenue over a range of days */
+    listSalesByProduct(params: { from?: string; to?: string } = {}): Promise<ListSalesByProductResponse> {
+        return this.request<ListSalesByProductResponse>('GET', '/sales/by-product', { query: params, response: 'json' });
+    }
+
+    /** Sales, refunds and net revenue of every day in a range of up to a year */
+    listDailySales(params: { from?: string; to?: string; product?: string } = {}): Promise<ListDailySalesResponse> {
+        return this.request<ListDailySalesResponse>('GET', '/sales/daily', { query: params, response: 'json' });
+    }
+
+    /** The latest sales, newest first */
+    listRecentSales(params: { limit?: string; product?: string } = {}): Promise<ListRecentSalesResponse> {
+        return this.request<ListRecentSalesResponse>('GET', '/sales/recent', { query: params, response: 'json' });
+    }
+
+    /** Logged expenses, with the AI spend recorded per day */
+    listExpenses(): Promise<ListExpensesResponse> {
+        return this.request<ListExpensesResponse>('GET', '/expenses', { response: 'json' });
+    }
+
+    /** Log a one-off, monthly or yearly expense */
+    addExpense(body: Expense): Promise<Expense> {
+        return this.request<Expense>('POST', '/expenses', { body, response: 'json' });
+    }
+
+    /** Remove an expense */
+    deleteExpense(id: string): Promise<void> {
+        return this.request<void>('DELETE', `/expenses/${encodeURIComponent(id)}`);
+    }
+
+    /** Whether launch mode is on and the running launch's report so far */
+    getLaunch(): Promise<LaunchStatus> {
+        return this.request<LaunchStatus>('GET', '/launch', { response: 'json' });
+    }
+
+    /** Turn on launch mode */
+    startLaunch(body: { name: string }): Promise<LaunchReport> {
+        return this.request<LaunchReport>('POST', '/launch/start', { body, response: 'json' });
+    }
+
+    /** Turn off launch mode and write the post-launch report */
+    stopLaunch(): Promise<LaunchReport> {
+        return this.request<LaunchReport>('POST', '/launch/stop', { response: 'json' });
+    }
+
+    /** Reports of finished launches */
+    listLaunchReports(): Promise<ListLaunchReportsResponse> {
+        return this.request<ListLaunchReportsResponse>('GET', '/launch/reports', { response: 'json' });
+    }
+
+    /** A summary of every customer */
+    listCustomers(): Promise<ListCustomersResponse> {
+        return this.request<ListCustomersResponse>('GET', '/customers', { response: 'json' });
+    }
+
+    /** A customer's full profile */
+    getCustomer(email: string): Promise<CustomerProfile> {
+        return this.request<CustomerProfile>('GET', `/customers/${encodeURIComponent(email)}`, { response: 'json' });
+    }
+
+    /** Every customer segment and its size */
+    listSegments(): Promise<ListSegmentsResponse> {
+        return this.request<ListSegmentsResponse>('GET', '/segments', { response: 'json' });
+    }
+
+    /** The customers in a segment */
+    getSegment(name: string): Promise<GetSegmentResponse> {
+        return this.request<GetSegmentResponse>('GET', `/segments/${encodeURIComponent(name)}`, { response: 'json' });
+    }
+
+    /** Scheduled, running and finished seasonal sales */
+    listSeasonalSales(): Promise<ListSeasonalSalesResponse> {
+        return this.request<ListSeasonalSalesResponse>('GET', '/seasonal-sales', { response: 'json' });
+    }
+
+    /** Schedule a seasonal sale */
+    scheduleSeasonalSale(body: SeasonalSale): Promise<SeasonalSale> {
+        return this.request<SeasonalSale>('POST', '/seasonal-sales', { body, response: 'json' });
+    }
+
+    /** The sale event stream's length and each subscriber's cursor */
+    listStreamSubscribers(): Promise<ListStreamSubscribersResponse> {
+        return this.request<ListStreamSubscribersResponse>('GET', '/stream/subscribers', { response: 'json' });
+    }
+
+    /** Launches, seasonal sales and payout dates as an iCalendar feed */
+    getCalendar(): Promise<string> {
+        return this.request<string>('GET', '/calendar.ics', { response: 'text' });
+    }
+
+    /** Messages whose handler failed */
+    listDeadLetters(params: { status?: string } = {}): Promise<ListDeadLettersResponse> {
+        return this.request<ListDeadLettersResponse>('GET', '/dead-letters', { query: params, response: 'json' });
+    }
+
+    /** Retry a dead letter now */
+    requeueDeadLetter(id: string): Promise<DeadLetter> {
+        return this.request<DeadLetter>('POST', `/dead-letters/${encodeURIComponent(id)}/requeue`, { response: 'json' });
+    }
+
+    /** Check a license key */
+    verifyLicense(params: { product_id: string; license_key: string }): Promise<LicenseCheck> {
+        return this.request<LicenseCheck>('GET', '/licenses/verify', { query: params, response: 'json' });
+    }
+
+    /** Check a license key, counting an activation with increment_uses_count */
+    activateLicense(params: { product_id: string; license_key: string; increment_uses_count?: boolean }): Promise<LicenseCheck> {
+        return this.request<LicenseCheck>('POST', '/licenses/verify', { form: params, response: 'json' });
+    }
+
+    /** Scheduled jobs */
+    listJobs(): Promise<ListJobsResponse> {
+        return this.request<ListJobsResponse>('GET', '/jobs', { response: 'json' });
+    }
+
+    /** Stop a job running on schedule */
+    pauseJob(id: string): Promise<Job> {
+        return this.request<Job>('POST', `/jobs/${encodeURIComponent(id)}/pause`, { response: 'json' });
+    }
+
+    /** Put a paused job back on schedule */
+    resumeJob(id: string): Promise<Job> {
+        return this.request<Job>('POST', `/jobs/${encodeURIComponent(id)}/resume`, { response: 'json' });
+    }
+
+    /** Run a job now */
+    triggerJob(id: string): Promise<Job> {
+        return this.request<Job>('POST', `/jobs/${encodeURIComponent(id)}/trigger`, { response: 'json' });
+    }
+
+    /** Drafted and approved automations */
+    listAutomations(): Promise<ListAutomationsResponse> {
+        return this.request<ListAutomationsResponse>('GET', '/automations', { response: 'json' });
+    }
+
+    /** Have the AI provider draft the rules of an automation described in plain English */
+    draftAutomation(body: { description: string }): Promise<Automation> {
+        return this.request<Automation>('POST', '/automations', { body, response: 'json' });
+    }
+
+    /** Replace a draft's rules */
+    editAutomation(id: string, body: { rules: string }): Promise<Automation> {
+        return this.request<Automation>('PUT', `/automations/${encodeURIComponent(id)}`, { body, response: 'json' });
+    }
+
+    /** Put a draft's rules in force */
+    approveAutomation(id: string): Promise<Automation> {
+        return this.request<Automation>('POST', `/automations/${encodeURIComponent(id)}/approve`, { response: 'json' });
+    }
+
+    /** Remove an automation, taking its rules out of force */
+    deleteAutomation(id: string): Promise<void> {
+        return this.request<void>('DELETE', `/automations/${encodeURIComponent(id)}`);
+    }
+
+    /** Suspicious sales held back from fulfilment for review */
+    listHeldPurchases(): Promise<ListHeldPurchasesResponse> {
+        return this.request<ListHeldPurchasesResponse>('GET', '/fraud/held', { response: 'json' });
+    }
+
+    /** Pass a held sale on to be recorded and fulfilled */
+    releaseHeldPurchase(id: string): Promise<HeldPurchase> {
+        return this.request<HeldPurchase>('POST', `/fraud/held/${encodeURIComponent(id)}/release`, { response: 'json' });
+    }
+
+    /** Drop a held sale without fulfilling it */
+    rejectHeldPurchase(id: string): Promise<HeldPurchase> {
+        return this.request<HeldPurchase>('POST', `/fraud/held/${encodeURIComponent(id)}/reject`, { response: 'json' });
+    }
+
+    /** Actions waiting for a person to approve them, oldest first */
+    listApprovals(): Promise<ListApprovalsResponse> {
+        return this.request<ListApprovalsResponse>('GET', '/approvals', { response: 'json' });
+    }
+
+    /** Run a waiting action; one that fails stays waiting */
+    approveApproval(id: string): Promise<Approval> {
+        return this.request<Approval>('POST', `/approvals/${encodeURIComponent(id)}/approve`, { response: 'json' });
+    }
+
+    /** Drop a waiting action without running it */
+    rejectApproval(id: string): Promise<Approval> {
+        return this.request<Approval>('POST', `/approvals/${encodeURIComponent(id)}/reject`, { response: 'json' });
+    }
+
+    /** Sent and received messages from the message store */
+    queryMessages(params: { type?: string; direction?: string; status?: string; correlation_id?: string; since?: string; until?: string; limit?: number } = {}): Promise<QueryMessagesResponse> {
+        return this.request<QueryMessagesResponse>('GET', '/messages', { query: params, response: 'json' });
+    }
+
+    /** The GraphQL schema, in SDL; with a query parameter it runs the query instead */
+    getGraphqlSchema(): Promise<string> {
+        return this.request<string>('GET', '/graphql', { response: 'text' });
+    }
+
+    /** Run a GraphQL query over sales, customers, products and messages */
+    runGraphqlQuery(body: GraphQLRequest): Promise<GraphQLResult> {
+        return this.request<GraphQLResult>('POST', '/graphql', { body, response: 'json' });
+    }
+
+    /** The features a member's tier grants */
+    getEntitlements(email: string): Promise<EntitlementGrant> {
+        return this.request<EntitlementGrant>('GET', `/entitlements/${encodeURIComponent(email)}`, { response: 'json' });
+    }
+
+    /** This specification */
+    getOpenAPISpec(): Promise<Record<string, unknown>> {
+        return this.request<Record<string, unknown>>('GET', '/openapi.json', { response: 'json' });
+    }
+}
+
+/** Customer portal and the public endpoints sold apps call, on -portal */
+export class PortalClient extends BaseClient {
+    /** Record a product view for abandoned checkout follow-ups */
+    trackCheckoutView(params: { email: string; product: string; source?: string }): Promise<void> {
+        return this.request<void>('POST', '/track', { form: params });
+    }
+
+    /** Check a license key from a sold app */
+    checkLicense(params: { product_id: string; license_key: string }): Promise<LicenseCheck> {
+        return this.request<LicenseCheck>('GET', '/licenses/verify', { query: params, response: 'json' });
+    }
+
+    /** Check a license key from a sold app, counting an activation with increment_uses_count */
+    activateAppLicense(params: { product_id: string; license_key: string; increment_uses_count?: boolean }): Promise<LicenseCheck> {
+        return this.request<LicenseCheck>('POST', '/licenses/verify', { form: params, response: 'json' });
+    }
+
+    /** Report an anonymous usage ping; without a license_key it starts the install's trial */
+    reportUsage(params: { product_id: string; license_key?: string; install_id: string; event?: string; version?: string }): Promise<void> {
+        return this.request<void>('POST', '/usage', { form: params });
+    }
+}
+
+/** Gumroad ping receiver, on -webhook */
+export class WebhookClient extends BaseClient {
+    protected authorize(url: URL, headers: Record<string, string>): void {
+        if (this.options.token) {
+            url.searchParams.set('secret', this.options.token);
+        }
+    }
+
+    /** A Gumroad ping, as the form Gumroad posts */
+    gumroadPing(params: { resource_name?: string; [field: string]: string | undefined } = {}): Promise<GumroadPingResponse> {
+        return this.request<GumroadPingResponse>('POST', '/webhook/gumroad', { form: params, response: 'json' });
+    }
+}
+
+/** Inbound messages from peer bridges, on -http */
+export class PeerClient extends BaseClient {
+    /** Deliver a message from a peer bridge; answers once it is handled */
+    postMessage(body: UniversalMessage): Promise<void> {
+        return this.request<void>('POST', '/messages', { body });
+    }
+}
@@ -0,0 +1,69 @@
+#!/usr/bin/env node
+/**
+ * Universal Protocol contract runner for the JavaScript bridge.
+ * Runs every case in contract/cases against core/bridge.js.
+ */
+
+const fs = require('fs');
+const path = require('path');
+const Module = require('module');
+
+// bridge.js requires 'ws' at load time; the contract only needs UniversalMessage
+const originalLoad = Module._load;
+Module._load = function (request, ...rest) {
+    if (request === 'ws') {
+        try {
+            return originalLoad.call(this, request, ...rest);
+        } catch (error) {
+            return class {};
+        }
+    }
+    return originalLoad.call(this, request, ...rest);
+};
+
+const { UniversalMessage } = require('../core/bridge.js');
+
+function checkCase(testCase) {
+    const raw = JSON.stringify(testCase.message);
+
+    let msg;
+    try {
+        msg = UniversalMessage.fromJSON(raw);
+    } catch (error) {
+        return testCase.expect.valid ? `decode failed: ${error.message}` : null;
+    }
+
+    if (!testCase.expect.valid) {
+        return 'decode accepted an invalid message';
+    }
+
+    const checksum = msg.calculateChecksum();
+    if (checksum !== testCase.message.checksum) {
+        return `checksum ${checksum} != ${testCase.message.checksum}`;
+    }
+    return null;
+}
+
+function main() {
+    const casesDir = path.join(__dirname, 'cases');
+    let failed = 0;
+
+    for (const file of fs.readdirSync(casesDir).filter(f => f.endsWith('.json')).sort()) {
+        const testCase = JSON.parse(fs.readFileSync(path.join(casesDir, file), 'utf8'));
+        const problem = checkCase(testCase);
+
+        let status;
+        if (problem === null) {
+            status = 'PASS';
+        } else {
+            status = `FAIL: ${problem}`;
+            failed++;
+        }
+        console.log(`${testCase.name}: ${status}`);
+    }
+
+    console.log(`\n${failed} failure(s)`);
+    process.exit(failed ? 1 : 0);
+}
+
+main();
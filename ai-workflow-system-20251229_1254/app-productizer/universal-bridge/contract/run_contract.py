@@ -0,0 +1,59 @@
+#!/usr/bin/env python3
+"""
+Universal Protocol contract runner for the Python bridge.
+Runs every case in contract/cases against core/universal_protocol.py.
+"""
+
+import contextlib
+import io
+import json
+import sys
+from pathlib import Path
+
+HERE = Path(__file__).resolve().parent
+sys.path.insert(0, str(HERE.parent / "core"))
+
+from universal_protocol import UniversalMessage  # noqa: E402
+
+
+def check_case(case):
+    """Return None if the case passes, otherwise a failure description"""
+    raw = json.dumps(case["message"])
+
+    try:
+        # from_json prints checksum warnings; keep the report readable
+        with contextlib.redirect_stdout(io.StringIO()):
+            msg = UniversalMessage.from_json(raw)
+    except Exception as exc:
+        if case["expect"]["valid"]:
+            return f"decode failed: {exc}"
+        return None
+
+    if not case["expect"]["valid"]:
+        return "decode accepted an invalid message"
+
+    checksum = msg._calculate_checksum_from_data()
+    if checksum != case["message"]["checksum"]:
+        return f"checksum {checksum} != {case['message']['checksum']}"
+    return None
+
+
+def main():
+    failed = 0
+    for path in sorted((HERE / "cases").glob("*.json")):
+        case = json.loads(path.read_text(encoding="utf-8"))
+        problem = check_case(case)
+
+        if problem is None:
+            status = "PASS"
+        else:
+            status = f"FAIL: {problem}"
+            failed += 1
+        print(f"{case['name']}: {status}")
+
+    print(f"\n{failed} failure(s)")
+    return 1 if failed else 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
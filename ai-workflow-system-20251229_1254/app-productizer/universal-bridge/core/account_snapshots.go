@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ProductSnapshot is one product as it stood when a snapshot was taken
+type ProductSnapshot struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Permalink  string `json:"permalink,omitempty"`
+	Published  bool   `json:"published"`
+	Price      Money  `json:"price"`
+	SalesCount int    `json:"sales_count"`
+	// Subscribers counts a membership's subscriptions that have not
+	// ended, been cancelled or failed
+	Subscribers int  `json:"subscribers"`
+	Membership  bool `json:"membership"`
+}
+
+// AccountSnapshot is the seller's Gumroad account at one moment. Gumroad's
+// API has no balance, so Balance is estimated: the net sales the sales
+// store holds from the last payout day, BalanceSince, through the day the
+// snapshot was taken.
+type AccountSnapshot struct {
+	TakenAt      time.Time         `json:"taken_at"`
+	Products     []ProductSnapshot `json:"products"`
+	Subscribers  int               `json:"subscribers"`
+	Balance      []Money           `json:"balance"`
+	BalanceSince string            `json:"balance_since"`
+}
+
+// AccountSnapshots takes account snapshots from the Gumroad API, keeps them
+// as JSON lines, one snapshot a line, and sends each to Target as a
+// data_sync message with payload {"sync": "account_snapshot", "snapshot":
+// ...}, so growth in subscribers and sales counts can be charted over time
+type AccountSnapshots struct {
+	// Target is the language snapshots are sent to; none sends nothing
+	Target string
+	// PayoutDay is the weekday Gumroad pays out on, where the balance starts
+	PayoutDay time.Weekday
+
+	bridge    *GoBridge
+	gumroad   *GumroadClient
+	analytics *SalesAnalytics
+	path      string
+
+	mu        sync.Mutex
+	snapshots []AccountSnapshot
+}
+
+// NewAccountSnapshots reads the snapshots kept at path. The balance is
+// read from the sales store for days in location.
+func NewAccountSnapshots(gb *GoBridge, gumroad *GumroadClient, sales *SalesStore, location *time.Location, path string) (*AccountSnapshots, error) {
+	analytics := NewSalesAnalytics(gb, sales)
+	analytics.Location = location
+	as := &AccountSnapshots{
+		Target:    "python",
+		PayoutDay: time.Friday,
+		bridge:    gb,
+		gumroad:   gumroad,
+		analytics: analytics,
+		path:      path,
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return as, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account snapshots: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var snapshot AccountSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to parse account snapshots %s line %d: %v", path, line, err)
+		}
+		as.snapshots = append(as.snapshots, snapshot)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read account snapshots: %v", err)
+	}
+	return as, nil
+}
+
+// Take snapshots the account, keeps the snapshot and sends it to Target
+func (as *AccountSnapshots) Take() (AccountSnapshot, error) {
+	now := as.bridge.clock.Now().UTC()
+	products, err := as.gumroad.Products()
+	if err != nil {
+		return AccountSnapshot{}, fmt.Errorf("failed to list products: %v", err)
+	}
+
+	snapshot := AccountSnapshot{TakenAt: now, Products: make([]ProductSnapshot, 0, len(products))}
+	for _, product := range products {
+		entry := ProductSnapshot{
+			ID:         product.ID,
+			Name:       product.Name,
+			Permalink:  product.CustomPermalink,
+			Published:  product.Published,
+			Price:      NewMoney(product.Price, product.Currency),
+			SalesCount: product.SalesCount,
+			Membership: product.IsTieredMembership,
+		}
+		if product.IsTieredMembership {
+			subscribers, err := as.gumroad.Subscribers(product.ID, "")
+			if err != nil {
+				return AccountSnapshot{}, fmt.Errorf("failed to list subscribers of %s: %v", product.ID, err)
+			}
+			for _, subscriber := range subscribers {
+				if subscriber.EndedAt == "" && subscriber.CancelledAt == "" && subscriber.FailedAt == "" {
+					entry.Subscribers++
+				}
+			}
+		}
+		snapshot.Subscribers += entry.Subscribers
+		snapshot.Products = append(snapshot.Products, entry)
+	}
+
+	today := now.In(as.analytics.Location)
+	since := today.AddDate(0, 0, -((int(today.Weekday()) - int(as.PayoutDay) + 7) % 7))
+	snapshot.BalanceSince = since.Format(expenseDate)
+	summary, err := as.analytics.Summary(snapshot.BalanceSince, today.Format(expenseDate), "")
+	if err != nil {
+		return AccountSnapshot{}, err
+	}
+	snapshot.Balance = summary.NetRevenue
+
+	if err := as.keep(snapshot); err != nil {
+		return AccountSnapshot{}, err
+	}
+	fmt.Printf("📸 Account snapshot: %d products, %d subscribers\n", len(snapshot.Products), snapshot.Subscribers)
+	if as.Target == "" {
+		return snapshot, nil
+	}
+	payload, err := snapshotPayload(snapshot)
+	if err == nil {
+		_, err = as.bridge.SendMessage(as.bridge.NewMessage(DataSync, as.Target, payload, FileSystem))
+	}
+	if err != nil {
+		// Kept, and listed by /metrics/snapshots, even if the peer missed it
+		log.Printf("❌ Error sending account snapshot to %s: %v", as.Target, err)
+	}
+	return snapshot, nil
+}
+
+// snapshotPayload turns a snapshot into a JSON-safe message payload
+func snapshotPayload(snapshot AccountSnapshot) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(encoded, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"sync": "account_snapshot", "snapshot": fields}, nil
+}
+
+// keep appends the snapshot to the file and the list
+func (as *AccountSnapshots) keep(snapshot AccountSnapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.path != "" {
+		if err := os.MkdirAll(filepath.Dir(as.path), 0755); err != nil {
+			return fmt.Errorf("failed to save account snapshot: %v", err)
+		}
+		file, err := os.OpenFile(as.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to save account snapshot: %v", err)
+		}
+		_, err = file.Write(append(line, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save account snapshot: %v", err)
+		}
+	}
+	as.snapshots = append(as.snapshots, snapshot)
+	return nil
+}
+
+// Between returns the snapshots taken on the days from through to, oldest
+// first
+func (as *AccountSnapshots) Between(from, to string) ([]AccountSnapshot, error) {
+	start, err := time.ParseInLocation(expenseDate, from, as.analytics.Location)
+	if err != nil {
+		return nil, fmt.Errorf("bad from date %q, want YYYY-MM-DD", from)
+	}
+	end, err := time.ParseInLocation(expenseDate, to, as.analytics.Location)
+	if err != nil {
+		return nil, fmt.Errorf("bad to date %q, want YYYY-MM-DD", to)
+	}
+	end = end.AddDate(0, 0, 1)
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	snapshots := []AccountSnapshot{}
+	for _, snapshot := range as.snapshots {
+		if !snapshot.TakenAt.Before(start) && snapshot.TakenAt.Before(end) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// Handler serves GET /metrics/snapshots, the snapshots taken from ?from=
+// through ?to=, this month so far by default
+func (as *AccountSnapshots) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		from, to := dayRangeQuery(r, as.bridge.clock.Now(), as.analytics.Location)
+		snapshots, err := as.Between(from, to)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"snapshots": snapshots})
+	})
+}
+
+// RegisterSnapshotJobs adds the account_snapshot job handler, which takes
+// a snapshot
+func RegisterSnapshotJobs(s *Scheduler, snapshots *AccountSnapshots) {
+	s.Handle("account_snapshot", func(job Job) error {
+		_, err := snapshots.Take()
+		return err
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccountSnapshots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products":
+			fmt.Fprint(w, `{"success": true, "products": [
+				{"id": "p1", "name": "The Ebook", "custom_permalink": "ebook", "price": 900, "currency": "usd", "published": true, "sales_count": 12},
+				{"id": "m1", "name": "Insiders", "price": 500, "currency": "usd", "published": true, "sales_count": 3, "is_tiered_membership": true}]}`)
+		case "/products/m1/subscribers":
+			fmt.Fprint(w, `{"success": true, "subscribers": [{"id": "s1"}, {"id": "s2"}, {"id": "s3", "cancelled_at": "2026-01-10T00:00:00Z"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	// A Thursday; the balance starts on the Friday before
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	ids := NewSequentialIDs("gumroad")
+	for i, day := range []time.Time{clock.Now().AddDate(0, 0, -7), clock.Now()} {
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", map[string]interface{}{
+			"resource_name": "sale", "sale_id": fmt.Sprintf("s%d", i), "product_id": "p1", "price": 9, "currency": "usd", "sale_timestamp": day.Format(time.RFC3339),
+		}, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := NewGumroadClient()
+	client.BaseURL = server.URL
+	client.AccessToken = "token"
+	client.Retries = 0
+	path := filepath.Join(t.TempDir(), "account.jsonl")
+	snapshots, err := NewAccountSnapshots(gb, client, sales, time.UTC, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := len(transport.Sent())
+	snapshot, err := snapshots.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Products) != 2 || snapshot.Products[1].Subscribers != 2 || snapshot.Subscribers != 2 || snapshot.Products[0].Price != NewMoney(900, "usd") {
+		t.Errorf("snapshot %+v, want two products and two live subscribers", snapshot)
+	}
+	if snapshot.BalanceSince != "2026-01-09" || len(snapshot.Balance) != 1 || snapshot.Balance[0] != NewMoney(900, "usd") {
+		t.Errorf("balance %v since %s, want the one sale since Friday", snapshot.Balance, snapshot.BalanceSince)
+	}
+	if synced := transport.Sent()[sent:]; len(synced) != 1 || synced[0].MessageType != DataSync || synced[0].TargetLanguage != "python" || synced[0].Payload["sync"] != "account_snapshot" {
+		t.Errorf("sent %+v, want the snapshot as data_sync", synced)
+	}
+
+	// Snapshots survive a restart and are listed by day
+	clock.Advance(24 * time.Hour)
+	reopened, err := NewAccountSnapshots(gb, client, sales, time.UTC, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Target = ""
+	if _, err := reopened.Take(); err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.Sent()) != sent+1 {
+		t.Errorf("sent a snapshot without a target")
+	}
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantTaken  []string
+	}{
+		{"", http.StatusOK, []string{"2026-01-15T09:30:00Z", "2026-01-16T09:30:00Z"}},
+		{"?from=2026-01-16", http.StatusOK, []string{"2026-01-16T09:30:00Z"}},
+		{"?from=2026-01-01&to=2026-01-14", http.StatusOK, nil},
+		{"?from=yesterday", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		reopened.Handler().ServeHTTP(response, httptest.NewRequest("GET", "/metrics/snapshots"+tt.query, nil))
+		var list struct{ Snapshots []AccountSnapshot }
+		json.Unmarshal(response.Body.Bytes(), &list)
+		var taken []string
+		for _, snapshot := range list.Snapshots {
+			taken = append(taken, snapshot.TakenAt.Format(time.RFC3339))
+		}
+		if response.Code != tt.wantStatus || strings.Join(taken, ",") != strings.Join(tt.wantTaken, ",") {
+			t.Errorf("%q: %d listed %v, want %d and %v", tt.query, response.Code, taken, tt.wantStatus, tt.wantTaken)
+		}
+	}
+}
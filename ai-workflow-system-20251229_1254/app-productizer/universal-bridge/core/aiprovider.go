@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	openAIAPIURL    = "https://api.openai.com/v1"
+	openAIKeyEnv    = "OPENAI_API_KEY"
+	anthropicAPIURL = "https://api.anthropic.com/v1"
+	anthropicKeyEnv = "ANTHROPIC_API_KEY"
+	ollamaURL       = "http://localhost:11434"
+)
+
+// AIPrompt is what an AI request asks a provider for
+type AIPrompt struct {
+	Prompt       string
+	Instructions string // the system prompt; may be empty
+	Model        string // empty for the provider's default
+	MaxTokens    int    // 0 for the provider's default
+}
+
+// AIResult is a provider's answer
+type AIResult struct {
+	Text         string `json:"text"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+}
+
+// AIProvider generates text with a model API
+type AIProvider interface {
+	Name() string
+	Generate(prompt AIPrompt) (AIResult, error)
+}
+
+// AIEndpoint is how a provider reaches its API. Network errors, 429s and
+// 5xx responses are retried.
+type AIEndpoint struct {
+	BaseURL string
+	APIKey  string
+	// Model and MaxTokens are used when a request names none
+	Model      string
+	MaxTokens  int
+	HTTPClient *http.Client
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	clock Clock
+}
+
+func newAIEndpoint(baseURL, apiKey, model string, clock Clock) AIEndpoint {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return AIEndpoint{
+		BaseURL:    baseURL,
+		APIKey:     apiKey,
+		Model:      model,
+		MaxTokens:  1024,
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute},
+		Retries:    2,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+		clock:      clock,
+	}
+}
+
+// settings fills in the endpoint's defaults for what the prompt leaves out
+func (e *AIEndpoint) settings(prompt AIPrompt) (model string, maxTokens int) {
+	model, maxTokens = prompt.Model, prompt.MaxTokens
+	if model == "" {
+		model = e.Model
+	}
+	if maxTokens <= 0 {
+		maxTokens = e.MaxTokens
+	}
+	return model, maxTokens
+}
+
+// post sends a JSON request to the API and decodes the JSON answer into out
+func (e *AIEndpoint) post(service, path string, headers map[string]string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %v", service, err)
+	}
+	retry := retrier{Retries: e.Retries, Backoff: e.Backoff, MaxBackoff: e.MaxBackoff, Clock: e.clock, Classify: retryTransient}
+	return retry.do(service+" call", func() error {
+		request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(e.BaseURL, "/")+path, bytes.NewReader(encoded))
+		if err != nil {
+			return final(err)
+		}
+		request.Header.Set("Content-Type", JSONContentType)
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+
+		response, err := e.HTTPClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			return readAPIError(service+" API", response)
+		}
+		err = json.NewDecoder(io.LimitReader(response.Body, 8<<20)).Decode(out)
+		if err != nil {
+			return final(fmt.Errorf("bad %s response: %v", service, err))
+		}
+		return nil
+	})
+}
+
+// chatMessage is one turn of an OpenAI or Ollama chat
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatMessages is the system and user turns of a prompt
+func chatMessages(prompt AIPrompt) []chatMessage {
+	var messages []chatMessage
+	if prompt.Instructions != "" {
+		messages = append(messages, chatMessage{"system", prompt.Instructions})
+	}
+	return append(messages, chatMessage{"user", prompt.Prompt})
+}
+
+// OpenAIProvider generates with OpenAI's chat completions API, or any API
+// compatible with it at BaseURL
+type OpenAIProvider struct {
+	AIEndpoint
+}
+
+// NewOpenAIProvider uses the model unless a request names another
+func NewOpenAIProvider(apiKey, model string, clock Clock) *OpenAIProvider {
+	return &OpenAIProvider{newAIEndpoint(openAIAPIURL, apiKey, model, clock)}
+}
+
+func (p *OpenAIProvider) Name() string { return "openai" }
+
+func (p *OpenAIProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	model, maxTokens := p.settings(prompt)
+	var answer struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	err := p.post("OpenAI", "/chat/completions", map[string]string{"Authorization": "Bearer " + p.APIKey}, map[string]interface{}{
+		"model":      model,
+		"messages":   chatMessages(prompt),
+		"max_tokens": maxTokens,
+	}, &answer)
+	if err != nil {
+		return AIResult{}, err
+	}
+	if len(answer.Choices) == 0 {
+		return AIResult{}, fmt.Errorf("OpenAI answered with no choices")
+	}
+	return AIResult{
+		Text:         answer.Choices[0].Message.Content,
+		Provider:     p.Name(),
+		Model:        answer.Model,
+		InputTokens:  answer.Usage.PromptTokens,
+		OutputTokens: answer.Usage.CompletionTokens,
+	}, nil
+}
+
+// AnthropicProvider generates with Anthropic's messages API
+type AnthropicProvider struct {
+	AIEndpoint
+}
+
+// NewAnthropicProvider uses the model unless a request names another
+func NewAnthropicProvider(apiKey, model string, clock Clock) *AnthropicProvider {
+	return &AnthropicProvider{newAIEndpoint(anthropicAPIURL, apiKey, model, clock)}
+}
+
+func (p *AnthropicProvider) Name() string { return "anthropic" }
+
+func (p *AnthropicProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	model, maxTokens := p.settings(prompt)
+	body := map[string]interface{}{
+		"model":      model,
+		"max_tokens": maxTokens,
+		"messages":   []chatMessage{{"user", prompt.Prompt}},
+	}
+	if prompt.Instructions != "" {
+		body["system"] = prompt.Instructions
+	}
+	var answer struct {
+		Model   string `json:"model"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	headers := map[string]string{"x-api-key": p.APIKey, "anthropic-version": "2023-06-01"}
+	err := p.post("Anthropic", "/messages", headers, body, &answer)
+	if err != nil {
+		return AIResult{}, err
+	}
+	var text strings.Builder
+	for _, block := range answer.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return AIResult{
+		Text:         text.String(),
+		Provider:     p.Name(),
+		Model:        answer.Model,
+		InputTokens:  answer.Usage.InputTokens,
+		OutputTokens: answer.Usage.OutputTokens,
+	}, nil
+}
+
+// OllamaProvider generates with a local Ollama server, which needs no key
+type OllamaProvider struct {
+	AIEndpoint
+}
+
+// NewOllamaProvider uses the model unless a request names another
+func NewOllamaProvider(baseURL, model string, clock Clock) *OllamaProvider {
+	if baseURL == "" {
+		baseURL = ollamaURL
+	}
+	p := &OllamaProvider{newAIEndpoint(baseURL, "", model, clock)}
+	// A local model on a CPU is slow to answer a long prompt
+	p.HTTPClient.Timeout = 10 * time.Minute
+	return p
+}
+
+func (p *OllamaProvider) Name() string { return "ollama" }
+
+func (p *OllamaProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	model, maxTokens := p.settings(prompt)
+	var answer struct {
+		Model           string      `json:"model"`
+		Message         chatMessage `json:"message"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+		EvalCount       int         `json:"eval_count"`
+	}
+	err := p.post("Ollama", "/api/chat", nil, map[string]interface{}{
+		"model":    model,
+		"messages": chatMessages(prompt),
+		"stream":   false,
+		"options":  map[string]interface{}{"num_predict": maxTokens},
+	}, &answer)
+	if err != nil {
+		return AIResult{}, err
+	}
+	return AIResult{
+		Text:         answer.Message.Content,
+		Provider:     p.Name(),
+		Model:        answer.Model,
+		InputTokens:  answer.PromptEvalCount,
+		OutputTokens: answer.EvalCount,
+	}, nil
+}
+
+// AIProviderConfig sets up one provider
+type AIProviderConfig struct {
+	Model     string `yaml:"model"`
+	URL       string `yaml:"url"`
+	MaxTokens int    `yaml:"max_tokens"`
+	// InputCost and OutputCost are US dollars per million tokens, which
+	// price each generation as an expense
+	InputCost  float64 `yaml:"input_cost"`
+	OutputCost float64 `yaml:"output_cost"`
+}
+
+// AIConfig says which providers answer AI requests, loaded from YAML:
+//
+//	default: anthropic      # needed when more than one provider is set up
+//	anthropic:
+//	  model: claude-3-5-haiku-latest
+//	  input_cost: 0.80     # USD per million tokens, logged as AI expenses
+//	  output_cost: 4.00
+//	openai:
+//	  model: gpt-4o-mini
+//	  url: https://api.openai.com/v1  # the default; any compatible API works
+//	ollama:
+//	  model: llama3.1
+//	  url: http://localhost:11434     # the default
+//	  max_tokens: 2048
+//
+// Each provider needs a model. OpenAI and Anthropic take their keys from
+// OPENAI_API_KEY and ANTHROPIC_API_KEY.
+type AIConfig struct {
+	Default   string            `yaml:"default"`
+	OpenAI    *AIProviderConfig `yaml:"openai"`
+	Anthropic *AIProviderConfig `yaml:"anthropic"`
+	Ollama    *AIProviderConfig `yaml:"ollama"`
+}
+
+// LoadAIConfig reads the provider settings
+func LoadAIConfig(path string) (AIConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return AIConfig{}, fmt.Errorf("failed to read AI config: %v", err)
+	}
+
+	var config AIConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return AIConfig{}, fmt.Errorf("failed to parse AI config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (AIConfig, error) {
+		return AIConfig{}, fmt.Errorf("invalid AI config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	configured := config.providers()
+	if len(configured) == 0 {
+		return invalid("no openai, anthropic or ollama provider")
+	}
+	for name, provider := range configured {
+		if provider.Model == "" {
+			return invalid("%s: no model", name)
+		}
+		if provider.InputCost < 0 || provider.OutputCost < 0 {
+			return invalid("%s: negative cost", name)
+		}
+	}
+	if config.Default == "" && len(configured) == 1 {
+		for name := range configured {
+			config.Default = name
+		}
+	}
+	if _, ok := configured[config.Default]; !ok {
+		return invalid("default %q is not a configured provider", config.Default)
+	}
+	return config, nil
+}
+
+// providers returns the configured providers by name
+func (c AIConfig) providers() map[string]*AIProviderConfig {
+	configured := make(map[string]*AIProviderConfig)
+	for name, provider := range map[string]*AIProviderConfig{"openai": c.OpenAI, "anthropic": c.Anthropic, "ollama": c.Ollama} {
+		if provider != nil {
+			configured[name] = provider
+		}
+	}
+	return configured
+}
+
+// AIServer answers AI requests with a provider instead of leaving them for
+// another bridge. A request picks its provider and model with provider and
+// model in the payload or its context, or gets the default. A result is
+// replied as an AI response carrying ai_result, a failure as an error
+// message. Like function calls, a reply that cannot be sent fails the
+// request so it is retried, and the retry resends the same reply rather
+// than paying for another generation.
+type AIServer struct {
+	Default string
+	// Expenses, when set, is charged for each generation with a provider
+	// that has a price in Prices
+	Expenses *Expenses
+	Prices   map[string]AIPrice
+
+	bridge    *GoBridge
+	providers map[string]AIProvider
+
+	mu     sync.Mutex
+	unsent map[string]functionReply // request message ID → its reply
+}
+
+// NewAIServer answers the bridge's AI requests with the providers, the
+// first of which is the default until Default is changed
+func NewAIServer(gb *GoBridge, providers ...AIProvider) *AIServer {
+	as := &AIServer{
+		bridge:    gb,
+		Prices:    make(map[string]AIPrice),
+		providers: make(map[string]AIProvider),
+		unsent:    make(map[string]functionReply),
+	}
+	for _, provider := range providers {
+		as.providers[provider.Name()] = provider
+	}
+	if len(providers) > 0 {
+		as.Default = providers[0].Name()
+	}
+	gb.OnMessage(AIRequest, as.handleMessage)
+	return as
+}
+
+// NewAIServerFromConfig sets up the configured providers, with the API keys
+// for those that need one
+func NewAIServerFromConfig(gb *GoBridge, config AIConfig, openAIKey, anthropicKey string) (*AIServer, error) {
+	var providers []AIProvider
+	if c := config.OpenAI; c != nil {
+		if openAIKey == "" {
+			return nil, fmt.Errorf("OpenAI needs %s", openAIKeyEnv)
+		}
+		provider := NewOpenAIProvider(openAIKey, c.Model, gb.clock)
+		provider.configure(c)
+		providers = append(providers, provider)
+	}
+	if c := config.Anthropic; c != nil {
+		if anthropicKey == "" {
+			return nil, fmt.Errorf("Anthropic needs %s", anthropicKeyEnv)
+		}
+		provider := NewAnthropicProvider(anthropicKey, c.Model, gb.clock)
+		provider.configure(c)
+		providers = append(providers, provider)
+	}
+	if c := config.Ollama; c != nil {
+		provider := NewOllamaProvider(c.URL, c.Model, gb.clock)
+		provider.configure(c)
+		providers = append(providers, provider)
+	}
+	as := NewAIServer(gb, providers...)
+	as.Default = config.Default
+	for name, provider := range config.providers() {
+		if provider.InputCost > 0 || provider.OutputCost > 0 {
+			as.Prices[name] = AIPrice{Input: provider.InputCost, Output: provider.OutputCost, Currency: defaultCurrency}
+		}
+	}
+	return as, nil
+}
+
+// configure applies a provider's optional settings
+func (e *AIEndpoint) configure(config *AIProviderConfig) {
+	if config.URL != "" {
+		e.BaseURL = config.URL
+	}
+	if config.MaxTokens > 0 {
+		e.MaxTokens = config.MaxTokens
+	}
+}
+
+// Providers lists the provider names, sorted
+func (as *AIServer) Providers() []string {
+	names := make([]string, 0, len(as.providers))
+	for name := range as.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generate answers a prompt with the named provider, or the default when
+// the name is empty, for Go subsystems that want text without a round trip
+// through the bridge
+func (as *AIServer) Generate(provider string, prompt AIPrompt) (AIResult, error) {
+	if provider == "" {
+		provider = as.Default
+	}
+	p, ok := as.providers[provider]
+	if !ok {
+		return AIResult{}, fmt.Errorf("unknown AI provider %q; this bridge has %s", provider, strings.Join(as.Providers(), ", "))
+	}
+	fmt.Printf("🤖 Generating with %s\n", provider)
+	result, err := p.Generate(prompt)
+	if err != nil {
+		return AIResult{}, fmt.Errorf("%s: %v", provider, err)
+	}
+	if price, ok := as.Prices[provider]; ok && as.Expenses != nil {
+		err = as.Expenses.RecordAISpend(provider, result, price)
+		if err != nil {
+			log.Printf("❌ Error logging AI spend: %v", err)
+		}
+	}
+	return result, nil
+}
+
+func (as *AIServer) handleMessage(message *UniversalMessage) error {
+	as.mu.Lock()
+	reply, retrying := as.unsent[message.ID]
+	as.mu.Unlock()
+	if !retrying {
+		reply = as.answer(message)
+	}
+
+	_, err := as.bridge.Reply(message, reply.messageType, reply.payload)
+	as.mu.Lock()
+	if err != nil {
+		as.unsent[message.ID] = reply
+	} else {
+		delete(as.unsent, message.ID)
+	}
+	as.mu.Unlock()
+	return err
+}
+
+// answer generates the reply to an AI request
+func (as *AIServer) answer(message *UniversalMessage) functionReply {
+	context := mapArg(message.Payload, "context")
+	hint := func(key string) string {
+		if value := stringArg(message.Payload, key); value != "" {
+			return value
+		}
+		return stringArg(context, key)
+	}
+	prompt := AIPrompt{
+		Prompt:       stringArg(message.Payload, "prompt"),
+		Instructions: stringArg(message.Payload, "instructions"),
+		Model:        hint("model"),
+	}
+	if strings.TrimSpace(prompt.Prompt) == "" {
+		return functionError(fmt.Errorf("AI request %s has no prompt", message.ID))
+	}
+	if value, ok := toNumber(message.Payload["max_tokens"]); ok {
+		prompt.MaxTokens = int(value)
+	}
+
+	span := as.bridge.tracer.Start("ai.generate", SpanClient, message.span.SpanContext())
+	result, err := as.Generate(hint("provider"), prompt)
+	span.SetAttribute("gen_ai.system", result.Provider)
+	span.SetAttribute("gen_ai.response.model", result.Model)
+	span.SetAttribute("gen_ai.usage.input_tokens", result.InputTokens)
+	span.SetAttribute("gen_ai.usage.output_tokens", result.OutputTokens)
+	span.Finish(err)
+	if err != nil {
+		log.Printf("❌ AI request %s failed: %v", message.ID, err)
+		return functionError(err)
+	}
+	return functionReply{AIResponse, map[string]interface{}{
+		"ai_result":     result.Text,
+		"provider":      result.Provider,
+		"model":         result.Model,
+		"input_tokens":  result.InputTokens,
+		"output_tokens": result.OutputTokens,
+		"success":       true,
+	}}
+}
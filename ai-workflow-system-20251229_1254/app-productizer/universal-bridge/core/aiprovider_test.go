@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeModelAPI answers one provider's API, recording the request it got
+type fakeModelAPI struct {
+	path   string
+	answer string // JSON response body
+	status int
+
+	calls   int
+	request map[string]interface{}
+	header  http.Header
+}
+
+func (f *fakeModelAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls++
+	if r.URL.Path != f.path {
+		http.NotFound(w, r)
+		return
+	}
+	f.header = r.Header
+	json.NewDecoder(r.Body).Decode(&f.request)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	fmt.Fprint(w, f.answer)
+}
+
+func TestAIProviders(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	tests := []struct {
+		name        string
+		provider    func(url string) AIProvider
+		api         *fakeModelAPI
+		wantHeader  [2]string
+		wantSystem  func(request map[string]interface{}) interface{}
+		wantTokens  [2]int
+		wantRequest string // key the max token count is sent under
+	}{
+		{
+			name: "openai",
+			provider: func(url string) AIProvider {
+				p := NewOpenAIProvider("sk-test", "gpt-4o-mini", clock)
+				p.BaseURL = url
+				return p
+			},
+			api:        &fakeModelAPI{path: "/chat/completions", answer: `{"model": "gpt-4o-mini-2024", "choices": [{"message": {"role": "assistant", "content": "Hello"}}], "usage": {"prompt_tokens": 12, "completion_tokens": 3}}`},
+			wantHeader: [2]string{"Authorization", "Bearer sk-test"},
+			wantSystem: func(request map[string]interface{}) interface{} {
+				return request["messages"].([]interface{})[0].(map[string]interface{})["content"]
+			},
+			wantTokens:  [2]int{12, 3},
+			wantRequest: "max_tokens",
+		},
+		{
+			name: "anthropic",
+			provider: func(url string) AIProvider {
+				p := NewAnthropicProvider("ak-test", "claude-3-5-haiku-latest", clock)
+				p.BaseURL = url
+				return p
+			},
+			api:         &fakeModelAPI{path: "/messages", answer: `{"model": "claude-3-5-haiku-20241022", "content": [{"type": "text", "text": "Hel"}, {"type": "text", "text": "lo"}], "usage": {"input_tokens": 12, "output_tokens": 3}}`},
+			wantHeader:  [2]string{"X-Api-Key", "ak-test"},
+			wantSystem:  func(request map[string]interface{}) interface{} { return request["system"] },
+			wantTokens:  [2]int{12, 3},
+			wantRequest: "max_tokens",
+		},
+		{
+			name: "ollama",
+			provider: func(url string) AIProvider {
+				return NewOllamaProvider(url, "llama3.1", clock)
+			},
+			api:        &fakeModelAPI{path: "/api/chat", answer: `{"model": "llama3.1", "message": {"role": "assistant", "content": "Hello"}, "prompt_eval_count": 12, "eval_count": 3}`},
+			wantHeader: [2]string{"Content-Type", JSONContentType},
+			wantSystem: func(request map[string]interface{}) interface{} {
+				return request["messages"].([]interface{})[0].(map[string]interface{})["content"]
+			},
+			wantTokens: [2]int{12, 3},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.api)
+			defer server.Close()
+			provider := tt.provider(server.URL)
+
+			result, err := provider.Generate(AIPrompt{Prompt: "Say hello", Instructions: "Be brief", MaxTokens: 50})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Text != "Hello" || result.Provider != tt.name || result.Model == "" {
+				t.Fatalf("result %+v", result)
+			}
+			if result.InputTokens != tt.wantTokens[0] || result.OutputTokens != tt.wantTokens[1] {
+				t.Fatalf("tokens %d/%d, want %v", result.InputTokens, result.OutputTokens, tt.wantTokens)
+			}
+			if got := tt.api.header.Get(tt.wantHeader[0]); got != tt.wantHeader[1] {
+				t.Fatalf("%s header %q, want %q", tt.wantHeader[0], got, tt.wantHeader[1])
+			}
+			if got := tt.wantSystem(tt.api.request); got != "Be brief" {
+				t.Fatalf("instructions sent as %v in %v", got, tt.api.request)
+			}
+			if tt.wantRequest != "" && tt.api.request[tt.wantRequest] != 50.0 {
+				t.Fatalf("%s = %v, want 50", tt.wantRequest, tt.api.request[tt.wantRequest])
+			}
+		})
+	}
+}
+
+func TestAIProviderErrors(t *testing.T) {
+	clock := &waitRecorder{ManualClock: NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))}
+	tests := []struct {
+		name      string
+		status    int
+		wantCalls int
+	}{
+		{"rate limited", http.StatusTooManyRequests, 3},
+		{"overloaded", http.StatusServiceUnavailable, 3},
+		{"bad key", http.StatusUnauthorized, 1},
+	}
+	for _, tt := range tests {
+		api := &fakeModelAPI{path: "/messages", status: tt.status, answer: `{"error": {"message": "no"}}`}
+		server := httptest.NewServer(api)
+		provider := NewAnthropicProvider("ak-test", "claude-3-5-haiku-latest", clock)
+		provider.BaseURL = server.URL
+		_, err := provider.Generate(AIPrompt{Prompt: "Say hello"})
+		server.Close()
+		if err == nil || api.calls != tt.wantCalls {
+			t.Errorf("%s: Generate = %v after %d calls, want an error after %d", tt.name, err, api.calls, tt.wantCalls)
+		}
+	}
+}
+
+// stubProvider answers every prompt with its name and the model asked for
+type stubProvider struct {
+	name string
+}
+
+func (s *stubProvider) Name() string { return s.name }
+
+func (s *stubProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	return AIResult{Text: s.name + " says hi", Provider: s.name, Model: prompt.Model}, nil
+}
+
+func TestAIServerAnswersRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      map[string]interface{}
+		wantType     MessageType
+		wantProvider string
+		wantModel    string
+	}{
+		{"default provider", map[string]interface{}{"prompt": "hi"}, AIResponse, "anthropic", ""},
+		{"payload hint", map[string]interface{}{"prompt": "hi", "provider": "ollama", "model": "llama3.1"}, AIResponse, "ollama", "llama3.1"},
+		{"context hint", map[string]interface{}{"prompt": "hi", "context": map[string]interface{}{"provider": "ollama"}}, AIResponse, "ollama", ""},
+		{"unknown provider", map[string]interface{}{"prompt": "hi", "provider": "bard"}, Error, "", ""},
+		{"no prompt", map[string]interface{}{"prompt": " "}, Error, "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+			transport := NewMemoryTransport()
+			gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("ai")))
+			t.Cleanup(func() { gb.Close() })
+			NewAIServer(gb, &stubProvider{name: "anthropic"}, &stubProvider{name: "ollama"})
+
+			request := newUniversalMessage(clock, NewSequentialIDs("py"), AIRequest, "python", "go", tt.payload, SharedMemory)
+			if reason := transport.Inject(request); reason != nil {
+				t.Fatal(reason)
+			}
+			replies := transport.SentOfType(tt.wantType)
+			if len(replies) != 1 || stringArg(replies[0].Payload, "original_message_id") != request.ID {
+				t.Fatalf("replies %v, want one %s to %s", transport.Sent(), tt.wantType, request.ID)
+			}
+			payload := replies[0].Payload
+			if tt.wantType == AIResponse {
+				if stringArg(payload, "ai_result") != tt.wantProvider+" says hi" || stringArg(payload, "model") != tt.wantModel {
+					t.Fatalf("reply payload %v, want %s with model %q", payload, tt.wantProvider, tt.wantModel)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadAIConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantDefault string
+		wantErr     bool
+	}{
+		{"one provider is the default", "ollama:\n  model: llama3.1\n", "ollama", false},
+		{"default picked", "default: openai\nopenai:\n  model: gpt-4o-mini\nollama:\n  model: llama3.1\n", "openai", false},
+		{"no default of two", "openai:\n  model: gpt-4o-mini\nollama:\n  model: llama3.1\n", "", true},
+		{"default not configured", "default: anthropic\nollama:\n  model: llama3.1\n", "", true},
+		{"no model", "openai: {}\n", "", true},
+		{"negative cost", "ollama: {model: llama3.1, input_cost: -1}\n", "", true},
+		{"no providers", "default: openai\n", "", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "ai.yaml")
+		if err := ioutil.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadAIConfig(path)
+		if (err != nil) != tt.wantErr || config.Default != tt.wantDefault {
+			t.Errorf("%s: LoadAIConfig = %+v, %v", tt.name, config, err)
+		}
+	}
+}
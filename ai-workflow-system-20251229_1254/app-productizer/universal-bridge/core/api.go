@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// apiTokenEnv holds the bearer token the seller API requires
+const apiTokenEnv = "BRIDGE_API_TOKEN"
+
+// API is the seller-facing JSON API: metrics about the bridge and the store,
+// for dashboards and the seller's own app
+type API struct {
+	// Token, when set, must be sent as "Authorization: Bearer <token>", or
+	// in a token query parameter by clients such as calendar apps that can
+	// only be given a URL
+	Token string
+	// LiveToken, when set, also opens the live feed at /live and nothing
+	// else, so an overlay's URL need not carry Token
+	LiveToken string
+
+	bridge  *GoBridge
+	pricing *PricingAnalytics
+	mux     *http.ServeMux
+	server  *http.Server
+}
+
+// NewAPI creates the API with its metrics routes and OpenAPI spec
+func NewAPI(gb *GoBridge, pricing *PricingAnalytics) *API {
+	api := &API{bridge: gb, pricing: pricing, mux: http.NewServeMux()}
+	api.mux.Handle("/metrics", gb.MetricsHandler())
+	api.mux.HandleFunc("/metrics/sinks", api.handleSinkMetrics)
+	api.mux.HandleFunc("/metrics/messages", api.handleMessageMetrics)
+	api.mux.HandleFunc("/metrics/pricing", api.handlePricing)
+	api.mux.HandleFunc("/metrics/pricing/", api.handlePricing)
+	api.mux.Handle("/openapi.json", OpenAPIHandler())
+	api.mux.Handle("/dashboard/", DashboardHandler())
+	return api
+}
+
+// Handle adds a route to the API
+func (api *API) Handle(pattern string, handler http.Handler) {
+	api.mux.Handle(pattern, handler)
+}
+
+// Handler returns the API's routes behind token authentication, but for
+// the dashboard's static files, which ask for the token themselves
+func (api *API) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if api.Token != "" && !strings.HasPrefix(path.Clean(r.URL.Path)+"/", "/dashboard/") {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+			live := api.LiveToken != "" && r.URL.Path == "/live" &&
+				subtle.ConstantTimeCompare([]byte(token), []byte(api.LiveToken)) == 1
+			if !live && subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) != 1 {
+				writeAPIError(w, http.StatusUnauthorized, "missing or invalid API token")
+				return
+			}
+		}
+		api.mux.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIToken refuses to serve the API on addr without a token unless
+// addr only listens on loopback, since the API exposes sales and customers
+func requireAPIToken(addr, token string) error {
+	if token != "" || isLoopbackAddr(addr) {
+		return nil
+	}
+	return fmt.Errorf("api.listen %s is reachable from other hosts; set %s, or listen on 127.0.0.1", addr, apiTokenEnv)
+}
+
+// isLoopbackAddr reports whether a host:port listen address only accepts
+// local connections. An empty host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve serves the API on the listener until Close
+func (api *API) Serve(listener net.Listener) {
+	api.server = &http.Server{Handler: api.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	fmt.Printf("🌐 Seller API on %s\n", listener.Addr())
+
+	go func() {
+		err := api.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ API stopped: %v", err)
+		}
+	}()
+}
+
+// Close waits up to timeout for in-flight requests, then stops the API
+func (api *API) Close(timeout time.Duration) error {
+	if api.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return api.server.Shutdown(ctx)
+}
+
+func (api *API) handleSinkMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"sinks": api.bridge.SinkStats()})
+}
+
+func (api *API) handleMessageMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"messages": api.bridge.MessageStats()})
+}
+
+// handlePricing serves /metrics/pricing for every pay-what-you-want product
+// and /metrics/pricing/{product} for one
+func (api *API) handlePricing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	product := strings.Trim(strings.TrimPrefix(r.URL.Path, "/metrics/pricing"), "/")
+	if product == "" {
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"products": api.pricing.All()})
+		return
+	}
+
+	stats := api.pricing.Product(product)
+	if len(stats) == 0 {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no pay-what-you-want sales of %s", product))
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"product": product, "currencies": stats})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("❌ Error writing API response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]interface{}{"error": message})
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestRequireAPIToken(t *testing.T) {
+	tests := []struct {
+		addr     string
+		loopback bool
+	}{
+		{":8081", false},
+		{"0.0.0.0:8081", false},
+		{"[::]:8081", false},
+		{"192.168.1.5:8081", false},
+		{"127.0.0.1:8081", true},
+		{"[::1]:8081", true},
+		{"localhost:8081", true},
+		{"8081", false},
+	}
+	for _, tt := range tests {
+		if err := requireAPIToken(tt.addr, "secret"); err != nil {
+			t.Errorf("requireAPIToken(%q) with a token = %v, want nil", tt.addr, err)
+		}
+		err := requireAPIToken(tt.addr, "")
+		if tt.loopback && err != nil {
+			t.Errorf("requireAPIToken(%q) without a token = %v, want nil on loopback", tt.addr, err)
+		}
+		if !tt.loopback && err == nil {
+			t.Errorf("requireAPIToken(%q) without a token = nil, want an error", tt.addr)
+		}
+	}
+}
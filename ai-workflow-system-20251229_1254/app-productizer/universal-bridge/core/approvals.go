@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoApproval is returned for an approval ID that is not pending
+var ErrNoApproval = errors.New("no such approval")
+
+// Approval is an action parked until a person approves or rejects it
+type Approval struct {
+	ID        string                 `json:"id"`
+	Action    string                 `json:"action"` // the handler that runs it once approved
+	Summary   string                 `json:"summary"`
+	Args      map[string]interface{} `json:"args"`
+	CreatedAt time.Time              `json:"created_at"`
+	Link      string                 `json:"link,omitempty"` // where it is reviewed, with DashboardURL
+}
+
+// Approvals is a queue of actions that wait for a person: rule actions
+// marked approve, and whatever is queued on a sink held with HoldSink.
+// Each request is persisted at path, told to the Notify sinks with a link
+// to the dashboard and passed to OnRequest callbacks such as Telegram's,
+// and runs its action's handler only once approved.
+type Approvals struct {
+	// DashboardURL, e.g. https://admin.example.com/dashboard/, links each
+	// notification to the approval on the dashboard
+	DashboardURL string
+	// Notify names the sinks told about each request, such as slack
+	Notify []string
+
+	bridge *GoBridge
+	path   string
+
+	mu         sync.Mutex
+	pending    map[string]*Approval
+	handlers   map[string]func(args map[string]interface{}) error
+	onRequest  []func(Approval)
+	inProgress map[string]bool
+}
+
+// NewApprovals opens the approvals pending at path. Held sink messages are
+// handled by the "sink_message" action.
+func NewApprovals(gb *GoBridge, path string) (*Approvals, error) {
+	a := &Approvals{
+		bridge:     gb,
+		path:       path,
+		pending:    make(map[string]*Approval),
+		handlers:   make(map[string]func(map[string]interface{}) error),
+		inProgress: make(map[string]bool),
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read approvals: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &a.pending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse approvals %s: %v", path, err)
+		}
+	}
+	a.Handle("sink_message", a.releaseSinkMessage)
+	return a, nil
+}
+
+// Handle sets what runs an action once it is approved
+func (a *Approvals) Handle(action string, run func(args map[string]interface{}) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.handlers[action] = run
+}
+
+// OnRequest calls fn with every new request, after it is saved
+func (a *Approvals) OnRequest(fn func(Approval)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onRequest = append(a.onRequest, fn)
+}
+
+// Request parks an action until it is approved. Args must survive a JSON
+// round trip, as they are persisted.
+func (a *Approvals) Request(action, summary string, args map[string]interface{}) (Approval, error) {
+	approval := &Approval{
+		ID:        a.bridge.ids.NewID(),
+		Action:    action,
+		Summary:   summary,
+		Args:      args,
+		CreatedAt: a.bridge.clock.Now().UTC(),
+	}
+	approval.Link = a.link(approval.ID)
+
+	a.mu.Lock()
+	if a.handlers[action] == nil {
+		a.mu.Unlock()
+		return Approval{}, fmt.Errorf("no handler for approved %s actions", action)
+	}
+	a.pending[approval.ID] = approval
+	err := a.saveLocked()
+	if err != nil {
+		delete(a.pending, approval.ID)
+		a.mu.Unlock()
+		return Approval{}, err
+	}
+	callbacks := append([]func(Approval){}, a.onRequest...)
+	a.mu.Unlock()
+
+	fmt.Printf("⏸️ Waiting for approval %s: %s\n", approval.ID, summary)
+	a.notify(fmt.Sprintf("⏸️ Waiting for approval: %s", summary), approval)
+	for _, fn := range callbacks {
+		fn(*approval)
+	}
+	return *approval, nil
+}
+
+// link is where an approval is reviewed, if the dashboard's address is known
+func (a *Approvals) link(id string) string {
+	if a.DashboardURL == "" {
+		return ""
+	}
+	return strings.SplitN(a.DashboardURL, "#", 2)[0] + "#approval=" + url.QueryEscape(id)
+}
+
+// notify tells the Notify sinks about an approval
+func (a *Approvals) notify(text string, approval *Approval) {
+	if approval.Link != "" {
+		text += "\n" + approval.Link
+	}
+	for _, name := range a.Notify {
+		sink := a.bridge.Sink(name)
+		if sink == nil {
+			log.Printf("❌ No sink named %s to tell about approval %s", name, approval.ID)
+			continue
+		}
+		err := sink.Enqueue(a.bridge.NewMessage(DataSync, "go", map[string]interface{}{"text": text, "approval": approval.ID}, FileSystem))
+		if err != nil {
+			log.Printf("❌ Error telling %s about approval %s: %v", name, approval.ID, err)
+		}
+	}
+}
+
+// Pending returns the approvals waiting, oldest first
+func (a *Approvals) Pending() []Approval {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]Approval, 0, len(a.pending))
+	for _, approval := range a.pending {
+		list = append(list, *approval)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// claim takes a pending approval for approving or rejecting, so a second
+// click while the first runs finds nothing
+func (a *Approvals) claim(id string) (*Approval, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	approval, ok := a.pending[id]
+	if !ok || a.inProgress[id] {
+		return nil, fmt.Errorf("%w: %s", ErrNoApproval, id)
+	}
+	a.inProgress[id] = true
+	return approval, nil
+}
+
+// settle removes a claimed approval, or puts it back when it is kept
+func (a *Approvals) settle(approval *Approval, keep bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.inProgress, approval.ID)
+	if keep {
+		return nil
+	}
+	delete(a.pending, approval.ID)
+	return a.saveLocked()
+}
+
+// Approve runs an approval's action. One whose action fails stays pending,
+// to be approved again or rejected.
+func (a *Approvals) Approve(id, by string) (Approval, error) {
+	approval, err := a.claim(id)
+	if err != nil {
+		return Approval{}, err
+	}
+	a.mu.Lock()
+	run := a.handlers[approval.Action]
+	a.mu.Unlock()
+	if run == nil {
+		a.settle(approval, true)
+		return *approval, fmt.Errorf("no handler for approved %s actions", approval.Action)
+	}
+
+	err = run(approval.Args)
+	if err != nil {
+		a.settle(approval, true)
+		return *approval, fmt.Errorf("approved %s failed: %v", approval.ID, err)
+	}
+	fmt.Printf("✅ %s approved %s: %s\n", by, approval.ID, approval.Summary)
+	a.notify(fmt.Sprintf("✅ %s approved: %s", by, approval.Summary), &Approval{ID: approval.ID})
+	return *approval, a.settle(approval, false)
+}
+
+// Reject drops an approval without running its action
+func (a *Approvals) Reject(id, by string) (Approval, error) {
+	approval, err := a.claim(id)
+	if err != nil {
+		return Approval{}, err
+	}
+	fmt.Printf("🚫 %s rejected %s: %s\n", by, approval.ID, approval.Summary)
+	a.notify(fmt.Sprintf("🚫 %s rejected: %s", by, approval.Summary), &Approval{ID: approval.ID})
+	return *approval, a.settle(approval, false)
+}
+
+// HoldSink parks every message queued on the sink until it is approved,
+// but for the approvals' own notifications
+func (a *Approvals) HoldSink(sink *Sink) {
+	sink.SetHold(func(message *UniversalMessage) bool {
+		if _, notification := message.Payload["approval"]; notification {
+			return false
+		}
+		kept, err := keepMessage(message)
+		if err == nil {
+			_, err = a.Request("sink_message", sinkMessageSummary(sink.Name(), message), map[string]interface{}{
+				"sink":    sink.Name(),
+				"message": kept,
+			})
+		}
+		if err != nil {
+			// Queued after all, rather than lost
+			log.Printf("❌ Error holding %s for approval, queueing it: %v", message.ID, err)
+			return false
+		}
+		return true
+	})
+}
+
+// sinkMessageSummary describes a held message by its recipient and what it
+// is about
+func sinkMessageSummary(sink string, message *UniversalMessage) string {
+	summary := fmt.Sprintf("%s on %s", message.MessageType, sink)
+	if product := stringArg(message.Payload, "product_name"); product != "" {
+		summary += " about " + product
+	}
+	for _, field := range []string{"to", "email"} {
+		if to := stringArg(message.Payload, field); to != "" {
+			return summary + " for " + to
+		}
+	}
+	return summary
+}
+
+// releaseSinkMessage queues an approved message on the sink it was held from
+func (a *Approvals) releaseSinkMessage(args map[string]interface{}) error {
+	name := stringArg(args, "sink")
+	sink := a.bridge.Sink(name)
+	if sink == nil {
+		return fmt.Errorf("no sink named %s", name)
+	}
+	message, err := unkeepMessage(mapArg(args, "message"))
+	if err != nil {
+		return err
+	}
+	return sink.release(message)
+}
+
+// keepMessage turns a message into JSON-safe args, for jobs and approvals
+func keepMessage(message *UniversalMessage) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to keep message for later: %v", err)
+	}
+	var kept map[string]interface{}
+	err = json.Unmarshal(encoded, &kept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to keep message for later: %v", err)
+	}
+	return kept, nil
+}
+
+// unkeepMessage reads back a message kept by keepMessage
+func unkeepMessage(kept map[string]interface{}) (*UniversalMessage, error) {
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		return nil, fmt.Errorf("bad kept message: %v", err)
+	}
+	var message UniversalMessage
+	err = json.Unmarshal(encoded, &message)
+	if err != nil {
+		return nil, fmt.Errorf("bad kept message: %v", err)
+	}
+	return &message, nil
+}
+
+// Handler serves /approvals: GET lists the pending approvals, and POST
+// /approvals/{id}/approve or /approvals/{id}/reject decides one
+func (a *Approvals) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/approvals"), "/")
+		if rest == "" {
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"approvals": a.Pending()})
+			return
+		}
+
+		slash := strings.LastIndex(rest, "/")
+		verb := rest[slash+1:]
+		if slash < 0 || (verb != "approve" && verb != "reject") {
+			writeAPIError(w, http.StatusNotFound, "use /approvals/{id}/approve or /approvals/{id}/reject")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		id, err := url.PathUnescape(rest[:slash])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad approval id: %v", err))
+			return
+		}
+		decide := a.Approve
+		if verb == "reject" {
+			decide = a.Reject
+		}
+		approval, err := decide(id, "API")
+		switch {
+		case errors.Is(err, ErrNoApproval):
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		case err != nil && approval.ID != "":
+			// The action failed and the approval is still pending
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+		default:
+			writeAPIJSON(w, http.StatusOK, approval)
+		}
+	})
+}
+
+func (a *Approvals) saveLocked() error {
+	return writeJSONFile(a.path, a.pending)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovals(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+
+	var licenses, notices []string
+	gb.AddSink("licenses", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		licenses = append(licenses, stringArg(message.Payload, "email"))
+		return message
+	})
+	gb.AddSink("slack", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		notices = append(notices, stringArg(message.Payload, "text"))
+		return message
+	})
+	emailed := make(chan string, 10)
+	email := gb.AddSink("email", SinkConfig{}, func(message *UniversalMessage) error {
+		emailed <- stringArg(message.Payload, "to")
+		return nil
+	})
+
+	path := filepath.Join(t.TempDir(), "pending.json")
+	approvals, err := NewApprovals(gb, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvals.DashboardURL = "https://admin.example.com/dashboard/"
+	approvals.Notify = []string{"slack"}
+	approvals.HoldSink(email)
+	rules, err := ParseRules([]byte(`rules:
+  - name: enterprise-license
+    on: sale_event
+    when:
+      - field: product_permalink
+        equals: enterprise
+    do:
+      - sink: licenses
+        approve: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterRuleApprovals(approvals, NewRuleEngine(gb, rules))
+
+	ids := NewSequentialIDs("gumroad")
+	if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", map[string]interface{}{
+		"resource_name": "sale", "product_permalink": "enterprise", "email": "ada@example.com",
+	}, HTTP)); err != nil {
+		t.Fatal(err)
+	}
+	if err := email.Enqueue(gb.NewMessage(DataSync, "go", map[string]interface{}{"to": "bob@example.com", "text": "Thanks"}, FileSystem)); err != nil {
+		t.Fatal(err)
+	}
+	if len(licenses) != 0 {
+		t.Fatalf("issued licenses %v before approval", licenses)
+	}
+	pending := approvals.Pending()
+	if len(pending) != 2 || !strings.Contains(pending[0].Summary, "ada@example.com") || !strings.Contains(pending[1].Summary, "bob@example.com") {
+		t.Fatalf("pending %+v, want the license and the email", pending)
+	}
+	if len(notices) != 2 || !strings.HasSuffix(notices[0], "\nhttps://admin.example.com/dashboard/#approval="+pending[0].ID) {
+		t.Errorf("told slack %q, want a link to each approval", notices)
+	}
+
+	// Pending approvals survive a restart
+	reopened, err := NewApprovals(gb, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Pending(); len(got) != 2 || got[0].ID != pending[0].ID {
+		t.Errorf("reopened %+v, want %+v", got, pending)
+	}
+
+	handler := approvals.Handler()
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"list", "GET", "/approvals", http.StatusOK, pending[0].ID},
+		{"reject the email", "POST", "/approvals/" + pending[1].ID + "/reject", http.StatusOK, "bob@example.com"},
+		{"approve the license", "POST", "/approvals/" + pending[0].ID + "/approve", http.StatusOK, "ada@example.com"},
+		{"approve it again", "POST", "/approvals/" + pending[0].ID + "/approve", http.StatusNotFound, "no such approval"},
+		{"unknown decision", "POST", "/approvals/" + pending[0].ID + "/maybe", http.StatusNotFound, "use /approvals/{id}/approve"},
+		{"decide with GET", "GET", "/approvals/" + pending[0].ID + "/approve", http.StatusMethodNotAllowed, "use POST"},
+		{"nothing left", "GET", "/approvals", http.StatusOK, `{"approvals":[]}`},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(tt.method, tt.target, nil))
+		if response.Code != tt.wantStatus || !strings.Contains(response.Body.String(), tt.wantBody) {
+			t.Errorf("%s: %s %s = %d %s, want %d with %q", tt.name, tt.method, tt.target, response.Code, response.Body, tt.wantStatus, tt.wantBody)
+		}
+	}
+	if len(licenses) != 1 || licenses[0] != "ada@example.com" {
+		t.Errorf("issued licenses %v after approval, want one for ada@example.com", licenses)
+	}
+	if len(notices) != 4 || !strings.HasPrefix(notices[2], "🚫 API rejected") || !strings.HasPrefix(notices[3], "✅ API approved") {
+		t.Errorf("told slack %q, want the decisions", notices)
+	}
+
+	// An approved message held from a sink is delivered on it
+	if err := email.Enqueue(gb.NewMessage(DataSync, "go", map[string]interface{}{"to": "cy@example.com", "text": "Thanks"}, FileSystem)); err != nil {
+		t.Fatal(err)
+	}
+	held := approvals.Pending()
+	if len(held) != 1 {
+		t.Fatalf("pending %+v, want the email", held)
+	}
+	if _, err := approvals.Approve(held[0].ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case to := <-emailed:
+		if to != "cy@example.com" {
+			t.Errorf("emailed %s, want the approved email to cy@example.com", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the approved email was not delivered")
+	}
+	select {
+	case to := <-emailed:
+		t.Errorf("emailed %s, whose email was rejected", to)
+	default:
+	}
+}
+
+func TestApprovalsKeepFailedActions(t *testing.T) {
+	gb := NewGoBridge("", WithClock(NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	approvals, err := NewApprovals(gb, filepath.Join(t.TempDir(), "pending.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := true
+	approvals.Handle("flaky", func(map[string]interface{}) error {
+		if fail {
+			return fmt.Errorf("license server down")
+		}
+		return nil
+	})
+	if _, err := approvals.Request("missing", "nobody runs this", nil); err == nil {
+		t.Error("requested an action without a handler")
+	}
+	approval, err := approvals.Request("flaky", "try twice", map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := httptest.NewRecorder()
+	approvals.Handler().ServeHTTP(response, httptest.NewRequest("POST", "/approvals/"+approval.ID+"/approve", nil))
+	if response.Code != http.StatusBadGateway || len(approvals.Pending()) != 1 {
+		t.Fatalf("failed approval = %d %s with %d pending, want 502 and still pending", response.Code, response.Body, len(approvals.Pending()))
+	}
+	fail = false
+	response = httptest.NewRecorder()
+	approvals.Handler().ServeHTTP(response, httptest.NewRequest("POST", "/approvals/"+approval.ID+"/approve", nil))
+	var approved Approval
+	json.Unmarshal(response.Body.Bytes(), &approved)
+	if response.Code != http.StatusOK || approved.ID != approval.ID || len(approvals.Pending()) != 0 {
+		t.Errorf("retried approval = %d %s, want it approved", response.Code, response.Body)
+	}
+}
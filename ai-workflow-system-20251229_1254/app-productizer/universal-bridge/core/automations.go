@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoAutomation is returned for an automation ID that is not kept
+	ErrNoAutomation = errors.New("no such automation")
+	// ErrAutomationActive is returned when editing an approved automation
+	ErrAutomationActive = errors.New("automation is active")
+	// ErrAutomationProblems is returned when approving a draft with problems
+	ErrAutomationProblems = errors.New("automation has problems")
+)
+
+// automationTypes are the message types a drafted rule may trigger on
+var automationTypes = []MessageType{SaleEvent, DataSync, FunctionCall, AIRequest, AIResponse, HealthCheck, Error}
+
+// automationInstructions is the system prompt that drafts an automation
+const automationInstructions = `You turn a seller's description of an automation into rules for the bridge that handles their Gumroad store's events.
+Answer with the YAML of the rules only, with no commentary, in this form:
+
+rules:
+  - name: course-bonus
+    on: sale_event
+    when:
+      - field: resource_name
+        equals: sale
+      - field: product_permalink
+        equals: course
+    do:
+      - sink: email
+        template: bonus
+        after: 72h
+
+Give every rule a short, unique kebab-case name. Conditions compare a dotted payload field with one operator: equals, not_equals, gt, gte, lt, lte, contains, in (a list of values), includes (the field is a list) or exists (true or false).
+Each action has exactly one of sink, which queues the message on a sink, send, which sends a message as {type, target, payload}, or log, which prints a line. A sink action may render a template for the sink's copy. after delays an action and is written in hours: 3 days is 72h. approve: true makes an action wait until the seller approves it; use it where the seller asks to check an action first.
+Gumroad pings are sale_event messages whose resource_name is sale, refund, dispute, cancellation or subscription_ended, among others, with the buyer's email, product_permalink, product_name and price. The email sink emails the buyer.
+Use the message types, sinks and templates listed. Where no listed template fits, name a new one after what it says, such as bonus; the seller writes it before approving.`
+
+// Automation is an automation a seller described in plain English and the
+// rules drafted for it. The rules can be edited while it is a draft; once
+// approved they are in force, across restarts, until it is deleted.
+type Automation struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description"`
+	Rules       string     `json:"rules"`              // YAML, as in a -rules file
+	Problems    []string   `json:"problems,omitempty"` // what stops a draft being approved
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"` // nil for a draft
+}
+
+// Automations drafts rules from descriptions with the AI provider and
+// keeps the drafts and approved automations, persisted as JSON at path.
+// Approved automations' rules are added to the rule engine.
+type Automations struct {
+	// AI drafts the rules; without it there are no drafts
+	AI       *AIServer
+	Provider string // empty for the AI server's default
+
+	engine *RuleEngine
+	path   string
+
+	mu          sync.Mutex
+	automations map[string]*Automation
+}
+
+// NewAutomations opens the automations persisted at path and puts the
+// approved ones' rules in force. One whose rules no longer fit alongside
+// the others is logged and made a draft again.
+func NewAutomations(engine *RuleEngine, ai *AIServer, path string) (*Automations, error) {
+	au := &Automations{AI: ai, engine: engine, path: path, automations: make(map[string]*Automation)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read automations: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &au.automations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse automations %s: %v", path, err)
+		}
+	}
+
+	for _, automation := range au.List() {
+		if automation.ActivatedAt == nil {
+			continue
+		}
+		rules, err := ParseRules([]byte(automation.Rules))
+		if err == nil {
+			err = engine.Add(rules.Rules)
+		}
+		if err != nil {
+			log.Printf("❌ Automation %s is a draft again: %v", automation.ID, err)
+			stored := au.automations[automation.ID]
+			stored.ActivatedAt, stored.Problems = nil, []string{err.Error()}
+		}
+	}
+	return au, nil
+}
+
+// Draft asks the AI provider for the rules of an automation described in
+// plain English and keeps them as a draft, with any problems found in them
+func (au *Automations) Draft(description string) (Automation, error) {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return Automation{}, fmt.Errorf("automation needs a description")
+	}
+	if au.AI == nil {
+		return Automation{}, fmt.Errorf("no AI provider; start the bridge with -ai")
+	}
+	result, err := au.AI.Generate(au.Provider, AIPrompt{Prompt: au.prompt(description), Instructions: automationInstructions})
+	if err != nil {
+		return Automation{}, err
+	}
+
+	automation := Automation{
+		ID:          au.engine.bridge.ids.NewID(),
+		Description: description,
+		Rules:       stripCodeFence(result.Text),
+		CreatedAt:   au.engine.bridge.clock.Now().UTC(),
+	}
+	automation.Problems = au.check(automation.Rules)
+
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	au.automations[automation.ID] = &automation
+	err = au.saveLocked()
+	if err != nil {
+		delete(au.automations, automation.ID)
+		return Automation{}, err
+	}
+	fmt.Printf("🤖 Drafted automation %s with %d problems: %s\n", automation.ID, len(automation.Problems), description)
+	return automation, nil
+}
+
+// prompt is the description with what the rules can use
+func (au *Automations) prompt(description string) string {
+	types := make([]string, len(automationTypes))
+	for i, messageType := range automationTypes {
+		types[i] = string(messageType)
+	}
+	var sinks []string
+	for _, stats := range au.engine.bridge.SinkStats() {
+		sinks = append(sinks, stats.Name)
+	}
+	sort.Strings(sinks)
+	var templates []string
+	if au.engine.Templates != nil {
+		templates, _ = au.engine.Templates.List()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Automation: %s\n\n", description)
+	fmt.Fprintf(&b, "Message types: %s\n", strings.Join(types, ", "))
+	fmt.Fprintf(&b, "Sinks: %s\n", orNone(sinks))
+	fmt.Fprintf(&b, "Templates: %s\n", orNone(templates))
+	return b.String()
+}
+
+func orNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+	return strings.Join(list, ", ")
+}
+
+// stripCodeFence drops the ``` lines a provider may wrap YAML in
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text + "\n"
+	}
+	lines := strings.Split(text, "\n")
+	lines = lines[1:]
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "```" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
+
+// check lists what stops rules being approved: invalid YAML, unnamed rules
+// or names already in force, and sinks or templates that do not exist
+func (au *Automations) check(yaml string) []string {
+	rules, err := ParseRules([]byte(yaml))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if len(rules.Rules) == 0 {
+		return []string{"no rules"}
+	}
+
+	inForce := make(map[string]bool)
+	for _, rule := range au.engine.Rules() {
+		inForce[rule.Name] = true
+	}
+	known := make(map[MessageType]bool)
+	for _, messageType := range automationTypes {
+		known[messageType] = true
+	}
+	templates := make(map[string]bool)
+	if au.engine.Templates != nil {
+		names, _ := au.engine.Templates.List()
+		for _, name := range names {
+			templates[name] = true
+		}
+	}
+
+	var problems []string
+	for i, rule := range rules.Rules {
+		label := rule.Name
+		switch {
+		case rule.Name == "":
+			label = fmt.Sprintf("rule %d", i+1)
+			problems = append(problems, label+": needs a name")
+		case inForce[rule.Name]:
+			problems = append(problems, label+": a rule in force has this name")
+		}
+		if !known[rule.On] {
+			problems = append(problems, fmt.Sprintf("%s: %s is not a message type the bridge receives", label, rule.On))
+		}
+		for _, action := range rule.Do {
+			if action.Sink != "" && au.engine.bridge.Sink(action.Sink) == nil {
+				problems = append(problems, fmt.Sprintf("%s: no sink named %s", label, action.Sink))
+			}
+			if action.Template != "" && !templates[action.Template] {
+				problems = append(problems, fmt.Sprintf("%s: no template %s; write %s%s", label, action.Template, action.Template, templateExt))
+			}
+		}
+	}
+	return problems
+}
+
+// Edit replaces a draft's rules, checking them again
+func (au *Automations) Edit(id, rules string) (Automation, error) {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	automation, ok := au.automations[id]
+	if !ok {
+		return Automation{}, fmt.Errorf("%w: %s", ErrNoAutomation, id)
+	}
+	if automation.ActivatedAt != nil {
+		return Automation{}, fmt.Errorf("%w: delete it and draft it again", ErrAutomationActive)
+	}
+
+	previous := *automation
+	automation.Rules = strings.TrimSpace(rules) + "\n"
+	automation.Problems = au.check(automation.Rules)
+	err := au.saveLocked()
+	if err != nil {
+		*automation = previous
+		return Automation{}, err
+	}
+	return *automation, nil
+}
+
+// Approve checks a draft again, as templates may have been written since,
+// and puts its rules in force
+func (au *Automations) Approve(id string) (Automation, error) {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	automation, ok := au.automations[id]
+	if !ok {
+		return Automation{}, fmt.Errorf("%w: %s", ErrNoAutomation, id)
+	}
+	if automation.ActivatedAt != nil {
+		return *automation, nil
+	}
+
+	previous := *automation
+	automation.Problems = au.check(automation.Rules)
+	if len(automation.Problems) > 0 {
+		return Automation{}, fmt.Errorf("%w: %s", ErrAutomationProblems, strings.Join(automation.Problems, "; "))
+	}
+	rules, err := ParseRules([]byte(automation.Rules))
+	if err != nil {
+		return Automation{}, err
+	}
+	err = au.engine.Add(rules.Rules)
+	if err != nil {
+		return Automation{}, fmt.Errorf("%w: %v", ErrAutomationProblems, err)
+	}
+	now := au.engine.bridge.clock.Now().UTC()
+	automation.ActivatedAt = &now
+	err = au.saveLocked()
+	if err != nil {
+		au.engine.Remove(ruleNames(rules.Rules)...)
+		*automation = previous
+		return Automation{}, err
+	}
+	fmt.Printf("✅ Activated automation %s: %s\n", automation.ID, automation.Description)
+	return *automation, nil
+}
+
+// Remove deletes an automation, taking an approved one's rules out of
+// force; ok is false when there is none with the ID
+func (au *Automations) Remove(id string) (ok bool, err error) {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	automation, ok := au.automations[id]
+	if !ok {
+		return false, nil
+	}
+	delete(au.automations, id)
+	err = au.saveLocked()
+	if err != nil {
+		au.automations[id] = automation
+		return true, err
+	}
+	if automation.ActivatedAt != nil {
+		if rules, err := ParseRules([]byte(automation.Rules)); err == nil {
+			au.engine.Remove(ruleNames(rules.Rules)...)
+		}
+	}
+	return true, nil
+}
+
+func ruleNames(rules []Rule) []string {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// List returns every automation, oldest first
+func (au *Automations) List() []Automation {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	list := make([]Automation, 0, len(au.automations))
+	for _, automation := range au.automations {
+		list = append(list, *automation)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Handler serves /automations: GET lists them, POST drafts one from the
+// description in the JSON body, PUT /automations/{id} replaces a draft's
+// rules, POST /automations/{id}/approve puts them in force, and DELETE
+// /automations/{id} removes one
+func (au *Automations) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/automations"), "/")
+		id := strings.TrimSuffix(rest, "/approve")
+		var body struct {
+			Description string `json:"description"`
+			Rules       string `json:"rules"`
+		}
+		decode := func() bool {
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad automation: %v", err))
+				return false
+			}
+			return true
+		}
+		fail := func(err error) {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrNoAutomation):
+				status = http.StatusNotFound
+			case errors.Is(err, ErrAutomationActive), errors.Is(err, ErrAutomationProblems):
+				status = http.StatusConflict
+			}
+			writeAPIError(w, status, err.Error())
+		}
+
+		switch {
+		case rest == "" && r.Method == http.MethodGet:
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"automations": au.List()})
+		case rest == "" && r.Method == http.MethodPost:
+			if !decode() {
+				return
+			}
+			if strings.TrimSpace(body.Description) == "" {
+				writeAPIError(w, http.StatusBadRequest, "automation needs a description")
+				return
+			}
+			if au.AI == nil {
+				writeAPIError(w, http.StatusServiceUnavailable, "no AI provider; start the bridge with -ai")
+				return
+			}
+			automation, err := au.Draft(body.Description)
+			if err != nil {
+				writeAPIError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusCreated, automation)
+		case rest == "":
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		case id != rest && r.Method == http.MethodPost:
+			automation, err := au.Approve(id)
+			if err != nil {
+				fail(err)
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, automation)
+		case id != rest:
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+		case r.Method == http.MethodPut:
+			if !decode() {
+				return
+			}
+			automation, err := au.Edit(id, body.Rules)
+			if err != nil {
+				fail(err)
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, automation)
+		case r.Method == http.MethodDelete:
+			ok, err := au.Remove(id)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%v: %s", ErrNoAutomation, id))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "use PUT or DELETE")
+		}
+	})
+}
+
+func (au *Automations) saveLocked() error {
+	return writeJSONFile(au.path, au.automations)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// automationProvider drafts the course bonus automation
+type automationProvider struct {
+	prompts []AIPrompt
+}
+
+func (p *automationProvider) Name() string { return "anthropic" }
+
+func (p *automationProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	p.prompts = append(p.prompts, prompt)
+	return AIResult{Text: "```yaml\nrules:\n  - name: course-bonus\n    on: sale_event\n    when:\n      - field: product_permalink\n        equals: course\n    do:\n      - sink: email\n        template: bonus\n        after: 72h\n```\n"}, nil
+}
+
+func TestAutomations(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var emails []map[string]interface{}
+	gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		emails = append(emails, message.Payload)
+		return message
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "automations.json")
+	templates := NewTemplateStore(filepath.Join(dir, "templates"))
+	scheduler := NewScheduler("", clock)
+	open := func() (*RuleEngine, http.Handler) {
+		engine := NewRuleEngine(gb, &RuleSet{})
+		engine.Templates = templates
+		automations, err := NewAutomations(engine, NewAIServer(gb, &automationProvider{}), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		RegisterRuleJobs(scheduler, engine)
+		return engine, automations.Handler()
+	}
+	engine, handler := open()
+
+	call := func(method, target, body string, wantStatus int) Automation {
+		t.Helper()
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if response.Code != wantStatus {
+			t.Fatalf("%s %s = %d, want %d: %s", method, target, response.Code, wantStatus, response.Body)
+		}
+		var automation Automation
+		json.Unmarshal(response.Body.Bytes(), &automation)
+		return automation
+	}
+
+	draft := call("POST", "/automations", `{"description": "when someone buys the course, wait 3 days then email them the bonus PDF"}`, http.StatusCreated)
+	if !strings.HasPrefix(draft.Rules, "rules:\n  - name: course-bonus\n") || draft.ActivatedAt != nil {
+		t.Errorf("drafted %+v", draft)
+	}
+	if len(draft.Problems) != 1 || !strings.Contains(draft.Problems[0], "no template bonus") {
+		t.Errorf("problems %q, want the missing template", draft.Problems)
+	}
+	call("POST", "/automations/"+draft.ID+"/approve", "", http.StatusConflict)
+
+	// With the template written, the draft is approved and in force
+	err := os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "templates", "bonus.tmpl"), []byte("Subject: Your bonus\nHere is the bonus PDF for {{.product_name}}."), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := call("POST", "/automations/"+draft.ID+"/approve", "", http.StatusOK)
+	if active.ActivatedAt == nil || len(active.Problems) != 0 {
+		t.Errorf("approved %+v", active)
+	}
+	call("PUT", "/automations/"+draft.ID, `{"rules": "rules: []"}`, http.StatusConflict)
+
+	sale := func(product string) {
+		t.Helper()
+		message := newUniversalMessage(clock, NewSequentialIDs("gumroad-"+product), SaleEvent, "gumroad", "go", map[string]interface{}{
+			"resource_name": "sale", "product_permalink": product, "product_name": "The Course", "email": "ada@example.com"}, HTTP)
+		if err := transport.Inject(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sale("ebook")
+	sale("course")
+	jobs := scheduler.List()
+	if len(jobs) != 1 || jobs[0].Handler != "rule_action" || !jobs[0].RunAt.Equal(clock.Now().Add(72*time.Hour)) {
+		t.Fatalf("jobs %+v, want the bonus email in 72h", jobs)
+	}
+	if len(emails) != 0 {
+		t.Errorf("emailed at once: %v", emails)
+	}
+
+	// The rules come back after a restart, and the delayed email runs
+	engine.Remove("course-bonus")
+	engine, handler = open()
+	if rules := engine.Rules(); len(rules) != 1 || rules[0].Name != "course-bonus" {
+		t.Errorf("rules after a restart %+v", rules)
+	}
+	if err := scheduler.handlers["rule_action"](jobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0]["to"] != "ada@example.com" || emails[0]["text"] != "Subject: Your bonus\nHere is the bonus PDF for The Course." {
+		t.Errorf("emails %v", emails)
+	}
+	if len(scheduler.List()) != 0 {
+		t.Errorf("the job that ran was kept")
+	}
+
+	call("DELETE", "/automations/"+draft.ID, "", http.StatusNoContent)
+	if len(engine.Rules()) != 0 {
+		t.Errorf("rules still in force after deleting: %+v", engine.Rules())
+	}
+	if _, err := scheduler.ScheduleOnce(jobs[0].ID, "rule_action", *jobs[0].RunAt, jobs[0].Args); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.handlers["rule_action"](jobs[0]); err != nil || len(emails) != 1 || len(scheduler.List()) != 0 {
+		t.Errorf("a removed rule's action ran or was kept: %v, %v", err, emails)
+	}
+	call("DELETE", "/automations/"+draft.ID, "", http.StatusNotFound)
+}
@@ -0,0 +1,191 @@
+package main
+
+// Message pipeline benchmarks. Compare against the recorded baseline with:
+//
+//	go test -run '^$' -bench . -benchmem -count 5 > new.txt
+//	benchstat testdata/bench_baseline.txt new.txt
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchPayloads covers a typical small request and a large code translation
+var benchPayloads = map[string]map[string]interface{}{
+	"small": {
+		"action":       "generate_content",
+		"prompt":       "Create a Go function that validates email addresses",
+		"instructions": "Use standard library and include error handling",
+		"context":      map[string]interface{}{"project": "App Productizer", "priority": "high"},
+	},
+	"large": {
+		"code": strings.Repeat("func calculateTotal(items []Item) float64 { var total float64; for _, item := range items { total += item.Price }; return total }\n", 2000),
+	},
+}
+
+// quietStdout silences the bridge's progress output for the rest of the benchmark
+func quietStdout(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+// inTempDir runs the benchmark or test from a scratch directory so file
+// transports write their bridge_messages tree there
+func inTempDir(tb testing.TB) {
+	dir, err := ioutil.TempDir("", "bridge-bench")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+}
+
+func benchMessage(size string) *UniversalMessage {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	return newUniversalMessage(clock, NewSequentialIDs("bench"), CodeTranslation, "go", "python", benchPayloads[size], FileSystem)
+}
+
+func BenchmarkNewUniversalMessage(b *testing.B) {
+	for _, size := range []string{"small", "large"} {
+		b.Run(size, func(b *testing.B) {
+			payload := benchPayloads[size]
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				NewUniversalMessage(AIRequest, "go", "universal", payload, FileSystem)
+			}
+		})
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	for _, size := range []string{"small", "large"} {
+		b.Run(size, func(b *testing.B) {
+			msg := benchMessage(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg.calculateChecksum()
+			}
+		})
+	}
+}
+
+func BenchmarkToJSON(b *testing.B) {
+	for _, size := range []string{"small", "large"} {
+		b.Run(size, func(b *testing.B) {
+			msg := benchMessage(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := msg.ToJSON(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFromJSON(b *testing.B) {
+	for _, size := range []string{"small", "large"} {
+		b.Run(size, func(b *testing.B) {
+			encoded, err := benchMessage(size).ToJSON()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(encoded)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := FromJSON(encoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkTransportSend(b *testing.B) {
+	transports := map[string]func() Transport{
+		"memory": func() Transport { return NewMemoryTransport() },
+		"file":   func() Transport { return NewFileTransport("go", nil) },
+	}
+
+	for _, name := range []string{"memory", "file"} {
+		for _, size := range []string{"small", "large"} {
+			b.Run(name+"/"+size, func(b *testing.B) {
+				inTempDir(b)
+				transport := transports[name]()
+				if err := transport.Start(func(*Envelope) {}); err != nil {
+					b.Fatal(err)
+				}
+				defer transport.Close()
+
+				msg := benchMessage(size)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := transport.Send(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, size := range []string{"small", "large"} {
+		b.Run(size, func(b *testing.B) {
+			quietStdout(b)
+			transport := NewMemoryTransport()
+			bridge := NewGoBridge("", WithTransport(transport))
+			bridge.OnMessage(CodeTranslation, func(*UniversalMessage) error { return nil })
+
+			msg := benchMessage(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := transport.Inject(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEncodeDecodeParallel simulates sustained load: every goroutine
+// builds, encodes and decodes messages, so allocs/op tracks GC pressure
+func BenchmarkEncodeDecodeParallel(b *testing.B) {
+	payload := benchPayloads["small"]
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			msg := NewUniversalMessage(AIRequest, "go", "python", payload, FileSystem)
+			encoded, err := msg.ToJSON()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := DecodeMessage(strings.NewReader(encoded), 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,18 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 // MessageType represents the type of universal message
@@ -26,34 +27,66 @@ const (
 	DataSync        MessageType = "data_sync"
 	HealthCheck     MessageType = "health_check"
 	Error           MessageType = "error"
+	SaleEvent       MessageType = "sale_event" // a Gumroad ping, resource_name naming the event
 )
 
 // CommunicationChannel represents the communication method
 type CommunicationChannel string
 
 const (
-	WebSocket     CommunicationChannel = "websocket"
-	HTTP          CommunicationChannel = "http"
-	FileSystem    CommunicationChannel = "file_system"
-	Database      CommunicationChannel = "database"
-	BinarySocket  CommunicationChannel = "binary_socket"
-	SharedMemory  CommunicationChannel = "shared_memory"
+	WebSocket    CommunicationChannel = "websocket"
+	HTTP         CommunicationChannel = "http"
+	FileSystem   CommunicationChannel = "file_system"
+	Database     CommunicationChannel = "database"
+	BinarySocket CommunicationChannel = "binary_socket"
+	SharedMemory CommunicationChannel = "shared_memory"
+	NATS         CommunicationChannel = "nats"
+	GRPC         CommunicationChannel = "grpc"
 )
 
 // UniversalMessage represents a message in the universal protocol
 type UniversalMessage struct {
-	ID              string                `json:"id"`
-	Timestamp       string                `json:"timestamp"`
-	MessageType     MessageType           `json:"message_type"`
-	SourceLanguage  string                `json:"source_language"`
-	TargetLanguage  string                `json:"target_language"`
+	ID              string                 `json:"id"`
+	Timestamp       string                 `json:"timestamp"`
+	MessageType     MessageType            `json:"message_type"`
+	SourceLanguage  string                 `json:"source_language"`
+	TargetLanguage  string                 `json:"target_language"`
 	Payload         map[string]interface{} `json:"payload"`
-	ResponseChannel CommunicationChannel  `json:"response_channel"`
-	Checksum        string                `json:"checksum"`
+	ResponseChannel CommunicationChannel   `json:"response_channel"`
+	Checksum        string                 `json:"checksum"`
+	Sequence        uint64                 `json:"sequence,omitempty"` // per sender and target, from 1; not checksummed
+	// Headers carry hop metadata such as the W3C traceparent; not checksummed,
+	// so a relaying peer may add to them
+	Headers map[string]string `json:"headers,omitempty"`
+	// ContentEncoding, such as gzip, says Payload is {"data": base64} of the
+	// compressed canonical payload; the checksum is the original payload's
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// ExpiresAt is the RFC 3339 time after which the message must not be
+	// handled; empty never expires. Not checksummed.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Priority is PriorityHigh, PriorityLow or empty for the type's own, and
+	// orders dispatch; not checksummed
+	Priority string `json:"priority,omitempty"`
+	// IdempotencyKey names the logical message, so copies sent with new IDs
+	// are handled once; the ID when empty. Not checksummed.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// DeliverAt is the RFC 3339 time before which the message is neither
+	// sent nor handled, held by the scheduler meanwhile; empty delivers at
+	// once. Not checksummed.
+	DeliverAt string `json:"deliver_at,omitempty"`
+
+	receivedOn CommunicationChannel // transport channel an inbound message arrived on
+	span       *Span                // the handler's span while it runs
+	nacked     bool                 // a Nack was sent for it, so a resend is handled again
 }
 
 // NewUniversalMessage creates a new universal message
 func NewUniversalMessage(messageType MessageType, sourceLanguage, targetLanguage string, payload map[string]interface{}, responseChannel CommunicationChannel) *UniversalMessage {
+	return newUniversalMessage(defaultClock, defaultIDs, messageType, sourceLanguage, targetLanguage, payload, responseChannel)
+}
+
+// newUniversalMessage creates a message using the given clock and ID generator
+func newUniversalMessage(clock Clock, ids IDGenerator, messageType MessageType, sourceLanguage, targetLanguage string, payload map[string]interface{}, responseChannel CommunicationChannel) *UniversalMessage {
 	if targetLanguage == "" {
 		targetLanguage = "universal"
 	}
@@ -62,8 +95,8 @@ func NewUniversalMessage(messageType MessageType, sourceLanguage, targetLanguage
 	}
 
 	msg := &UniversalMessage{
-		ID:              uuid.New().String(),
-		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		ID:              ids.NewID(),
+		Timestamp:       clock.Now().UTC().Format(time.RFC3339),
 		MessageType:     messageType,
 		SourceLanguage:  sourceLanguage,
 		TargetLanguage:  targetLanguage,
@@ -77,21 +110,57 @@ func NewUniversalMessage(messageType MessageType, sourceLanguage, targetLanguage
 
 // calculateChecksum calculates the message checksum for integrity
 func (m *UniversalMessage) calculateChecksum() string {
-	// Sort payload keys for consistent checksum
-	payloadJSON, _ := json.Marshal(m.Payload)
-	content := fmt.Sprintf("%s%s%s%s", m.ID, m.Timestamp, m.MessageType, string(payloadJSON))
-	
-	hash := md5.Sum([]byte(content))
-	return fmt.Sprintf("%x", hash)
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	buf.WriteString(m.ID)
+	buf.WriteString(m.Timestamp)
+	buf.WriteString(string(m.MessageType))
+
+	// json.Encoder sorts map keys like json.Marshal but writes into the pooled buffer
+	buf.compact.Encode(m.Payload)
+	content := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	hash := md5.Sum(content)
+	return hex.EncodeToString(hash[:])
 }
 
 // ToJSON converts the message to JSON string
 func (m *UniversalMessage) ToJSON() (string, error) {
-	jsonBytes, err := json.MarshalIndent(m, "", "  ")
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := m.encodeIndented(buf)
 	if err != nil {
 		return "", err
 	}
-	return string(jsonBytes), nil
+	return buf.String(), nil
+}
+
+// WriteJSON writes the indented JSON encoding to w without an intermediate string
+func (m *UniversalMessage) WriteJSON(w io.Writer) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := m.encodeIndented(buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// encodeIndented appends the MarshalIndent-compatible encoding to buf
+func (m *UniversalMessage) encodeIndented(buf *encodeBuffer) error {
+	err := buf.indented.Encode(m)
+	if err != nil {
+		return err
+	}
+
+	// Encode terminates with a newline that MarshalIndent does not emit
+	buf.Truncate(buf.Len() - 1)
+	return nil
 }
 
 // FromJSON creates a UniversalMessage from JSON string
@@ -101,6 +170,10 @@ func FromJSON(jsonStr string) (*UniversalMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = decompressPayload(&msg, 0)
+	if err != nil {
+		return nil, err
+	}
 
 	// Verify checksum
 	expectedChecksum := msg.calculateChecksum()
@@ -111,155 +184,567 @@ func FromJSON(jsonStr string) (*UniversalMessage, error) {
 	return &msg, nil
 }
 
+// DecodeMessage reads one JSON message from r without buffering it into a
+// string first. At most limit bytes are read; limit <= 0 means no limit.
+func DecodeMessage(r io.Reader, limit int64) (*UniversalMessage, error) {
+	if limit > 0 {
+		r = &limitedReader{r: r, remaining: limit}
+	}
+
+	var msg UniversalMessage
+	decoder := json.NewDecoder(r)
+	err := decoder.Decode(&msg)
+	if err != nil {
+		if errors.Is(err, errReadLimit) {
+			return nil, &PayloadTooLargeError{Size: -1, Limit: limit}
+		}
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after message")
+	}
+	err = decompressPayload(&msg, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify checksum
+	expectedChecksum := msg.calculateChecksum()
+	if msg.Checksum != expectedChecksum {
+		return nil, fmt.Errorf("message checksum mismatch - data may be corrupted")
+	}
+
+	return &msg, nil
+}
+
+// errReadLimit is returned by limitedReader once its budget is spent
+var errReadLimit = errors.New("read limit exceeded")
+
+// limitedReader is io.LimitReader that fails instead of reporting EOF
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errReadLimit
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
 // GoBridge represents the Go implementation of the Universal Bridge
 type GoBridge struct {
 	bridgeURL       string
+	mu              sync.RWMutex
 	messageHandlers map[MessageType]func(*UniversalMessage) error
-	isConnected     bool
+	filters         []func(*UniversalMessage) bool
+	receiveHooks    []func(*UniversalMessage)
+	sendHooks       []func(*UniversalMessage)
+	middleware      []Middleware
+	pending         *PendingRequests
+	store           *MessageStore
+	deadLetters     *DeadLetterQueue
+	scheduler       *Scheduler // holds messages with a deliver_at, once RegisterBridgeJobs is called
+	sequences       *Sequencer
+	receipts        *Receipts
+	streams         *streamRegistry
+	metrics         *BridgeMetrics
+	tracer          *Tracer
+	sinks           []*Sink
+	state           BridgeState
+	manualStart     bool
+	closeOnce       sync.Once
+	closed          chan struct{} // closed once Close has finished
+	closeErr        error
+	clock           Clock
+	ids             IDGenerator
+	transport       Transport
+	channels        map[CommunicationChannel]Transport // extra transports by channel
+	pipelineConfig  PipelineConfig
+	pipeline        *Pipeline
+	drainTimeout    time.Duration
+}
+
+// BridgeOption configures optional GoBridge behaviour
+type BridgeOption func(*GoBridge)
+
+// WithClock injects the clock used for timestamps and polling
+func WithClock(clock Clock) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.clock = clock
+	}
+}
+
+// WithIDGenerator injects the generator used for message IDs
+func WithIDGenerator(ids IDGenerator) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.ids = ids
+	}
+}
+
+// WithDrainTimeout bounds how long Close waits for sinks to empty their queues
+func WithDrainTimeout(timeout time.Duration) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.drainTimeout = timeout
+	}
 }
 
 // NewGoBridge creates a new Go bridge instance
-func NewGoBridge(bridgeURL string) *GoBridge {
+func NewGoBridge(bridgeURL string, opts ...BridgeOption) *GoBridge {
 	if bridgeURL == "" {
-		bridgeURL = "ws://localhost:8765"
+		bridgeURL = DefaultBridgeURL
 	}
 
 	bridge := &GoBridge{
 		bridgeURL:       bridgeURL,
 		messageHandlers: make(map[MessageType]func(*UniversalMessage) error),
-		isConnected:     false,
+		state:           BridgeIdle,
+		closed:          make(chan struct{}),
+		clock:           defaultClock,
+		ids:             defaultIDs,
+		pipelineConfig:  DefaultPipelineConfig(),
+		drainTimeout:    30 * time.Second,
+	}
+
+	bridge.sequences = newSequencer(bridge)
+	bridge.messageHandlers[ResendRequest] = bridge.sequences.handleResendRequest
+	bridge.receipts = newReceipts(bridge)
+	bridge.messageHandlers[Ack] = bridge.receipts.handleReceipt
+	bridge.messageHandlers[Nack] = bridge.receipts.handleReceipt
+	bridge.streams = newStreamRegistry()
+	bridge.metrics = newBridgeMetrics()
+	bridge.messageHandlers[StreamChunk] = bridge.streams.handleChunk
+	bridge.receiveHooks = append(bridge.receiveHooks, bridge.streams.observe)
+
+	for _, opt := range opts {
+		opt(bridge)
+	}
+	if bridge.transport == nil {
+		bridge.transport = NewFileTransport("go", bridge.clock)
+	}
+	for _, transport := range bridge.transports() {
+		if files, ok := transport.(*FileTransport); ok {
+			files.tracer = bridge.tracer
+		}
 	}
 
-	bridge.connect()
+	// Inbound envelopes flow through the staged pipeline into handlers
+	bridge.pipeline = NewPipeline(bridge.pipelineConfig, bridge.clock, bridge.dispatchIncoming)
+	bridge.pipeline.tracer = bridge.tracer
+	bridge.pipeline.duplicate = func(message *UniversalMessage) { bridge.sendReceipt(message, nil) }
+
+	if !bridge.manualStart {
+		err := bridge.connect()
+		if err != nil {
+			log.Printf("❌ %v", err)
+		}
+	}
 	return bridge
 }
 
-// connect establishes connection to the Universal Bridge
+// connect starts the transports receiving into the pipeline
 func (gb *GoBridge) connect() error {
 	fmt.Println("🔌 Connecting to Universal Bridge...")
 
-	// Ensure directories exist
-	err := gb.ensureDirectories()
-	if err != nil {
-		return fmt.Errorf("failed to create directories: %v", err)
+	for _, transport := range gb.transports() {
+		err := transport.Start(gb.pipeline.Submit)
+		if err != nil {
+			return fmt.Errorf("failed to start %s transport: %v", transport.Channel(), err)
+		}
 	}
 
-	// Start file watcher
-	go gb.startFileWatcher()
-
-	gb.isConnected = true
+	gb.mu.Lock()
+	if gb.state == BridgeIdle {
+		gb.state = BridgeRunning
+	}
+	gb.mu.Unlock()
 	fmt.Println("✅ Connected to Universal Bridge")
 	return nil
 }
 
-// ensureDirectories creates necessary directories
-func (gb *GoBridge) ensureDirectories() error {
-	dirs := []string{
-		"bridge_messages/go",
-		"bridge_messages/incoming",
-		"bridge_messages/outgoing",
-	}
+// Close stops receiving, finishes every message already in the pipeline,
+// then drains the sinks. Unhandled inbound messages stay with the transport,
+// so a replacement process picks them up. Handlers may still send while the
+// pipeline drains. Later calls wait for the first and return its error.
+func (gb *GoBridge) Close() error {
+	gb.closeOnce.Do(func() {
+		gb.closeErr = gb.drain()
+		gb.mu.Lock()
+		gb.state = BridgeClosed
+		gb.mu.Unlock()
+		close(gb.closed)
+	})
+	<-gb.closed
+	return gb.closeErr
+}
 
-	for _, dir := range dirs {
-		err := os.MkdirAll(dir, 0755)
-		if err != nil {
-			return err
+func (gb *GoBridge) drain() error {
+	fmt.Println("🛑 Draining Universal Bridge...")
+	gb.mu.Lock()
+	gb.state = BridgeDraining
+	sinks := gb.sinks
+	gb.mu.Unlock()
+
+	// Transports that can stop receiving are closed after the drain, so the
+	// acks of messages still in the pipeline are recorded
+	var err error
+	var stopped []Transport
+	for _, transport := range gb.transports() {
+		if stopper, ok := transport.(ReceiveStopper); ok {
+			stopper.StopReceiving()
+			stopped = append(stopped, transport)
+			continue
+		}
+		if closeErr := transport.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	gb.pipeline.Close()
+	for _, transport := range stopped {
+		if closeErr := transport.Close(); err == nil {
+			err = closeErr
 		}
 	}
 
-	return nil
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		wg.Add(1)
+		go func(sink *Sink) {
+			defer wg.Done()
+			sink.Drain(gb.drainTimeout)
+		}(sink)
+	}
+	wg.Wait()
+
+	fmt.Println("✅ Universal Bridge drained")
+	return err
 }
 
-// startFileWatcher watches for incoming messages
-func (gb *GoBridge) startFileWatcher() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// PipelineStats returns per-stage counters for inbound processing
+func (gb *GoBridge) PipelineStats() []StageStats {
+	return gb.pipeline.Stats()
+}
 
-	for range ticker.C {
-		gb.processIncomingMessages()
+// dispatchIncoming handles a message from the pipeline. A message whose
+// handler fails is handed to the dead letter queue, if there is one, and
+// counts as handled so the transport discards it. One past its expires_at
+// is not handled at all, and one before its deliver_at is held until then.
+// A message that asked for a receipt is acked once handled or held and
+// nacked once expired or dead, not while the queue retries it.
+func (gb *GoBridge) dispatchIncoming(message *UniversalMessage) error {
+	if held, err := gb.holdIncoming(message); held || err != nil {
+		return err
 	}
+	if message.Expired(gb.clock.Now()) {
+		if !gb.expire(message) {
+			return fmt.Errorf("failed to dead-letter expired message %s", message.ID)
+		}
+		gb.sendReceipt(message, fmt.Errorf("%w at %s", ErrMessageExpired, message.ExpiresAt))
+		return nil
+	}
+	err := gb.handleIncomingMessage(message)
+	if err == nil {
+		gb.sendReceipt(message, nil)
+		return nil
+	}
+	gb.mu.RLock()
+	deadLetters := gb.deadLetters
+	gb.mu.RUnlock()
+	if deadLetters != nil && deadLetters.failed(message, err) {
+		if deadLetters.dead(message.ID) {
+			gb.sendReceipt(message, err)
+		}
+		return nil
+	}
+	return err
 }
 
-// processIncomingMessages processes messages from the file system
-func (gb *GoBridge) processIncomingMessages() {
-	incomingDir := "bridge_messages/go"
-	
-	files, err := ioutil.ReadDir(incomingDir)
-	if err != nil {
-		return // Directory might not exist yet
-	}
+// handleIncomingMessage handles an incoming message
+func (gb *GoBridge) handleIncomingMessage(message *UniversalMessage) error {
+	fmt.Printf("📥 Received message: %s (%s)\n", message.ID, message.MessageType)
+	gb.sequences.observe(message)
+	gb.sequences.CheckGaps()
 
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") {
-			filePath := filepath.Join(incomingDir, file.Name())
-			
-			content, err := ioutil.ReadFile(filePath)
-			if err != nil {
-				log.Printf("❌ Error reading file %s: %v", filePath, err)
-				continue
-			}
+	gb.mu.RLock()
+	filters := gb.filters
+	hooks := gb.receiveHooks
+	store := gb.store
+	gb.mu.RUnlock()
 
-			message, err := FromJSON(string(content))
-			if err != nil {
-				log.Printf("❌ Error parsing message %s: %v", filePath, err)
-				continue
-			}
+	if store != nil {
+		store.received(message)
+	}
+	gb.metrics.receivedMessage(message)
 
-			err = gb.handleIncomingMessage(message)
-			if err != nil {
-				log.Printf("❌ Error handling message %s: %v", message.ID, err)
-				continue
+	for _, filter := range filters {
+		if !filter(message) {
+			if store != nil {
+				store.handled(message, StatusFiltered, nil)
 			}
-
-			// Move to processed
-			processedDir := filepath.Join(incomingDir, "processed")
-			os.MkdirAll(processedDir, 0755)
-			os.Rename(filePath, filepath.Join(processedDir, file.Name()))
+			return nil
 		}
 	}
+	for _, hook := range hooks {
+		hook(message)
+	}
+	gb.routeToSinks(message)
+	return gb.runHandler(message)
 }
 
-// handleIncomingMessage handles an incoming message
-func (gb *GoBridge) handleIncomingMessage(message *UniversalMessage) error {
-	fmt.Printf("📥 Received message: %s (%s)\n", message.ID, message.MessageType)
-
+// runHandler runs the message type's handler and records the outcome. The
+// dead letter queue retries through it, so a retry does not run receive
+// hooks or sinks again.
+func (gb *GoBridge) runHandler(message *UniversalMessage) error {
+	gb.mu.RLock()
 	handler, exists := gb.messageHandlers[message.MessageType]
+	middleware := gb.middleware
+	store := gb.store
+	gb.mu.RUnlock()
+
 	if exists {
-		return handler(message)
+		handle := wrapHandler(middleware, handler)
+		span := gb.tracer.Start("bridge.handle "+string(message.MessageType), SpanConsumer, message.traceContext())
+		span.SetAttribute("messaging.message.id", message.ID)
+		span.SetAttribute("bridge.source_language", message.SourceLanguage)
+		message.span = span
+		start := gb.clock.Now()
+		err := handle(message)
+		gb.metrics.handled(message.MessageType, gb.clock.Now().Sub(start), err)
+		span.Finish(err)
+		if store != nil {
+			status := StatusHandled
+			if err != nil {
+				status = StatusFailed
+			}
+			store.handled(message, status, err)
+		}
+		return err
 	}
 
 	fmt.Printf("⚠️ No handler for message type: %s\n", message.MessageType)
+	if store != nil {
+		store.handled(message, StatusUnhandled, nil)
+	}
 	return nil
 }
 
-// SendMessage sends a message through the universal bridge
-func (gb *GoBridge) SendMessage(message *UniversalMessage) (string, error) {
-	if !gb.isConnected {
-		return "", fmt.Errorf("not connected to Universal Bridge")
+// SendMessage sends a message through the universal bridge. The Delivery
+// names the sent message and, when it asked for a receipt with RequestAck,
+// waits for the receiver's ack while Receipts resends it. A message whose
+// deliver_at is ahead is held by the scheduler and sent then; its Delivery
+// does not wait for a receipt.
+func (gb *GoBridge) SendMessage(message *UniversalMessage) (*Delivery, error) {
+	later, err := gb.deliverLater(message, deliverSend)
+	if err != nil {
+		return nil, err
+	}
+	if later {
+		return &Delivery{ID: message.ID}, nil
+	}
+	transport := gb.transportFor(message)
+	// Track before sending, since a fast peer can ack before Send returns
+	delivery, added := gb.receipts.expect(message, transport)
+	_, err = gb.sendOn(transport, message)
+	if err != nil {
+		if added {
+			gb.receipts.forget(message.ID)
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// send sends a message, returning its ID, for the helpers that return one
+func (gb *GoBridge) send(message *UniversalMessage) (string, error) {
+	delivery, err := gb.SendMessage(message)
+	if err != nil {
+		return "", err
+	}
+	return delivery.ID, nil
+}
+
+// sendOn sends a message over a specific transport
+func (gb *GoBridge) sendOn(transport Transport, message *UniversalMessage) (string, error) {
+	gb.mu.RLock()
+	state := gb.state
+	gb.mu.RUnlock()
+	switch state {
+	case BridgeIdle:
+		return "", ErrBridgeNotStarted
+	case BridgeClosed:
+		return "", ErrBridgeClosed
+	}
+
+	if ttl := gb.pipeline.ttl[message.MessageType]; ttl > 0 && message.ExpiresAt == "" {
+		if err := message.ExpireAfter(ttl); err != nil {
+			log.Printf("❌ Sending %s without an expiry: %v", message.ID, err)
+		}
+	}
+
+	gb.mu.RLock()
+	sendHooks := gb.sendHooks
+	gb.mu.RUnlock()
+	if len(sendHooks) > 0 {
+		for _, hook := range sendHooks {
+			hook(message)
+		}
+		message.Checksum = message.calculateChecksum()
 	}
 
-	// Send via file system
-	jsonStr, err := message.ToJSON()
+	gb.mu.RLock()
+	pending := gb.pending
+	store := gb.store
+	gb.mu.RUnlock()
+
+	// The send span is the parent of whatever the peer does with the message
+	span := gb.tracer.Start("bridge.send "+string(message.MessageType), SpanProducer, message.traceContext())
+	span.SetAttribute("messaging.message.id", message.ID)
+	span.SetAttribute("bridge.channel", string(transport.Channel()))
+	span.SetAttribute("bridge.target_language", message.TargetLanguage)
+	message.setTraceContext(span.SpanContext())
+
+	err := gb.checkPayloadSize(message, transport.Channel())
+	if err == nil && message.Expired(gb.clock.Now()) {
+		// The peer would only dead-letter it
+		_, _, err = message.expiry()
+		if err == nil {
+			err = fmt.Errorf("%w at %s, before it was sent", ErrMessageExpired, message.ExpiresAt)
+		}
+	}
 	if err != nil {
+		if store != nil {
+			store.sent(message, transport.Channel(), err)
+		}
+		gb.metrics.sentMessage(message, transport.Channel(), err)
+		span.Finish(err)
 		return "", err
 	}
+	gb.sequences.assign(message)
+
+	// Track requests before sending, since a fast peer can reply before Send returns
+	if pending != nil {
+		pending.sending(message)
+	}
 
-	outgoingPath := filepath.Join("bridge_messages/incoming", message.ID+".json")
-	err = ioutil.WriteFile(outgoingPath, []byte(jsonStr), 0644)
+	// Limits apply to the message uncompressed, as the receiver restores it
+	wire, err := compressPayload(message, gb.pipeline.compression, gb.pipeline.compressOver)
+	if err == nil {
+		err = transport.Send(wire)
+	}
+	if store != nil {
+		store.sent(message, transport.Channel(), err)
+	}
+	gb.metrics.sentMessage(message, transport.Channel(), err)
+	span.Finish(err)
 	if err != nil {
+		if pending != nil {
+			pending.forget(message.ID)
+		}
 		return "", err
 	}
 
-	fmt.Printf("📤 Message sent: %s (%s → %s)\n", message.ID, message.SourceLanguage, message.TargetLanguage)
+	fmt.Printf("📤 Message sent: %s (%s → %s over %s)\n", message.ID, message.SourceLanguage, message.TargetLanguage, transport.Channel())
 	return message.ID, nil
 }
 
+// NewMessage creates a message stamped with the bridge's clock and ID generator
+func (gb *GoBridge) NewMessage(messageType MessageType, targetLanguage string, payload map[string]interface{}, responseChannel CommunicationChannel) *UniversalMessage {
+	return newUniversalMessage(gb.clock, gb.ids, messageType, "go", targetLanguage, payload, responseChannel)
+}
+
 // OnMessage registers a handler for a specific message type
 func (gb *GoBridge) OnMessage(messageType MessageType, handler func(*UniversalMessage) error) {
+	gb.mu.Lock()
 	gb.messageHandlers[messageType] = handler
+	gb.mu.Unlock()
 	fmt.Printf("📝 Registered handler for %s\n", messageType)
 }
 
+// OnFilter registers a hook that runs before the receive hooks, sinks and
+// handler. It may rewrite the payload, and returning false drops the
+// message as handled.
+func (gb *GoBridge) OnFilter(filter func(*UniversalMessage) bool) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.filters = append(gb.filters, filter)
+}
+
+// OnReceive registers a hook that observes every received message before its handler runs
+func (gb *GoBridge) OnReceive(hook func(*UniversalMessage)) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.receiveHooks = append(gb.receiveHooks, hook)
+}
+
+// OnSend registers a hook that may rewrite every message's payload before it
+// is sent, resends included; the checksum is recomputed afterwards
+func (gb *GoBridge) OnSend(hook func(*UniversalMessage)) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.sendHooks = append(gb.sendHooks, hook)
+}
+
+// AddSink registers a downstream service handler behind its own bulkhead and
+// circuit breaker. Unlike OnMessage handlers, sink failures never hold up
+// other sinks or cause the inbound message to be redelivered.
+func (gb *GoBridge) AddSink(name string, config SinkConfig, handler func(*UniversalMessage) error) *Sink {
+	sink := NewSink(name, config, gb.clock, handler)
+
+	gb.mu.Lock()
+	gb.sinks = append(gb.sinks, sink)
+	gb.mu.Unlock()
+	fmt.Printf("📝 Registered sink %s\n", name)
+	return sink
+}
+
+// Sink returns the registered sink with this name, or nil
+func (gb *GoBridge) Sink(name string) *Sink {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	for _, sink := range gb.sinks {
+		if sink.Name() == name {
+			return sink
+		}
+	}
+	return nil
+}
+
+// SinkStats returns counters and breaker state for every sink
+func (gb *GoBridge) SinkStats() []SinkStats {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+
+	stats := make([]SinkStats, 0, len(gb.sinks))
+	for _, sink := range gb.sinks {
+		stats = append(stats, sink.Stats())
+	}
+	return stats
+}
+
+// routeToSinks queues the message on every sink that accepts its type
+func (gb *GoBridge) routeToSinks(message *UniversalMessage) {
+	gb.mu.RLock()
+	sinks := gb.sinks
+	gb.mu.RUnlock()
+
+	for _, sink := range sinks {
+		if !sink.Accepts(message.MessageType) {
+			continue
+		}
+		err := sink.Enqueue(message)
+		if err != nil {
+			log.Printf("❌ Sink %s rejected message %s: %v", sink.Name(), message.ID, err)
+		}
+	}
+}
+
 // RequestAI sends an AI request
 func (gb *GoBridge) RequestAI(prompt, instructions string, context map[string]interface{}) (string, error) {
 	if context == nil {
@@ -273,8 +758,8 @@ func (gb *GoBridge) RequestAI(prompt, instructions string, context map[string]in
 		"context":      context,
 	}
 
-	message := NewUniversalMessage(AIRequest, "go", "universal", payload, FileSystem)
-	return gb.SendMessage(message)
+	message := gb.NewMessage(AIRequest, "universal", payload, FileSystem)
+	return gb.send(message)
 }
 
 // TranslateCode translates code to another language
@@ -283,8 +768,8 @@ func (gb *GoBridge) TranslateCode(code, targetLanguage string) (string, error) {
 		"code": code,
 	}
 
-	message := NewUniversalMessage(CodeTranslation, "go", targetLanguage, payload, FileSystem)
-	return gb.SendMessage(message)
+	message := gb.NewMessage(CodeTranslation, targetLanguage, payload, FileSystem)
+	return gb.send(message)
 }
 
 // CallFunction calls a function in another language
@@ -302,8 +787,8 @@ func (gb *GoBridge) CallFunction(targetLanguage, functionName string, args []int
 		"kwargs":        kwargs,
 	}
 
-	message := NewUniversalMessage(FunctionCall, "go", targetLanguage, payload, FileSystem)
-	return gb.SendMessage(message)
+	message := gb.NewMessage(FunctionCall, targetLanguage, payload, FileSystem)
+	return gb.send(message)
 }
 
 // Go-specific AI helpers
@@ -351,86 +836,811 @@ func (gb *GoBridge) GenerateGoTests(code string) (string, error) {
 	)
 }
 
-// Demo function
-func demoGoBridge() {
-	fmt.Println("🌍 GO UNIVERSAL BRIDGE DEMO")
-	fmt.Println(strings.Repeat("=", 50))
+func main() {
+	configPath := flag.String("config", os.Getenv(configEnv), "YAML or TOML file of the data directory, channels, poll intervals, secrets and provider keys; environment variables override it, and flags override both")
+	scenarioPath := flag.String("scenario", "scenarios/demo.yaml", "scenario file to run")
+	serve := flag.Bool("serve", false, "run until SIGINT or SIGTERM instead of running a scenario; SIGHUP restarts without dropping connections; set "+otlpEndpointEnv+" to export traces to an OpenTelemetry collector")
+	preview := flag.String("preview", "", "render the named template against its preview data and exit")
+	previewProduct := flag.String("product", "", "product whose template overrides -preview uses")
+	previewLocale := flag.String("locale", "", "locale whose translations -preview uses")
+	openAPIDir := flag.String("openapi", "", "write the OpenAPI spec of every HTTP endpoint and the TypeScript and Python clients generated from it into this directory and exit")
+	RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *openAPIDir != "" {
+		err := WriteAPIClients(*openAPIDir)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("📜 Wrote the OpenAPI spec and clients to %s\n", *openAPIDir)
+		return
+	}
 
-	bridge := NewGoBridge("")
+	settings, err := LoadConfig(*configPath, flag.CommandLine)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	templates := NewTemplateStore(settings.Templates)
+	if *preview != "" {
+		text, err := templates.Preview(*preview, *previewProduct, *previewLocale)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Print(text)
+		return
+	}
 
-	// Wait for connection
-	time.Sleep(1 * time.Second)
+	if *serve {
+		listeners, err := NewListenerSet()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		config := DefaultPipelineConfig()
+		config.MaxMessageBytes = settings.Messages.MaxBytes
+		config.Compression = settings.Compression.Encoding
+		config.CompressOver = int64(settings.Compression.Over)
+		config.ExpiredAction = settings.Expiry.Action
+		config.DedupeWindow = settings.Dedupe.Window
+		config.DedupePath = settings.Path("dedupe", "seen.jsonl")
+		if settings.Expiry.AIRequestTTL > 0 {
+			config.TTL = map[MessageType]time.Duration{AIRequest: settings.Expiry.AIRequestTTL}
+		}
+		config.DispatchWorkers = settings.Messages.Workers
+		config.LowPriorityTypes = nil
+		for _, name := range settings.Messages.LowPriority {
+			config.LowPriorityTypes = append(config.LowPriorityTypes, MessageType(name))
+		}
+		config.LowPriorityEvery = settings.Messages.LowPriorityEvery
+		config.TypeConcurrency = settings.Messages.TypeConcurrency
+		fileTransport := settings.NewFileTransport(nil)
+		// The process that handed over is still draining its claimed files
+		fileTransport.RecoverClaimed = !listeners.HandedOver()
+		options := []BridgeOption{WithPipelineConfig(config), WithTransport(fileTransport)}
+		var httpTransport *HTTPTransport
+		if settings.HTTP.Listen != "" || settings.HTTP.Peer != "" {
+			httpTransport, err = settings.NewHTTPTransport(settings.HTTP.Peer, nil)
+			if err != nil {
+				log.Fatalf("❌ -http-peer: %v", err)
+			}
+			if token := settings.Secrets.HTTPToken; token != "" {
+				httpTransport.Token = token
+				httpTransport.Headers.Set("Authorization", "Bearer "+token)
+			}
+			options = append(options, WithChannelTransport(httpTransport))
+		}
+		var grpcTransport *GRPCTransport
+		if settings.GRPC.Listen != "" || settings.GRPC.Peer != "" {
+			grpcTransport = NewGRPCTransport(settings.GRPC.Peer, settings.Language, nil)
+			grpcTransport.Token = settings.Secrets.HTTPToken
+			options = append(options, WithChannelTransport(grpcTransport))
+		}
+		if settings.Redis.URL != "" {
+			redisTransport, err := settings.NewRedisTransport(nil)
+			if err != nil {
+				log.Fatalf("❌ -redis: %v", err)
+			}
+			options = append(options, WithChannelTransport(redisTransport))
+		}
+		if settings.NATS.URL != "" {
+			natsTransport, err := settings.NewNATSTransport(nil)
+			if err != nil {
+				log.Fatalf("❌ -nats: %v", err)
+			}
+			options = append(options, WithChannelTransport(natsTransport))
+		}
+		var traces *OTLPExporter
+		if endpoint := settings.Providers.OTLPEndpoint; endpoint != "" {
+			traces = NewOTLPExporter(endpoint, settings.Providers.OTelService, nil)
+			traces.Start()
+			options = append(options, WithTracer(NewTracer(nil, traces)))
+		}
+		bridge := NewGoBridge(settings.BridgeURL, options...)
+		bridge.Use(RecoverMiddleware())
+		if len(settings.Messages.AllowSources) > 0 {
+			bridge.Use(AuthMiddleware(AllowSources(settings.Messages.AllowSources...)))
+		}
+		if settings.HTTP.Listen != "" {
+			listener, err := listeners.Listen("http", "tcp", settings.HTTP.Listen)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			httpTransport.Serve(listener)
+		}
+		if settings.GRPC.Listen != "" {
+			listener, err := listeners.Listen("grpc", "tcp", settings.GRPC.Listen)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			grpcTransport.Serve(listener)
+		}
+		pending := NewPendingRequests(bridge, settings.Messages.RequestTimeout)
+		pending.Start(settings.Poll.PendingRequests)
+		bridge.Sequences().Start(settings.Poll.Sequences)
+		bridge.Receipts().Timeout = settings.Messages.AckTimeout
+		bridge.Receipts().Attempts = settings.Messages.AckAttempts
+		bridge.Receipts().Start(settings.Poll.Receipts)
+		sales, err := NewSalesStore(settings.Stores.Sales)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		expenses, err := NewExpenses(bridge, sales, settings.Path("expenses", "expenses.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		expenses.Location, err = time.LoadLocation(settings.Timezone)
+		if err != nil {
+			log.Fatalf("❌ timezone: %v", err)
+		}
+		if settings.Features.Bundles != "" {
+			catalog, err := LoadBundleCatalog(settings.Features.Bundles)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			DecomposeBundles(bridge, catalog)
+		}
+		var enrichment *BuyerEnrichment
+		if settings.Enrichment.Buyers {
+			enrichment, err = NewBuyerEnrichment(bridge, sales, settings.Path("enrichment", "companies.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			if settings.Enrichment.CompanyLookup != "" {
+				enrichment.Lookup = NewCompanyLookup(settings.Enrichment.CompanyLookup, settings.Providers.CompanyLookupKey, bridge.clock)
+			}
+			enrichment.Start()
+		}
+		HandleGifts(bridge, templates)
+		RecordSales(bridge, sales)
+		var store *MessageStore
+		if settings.Stores.Messages != "off" {
+			store, err = NewMessageStore(bridge, settings.Stores.Messages)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		deadLetters, err := NewDeadLetterQueue(bridge, settings.Path("deadletter"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		deadLetters.MaxAttempts = settings.Messages.MaxAttempts
+		deadLetters.Start()
+		sweeper := NewExpirySweeper(bridge, fileTransport)
+		sweeper.Start(settings.Poll.Expired)
+		switch {
+		case settings.Email.SMTP != "":
+			_, err = NewEmailSender(bridge, settings.Email.SMTP, settings.Email.From, settings.Providers.SMTPUsername, settings.Providers.SMTPPassword)
+			if err != nil {
+				log.Fatalf("❌ -smtp: %v", err)
+			}
+		case settings.Email.API != "":
+			var driver EmailDriver
+			switch settings.Email.API {
+			case "sendgrid":
+				driver, err = NewSendGridDriver(settings.Providers.SendGridKey)
+			case "mailgun":
+				driver, err = NewMailgunDriver(settings.Email.MailgunDomain, settings.Providers.MailgunKey)
+			}
+			if err == nil {
+				_, err = NewDriverEmailSender(bridge, settings.Email.From, driver)
+			}
+			if err != nil {
+				log.Fatalf("❌ -email-api: %v", err)
+			}
+		}
+		if settings.Email.PurchaseEmails {
+			_, err = NewPurchaseEmails(bridge, templates, settings.Path("emails", "purchases.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var sms *SMSNotifier
+		if settings.Features.SMS != "" {
+			config, err := LoadSMSConfig(settings.Features.SMS)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			sms, err = NewSMSNotifier(bridge, config, settings.Providers.TwilioAccountSID, settings.Providers.TwilioAuthToken, settings.Path("sms", "held.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			sms.Start()
+		}
+		var aiServer *AIServer
+		if settings.Features.AI != "" {
+			config, err := LoadAIConfig(settings.Features.AI)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			aiServer, err = NewAIServerFromConfig(bridge, config, settings.Providers.OpenAIKey, settings.Providers.AnthropicKey)
+			if err != nil {
+				log.Fatalf("❌ -ai: %v", err)
+			}
+			aiServer.Expenses = expenses
+		}
+		var push *PushNotifier
+		if settings.Features.Push != "" {
+			config, err := LoadPushConfig(settings.Features.Push)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			push, err = NewPushNotifier(bridge, config, settings.Providers.NtfyToken, settings.Providers.PushoverToken)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var chat *ChatNotifier
+		if settings.Features.Chat != "" {
+			config, err := LoadChatConfig(settings.Features.Chat)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			chat = NewChatNotifier(bridge, config, sales, expenses.Location)
+			chat.Start()
+		}
+		if settings.Features.Webhooks != "" {
+			config, err := LoadWebhookConfig(settings.Features.Webhooks)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			NewWebhookDispatcher(bridge, config)
+		}
+		if settings.Features.GitHub != "" {
+			config, err := LoadGitHubConfig(settings.Features.GitHub)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			_, err = NewGitHubAccess(bridge, config, settings.Providers.GitHubToken, settings.Path("github", "grants.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var discord *DiscordRoles
+		if settings.Features.Discord != "" {
+			config, err := LoadDiscordConfig(settings.Features.Discord)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			discord, err = NewDiscordRoles(bridge, config, sales, templates, settings.Providers.DiscordToken, settings.Path("discord", "members.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			if config.ApplicationID != "" {
+				err = discord.RegisterCommands()
+				if err != nil {
+					log.Printf("❌ %v", err)
+				}
+			}
+		}
+		var account *ServiceAccount
+		if settings.Google.Sheet != "" || settings.Features.Drive != "" || settings.Google.Calendar != "" {
+			account, err = LoadServiceAccount(settings.Providers.GoogleCredentials)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var sheets *SheetsLogger
+		if settings.Google.Sheet != "" {
+			sheets = NewSheetsLogger(bridge, account, settings.Google.Sheet)
+			sheets.Start()
+		}
+		if settings.Features.Drive != "" {
+			config, err := LoadDriveConfig(settings.Features.Drive)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			NewDriveUploader(bridge, account, config)
+		}
+		stream, err := NewSaleStream(bridge, settings.Path("stream"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if settings.Features.StreamSubscribers != "" {
+			webhooks, err := LoadStreamWebhooks(settings.Features.StreamSubscribers)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			for _, webhook := range webhooks {
+				_, err = stream.SubscribeWebhook(webhook)
+				if err != nil {
+					log.Fatalf("❌ %v", err)
+				}
+			}
+		}
+		recommender := NewRecommender(sales)
 
-	// Set up message handlers
-	bridge.OnMessage(AIResponse, func(message *UniversalMessage) error {
-		fmt.Printf("🤖 AI Response received: %+v\n", message.Payload)
-		return nil
-	})
+		optOuts, err := NewOptOutList(settings.Path("broadcasts", "optouts.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		broadcaster, err := NewBroadcaster(bridge, sales, templates, optOuts, settings.Path("broadcasts", "broadcasts.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
 
-	bridge.OnMessage(CodeTranslation, func(message *UniversalMessage) error {
-		fmt.Printf("🔄 Code translation received: %+v\n", message.Payload)
-		return nil
-	})
+		gumroad := NewGumroadClient()
+		gumroad.AccessToken = settings.Providers.GumroadAccessToken
+		offers, err := NewOfferCodes(gumroad, settings.Path("offers", "offer_codes.json"), bridge.clock)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		TrackOfferCodes(bridge, offers)
+		ServeGumroadFunctions(bridge, offers.client)
+		licenses := NewLicenseVerifier(bridge, offers.client)
+		licenses.TTL = settings.Gumroad.LicenseTTL
+		scheduler := NewScheduler(settings.Stores.Jobs, bridge.clock)
+		seasonal, err := NewSeasonalSales(bridge, offers.client, offers, templates, scheduler, settings.Path("seasonal", "sales.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		calendar := NewCalendar(bridge, scheduler, seasonal)
+		calendar.PayoutDay, _ = parseWeekday(settings.Gumroad.PayoutDay)
+		var calendarSync *GoogleCalendarSync
+		if settings.Google.Calendar != "" {
+			calendarSync, err = NewGoogleCalendarSync(calendar, account, settings.Google.Calendar, settings.Path("calendar", "synced.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var snapshots *AccountSnapshots
+		if settings.Gumroad.Snapshots != "" {
+			snapshots, err = NewAccountSnapshots(bridge, gumroad, sales, expenses.Location, settings.Path("snapshots", "account.jsonl"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			snapshots.Target = settings.Gumroad.SnapshotTarget
+			snapshots.PayoutDay = calendar.PayoutDay
+		}
+		var priceWatch *PriceWatch
+		if settings.Features.PriceWatch != "" {
+			config, err := LoadPriceWatchConfig(settings.Features.PriceWatch)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			priceWatch, err = NewPriceWatch(bridge, config, settings.Path("pricewatch", "pages.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		waitlists, err := NewWaitlists(bridge, templates, offers, optOuts, settings.Path("waitlists", "waitlists.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		customers, err := NewCustomers(bridge, sales, settings.Path("customers", "customers.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		customers.Waitlists = waitlists
+		customers.OptOuts = optOuts
+		var segments *Segments
+		if settings.Features.Segments != "" {
+			set, err := LoadSegments(settings.Features.Segments)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			segments, err = NewSegments(bridge, customers, set, settings.Path("segments", "members.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		checkouts, err := NewAbandonedCheckouts(bridge, templates, optOuts, settings.Path("checkouts", "visits.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		checkouts.FollowUps = settings.Checkout.FollowUps
+		if settings.Checkout.FollowUpSegment != "" {
+			if _, ok := segments.Members(settings.Checkout.FollowUpSegment); !ok {
+				log.Fatalf("❌ -checkout-followup-segment: no segment named %s", settings.Checkout.FollowUpSegment)
+			}
+			checkouts.Segments = segments
+			checkouts.FollowUpSegment = settings.Checkout.FollowUpSegment
+		}
+		var winBack *WinBack
+		if settings.Features.WinBack != "" {
+			set, err := LoadWinBack(settings.Features.WinBack)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			winBack, err = NewWinBack(bridge, sales, templates, optOuts, set, settings.Path("winback", "enrollments.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		var splits *RevenueSplits
+		if settings.Features.Splits != "" {
+			config, err := LoadSplitConfig(settings.Features.Splits)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			splits = NewRevenueSplits(bridge, sales, config, settings.Path("splits"))
+		}
+		usage, err := NewUsageTelemetry(bridge, sales, settings.Path("usage", "installs.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		trials, err := NewTrials(bridge, sales, settings.Path("trials", "trials.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		trials.Window = settings.Gumroad.TrialWindow
+		usage.Trials = trials
 
-	// Demo 1: AI request
-	fmt.Println("\n🤖 Demo 1: AI Request from Go")
-	context := map[string]interface{}{
-		"project":  "App Productizer",
-		"priority": "high",
-	}
-	bridge.RequestAI(
-		"Create a Go function that validates email addresses",
-		"Use standard library and include error handling",
-		context,
-	)
+		var portal *Portal
+		if settings.Portal.Listen != "" {
+			listener, err := listeners.Listen("portal", "tcp", settings.Portal.Listen)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			portal = NewPortal(bridge, sales, templates, settings.Portal.URL)
+			portal.OptOuts = optOuts
+			portal.Waitlists = waitlists
+			portal.Checkouts = checkouts
+			portal.Licenses = licenses
+			portal.Usage = usage
+			portal.Discord = discord
+			portal.Serve(listener)
+			broadcaster.UnsubscribeBase = portal.BaseURL
+		}
 
-	// Demo 2: Code translation
-	fmt.Println("\n🔄 Demo 2: Translate Go to Python")
-	goCode := `
-func calculateTotal(items []Item) float64 {
-	var total float64
-	for _, item := range items {
-		total += item.Price
-	}
-	return total
-}
-`
-	bridge.TranslateCode(goCode, "python")
-
-	// Demo 3: Function call to Python
-	fmt.Println("\n📞 Demo 3: Call Python function from Go")
-	args := []interface{}{goCode}
-	kwargs := map[string]interface{}{
-		"format":           "markdown",
-		"include_examples": true,
-	}
-	bridge.CallFunction("python", "generate_documentation", args, kwargs)
-
-	// Demo 4: Go-specific AI helpers
-	fmt.Println("\n⚡ Demo 4: Go-specific AI helpers")
-	bridge.GenerateGoStruct(
-		"A struct representing a user profile",
-		[]string{"ID", "Name", "Email", "CreatedAt"},
-	)
+		var entitlements *Entitlements
+		if settings.Features.Entitlements != "" {
+			config, err := LoadEntitlementConfig(settings.Features.Entitlements)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			entitlements, err = NewEntitlements(config, settings.Path("entitlements", "memberships.json"), bridge.clock)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			TrackEntitlements(bridge, entitlements)
+		}
+
+		var api *API
+		var live *LiveFeed
+		var launch *LaunchMode
+		if settings.API.Listen != "" {
+			listener, err := listeners.Listen("api", "tcp", settings.API.Listen)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			api = NewAPI(bridge, NewPricingAnalytics(sales))
+			api.Token = settings.Secrets.APIToken
+			api.LiveToken = settings.Secrets.LiveToken
+			api.Handle("/metrics/offer-codes", offers.Handler())
+			api.Handle("/metrics/waitlists", waitlists.Handler())
+			api.Handle("/metrics/checkouts", checkouts.Handler())
+			api.Handle("/metrics/usage", usage.Handler())
+			api.Handle("/metrics/trials", trials.Handler())
+			api.Handle("/metrics/profit", expenses.ProfitHandler())
+			salesAnalytics := NewSalesAnalytics(bridge, sales)
+			salesAnalytics.Location = expenses.Location
+			api.Handle("/sales/", salesAnalytics.Handler())
+			api.Handle("/expenses", expenses.Handler())
+			api.Handle("/expenses/", expenses.Handler())
+			if winBack != nil {
+				api.Handle("/metrics/winback", winBack.Handler())
+			}
+			if snapshots != nil {
+				api.Handle("/metrics/snapshots", snapshots.Handler())
+			}
+			if priceWatch != nil {
+				api.Handle("/metrics/price-watch", priceWatch.Handler())
+			}
+			if splits != nil {
+				api.Handle("/splits/statements", splits.Handler())
+				api.Handle("/splits/statements/", splits.Handler())
+			}
+			api.Handle("/customers", customers.Handler())
+			api.Handle("/customers/", customers.Handler())
+			if segments != nil {
+				api.Handle("/segments", segments.Handler())
+				api.Handle("/segments/", segments.Handler())
+			}
+			api.Handle("/seasonal-sales", seasonal.Handler())
+			api.Handle("/stream/subscribers", stream.Handler())
+			api.Handle("/calendar.ics", calendar.Handler())
+			api.Handle("/dead-letters", deadLetters.Handler())
+			api.Handle("/dead-letters/", deadLetters.Handler())
+			api.Handle("/licenses/verify", licenses.Handler())
+			api.Handle("/jobs", scheduler.Handler())
+			api.Handle("/jobs/", scheduler.Handler())
+			api.Handle("/graphql", NewGraphQLAPI(sales, customers, store).Handler())
+			live = NewLiveFeed(bridge, sales)
+			api.Handle("/live", live.Handler())
+			if settings.Features.Launch != "" {
+				config, err := LoadLaunchConfig(settings.Features.Launch)
+				if err != nil {
+					log.Fatalf("❌ %v", err)
+				}
+				launch, err = NewLaunchMode(bridge, sales, scheduler, live, push, config, settings.Path("launch"))
+				if err != nil {
+					log.Fatalf("❌ -launch: %v", err)
+				}
+				api.Handle("/launch", launch.Handler())
+				api.Handle("/launch/", launch.Handler())
+			}
+			if store != nil {
+				api.Handle("/messages", store.Handler())
+			}
+			if entitlements != nil {
+				api.Handle("/entitlements/", entitlements.Handler())
+			}
+			api.Serve(listener)
+		}
+
+		var webhook *GumroadWebhook
+		if settings.Webhook.Listen != "" {
+			listener, err := listeners.Listen("webhook", "tcp", settings.Webhook.Listen)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			webhook = NewGumroadWebhook(bridge)
+			webhook.SellerID = settings.Secrets.GumroadSellerID
+			webhook.Secret = settings.Secrets.WebhookSecret
+			webhook.Serve(listener)
+		}
+
+		var supportInbox *SupportInbox
+		if settings.Email.IMAP != "" {
+			supportInbox, err = NewSupportInbox(bridge, sales, settings.Email.IMAP, settings.Providers.IMAPUsername, settings.Providers.IMAPPassword)
+			if err != nil {
+				log.Fatalf("❌ -imap: %v", err)
+			}
+			supportInbox.Mailbox = settings.Email.IMAPMailbox
+		}
+
+		var siteFeed *SiteFeed
+		if settings.Features.SiteFeed != "" {
+			config, err := LoadSiteFeedConfig(settings.Features.SiteFeed)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			siteFeed, err = NewSiteFeed(config, sales, bridge.clock, settings.Providers.AWSAccessKey, settings.Providers.AWSSecretKey)
+			if err != nil {
+				log.Fatalf("❌ -site-feed: %v", err)
+			}
+		}
+
+		if settings.Features.Scripts != "" {
+			scripts, err := LoadScripts(settings.Features.Scripts)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			NewScriptHooks(bridge, scripts)
+		}
+		if settings.Features.Fraud != "" {
+			config, err := LoadFraudConfig(settings.Features.Fraud)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			fraud, err := NewFraudGuard(bridge, sales, config, settings.Path("fraud", "held.json"))
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			if api != nil {
+				api.Handle("/fraud/held", fraud.Handler())
+				api.Handle("/fraud/held/", fraud.Handler())
+			}
+		}
+		rules := &RuleSet{}
+		if settings.Features.Rules != "" {
+			rules, err = LoadRules(settings.Features.Rules)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		engine := NewRuleEngine(bridge, rules)
+		engine.Templates = templates
+		engine.Recommender = recommender
+		automations, err := NewAutomations(engine, aiServer, settings.Path("automations", "automations.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if api != nil {
+			api.Handle("/automations", automations.Handler())
+			api.Handle("/automations/", automations.Handler())
+		}
+		var plugins []*Plugin
+		if settings.Features.Plugins != "" {
+			config, err := LoadPluginConfig(settings.Features.Plugins)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			plugins, err = RegisterPlugins(bridge, config)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		if settings.Features.Transforms != "" {
+			transforms, err := LoadTransforms(settings.Features.Transforms)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			if _, err = NewTransforms(bridge, transforms); err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+		}
+		approvals, err := NewApprovals(bridge, settings.Path("approvals", "pending.json"))
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		approvals.DashboardURL = settings.API.DashboardURL
+		approvals.Notify = append(approvals.Notify, settings.Approvals.Notify...)
+		for _, name := range settings.Approvals.Sinks {
+			sink := bridge.Sink(name)
+			if sink == nil {
+				log.Fatalf("❌ -approve-sinks: no sink named %s", name)
+			}
+			approvals.HoldSink(sink)
+		}
+		RegisterRuleApprovals(approvals, engine)
+		var telegram *TelegramApprover
+		if settings.Approvals.TelegramChat != "" {
+			telegram, err = NewTelegramApprover(approvals, settings.Providers.TelegramToken, settings.Approvals.TelegramChat)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			telegram.Start()
+		}
+		if api != nil {
+			api.Handle("/approvals", approvals.Handler())
+			api.Handle("/approvals/", approvals.Handler())
+		}
+
+		RegisterBridgeJobs(scheduler, bridge)
+		RegisterRuleJobs(scheduler, engine)
+		RegisterRecommendationJobs(scheduler, bridge, recommender, templates)
+		RegisterExpenseJobs(scheduler, bridge, expenses, templates)
+		RegisterReviewJobs(scheduler, bridge, NewBusinessReview(expenses, aiServer), templates)
+		RegisterOfferCodeJobs(scheduler, offers)
+		RegisterWaitlistJobs(scheduler, waitlists)
+		RegisterCheckoutJobs(scheduler, checkouts, gumroad)
+		RegisterCustomerJobs(scheduler, customers)
+		if segments != nil {
+			RegisterSegmentJobs(scheduler, segments)
+		}
+		if launch != nil {
+			RegisterLaunchJobs(scheduler, launch)
+		}
+		if winBack != nil {
+			RegisterWinBackJobs(scheduler, winBack)
+		}
+		if splits != nil {
+			RegisterSplitJobs(scheduler, splits)
+		}
+		if calendarSync != nil {
+			RegisterCalendarJobs(scheduler, calendarSync)
+		}
+		if siteFeed != nil {
+			RegisterSiteFeedJobs(scheduler, siteFeed)
+		}
+		if supportInbox != nil {
+			RegisterSupportJobs(scheduler, supportInbox)
+		}
+		if snapshots != nil {
+			RegisterSnapshotJobs(scheduler, snapshots)
+		}
+		if priceWatch != nil {
+			RegisterPriceWatchJobs(scheduler, priceWatch)
+		}
+		err = scheduler.Start()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		err = scheduler.EnsureScheduled("offer_code_sweep", "offer_code_sweep", "*/5 * * * *", nil)
+		if err == nil && len(checkouts.FollowUps) > 0 {
+			err = scheduler.EnsureScheduled("checkout_followups", "checkout_followups", "*/10 * * * *", nil)
+		}
+		if err == nil {
+			err = scheduler.EnsureScheduled("checkout_catalog", "checkout_catalog", "0 * * * *", nil)
+		}
+		if err == nil {
+			err = scheduler.Trigger("checkout_catalog")
+		}
+		if err == nil && calendarSync != nil {
+			err = scheduler.EnsureScheduled("calendar_sync", "calendar_sync", "*/5 * * * *", nil)
+			if err == nil {
+				err = scheduler.Trigger("calendar_sync")
+			}
+		}
+		if err == nil && segments != nil {
+			err = scheduler.EnsureScheduled("evaluate_segments", "evaluate_segments", "*/15 * * * *", nil)
+		}
+		if err == nil && winBack != nil {
+			err = scheduler.EnsureScheduled("winback_emails", "winback_emails", "*/10 * * * *", nil)
+		}
+		if err == nil && splits != nil {
+			err = scheduler.EnsureScheduled("split_statements", "split_statements", "0 6 * * *", nil)
+		}
+		if err == nil && siteFeed != nil {
+			err = scheduler.EnsureScheduled("site_feed", "site_feed", siteFeed.Config.Schedule, nil)
+		}
+		if err == nil && supportInbox != nil {
+			err = scheduler.EnsureScheduled("support_inbox", "support_inbox", "* * * * *", nil)
+		}
+		if err == nil && snapshots != nil {
+			err = scheduler.EnsureScheduled("account_snapshot", "account_snapshot", settings.Gumroad.Snapshots, nil)
+		}
+		if err == nil && priceWatch != nil {
+			err = scheduler.EnsureScheduled("price_watch", "price_watch", priceWatch.Config.Schedule, nil)
+		}
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
 
-	bridge.OptimizeGoCode(`
-func slowFunction(arr []int) []int {
-	var result []int
-	for i := 0; i < len(arr); i++ {
-		for j := 0; j < len(arr); j++ {
-			if arr[i] == arr[j] && i != j {
-				result = append(result, arr[i])
+		err = Serve(bridge, listeners)
+		for _, plugin := range plugins {
+			plugin.Close()
+		}
+		if flushErr := checkouts.Flush(); flushErr != nil {
+			log.Printf("❌ Error saving checkout visits: %v", flushErr)
+		}
+		if flushErr := usage.Flush(); flushErr != nil {
+			log.Printf("❌ Error saving usage installs: %v", flushErr)
+		}
+		if flushErr := trials.Flush(); flushErr != nil {
+			log.Printf("❌ Error saving trials: %v", flushErr)
+		}
+		sweeper.Close()
+		deadLetters.Close()
+		if enrichment != nil {
+			enrichment.Close()
+		}
+		if sms != nil {
+			sms.Close()
+		}
+		if telegram != nil {
+			telegram.Close()
+		}
+		if chat != nil {
+			chat.Close()
+		}
+		stream.Close()
+		pending.Close()
+		bridge.Sequences().Close()
+		bridge.Receipts().Close()
+		scheduler.Stop()
+		if portal != nil {
+			portal.Close(30 * time.Second)
+		}
+		if api != nil {
+			live.Close()
+			api.Close(30 * time.Second)
+		}
+		if webhook != nil {
+			webhook.Close(30 * time.Second)
+		}
+		if sheets != nil {
+			if closeErr := sheets.Close(); closeErr != nil {
+				log.Printf("❌ %v", closeErr)
+			}
+		}
+		if store != nil {
+			store.Close()
+		}
+		if traces != nil {
+			if closeErr := traces.Close(); closeErr != nil {
+				log.Printf("❌ %v", closeErr)
 			}
 		}
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
 	}
-	return result
-}
-`)
 
-	fmt.Println("\n✅ Go Bridge Demo Complete")
-	fmt.Println("📁 Check bridge_messages/ directory for message files")
-}
+	scenario, err := LoadScenario(*scenarioPath)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
 
-func main() {
-	demoGoBridge()
-}
\ No newline at end of file
+	fmt.Printf("🌍 GO UNIVERSAL BRIDGE: %s\n", scenario.Name)
+	fmt.Println(strings.Repeat("=", 50))
+
+	bridge := NewGoBridge(settings.BridgeURL, WithTransport(settings.NewFileTransport(nil)))
+	result := RunScenario(bridge, scenario)
+	result.Print()
+
+	if !result.Passed() {
+		os.Exit(1)
+	}
+}
@@ -9,6 +9,24 @@ const path = require('path');
 const WebSocket = require('ws');
 const http = require('http');
 const crypto = require('crypto');
+const zlib = require('zlib');
+
+/**
+ * Serialize a payload the way every bridge checksums it: compact JSON with
+ * keys sorted at every level
+ */
+function canonicalJSON(value) {
+    if (Array.isArray(value)) {
+        return `[${value.map(item => canonicalJSON(item === undefined ? null : item)).join(',')}]`;
+    }
+    if (value !== null && typeof value === 'object') {
+        const entries = Object.keys(value).sort()
+            .filter(key => value[key] !== undefined)
+            .map(key => `${JSON.stringify(key)}:${canonicalJSON(value[key])}`);
+        return `{${entries.join(',')}}`;
+    }
+    return JSON.stringify(value);
+}
 
 class UniversalMessage {
     constructor(messageType, sourceLanguage, targetLanguage = 'universal', payload = {}, responseChannel = 'websocket') {
@@ -19,16 +37,21 @@ class UniversalMessage {
         this.targetLanguage = targetLanguage;
         this.payload = payload;
         this.responseChannel = responseChannel;
+        this.headers = {}; // hop metadata such as traceparent; not checksummed
+        this.expiresAt = null; // RFC 3339; not handled after it; not checksummed
+        this.priority = null; // high, normal or low; the type's own when null; not checksummed
+        this.idempotencyKey = null; // copies with the same key are handled once; not checksummed
+        this.deliverAt = null; // RFC 3339; not handled before it; not checksummed
         this.checksum = this.calculateChecksum();
     }
 
     calculateChecksum() {
-        const content = `${this.id}${this.timestamp}${this.messageType}${JSON.stringify(this.payload, Object.keys(this.payload).sort())}`;
+        const content = `${this.id}${this.timestamp}${this.messageType}${canonicalJSON(this.payload)}`;
         return crypto.createHash('md5').update(content).digest('hex');
     }
 
     toJSON() {
-        return {
+        const data = {
             id: this.id,
             timestamp: this.timestamp,
             message_type: this.messageType,
@@ -38,6 +61,41 @@ class UniversalMessage {
             response_channel: this.responseChannel,
             checksum: this.checksum
         };
+        if (Object.keys(this.headers).length > 0) {
+            data.headers = this.headers;
+        }
+        if (this.expiresAt) {
+            data.expires_at = this.expiresAt;
+        }
+        if (this.priority) {
+            data.priority = this.priority;
+        }
+        if (this.idempotencyKey) {
+            data.idempotency_key = this.idempotencyKey;
+        }
+        if (this.deliverAt) {
+            data.deliver_at = this.deliverAt;
+        }
+        return data;
+    }
+
+    // Whether expires_at has passed, so the message must not be handled
+    expired() {
+        return this.expiresAt !== null && Date.now() >= Date.parse(this.expiresAt);
+    }
+
+    // Whether deliver_at has come, so the message may be handled
+    due() {
+        return this.deliverAt === null || Date.now() >= Date.parse(this.deliverAt);
+    }
+
+    // Carry the request's trace context, so a reply joins its trace
+    continueTrace(request) {
+        for (const header of ['traceparent', 'tracestate']) {
+            if (request.headers[header]) {
+                this.headers[header] = request.headers[header];
+            }
+        }
     }
 
     toString() {
@@ -46,17 +104,30 @@ class UniversalMessage {
 
     static fromJSON(jsonStr) {
         const data = JSON.parse(jsonStr);
+        let payload = data.payload;
+        if (data.content_encoding === 'gzip') {
+            // The checksum covers the payload as it was before compression
+            payload = JSON.parse(zlib.gunzipSync(Buffer.from(payload.data, 'base64')).toString('utf8'));
+        } else if (data.content_encoding) {
+            throw new Error(`Unsupported content encoding ${data.content_encoding}`);
+        }
         const msg = new UniversalMessage(
             data.message_type,
             data.source_language,
             data.target_language,
-            data.payload,
+            payload,
             data.response_channel
         );
         
         msg.id = data.id;
         msg.timestamp = data.timestamp;
-        
+        msg.headers = data.headers || {};
+        msg.expiresAt = data.expires_at || null;
+        msg.priority = data.priority || null;
+        msg.idempotencyKey = data.idempotency_key || null;
+        msg.deliverAt = data.deliver_at || null;
+        msg.checksum = msg.calculateChecksum();
+
         // Verify checksum
         if (msg.checksum !== data.checksum) {
             throw new Error('Message checksum mismatch - data may be corrupted');
@@ -119,6 +190,18 @@ class JavaScriptBridge {
                         
                         try {
                             const message = UniversalMessage.fromJSON(content);
+                            if (message.expired()) {
+                                // Stale, such as an AI request left while this bridge was down
+                                console.log(`⌛ Skipping ${file}, which expired at ${message.expiresAt}`);
+                                const expiredDir = path.join(incomingDir, 'expired');
+                                await fs.mkdir(expiredDir, { recursive: true });
+                                await fs.rename(filePath, path.join(expiredDir, file));
+                                continue;
+                            }
+                            if (!message.due()) {
+                                // Left in the inbox, which keeps it across restarts, until due
+                                continue;
+                            }
                             await this.handleIncomingMessage(message);
                             
                             // Move to processed
@@ -146,10 +229,32 @@ class JavaScriptBridge {
                 await handler(message);
             } catch (error) {
                 console.error(`❌ Error handling message ${message.id}:`, error);
+                await this.sendReceipt(message, error);
+                return;
             }
         } else {
             console.log(`⚠️ No handler for message type: ${message.messageType}`);
         }
+        await this.sendReceipt(message);
+    }
+
+    // Answers a message whose ack header is "requested": an ack once
+    // handled, a nack with the error once it failed
+    async sendReceipt(message, error = null) {
+        if (message.headers.ack !== 'requested' || message.messageType === 'ack' || message.messageType === 'nack') {
+            return;
+        }
+        const payload = { original_message_id: message.id };
+        if (error) {
+            payload.error = String(error.message || error);
+        }
+        const receipt = new UniversalMessage(error ? 'nack' : 'ack', 'javascript', message.sourceLanguage, payload, message.responseChannel);
+        receipt.continueTrace(message);
+        try {
+            await this.sendMessage(receipt);
+        } catch (sendError) {
+            console.error(`❌ Error sending receipt for ${message.id}:`, sendError);
+        }
     }
 
     async sendMessage(message) {
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Broadcast statuses
+const (
+	BroadcastSending  = "sending"
+	BroadcastFinished = "finished"
+)
+
+// Broadcast is one product update emailed to past buyers. Queued counts
+// emails handed to the email sink; Delivered and Bounced come from delivery
+// events reported back by the email provider.
+type Broadcast struct {
+	ID          string    `json:"id"`
+	Product     string    `json:"product"`
+	Version     string    `json:"version"`
+	Changelog   string    `json:"changelog"`
+	DownloadURL string    `json:"download_url,omitempty"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	Recipients  int       `json:"recipients"`
+	OptedOut    int       `json:"opted_out"`
+	Queued      int       `json:"queued"`
+	Failed      int       `json:"failed"`
+	Delivered   int       `json:"delivered"`
+	Bounced     int       `json:"bounced"`
+}
+
+// OptOutList is the persisted set of addresses that must not receive
+// broadcasts, either because the buyer unsubscribed or because mail bounced
+type OptOutList struct {
+	path   string
+	mu     sync.Mutex
+	state  optOutState
+	secret []byte
+}
+
+type optOutState struct {
+	Secret string            `json:"secret"`
+	Emails map[string]string `json:"emails"` // email → reason
+}
+
+// NewOptOutList loads the list at path, creating its signing secret on first use
+func NewOptOutList(path string) (*OptOutList, error) {
+	list := &OptOutList{path: path, state: optOutState{Emails: make(map[string]string)}}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read opt-outs: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &list.state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse opt-outs %s: %v", path, err)
+		}
+		if list.state.Emails == nil {
+			list.state.Emails = make(map[string]string)
+		}
+	}
+
+	if list.state.Secret == "" {
+		list.state.Secret = randomToken()
+		err = list.saveLocked()
+		if err != nil {
+			return nil, err
+		}
+	}
+	list.secret, _ = hex.DecodeString(list.state.Secret)
+	return list, nil
+}
+
+// Add opts the address out of future broadcasts
+func (ol *OptOutList) Add(email, reason string) error {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	email = NormalizeEmail(email)
+	if _, exists := ol.state.Emails[email]; exists {
+		return nil
+	}
+	ol.state.Emails[email] = reason
+	fmt.Printf("🔕 Opted out %s (%s)\n", email, reason)
+	return ol.saveLocked()
+}
+
+// Contains reports whether the address has opted out
+func (ol *OptOutList) Contains(email string) bool {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	_, exists := ol.state.Emails[NormalizeEmail(email)]
+	return exists
+}
+
+// Token signs an address so unsubscribe links cannot be forged for others
+func (ol *OptOutList) Token(email string) string {
+	mac := hmac.New(sha256.New, ol.secret)
+	mac.Write([]byte(NormalizeEmail(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidToken reports whether token was issued for the address
+func (ol *OptOutList) ValidToken(email, token string) bool {
+	return hmac.Equal([]byte(ol.Token(email)), []byte(token))
+}
+
+func (ol *OptOutList) saveLocked() error {
+	return writeJSONFile(ol.path, ol.state)
+}
+
+// Broadcaster emails product updates to every past buyer of a product
+type Broadcaster struct {
+	// UnsubscribeBase is the portal URL unsubscribe links point at
+	UnsubscribeBase string
+
+	bridge    *GoBridge
+	sales     *SalesStore
+	templates *TemplateStore
+	optOuts   *OptOutList
+	path      string
+
+	mu         sync.Mutex
+	broadcasts map[string]*Broadcast
+}
+
+// NewBroadcaster creates a broadcaster persisting progress to path. It
+// listens for data_sync messages with resource_name product_update to start
+// broadcasts, and email_delivery to track deliveries and bounces.
+func NewBroadcaster(gb *GoBridge, sales *SalesStore, templates *TemplateStore, optOuts *OptOutList, path string) (*Broadcaster, error) {
+	b := &Broadcaster{
+		bridge:     gb,
+		sales:      sales,
+		templates:  templates,
+		optOuts:    optOuts,
+		path:       path,
+		broadcasts: make(map[string]*Broadcast),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read broadcasts: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &b.broadcasts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse broadcasts %s: %v", path, err)
+		}
+	}
+
+	gb.OnReceive(b.handleMessage)
+	return b, nil
+}
+
+func (b *Broadcaster) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+
+	payload := message.Payload
+	switch stringArg(payload, "resource_name") {
+	case "product_update":
+		_, err := b.Start(stringArg(payload, "product_id"), stringArg(payload, "version"), stringArg(payload, "changelog"), stringArg(payload, "download_url"))
+		if err != nil {
+			log.Printf("❌ Error starting broadcast from %s: %v", message.ID, err)
+		}
+	case "email_delivery":
+		b.recordDelivery(stringArg(payload, "broadcast_id"), stringArg(payload, "to"), stringArg(payload, "status"))
+	case "unsubscribe":
+		b.optOuts.Add(stringArg(payload, "email"), "unsubscribed")
+	}
+}
+
+// Start begins emailing the update to the product's buyers in the background
+// and returns the broadcast so its progress can be followed
+func (b *Broadcaster) Start(product, version, changelog, downloadURL string) (Broadcast, error) {
+	if product == "" || version == "" {
+		return Broadcast{}, fmt.Errorf("broadcast needs a product and version")
+	}
+	if b.bridge.Sink("email") == nil {
+		return Broadcast{}, fmt.Errorf("no email sink registered")
+	}
+
+	broadcast := &Broadcast{
+		ID:          b.bridge.ids.NewID(),
+		Product:     product,
+		Version:     version,
+		Changelog:   changelog,
+		DownloadURL: downloadURL,
+		Status:      BroadcastSending,
+		CreatedAt:   b.bridge.clock.Now(),
+	}
+
+	recipients := b.recipients(product)
+	broadcast.Recipients = len(recipients)
+
+	b.mu.Lock()
+	b.broadcasts[broadcast.ID] = broadcast
+	snapshot := *broadcast
+	b.saveLocked()
+	b.mu.Unlock()
+
+	fmt.Printf("📣 Broadcasting %s %s to %d buyers\n", product, version, len(recipients))
+	go b.send(broadcast, recipients)
+	return snapshot, nil
+}
+
+// recipients returns each buyer's latest non-refunded sale of the product
+func (b *Broadcaster) recipients(product string) []Sale {
+	latest := make(map[string]Sale)
+	var order []string
+
+	sales := b.sales.Sales(func(sale Sale) bool {
+		return (sale.Product == product || sale.Permalink == product) && sale.Email != "" && !sale.Refunded
+	})
+	for _, sale := range sales {
+		if _, seen := latest[sale.Email]; !seen {
+			order = append(order, sale.Email)
+		}
+		latest[sale.Email] = sale
+	}
+
+	recipients := make([]Sale, 0, len(order))
+	for _, email := range order {
+		recipients = append(recipients, latest[email])
+	}
+	return recipients
+}
+
+// send renders and queues one email per recipient, waiting whenever the
+// email sink's queue is full so large lists are paced rather than dropped
+func (b *Broadcaster) send(broadcast *Broadcast, recipients []Sale) {
+	sink := b.bridge.Sink("email")
+
+	for i, sale := range recipients {
+		email := sale.Email
+		if b.optOuts.Contains(email) {
+			b.progress(broadcast, false, func(bc *Broadcast) { bc.OptedOut++ })
+			continue
+		}
+
+		message, err := b.render(broadcast, sale)
+		if err == nil {
+			err = sink.Enqueue(message)
+			for err == ErrSinkFull {
+				// Wait for the sink to catch up instead of dropping the email
+				<-b.bridge.clock.After(time.Second)
+				err = sink.Enqueue(message)
+			}
+		}
+
+		// Save progress every 100 recipients rather than after each one
+		checkpoint := (i+1)%100 == 0
+		if err != nil {
+			log.Printf("❌ Broadcast %s to %s failed: %v", broadcast.ID, email, err)
+			b.progress(broadcast, checkpoint, func(bc *Broadcast) { bc.Failed++ })
+			continue
+		}
+		b.progress(broadcast, checkpoint, func(bc *Broadcast) { bc.Queued++ })
+
+		if checkpoint {
+			fmt.Printf("📣 Broadcast %s: %d/%d queued\n", broadcast.ID, i+1, len(recipients))
+		}
+	}
+
+	b.progress(broadcast, true, func(bc *Broadcast) { bc.Status = BroadcastFinished })
+	fmt.Printf("✅ Broadcast %s queued for all %d buyers\n", broadcast.ID, len(recipients))
+}
+
+// render builds the product_update email for one buyer, in their locale and
+// with the product's template override if it has one
+func (b *Broadcaster) render(broadcast *Broadcast, sale Sale) (*UniversalMessage, error) {
+	email := sale.Email
+	downloadURL := broadcast.DownloadURL
+	if downloadURL == "" {
+		downloadURL = stringArg(sale.Fields, "download_url")
+	}
+
+	data := mergePayload(sale.TemplateData(), map[string]interface{}{
+		"version":      broadcast.Version,
+		"changelog":    broadcast.Changelog,
+		"download_url": downloadURL,
+	})
+	unsubscribe := ""
+	if b.UnsubscribeBase != "" {
+		unsubscribe = b.UnsubscribeBase + "/unsubscribe?email=" + url.QueryEscape(email) + "&token=" + b.optOuts.Token(email)
+		data["unsubscribe_url"] = unsubscribe
+	}
+
+	locale := DetectLocale(sale.Fields)
+	text, err := b.templates.RenderLocale("product_update", broadcast.Product, locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]interface{}{
+		"to":           email,
+		"text":         text,
+		"locale":       locale,
+		"broadcast_id": broadcast.ID,
+	}
+	if unsubscribe != "" {
+		// For the List-Unsubscribe header as well as the link in the text
+		payload["unsubscribe_url"] = unsubscribe
+	}
+	return b.bridge.NewMessage(DataSync, "go", payload, FileSystem), nil
+}
+
+// recordDelivery applies a delivery event; bounced addresses are opted out
+// so later broadcasts skip them
+func (b *Broadcaster) recordDelivery(id, email, status string) {
+	b.mu.Lock()
+	broadcast, ok := b.broadcasts[id]
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	switch status {
+	case "delivered":
+		b.progress(broadcast, true, func(bc *Broadcast) { bc.Delivered++ })
+	case "bounced":
+		b.progress(broadcast, true, func(bc *Broadcast) { bc.Bounced++ })
+		b.optOuts.Add(email, "bounced")
+	}
+}
+
+// progress applies a change to the broadcast, saving every broadcast if asked
+func (b *Broadcaster) progress(broadcast *Broadcast, save bool, change func(*Broadcast)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	change(broadcast)
+	if !save {
+		return
+	}
+	err := b.saveLocked()
+	if err != nil {
+		log.Printf("❌ Error saving broadcasts: %v", err)
+	}
+}
+
+// Broadcasts returns every broadcast, newest first
+func (b *Broadcaster) Broadcasts() []Broadcast {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]Broadcast, 0, len(b.broadcasts))
+	for _, broadcast := range b.broadcasts {
+		list = append(list, *broadcast)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+func (b *Broadcaster) saveLocked() error {
+	return writeJSONFile(b.path, b.broadcasts)
+}
+
+// writeJSONFile writes value as indented JSON via a temporary file, so a
+// crash mid-write never leaves a truncated file behind
+func writeJSONFile(path string, value interface{}) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BundleCatalog lists the products inside each bundle, loaded from YAML:
+//
+//	bundles:
+//	  creator-kit:              # bundle product ID or permalink
+//	    components:
+//	      - product: icons
+//	        product_name: Icon Pack
+//	        weight: 1            # share of the bundle price; defaults to 1
+//	        license: true        # the component is licensed on its own
+//	      - product: figma-kit
+//	        product_name: Figma UI Kit
+//	        weight: 2
+//	        download_url: https://example.com/figma-kit
+type BundleCatalog struct {
+	Bundles map[string]struct {
+		Components []BundleComponent `yaml:"components"`
+	} `yaml:"bundles"`
+}
+
+// BundleComponent is one product sold as part of a bundle
+type BundleComponent struct {
+	Product     string  `yaml:"product"`
+	ProductName string  `yaml:"product_name"`
+	Weight      float64 `yaml:"weight"`
+	License     bool    `yaml:"license"`
+	DownloadURL string  `yaml:"download_url"`
+}
+
+// LoadBundleCatalog reads and checks the bundle definitions
+func LoadBundleCatalog(path string) (*BundleCatalog, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundles: %v", err)
+	}
+
+	var catalog BundleCatalog
+	err = yaml.Unmarshal(content, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bundles %s: %v", path, err)
+	}
+	for name, bundle := range catalog.Bundles {
+		if len(bundle.Components) == 0 {
+			return nil, fmt.Errorf("invalid bundles %s: bundle %s has no components", path, name)
+		}
+		for _, component := range bundle.Components {
+			if component.Product == "" || component.Weight < 0 {
+				return nil, fmt.Errorf("invalid bundles %s: bundle %s needs a product and a non-negative weight for each component", path, name)
+			}
+		}
+	}
+	return &catalog, nil
+}
+
+// Decompose turns a bundle sale into one sale per component. Each component
+// sale copies the buyer, currency, refund and gift fields, gets a sale_id of
+// "<bundle sale_id>/<product>" so replays and refunds update it in place, and
+// carries its weighted share of the price, allocated so the shares add up to
+// the bundle price to the cent. Non-bundle sales return nil.
+func (bc *BundleCatalog) Decompose(sale map[string]interface{}) []map[string]interface{} {
+	bundle, ok := bc.Bundles[productKey(sale)]
+	if !ok {
+		bundle, ok = bc.Bundles[stringArg(sale, "product_permalink")]
+	}
+	saleID := stringArg(sale, "sale_id")
+	if !ok || saleID == "" {
+		return nil
+	}
+
+	weights := make([]float64, len(bundle.Components))
+	for i, component := range bundle.Components {
+		weights[i] = component.Weight
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+	var shares []Money
+	if value, ok := sale["price"]; ok {
+		// An unreadable price is left for RecordSales to reject
+		currency, _ := parseCurrency(stringArg(sale, "currency"))
+		if price, err := moneyArg(value, currency); err == nil {
+			shares, _ = price.Allocate(weights)
+		}
+	}
+
+	components := make([]map[string]interface{}, 0, len(bundle.Components))
+	for i, component := range bundle.Components {
+		item := mergePayload(sale, map[string]interface{}{
+			"sale_id":           saleID + "/" + component.Product,
+			"product_id":        component.Product,
+			"product_permalink": component.Product,
+			"product_name":      component.ProductName,
+			"bundle_sale_id":    saleID,
+			"bundle_product":    productKey(sale),
+			"license_required":  component.License,
+		})
+		if component.ProductName == "" {
+			item["product_name"] = component.Product
+		}
+		if component.DownloadURL != "" {
+			item["download_url"] = component.DownloadURL
+		}
+		if shares != nil {
+			// As a decimal string, so the share reads back to the cent
+			item["price"] = shares[i].Decimal()
+			item["price_cents"] = shares[i].Amount
+		}
+		// The bundle's license, offer code and subscription belong to the
+		// bundle sale; copying them would count each one per component
+		for _, field := range []string{"license_key", "license_uses", "offer_code", "subscription_id", "bundle_components"} {
+			delete(item, field)
+		}
+		components = append(components, item)
+	}
+	return components
+}
+
+// isBundleSale reports whether a sale is a bundle that was split into components
+func isBundleSale(sale map[string]interface{}) bool {
+	_, ok := sale["bundle_components"]
+	return ok
+}
+
+// DecomposeBundles splits every received bundle sale into component sales
+// and handles each one as if it had been received itself, so the sales
+// store, rules, license issuance and delivery all see individual products.
+// The bundle sale continues on with bundle_components listing their IDs;
+// per-product reports skip it so revenue is not counted twice. Register it
+// before the other sale hooks.
+func DecomposeBundles(gb *GoBridge, catalog *BundleCatalog) {
+	gb.OnReceive(func(message *UniversalMessage) {
+		if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+			return
+		}
+		components := catalog.Decompose(message.Payload)
+		if components == nil {
+			return
+		}
+
+		ids := make([]interface{}, len(components))
+		for i, component := range components {
+			ids[i] = stringArg(component, "sale_id")
+		}
+		message.Payload["bundle_components"] = ids
+		fmt.Printf("📦 Bundle %s split into %d products\n", stringArg(message.Payload, "sale_id"), len(components))
+
+		for _, component := range components {
+			err := gb.handleIncomingMessage(gb.NewMessage(DataSync, message.TargetLanguage, component, message.ResponseChannel))
+			if err != nil {
+				fmt.Printf("⚠️ Bundle component %s failed: %v\n", stringArg(component, "sale_id"), err)
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBundles = `bundles:
+  creator-kit:
+    components:
+      - product: icons
+        product_name: Icon Pack
+        license: true
+      - product: figma-kit
+        weight: 2
+        download_url: https://example.com/figma-kit
+  trio:
+    components:
+      - product: a
+      - product: b
+        weight: 0
+      - product: c
+`
+
+func loadTestBundles(t *testing.T, content string) (*BundleCatalog, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bundles.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadBundleCatalog(path)
+}
+
+func TestLoadBundleCatalogRejectsInvalidBundles(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"no components", "bundles:\n  kit: {components: []}\n", "bundle kit has no components"},
+		{"component without a product", "bundles:\n  kit:\n    components: [{product_name: Icons}]\n", "needs a product"},
+		{"negative weight", "bundles:\n  kit:\n    components: [{product: icons, weight: -1}]\n", "non-negative weight"},
+		{"not YAML", "bundles: [\n", "failed to parse bundles"},
+	}
+	for _, tt := range tests {
+		if _, err := loadTestBundles(t, tt.yaml); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: LoadBundleCatalog = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBundleCatalogDecompose(t *testing.T) {
+	catalog, err := loadTestBundles(t, testBundles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		sale       map[string]interface{}
+		wantIDs    []string
+		wantPrices []string
+	}{
+		{"by permalink, weighted", map[string]interface{}{"sale_id": "s1", "product_id": "p9", "product_permalink": "creator-kit", "price": "10", "currency": "usd"},
+			[]string{"s1/icons", "s1/figma-kit"}, []string{"3.33", "6.67"}},
+		{"by product ID, even split to the cent", map[string]interface{}{"sale_id": "s2", "product_id": "trio", "price": 10, "currency": "usd"},
+			[]string{"s2/a", "s2/b", "s2/c"}, []string{"3.34", "3.33", "3.33"}},
+		{"zero-decimal currency", map[string]interface{}{"sale_id": "s3", "product_id": "trio", "price": "1000", "currency": "jpy"},
+			[]string{"s3/a", "s3/b", "s3/c"}, []string{"334", "333", "333"}},
+		{"without a price", map[string]interface{}{"sale_id": "s4", "product_id": "trio"},
+			[]string{"s4/a", "s4/b", "s4/c"}, nil},
+		{"not a bundle", map[string]interface{}{"sale_id": "s5", "product_id": "ebook", "price": "10"}, nil, nil},
+		{"no sale_id", map[string]interface{}{"product_id": "trio", "price": "10"}, nil, nil},
+	}
+	for _, tt := range tests {
+		components := catalog.Decompose(tt.sale)
+		if len(components) != len(tt.wantIDs) {
+			t.Errorf("%s: %d components, want %d", tt.name, len(components), len(tt.wantIDs))
+			continue
+		}
+		for i, component := range components {
+			if id := stringArg(component, "sale_id"); id != tt.wantIDs[i] {
+				t.Errorf("%s: component %d sale_id %q, want %q", tt.name, i, id, tt.wantIDs[i])
+			}
+			_, priced := component["price"]
+			if tt.wantPrices == nil && priced {
+				t.Errorf("%s: component %d priced at %v without a bundle price", tt.name, i, component["price"])
+			}
+			if tt.wantPrices != nil && component["price"] != tt.wantPrices[i] {
+				t.Errorf("%s: component %d price %v, want %s", tt.name, i, component["price"], tt.wantPrices[i])
+			}
+		}
+	}
+}
+
+func TestBundleComponentFields(t *testing.T) {
+	catalog, err := loadTestBundles(t, testBundles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	components := catalog.Decompose(map[string]interface{}{
+		"sale_id": "s1", "product_permalink": "creator-kit", "price": "10", "currency": "usd",
+		"email": "ada@example.com", "refunded": false, "gift": true,
+		"license_key": "KEY", "offer_code": "LAUNCH", "subscription_id": "sub1",
+	})
+	icons, figma := components[0], components[1]
+	if icons["email"] != "ada@example.com" || icons["gift"] != true || icons["refunded"] != false {
+		t.Errorf("icons %v, want the buyer, gift and refund fields copied", icons)
+	}
+	if icons["product_id"] != "icons" || icons["product_name"] != "Icon Pack" || icons["license_required"] != true {
+		t.Errorf("icons %v, want the component product", icons)
+	}
+	if figma["product_name"] != "figma-kit" || figma["download_url"] != "https://example.com/figma-kit" || figma["license_required"] != false {
+		t.Errorf("figma-kit %v, want the product as its name and its download", figma)
+	}
+	if icons["bundle_sale_id"] != "s1" || icons["bundle_product"] != "creator-kit" || icons["price_cents"] != int64(333) {
+		t.Errorf("icons %v, want the bundle sale and its share in cents", icons)
+	}
+	for _, field := range []string{"license_key", "offer_code", "subscription_id"} {
+		if _, ok := icons[field]; ok {
+			t.Errorf("icons kept the bundle's %s", field)
+		}
+	}
+}
+
+func TestDecomposeBundlesRecordsComponents(t *testing.T) {
+	catalog, err := loadTestBundles(t, testBundles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	DecomposeBundles(gb, catalog)
+	sales, err := NewSalesStore(filepath.Join(t.TempDir(), "sales.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+
+	if err := transport.Inject(newUniversalMessage(clock, NewSequentialIDs("gumroad"), SaleEvent, "gumroad", "go", map[string]interface{}{
+		"resource_name": "sale", "sale_id": "s1", "product_permalink": "creator-kit", "email": "ada@example.com", "price": "10", "currency": "usd",
+	}, HTTP)); err != nil {
+		t.Fatal(err)
+	}
+	bundle, ok := sales.Sale("s1")
+	if !ok || strings.Join(bundle.BundleComponents, ",") != "s1/icons,s1/figma-kit" {
+		t.Fatalf("bundle sale %+v, want its components listed", bundle)
+	}
+	for id, cents := range map[string]int64{"s1/icons": 333, "s1/figma-kit": 667} {
+		sale, ok := sales.Sale(id)
+		if !ok || sale.BundleSaleID != "s1" || sale.Price.Amount != cents || sale.Email != "ada@example.com" {
+			t.Errorf("component %s %+v, want %d cents from bundle s1", id, sale, cents)
+		}
+	}
+}
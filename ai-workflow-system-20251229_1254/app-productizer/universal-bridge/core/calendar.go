@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	calendarAPIURL = "https://www.googleapis.com/calendar/v3"
+	calendarScope  = "https://www.googleapis.com/auth/calendar"
+	calendarDomain = "universal-bridge"
+)
+
+// CalendarEvent is one entry on the seller's calendar. AllDay events use
+// only the dates of Start and End, End being the day after the last day.
+type CalendarEvent struct {
+	UID         string    `json:"uid"`
+	Kind        string    `json:"kind"` // launch, seasonal_sale or payout
+	Summary     string    `json:"summary"`
+	Description string    `json:"description,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AllDay      bool      `json:"all_day"`
+	Tentative   bool      `json:"tentative"`
+}
+
+// Calendar lists scheduled launches, seasonal sales and expected Gumroad
+// payouts as calendar events. The events are built from the scheduler and
+// the seasonal sales each time they are asked for, so a rescheduled,
+// paused or removed launch or sale shows up on the next fetch or sync.
+type Calendar struct {
+	// PayoutDay is the weekday Gumroad pays out on
+	PayoutDay time.Weekday
+	// PayoutWeeks is how many upcoming payouts are listed
+	PayoutWeeks int
+	// LaunchLength is how long a launch event lasts
+	LaunchLength time.Duration
+
+	scheduler *Scheduler
+	seasonal  *SeasonalSales
+	clock     Clock
+}
+
+// NewCalendar creates a calendar of the scheduler's launches and the
+// seasonal sales; seasonal may be nil
+func NewCalendar(gb *GoBridge, scheduler *Scheduler, seasonal *SeasonalSales) *Calendar {
+	return &Calendar{
+		PayoutDay:    time.Friday,
+		PayoutWeeks:  8,
+		LaunchLength: time.Hour,
+		scheduler:    scheduler,
+		seasonal:     seasonal,
+		clock:        gb.clock,
+	}
+}
+
+// parseWeekday reads a weekday name such as "friday" or "fri"
+func parseWeekday(name string) (time.Weekday, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || (len(name) >= 3 && strings.HasPrefix(full, name)) {
+			return day, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown weekday %q", name)
+}
+
+// Events returns every event, soonest first
+func (c *Calendar) Events() []CalendarEvent {
+	var events []CalendarEvent
+	events = append(events, c.launches()...)
+	events = append(events, c.seasonalSales()...)
+	events = append(events, c.payouts()...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events
+}
+
+// launches lists the one-shot launch_waitlist jobs
+func (c *Calendar) launches() []CalendarEvent {
+	var events []CalendarEvent
+	for _, job := range c.scheduler.List() {
+		if job.Handler != "launch_waitlist" || job.RunAt == nil {
+			continue
+		}
+		launch, err := launchFromArgs(job.Args)
+		if err != nil {
+			continue
+		}
+		description := fmt.Sprintf("The %s waitlist gets %s off for %s", launch.ProductName, formatDiscount(launch.AmountOff, launch.OfferType), launch.ExpiresIn)
+		if launch.URL != "" {
+			description += "\n" + launch.URL
+		}
+		summary := "Launch: " + launch.ProductName
+		if job.Paused {
+			summary += " (paused)"
+		}
+		events = append(events, CalendarEvent{
+			UID:         "launch-" + job.ID + "@" + calendarDomain,
+			Kind:        "launch",
+			Summary:     summary,
+			Description: description,
+			Start:       *job.RunAt,
+			End:         job.RunAt.Add(c.LaunchLength),
+			Tentative:   job.Paused,
+		})
+	}
+	return events
+}
+
+// seasonalSales lists each seasonal sale from its start to its end
+func (c *Calendar) seasonalSales() []CalendarEvent {
+	if c.seasonal == nil {
+		return nil
+	}
+	var events []CalendarEvent
+	for _, sale := range c.seasonal.List() {
+		description := fmt.Sprintf("Code %s: %s off %s", sale.Code, formatDiscount(sale.AmountOff, sale.OfferType), strings.Join(sale.Products, ", "))
+		events = append(events, CalendarEvent{
+			UID:         "seasonal-" + sale.ID + "@" + calendarDomain,
+			Kind:        "seasonal_sale",
+			Summary:     "Sale: " + sale.Name,
+			Description: description,
+			Start:       sale.StartsAt,
+			End:         sale.EndsAt,
+		})
+	}
+	return events
+}
+
+// payouts lists the next PayoutWeeks payout days, starting today if today
+// is one
+func (c *Calendar) payouts() []CalendarEvent {
+	now := c.clock.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	day = day.AddDate(0, 0, (int(c.PayoutDay)-int(day.Weekday())+7)%7)
+
+	events := make([]CalendarEvent, 0, c.PayoutWeeks)
+	for i := 0; i < c.PayoutWeeks; i++ {
+		events = append(events, CalendarEvent{
+			UID:         "payout-" + day.Format("20060102") + "@" + calendarDomain,
+			Kind:        "payout",
+			Summary:     "Expected Gumroad payout",
+			Description: "Gumroad pays out the balance of sales up to the previous week",
+			Start:       day,
+			End:         day.AddDate(0, 0, 1),
+			AllDay:      true,
+		})
+		day = day.AddDate(0, 0, 7)
+	}
+	return events
+}
+
+// WriteICS writes the events as an iCalendar feed
+func (c *Calendar) WriteICS(w io.Writer) error {
+	stamp := c.clock.Now().UTC().Format("20060102T150405Z")
+	var out bytes.Buffer
+	line := func(text string) {
+		// Lines longer than 75 octets are folded onto continuation lines
+		// starting with a space, without splitting a UTF-8 sequence
+		for len(text) > 75 {
+			cut := 75
+			for cut > 0 && text[cut]&0xC0 == 0x80 {
+				cut--
+			}
+			out.WriteString(text[:cut] + "\r\n")
+			text = " " + text[cut:]
+		}
+		out.WriteString(text + "\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//" + calendarDomain + "//Seller calendar//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:Gumroad schedule")
+	for _, event := range c.Events() {
+		line("BEGIN:VEVENT")
+		line("UID:" + icsEscape(event.UID))
+		line("DTSTAMP:" + stamp)
+		if event.AllDay {
+			line("DTSTART;VALUE=DATE:" + event.Start.Format("20060102"))
+			line("DTEND;VALUE=DATE:" + event.End.Format("20060102"))
+		} else {
+			line("DTSTART:" + event.Start.UTC().Format("20060102T150405Z"))
+			line("DTEND:" + event.End.UTC().Format("20060102T150405Z"))
+		}
+		line("SUMMARY:" + icsEscape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION:" + icsEscape(event.Description))
+		}
+		line("CATEGORIES:" + icsEscape(event.Kind))
+		if event.Tentative {
+			line("STATUS:TENTATIVE")
+		} else {
+			line("STATUS:CONFIRMED")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// icsEscape escapes a text value for an iCalendar property
+func icsEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// Handler serves the feed at /calendar.ics for calendar apps to subscribe to
+func (c *Calendar) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		err := c.WriteICS(w)
+		if err != nil {
+			log.Printf("❌ Error writing calendar feed: %v", err)
+		}
+	})
+}
+
+// syncedEvent is what GoogleCalendarSync remembers about an event it wrote
+type syncedEvent struct {
+	Hash string    `json:"hash"`
+	End  time.Time `json:"end"`
+}
+
+// GoogleCalendarSync keeps a Google calendar matching the Calendar. Each
+// event is written under an ID derived from its UID, rewritten only when it
+// changes, and deleted once it no longer appears, so moving a launch moves
+// its event rather than adding a second one. Past events that drop off the
+// calendar, such as last week's payout, are left in place.
+type GoogleCalendarSync struct {
+	CalendarID string
+	// Retries is how many times a failed API call is retried
+	Retries int
+	// Backoff is the delay before the first retry; each retry doubles it up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// APIURL defaults to the public API
+	APIURL     string
+	HTTPClient *http.Client
+
+	calendar *Calendar
+	account  *ServiceAccount
+	clock    Clock
+	path     string
+
+	mu     sync.Mutex // serializes syncs
+	synced map[string]syncedEvent
+}
+
+// NewGoogleCalendarSync syncs the calendar to the Google calendar with the
+// given ID, authenticating as the service account, which must be able to
+// make changes to its events. What has been written is persisted at path.
+func NewGoogleCalendarSync(calendar *Calendar, account *ServiceAccount, calendarID, path string) (*GoogleCalendarSync, error) {
+	gs := &GoogleCalendarSync{
+		CalendarID: calendarID,
+		Retries:    3,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+		APIURL:     calendarAPIURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		calendar:   calendar,
+		account:    account,
+		clock:      calendar.clock,
+		path:       path,
+		synced:     make(map[string]syncedEvent),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read calendar sync state: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &gs.synced)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse calendar sync state %s: %v", path, err)
+		}
+	}
+	return gs, nil
+}
+
+// googleEventID derives an event ID from a UID. Google accepts the
+// characters 0-9 and a-v, which hex digits are.
+func googleEventID(uid string) string {
+	sum := md5.Sum([]byte(uid))
+	return hex.EncodeToString(sum[:])
+}
+
+// googleEvent is the event resource written for an event
+func googleEvent(id string, event CalendarEvent) map[string]interface{} {
+	when := func(t time.Time) map[string]interface{} {
+		if event.AllDay {
+			return map[string]interface{}{"date": t.Format("2006-01-02")}
+		}
+		return map[string]interface{}{"dateTime": t.UTC().Format(time.RFC3339)}
+	}
+	status := "confirmed"
+	if event.Tentative {
+		status = "tentative"
+	}
+	return map[string]interface{}{
+		"id":          id,
+		"summary":     event.Summary,
+		"description": event.Description,
+		"start":       when(event.Start),
+		"end":         when(event.End),
+		"status":      status,
+	}
+}
+
+// Sync writes new and changed events and deletes removed ones. Events that
+// fail are retried on the next sync; the first error is returned.
+func (gs *GoogleCalendarSync) Sync() error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	now := gs.clock.Now()
+	current := make(map[string]bool)
+	var firstErr error
+	written, deleted := 0, 0
+	fail := func(err error) {
+		log.Printf("❌ Calendar sync: %v", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, event := range gs.calendar.Events() {
+		id := googleEventID(event.UID)
+		current[id] = true
+		body, _ := json.Marshal(googleEvent(id, event))
+		sum := md5.Sum(body)
+		hash := hex.EncodeToString(sum[:])
+
+		previous, known := gs.synced[id]
+		if known && previous.Hash == hash {
+			continue
+		}
+		err := gs.write(id, body, known)
+		if err != nil {
+			fail(fmt.Errorf("writing %s: %v", event.UID, err))
+			continue
+		}
+		gs.synced[id] = syncedEvent{Hash: hash, End: event.End}
+		written++
+	}
+
+	for id, previous := range gs.synced {
+		if current[id] {
+			continue
+		}
+		if previous.End.Before(now) {
+			delete(gs.synced, id)
+			continue
+		}
+		err := gs.call(http.MethodDelete, gs.eventURL(id), nil)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone) {
+			err = nil // already deleted by hand
+		}
+		if err != nil {
+			fail(fmt.Errorf("deleting %s: %v", id, err))
+			continue
+		}
+		delete(gs.synced, id)
+		deleted++
+	}
+
+	err := writeJSONFile(gs.path, gs.synced)
+	if err != nil {
+		fail(err)
+	}
+	if written > 0 || deleted > 0 {
+		fmt.Printf("📅 Synced calendar %s: %d written, %d deleted\n", gs.CalendarID, written, deleted)
+	}
+	return firstErr
+}
+
+// write updates an event written before, or inserts a new one. Each falls
+// back to the other, for events deleted by hand and for a lost sync state.
+func (gs *GoogleCalendarSync) write(id string, body []byte, known bool) error {
+	update := func() error { return gs.call(http.MethodPut, gs.eventURL(id), body) }
+	insert := func() error { return gs.call(http.MethodPost, gs.eventsURL(), body) }
+
+	var apiErr *apiError
+	if known {
+		err := update()
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusGone) {
+			return insert()
+		}
+		return err
+	}
+	err := insert()
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		// The ID is taken, by an earlier write or a deleted event Google
+		// still remembers; updating it restores it
+		return update()
+	}
+	return err
+}
+
+func (gs *GoogleCalendarSync) eventsURL() string {
+	return strings.TrimSuffix(gs.APIURL, "/") + "/calendars/" + url.PathEscape(gs.CalendarID) + "/events"
+}
+
+func (gs *GoogleCalendarSync) eventURL(id string) string {
+	return gs.eventsURL() + "/" + url.PathEscape(id)
+}
+
+// call makes an API request, retrying 429s, 5xx responses and network
+// errors with exponential backoff
+func (gs *GoogleCalendarSync) call(method, endpoint string, body []byte) error {
+	retry := retrier{Retries: gs.Retries, Backoff: gs.Backoff, MaxBackoff: gs.MaxBackoff, Clock: gs.clock, Classify: retryGoogle}
+	return retry.do("Calendar call", func() error {
+		return gs.attempt(method, endpoint, body)
+	})
+}
+
+// attempt makes one API request
+func (gs *GoogleCalendarSync) attempt(method, endpoint string, body []byte) error {
+	token, err := gs.account.Token(gs.HTTPClient, calendarScope, gs.clock.Now())
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return final(err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", JSONContentType)
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := gs.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 300 {
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<20))
+		return nil
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		gs.account.invalidate(calendarScope)
+	}
+	return readAPIError("Calendar API", response)
+}
+
+// RegisterCalendarJobs adds the calendar_sync job handler, which brings the
+// Google calendar up to date
+func RegisterCalendarJobs(s *Scheduler, calendarSync *GoogleCalendarSync) {
+	s.Handle("calendar_sync", func(job Job) error {
+		return calendarSync.Sync()
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newChaosBridge runs a bridge over a ChaosTransport wrapping a
+// MemoryTransport, counting how often each DataSync message is handled by
+// its payload's n
+func newChaosBridge(t *testing.T, config ChaosConfig) (*GoBridge, *MemoryTransport, *ChaosTransport, func() map[int]int) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	memory := NewMemoryTransport()
+	chaos := NewChaosTransport(memory, config, clock)
+	gb := NewGoBridge("", WithClock(clock), WithTransport(chaos), WithIDGenerator(NewSequentialIDs("chaos")))
+
+	var mu sync.Mutex
+	handled := make(map[int]int)
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		n, _ := toNumber(message.Payload["n"])
+		if stringArg(message.Payload, "text") != chaosText(int(n)) {
+			t.Errorf("handled a corrupted payload %v", message.Payload)
+		}
+		mu.Lock()
+		handled[int(n)]++
+		mu.Unlock()
+		return nil
+	})
+	// Close drains the pipeline, so every delivery has been handled
+	closed := false
+	counts := func() map[int]int {
+		if !closed {
+			gb.Close()
+			closed = true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return handled
+	}
+	t.Cleanup(func() { counts() })
+	return gb, memory, chaos, counts
+}
+
+func chaosText(n int) string {
+	return fmt.Sprintf("message %d", n)
+}
+
+// chaosMessages makes count DataSync messages as a peer would send them
+func chaosMessages(gb *GoBridge, count int) []*UniversalMessage {
+	messages := make([]*UniversalMessage, count)
+	for i := range messages {
+		messages[i] = gb.NewMessage(DataSync, "go", map[string]interface{}{"n": i, "text": chaosText(i)}, SharedMemory)
+	}
+	return messages
+}
+
+func TestChaosDuplicatesAreDeduplicated(t *testing.T) {
+	gb, memory, chaos, counts := newChaosBridge(t, ChaosConfig{DuplicateRate: 1, Seed: 1})
+	for _, message := range chaosMessages(gb, 20) {
+		// Decode runs in parallel, so the copy can get ahead and the
+		// original is nacked as in flight; the sender redelivers it
+		err := memory.Inject(message)
+		for errors.Is(err, ErrDuplicateInFlight) {
+			err = memory.Inject(message)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled := counts()
+	if chaos.Stats().Duplicated < 20 {
+		t.Fatalf("%d duplicates injected, want one for every message", chaos.Stats().Duplicated)
+	}
+	for n := 0; n < 20; n++ {
+		if handled[n] != 1 {
+			t.Fatalf("message %d handled %d times, want once", n, handled[n])
+		}
+	}
+}
+
+func TestChaosDroppedMessagesAreRedelivered(t *testing.T) {
+	gb, memory, chaos, counts := newChaosBridge(t, ChaosConfig{DropRate: 0.5, Seed: 7})
+	for _, message := range chaosMessages(gb, 20) {
+		// The sender redelivers on nack, as the file and HTTP transports do
+		var err error
+		for attempt := 0; attempt < 100; attempt++ {
+			err = memory.Inject(message)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrChaosDropped) {
+				t.Fatalf("nacked with %v, want ErrChaosDropped", err)
+			}
+		}
+		if err != nil {
+			t.Fatalf("message %s never got through", message.ID)
+		}
+	}
+	handled := counts()
+	dropped := chaos.Stats().Dropped
+	if dropped == 0 || len(memory.Nacked()) != dropped {
+		t.Fatalf("%d drops and %d nacks, want the same nonzero number", dropped, len(memory.Nacked()))
+	}
+	for n := 0; n < 20; n++ {
+		if handled[n] != 1 {
+			t.Fatalf("message %d handled %d times, want once", n, handled[n])
+		}
+	}
+}
+
+func TestChaosCorruptionIsRejected(t *testing.T) {
+	t.Run("inbound bit flips", func(t *testing.T) {
+		gb, memory, chaos, counts := newChaosBridge(t, ChaosConfig{CorruptRate: 1, Seed: 3})
+		rejected := 0
+		for _, message := range chaosMessages(gb, 50) {
+			if memory.Inject(message) != nil {
+				rejected++
+			}
+		}
+		// Flips outside the payload can go unnoticed; the handler checks
+		// that no changed payload got through
+		handled := counts()
+		if chaos.Stats().Corrupted != 50 || rejected == 0 || len(handled) == 50 {
+			t.Fatalf("%d corrupted, %d rejected, %d handled", chaos.Stats().Corrupted, rejected, len(handled))
+		}
+	})
+
+	t.Run("outbound payload changes", func(t *testing.T) {
+		gb, memory, _, _ := newChaosBridge(t, ChaosConfig{CorruptRate: 1, Seed: 3})
+		for _, message := range chaosMessages(gb, 5) {
+			if _, err := gb.SendMessage(message); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, peerMemory, _, peerCounts := newChaosBridge(t, ChaosConfig{})
+		sent := memory.Sent()
+		if len(sent) != 5 {
+			t.Fatalf("%d messages sent, want 5", len(sent))
+		}
+		for _, message := range sent {
+			err := peerMemory.Inject(message)
+			if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+				t.Fatalf("peer accepted corrupted message %s: %v", message.ID, err)
+			}
+		}
+		if handled := peerCounts(); len(handled) != 0 {
+			t.Fatalf("peer handled %d corrupted messages", len(handled))
+		}
+	})
+}
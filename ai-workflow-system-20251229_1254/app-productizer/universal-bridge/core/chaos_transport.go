@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrChaosDropped is the nack reason for inbound messages dropped by a ChaosTransport
+var ErrChaosDropped = errors.New("chaos: message dropped")
+
+// ChaosConfig sets the probability (0..1) of each fault per message
+type ChaosConfig struct {
+	DropRate      float64
+	DuplicateRate float64
+	ReorderRate   float64
+	CorruptRate   float64
+	DelayRate     float64
+	MaxDelay      time.Duration // upper bound for delays and reorder holds
+	Seed          int64         // 0 picks a time-based seed
+}
+
+// ChaosStats counts the faults a ChaosTransport has injected
+type ChaosStats struct {
+	Dropped    int
+	Duplicated int
+	Reordered  int
+	Corrupted  int
+	Delayed    int
+}
+
+// ChaosTransport wraps another transport and randomly drops, duplicates,
+// reorders, corrupts, and delays traffic in both directions
+type ChaosTransport struct {
+	inner  Transport
+	config ChaosConfig
+	clock  Clock
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	stats   ChaosStats
+	heldOut *UniversalMessage
+	heldIn  *Envelope
+}
+
+// NewChaosTransport wraps inner with fault injection
+func NewChaosTransport(inner Transport, config ChaosConfig, clock Clock) *ChaosTransport {
+	if clock == nil {
+		clock = defaultClock
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = 100 * time.Millisecond
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &ChaosTransport{
+		inner:  inner,
+		config: config,
+		clock:  clock,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Channel returns the wrapped transport's channel
+func (ct *ChaosTransport) Channel() CommunicationChannel {
+	return ct.inner.Channel()
+}
+
+// Stats returns the faults injected so far
+func (ct *ChaosTransport) Stats() ChaosStats {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.stats
+}
+
+// roll returns true with the given probability and records the fault
+func (ct *ChaosTransport) roll(rate float64, counter *int) bool {
+	if rate <= 0 {
+		return false
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.rng.Float64() >= rate {
+		return false
+	}
+	*counter++
+	return true
+}
+
+// randomDelay picks a delay in (0, MaxDelay]
+func (ct *ChaosTransport) randomDelay() time.Duration {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return time.Duration(ct.rng.Int63n(int64(ct.config.MaxDelay))) + 1
+}
+
+// Send applies outbound faults before handing the message to the wrapped transport
+func (ct *ChaosTransport) Send(message *UniversalMessage) error {
+	if ct.roll(ct.config.DropRate, &ct.stats.Dropped) {
+		return nil // silently lost
+	}
+	if ct.roll(ct.config.CorruptRate, &ct.stats.Corrupted) {
+		message = ct.corruptMessage(message)
+	}
+	if ct.roll(ct.config.DelayRate, &ct.stats.Delayed) {
+		delay := ct.randomDelay()
+		go func() {
+			<-ct.clock.After(delay)
+			ct.sendOrdered(message)
+		}()
+		return nil
+	}
+	return ct.sendOrdered(message)
+}
+
+// sendOrdered applies reordering and duplication, then sends
+func (ct *ChaosTransport) sendOrdered(message *UniversalMessage) error {
+	if ct.roll(ct.config.ReorderRate, &ct.stats.Reordered) {
+		ct.mu.Lock()
+		if ct.heldOut == nil {
+			ct.heldOut = message
+			ct.mu.Unlock()
+			go ct.releaseHeldOut(message, ct.randomDelay())
+			return nil
+		}
+		ct.mu.Unlock()
+	}
+
+	err := ct.sendWithDuplicates(message)
+
+	// A held message goes out after the one that overtook it
+	ct.mu.Lock()
+	held := ct.heldOut
+	if held != nil && held != message {
+		ct.heldOut = nil
+	} else {
+		held = nil
+	}
+	ct.mu.Unlock()
+	if held != nil {
+		ct.sendWithDuplicates(held)
+	}
+	return err
+}
+
+// releaseHeldOut flushes a held outbound message if nothing overtook it in time
+func (ct *ChaosTransport) releaseHeldOut(message *UniversalMessage, after time.Duration) {
+	<-ct.clock.After(after)
+
+	ct.mu.Lock()
+	if ct.heldOut != message {
+		ct.mu.Unlock()
+		return
+	}
+	ct.heldOut = nil
+	ct.mu.Unlock()
+	ct.sendWithDuplicates(message)
+}
+
+func (ct *ChaosTransport) sendWithDuplicates(message *UniversalMessage) error {
+	err := ct.inner.Send(message)
+	if err == nil && ct.roll(ct.config.DuplicateRate, &ct.stats.Duplicated) {
+		err = ct.inner.Send(message)
+	}
+	return err
+}
+
+// corruptMessage returns a copy of the message whose payload no longer matches its checksum
+func (ct *ChaosTransport) corruptMessage(message *UniversalMessage) *UniversalMessage {
+	corrupted := *message
+	corrupted.Payload = make(map[string]interface{}, len(message.Payload)+1)
+	for key, value := range message.Payload {
+		corrupted.Payload[key] = value
+	}
+
+	ct.mu.Lock()
+	corrupted.Payload["__chaos"] = ct.rng.Int63()
+	ct.mu.Unlock()
+	return &corrupted
+}
+
+// Start wraps the delivery callback with inbound faults
+func (ct *ChaosTransport) Start(deliver func(*Envelope)) error {
+	return ct.inner.Start(func(envelope *Envelope) {
+		ct.receive(envelope, deliver)
+	})
+}
+
+// receive applies inbound faults. Dropped envelopes are nacked with
+// ErrChaosDropped so transports that redeliver on nack see a retry.
+func (ct *ChaosTransport) receive(envelope *Envelope, deliver func(*Envelope)) {
+	if ct.roll(ct.config.DropRate, &ct.stats.Dropped) {
+		envelope.Nack(ErrChaosDropped)
+		return
+	}
+	if ct.roll(ct.config.CorruptRate, &ct.stats.Corrupted) {
+		data, err := envelope.Bytes()
+		if err == nil {
+			envelope.Data = ct.corruptBytes(data)
+		}
+	}
+	if ct.roll(ct.config.DelayRate, &ct.stats.Delayed) {
+		delay := ct.randomDelay()
+		go func() {
+			<-ct.clock.After(delay)
+			ct.receiveOrdered(envelope, deliver)
+		}()
+		return
+	}
+	ct.receiveOrdered(envelope, deliver)
+}
+
+func (ct *ChaosTransport) receiveOrdered(envelope *Envelope, deliver func(*Envelope)) {
+	if ct.roll(ct.config.ReorderRate, &ct.stats.Reordered) {
+		ct.mu.Lock()
+		if ct.heldIn == nil {
+			ct.heldIn = envelope
+			ct.mu.Unlock()
+			go ct.releaseHeldIn(envelope, ct.randomDelay(), deliver)
+			return
+		}
+		ct.mu.Unlock()
+	}
+
+	ct.deliverWithDuplicates(envelope, deliver)
+
+	ct.mu.Lock()
+	held := ct.heldIn
+	if held != nil && held != envelope {
+		ct.heldIn = nil
+	} else {
+		held = nil
+	}
+	ct.mu.Unlock()
+	if held != nil {
+		ct.deliverWithDuplicates(held, deliver)
+	}
+}
+
+func (ct *ChaosTransport) releaseHeldIn(envelope *Envelope, after time.Duration, deliver func(*Envelope)) {
+	<-ct.clock.After(after)
+
+	ct.mu.Lock()
+	if ct.heldIn != envelope {
+		ct.mu.Unlock()
+		return
+	}
+	ct.heldIn = nil
+	ct.mu.Unlock()
+	ct.deliverWithDuplicates(envelope, deliver)
+}
+
+// deliverWithDuplicates delivers the envelope and possibly an extra copy whose
+// ack and nack are no-ops, as a transport redelivery would look to the bridge
+func (ct *ChaosTransport) deliverWithDuplicates(envelope *Envelope, deliver func(*Envelope)) {
+	deliver(envelope)
+	if ct.roll(ct.config.DuplicateRate, &ct.stats.Duplicated) {
+		data, err := envelope.Bytes()
+		if err == nil {
+			duplicate := NewEnvelope(envelope.Channel, envelope.Source, data, nil, nil)
+			duplicate.ContentType = envelope.ContentType
+			deliver(duplicate)
+		}
+	}
+}
+
+// corruptBytes flips one random bit in a copy of data
+func (ct *ChaosTransport) corruptBytes(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	corrupted := append([]byte(nil), data...)
+
+	ct.mu.Lock()
+	index := ct.rng.Intn(len(corrupted))
+	bit := byte(1) << uint(ct.rng.Intn(8))
+	ct.mu.Unlock()
+
+	corrupted[index] ^= bit
+	return corrupted
+}
+
+// Close closes the wrapped transport
+func (ct *ChaosTransport) Close() error {
+	return ct.inner.Close()
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Chat notice events targets opt in to
+const (
+	ChatSale   = "sale"
+	ChatRefund = "refund"
+	ChatPrice  = "price" // a watched page's price or description changed
+)
+
+const (
+	// defaultChatPerMinute is how many notices a target is posted a minute
+	defaultChatPerMinute = 20
+	// maxChatDigestLines is how many held notices a digest lists by name
+	maxChatDigestLines = 10
+)
+
+// ChatTarget is a Discord or Slack incoming webhook
+type ChatTarget struct {
+	Name    string `yaml:"name"`
+	Discord string `yaml:"discord"`
+	Slack   string `yaml:"slack"`
+	// Products limits the target to these product IDs or permalinks, and
+	// price notices to these watched pages
+	Products []string `yaml:"products"`
+	Events   []string `yaml:"events"`
+	// PerMinute caps the notices posted in any minute; the rest wait and
+	// go out together as one digest
+	PerMinute int `yaml:"per_minute"`
+}
+
+// service names the chat service a target posts to
+func (t ChatTarget) service() string {
+	if t.Discord != "" {
+		return "discord"
+	}
+	return "slack"
+}
+
+// wants reports whether the target posts the event for the sale
+func (t ChatTarget) wants(event string, sale Sale) bool {
+	if !hasEvent(t.Events, event) {
+		return false
+	}
+	if len(t.Products) == 0 {
+		return true
+	}
+	return hasEvent(t.Products, sale.Product) || (sale.Permalink != "" && hasEvent(t.Products, sale.Permalink))
+}
+
+// ChatConfig says where sale notices are posted, loaded from YAML:
+//
+//	targets:
+//	  - name: sales
+//	    discord: https://discord.com/api/webhooks/123/abc
+//	    products: [course-pro]  # all products when left out
+//	    events: [sale, refund]  # the default; price is opt-in
+//	    per_minute: 10          # the default is 20
+//	  - name: team
+//	    slack: https://hooks.slack.com/services/T000/B000/XXXX
+type ChatConfig struct {
+	Targets []ChatTarget `yaml:"targets"`
+}
+
+// LoadChatConfig reads the chat targets
+func LoadChatConfig(path string) (ChatConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ChatConfig{}, fmt.Errorf("failed to read chat config: %v", err)
+	}
+
+	var config ChatConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return ChatConfig{}, fmt.Errorf("failed to parse chat config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (ChatConfig, error) {
+		return ChatConfig{}, fmt.Errorf("invalid chat config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	if len(config.Targets) == 0 {
+		return invalid("no targets")
+	}
+	names := make(map[string]bool)
+	for i := range config.Targets {
+		target := &config.Targets[i]
+		if (target.Discord == "") == (target.Slack == "") {
+			return invalid("target %d needs exactly one of discord or slack", i+1)
+		}
+		webhook := target.Discord + target.Slack
+		if parsed, err := url.Parse(webhook); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return invalid("target %d: %s webhook %q is not a URL", i+1, target.service(), webhook)
+		}
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("%s-%d", target.service(), i+1)
+		}
+		if names[target.Name] {
+			return invalid("two targets are named %s", target.Name)
+		}
+		names[target.Name] = true
+		if target.Events == nil {
+			target.Events = []string{ChatSale, ChatRefund}
+		}
+		for _, event := range target.Events {
+			if event != ChatSale && event != ChatRefund && event != ChatPrice {
+				return invalid("%s: unknown event %q", target.Name, event)
+			}
+		}
+		if target.PerMinute < 0 {
+			return invalid("%s: per_minute is negative", target.Name)
+		}
+		if target.PerMinute == 0 {
+			target.PerMinute = defaultChatPerMinute
+		}
+	}
+	return config, nil
+}
+
+// ChatNotifier posts each sale and refund to Discord and Slack webhooks:
+// the product, the amount, the buyer's country and the day's running total
+// in the sale's currency. Targets opted in to the price event also get each
+// change a price watch finds. Each target posts the products and events it
+// opted in to, at most PerMinute notices a minute; notices over the limit
+// wait, in memory, and go out together as one digest once the minute has
+// room. Notices are delivered through the "chat" sink, so an unreachable
+// webhook is retried behind its circuit breaker.
+type ChatNotifier struct {
+	Config     ChatConfig
+	HTTPClient *http.Client
+
+	bridge    *GoBridge
+	clock     Clock
+	sales     *SalesStore
+	analytics *SalesAnalytics
+	sink      *Sink
+
+	mu       sync.Mutex
+	notified map[string]bool        // sales and refunds already posted, so redelivered pings post once
+	posted   map[string][]time.Time // per target, when notices went out in the last minute
+	held     map[string][]string    // per target, notices waiting for room
+
+	running  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewChatNotifier posts the sales and refunds the bridge receives. The
+// running totals are read from the sales store for days in location,
+// so the notifier must be created after RecordSales.
+func NewChatNotifier(gb *GoBridge, config ChatConfig, sales *SalesStore, location *time.Location) *ChatNotifier {
+	analytics := NewSalesAnalytics(gb, sales)
+	analytics.Location = location
+	cn := &ChatNotifier{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		bridge:     gb,
+		clock:      gb.clock,
+		sales:      sales,
+		analytics:  analytics,
+		notified:   make(map[string]bool),
+		posted:     make(map[string][]time.Time),
+		held:       make(map[string][]string),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	cn.sink = gb.AddSink("chat", SinkConfig{}, cn.deliver)
+	gb.OnReceive(cn.handleMessage)
+	return cn
+}
+
+func (cn *ChatNotifier) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+	var event, key string
+	var sale Sale
+	var amount Money
+	switch stringArg(message.Payload, "resource_name") {
+	case "sale":
+		var err error
+		sale, err = ParseSale(message.Payload)
+		if err != nil || sale.Refunded || sale.IsBundle() {
+			// A bundle's components are posted instead
+			return
+		}
+		event, key, amount = ChatSale, "sale:"+sale.ID, sale.Price
+	case "refund":
+		refund, err := ParseRefund(message.Payload, cn.clock.Now())
+		if err != nil {
+			return
+		}
+		sale, _ = ParseSale(message.Payload)
+		if stored, ok := cn.sales.Sale(refund.SaleID); ok {
+			sale = stored
+		}
+		event, key, amount = ChatRefund, fmt.Sprintf("refund:%s:%d", refund.SaleID, refund.Amount.Amount), refund.Amount
+	case "price_watch":
+		if cn.first("price:" + message.ID) {
+			cn.Notify(ChatPrice, Sale{Product: stringArg(message.Payload, "page")}, stringArg(message.Payload, "text"))
+		}
+		return
+	default:
+		return
+	}
+	if !cn.first(key) {
+		return
+	}
+	cn.Notify(event, sale, cn.noticeText(event, sale, amount))
+}
+
+// first reports whether the sale or refund has not been posted before
+func (cn *ChatNotifier) first(key string) bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.notified[key] {
+		return false
+	}
+	cn.notified[key] = true
+	return true
+}
+
+// noticeText writes a notice, e.g.
+//
+//	💰 New sale: Course Pro, 49.00 USD from Germany
+//	Today: 310.00 USD net from 7 sales
+func (cn *ChatNotifier) noticeText(event string, sale Sale, amount Money) string {
+	product := sale.ProductName
+	if product == "" {
+		product = sale.Product
+	}
+	var text string
+	if event == ChatRefund {
+		text = fmt.Sprintf("↩️ Refund: %s, %s", product, amount)
+	} else {
+		text = fmt.Sprintf("💰 New sale: %s, %s", product, amount)
+	}
+	if country := BuyerCountry(sale.Fields); country != "" {
+		if name := countryZones[country].name; name != "" {
+			country = name
+		}
+		text += " from " + country
+	}
+
+	today := cn.clock.Now().In(cn.analytics.Location).Format("2006-01-02")
+	summary, err := cn.analytics.Summary(today, today, "")
+	if err != nil {
+		return text
+	}
+	for _, net := range summary.NetRevenue {
+		if net.Currency == amount.Currency {
+			noun := "sales"
+			if summary.Sales == 1 {
+				noun = "sale"
+			}
+			text += fmt.Sprintf("\nToday: %s net from %d %s", net, summary.Sales, noun)
+		}
+	}
+	return text
+}
+
+// Notify posts a notice about the sale to every target that opted in to
+// the event and the sale's product, holding it for targets at their limit
+func (cn *ChatNotifier) Notify(event string, sale Sale, text string) {
+	for _, target := range cn.Config.Targets {
+		if !target.wants(event, sale) {
+			continue
+		}
+		if cn.take(target) {
+			cn.enqueue(target.Name, event, text)
+			continue
+		}
+		cn.mu.Lock()
+		cn.held[target.Name] = append(cn.held[target.Name], text)
+		cn.mu.Unlock()
+		fmt.Printf("🚦 Holding %s notice for %s, over %d a minute\n", event, target.Name, target.PerMinute)
+	}
+}
+
+// take reports whether the target has room for a notice this minute, and
+// if so counts one
+func (cn *ChatNotifier) take(target ChatTarget) bool {
+	now := cn.clock.Now()
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	recent := cn.posted[target.Name][:0]
+	for _, at := range cn.posted[target.Name] {
+		if now.Sub(at) < time.Minute {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= target.PerMinute {
+		cn.posted[target.Name] = recent
+		return false
+	}
+	cn.posted[target.Name] = append(recent, now)
+	return true
+}
+
+// flush posts each target's held notices as one digest, where it has room
+func (cn *ChatNotifier) flush() {
+	for _, target := range cn.Config.Targets {
+		cn.mu.Lock()
+		waiting := len(cn.held[target.Name]) > 0
+		cn.mu.Unlock()
+		if !waiting || !cn.take(target) {
+			continue
+		}
+		cn.mu.Lock()
+		held := cn.held[target.Name]
+		delete(cn.held, target.Name)
+		cn.mu.Unlock()
+		cn.enqueue(target.Name, "digest", chatDigest(held))
+	}
+}
+
+// chatDigest rolls held notices into one post, naming the first few by
+// their first line
+func chatDigest(notices []string) string {
+	if len(notices) == 1 {
+		return notices[0]
+	}
+	lines := []string{fmt.Sprintf("📦 %d notices held back by the rate limit:", len(notices))}
+	for i, notice := range notices {
+		if i == maxChatDigestLines {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(notices)-i))
+			break
+		}
+		first, _, _ := strings.Cut(notice, "\n")
+		lines = append(lines, first)
+	}
+	// The latest running total is the one that still holds
+	if _, total, ok := strings.Cut(notices[len(notices)-1], "\n"); ok {
+		lines = append(lines, total)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// enqueue queues a notice for one target on the chat sink
+func (cn *ChatNotifier) enqueue(target, event, text string) {
+	message := cn.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+		"target": target,
+		"event":  event,
+		"text":   text,
+	}, FileSystem)
+	err := cn.sink.Enqueue(message)
+	if err != nil {
+		log.Printf("❌ Error queueing %s notice for %s: %v", event, target, err)
+	}
+}
+
+// Held returns how many notices wait for each target's limit
+func (cn *ChatNotifier) Held() map[string]int {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	counts := make(map[string]int, len(cn.held))
+	for name, notices := range cn.held {
+		counts[name] = len(notices)
+	}
+	return counts
+}
+
+// deliver posts one queued notice to its target's webhook
+func (cn *ChatNotifier) deliver(message *UniversalMessage) error {
+	name := stringArg(message.Payload, "target")
+	text := stringArg(message.Payload, "text")
+	var target *ChatTarget
+	for i := range cn.Config.Targets {
+		if cn.Config.Targets[i].Name == name {
+			target = &cn.Config.Targets[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("notice %s is for %s, which is not configured", message.ID, name)
+	}
+
+	var body interface{}
+	if target.Discord != "" {
+		// No @everyone from a product name
+		body = map[string]interface{}{"content": text, "allowed_mentions": map[string]interface{}{"parse": []string{}}}
+	} else {
+		body = map[string]interface{}{"text": slackEscape(text)}
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, target.Discord+target.Slack, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := cn.HTTPClient.Do(request)
+	if err != nil {
+		// Not the whole error, which names the webhook URL, its secret
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting to %s: %v", name, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("posting to %s: %s answered %s: %s", name, target.service(), response.Status, strings.TrimSpace(string(detail)))
+	}
+	fmt.Printf("💬 Posted %s notice to %s\n", stringArg(message.Payload, "event"), name)
+	return nil
+}
+
+// slackEscape escapes the characters Slack reads as markup
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Start posts held notices as digests once their targets have room,
+// checking every ten seconds, until Close
+func (cn *ChatNotifier) Start() {
+	cn.mu.Lock()
+	cn.running = true
+	cn.mu.Unlock()
+
+	go func() {
+		defer close(cn.done)
+		for {
+			select {
+			case <-cn.stop:
+				return
+			case <-cn.clock.After(10 * time.Second):
+			}
+			cn.flush()
+		}
+	}()
+}
+
+// Close stops posting digests; notices still held are dropped
+func (cn *ChatNotifier) Close() {
+	cn.stopOnce.Do(func() { close(cn.stop) })
+	cn.mu.Lock()
+	running := cn.running
+	cn.mu.Unlock()
+	if running {
+		<-cn.done
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadChatConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"defaults", "targets:\n  - discord: https://discord.com/api/webhooks/1/a\n", ""},
+		{"no targets", "targets: []\n", "no targets"},
+		{"both services", "targets:\n  - discord: https://discord.com/api/webhooks/1/a\n    slack: https://hooks.slack.com/services/a\n", "exactly one of discord or slack"},
+		{"not a URL", "targets:\n  - slack: hooks.slack.com\n", "is not a URL"},
+		{"same name", "targets:\n  - name: a\n    slack: https://hooks.slack.com/services/a\n  - name: a\n    slack: https://hooks.slack.com/services/b\n", "two targets are named a"},
+		{"unknown event", "targets:\n  - slack: https://hooks.slack.com/services/a\n    events: [dispute]\n", `unknown event "dispute"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "chat.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadChatConfig(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		target := config.Targets[0]
+		if target.Name != "discord-1" || target.PerMinute != defaultChatPerMinute || len(target.Events) != 2 {
+			t.Errorf("%s: got %+v, want the defaults", tt.name, target)
+		}
+	}
+}
+
+func TestChatNotifier(t *testing.T) {
+	// Closed after the bridge, which waits for the posts to go out
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(server.Close)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	cn := NewChatNotifier(gb, ChatConfig{Targets: []ChatTarget{
+		{Name: "team", Slack: server.URL + "/slack", Events: []string{ChatSale, ChatRefund}, PerMinute: 2},
+		{Name: "course", Discord: server.URL + "/discord", Products: []string{"course"}, Events: []string{ChatSale}, PerMinute: 20},
+	}}, sales, time.UTC)
+	var posts []string
+	cn.sink.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		posts = append(posts, stringArg(message.Payload, "target")+": "+stringArg(message.Payload, "text"))
+		return message
+	})
+
+	ids := NewSequentialIDs("gumroad")
+	ping := func(resource string, fields map[string]interface{}) {
+		t.Helper()
+		payload := map[string]interface{}{"resource_name": resource, "price": 49, "currency": "usd", "sale_timestamp": clock.Now().Format(time.RFC3339)}
+		for key, value := range fields {
+			payload[key] = value
+		}
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	course := map[string]interface{}{"sale_id": "s1", "product_id": "p1", "product_permalink": "course", "product_name": "Course Pro", "ip_country": "Germany"}
+	ebook := func(id string) map[string]interface{} {
+		return map[string]interface{}{"sale_id": id, "product_id": "ebook", "product_name": "The Ebook"}
+	}
+	tests := []struct {
+		name      string
+		resource  string
+		fields    map[string]interface{}
+		wantPosts []string
+	}{
+		{"sale on both targets", "sale", course, []string{
+			"team: 💰 New sale: Course Pro, 49.00 USD from Germany\nToday: 49.00 USD net from 1 sale",
+			"course: 💰 New sale: Course Pro, 49.00 USD from Germany\nToday: 49.00 USD net from 1 sale",
+		}},
+		{"redelivered", "sale", course, nil},
+		{"another product", "sale", ebook("s2"), []string{"team: 💰 New sale: The Ebook, 49.00 USD\nToday: 98.00 USD net from 2 sales"}},
+		{"over the limit", "sale", ebook("s3"), nil},
+		{"refund over the limit", "refund", course, nil},
+	}
+	for _, tt := range tests {
+		posts = nil
+		ping(tt.resource, tt.fields)
+		if strings.Join(posts, "|") != strings.Join(tt.wantPosts, "|") {
+			t.Errorf("%s: posted %q, want %q", tt.name, posts, tt.wantPosts)
+		}
+	}
+	if held := cn.Held(); held["team"] != 2 {
+		t.Fatalf("held %v, want 2 notices for team", held)
+	}
+
+	posts = nil
+	cn.flush()
+	if len(posts) != 0 {
+		t.Errorf("posted %q before the minute was up", posts)
+	}
+	clock.Advance(time.Minute)
+	cn.flush()
+	want := "team: 📦 2 notices held back by the rate limit:\n💰 New sale: The Ebook, 49.00 USD\n↩️ Refund: Course Pro, 49.00 USD from Germany\nToday: 98.00 USD net from 3 sales"
+	if len(posts) != 1 || posts[0] != want {
+		t.Errorf("flushed %q, want %q", posts, want)
+	}
+	if held := cn.Held(); held["team"] != 0 {
+		t.Errorf("still held %v after the digest", held)
+	}
+}
+
+func TestChatNotifierPosts(t *testing.T) {
+	gb := NewGoBridge("", WithClock(NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if r.URL.Path == "/down" {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	sales, _ := NewSalesStore("")
+	cn := NewChatNotifier(gb, ChatConfig{Targets: []ChatTarget{
+		{Name: "discord", Discord: server.URL + "/discord"},
+		{Name: "slack", Slack: server.URL + "/slack"},
+		{Name: "down", Slack: server.URL + "/down"},
+	}}, sales, time.UTC)
+
+	tests := []struct {
+		target  string
+		wantErr string
+		want    string
+	}{
+		{"discord", "", "map[allowed_mentions:map[parse:[]] content:💰 New sale: <Kit> & more]"},
+		{"slack", "", "map[text:💰 New sale: &lt;Kit&gt; &amp; more]"},
+		{"down", "429 Too Many Requests: rate limited", ""},
+		{"gone", "gone, which is not configured", ""},
+	}
+	for _, tt := range tests {
+		bodies = nil
+		err := cn.deliver(gb.NewMessage(DataSync, "go", map[string]interface{}{"target": tt.target, "event": "sale", "text": "💰 New sale: <Kit> & more"}, FileSystem))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.target, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		if len(bodies) != 1 || fmt.Sprint(bodies[0]) != tt.want {
+			t.Errorf("%s: posted %v, want %s", tt.target, bodies, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCheckouts tracks views of one catalog product, persisted in a
+// scratch directory
+func newTestCheckouts(t *testing.T) (*AbandonedCheckouts, *ManualClock, string) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	path := filepath.Join(t.TempDir(), "visits.json")
+	checkouts, err := NewAbandonedCheckouts(gb, NewTemplateStore("templates"), nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkouts.SetCatalog([]GumroadProduct{{ID: "p1", Name: "Go Course", ShortURL: "https://seller.gumroad.com/l/go", CustomPermalink: "go"}})
+	return checkouts, clock, path
+}
+
+func TestTrackEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantVisits int
+	}{
+		{"catalog product", url.Values{"email": {"a@example.com"}, "product": {"p1"}}, http.StatusNoContent, 1},
+		{"by permalink", url.Values{"email": {"a@example.com"}, "product": {"go"}}, http.StatusNoContent, 1},
+		{"unknown product", url.Values{"email": {"a@example.com"}, "product": {"phish"}}, http.StatusBadRequest, 0},
+		{"no email", url.Values{"product": {"p1"}}, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			checkouts, _, _ := newTestCheckouts(t)
+			portal := NewPortal(checkouts.bridge, nil, checkouts.templates, "https://portal.example.com")
+			portal.Checkouts = checkouts
+
+			form := tt.form
+			form.Set("url", "https://evil.example.com/login")
+			form.Set("product_name", "Your account is locked")
+			request := httptest.NewRequest(http.MethodPost, "/track?"+form.Encode(), nil)
+			response := httptest.NewRecorder()
+			portal.Handler().ServeHTTP(response, request)
+
+			if response.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", response.Code, tt.wantStatus, response.Body)
+			}
+			if len(checkouts.visits) != tt.wantVisits {
+				t.Fatalf("%d visits tracked, want %d", len(checkouts.visits), tt.wantVisits)
+			}
+			for _, visit := range checkouts.visits {
+				if visit.URL != "https://seller.gumroad.com/l/go" || visit.ProductName != "Go Course" || visit.Product != "p1" {
+					t.Fatalf("visit took caller-supplied details: %+v", visit)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackLimits(t *testing.T) {
+	t.Run("no catalog", func(t *testing.T) {
+		checkouts, _, _ := newTestCheckouts(t)
+		checkouts.catalog = nil
+		if err := checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"}); err != ErrNoCheckoutCatalog {
+			t.Fatalf("Track = %v, want ErrNoCheckoutCatalog", err)
+		}
+	})
+
+	t.Run("visit cap", func(t *testing.T) {
+		checkouts, _, _ := newTestCheckouts(t)
+		checkouts.MaxVisits = 2
+		for _, email := range []string{"a@example.com", "b@example.com"} {
+			if err := checkouts.Track(CheckoutView{Email: email, Product: "p1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := checkouts.Track(CheckoutView{Email: "c@example.com", Product: "p1"}); err != ErrTooManyVisits {
+			t.Fatalf("Track past the cap = %v, want ErrTooManyVisits", err)
+		}
+		// Known visitors are still counted
+		if err := checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"}); err != nil {
+			t.Fatalf("repeat view past the cap: %v", err)
+		}
+	})
+
+	t.Run("saves are throttled", func(t *testing.T) {
+		checkouts, clock, path := newTestCheckouts(t)
+		modified := func() time.Time {
+			info, err := os.Stat(path)
+			if err != nil {
+				return time.Time{}
+			}
+			return info.ModTime()
+		}
+		checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"})
+		first := modified()
+		if first.IsZero() {
+			t.Fatal("first view not saved")
+		}
+		os.Remove(path)
+		checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"})
+		if !modified().IsZero() {
+			t.Fatal("repeat view within SaveInterval rewrote the file")
+		}
+		clock.Advance(checkouts.SaveInterval)
+		checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"})
+		if modified().IsZero() {
+			t.Fatal("view after SaveInterval not saved")
+		}
+		os.Remove(path)
+		checkouts.Track(CheckoutView{Email: "a@example.com", Product: "p1"})
+		if err := checkouts.Flush(); err != nil || modified().IsZero() {
+			t.Fatalf("Flush did not save: %v", err)
+		}
+	})
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const generatedHeader = "Code generated by universalbridge -openapi. DO NOT EDIT."
+
+// APIClientFiles renders the OpenAPI spec and the TypeScript and Python
+// clients generated from it, by file name
+func APIClientFiles() (map[string][]byte, error) {
+	spec, err := json.MarshalIndent(OpenAPISpec(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OpenAPI spec: %v", err)
+	}
+	doc := buildAPIDocument()
+	return map[string][]byte{
+		"openapi.json":  append(spec, '\n'),
+		"bridge_api.ts": typeScriptClient(doc),
+		"bridge_api.py": pythonClient(doc),
+	}, nil
+}
+
+// WriteAPIClients writes the spec and generated clients into dir
+func WriteAPIClients(dir string) error {
+	files, err := APIClientFiles()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), content, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// clientOperations returns the server's operations the clients cover
+func (doc apiDocument) clientOperations(server string) []APIOperation {
+	var ops []APIOperation
+	for _, op := range doc.operations {
+		if op.Server == server && !op.Browser {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// componentNames returns the schema components sorted, for stable output
+func (doc apiDocument) componentNames() []string {
+	names := make([]string, 0, len(doc.components))
+	for name := range doc.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paramsIn returns the parameters of op that are in one of the places
+func paramsIn(op APIOperation, in ...string) []APIParam {
+	var params []APIParam
+	for _, param := range op.Params {
+		for _, place := range in {
+			if param.In == place {
+				params = append(params, param)
+			}
+		}
+	}
+	return params
+}
+
+// formOrQuery says whether op's parameters go in a form body or the query
+func formOrQuery(op APIOperation) string {
+	if op.AnyFields || len(paramsIn(op, "form")) > 0 {
+		return "form"
+	}
+	return "query"
+}
+
+// clientName names a server's generated client class
+func clientName(server string) string {
+	return exportedName(server) + "Client"
+}
+
+func isRequired(schema *apiSchema, name string) bool {
+	for _, required := range schema.Required {
+		if required == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// tsType renders a schema as a TypeScript type
+func tsType(schema *apiSchema) string {
+	var t string
+	switch {
+	case schema.Ref != "":
+		t = schema.refName()
+	case schema.Type == "string":
+		t = "string"
+	case schema.Type == "integer", schema.Type == "number":
+		t = "number"
+	case schema.Type == "boolean":
+		t = "boolean"
+	case schema.Type == "array":
+		t = tsType(schema.Items)
+		if strings.Contains(t, " | ") {
+			t = "(" + t + ")"
+		}
+		t += "[]"
+	case schema.Type == "object" && schema.Properties != nil:
+		var fields []string
+		for _, name := range schema.order {
+			fields = append(fields, tsField(schema, name))
+		}
+		t = "{ " + strings.Join(fields, "; ") + " }"
+	case schema.Type == "object" && schema.AdditionalProperties != nil:
+		t = "Record<string, " + tsType(schema.AdditionalProperties) + ">"
+	default:
+		t = "unknown"
+	}
+	if schema.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+func tsField(schema *apiSchema, name string) string {
+	key := name
+	if !isIdentifier(name) {
+		key = fmt.Sprintf("%q", name)
+	}
+	if !isRequired(schema, name) {
+		key += "?"
+	}
+	return key + ": " + tsType(schema.Properties[name])
+}
+
+func tsParamType(param APIParam) string {
+	switch param.Type {
+	case "integer":
+		return "number"
+	case "boolean":
+		return "boolean"
+	}
+	return "string"
+}
+
+// tsCamel turns a path parameter into a TypeScript argument name
+func tsCamel(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = exportedName(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+const tsRuntime = `export interface ClientOptions {
+    /** Bearer token; for WebhookClient, the ping secret */
+    token?: string;
+    /** A fetch implementation, for runtimes without a global one */
+    fetch?: typeof fetch;
+    headers?: Record<string, string>;
+}
+
+type Params = Record<string, string | number | boolean | undefined>;
+
+interface RequestOptions {
+    query?: Params;
+    form?: Params;
+    body?: unknown;
+    response?: 'json' | 'text';
+}
+
+export class BridgeAPIError extends Error {
+    readonly status: number;
+
+    constructor(status: number, message: string) {
+        super(` + "`${status}: ${message}`" + `);
+        this.name = 'BridgeAPIError';
+        this.status = status;
+    }
+}
+
+function defined(params: Params): Record<string, string> {
+    const values: Record<string, string> = {};
+    for (const [name, value] of Object.entries(params)) {
+        if (value !== undefined) {
+            values[name] = String(value);
+        }
+    }
+    return values;
+}
+
+abstract class BaseClient {
+    protected readonly baseUrl: string;
+    protected readonly options: ClientOptions;
+
+    constructor(baseUrl: string, options: ClientOptions = {}) {
+        this.baseUrl = baseUrl.replace(/\/+$/, '');
+        this.options = options;
+    }
+
+    protected authorize(url: URL, headers: Record<string, string>): void {
+        if (this.options.token) {
+            headers['Authorization'] = ` + "`Bearer ${this.options.token}`" + `;
+        }
+    }
+
+    protected async request<T>(method: string, path: string, options: RequestOptions = {}): Promise<T> {
+        const url = new URL(this.baseUrl + path);
+        for (const [name, value] of Object.entries(defined(options.query ?? {}))) {
+            url.searchParams.set(name, value);
+        }
+        const headers: Record<string, string> = { ...this.options.headers };
+        this.authorize(url, headers);
+
+        let body: string | undefined;
+        if (options.form) {
+            headers['Content-Type'] = 'application/x-www-form-urlencoded';
+            body = new URLSearchParams(defined(options.form)).toString();
+        } else if (options.body !== undefined) {
+            headers['Content-Type'] = 'application/json';
+            body = JSON.stringify(options.body);
+        }
+
+        const response = await (this.options.fetch ?? fetch)(url.toString(), { method, headers, body });
+        if (!response.ok) {
+            const text = await response.text();
+            let message = text.trim() || response.statusText;
+            try {
+                message = JSON.parse(text).error ?? message;
+            } catch {
+                // a plain text error
+            }
+            throw new BridgeAPIError(response.status, message);
+        }
+        if (options.response === 'json') {
+            return (await response.json()) as T;
+        }
+        if (options.response === 'text') {
+            return (await response.text()) as unknown as T;
+        }
+        return undefined as unknown as T;
+    }
+}
+`
+
+// typeScriptClient renders a fetch-based client with an interface per
+// schema component and a class per server
+func typeScriptClient(doc apiDocument) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s\n// Universal Bridge HTTP API client; see openapi.json.\n\n", generatedHeader)
+	for _, name := range doc.componentNames() {
+		schema := doc.components[name]
+		fmt.Fprintf(&b, "export interface %s {\n", name)
+		for _, field := range schema.order {
+			fmt.Fprintf(&b, "    %s;\n", tsField(schema, field))
+		}
+		b.WriteString("}\n\n")
+	}
+	b.WriteString(tsRuntime)
+
+	for _, server := range apiServers {
+		ops := doc.clientOperations(server.Name)
+		if len(ops) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n/** %s */\nexport class %s extends BaseClient {\n", server.Description, clientName(server.Name))
+		if server.Name == "webhook" {
+			b.WriteString("    protected authorize(url: URL, headers: Record<string, string>): void {\n")
+			b.WriteString("        if (this.options.token) {\n            url.searchParams.set('secret', this.options.token);\n        }\n    }\n\n")
+		}
+		for i, op := range ops {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			writeTypeScriptMethod(&b, doc, op)
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes()
+}
+
+func writeTypeScriptMethod(b *bytes.Buffer, doc apiDocument, op APIOperation) {
+	var args []string
+	path := op.Path
+	for _, param := range paramsIn(op, "path") {
+		name := tsCamel(param.Name)
+		args = append(args, name+": string")
+		path = strings.Replace(path, "{"+param.Name+"}", "${encodeURIComponent("+name+")}", 1)
+	}
+	quoted := "'" + path + "'"
+	if strings.Contains(path, "${") {
+		quoted = "`" + path + "`"
+	}
+
+	var options []string
+	if params := paramsIn(op, "query", "form"); len(params) > 0 || op.AnyFields {
+		var fields []string
+		optional := true
+		for _, param := range params {
+			field := param.Name
+			if param.Required {
+				optional = false
+			} else {
+				field += "?"
+			}
+			fields = append(fields, field+": "+tsParamType(param))
+		}
+		if op.AnyFields {
+			fields = append(fields, "[field: string]: string | undefined")
+		}
+		arg := "params: { " + strings.Join(fields, "; ") + " }"
+		if optional {
+			arg += " = {}"
+		}
+		args = append(args, arg)
+		options = append(options, formOrQuery(op)+": params")
+	}
+	if body := doc.bodies[op.ID]; body != nil {
+		args = append(args, "body: "+tsType(body))
+		options = append(options, "body")
+	}
+
+	result := "void"
+	switch {
+	case doc.responses[op.ID] != nil:
+		result = tsType(doc.responses[op.ID])
+		options = append(options, "response: 'json'")
+	case op.ContentType != "":
+		result = "string"
+		options = append(options, "response: 'text'")
+	}
+
+	call := fmt.Sprintf("this.request<%s>('%s', %s", result, op.Method, quoted)
+	if len(options) > 0 {
+		call += ", { " + strings.Join(options, ", ") + " }"
+	}
+	fmt.Fprintf(b, "    /** %s */\n", op.Summary)
+	fmt.Fprintf(b, "    %s(%s): Promise<%s> {\n        return %s);\n    }\n", op.ID, strings.Join(args, ", "), result, call)
+}
+
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true, "async": true,
+	"await": true, "break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// pyType renders a schema as a Python type hint
+func pyType(schema *apiSchema) string {
+	var t string
+	switch {
+	case schema.Ref != "":
+		t = `"` + schema.refName() + `"`
+	case schema.Type == "string":
+		t = "str"
+	case schema.Type == "integer":
+		t = "int"
+	case schema.Type == "number":
+		t = "float"
+	case schema.Type == "boolean":
+		t = "bool"
+	case schema.Type == "array":
+		t = "List[" + pyType(schema.Items) + "]"
+	case schema.Type == "object" && schema.AdditionalProperties != nil && schema.Properties == nil:
+		t = "Dict[str, " + pyType(schema.AdditionalProperties) + "]"
+	case schema.Type == "object":
+		t = "Dict[str, Any]"
+	default:
+		t = "Any"
+	}
+	if schema.Nullable {
+		t = "Optional[" + t + "]"
+	}
+	return t
+}
+
+func pyParamType(param APIParam) string {
+	switch param.Type {
+	case "integer":
+		return "int"
+	case "boolean":
+		return "bool"
+	}
+	return "str"
+}
+
+// pySnake turns an operation ID into a Python method name
+func pySnake(id string) string {
+	runes := []rune(id)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Runs of capitals such as API stay one word
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+const pyRuntime = `class BridgeAPIError(Exception):
+    """An error response from the bridge"""
+
+    def __init__(self, status: int, message: str) -> None:
+        super().__init__(f"{status}: {message}")
+        self.status = status
+        self.message = message
+
+
+def _encode(params: Dict[str, Any]) -> str:
+    values = {}
+    for name, value in params.items():
+        if value is None:
+            continue
+        if isinstance(value, bool):
+            value = "true" if value else "false"
+        values[name] = str(value)
+    return urllib.parse.urlencode(values)
+
+
+class _Client:
+    def __init__(self, base_url: str, token: Optional[str] = None, timeout: float = 30.0) -> None:
+        self._base_url = base_url.rstrip("/")
+        self._token = token
+        self._timeout = timeout
+
+    def _authorize(self, query: Dict[str, Any], headers: Dict[str, str]) -> None:
+        if self._token:
+            headers["Authorization"] = f"Bearer {self._token}"
+
+    def _request(
+        self,
+        method: str,
+        path: str,
+        query: Optional[Dict[str, Any]] = None,
+        form: Optional[Dict[str, Any]] = None,
+        body: Any = None,
+        response: Optional[str] = None,
+    ) -> Any:
+        query = dict(query or {})
+        headers: Dict[str, str] = {}
+        self._authorize(query, headers)
+        url = self._base_url + path
+        encoded = _encode(query)
+        if encoded:
+            url += "?" + encoded
+
+        data = None
+        if form is not None:
+            headers["Content-Type"] = "application/x-www-form-urlencoded"
+            data = _encode(form).encode("utf-8")
+        elif body is not None:
+            headers["Content-Type"] = "application/json"
+            data = json.dumps(body).encode("utf-8")
+
+        request = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(request, timeout=self._timeout) as reply:
+                content = reply.read()
+        except urllib.error.HTTPError as err:
+            text = err.read().decode("utf-8", "replace")
+            message = text.strip() or str(err.reason)
+            try:
+                message = json.loads(text).get("error", message)
+            except (ValueError, AttributeError):
+                pass  # a plain text error
+            raise BridgeAPIError(err.code, message) from None
+
+        if response == "json":
+            return json.loads(content)
+        if response == "text":
+            return content.decode("utf-8")
+        return None
+`
+
+// pythonClient renders a urllib-based client with a TypedDict per schema
+// component and a class per server
+func pythonClient(doc apiDocument) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n\"\"\"Universal Bridge HTTP API client; see openapi.json.\"\"\"\n\n", generatedHeader)
+	b.WriteString("from __future__ import annotations\n\nimport json\nimport urllib.error\nimport urllib.parse\nimport urllib.request\n")
+	b.WriteString("from typing import Any, Dict, List, Optional, TypedDict\n\n")
+	for _, name := range doc.componentNames() {
+		schema := doc.components[name]
+		functional := false
+		for _, field := range schema.order {
+			if !isIdentifier(field) || pythonKeywords[field] {
+				functional = true
+			}
+		}
+		b.WriteString("\n")
+		if functional {
+			fmt.Fprintf(&b, "%s = TypedDict(\n    %q,\n    {\n", name, name)
+			for _, field := range schema.order {
+				fmt.Fprintf(&b, "        %q: %s,\n", field, pyType(schema.Properties[field]))
+			}
+			b.WriteString("    },\n    total=False,\n)\n\n")
+			continue
+		}
+		fmt.Fprintf(&b, "class %s(TypedDict, total=False):\n", name)
+		if len(schema.order) == 0 {
+			b.WriteString("    pass\n")
+		}
+		for _, field := range schema.order {
+			fmt.Fprintf(&b, "    %s: %s\n", field, pyType(schema.Properties[field]))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n" + pyRuntime)
+
+	for _, server := range apiServers {
+		ops := doc.clientOperations(server.Name)
+		if len(ops) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n\nclass %s(_Client):\n    \"\"\"%s\"\"\"\n", clientName(server.Name), server.Description)
+		if server.Name == "webhook" {
+			b.WriteString("\n    def _authorize(self, query: Dict[str, Any], headers: Dict[str, str]) -> None:\n")
+			b.WriteString("        if self._token:\n            query[\"secret\"] = self._token\n")
+		}
+		for _, op := range ops {
+			writePythonMethod(&b, doc, op)
+		}
+	}
+	return b.Bytes()
+}
+
+func writePythonMethod(b *bytes.Buffer, doc apiDocument, op APIOperation) {
+	args := []string{"self"}
+	var optional []string
+	path := op.Path
+	formatted := false
+	for _, param := range paramsIn(op, "path") {
+		args = append(args, param.Name+": str")
+		path = strings.Replace(path, "{"+param.Name+"}", "{urllib.parse.quote("+param.Name+", safe='')}", 1)
+		formatted = true
+	}
+	quoted := `"` + path + `"`
+	if formatted {
+		quoted = "f" + quoted
+	}
+
+	var options []string
+	params := paramsIn(op, "query", "form")
+	if len(params) > 0 {
+		var fields []string
+		for _, param := range params {
+			if param.Required {
+				args = append(args, param.Name+": "+pyParamType(param))
+			} else {
+				optional = append(optional, param.Name+": Optional["+pyParamType(param)+"] = None")
+			}
+			fields = append(fields, fmt.Sprintf("%q: %s", param.Name, param.Name))
+		}
+		values := "{" + strings.Join(fields, ", ") + "}"
+		if op.AnyFields {
+			values = "{" + strings.Join(fields, ", ") + ", **fields}"
+		}
+		options = append(options, formOrQuery(op)+"="+values)
+	}
+	if op.AnyFields {
+		args = append(args, "fields: Dict[str, str]")
+	}
+	if body := doc.bodies[op.ID]; body != nil {
+		args = append(args, "body: "+strings.Trim(pyType(body), `"`))
+		options = append(options, "body=body")
+	}
+	if len(optional) > 0 {
+		args = append(args, "*")
+		args = append(args, optional...)
+	}
+
+	result := "None"
+	switch {
+	case doc.responses[op.ID] != nil:
+		result = strings.Trim(pyType(doc.responses[op.ID]), `"`)
+		options = append(options, `response="json"`)
+	case op.ContentType != "":
+		result = "str"
+		options = append(options, `response="text"`)
+	}
+
+	call := fmt.Sprintf("self._request(%q, %s", op.Method, quoted)
+	if len(options) > 0 {
+		call += ", " + strings.Join(options, ", ")
+	}
+	fmt.Fprintf(b, "\n    def %s(%s) -> %s:\n", pySnake(op.ID), strings.Join(args, ", "), result)
+	fmt.Fprintf(b, "        \"\"\"%s\"\"\"\n", op.Summary)
+	fmt.Fprintf(b, "        return self._request(%s)\n", strings.TrimPrefix(call, "self._request("))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock abstracts the wall clock so timestamps and timers can be controlled in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// IDGenerator abstracts message ID creation
+type IDGenerator interface {
+	NewID() string
+}
+
+// systemClock is the real wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// uuidGenerator produces random UUIDv4 message IDs
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string { return uuid.New().String() }
+
+// Package defaults used when no clock or ID generator is injected
+var (
+	defaultClock Clock       = systemClock{}
+	defaultIDs   IDGenerator = uuidGenerator{}
+)
+
+// ManualClock is a Clock that only moves when Advance or Set is called
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewManualClock creates a manual clock starting at the given time
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that fires once the clock has been advanced past d
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	deadline := c.now.Add(d)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{deadline: deadline, ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires any timers that are now due
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.fireDue()
+	c.mu.Unlock()
+}
+
+// Set jumps the clock to t and fires any timers that are now due
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.fireDue()
+	c.mu.Unlock()
+}
+
+// Pending returns the number of timers that have not fired yet
+func (c *ManualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// fireDue fires due timers in deadline order; callers must hold c.mu
+func (c *ManualClock) fireDue() {
+	sort.Slice(c.waiters, func(i, j int) bool {
+		return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+	})
+
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = remaining
+}
+
+// SequentialIDs generates predictable IDs such as "msg-000001"
+type SequentialIDs struct {
+	mu     sync.Mutex
+	prefix string
+	next   int
+}
+
+// NewSequentialIDs creates a sequential ID generator with the given prefix
+func NewSequentialIDs(prefix string) *SequentialIDs {
+	if prefix == "" {
+		prefix = "msg"
+	}
+	return &SequentialIDs{prefix: prefix, next: 1}
+}
+
+// NewID returns the next ID in the sequence
+func (s *SequentialIDs) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := fmt.Sprintf("%s-%06d", s.prefix, s.next)
+	s.next++
+	return id
+}
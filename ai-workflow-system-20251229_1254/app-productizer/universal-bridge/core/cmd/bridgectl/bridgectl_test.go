@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChecksumMatchesBridge checks the checksum against the bridge's own
+// golden messages
+func TestChecksumMatchesBridge(t *testing.T) {
+	paths, err := filepath.Glob("../../testdata/golden/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no golden messages: %v", err)
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m message
+		if err = json.Unmarshal(content, &m); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := m.checksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != m.Checksum {
+			t.Errorf("%s: checksum %s, want %s", path, sum, m.Checksum)
+		}
+	}
+}
+
+func TestBuildPayload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "payload.json")
+	if err := ioutil.WriteFile(file, []byte(`{"from": "file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		flag    string
+		fields  []string
+		stdin   string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{"empty", "", nil, "", map[string]interface{}{}, ""},
+		{"fields", "", []string{"price=29", "name=Ada", "tags=[\"a\"]", "note="}, "",
+			map[string]interface{}{"price": 29.0, "name": "Ada", "tags": []interface{}{"a"}, "note": ""}, ""},
+		{"json with override", `{"price": 10, "email": "ada@example.com"}`, []string{"price=12"}, "",
+			map[string]interface{}{"price": 12.0, "email": "ada@example.com"}, ""},
+		{"file", "@" + file, nil, "", map[string]interface{}{"from": "file"}, ""},
+		{"stdin", "-", nil, `{"from": "stdin"}`, map[string]interface{}{"from": "stdin"}, ""},
+		{"not an object", "[1]", nil, "", nil, "not a JSON object"},
+		{"bad field", "", []string{"price"}, "", nil, `bad payload field "price"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := buildPayload(tt.flag, tt.fields, strings.NewReader(tt.stdin))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(payload, tt.want) {
+				t.Errorf("payload %v, want %v", payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayCopy(t *testing.T) {
+	processed := filepath.Join(t.TempDir(), "processed")
+	original := &message{ID: "py-000007", Timestamp: "2026-01-15T09:30:00Z", MessageType: "data_sync", SourceLanguage: "python",
+		Payload: map[string]interface{}{"sale_id": "s1"}, Sequence: 7}
+	original.Checksum, _ = original.checksum()
+	content, err := original.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = deliverFile(processed, "py-000007.json", content); err != nil {
+		t.Fatal(err)
+	}
+	if err = deliverFile(processed, "py-000007.json", content); err == nil {
+		t.Error("delivered over an existing file")
+	}
+
+	path, err := processedFile(processed, "py-000007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	replay, err := replayCopy(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID == original.ID || !strings.HasPrefix(replay.ID, "py-000007-replay-") || replay.Headers[replayOfHeader] != "py-000007" || replay.Sequence != 0 {
+		t.Errorf("replay %+v", replay)
+	}
+	if sum, _ := replay.checksum(); sum != replay.Checksum || sum == original.Checksum {
+		t.Errorf("replay checksum %s, want a fresh %s", replay.Checksum, sum)
+	}
+
+	// Replaying a replay names the first message, not the copy
+	content, _ = replay.encode()
+	if err = deliverFile(processed, replay.ID+".json", content); err != nil {
+		t.Fatal(err)
+	}
+	again, err := replayCopy(filepath.Join(processed, replay.ID+".json"), now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Headers[replayOfHeader] != "py-000007" || !strings.HasPrefix(again.ID, "py-000007-replay-") {
+		t.Errorf("second replay %+v", again)
+	}
+
+	if _, err = processedFile(processed, "../secrets"); err == nil {
+		t.Error("found a file outside the processed directory")
+	}
+	if _, err = processedFile(processed, "py-000008"); err == nil {
+		t.Error("found a missing message")
+	}
+}
+
+func TestTailSkipsMessagesAlreadyPrinted(t *testing.T) {
+	at := time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)
+	stored := func(id string, seen time.Time) storedMessage {
+		return storedMessage{ID: id, Direction: "received", MessageType: "data_sync", SourceLanguage: "python",
+			Status: "handled", Payload: map[string]interface{}{"note": "<b>"}, FirstSeen: seen}
+	}
+
+	var tail tailer
+	var out bytes.Buffer
+	tail.print(&out, []storedMessage{stored("a", at), stored("b", at)}, false)
+	// Since is inclusive, so the next poll returns a and b again
+	tail.print(&out, []storedMessage{stored("a", at), stored("b", at), stored("c", at), stored("d", at.Add(time.Second))}, false)
+	tail.print(&out, []storedMessage{stored("d", at.Add(time.Second))}, false)
+
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		ids = append(ids, fields[4])
+		if !strings.HasSuffix(line, `{"note":"<b>"}`) {
+			t.Errorf("line %q", line)
+		}
+	}
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("printed %v, want %v", ids, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/graphql":
+			var req struct {
+				Query string `json:"query"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Query != statsQuery {
+				t.Errorf("query %q", req.Query)
+			}
+			// As the bridge answers with its message store off
+			w.Write([]byte(`{"data":{"sent":null,"send_failures":null,"received":null,"handled":null,"handler_failures":null,"unhandled":null,"filtered":null,
+				"sales":{"total_count":57},"refunds":{"total_count":2},"products":{"total_count":3}},
+				"errors":[{"message":"the message store is off","path":["sent"]},{"message":"the message store is off","path":["received"]}]}`))
+		case "/dead-letters":
+			w.Write([]byte(`{"dead_letters":[{"status":"retrying"},{"status":"dead"},{"status":"retrying"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	stats, warnings, err := fetchStats(&apiClient{BaseURL: server.URL + "/", Token: "secret", client: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Authorization %q", authorization)
+	}
+	if !reflect.DeepEqual(warnings, []string{"the message store is off"}) {
+		t.Errorf("warnings %v", warnings)
+	}
+	var out bytes.Buffer
+	printStats(&out, stats)
+	want := "💀 Dead letters: 1 dead, 2 retrying\n💰 Sales:        57 (2 refunded) of 3 products\n"
+	if out.String() != want {
+		t.Errorf("stats\n%s\nwant\n%s", out.String(), want)
+	}
+
+	err = (&apiClient{BaseURL: server.URL, client: server.Client()}).do("GET", "/nope", nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "GET /nope: Not Found") {
+		t.Errorf("error %v", err)
+	}
+}
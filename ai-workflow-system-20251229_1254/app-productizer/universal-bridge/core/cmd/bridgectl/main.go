@@ -0,0 +1,147 @@
+// Command bridgectl sends, tails and replays Universal Bridge messages and
+// reports the bridge's counts, so operators can work with a running bridge
+// without writing Go. It reaches the bridge through its data directory, its
+// seller API (-api) and its peer endpoint (-http).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: bridgectl <command> [flags]
+
+Commands:
+  send    construct a message and deliver it to the bridge
+  tail    print messages as the bridge receives them
+  replay  re-dispatch dead letters or processed messages
+  stats   message, dead letter and sales counts
+
+Run bridgectl <command> -h for the command's flags.
+`
+
+func main() {
+	log.SetFlags(0)
+	commands := map[string]func([]string) error{
+		"send":   runSend,
+		"tail":   runTail,
+		"replay": runReplay,
+		"stats":  runStats,
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	switch name := os.Args[1]; name {
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		run, ok := commands[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "bridgectl: unknown command %q\n\n%s", name, usage)
+			os.Exit(2)
+		}
+		if err := run(os.Args[2:]); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+}
+
+// newFlagSet creates a subcommand's flags, with its usage line
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bridgectl %s %s\n\n", name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// apiClient calls the bridge's seller API
+type apiClient struct {
+	BaseURL string
+	Token   string
+	client  *http.Client
+}
+
+func addAPIFlags(fs *flag.FlagSet) *apiClient {
+	api := &apiClient{client: &http.Client{Timeout: 30 * time.Second}}
+	fs.StringVar(&api.BaseURL, "api", envOr("BRIDGECTL_API", "http://localhost:8081"), "base URL of the bridge's seller API, its -api address; defaults to $BRIDGECTL_API")
+	fs.StringVar(&api.Token, "token", os.Getenv("BRIDGE_API_TOKEN"), "seller API token; defaults to $BRIDGE_API_TOKEN")
+	return api
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON
+// response into out
+func (api *apiClient) do(method, path string, query url.Values, body, out interface{}) error {
+	target := strings.TrimSuffix(api.BaseURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if api.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+api.Token)
+	}
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach the bridge API: %v", err)
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, 32<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s %s: %v", method, path, err)
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, responseError(response.StatusCode, content))
+	}
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("bad response from %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+// responseError is the bridge's {"error": ...} message, or the status
+func responseError(status int, content []byte) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(content, &body) == nil && body.Error != "" {
+		return fmt.Sprintf("%s (%d)", body.Error, status)
+	}
+	return http.StatusText(status)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// message is a UniversalMessage as the bridge reads it
+type message struct {
+	ID              string                 `json:"id"`
+	Timestamp       string                 `json:"timestamp"`
+	MessageType     string                 `json:"message_type"`
+	SourceLanguage  string                 `json:"source_language"`
+	TargetLanguage  string                 `json:"target_language"`
+	Payload         map[string]interface{} `json:"payload"`
+	ResponseChannel string                 `json:"response_channel"`
+	Checksum        string                 `json:"checksum"`
+	Sequence        uint64                 `json:"sequence,omitempty"`
+	Headers         map[string]string      `json:"headers,omitempty"`
+}
+
+// checksum is the MD5 the bridge verifies: the ID, timestamp and type, then
+// the payload as compact JSON with sorted keys and unescaped HTML
+func (m *message) checksum() (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString(m.ID)
+	buf.WriteString(m.Timestamp)
+	buf.WriteString(m.MessageType)
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(m.Payload); err != nil {
+		return "", fmt.Errorf("failed to encode the payload: %v", err)
+	}
+	hash := md5.Sum(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// encode writes the message as the bridge writes its own files
+func (m *message) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliverFile drops a message file into an inbox. It is written under a
+// name the bridge ignores and renamed, so the bridge never claims half a
+// file.
+func deliverFile(inbox, name string, content []byte) error {
+	if err := os.MkdirAll(inbox, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", inbox, err)
+	}
+	target := filepath.Join(inbox, name)
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s is already in the inbox", target)
+	}
+	temp := filepath.Join(inbox, "."+name+".tmp")
+	if err := ioutil.WriteFile(temp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", temp, err)
+	}
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to deliver %s: %v", target, err)
+	}
+	return nil
+}
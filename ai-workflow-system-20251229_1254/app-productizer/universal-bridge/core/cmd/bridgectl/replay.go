@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayOfHeader names the message a replayed copy was made from
+const replayOfHeader = "replay-of"
+
+// runReplay requeues dead letters through the seller API, or puts copies of
+// processed inbox files back into the inbox for the bridge to handle again.
+// The bridge skips IDs it has handled, so each copy gets a new ID.
+func runReplay(args []string) error {
+	fs := newFlagSet("replay", "[-from dead-letters|processed] [-all] <message id> ...")
+	api := addAPIFlags(fs)
+	from := fs.String("from", "dead-letters", "dead-letters, retried through the API, or processed, files the bridge's file transport already handled")
+	all := fs.Bool("all", false, "replay every dead letter that has given up; only with -from dead-letters")
+	dataDir := fs.String("data-dir", envOr("BRIDGE_DATA_DIR", "bridge_messages"), "the bridge's data directory, for -from processed; defaults to $BRIDGE_DATA_DIR")
+	language := fs.String("language", envOr("BRIDGE_LANGUAGE", "go"), "the bridge's language; its inbox is <data-dir>/<language>")
+	fs.Parse(args)
+	ids := fs.Args()
+
+	switch *from {
+	case "dead-letters":
+		if *all {
+			var list struct {
+				DeadLetters []struct {
+					Message struct {
+						ID string `json:"id"`
+					} `json:"message"`
+				} `json:"dead_letters"`
+			}
+			err := api.do("GET", "/dead-letters", url.Values{"status": {"dead"}}, nil, &list)
+			if err != nil {
+				return err
+			}
+			for _, letter := range list.DeadLetters {
+				ids = append(ids, letter.Message.ID)
+			}
+		}
+		if len(ids) == 0 {
+			fmt.Println("📭 No dead letters to replay")
+			return nil
+		}
+		failed := 0
+		for _, id := range ids {
+			err := api.do("POST", "/dead-letters/"+url.PathEscape(id)+"/requeue", nil, nil, nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("🔁 Requeued dead letter %s\n", id)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d dead letters were not requeued", failed, len(ids))
+		}
+		return nil
+
+	case "processed":
+		if *all {
+			return fmt.Errorf("-all only replays dead letters; name the processed messages to replay")
+		}
+		if len(ids) == 0 {
+			fs.Usage()
+			return fmt.Errorf("replay -from processed needs message IDs")
+		}
+		inbox := filepath.Join(*dataDir, *language)
+		for _, id := range ids {
+			path, err := processedFile(filepath.Join(inbox, "processed"), id)
+			if err != nil {
+				return err
+			}
+			m, err := replayCopy(path, time.Now())
+			if err != nil {
+				return err
+			}
+			content, err := m.encode()
+			if err != nil {
+				return err
+			}
+			if err = deliverFile(inbox, m.ID+".json", content); err != nil {
+				return err
+			}
+			fmt.Printf("🔁 Replayed %s as %s into %s\n", m.Headers[replayOfHeader], m.ID, inbox)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown -from %q; use dead-letters or processed", *from)
+}
+
+// replayCopy reads a processed message and gives it a new ID and checksum.
+// Its sequence number is dropped, since the sender's sequence has moved on.
+func replayCopy(path string, now time.Time) (*message, error) {
+	if !strings.HasSuffix(path, ".json") {
+		return nil, fmt.Errorf("%s is not a JSON message; only JSON messages can be replayed", path)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var m message
+	if err = json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	original := m.ID
+	if replayOf := m.Headers[replayOfHeader]; replayOf != "" {
+		original = replayOf
+	}
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[replayOfHeader] = original
+	m.ID = original + "-replay-" + strconv.FormatInt(now.UnixNano(), 36)
+	m.Sequence = 0
+	if m.Checksum, err = m.checksum(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// processedFile finds a processed message by its file name, or by the
+// message ID its file is named after whatever the extension
+func processedFile(dir, id string) (string, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("bad message ID %q", id)
+	}
+	if _, err := os.Stat(filepath.Join(dir, id)); err == nil {
+		return filepath.Join(dir, id), nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, escapeGlob(id)+".*"))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no processed message %s in %s", id, dir)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%d processed files match %s; name the file", len(matches), id)
+}
+
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runSend builds a message and writes it into the bridge's inbox, or
+// POSTs it to the bridge's peer endpoint with -http
+func runSend(args []string) error {
+	fs := newFlagSet("send", "-type <message type> [-payload <json>] [field=value ...]")
+	messageType := fs.String("type", "", "message type, e.g. data_sync or ai_request (required)")
+	payloadFlag := fs.String("payload", "", "payload as a JSON object, @file to read it from a file, or - for stdin")
+	source := fs.String("source", "bridgectl", "source language the bridge sees the message from")
+	target := fs.String("target", envOr("BRIDGE_LANGUAGE", "go"), "language of the bridge the message is for; its inbox is <data-dir>/<target>")
+	responseChannel := fs.String("response-channel", "file_system", "channel replies go back on")
+	dataDir := fs.String("data-dir", envOr("BRIDGE_DATA_DIR", "bridge_messages"), "the bridge's data directory; defaults to $BRIDGE_DATA_DIR")
+	peer := fs.String("http", "", "base URL of the bridge's peer endpoint, its -http address, to POST the message to instead of writing a file")
+	peerToken := fs.String("http-token", os.Getenv("BRIDGE_HTTP_TOKEN"), "peer endpoint token; defaults to $BRIDGE_HTTP_TOKEN")
+	dryRun := fs.Bool("print", false, "print the message instead of sending it")
+	fs.Parse(args)
+	if *messageType == "" {
+		fs.Usage()
+		return fmt.Errorf("send needs -type")
+	}
+
+	payload, err := buildPayload(*payloadFlag, fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+	m := &message{
+		ID:              *source + "-" + uuid.NewString(),
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		MessageType:     *messageType,
+		SourceLanguage:  *source,
+		TargetLanguage:  *target,
+		Payload:         payload,
+		ResponseChannel: *responseChannel,
+	}
+	if m.Checksum, err = m.checksum(); err != nil {
+		return err
+	}
+	content, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *dryRun:
+		os.Stdout.Write(content)
+	case *peer != "":
+		if err = postMessage(*peer, *peerToken, content); err != nil {
+			return err
+		}
+		fmt.Printf("📤 Delivered %s message %s to %s\n", m.MessageType, m.ID, *peer)
+	default:
+		inbox := filepath.Join(*dataDir, *target)
+		if err = deliverFile(inbox, m.ID+".json", content); err != nil {
+			return err
+		}
+		fmt.Printf("📤 Wrote %s message %s to %s\n", m.MessageType, m.ID, inbox)
+	}
+	return nil
+}
+
+// buildPayload reads the -payload object, then sets each field=value
+// argument on it. A value that is valid JSON is used as that JSON, so
+// price=29 is a number and name=Ada a string.
+func buildPayload(flagValue string, fields []string, stdin io.Reader) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	var content []byte
+	var err error
+	switch {
+	case flagValue == "-":
+		content, err = ioutil.ReadAll(stdin)
+	case strings.HasPrefix(flagValue, "@"):
+		content, err = ioutil.ReadFile(flagValue[1:])
+	default:
+		content = []byte(flagValue)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the payload: %v", err)
+	}
+	if len(bytes.TrimSpace(content)) > 0 {
+		if err = json.Unmarshal(content, &payload); err != nil {
+			return nil, fmt.Errorf("the payload is not a JSON object: %v", err)
+		}
+		if payload == nil {
+			payload = make(map[string]interface{})
+		}
+	}
+
+	for _, field := range fields {
+		name, raw, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad payload field %q; use name=value", field)
+		}
+		var value interface{}
+		if json.Unmarshal([]byte(raw), &value) != nil {
+			value = raw
+		}
+		payload[name] = value
+	}
+	return payload, nil
+}
+
+// postMessage sends an encoded message to a peer endpoint, which answers
+// once the bridge has handled it
+func postMessage(base, token string, content []byte) error {
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(base, "/")+"/messages", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := (&http.Client{Timeout: 2 * time.Minute}).Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach the peer endpoint: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("the bridge refused the message: %s", responseError(response.StatusCode, body))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// statsQuery counts messages, sales and products in one GraphQL request
+const statsQuery = `{
+  sent: messages(direction: "sent", first: 0) { total_count }
+  send_failures: messages(direction: "sent", status: "failed", first: 0) { total_count }
+  received: messages(direction: "received", first: 0) { total_count }
+  handled: messages(direction: "received", status: "handled", first: 0) { total_count }
+  handler_failures: messages(direction: "received", status: "failed", first: 0) { total_count }
+  unhandled: messages(direction: "received", status: "unhandled", first: 0) { total_count }
+  filtered: messages(direction: "received", status: "filtered", first: 0) { total_count }
+  sales(first: 0) { total_count }
+  refunds: sales(refunded: true, first: 0) { total_count }
+  products(first: 0) { total_count }
+}`
+
+// bridgeStats is what stats reports; message counts are missing while the
+// bridge's message store is off
+type bridgeStats struct {
+	Messages    map[string]int `json:"messages,omitempty"`
+	Sales       int            `json:"sales"`
+	Refunds     int            `json:"refunds"`
+	Products    int            `json:"products"`
+	DeadLetters map[string]int `json:"dead_letters"` // by status
+}
+
+// runStats prints the bridge's message, dead letter and sales counts
+func runStats(args []string) error {
+	fs := newFlagSet("stats", "[-json]")
+	api := addAPIFlags(fs)
+	jsonOut := fs.Bool("json", false, "print the counts as JSON")
+	fs.Parse(args)
+
+	stats, warnings, err := fetchStats(api)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "⚠️ %s\n", warning)
+	}
+	if *jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	printStats(os.Stdout, stats)
+	return nil
+}
+
+func fetchStats(api *apiClient) (bridgeStats, []string, error) {
+	var result struct {
+		Data map[string]*struct {
+			TotalCount int `json:"total_count"`
+		} `json:"data"`
+		Errors []struct {
+			Message string        `json:"message"`
+			Path    []interface{} `json:"path"`
+		} `json:"errors"`
+	}
+	err := api.do("POST", "/graphql", nil, map[string]string{"query": statsQuery}, &result)
+	if err != nil {
+		return bridgeStats{}, nil, err
+	}
+
+	// The message counts all fail together when the store is off
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, e := range result.Errors {
+		if !seen[e.Message] {
+			warnings = append(warnings, e.Message)
+			seen[e.Message] = true
+		}
+	}
+	count := func(name string) (int, bool) {
+		if field := result.Data[name]; field != nil {
+			return field.TotalCount, true
+		}
+		return 0, false
+	}
+
+	stats := bridgeStats{DeadLetters: map[string]int{}}
+	for _, name := range []string{"sent", "send_failures", "received", "handled", "handler_failures", "unhandled", "filtered"} {
+		if n, ok := count(name); ok {
+			if stats.Messages == nil {
+				stats.Messages = make(map[string]int)
+			}
+			stats.Messages[name] = n
+		}
+	}
+	stats.Sales, _ = count("sales")
+	stats.Refunds, _ = count("refunds")
+	stats.Products, _ = count("products")
+
+	var letters struct {
+		DeadLetters []struct {
+			Status string `json:"status"`
+		} `json:"dead_letters"`
+	}
+	if err = api.do("GET", "/dead-letters", nil, nil, &letters); err != nil {
+		return bridgeStats{}, nil, err
+	}
+	for _, letter := range letters.DeadLetters {
+		stats.DeadLetters[letter.Status]++
+	}
+	return stats, warnings, nil
+}
+
+func printStats(w io.Writer, stats bridgeStats) {
+	if stats.Messages != nil {
+		m := stats.Messages
+		fmt.Fprintf(w, "📤 Sent:         %d (%d failed)\n", m["sent"], m["send_failures"])
+		fmt.Fprintf(w, "📥 Received:     %d (%d handled, %d failed, %d unhandled, %d filtered)\n", m["received"], m["handled"], m["handler_failures"], m["unhandled"], m["filtered"])
+	}
+	var statuses []string
+	for status := range stats.DeadLetters {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	var letters []string
+	for _, status := range statuses {
+		letters = append(letters, fmt.Sprintf("%d %s", stats.DeadLetters[status], status))
+	}
+	if len(letters) == 0 {
+		letters = []string{"none"}
+	}
+	fmt.Fprintf(w, "💀 Dead letters: %s\n", strings.Join(letters, ", "))
+	fmt.Fprintf(w, "💰 Sales:        %d (%d refunded) of %d products\n", stats.Sales, stats.Refunds, stats.Products)
+}
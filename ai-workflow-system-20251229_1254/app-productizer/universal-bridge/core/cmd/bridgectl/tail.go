@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// storedMessage is a message as the bridge's message store returns it
+type storedMessage struct {
+	ID             string                 `json:"id"`
+	Direction      string                 `json:"direction"`
+	MessageType    string                 `json:"message_type"`
+	SourceLanguage string                 `json:"source_language"`
+	TargetLanguage string                 `json:"target_language"`
+	Channel        string                 `json:"channel"`
+	CorrelationID  string                 `json:"correlation_id"`
+	Status         string                 `json:"status"`
+	Attempts       int                    `json:"attempts"`
+	Error          string                 `json:"error,omitempty"`
+	Timestamp      string                 `json:"timestamp"`
+	Payload        map[string]interface{} `json:"payload"`
+	FirstSeen      time.Time              `json:"first_seen"`
+	LastSeen       time.Time              `json:"last_seen"`
+}
+
+// runTail prints the latest messages from the bridge's message store, then
+// polls it for new ones until interrupted
+func runTail(args []string) error {
+	fs := newFlagSet("tail", "[-n 10] [-type <message type>] [-direction received]")
+	api := addAPIFlags(fs)
+	lines := fs.Int("n", 10, "messages to print before following")
+	messageType := fs.String("type", "", "only messages of this type")
+	direction := fs.String("direction", "received", "received, sent, or empty for both")
+	status := fs.String("status", "", "only messages with this status, e.g. failed")
+	follow := fs.Bool("f", true, "keep printing new messages; -f=false exits after the first ones")
+	interval := fs.Duration("interval", time.Second, "how often to poll for new messages")
+	jsonOut := fs.Bool("json", false, "print each message as a line of JSON")
+	fs.Parse(args)
+
+	filter := url.Values{}
+	for name, value := range map[string]string{"type": *messageType, "direction": *direction, "status": *status} {
+		if value != "" {
+			filter.Set(name, value)
+		}
+	}
+	fetch := func(since time.Time, limit int) ([]storedMessage, error) {
+		query := url.Values{}
+		for name, values := range filter {
+			query[name] = values
+		}
+		if !since.IsZero() {
+			query.Set("since", since.Format(time.RFC3339Nano))
+		}
+		query.Set("limit", strconv.Itoa(limit))
+		var page struct {
+			Messages []storedMessage `json:"messages"`
+		}
+		err := api.do("GET", "/messages", query, nil, &page)
+		// Newest first from the store; oldest first on screen
+		for i, j := 0, len(page.Messages)-1; i < j; i, j = i+1, j-1 {
+			page.Messages[i], page.Messages[j] = page.Messages[j], page.Messages[i]
+		}
+		return page.Messages, err
+	}
+
+	var tail tailer
+	if *lines > 0 {
+		messages, err := fetch(time.Time{}, *lines)
+		if err != nil {
+			return err
+		}
+		tail.print(os.Stdout, messages, *jsonOut)
+	}
+	if !*follow {
+		return nil
+	}
+	if tail.since.IsZero() {
+		tail.since = time.Now().Add(-*interval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		messages, err := fetch(tail.since, 500)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+			continue
+		}
+		tail.print(os.Stdout, messages, *jsonOut)
+	}
+}
+
+// tailer remembers where the last poll ended. Since is inclusive, so the
+// messages first seen at that instant are remembered too.
+type tailer struct {
+	since  time.Time
+	atLast map[string]bool
+}
+
+func (t *tailer) print(w io.Writer, messages []storedMessage, jsonOut bool) {
+	for _, m := range messages {
+		if m.FirstSeen.Equal(t.since) && t.atLast[m.ID+m.Direction] {
+			continue
+		}
+		if m.FirstSeen.After(t.since) {
+			t.since = m.FirstSeen
+			t.atLast = make(map[string]bool)
+		}
+		if t.atLast == nil {
+			t.atLast = make(map[string]bool)
+		}
+		t.atLast[m.ID+m.Direction] = true
+
+		if jsonOut {
+			line, _ := json.Marshal(m)
+			fmt.Fprintf(w, "%s\n", line)
+			continue
+		}
+		fmt.Fprintln(w, formatMessage(m))
+	}
+}
+
+// formatMessage is a one-line summary of a message, its payload cut short
+func formatMessage(m storedMessage) string {
+	arrow, peer := "←", m.SourceLanguage
+	if m.Direction == "sent" {
+		arrow, peer = "→", m.TargetLanguage
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(m.Payload)
+	payload := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if len(payload) > 120 {
+		payload = append(payload[:117], "..."...)
+	}
+	line := fmt.Sprintf("%s %s %-14s %s %s [%s] %s", m.FirstSeen.Local().Format("15:04:05"), arrow, m.MessageType, peer, m.ID, m.Status, payload)
+	if m.Error != "" {
+		line += " ❌ " + m.Error
+	}
+	return line
+}
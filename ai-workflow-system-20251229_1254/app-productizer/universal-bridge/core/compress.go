@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// GzipEncoding is the content encoding every bridge can decompress
+const GzipEncoding = "gzip"
+
+// Compressor compresses payloads for one content encoding. zstd and others
+// need a library this module does not vendor; a build that has one can add
+// it with RegisterCompressor.
+type Compressor interface {
+	Compress(w io.Writer) (io.WriteCloser, error)
+	Decompress(r io.Reader) (io.ReadCloser, error)
+	ContentEncoding() string
+}
+
+// GzipCompressor is gzip at the default level
+type GzipCompressor struct{}
+
+// Compress returns a gzip writer on w
+func (GzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// Decompress returns a gzip reader on r
+func (GzipCompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ContentEncoding returns gzip
+func (GzipCompressor) ContentEncoding() string {
+	return GzipEncoding
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{GzipEncoding: GzipCompressor{}}
+)
+
+// RegisterCompressor makes a content encoding available for sending and
+// receiving by its name
+func RegisterCompressor(compressor Compressor) error {
+	encoding := compressor.ContentEncoding()
+	if encoding == "" {
+		return fmt.Errorf("compressor has no content encoding")
+	}
+
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if _, exists := compressors[encoding]; exists {
+		return fmt.Errorf("compressor %s is already registered", encoding)
+	}
+	compressors[encoding] = compressor
+	return nil
+}
+
+// CompressorFor returns the compressor registered for a content encoding
+func CompressorFor(encoding string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	compressor, ok := compressors[encoding]
+	if !ok {
+		names := make([]string, 0, len(compressors))
+		for name := range compressors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown content encoding %q; registered: %v", encoding, names)
+	}
+	return compressor, nil
+}
+
+// compressPayload returns the message as sent with its payload compressed,
+// or the message itself if its canonical payload is no longer than over
+// bytes or would not shrink. The compressed payload is {"data": base64}; the
+// checksum stays that of the original payload.
+func compressPayload(message *UniversalMessage, encoding string, over int64) (*UniversalMessage, error) {
+	if encoding == "" || message.ContentEncoding != "" {
+		return message, nil
+	}
+	compressor, err := CompressorFor(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	err = buf.compact.Encode(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	canonical := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if int64(len(canonical)) <= over {
+		return message, nil
+	}
+
+	var compressed bytes.Buffer
+	w, err := compressor.Compress(&compressed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(canonical)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compressing payload of %s: %v", message.ID, err)
+	}
+	if base64.StdEncoding.EncodedLen(compressed.Len()) >= len(canonical) {
+		return message, nil
+	}
+
+	sent := *message
+	sent.Payload = map[string]interface{}{"data": base64.StdEncoding.EncodeToString(compressed.Bytes())}
+	sent.ContentEncoding = encoding
+	return &sent, nil
+}
+
+// decompressPayload restores a received message's compressed payload in
+// place so the checksum can be verified. A payload that would decompress to
+// more than limit bytes fails with a PayloadTooLargeError; limit <= 0 means
+// no limit.
+func decompressPayload(message *UniversalMessage, limit int64) error {
+	if message.ContentEncoding == "" {
+		return nil
+	}
+	compressor, err := CompressorFor(message.ContentEncoding)
+	if err != nil {
+		return err
+	}
+	encoded, ok := message.Payload["data"].(string)
+	if !ok {
+		return fmt.Errorf("%s payload has no data", message.ContentEncoding)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%s payload: %v", message.ContentEncoding, err)
+	}
+
+	r, err := compressor.Decompress(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s payload: %v", message.ContentEncoding, err)
+	}
+	defer r.Close()
+	var reader io.Reader = r
+	if limit > 0 {
+		reader = &limitedReader{r: r, remaining: limit}
+	}
+	canonical, err := ioutil.ReadAll(reader)
+	if errors.Is(err, errReadLimit) {
+		return &PayloadTooLargeError{MessageID: message.ID, Size: -1, Limit: limit}
+	}
+	if err != nil {
+		return fmt.Errorf("%s payload: %v", message.ContentEncoding, err)
+	}
+
+	var payload map[string]interface{}
+	err = json.Unmarshal(canonical, &payload)
+	if err != nil {
+		return fmt.Errorf("%s payload: %v", message.ContentEncoding, err)
+	}
+	message.Payload = payload
+	message.ContentEncoding = ""
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressedPayloads(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	config := DefaultPipelineConfig()
+	config.Compression = GzipEncoding
+	config.CompressOver = 1024
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithPipelineConfig(config))
+	t.Cleanup(func() { gb.Close() })
+
+	source := strings.Repeat("def greet(name):\n    return f\"Hello, {name}!\"\n", 2000)
+	large := gb.NewMessage(CodeTranslation, "python", map[string]interface{}{"source_language": "go", "code": source}, SharedMemory)
+	small := gb.NewMessage(CodeTranslation, "python", map[string]interface{}{"code": "x = 1"}, SharedMemory)
+	for _, message := range []*UniversalMessage{large, small} {
+		if _, err := gb.SendMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := transport.Sent()
+	if sent[1] != small {
+		t.Errorf("small payload was not sent as it was: %+v", sent[1])
+	}
+	wire := sent[0]
+	if wire.ContentEncoding != GzipEncoding || len(wire.Payload) != 1 || wire.Checksum != large.Checksum {
+		t.Fatalf("sent %s %v", wire.ContentEncoding, wire.Payload)
+	}
+	if large.ContentEncoding != "" || large.Payload["code"] != source {
+		t.Error("compressing changed the caller's message")
+	}
+
+	for _, serializer := range []Serializer{JSONSerializer{}, ProtobufSerializer{}, MsgpackSerializer{}} {
+		data, err := serializer.Marshal(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > len(source)/10 {
+			t.Errorf("%s: %d bytes for %d of code", serializer.ContentType(), len(data), len(source))
+		}
+		received, err := decodeEnvelope(bytes.NewReader(data), serializer.ContentType(), config.MaxMessageBytes)
+		if err != nil {
+			t.Fatalf("%s: %v", serializer.ContentType(), err)
+		}
+		if received.ContentEncoding != "" || !reflect.DeepEqual(received.Payload, large.Payload) {
+			t.Errorf("%s decoded %s %.60v", serializer.ContentType(), received.ContentEncoding, received.Payload)
+		}
+
+		// The limit is on what the payload expands to
+		_, err = decodeEnvelope(bytes.NewReader(data), serializer.ContentType(), int64(len(data))+1024)
+		if !errors.Is(err, ErrPayloadTooLarge) {
+			t.Errorf("%s under a small limit: %v", serializer.ContentType(), err)
+		}
+	}
+
+	unknown := *wire
+	unknown.ContentEncoding = "zstd"
+	data, err := JSONSerializer{}.Marshal(&unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromJSON(string(data)); err == nil || !strings.Contains(err.Error(), `unknown content encoding "zstd"`) {
+		t.Errorf("zstd payload: %v", err)
+	}
+}
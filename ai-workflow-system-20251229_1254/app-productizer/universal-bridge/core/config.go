@@ -0,0 +1,1259 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultDataDir holds the message directories and every store's files
+	DefaultDataDir = "bridge_messages"
+	// DefaultBridgeURL is the WebSocket hub the language bridges share
+	DefaultBridgeURL = "ws://localhost:8765"
+
+	configEnv         = "BRIDGE_CONFIG"
+	dataDirEnv        = "BRIDGE_DATA_DIR"
+	languageEnv       = "BRIDGE_LANGUAGE"
+	bridgeURLEnv      = "BRIDGE_URL"
+	inboxDirEnv       = "BRIDGE_INBOX_DIR"
+	outboxDirEnv      = "BRIDGE_OUTBOX_DIR"
+	mirrorDirsEnv     = "BRIDGE_MIRROR_DIRS"
+	fileBatchSizeEnv  = "BRIDGE_FILE_BATCH_SIZE"
+	fileSettleEnv     = "BRIDGE_FILE_SETTLE"
+	fileReconcileEnv  = "BRIDGE_FILE_RECONCILE"
+	fileRetryDelayEnv = "BRIDGE_FILE_RETRY_DELAY"
+	httpPeerEnv       = "BRIDGE_HTTP_PEER"
+	grpcPeerEnv       = "BRIDGE_GRPC_PEER"
+	fileEncodingEnv   = "BRIDGE_FILE_ENCODING"
+	httpEncodingEnv   = "BRIDGE_HTTP_ENCODING"
+	redisEncodingEnv  = "BRIDGE_REDIS_ENCODING"
+	natsEncodingEnv   = "BRIDGE_NATS_ENCODING"
+	compressionEnv    = "BRIDGE_COMPRESSION"
+	compressOverEnv   = "BRIDGE_COMPRESS_OVER"
+	redisURLEnv       = "BRIDGE_REDIS_URL"
+	redisPrefixEnv    = "BRIDGE_REDIS_PREFIX"
+	natsURLEnv        = "BRIDGE_NATS_URL"
+	natsPrefixEnv     = "BRIDGE_NATS_PREFIX"
+	pollPendingEnv    = "BRIDGE_POLL_PENDING"
+	pollSequencesEnv  = "BRIDGE_POLL_SEQUENCES"
+	pollReceiptsEnv   = "BRIDGE_POLL_RECEIPTS"
+	pollExpiredEnv    = "BRIDGE_POLL_EXPIRED"
+	expiryActionEnv   = "BRIDGE_EXPIRY_ACTION"
+	aiRequestTTLEnv   = "BRIDGE_AI_REQUEST_TTL"
+	dedupeWindowEnv   = "BRIDGE_DEDUPE_WINDOW"
+)
+
+var languageName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Config is every setting of the bridge: where it keeps its files, how it
+// reaches the other bridges, what it serves and which features it runs,
+// and the secrets and provider keys of the services it calls. LoadConfig
+// reads it from a YAML or TOML file and then the environment, which wins;
+// command-line flags win over both.
+type Config struct {
+	// DataDir holds the message directories and every store's files
+	DataDir string `yaml:"data_dir"`
+	// Language names this bridge; its inbox is DataDir/<language>
+	Language string `yaml:"language"`
+	// BridgeURL is the WebSocket hub the language bridges share
+	BridgeURL string `yaml:"bridge_url"`
+	// Templates is the customer-facing template directory
+	Templates string `yaml:"templates"`
+	// Timezone is where expense dates and reported days fall
+	Timezone    string            `yaml:"timezone"`
+	Files       FileConfig        `yaml:"files"`
+	HTTP        HTTPConfig        `yaml:"http"`
+	GRPC        GRPCConfig        `yaml:"grpc"`
+	Redis       RedisConfig       `yaml:"redis"`
+	NATS        NATSConfig        `yaml:"nats"`
+	Compression CompressionConfig `yaml:"compression"`
+	Expiry      ExpiryConfig      `yaml:"expiry"`
+	Dedupe      DedupeConfig      `yaml:"dedupe"`
+	Poll        PollConfig        `yaml:"poll"`
+	Messages    MessageConfig     `yaml:"messages"`
+	Stores      StoreConfig       `yaml:"stores"`
+	API         APIConfig         `yaml:"api"`
+	Portal      PortalConfig      `yaml:"portal"`
+	Webhook     PingConfig        `yaml:"webhook"`
+	Email       EmailConfig       `yaml:"email"`
+	Google      GoogleConfig      `yaml:"google"`
+	Gumroad     GumroadConfig     `yaml:"gumroad"`
+	Enrichment  EnrichmentConfig  `yaml:"enrichment"`
+	Checkout    CheckoutConfig    `yaml:"checkout"`
+	Approvals   ApprovalConfig    `yaml:"approvals"`
+	Features    FeatureConfig     `yaml:"features"`
+	Secrets     SecretConfig      `yaml:"secrets"`
+	Providers   ProviderConfig    `yaml:"providers"`
+}
+
+// FileConfig is the shared-directory channel. Directories left empty are
+// placed under DataDir as the other bridges expect.
+//
+// Each channel's Encoding is what it sends: json, protobuf, msgpack or a
+// registered content type, empty being JSON; grpc is always protobuf.
+// Received messages are decoded by the encoding their file extension or
+// content type names, so peers need not agree on one.
+type FileConfig struct {
+	Inbox      string        `yaml:"inbox"`   // DataDir/<language>
+	Outbox     string        `yaml:"outbox"`  // DataDir/incoming
+	Mirrors    []string      `yaml:"mirrors"` // also written to; DataDir/outgoing
+	BatchSize  int           `yaml:"batch_size"`
+	Settle     time.Duration `yaml:"settle"`
+	Reconcile  time.Duration `yaml:"reconcile"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	Encoding   string        `yaml:"encoding"`
+}
+
+// HTTPConfig is the peer channel
+type HTTPConfig struct {
+	// Listen is the address POST /messages is served on; empty serves none
+	Listen string `yaml:"listen"`
+	// Peer is the base URL messages with an http response channel are
+	// POSTed to
+	Peer     string `yaml:"peer"`
+	Encoding string `yaml:"encoding"`
+}
+
+// GRPCConfig is the grpc channel
+type GRPCConfig struct {
+	// Listen is the address of the gRPC Bridge service; empty serves none
+	Listen string `yaml:"listen"`
+	// Peer is the host:port of the bridge whose gRPC listener messages
+	// with a grpc response channel are sent to
+	Peer string `yaml:"peer"`
+}
+
+// RedisConfig is the database channel, streams on a Redis server shared
+// by bridges on different hosts
+type RedisConfig struct {
+	// URL is redis://[[user]:password@]host[:port][/db], or rediss:// for TLS
+	URL string `yaml:"url"`
+	// Prefix is put before each language to name its stream
+	Prefix   string `yaml:"prefix"`
+	Encoding string `yaml:"encoding"`
+}
+
+// NATSConfig is the NATS channel, subjects on a NATS server shared by
+// bridges on different hosts
+type NATSConfig struct {
+	// URL is nats://[user:password@|token@]host[:port], or tls:// for TLS
+	URL string `yaml:"url"`
+	// Prefix is the first token of every subject
+	Prefix   string `yaml:"prefix"`
+	Encoding string `yaml:"encoding"`
+}
+
+// CompressionConfig is how large payloads are sent on every channel. Peers
+// decompress whatever they receive, so they need not agree on it.
+type CompressionConfig struct {
+	// Encoding is gzip or a registered content encoding; empty sends
+	// payloads uncompressed
+	Encoding string `yaml:"encoding"`
+	// Over is the canonical JSON size in bytes above which a payload is
+	// compressed
+	Over int `yaml:"over"`
+}
+
+// ExpiryConfig is what becomes of messages past their expires_at
+type ExpiryConfig struct {
+	// Action is dead_letter, keeping expired messages received as dead
+	// letters, or drop
+	Action string `yaml:"action"`
+	// AIRequestTTL expires ai_request messages sent without an expires_at
+	// that long after they are sent; zero lets them wait forever
+	AIRequestTTL time.Duration `yaml:"ai_request_ttl"`
+}
+
+// DedupeConfig is how long copies of a handled message are skipped. The
+// keys handled are kept under DataDir/dedupe, so this holds across restarts.
+type DedupeConfig struct {
+	// Window is how long after a message is handled another with its
+	// idempotency key, or its ID, is skipped
+	Window time.Duration `yaml:"window"`
+}
+
+// PollConfig is how often background sweeps run
+type PollConfig struct {
+	// PendingRequests is how often sent requests are checked for timeouts
+	PendingRequests time.Duration `yaml:"pending_requests"`
+	// Sequences is how often skipped sequence numbers are asked for again
+	Sequences time.Duration `yaml:"sequences"`
+	// Receipts is how often sent messages are checked for overdue receipts
+	Receipts time.Duration `yaml:"receipts"`
+	// Expired is how often the message directories are swept for expired
+	// messages
+	Expired time.Duration `yaml:"expired"`
+}
+
+// MessageConfig is how received messages are handled and sent ones
+// followed up
+type MessageConfig struct {
+	// MaxBytes is the largest encoded message sent or accepted
+	MaxBytes int64 `yaml:"max_bytes"`
+	// Workers is how many received messages are handled at once
+	Workers int `yaml:"workers"`
+	// TypeConcurrency gives message types workers of their own
+	TypeConcurrency map[MessageType]int `yaml:"type_concurrency"`
+	// LowPriority types are handled once no other message waits, bar one
+	// in every LowPriorityEvery
+	LowPriority      []string `yaml:"low_priority"`
+	LowPriorityEvery int      `yaml:"low_priority_every"`
+	// AllowSources limits handling to these source languages; others are
+	// dead-lettered. Empty allows all.
+	AllowSources []string `yaml:"allow_sources"`
+	// MaxAttempts is how many failed handler runs dead-letter a message
+	MaxAttempts int `yaml:"max_attempts"`
+	// RequestTimeout is how long sent AI requests and function calls wait
+	// for a reply
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// AckTimeout and AckAttempts are how long a message that asked for a
+	// receipt waits before it is resent, and how often it is sent
+	AckTimeout  time.Duration `yaml:"ack_timeout"`
+	AckAttempts int           `yaml:"ack_attempts"`
+}
+
+// StoreConfig is the files the stores keep; those left empty are placed
+// under DataDir
+type StoreConfig struct {
+	Sales string `yaml:"sales"` // DataDir/sales/sales.jsonl
+	Jobs  string `yaml:"jobs"`  // DataDir/scheduler/jobs.json
+	// Messages is the SQLite database of every sent and received message;
+	// off records none
+	Messages string `yaml:"messages"` // DataDir/store/messages.db
+}
+
+// APIConfig is the seller API, with metrics and the dashboard
+type APIConfig struct {
+	// Listen is its address; empty serves none
+	Listen string `yaml:"listen"`
+	// DashboardURL is the dashboard's public address, which approval
+	// notifications link to
+	DashboardURL string `yaml:"dashboard_url"`
+}
+
+// PortalConfig is the customer portal
+type PortalConfig struct {
+	// Listen is its address; empty serves none
+	Listen string `yaml:"listen"`
+	// URL is its public address, used in login links
+	URL string `yaml:"url"`
+}
+
+// PingConfig is the Gumroad ping receiver
+type PingConfig struct {
+	// Listen is its address; empty serves none
+	Listen string `yaml:"listen"`
+}
+
+// EmailConfig is how buyer emails are sent and support email is read. SMTP
+// and API are alternatives.
+type EmailConfig struct {
+	// SMTP is a submission server as host:port
+	SMTP string `yaml:"smtp"`
+	// API is sendgrid or mailgun, to send through that service instead
+	API           string `yaml:"api"`
+	MailgunDomain string `yaml:"mailgun_domain"`
+	From          string `yaml:"from"`
+	// PurchaseEmails emails each buyer a receipt and product delivery
+	PurchaseEmails bool `yaml:"purchase_emails"`
+	// IMAP is the support mailbox's server as host:port; empty reads none
+	IMAP        string `yaml:"imap"`
+	IMAPMailbox string `yaml:"imap_mailbox"`
+}
+
+// GoogleConfig is the Google documents the bridge keeps current
+type GoogleConfig struct {
+	// Sheet is the spreadsheet ID each sale is appended to
+	Sheet string `yaml:"sheet"`
+	// Calendar is the calendar ID of launches, seasonal sales and payouts
+	Calendar string `yaml:"calendar"`
+}
+
+// GumroadConfig is how the seller's Gumroad account is tracked
+type GumroadConfig struct {
+	// PayoutDay is the weekday Gumroad pays out on
+	PayoutDay string `yaml:"payout_day"`
+	// Snapshots is the cron schedule of account snapshots; empty takes none
+	Snapshots string `yaml:"snapshots"`
+	// SnapshotTarget is the language snapshots are sent to; empty sends none
+	SnapshotTarget string `yaml:"snapshot_target"`
+	// TrialWindow is how long a trial runs before it counts as lapsed
+	TrialWindow time.Duration `yaml:"trial_window"`
+	// LicenseTTL is how long a license key check is cached
+	LicenseTTL time.Duration `yaml:"license_ttl"`
+}
+
+// EnrichmentConfig is what is added to each sale about its buyer
+type EnrichmentConfig struct {
+	Buyers bool `yaml:"buyers"`
+	// CompanyLookup is a company API URL with {domain} in it
+	CompanyLookup string `yaml:"company_lookup"`
+}
+
+// CheckoutConfig is the follow-up of product views without a purchase
+type CheckoutConfig struct {
+	FollowUps []time.Duration `yaml:"followups"`
+	// FollowUpSegment limits follow-ups to a segment of Features.Segments
+	FollowUpSegment string `yaml:"followup_segment"`
+}
+
+// ApprovalConfig is which queued messages wait for a person to approve them
+type ApprovalConfig struct {
+	Sinks []string `yaml:"sinks"`
+	// Notify is the sinks told about each waiting approval
+	Notify []string `yaml:"notify"`
+	// TelegramChat is asked about each waiting approval
+	TelegramChat string `yaml:"telegram_chat"`
+}
+
+// FeatureConfig is the files that turn on optional features; a feature
+// whose file is empty is off
+type FeatureConfig struct {
+	AI                string `yaml:"ai"`
+	Bundles           string `yaml:"bundles"`
+	Chat              string `yaml:"chat"`
+	Discord           string `yaml:"discord"`
+	Drive             string `yaml:"drive"`
+	Entitlements      string `yaml:"entitlements"`
+	Fraud             string `yaml:"fraud"`
+	GitHub            string `yaml:"github"`
+	Launch            string `yaml:"launch"`
+	Plugins           string `yaml:"plugins"`
+	PriceWatch        string `yaml:"price_watch"`
+	Push              string `yaml:"push"`
+	Rules             string `yaml:"rules"`
+	Scripts           string `yaml:"scripts"`
+	Segments          string `yaml:"segments"`
+	SiteFeed          string `yaml:"site_feed"`
+	SMS               string `yaml:"sms"`
+	Splits            string `yaml:"splits"`
+	StreamSubscribers string `yaml:"stream_subscribers"`
+	Transforms        string `yaml:"transforms"`
+	Webhooks          string `yaml:"webhooks"`
+	WinBack           string `yaml:"winback"`
+}
+
+// SecretConfig is what callers of the bridge's own endpoints must present
+type SecretConfig struct {
+	APIToken        string `yaml:"api_token"`
+	LiveToken       string `yaml:"live_token"`
+	HTTPToken       string `yaml:"http_token"`
+	WebhookSecret   string `yaml:"webhook_secret"`
+	GumroadSellerID string `yaml:"gumroad_seller_id"`
+}
+
+// ProviderConfig is the credentials of the services the bridge calls
+type ProviderConfig struct {
+	GumroadAccessToken string `yaml:"gumroad_access_token"`
+	OpenAIKey          string `yaml:"openai_api_key"`
+	AnthropicKey       string `yaml:"anthropic_api_key"`
+	GoogleCredentials  string `yaml:"google_credentials"` // service account key file
+	AWSAccessKey       string `yaml:"aws_access_key_id"`
+	AWSSecretKey       string `yaml:"aws_secret_access_key"`
+	SMTPUsername       string `yaml:"smtp_username"`
+	SMTPPassword       string `yaml:"smtp_password"`
+	SendGridKey        string `yaml:"sendgrid_api_key"`
+	MailgunKey         string `yaml:"mailgun_api_key"`
+	IMAPUsername       string `yaml:"imap_username"`
+	IMAPPassword       string `yaml:"imap_password"`
+	TwilioAccountSID   string `yaml:"twilio_account_sid"`
+	TwilioAuthToken    string `yaml:"twilio_auth_token"`
+	NtfyToken          string `yaml:"ntfy_token"`
+	PushoverToken      string `yaml:"pushover_token"`
+	GitHubToken        string `yaml:"github_token"`
+	DiscordToken       string `yaml:"discord_bot_token"`
+	TelegramToken      string `yaml:"telegram_bot_token"`
+	CompanyLookupKey   string `yaml:"company_lookup_key"`
+	OTLPEndpoint       string `yaml:"otlp_endpoint"`
+	OTelService        string `yaml:"otel_service_name"`
+}
+
+// DefaultConfig returns the settings used when neither a file nor the
+// environment sets them
+func DefaultConfig() Config {
+	return Config{
+		DataDir:   DefaultDataDir,
+		Language:  "go",
+		BridgeURL: DefaultBridgeURL,
+		Templates: "templates",
+		Timezone:  "UTC",
+		Files: FileConfig{
+			BatchSize:  DefaultFileBatchSize,
+			Settle:     10 * time.Millisecond,
+			Reconcile:  10 * time.Second,
+			RetryDelay: time.Second,
+		},
+		Redis: RedisConfig{
+			Prefix: defaultRedisPrefix,
+		},
+		NATS: NATSConfig{
+			Prefix: defaultNATSPrefix,
+		},
+		Compression: CompressionConfig{
+			Over: int(DefaultPipelineConfig().CompressOver),
+		},
+		Expiry: ExpiryConfig{
+			Action: ExpiryDeadLetter,
+		},
+		Dedupe: DedupeConfig{
+			Window: DefaultPipelineConfig().DedupeWindow,
+		},
+		Poll: PollConfig{
+			PendingRequests: time.Second,
+			Sequences:       time.Second,
+			Receipts:        time.Second,
+			Expired:         30 * time.Second,
+		},
+		Messages: MessageConfig{
+			MaxBytes:         DefaultPipelineConfig().MaxMessageBytes,
+			Workers:          DefaultPipelineConfig().DispatchWorkers,
+			LowPriority:      []string{string(CodeTranslation)},
+			LowPriorityEvery: DefaultPipelineConfig().LowPriorityEvery,
+			MaxAttempts:      5,
+			RequestTimeout:   2 * time.Minute,
+			AckTimeout:       30 * time.Second,
+			AckAttempts:      5,
+		},
+		Portal: PortalConfig{
+			URL: "http://localhost:8080",
+		},
+		Email: EmailConfig{
+			IMAPMailbox: "INBOX",
+		},
+		Gumroad: GumroadConfig{
+			PayoutDay:      "friday",
+			SnapshotTarget: "python",
+			TrialWindow:    14 * 24 * time.Hour,
+			LicenseTTL:     time.Hour,
+		},
+	}
+}
+
+// LoadConfig reads the YAML or TOML file at path over the defaults, by its
+// extension, then applies the environment and the flags given on flags,
+// which RegisterConfigFlags made, and validates the result. An empty path
+// skips the file, and nil flags apply none.
+func LoadConfig(path string, flags *flag.FlagSet) (Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read config: %v", err)
+		}
+		err = config.decode(path, content)
+		if err != nil {
+			return config, fmt.Errorf("failed to parse config %s: %v", path, err)
+		}
+	}
+
+	err := config.applyEnv(os.LookupEnv)
+	if err != nil {
+		return config, err
+	}
+	if flags != nil {
+		err = config.applyFlags(flags)
+		if err != nil {
+			return config, err
+		}
+	}
+	config.resolve()
+	err = config.Validate()
+	if err != nil {
+		return config, err
+	}
+
+	if path != "" && config.hasSecrets() {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			fmt.Printf("⚠️ Config %s holds secrets and can be read by other users; chmod 600 it\n", path)
+		}
+	}
+	return config, nil
+}
+
+// decode reads a YAML or TOML file; TOML is converted to YAML so both
+// share the yaml field tags, and unknown keys are refused in either
+func (c *Config) decode(path string, content []byte) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		values, err := parseTOML(content)
+		if err != nil {
+			return err
+		}
+		content, err = yaml.Marshal(values)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown config format %q; use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(c)
+	// An empty file sets nothing
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// configEnvVar pairs an environment variable with the setting it overrides
+type configEnvVar struct {
+	name   string
+	target interface{}
+}
+
+func (c *Config) envVars() []configEnvVar {
+	return []configEnvVar{
+		{dataDirEnv, &c.DataDir},
+		{languageEnv, &c.Language},
+		{bridgeURLEnv, &c.BridgeURL},
+		{inboxDirEnv, &c.Files.Inbox},
+		{outboxDirEnv, &c.Files.Outbox},
+		{mirrorDirsEnv, &c.Files.Mirrors},
+		{fileBatchSizeEnv, &c.Files.BatchSize},
+		{fileSettleEnv, &c.Files.Settle},
+		{fileReconcileEnv, &c.Files.Reconcile},
+		{fileRetryDelayEnv, &c.Files.RetryDelay},
+		{fileEncodingEnv, &c.Files.Encoding},
+		{httpEncodingEnv, &c.HTTP.Encoding},
+		{redisEncodingEnv, &c.Redis.Encoding},
+		{natsEncodingEnv, &c.NATS.Encoding},
+		{compressionEnv, &c.Compression.Encoding},
+		{compressOverEnv, &c.Compression.Over},
+		{httpPeerEnv, &c.HTTP.Peer},
+		{grpcPeerEnv, &c.GRPC.Peer},
+		{redisURLEnv, &c.Redis.URL},
+		{redisPrefixEnv, &c.Redis.Prefix},
+		{natsURLEnv, &c.NATS.URL},
+		{natsPrefixEnv, &c.NATS.Prefix},
+		{pollPendingEnv, &c.Poll.PendingRequests},
+		{pollSequencesEnv, &c.Poll.Sequences},
+		{pollReceiptsEnv, &c.Poll.Receipts},
+		{pollExpiredEnv, &c.Poll.Expired},
+		{expiryActionEnv, &c.Expiry.Action},
+		{aiRequestTTLEnv, &c.Expiry.AIRequestTTL},
+		{dedupeWindowEnv, &c.Dedupe.Window},
+		{apiTokenEnv, &c.Secrets.APIToken},
+		{liveTokenEnv, &c.Secrets.LiveToken},
+		{httpTokenEnv, &c.Secrets.HTTPToken},
+		{gumroadWebhookSecretEnv, &c.Secrets.WebhookSecret},
+		{gumroadSellerEnv, &c.Secrets.GumroadSellerID},
+		{gumroadTokenEnv, &c.Providers.GumroadAccessToken},
+		{openAIKeyEnv, &c.Providers.OpenAIKey},
+		{anthropicKeyEnv, &c.Providers.AnthropicKey},
+		{googleCredentialsEnv, &c.Providers.GoogleCredentials},
+		{awsAccessKeyEnv, &c.Providers.AWSAccessKey},
+		{awsSecretKeyEnv, &c.Providers.AWSSecretKey},
+		{smtpUsernameEnv, &c.Providers.SMTPUsername},
+		{smtpPasswordEnv, &c.Providers.SMTPPassword},
+		{sendGridKeyEnv, &c.Providers.SendGridKey},
+		{mailgunKeyEnv, &c.Providers.MailgunKey},
+		{imapUsernameEnv, &c.Providers.IMAPUsername},
+		{imapPasswordEnv, &c.Providers.IMAPPassword},
+		{twilioAccountSIDEnv, &c.Providers.TwilioAccountSID},
+		{twilioAuthTokenEnv, &c.Providers.TwilioAuthToken},
+		{ntfyTokenEnv, &c.Providers.NtfyToken},
+		{pushoverTokenEnv, &c.Providers.PushoverToken},
+		{githubTokenEnv, &c.Providers.GitHubToken},
+		{discordTokenEnv, &c.Providers.DiscordToken},
+		{telegramTokenEnv, &c.Providers.TelegramToken},
+		{companyLookupKeyEnv, &c.Providers.CompanyLookupKey},
+		{otlpEndpointEnv, &c.Providers.OTLPEndpoint},
+		{otelServiceEnv, &c.Providers.OTelService},
+	}
+}
+
+// applyEnv overrides settings with the environment variables that are set
+// and not empty
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for _, env := range c.envVars() {
+		value, ok := lookup(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		err := setSetting(env.target, value)
+		if err != nil {
+			return fmt.Errorf("bad %s: %v", env.name, err)
+		}
+	}
+	return nil
+}
+
+// configFlag pairs a command-line flag with the setting it overrides
+type configFlag struct {
+	name   string
+	target interface{}
+	usage  string
+}
+
+func (c *Config) flagVars() []configFlag {
+	return []configFlag{
+		{"templates", &c.Templates, "customer-facing template directory"},
+		{"profit-timezone", &c.Timezone, "timezone of expense dates and of the days /metrics/profit, /sales and the profit_digest and weekly_review jobs report on, used with -serve"},
+		{"sales", &c.Stores.Sales, "sales store, used with -serve; defaults to <data_dir>/sales/sales.jsonl"},
+		{"jobs", &c.Stores.Jobs, "persisted scheduler jobs, used with -serve; defaults to <data_dir>/scheduler/jobs.json"},
+		{"message-store", &c.Stores.Messages, "SQLite database every sent and received message is recorded in, used with -serve; defaults to <data_dir>/store/messages.db, and off turns it off"},
+		{"portal", &c.Portal.Listen, "address for the customer portal, e.g. :8080, used with -serve"},
+		{"portal-url", &c.Portal.URL, "public portal URL used in login links"},
+		{"api", &c.API.Listen, "address for the seller API, e.g. :8081, used with -serve, with Prometheus metrics at /metrics and a dashboard at /dashboard/; needs " + apiTokenEnv + " unless it listens on loopback"},
+		{"dashboard-url", &c.API.DashboardURL, "public address of the dashboard, e.g. https://admin.example.com/dashboard/, which approval notifications link to"},
+		{"webhook", &c.Webhook.Listen, "address for the Gumroad ping receiver at /webhook/gumroad, e.g. :8082, used with -serve; set " + gumroadWebhookSecretEnv + " to the ping secret, and " + gumroadSellerEnv + " to check the seller"},
+		{"http", &c.HTTP.Listen, "address that accepts POST /messages from peer bridges, e.g. :8090, used with -serve; set " + httpTokenEnv + " to require a token"},
+		{"http-peer", &c.HTTP.Peer, "peer bridge base URL that messages with an http response channel are POSTed to, used with -serve"},
+		{"grpc", &c.GRPC.Listen, "address of the gRPC Bridge service that co-located services call to send messages and subscribe to theirs, e.g. localhost:9090, used with -serve; set " + httpTokenEnv + " to require a token"},
+		{"grpc-peer", &c.GRPC.Peer, "host:port of a bridge's gRPC listener that messages with a grpc response channel no local subscriber takes are sent to, and that this bridge subscribes to, used with -serve"},
+		{"redis", &c.Redis.URL, "Redis server whose streams carry messages with a database response channel between bridges on different hosts, e.g. redis://cache:6379/0, used with -serve"},
+		{"nats", &c.NATS.URL, "NATS server whose subjects carry messages with a nats response channel, e.g. nats://nats:4222, used with -serve"},
+		{"max-message-bytes", &c.Messages.MaxBytes, "largest encoded message sent or accepted, used with -serve"},
+		{"dispatch-workers", &c.Messages.Workers, "messages handled at once, used with -serve"},
+		{"type-concurrency", &c.Messages.TypeConcurrency, "message types handled by workers of their own, with how many at once, e.g. ai_request=2,function_call=4; used with -serve"},
+		{"low-priority", &c.Messages.LowPriority, "message types handled only once no other received message waits, bar one in every -low-priority-every, used with -serve; empty for none"},
+		{"low-priority-every", &c.Messages.LowPriorityEvery, "how often a waiting low-priority message is handled ahead of the others, so they are never starved, used with -serve"},
+		{"allow-sources", &c.Messages.AllowSources, "source languages whose received messages are handled, e.g. python,javascript,gumroad,imap; others are dead-lettered; empty allows all, used with -serve"},
+		{"max-attempts", &c.Messages.MaxAttempts, "failed handler runs after which a received message is dead-lettered, used with -serve"},
+		{"request-timeout", &c.Messages.RequestTimeout, "how long sent AI requests and function calls wait for a reply before timing out, used with -serve"},
+		{"ack-timeout", &c.Messages.AckTimeout, "how long a sent message that asked for a receipt waits for its ack before it is resent, used with -serve"},
+		{"ack-attempts", &c.Messages.AckAttempts, "how often a message that asked for a receipt is sent before it is given up on, used with -serve"},
+		{"smtp", &c.Email.SMTP, "SMTP submission server as host:port that buyer emails are sent through, used with -serve; -portal, -discord and -checkout-followups need it or -email-api; set " + smtpUsernameEnv + " and " + smtpPasswordEnv + " to log in"},
+		{"email-api", &c.Email.API, "sendgrid or mailgun to send buyer emails through that service's API instead of -smtp, used with -serve; set " + sendGridKeyEnv + " or " + mailgunKeyEnv + " to its API key"},
+		{"mailgun-domain", &c.Email.MailgunDomain, "Mailgun sending domain, required by -email-api mailgun"},
+		{"email-from", &c.Email.From, "sender address of buyer emails, e.g. \"Shop <hello@example.com>\", used with -smtp or -email-api"},
+		{"purchase-emails", &c.Email.PurchaseEmails, "email each buyer the sale_receipt template, and products/<product>/product_delivery where a product has one, once per sale, used with -smtp or -email-api"},
+		{"imap", &c.Email.IMAP, "IMAP server as host:port whose support mailbox is polled every minute for customer emails, used with -serve; set " + imapUsernameEnv + " and " + imapPasswordEnv + " to log in"},
+		{"imap-mailbox", &c.Email.IMAPMailbox, "mailbox -imap polls"},
+		{"sheet", &c.Google.Sheet, "Google spreadsheet ID that each sale is appended to as a row, used with -serve; set " + googleCredentialsEnv + " to a service account key with edit access"},
+		{"calendar", &c.Google.Calendar, "Google calendar ID that launches, seasonal sales and payout dates are kept on, used with -serve; set " + googleCredentialsEnv + " to a service account key that can edit it"},
+		{"payout-day", &c.Gumroad.PayoutDay, "weekday Gumroad pays out on, for the payout dates on the calendar and the balance in account snapshots"},
+		{"account-snapshots", &c.Gumroad.Snapshots, "cron schedule, e.g. \"0 * * * *\", on which products, sales counts, subscribers and the estimated balance are snapshotted from the Gumroad API, kept for /metrics/snapshots, used with -serve"},
+		{"snapshot-target", &c.Gumroad.SnapshotTarget, "language each account snapshot is sent to as a data_sync message; empty sends none"},
+		{"trial-window", &c.Gumroad.TrialWindow, "how long a trial runs before /metrics/trials counts it as lapsed, used with -serve"},
+		{"license-ttl", &c.Gumroad.LicenseTTL, "how long a license key check is cached before /licenses/verify asks Gumroad again, used with -serve"},
+		{"enrich-buyers", &c.Enrichment.Buyers, "store each sale's email domain type, buyer country and time zone in its buyer field, used with -serve"},
+		{"company-lookup", &c.Enrichment.CompanyLookup, "Clearbit-style company API that corporate email domains are looked up with, {domain} replaced, e.g. " + ClearbitCompanyURL + "; used with -enrich-buyers; set " + companyLookupKeyEnv + " to its key"},
+		{"checkout-followups", &c.Checkout.FollowUps, "delays after a product view without a purchase at which to email the viewer, e.g. 1h,24h; used with -serve"},
+		{"checkout-followup-segment", &c.Checkout.FollowUpSegment, "segment from -segments that checkout follow-ups are limited to"},
+		{"approve-sinks", &c.Approvals.Sinks, "sinks, e.g. email,discord, whose every queued message waits at /approvals until a person approves it, used with -serve; rule actions wait with approve: true"},
+		{"approval-notify", &c.Approvals.Notify, "sinks, e.g. slack, told about each approval that is waiting and how it was decided, used with -serve"},
+		{"telegram-chat", &c.Approvals.TelegramChat, "Telegram chat ID that is asked about each waiting approval, with buttons to approve or reject it, used with -serve; set " + telegramTokenEnv + " to the bot's token"},
+		{"ai", &c.Features.AI, "OpenAI, Anthropic and Ollama providers that answer received AI requests, write the weekly_review job's narrative and draft the automations described at /automations on -api, used with -serve; set " + openAIKeyEnv + " and " + anthropicKeyEnv + " for those providers"},
+		{"bundles", &c.Features.Bundles, "bundle → component products table, used with -serve"},
+		{"chat", &c.Features.Chat, "Discord and Slack webhooks each sale and refund is posted to, with the day's running total, per product and rate limited, used with -serve"},
+		{"discord", &c.Features.Discord, "community products whose buyers get Discord roles after /verify, used with -serve and -portal, which receives the interactions; set " + discordTokenEnv + " to the bot's token"},
+		{"drive", &c.Features.Drive, "Drive folders that generated artifacts in sent messages are uploaded to, used with -serve; set " + googleCredentialsEnv + " to a service account key with access to them"},
+		{"entitlements", &c.Features.Entitlements, "membership tier → features table, used with -serve"},
+		{"fraud", &c.Features.Fraud, "thresholds for holding suspicious sales, from one IP or email in quick succession, by a quick refunder or from a disposable address, for review at /fraud/held before anything fulfils them, used with -serve"},
+		{"github", &c.Features.GitHub, "products whose buyers are invited to a private GitHub repo or team, used with -serve; set " + githubTokenEnv + " to a token with admin rights on them"},
+		{"launch", &c.Features.Launch, "launch mode settings: milestones pushed every few sales and at revenue thresholds, a faster live feed and job schedules while a launch runs, and a report when it stops; started and stopped at /launch on -api, used with -serve"},
+		{"plugins", &c.Features.Plugins, "subprocess plugins that add sinks and message handlers, used with -serve"},
+		{"price-watch", &c.Features.PriceWatch, "public product pages, competitors' or your own, fetched on a schedule; each price or description change is delivered as a price_watch data_sync event, for rules, webhooks and the push and chat price event, used with -serve"},
+		{"push", &c.Features.Push, "ntfy and Pushover targets for mobile push alerts on sales and failures, used with -serve; set " + pushoverTokenEnv + " for Pushover and " + ntfyTokenEnv + " for a protected ntfy topic"},
+		{"rules", &c.Features.Rules, "rules file evaluated against received messages, alongside the automations approved at /automations on -api, used with -serve"},
+		{"scripts", &c.Features.Scripts, "script hooks that skip received messages or set payload fields before anything records or handles them, used with -serve"},
+		{"segments", &c.Features.Segments, "customer segments file, kept current as customers buy and served at /segments, used with -serve"},
+		{"site-feed", &c.Features.SiteFeed, "sales counters, badges and testimonials published on a schedule to a static site's Git repo or S3 bucket, used with -serve; set " + awsAccessKeyEnv + " and " + awsSecretKeyEnv + " for S3"},
+		{"sms", &c.Features.SMS, "who is texted about big sales, disputes and outages, used with -serve; set " + twilioAccountSIDEnv + " and " + twilioAuthTokenEnv + " to the Twilio account"},
+		{"splits", &c.Features.Splits, "revenue split rules per product, with collaborators' monthly statements at /splits/statements on -api, written when each month ends and emailed with -smtp; used with -serve"},
+		{"stream-subscribers", &c.Features.StreamSubscribers, "webhook URLs that each receive the sale event stream from their own cursor, used with -serve"},
+		{"transforms", &c.Features.Transforms, "pipelines that rename, drop, set, enrich and currency-convert payload fields of received messages and of the copies queued on named sinks, used with -serve"},
+		{"webhooks", &c.Features.Webhooks, "HTTPS endpoints, such as Zapier or Make catch hooks, that selected received or sent messages are POSTed to as JSON, filtered per endpoint, HMAC-signed and retried, used with -serve"},
+		{"winback", &c.Features.WinBack, "win-back campaigns file emailing churned members and refunded buyers after a cooling-off, with conversion at /metrics/winback; used with -serve"},
+	}
+}
+
+// RegisterConfigFlags adds a flag to fs for each setting of flagVars,
+// showing its default. LoadConfig applies the ones given.
+func RegisterConfigFlags(fs *flag.FlagSet) {
+	defaults := DefaultConfig()
+	for _, setting := range defaults.flagVars() {
+		fs.Var(&settingFlag{target: setting.target}, setting.name, setting.usage)
+	}
+}
+
+// applyFlags overrides settings with the flags given on the command line
+func (c *Config) applyFlags(fs *flag.FlagSet) error {
+	targets := make(map[string]interface{})
+	for _, setting := range c.flagVars() {
+		targets[setting.name] = setting.target
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		given, ok := f.Value.(*settingFlag)
+		if !ok || err != nil {
+			return
+		}
+		err = setSetting(targets[f.Name], given.value)
+		if err != nil {
+			err = fmt.Errorf("bad -%s: %v", f.Name, err)
+		}
+	})
+	return err
+}
+
+// settingFlag is the flag of one setting. It parses into the default
+// config, so flag.Parse reports a bad value, and keeps the value as given
+// for applyFlags.
+type settingFlag struct {
+	target interface{}
+	value  string
+}
+
+func (f *settingFlag) String() string {
+	if f == nil || f.target == nil {
+		return ""
+	}
+	// Like flag.Bool, an unset switch shows no default
+	if on, ok := f.target.(*bool); ok && !*on {
+		return ""
+	}
+	return formatSetting(f.target)
+}
+
+func (f *settingFlag) Set(value string) error {
+	f.value = value
+	return setSetting(f.target, value)
+}
+
+func (f *settingFlag) IsBoolFlag() bool {
+	_, ok := f.target.(*bool)
+	return ok
+}
+
+// setSetting parses value into the setting target points at, as the
+// environment and flags give it. Lists are comma-separated, and message
+// type limits are type=workers.
+func setSetting(target interface{}, value string) error {
+	var err error
+	switch target := target.(type) {
+	case *string:
+		*target = value
+	case *[]string:
+		*target = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+	case *bool:
+		*target, err = strconv.ParseBool(value)
+	case *int:
+		*target, err = strconv.Atoi(value)
+	case *int64:
+		*target, err = strconv.ParseInt(value, 10, 64)
+	case *time.Duration:
+		*target, err = time.ParseDuration(value)
+	case *[]time.Duration:
+		*target, err = parseDurations(value)
+	case *map[MessageType]int:
+		*target, err = parseTypeConcurrency(value)
+	default:
+		err = fmt.Errorf("unsupported setting %T", target)
+	}
+	return err
+}
+
+// formatSetting writes a setting as setSetting reads it
+func formatSetting(target interface{}) string {
+	switch target := target.(type) {
+	case *string:
+		return *target
+	case *[]string:
+		return strings.Join(*target, ",")
+	case *bool:
+		return strconv.FormatBool(*target)
+	case *int:
+		return strconv.Itoa(*target)
+	case *int64:
+		return strconv.FormatInt(*target, 10)
+	case *time.Duration:
+		return target.String()
+	case *[]time.Duration:
+		durations := make([]string, len(*target))
+		for i, d := range *target {
+			durations[i] = d.String()
+		}
+		return strings.Join(durations, ",")
+	case *map[MessageType]int:
+		var limits []string
+		for messageType, workers := range *target {
+			limits = append(limits, fmt.Sprintf("%s=%d", messageType, workers))
+		}
+		sort.Strings(limits)
+		return strings.Join(limits, ",")
+	}
+	return ""
+}
+
+// resolve places the directories left empty under DataDir
+func (c *Config) resolve() {
+	if c.Files.Inbox == "" {
+		c.Files.Inbox = c.Path(c.Language)
+	}
+	if c.Files.Outbox == "" {
+		c.Files.Outbox = c.Path("incoming")
+	}
+	if c.Files.Mirrors == nil {
+		c.Files.Mirrors = []string{c.Path("outgoing")}
+	}
+	if c.Stores.Sales == "" {
+		c.Stores.Sales = c.Path("sales", "sales.jsonl")
+	}
+	if c.Stores.Jobs == "" {
+		c.Stores.Jobs = c.Path("scheduler", "jobs.json")
+	}
+	if c.Stores.Messages == "" {
+		c.Stores.Messages = c.Path("store", "messages.db")
+	}
+}
+
+// Validate reports every setting that cannot work
+func (c Config) Validate() error {
+	var problems []string
+	if c.DataDir == "" {
+		problems = append(problems, "data_dir is empty")
+	}
+	if !languageName.MatchString(c.Language) {
+		problems = append(problems, fmt.Sprintf("language %q is not a lowercase name such as go", c.Language))
+	}
+	if u, err := url.Parse(c.BridgeURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("bridge_url %q is not a ws:// or wss:// URL", c.BridgeURL))
+	}
+	if c.HTTP.Peer != "" {
+		if u, err := url.Parse(c.HTTP.Peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("http.peer %q is not an http:// or https:// URL", c.HTTP.Peer))
+		}
+	}
+	if c.GRPC.Peer != "" {
+		if _, port, err := net.SplitHostPort(c.GRPC.Peer); err != nil || port == "" {
+			problems = append(problems, fmt.Sprintf("grpc.peer %q is not a host:port", c.GRPC.Peer))
+		}
+	}
+	if c.Redis.URL != "" {
+		if _, err := parseRedisURL(c.Redis.URL); err != nil {
+			problems = append(problems, "redis.url: "+err.Error())
+		}
+	}
+	if c.NATS.URL != "" {
+		if _, err := parseNATSURL(c.NATS.URL); err != nil {
+			problems = append(problems, "nats.url: "+err.Error())
+		}
+	}
+	if c.NATS.Prefix == "" || strings.ContainsAny(c.NATS.Prefix, "*> \t") {
+		problems = append(problems, fmt.Sprintf("nats.prefix %q is not a subject prefix such as bridge", c.NATS.Prefix))
+	}
+	for name, encoding := range map[string]string{
+		"files.encoding": c.Files.Encoding,
+		"http.encoding":  c.HTTP.Encoding,
+		"redis.encoding": c.Redis.Encoding,
+		"nats.encoding":  c.NATS.Encoding,
+	} {
+		if _, err := EncodingSerializer(encoding); err != nil {
+			problems = append(problems, name+": "+err.Error())
+		}
+	}
+	if c.Compression.Encoding != "" {
+		if _, err := CompressorFor(c.Compression.Encoding); err != nil {
+			problems = append(problems, "compression.encoding: "+err.Error())
+		}
+	}
+	if c.Compression.Over < 0 {
+		problems = append(problems, "compression.over must not be negative")
+	}
+	if c.Expiry.Action != ExpiryDeadLetter && c.Expiry.Action != ExpiryDrop {
+		problems = append(problems, fmt.Sprintf("expiry.action %q is not %s or %s", c.Expiry.Action, ExpiryDeadLetter, ExpiryDrop))
+	}
+	if c.Expiry.AIRequestTTL < 0 {
+		problems = append(problems, "expiry.ai_request_ttl must not be negative")
+	}
+	if c.Dedupe.Window <= 0 {
+		problems = append(problems, "dedupe.window must be positive")
+	}
+	if c.Files.BatchSize <= 0 {
+		problems = append(problems, "files.batch_size must be positive")
+	}
+	// The live token only narrows access to an API that has a token
+	if c.Secrets.LiveToken != "" && c.Secrets.APIToken == "" {
+		problems = append(problems, "secrets.live_token is set without secrets.api_token, which leaves the whole API open")
+	}
+	for name, interval := range map[string]time.Duration{
+		"files.settle":          c.Files.Settle,
+		"files.reconcile":       c.Files.Reconcile,
+		"files.retry_delay":     c.Files.RetryDelay,
+		"poll.pending_requests": c.Poll.PendingRequests,
+		"poll.sequences":        c.Poll.Sequences,
+		"poll.receipts":         c.Poll.Receipts,
+		"poll.expired":          c.Poll.Expired,
+	} {
+		if interval <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive", name))
+		}
+	}
+	for name, limit := range map[string]int64{
+		"messages.max_bytes":          c.Messages.MaxBytes,
+		"messages.workers":            int64(c.Messages.Workers),
+		"messages.low_priority_every": int64(c.Messages.LowPriorityEvery),
+		"messages.max_attempts":       int64(c.Messages.MaxAttempts),
+		"messages.ack_attempts":       int64(c.Messages.AckAttempts),
+		"messages.request_timeout":    int64(c.Messages.RequestTimeout),
+		"messages.ack_timeout":        int64(c.Messages.AckTimeout),
+	} {
+		if limit <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive", name))
+		}
+	}
+	for messageType, workers := range c.Messages.TypeConcurrency {
+		if workers <= 0 {
+			problems = append(problems, fmt.Sprintf("messages.type_concurrency of %s must be positive", messageType))
+		}
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		problems = append(problems, "timezone: "+err.Error())
+	}
+	if _, err := parseWeekday(c.Gumroad.PayoutDay); err != nil {
+		problems = append(problems, "gumroad.payout_day: "+err.Error())
+	}
+	switch c.Email.API {
+	case "", "sendgrid", "mailgun":
+	default:
+		problems = append(problems, fmt.Sprintf("email.api %q is not sendgrid or mailgun", c.Email.API))
+	}
+	if c.Email.SMTP != "" && c.Email.API != "" {
+		problems = append(problems, "email.smtp and email.api are both set; use one")
+	}
+	// Login links, verification codes and follow-ups would go nowhere
+	if c.Email.SMTP == "" && c.Email.API == "" {
+		for name, set := range map[string]bool{
+			"portal.listen":         c.Portal.Listen != "",
+			"features.discord":      c.Features.Discord != "",
+			"checkout.followups":    len(c.Checkout.FollowUps) > 0,
+			"features.winback":      c.Features.WinBack != "",
+			"email.purchase_emails": c.Email.PurchaseEmails,
+		} {
+			if set {
+				problems = append(problems, name+" emails buyers and needs email.smtp or email.api")
+			}
+		}
+	}
+	if c.Features.Discord != "" && c.Portal.Listen == "" {
+		problems = append(problems, "features.discord needs portal.listen to receive Discord interactions")
+	}
+	if c.Features.Launch != "" && c.API.Listen == "" {
+		problems = append(problems, "features.launch is started and stopped through the API and needs api.listen")
+	}
+	if c.Checkout.FollowUpSegment != "" && c.Features.Segments == "" {
+		problems = append(problems, "checkout.followup_segment needs features.segments")
+	}
+	// Anyone who can reach it could post a fake sale
+	if c.Webhook.Listen != "" && c.Secrets.WebhookSecret == "" {
+		problems = append(problems, fmt.Sprintf("webhook.listen needs %s to authenticate pings", gumroadWebhookSecretEnv))
+	}
+	if c.API.Listen != "" {
+		if err := requireAPIToken(c.API.Listen, c.Secrets.APIToken); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, env := range c.envVars() {
+		// A pasted secret's stray newline fails authentication obscurely
+		if value, ok := env.target.(*string); ok && strings.TrimSpace(*value) != *value {
+			problems = append(problems, fmt.Sprintf("%s has leading or trailing whitespace", env.name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c Config) hasSecrets() bool {
+	for _, server := range []string{c.Redis.URL, c.NATS.URL} {
+		if u, err := url.Parse(server); err == nil && u.User != nil {
+			return true
+		}
+	}
+	return c.Secrets != SecretConfig{} || c.Providers != ProviderConfig{}
+}
+
+// Path returns a file or directory under DataDir
+func (c Config) Path(elem ...string) string {
+	return filepath.Join(append([]string{c.DataDir}, elem...)...)
+}
+
+// NewFileTransport creates the shared-directory transport with the
+// configured directories and timings
+func (c Config) NewFileTransport(clock Clock) *FileTransport {
+	ft := NewFileTransport(c.Language, clock)
+	ft.BatchSize = c.Files.BatchSize
+	ft.Settle = c.Files.Settle
+	ft.Reconcile = c.Files.Reconcile
+	ft.RetryDelay = c.Files.RetryDelay
+	ft.inboxDir = c.Files.Inbox
+	ft.claimedDir = filepath.Join(c.Files.Inbox, "claimed")
+	ft.processedDir = filepath.Join(c.Files.Inbox, "processed")
+	ft.outboxDir = c.Files.Outbox
+	ft.extraDirs = c.Files.Mirrors
+	ft.Serializer, _ = EncodingSerializer(c.Files.Encoding)
+	return ft
+}
+
+// NewRedisTransport creates the Redis transport at the configured URL
+func (c Config) NewRedisTransport(clock Clock) (*RedisTransport, error) {
+	rt, err := NewRedisTransport(c.Redis.URL, c.Language, clock)
+	if err != nil {
+		return nil, err
+	}
+	rt.Prefix = c.Redis.Prefix
+	rt.Serializer, err = EncodingSerializer(c.Redis.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// NewNATSTransport creates the NATS transport at the configured URL
+func (c Config) NewNATSTransport(clock Clock) (*NATSTransport, error) {
+	nt, err := NewNATSTransport(c.NATS.URL, c.Language, clock)
+	if err != nil {
+		return nil, err
+	}
+	nt.Prefix = c.NATS.Prefix
+	nt.Serializer, err = EncodingSerializer(c.NATS.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return nt, nil
+}
+
+// NewHTTPTransport creates the peer transport, POSTing to peer in the
+// configured encoding
+func (c Config) NewHTTPTransport(peer string, clock Clock) (*HTTPTransport, error) {
+	ht := NewHTTPTransport(peer, clock)
+	var err error
+	ht.Serializer, err = EncodingSerializer(c.HTTP.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return ht, nil
+}
+
+// parseTOML reads the subset of TOML a config needs: [tables], including
+// dotted names, and key = value pairs of strings, integers, floats,
+// booleans and arrays of them, with # comments
+func parseTOML(content []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+	lines := strings.Split(string(content), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unsupported table header %s", lineNumber, line)
+			}
+			table = root
+			for _, part := range strings.Split(line[1:len(line)-1], ".") {
+				name, err := tomlKey(part)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+				}
+				next, ok := table[name].(map[string]interface{})
+				if !ok {
+					if _, exists := table[name]; exists {
+						return nil, fmt.Errorf("line %d: %s is already a value", lineNumber, name)
+					}
+					next = make(map[string]interface{})
+					table[name] = next
+				}
+				table = next
+			}
+			continue
+		}
+
+		rawKey, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key, err := tomlKey(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		rawValue = strings.TrimSpace(rawValue)
+		// An array may continue over the following lines
+		for strings.HasPrefix(rawValue, "[") && !tomlBalanced(rawValue) && i+1 < len(lines) {
+			i++
+			rawValue += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+		value, err := parseTOMLValue(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if _, exists := table[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNumber, key)
+		}
+		table[key] = value
+	}
+	return root, nil
+}
+
+// tomlKey returns a bare or quoted key's name
+func tomlKey(raw string) (string, error) {
+	key := strings.TrimSpace(raw)
+	if strings.HasPrefix(key, `"`) || strings.HasPrefix(key, "'") {
+		value, err := parseTOMLValue(key)
+		if err != nil {
+			return "", err
+		}
+		return value.(string), nil
+	}
+	for _, r := range key {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", fmt.Errorf("bad key %q", key)
+		}
+	}
+	if key == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	return key, nil
+}
+
+// stripTOMLComment drops a # comment that is not inside a string
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlBalanced reports whether every [ outside a string is closed
+func tomlBalanced(value string) bool {
+	depth := 0
+	splitTOMLArray(value, func(c byte) {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+	})
+	return depth <= 0
+}
+
+// splitTOMLArray calls visit with each byte outside strings, returning the
+// top-level pieces between commas of the array in value
+func splitTOMLArray(value string, visit func(byte)) []string {
+	var pieces []string
+	var quote byte
+	depth, start := 0, 1
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+			continue
+		}
+		if visit != nil {
+			visit(c)
+		}
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 1 {
+				pieces = append(pieces, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if len(value) > start {
+		pieces = append(pieces, value[start:len(value)-1])
+	}
+	return pieces
+}
+
+func parseTOMLValue(raw string) (interface{}, error) {
+	value := strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", value)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") || strings.Contains(value[1:len(value)-1], "'") {
+			return nil, fmt.Errorf("bad string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case value == "true", value == "false":
+		return value == "true", nil
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("unclosed array %s", value)
+		}
+		items := []interface{}{}
+		pieces := splitTOMLArray(value, nil)
+		for i, piece := range pieces {
+			// A trailing comma leaves an empty last piece
+			if strings.TrimSpace(piece) == "" && i == len(pieces)-1 {
+				continue
+			}
+			item, err := parseTOMLValue(piece)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	number := strings.ReplaceAll(value, "_", "")
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", value)
+}
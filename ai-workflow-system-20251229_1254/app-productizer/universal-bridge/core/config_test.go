@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv keeps the environment the tests run in out of the config
+func clearConfigEnv(t *testing.T) {
+	var config Config
+	for _, env := range config.envVars() {
+		t.Setenv(env.name, "")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	yamlPath := write("bridge.yaml", `
+data_dir: /srv/bridge
+bridge_url: wss://hub.example.com/bridge
+files:
+  reconcile: 30s
+  mirrors: [/srv/mirror]
+poll:
+  sequences: 5s
+secrets:
+  api_token: from-file
+`)
+	tomlPath := write("bridge.toml", `
+# the same settings as bridge.yaml
+data_dir = "/srv/bridge"
+bridge_url = 'wss://hub.example.com/bridge'
+
+[files]
+reconcile = "30s"
+mirrors = [
+  "/srv/mirror", # the backup box
+]
+
+[poll]
+sequences = "5s"
+
+[secrets]
+api_token = "from-file"
+`)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			t.Setenv(apiTokenEnv, "from-env")
+			t.Setenv(fileBatchSizeEnv, "64")
+			config, err := LoadConfig(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := DefaultConfig()
+			want.DataDir = "/srv/bridge"
+			want.BridgeURL = "wss://hub.example.com/bridge"
+			want.Files.Inbox = "/srv/bridge/go"
+			want.Files.Outbox = "/srv/bridge/incoming"
+			want.Files.Mirrors = []string{"/srv/mirror"}
+			want.Files.BatchSize = 64
+			want.Files.Reconcile = 30 * time.Second
+			want.Poll.Sequences = 5 * time.Second
+			want.Secrets.APIToken = "from-env"
+			want.Stores = StoreConfig{
+				Sales:    "/srv/bridge/sales/sales.jsonl",
+				Jobs:     "/srv/bridge/scheduler/jobs.json",
+				Messages: "/srv/bridge/store/messages.db",
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("config\n%+v\nwant\n%+v", config, want)
+			}
+		})
+	}
+
+	example, err := LoadConfig("config.example.yaml", nil)
+	if err != nil {
+		t.Fatalf("config.example.yaml: %v", err)
+	}
+	defaults := DefaultConfig()
+	defaults.resolve()
+	if !reflect.DeepEqual(example, defaults) {
+		t.Errorf("config.example.yaml differs from the defaults: %+v", example)
+	}
+}
+
+func TestConfigFlags(t *testing.T) {
+	clearConfigEnv(t)
+	path := filepath.Join(t.TempDir(), "bridge.yaml")
+	err := ioutil.WriteFile(path, []byte(`
+data_dir: /srv/bridge
+timezone: Europe/Berlin
+messages:
+  workers: 8
+  max_attempts: 3
+email:
+  smtp: mail.example.com:587
+portal:
+  listen: ":8080"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fileBatchSizeEnv, "64")
+
+	flags := flag.NewFlagSet("bridge", flag.ContinueOnError)
+	RegisterConfigFlags(flags)
+	err = flags.Parse([]string{
+		"-dispatch-workers", "2",
+		"-purchase-emails",
+		"-allow-sources", "python, gumroad",
+		"-type-concurrency", "ai_request=2",
+		"-checkout-followups", "1h,24h",
+		"-message-store", "off",
+		"-http-peer", "https://peer.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultConfig()
+	want.DataDir = "/srv/bridge"
+	want.Timezone = "Europe/Berlin"
+	want.Files.BatchSize = 64
+	want.Messages.Workers = 2
+	want.Messages.MaxAttempts = 3
+	want.Messages.AllowSources = []string{"python", "gumroad"}
+	want.Messages.TypeConcurrency = map[MessageType]int{AIRequest: 2}
+	want.Email.SMTP = "mail.example.com:587"
+	want.Email.PurchaseEmails = true
+	want.Portal.Listen = ":8080"
+	want.Checkout.FollowUps = []time.Duration{time.Hour, 24 * time.Hour}
+	want.HTTP.Peer = "https://peer.example.com"
+	want.Stores.Messages = "off"
+	want.resolve()
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config\n%+v\nwant\n%+v", config, want)
+	}
+	if got := flags.Lookup("max-attempts").DefValue; got != "5" {
+		t.Errorf("-max-attempts default %q, want the config's 5", got)
+	}
+
+	bad := flag.NewFlagSet("bridge", flag.ContinueOnError)
+	bad.SetOutput(ioutil.Discard)
+	RegisterConfigFlags(bad)
+	if err := bad.Parse([]string{"-request-timeout", "soon"}); err == nil {
+		t.Error("parsed a bad -request-timeout")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	clearConfigEnv(t)
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{"unknown key", "bridge.yaml", "data_dr: x\n", nil, "field data_dr not found"},
+		{"unknown toml key", "bridge.toml", "[files]\nreconcil = \"1s\"\n", nil, "field reconcil not found"},
+		{"bad toml", "bridge.toml", "data_dir = \"unterminated\n", nil, "line 1: bad string"},
+		{"format", "bridge.json", "{}", nil, "unknown config format"},
+		{"url", "bridge.yaml", "bridge_url: http://hub\n", nil, `bridge_url "http://hub" is not a ws:// or wss:// URL`},
+		{"interval", "bridge.yaml", "poll:\n  pending_requests: 0s\n", nil, "poll.pending_requests must be positive"},
+		{"env duration", "bridge.yaml", "", map[string]string{fileSettleEnv: "soon"}, "bad BRIDGE_FILE_SETTLE"},
+		{"pasted secret", "bridge.yaml", "", map[string]string{gumroadWebhookSecretEnv: "s3cret\n"}, "GUMROAD_WEBHOOK_SECRET has leading or trailing whitespace"},
+		{"redis url", "bridge.yaml", "redis:\n  url: localhost:6379\n", nil, "redis.url: bad Redis URL"},
+		{"grpc peer", "bridge.yaml", "grpc:\n  peer: http://localhost:9091\n", nil, "grpc.peer \"http://localhost:9091\" is not a host:port"},
+		{"encoding", "bridge.yaml", "", map[string]string{natsEncodingEnv: "avro"}, "nats.encoding: unknown encoding \"avro\""},
+		{"compression", "bridge.yaml", "", map[string]string{compressionEnv: "zstd"}, "compression.encoding: unknown content encoding \"zstd\""},
+		{"expiry action", "bridge.yaml", "expiry:\n  action: archive\n", nil, `expiry.action "archive" is not dead_letter or drop`},
+		{"dedupe window", "bridge.yaml", "", map[string]string{dedupeWindowEnv: "0s"}, "dedupe.window must be positive"},
+		{"nats url", "bridge.yaml", "nats:\n  url: http://nats:4222\n", nil, "nats.url: bad NATS URL"},
+		{"workers", "bridge.yaml", "messages:\n  workers: 0\n", nil, "messages.workers must be positive"},
+		{"type concurrency", "bridge.yaml", "messages:\n  type_concurrency:\n    ai_request: 0\n", nil, "messages.type_concurrency of ai_request must be positive"},
+		{"timezone", "bridge.yaml", "timezone: Mars/Olympus\n", nil, "timezone: unknown time zone Mars/Olympus"},
+		{"payout day", "bridge.yaml", "gumroad:\n  payout_day: someday\n", nil, "gumroad.payout_day"},
+		{"email api", "bridge.yaml", "email:\n  api: postmark\n", nil, `email.api "postmark" is not sendgrid or mailgun`},
+		{"two email routes", "bridge.yaml", "email:\n  smtp: mail:587\n  api: sendgrid\n", nil, "email.smtp and email.api are both set"},
+		{"portal without email", "bridge.yaml", "portal:\n  listen: \":8080\"\n", nil, "portal.listen emails buyers and needs email.smtp or email.api"},
+		{"discord without portal", "bridge.yaml", "email:\n  smtp: mail:587\nfeatures:\n  discord: discord.yaml\n", nil, "features.discord needs portal.listen"},
+		{"launch without api", "bridge.yaml", "features:\n  launch: launch.yaml\n", nil, "features.launch is started and stopped through the API"},
+		{"segment without segments", "bridge.yaml", "checkout:\n  followup_segment: vip\n", nil, "checkout.followup_segment needs features.segments"},
+		{"webhook without secret", "bridge.yaml", "webhook:\n  listen: \":8082\"\n", nil, "webhook.listen needs GUMROAD_WEBHOOK_SECRET"},
+		{"open api", "bridge.yaml", "api:\n  listen: \":8081\"\n", nil, "api.listen :8081 is reachable from other hosts"},
+		{"live token alone", "bridge.yaml", "", map[string]string{liveTokenEnv: "overlay"}, "secrets.live_token is set without secrets.api_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := filepath.Join(dir, tt.file)
+			err := ioutil.WriteFile(path, []byte(tt.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = LoadConfig(path, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// contractCase mirrors the files in ../contract/cases shared with the Python and JS bridges
+type contractCase struct {
+	Name             string                 `json:"name"`
+	Description      string                 `json:"description"`
+	Message          map[string]interface{} `json:"message"`
+	CanonicalPayload string                 `json:"canonical_payload"`
+	Expect           struct {
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	} `json:"expect"`
+}
+
+// wireFields are the envelope field names every bridge must emit
+var wireFields = []string{
+	"checksum", "id", "message_type", "payload", "response_channel",
+	"source_language", "target_language", "timestamp",
+}
+
+func loadContractCases(t *testing.T) []contractCase {
+	paths, err := filepath.Glob(filepath.Join("..", "contract", "cases", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no contract cases found: %v", err)
+	}
+	sort.Strings(paths)
+
+	cases := make([]contractCase, 0, len(paths))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		var c contractCase
+		if err := json.Unmarshal(content, &c); err != nil {
+			t.Fatalf("parsing %s: %v", path, err)
+		}
+		cases = append(cases, c)
+	}
+	return cases
+}
+
+func TestContract(t *testing.T) {
+	for _, c := range loadContractCases(t) {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			raw, err := json.Marshal(c.Message)
+			if err != nil {
+				t.Fatalf("encoding case message: %v", err)
+			}
+
+			msg, err := FromJSON(string(raw))
+			if !c.Expect.Valid {
+				if err == nil {
+					t.Fatalf("FromJSON accepted an invalid message")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromJSON: %v", err)
+			}
+
+			// Encoded as calculateChecksum does
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(msg.Payload); err != nil {
+				t.Fatalf("encoding payload: %v", err)
+			}
+			canonical := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+			if string(canonical) != c.CanonicalPayload {
+				t.Errorf("canonical payload\n got: %s\nwant: %s", canonical, c.CanonicalPayload)
+			}
+			if checksum := msg.calculateChecksum(); checksum != c.Message["checksum"] {
+				t.Errorf("checksum %s, want %v", checksum, c.Message["checksum"])
+			}
+
+			// Re-encoding must keep the shared field names
+			out, err := msg.ToJSON()
+			if err != nil {
+				t.Fatalf("ToJSON: %v", err)
+			}
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(out), &fields); err != nil {
+				t.Fatalf("decoding ToJSON output: %v", err)
+			}
+			for _, name := range wireFields {
+				if _, ok := fields[name]; !ok {
+					t.Errorf("ToJSON output is missing %q", name)
+				}
+			}
+			if len(fields) != len(wireFields) {
+				t.Errorf("ToJSON emitted %d fields, want %d", len(fields), len(wireFields))
+			}
+		})
+	}
+}
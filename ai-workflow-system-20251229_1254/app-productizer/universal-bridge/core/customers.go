@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CustomerProfile is everything the bridge knows about one customer, merged
+// from their purchases, subscriptions, support messages and list
+// memberships under their normalized email
+type CustomerProfile struct {
+	Customer
+	FirstSeen       time.Time        `json:"first_seen"`
+	LastSeen        time.Time        `json:"last_seen"`
+	Purchases       []Sale           `json:"purchases"`
+	Spend           MoneyTotals      `json:"spend"` // unrefunded purchases, per currency
+	Subscriptions   []Subscription   `json:"subscriptions"`
+	SupportMessages []SupportMessage `json:"support_messages"`
+	Lists           []string         `json:"lists"` // mailing lists, and waitlist:<product>
+	OptedOut        bool             `json:"opted_out"`
+}
+
+// SupportMessage is a message a customer sent through a support channel
+type SupportMessage struct {
+	ID         string    `json:"id,omitempty"`
+	Channel    string    `json:"channel,omitempty"` // e.g. "email" or "helpdesk"
+	Subject    string    `json:"subject,omitempty"`
+	Text       string    `json:"text"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// customerRecord is what Customers stores itself; purchases, waitlists and
+// opt-outs are read from their own stores when a profile is built
+type customerRecord struct {
+	FirstSeen     time.Time                `json:"first_seen"`
+	LastSeen      time.Time                `json:"last_seen"`
+	Subscriptions map[string]*Subscription `json:"subscriptions,omitempty"`
+	Support       []SupportMessage         `json:"support,omitempty"`
+	Lists         map[string]time.Time     `json:"lists,omitempty"` // list → joined at
+}
+
+type customerState struct {
+	Records  map[string]*customerRecord `json:"records"` // email → record
+	SyncedAt time.Time                  `json:"synced_at"`
+}
+
+// Customers builds unified customer profiles. It records subscription
+// events, support messages and mailing list changes from data_sync messages
+// itself, and merges in the sales store, waitlists and opt-outs.
+type Customers struct {
+	// Waitlists and OptOuts, when set, are merged into profiles
+	Waitlists *Waitlists
+	OptOuts   *OptOutList
+
+	bridge *GoBridge
+	sales  *SalesStore
+	path   string
+
+	mu    sync.Mutex
+	state customerState
+}
+
+// NewCustomers opens the records persisted at path. Every data_sync message
+// about a known customer leaves with a customer summary in its payload, so
+// register it after RecordSales for sinks to see the sale it carries.
+func NewCustomers(gb *GoBridge, sales *SalesStore, path string) (*Customers, error) {
+	c := &Customers{
+		bridge: gb,
+		sales:  sales,
+		path:   path,
+		state:  customerState{Records: make(map[string]*customerRecord)},
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read customers: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &c.state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse customers %s: %v", path, err)
+		}
+		if c.state.Records == nil {
+			c.state.Records = make(map[string]*customerRecord)
+		}
+	}
+
+	gb.OnReceive(c.handleMessage)
+	return c, nil
+}
+
+func (c *Customers) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+
+	email, err := c.record(message.Payload)
+	if err != nil {
+		log.Printf("❌ Error recording customer from %s: %v", message.ID, err)
+	}
+	if email == "" {
+		return
+	}
+	if profile, ok := c.Profile(email); ok {
+		// A copy, so the sale the store holds stays as Gumroad sent it
+		message.Payload = mergePayload(message.Payload, map[string]interface{}{"customer": profile.summary()})
+	}
+}
+
+// customerEmail reads whose message a payload is: Gumroad sends email on
+// sales and user_email on subscription events
+func customerEmail(payload map[string]interface{}) string {
+	for _, field := range []string{"email", "user_email"} {
+		if email := NormalizeEmail(stringArg(payload, field)); strings.Contains(email, "@") {
+			return email
+		}
+	}
+	return ""
+}
+
+// record applies a payload to the customer's own record, returning whose it
+// is; subscription events without an email are matched by subscription ID
+func (c *Customers) record(payload map[string]interface{}) (string, error) {
+	resource := stringArg(payload, "resource_name")
+	email := customerEmail(payload)
+	subscriptionID := stringArg(payload, "subscription_id")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.bridge.clock.Now()
+	if email == "" && subscriptionID != "" {
+		email = c.subscriberLocked(subscriptionID)
+	}
+	if email == "" {
+		return "", nil
+	}
+
+	rec := c.state.Records[email]
+	if rec == nil {
+		rec = &customerRecord{FirstSeen: now}
+	}
+
+	switch resource {
+	case "sale", "subscription_updated", "subscription_restarted", "cancellation", "subscription_ended":
+		event, ok := ParseSubscriptionEvent(payload, now)
+		if !ok {
+			if resource != "sale" {
+				return email, nil
+			}
+			break
+		}
+		if rec.Subscriptions == nil {
+			rec.Subscriptions = make(map[string]*Subscription)
+		}
+		subscription := rec.Subscriptions[event.ID]
+		if subscription == nil {
+			subscription = &Subscription{ID: event.ID, Product: event.Product, Email: email, Status: MembershipActive}
+			rec.Subscriptions[event.ID] = subscription
+		}
+		if event.Status != "" {
+			subscription.Status = event.Status
+		}
+		if event.Tier != "" {
+			subscription.Tier = event.Tier
+		}
+		subscription.UpdatedAt = now
+	case "waitlist_signup":
+		// Waitlists keeps the signup; this only marks the activity for Sync
+	case "support_message":
+		text := stringArg(payload, "text")
+		if text == "" {
+			text = stringArg(payload, "body")
+		}
+		rec.Support = append(rec.Support, SupportMessage{
+			ID:         stringArg(payload, "message_id"),
+			Channel:    stringArg(payload, "channel"),
+			Subject:    stringArg(payload, "subject"),
+			Text:       text,
+			ReceivedAt: now,
+		})
+	case "list_subscribe", "list_unsubscribe":
+		list := stringArg(payload, "list")
+		if list == "" {
+			return email, fmt.Errorf("%s needs a list", resource)
+		}
+		if rec.Lists == nil {
+			rec.Lists = make(map[string]time.Time)
+		}
+		if resource == "list_subscribe" {
+			if _, joined := rec.Lists[list]; !joined {
+				rec.Lists[list] = now
+			}
+		} else {
+			delete(rec.Lists, list)
+		}
+	default:
+		return email, nil
+	}
+
+	rec.LastSeen = now
+	c.state.Records[email] = rec
+	return email, c.saveLocked()
+}
+
+// subscriberLocked finds who a subscription belongs to, for events that
+// carry only its ID
+func (c *Customers) subscriberLocked(id string) string {
+	for email, rec := range c.state.Records {
+		if _, ok := rec.Subscriptions[id]; ok {
+			return email
+		}
+	}
+	return ""
+}
+
+// Profile merges everything known about an email, reporting false when
+// nothing is
+func (c *Customers) Profile(email string) (CustomerProfile, bool) {
+	email = NormalizeEmail(email)
+	profile := CustomerProfile{
+		Customer:        Customer{Email: email},
+		Purchases:       []Sale{},
+		Spend:           MoneyTotals{},
+		Subscriptions:   []Subscription{},
+		SupportMessages: []SupportMessage{},
+		Lists:           []string{},
+	}
+	known := false
+
+	c.mu.Lock()
+	if rec := c.state.Records[email]; rec != nil {
+		known = true
+		profile.FirstSeen = rec.FirstSeen
+		profile.LastSeen = rec.LastSeen
+		for _, subscription := range rec.Subscriptions {
+			profile.Subscriptions = append(profile.Subscriptions, *subscription)
+		}
+		profile.SupportMessages = append(profile.SupportMessages, rec.Support...)
+		for list := range rec.Lists {
+			profile.Lists = append(profile.Lists, list)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, sale := range c.sales.ByEmail(email) {
+		known = true
+		profile.Purchases = append(profile.Purchases, sale)
+		// A bundle's components carry its price between them
+		if !sale.Refunded && !sale.IsBundle() {
+			profile.Spend.Add(sale.Price)
+		}
+		if sale.FullName != "" {
+			profile.Name = sale.FullName
+		}
+		profile.Locale = DetectLocale(sale.Fields)
+	}
+
+	if c.Waitlists != nil {
+		for _, product := range c.Waitlists.Joined(email) {
+			known = true
+			profile.Lists = append(profile.Lists, "waitlist:"+product)
+		}
+	}
+	if !known {
+		return CustomerProfile{}, false
+	}
+	if c.OptOuts != nil {
+		profile.OptedOut = c.OptOuts.Contains(email)
+	}
+
+	sort.Slice(profile.Subscriptions, func(i, j int) bool { return profile.Subscriptions[i].ID < profile.Subscriptions[j].ID })
+	sort.Strings(profile.Lists)
+	return profile, true
+}
+
+// Emails returns every email with a profile, sorted
+func (c *Customers) Emails() []string {
+	seen := make(map[string]bool)
+	for _, email := range c.sales.Emails() {
+		seen[email] = true
+	}
+	c.mu.Lock()
+	for email := range c.state.Records {
+		seen[email] = true
+	}
+	c.mu.Unlock()
+	if c.Waitlists != nil {
+		for _, email := range c.Waitlists.Emails() {
+			seen[email] = true
+		}
+	}
+
+	emails := make([]string, 0, len(seen))
+	for email := range seen {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// summary is the compact form of a profile attached to message payloads
+// and sent to CRM sinks
+func (p CustomerProfile) summary() map[string]interface{} {
+	products := []interface{}{}
+	owned := make(map[string]bool)
+	for _, purchase := range p.Purchases {
+		if !purchase.Refunded && !owned[purchase.Product] {
+			owned[purchase.Product] = true
+			products = append(products, purchase.Product)
+		}
+	}
+	active := []interface{}{}
+	for _, subscription := range p.Subscriptions {
+		if subscription.Status != MembershipEnded {
+			active = append(active, subscription.Product)
+		}
+	}
+	spend := make(map[string]interface{}, len(p.Spend))
+	for currency, total := range p.Spend {
+		spend[currency] = total
+	}
+	lists := make([]interface{}, len(p.Lists))
+	for i, list := range p.Lists {
+		lists[i] = list
+	}
+
+	return map[string]interface{}{
+		"email":            p.Email,
+		"name":             p.Name,
+		"locale":           p.Locale,
+		"purchases":        len(p.Purchases),
+		"products":         products,
+		"spend_cents":      spend,
+		"subscriptions":    active,
+		"support_messages": len(p.SupportMessages),
+		"lists":            lists,
+		"opted_out":        p.OptedOut,
+	}
+}
+
+// Sync sends the summary of every profile with activity since the last sync,
+// or of every profile when all is set, to the sink as a customer_profile
+// message. Opt-outs are not activity, so a periodic full sync picks them up.
+func (c *Customers) Sync(sinkName string, all bool) error {
+	sink := c.bridge.Sink(sinkName)
+	if sink == nil {
+		return fmt.Errorf("no sink named %s", sinkName)
+	}
+
+	c.mu.Lock()
+	since := c.state.SyncedAt
+	c.mu.Unlock()
+	started := c.bridge.clock.Now()
+
+	sent := 0
+	for _, email := range c.Emails() {
+		profile, ok := c.Profile(email)
+		if !ok || (!all && !since.IsZero() && !profile.LastSeen.After(since)) {
+			continue
+		}
+		payload := profile.summary()
+		payload["resource_name"] = "customer_profile"
+		message := c.bridge.NewMessage(DataSync, "go", payload, FileSystem)
+		err := sink.Enqueue(message)
+		for err == ErrSinkFull {
+			<-c.bridge.clock.After(time.Second)
+			err = sink.Enqueue(message)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to queue customer %s: %v", email, err)
+		}
+		sent++
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.SyncedAt = started
+	fmt.Printf("👥 Synced %d customer profiles to %s\n", sent, sinkName)
+	return c.saveLocked()
+}
+
+// Handler serves GET /customers with every customer's summary and
+// GET /customers/{email} with one full profile
+func (c *Customers) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+
+		rest := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/customers"), "/")
+		if rest == "" {
+			summaries := []map[string]interface{}{}
+			for _, email := range c.Emails() {
+				if profile, ok := c.Profile(email); ok {
+					summaries = append(summaries, profile.summary())
+				}
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"customers": summaries})
+			return
+		}
+
+		email, err := url.PathUnescape(rest)
+		if err != nil || !strings.Contains(email, "@") {
+			writeAPIError(w, http.StatusBadRequest, "expected /customers/{email}")
+			return
+		}
+		profile, ok := c.Profile(email)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no customer with that email")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, profile)
+	})
+}
+
+func (c *Customers) saveLocked() error {
+	return writeJSONFile(c.path, c.state)
+}
+
+// RegisterCustomerJobs adds the sync_customers job handler, which sends
+// changed profiles to args.sink (default "crm"); args.all resends every
+// profile
+func RegisterCustomerJobs(s *Scheduler, customers *Customers) {
+	s.Handle("sync_customers", func(job Job) error {
+		sink := stringArg(job.Args, "sink")
+		if sink == "" {
+			sink = "crm"
+		}
+		all := job.Args["all"] == true || stringArg(job.Args, "all") == "true"
+		return customers.Sync(sink, all)
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCustomers(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore(filepath.Join(dir, "sales.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	optOuts, err := NewOptOutList(filepath.Join(dir, "optouts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitlists, err := NewWaitlists(gb, nil, nil, optOuts, filepath.Join(dir, "waitlists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "customers.json")
+	customers, err := NewCustomers(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customers.Waitlists = waitlists
+	customers.OptOuts = optOuts
+
+	var summaries []map[string]interface{}
+	gb.AddSink("slack", SinkConfig{Types: []MessageType{SaleEvent}}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		summaries = append(summaries, mapArg(message.Payload, "customer"))
+		return message
+	})
+	var synced []string
+	gb.AddSink("crm", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		synced = append(synced, stringArg(message.Payload, "email"))
+		return message
+	})
+
+	ids := NewSequentialIDs("gumroad")
+	receive := func(messageType MessageType, payload map[string]interface{}) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, messageType, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(SaleEvent, map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "product_id": "ebook", "email": "Ada@example.com", "full_name": "Ada L", "price": "10", "currency": "usd"})
+	receive(SaleEvent, map[string]interface{}{"resource_name": "sale", "sale_id": "s2", "product_id": "club", "email": "ada@example.com", "price": "5", "currency": "eur",
+		"subscription_id": "sub1", "variants": map[string]interface{}{"Tier": "Pro"}})
+	receive(SaleEvent, map[string]interface{}{"resource_name": "sale", "sale_id": "s3", "product_id": "kit", "email": "ada@example.com", "price": "20", "currency": "usd"})
+	clock.Advance(time.Hour)
+	receive(SaleEvent, map[string]interface{}{"resource_name": "refund", "sale_id": "s3", "email": "ada@example.com", "price": "20", "currency": "usd"})
+	receive(DataSync, map[string]interface{}{"resource_name": "cancellation", "subscription_id": "sub1"}) // no email; matched by subscription
+	receive(DataSync, map[string]interface{}{"resource_name": "support_message", "email": "ada@example.com", "channel": "email", "subject": "Download", "body": "The link expired"})
+	receive(DataSync, map[string]interface{}{"resource_name": "list_subscribe", "email": "ada@example.com", "list": "newsletter"})
+	receive(DataSync, map[string]interface{}{"resource_name": "list_subscribe", "email": "ada@example.com", "list": "beta"})
+	receive(DataSync, map[string]interface{}{"resource_name": "list_unsubscribe", "email": "ada@example.com", "list": "beta"})
+	receive(DataSync, map[string]interface{}{"resource_name": "list_subscribe", "email": "ada@example.com"}) // no list
+	receive(DataSync, map[string]interface{}{"resource_name": "waitlist_signup", "product_id": "course", "email": "bob@example.com"})
+	if err := optOuts.Add("ada@example.com", "unsubscribed"); err != nil {
+		t.Fatal(err)
+	}
+
+	ada, ok := customers.Profile(" ADA@example.com")
+	if !ok {
+		t.Fatal("no profile for ada")
+	}
+	if ada.Name != "Ada L" || len(ada.Purchases) != 3 || ada.Spend["usd"] != 1000 || ada.Spend["eur"] != 500 || !ada.OptedOut {
+		t.Errorf("ada %+v, want three purchases, the refund not spent, and opted out", ada)
+	}
+	if len(ada.Subscriptions) != 1 || ada.Subscriptions[0].Status != MembershipCancelled || ada.Subscriptions[0].Tier != "Pro" {
+		t.Errorf("subscriptions %+v, want sub1 cancelled on Pro", ada.Subscriptions)
+	}
+	if len(ada.SupportMessages) != 1 || ada.SupportMessages[0].Text != "The link expired" || strings.Join(ada.Lists, ",") != "newsletter" {
+		t.Errorf("support %+v and lists %v", ada.SupportMessages, ada.Lists)
+	}
+	if !ada.FirstSeen.Equal(clock.Now().Add(-time.Hour)) || !ada.LastSeen.Equal(clock.Now()) {
+		t.Errorf("seen %v to %v", ada.FirstSeen, ada.LastSeen)
+	}
+	if bob, ok := customers.Profile("bob@example.com"); !ok || strings.Join(bob.Lists, ",") != "waitlist:course" || len(bob.Purchases) != 0 {
+		t.Errorf("bob %+v, want his waitlist alone", bob)
+	}
+	if _, ok := customers.Profile("nobody@example.com"); ok {
+		t.Error("a profile for a stranger")
+	}
+
+	// Sales leave with the buyer's summary
+	if len(summaries) != 4 || summaries[0]["purchases"] != 1 || summaries[2]["purchases"] != 3 {
+		t.Fatalf("summaries %v, want one per sale event as the purchases add up", summaries)
+	}
+	if products := summaries[3]["products"].([]interface{}); len(products) != 2 {
+		t.Errorf("products after the refund %v, want ebook and club", products)
+	}
+
+	handler := customers.Handler()
+	get := func(method, target string) (int, map[string]interface{}) {
+		t.Helper()
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+		var body map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &body)
+		return response.Code, body
+	}
+	status, body := get("GET", "/customers")
+	if list, _ := body["customers"].([]interface{}); status != http.StatusOK || len(list) != 2 {
+		t.Errorf("GET /customers = %d %v, want both customers", status, body)
+	}
+	status, body = get("GET", "/customers/ada%40example.com")
+	if status != http.StatusOK || body["email"] != "ada@example.com" || body["opted_out"] != true {
+		t.Errorf("GET /customers/ada = %d %v", status, body)
+	}
+	for target, want := range map[string]int{
+		"/customers/nobody@example.com": http.StatusNotFound,
+		"/customers/ada":                http.StatusBadRequest,
+	} {
+		if status, _ := get("GET", target); status != want {
+			t.Errorf("GET %s = %d, want %d", target, status, want)
+		}
+	}
+	if status, _ := get("POST", "/customers"); status != http.StatusMethodNotAllowed {
+		t.Errorf("POST /customers = %d, want 405", status)
+	}
+
+	// Syncs send every profile once, then only those with activity since
+	if err := customers.Sync("crm", false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	receive(DataSync, map[string]interface{}{"resource_name": "support_message", "email": "ada@example.com", "text": "Thanks!"})
+	if err := customers.Sync("crm", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := customers.Sync("crm", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(synced, ","); got != "ada@example.com,bob@example.com,ada@example.com,ada@example.com,bob@example.com" {
+		t.Errorf("synced %s", got)
+	}
+	if err := customers.Sync("hubspot", true); err == nil {
+		t.Error("synced to a sink that does not exist")
+	}
+
+	// The records survive a restart
+	other := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { other.Close() })
+	reopened, err := NewCustomers(other, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, ok := reopened.Profile("ada@example.com"); !ok || len(again.SupportMessages) != 2 || len(again.Subscriptions) != 1 {
+		t.Errorf("ada after a restart %+v", again)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles is the seller dashboard, built into the binary so it needs
+// nothing beyond the API
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// DashboardHandler serves the dashboard at /dashboard/: revenue and recent
+// sales from /sales, message throughput and handler error rates from
+// /metrics/messages. The files hold no data and are served without the API
+// token; the page asks for it, or takes it from /dashboard/#token=, and
+// sends it with each request for figures.
+func DashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err) // the directory is embedded above
+	}
+	fileServer := http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,328 @@
+// The seller dashboard. The API token comes from the page's #token=
+// fragment, which browsers never send, or from the login form, and is kept
+// for the tab's session only. Every figure is read from the API the page is
+// served by, relative to /dashboard/. An #approval= fragment, as approval
+// notifications link to, highlights that approval.
+"use strict";
+
+const refreshEvery = 15000; // ms between polls
+const throughputSamples = 40; // polls the throughput chart keeps
+
+let token = sessionStorage.getItem("bridgeToken") || "";
+let currency = "";
+let lastDaily = [];
+let previousStats = null; // the last poll of /metrics/messages and when
+let linkedApproval = "";
+const throughput = [];
+
+function takeFragment() {
+  const fragment = new URLSearchParams(location.hash.slice(1));
+  linkedApproval = fragment.get("approval") || "";
+  if (fragment.has("token")) {
+    token = fragment.get("token");
+    sessionStorage.setItem("bridgeToken", token);
+    // The token leaves the address bar; the approval stays linkable
+    fragment.delete("token");
+    const rest = fragment.toString();
+    history.replaceState(null, "", location.pathname + location.search + (rest ? "#" + rest : ""));
+  }
+}
+
+async function api(path, method) {
+  const headers = token ? { Authorization: "Bearer " + token } : {};
+  const response = await fetch("../" + path, { method: method || "GET", headers, cache: "no-store" });
+  if (response.status === 401) {
+    throw new Error("unauthorized");
+  }
+  const body = await response.json();
+  if (!response.ok) {
+    const err = new Error(body.error || response.statusText);
+    err.status = response.status;
+    throw err;
+  }
+  return body;
+}
+
+function date(offsetDays) {
+  const day = new Date();
+  day.setDate(day.getDate() + offsetDays);
+  return day.getFullYear() + "-" + String(day.getMonth() + 1).padStart(2, "0") + "-" + String(day.getDate()).padStart(2, "0");
+}
+
+function formatMoney(money) {
+  if (!money) {
+    return "–";
+  }
+  const code = money.currency.toUpperCase();
+  let format;
+  try {
+    format = new Intl.NumberFormat(undefined, { style: "currency", currency: code });
+  } catch (err) {
+    return (money.amount / 100).toFixed(2) + " " + code;
+  }
+  const digits = format.resolvedOptions().maximumFractionDigits;
+  return format.format(money.amount / Math.pow(10, digits));
+}
+
+function inCurrency(list) {
+  return (list || []).find((money) => money.currency === currency);
+}
+
+function cell(row, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  row.appendChild(td);
+  return td;
+}
+
+// setupCanvas sizes a canvas to its box for sharp lines and returns its
+// context and size in CSS pixels
+function setupCanvas(canvas) {
+  const ratio = window.devicePixelRatio || 1;
+  const width = canvas.clientWidth;
+  const height = canvas.height / (canvas.dataset.ratio || 1);
+  canvas.dataset.ratio = ratio;
+  canvas.width = width * ratio;
+  canvas.height = height * ratio;
+  const ctx = canvas.getContext("2d");
+  ctx.scale(ratio, ratio);
+  ctx.clearRect(0, 0, width, height);
+  ctx.font = "11px system-ui, sans-serif";
+  return { ctx, width, height };
+}
+
+function drawRevenue() {
+  const { ctx, width, height } = setupCanvas(document.getElementById("revenue"));
+  const values = lastDaily.map((day) => (inCurrency(day.net_revenue) || { amount: 0 }).amount);
+  const top = Math.max(1, ...values);
+  const bottom = Math.min(0, ...values);
+  const chart = height - 18; // leaves room for the date labels
+  const zero = chart * top / (top - bottom);
+  const slot = width / Math.max(1, values.length);
+  values.forEach((value, i) => {
+    const barHeight = chart * Math.abs(value) / (top - bottom);
+    ctx.fillStyle = value < 0 ? "#d9534f" : "#4a7bd0";
+    ctx.fillRect(i * slot + 1, value < 0 ? zero : zero - barHeight, Math.max(1, slot - 2), barHeight);
+  });
+  ctx.fillStyle = "#777";
+  lastDaily.forEach((day, i) => {
+    if (i % 7 === 0 || i === lastDaily.length - 1) {
+      ctx.fillText(day.date.slice(5), i * slot, height - 4);
+    }
+  });
+  if (top > 1) {
+    ctx.fillText(formatMoney({ amount: top, currency }), 2, 11);
+  }
+}
+
+function drawThroughput() {
+  const { ctx, width, height } = setupCanvas(document.getElementById("throughput"));
+  ctx.fillStyle = "#777";
+  if (throughput.length < 2) {
+    ctx.fillText("Collecting…", 2, 11);
+    return;
+  }
+  const top = Math.max(1, ...throughput);
+  const step = width / (throughputSamples - 1);
+  const offset = (throughputSamples - throughput.length) * step;
+  ctx.strokeStyle = "#4a7bd0";
+  ctx.lineWidth = 2;
+  ctx.beginPath();
+  throughput.forEach((rate, i) => {
+    const x = offset + i * step;
+    const y = height - 2 - (height - 16) * rate / top;
+    i === 0 ? ctx.moveTo(x, y) : ctx.lineTo(x, y);
+  });
+  ctx.stroke();
+  ctx.fillText(top.toFixed(1) + "/min", 2, 11);
+}
+
+async function refreshSales() {
+  const from = date(-29);
+  const to = date(0);
+  const [summary, daily, recent] = await Promise.all([
+    api("sales/summary?from=" + from + "&to=" + to),
+    api("sales/daily?from=" + from + "&to=" + to),
+    api("sales/recent?limit=15"),
+  ]);
+
+  const select = document.getElementById("currency");
+  const currencies = (summary.gross || []).map((money) => money.currency);
+  if (!currencies.includes(currency)) {
+    // The currency that took the most, for a fresh page
+    const best = (summary.gross || []).slice().sort((a, b) => b.amount - a.amount)[0];
+    currency = best ? best.currency : "";
+  }
+  select.replaceChildren(...currencies.map((code) => new Option(code.toUpperCase(), code, false, code === currency)));
+
+  document.getElementById("net").textContent = formatMoney(inCurrency(summary.net_revenue));
+  document.getElementById("sales").textContent = summary.sales;
+  document.getElementById("refunds").textContent = summary.refunds;
+  document.getElementById("average").textContent = formatMoney(inCurrency(summary.average_order));
+  document.getElementById("buyers").textContent = summary.buyers;
+  lastDaily = daily.days;
+  drawRevenue();
+
+  const rows = document.getElementById("recent");
+  rows.replaceChildren();
+  for (const sale of recent.sales) {
+    const row = document.createElement("tr");
+    cell(row, new Date(sale.created_at).toLocaleString());
+    cell(row, sale.product_name || sale.product);
+    cell(row, sale.full_name || sale.email || "–");
+    cell(row, formatMoney(sale.price), sale.refunded ? "number refunded" : "number");
+    rows.appendChild(row);
+  }
+  if (recent.sales.length === 0) {
+    cell(rows.insertRow(), "No sales yet").colSpan = 4;
+  }
+}
+
+async function refreshMessages() {
+  const stats = (await api("metrics/messages")).messages;
+  const now = Date.now();
+  const received = stats.reduce((sum, s) => sum + s.received, 0);
+  if (previousStats) {
+    const minutes = (now - previousStats.at) / 60000;
+    // A restarted bridge starts its counters again from zero
+    throughput.push(Math.max(0, received - previousStats.received) / minutes);
+    if (throughput.length > throughputSamples) {
+      throughput.shift();
+    }
+  }
+  previousStats = { at: now, received };
+  drawThroughput();
+
+  const rows = document.getElementById("handlers");
+  rows.replaceChildren();
+  for (const s of stats) {
+    const row = document.createElement("tr");
+    cell(row, s.message_type);
+    cell(row, s.received, "number");
+    cell(row, s.handled, "number");
+    cell(row, s.failed, "number");
+    const rate = s.handled ? s.failed / s.handled : 0;
+    cell(row, (rate * 100).toFixed(1) + "%", rate >= 0.05 ? "number bad" : "number");
+    cell(row, s.handled ? s.handler_ms.toFixed(1) + " ms" : "–", "number");
+    rows.appendChild(row);
+  }
+  if (stats.length === 0) {
+    cell(rows.insertRow(), "No messages yet").colSpan = 6;
+  }
+}
+
+async function refreshApprovals() {
+  const section = document.getElementById("approvals-section");
+  let approvals;
+  try {
+    approvals = (await api("approvals")).approvals;
+  } catch (err) {
+    if (err.status === 404) {
+      // A bridge without approvals
+      section.hidden = true;
+      return;
+    }
+    throw err;
+  }
+  section.hidden = false;
+
+  const rows = document.getElementById("approvals");
+  rows.replaceChildren();
+  for (const approval of approvals) {
+    const row = document.createElement("tr");
+    if (approval.id === linkedApproval) {
+      row.className = "highlight";
+    }
+    cell(row, new Date(approval.created_at).toLocaleString());
+    cell(row, approval.summary);
+    const buttons = cell(row, "", "number");
+    for (const [label, verb] of [["Approve", "approve"], ["Reject", "reject"]]) {
+      const button = document.createElement("button");
+      button.textContent = label;
+      button.addEventListener("click", () => decide(approval, verb, row));
+      buttons.appendChild(button);
+    }
+    rows.appendChild(row);
+  }
+  if (approvals.length === 0) {
+    cell(rows.insertRow(), "Nothing is waiting").colSpan = 3;
+  }
+  const linked = approvals.findIndex((approval) => approval.id === linkedApproval);
+  if (linked >= 0) {
+    rows.rows[linked].scrollIntoView({ block: "center" });
+    linkedApproval = ""; // scrolled to once, not on every refresh
+  }
+}
+
+async function decide(approval, verb, row) {
+  row.querySelectorAll("button").forEach((button) => { button.disabled = true; });
+  const error = document.getElementById("error");
+  try {
+    await api("approvals/" + encodeURIComponent(approval.id) + "/" + verb, "POST");
+    error.hidden = true;
+  } catch (err) {
+    if (err.message === "unauthorized") {
+      showLogin();
+      return;
+    }
+    error.textContent = "Could not " + verb + " " + approval.summary + ": " + err.message;
+    error.hidden = false;
+  }
+  refreshApprovals().catch(() => {});
+}
+
+async function refresh() {
+  const error = document.getElementById("error");
+  try {
+    await Promise.all([refreshSales(), refreshMessages(), refreshApprovals()]);
+    error.hidden = true;
+    document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    if (err.message === "unauthorized") {
+      showLogin();
+      return false;
+    }
+    error.textContent = "Could not refresh: " + err.message;
+    error.hidden = false;
+  }
+  return true;
+}
+
+function showLogin() {
+  clearInterval(timer);
+  timer = null;
+  sessionStorage.removeItem("bridgeToken");
+  document.getElementById("dashboard").hidden = true;
+  document.getElementById("login").hidden = false;
+}
+
+let timer = null;
+
+async function start() {
+  document.getElementById("login").hidden = true;
+  document.getElementById("dashboard").hidden = false;
+  if (await refresh() && timer === null) {
+    timer = setInterval(refresh, refreshEvery);
+  }
+}
+
+document.getElementById("login").addEventListener("submit", (event) => {
+  event.preventDefault();
+  token = event.target.elements.token.value;
+  sessionStorage.setItem("bridgeToken", token);
+  start();
+});
+document.getElementById("currency").addEventListener("change", (event) => {
+  currency = event.target.value;
+  refreshSales().catch(() => {});
+});
+window.addEventListener("resize", () => {
+  drawRevenue();
+  drawThroughput();
+});
+
+takeFragment();
+start();
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	api := NewAPI(gb, nil)
+	api.Token = "secret"
+	handler := api.Handler()
+
+	tests := []struct {
+		method, target string
+		wantStatus     int
+		want           string
+	}{
+		// The page and its script are served without the token
+		{"GET", "/dashboard/", http.StatusOK, `<script src="dashboard.js">`},
+		{"GET", "/dashboard/dashboard.js", http.StatusOK, `api("metrics/messages")`},
+		{"GET", "/dashboard/dashboard.css", http.StatusOK, "canvas"},
+		{"GET", "/dashboard/missing.js", http.StatusNotFound, ""},
+		{"POST", "/dashboard/", http.StatusMethodNotAllowed, ""},
+		// but the figures it shows are not
+		{"GET", "/metrics/messages", http.StatusUnauthorized, ""},
+		{"GET", "/dashboard/../metrics/messages", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(tt.method, tt.target, nil))
+		if response.Code != tt.wantStatus || !strings.Contains(response.Body.String(), tt.want) {
+			t.Errorf("%s %s = %d, want %d with %q:\n%s", tt.method, tt.target, response.Code, tt.wantStatus, tt.want, response.Body)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoDeadLetter is returned for a message ID the queue does not hold
+var ErrNoDeadLetter = errors.New("no such dead letter")
+
+// Dead letter statuses
+const (
+	DeadLetterRetrying = "retrying"
+	DeadLetterDead     = "dead"
+)
+
+// DeadLetter is a received message whose handler failed, with its retry
+// history. Attempts counts failed handler runs, the original delivery
+// included.
+type DeadLetter struct {
+	Message     *UniversalMessage    `json:"message"`
+	Channel     CommunicationChannel `json:"channel"`
+	Status      string               `json:"status"`
+	Attempts    int                  `json:"attempts"`
+	LastError   string               `json:"last_error"`
+	FirstFailed time.Time            `json:"first_failed"`
+	LastFailed  time.Time            `json:"last_failed"`
+	NextRetry   *time.Time           `json:"next_retry,omitempty"`
+}
+
+// DeadLetterQueue takes over received messages whose handler returns an
+// error. Each is written to a file of its own in the directory and acked,
+// then its handler is run again after Backoff, doubling up to MaxBackoff, until it
+// succeeds or has failed MaxAttempts times. It then stays in the directory
+// as dead until requeued; messages middleware rejected are dead at once. Retries run one at a time, outside the
+// pipeline's dispatch workers.
+type DeadLetterQueue struct {
+	// MaxAttempts is how many failed runs dead-letter a message
+	MaxAttempts int
+	// Backoff is the delay before the first retry
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	bridge *GoBridge
+	dir    string
+	clock  Clock
+
+	mu      sync.Mutex
+	letters map[string]*DeadLetter
+
+	running  bool
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewDeadLetterQueue loads the dead letters in dir and takes over the
+// bridge's failed messages. A bridge has one queue.
+func NewDeadLetterQueue(gb *GoBridge, dir string) (*DeadLetterQueue, error) {
+	dl := &DeadLetterQueue{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  5 * time.Minute,
+		bridge:      gb,
+		dir:         dir,
+		clock:       gb.clock,
+		letters:     make(map[string]*DeadLetter),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %v", err)
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead letter: %v", err)
+		}
+		var letter DeadLetter
+		err = json.Unmarshal(content, &letter)
+		if err != nil || letter.Message == nil {
+			log.Printf("❌ Skipping unreadable dead letter %s: %v", file, err)
+			continue
+		}
+		letter.Message.receivedOn = letter.Channel
+		dl.letters[letter.Message.ID] = &letter
+	}
+
+	gb.mu.Lock()
+	gb.deadLetters = dl
+	gb.mu.Unlock()
+	return dl, nil
+}
+
+// path is the file a dead letter is kept in
+func (dl *DeadLetterQueue) path(id string) string {
+	return filepath.Join(dl.dir, url.PathEscape(id)+".json")
+}
+
+// delay is the wait before the retry that follows the given failed attempt
+func (dl *DeadLetterQueue) delay(attempts int) time.Duration {
+	delay := dl.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if dl.MaxBackoff > 0 && delay >= dl.MaxBackoff {
+			return dl.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// failed takes a message whose handler just failed. It reports false, so
+// the transport keeps the message, if the dead letter cannot be written.
+func (dl *DeadLetterQueue) failed(message *UniversalMessage, handlerErr error) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	now := dl.clock.Now()
+	letter, known := dl.letters[message.ID]
+	if !known {
+		letter = &DeadLetter{Message: message, Channel: message.receivedOn, FirstFailed: now}
+	}
+	previous := *letter
+	letter.Attempts++
+	letter.LastError = handlerErr.Error()
+	letter.LastFailed = now
+	letter.Status = DeadLetterRetrying
+	letter.NextRetry = nil
+	if letter.Attempts >= dl.MaxAttempts || errors.Is(handlerErr, ErrMessageRejected) || errors.Is(handlerErr, ErrMessageExpired) {
+		letter.Status = DeadLetterDead
+	} else {
+		next := now.Add(dl.delay(letter.Attempts))
+		letter.NextRetry = &next
+	}
+
+	err := writeJSONFile(dl.path(message.ID), letter)
+	if err != nil {
+		log.Printf("❌ Error dead-lettering %s: %v", message.ID, err)
+		if known {
+			*letter = previous
+		}
+		return false
+	}
+	dl.letters[message.ID] = letter
+
+	if letter.Status == DeadLetterDead {
+		log.Printf("❌ Dead-lettered %s after %d attempts: %v", message.ID, letter.Attempts, handlerErr)
+	} else {
+		log.Printf("❌ Handling %s failed (attempt %d of %d), retrying at %s: %v",
+			message.ID, letter.Attempts, dl.MaxAttempts, letter.NextRetry.Format(time.RFC3339), handlerErr)
+	}
+	dl.notify()
+	return true
+}
+
+// dead reports whether the queue has given up on a message
+func (dl *DeadLetterQueue) dead(id string) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	letter := dl.letters[id]
+	return letter != nil && letter.Status == DeadLetterDead
+}
+
+// succeeded forgets a message whose retry was handled
+func (dl *DeadLetterQueue) succeeded(id string) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	delete(dl.letters, id)
+	err := os.Remove(dl.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("❌ Error removing dead letter %s: %v", id, err)
+	}
+	fmt.Printf("✅ Retried message handled: %s\n", id)
+}
+
+func (dl *DeadLetterQueue) notify() {
+	select {
+	case dl.wake <- struct{}{}:
+	default:
+	}
+}
+
+// List returns the dead letters with the given status, or all of them when
+// status is empty, oldest failure first
+func (dl *DeadLetterQueue) List(status string) []DeadLetter {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	list := make([]DeadLetter, 0, len(dl.letters))
+	for _, letter := range dl.letters {
+		if status == "" || letter.Status == status {
+			list = append(list, *letter)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FirstFailed.Before(list[j].FirstFailed) })
+	return list
+}
+
+// Requeue gives a message a fresh set of attempts, starting now
+func (dl *DeadLetterQueue) Requeue(id string) (DeadLetter, error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	letter, ok := dl.letters[id]
+	if !ok {
+		return DeadLetter{}, fmt.Errorf("%w: %s", ErrNoDeadLetter, id)
+	}
+	previous := *letter
+	now := dl.clock.Now()
+	letter.Status = DeadLetterRetrying
+	letter.Attempts = 0
+	letter.NextRetry = &now
+	err := writeJSONFile(dl.path(id), letter)
+	if err != nil {
+		*letter = previous
+		return DeadLetter{}, fmt.Errorf("failed to requeue %s: %v", id, err)
+	}
+	fmt.Printf("🔁 Requeued dead letter %s\n", id)
+	dl.notify()
+	return *letter, nil
+}
+
+// due returns the next message to retry, or how long until one is due
+func (dl *DeadLetterQueue) due() (*UniversalMessage, time.Duration, bool) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	now := dl.clock.Now()
+	var next *DeadLetter
+	for _, letter := range dl.letters {
+		if letter.Status != DeadLetterRetrying || letter.NextRetry == nil {
+			continue
+		}
+		if next == nil || letter.NextRetry.Before(*next.NextRetry) {
+			next = letter
+		}
+	}
+	if next == nil {
+		return nil, 0, false
+	}
+	if wait := next.NextRetry.Sub(now); wait > 0 {
+		return nil, wait, true
+	}
+	// Cleared so a slow retry is not picked up twice
+	next.NextRetry = nil
+	return next.Message, 0, true
+}
+
+// Start retries due messages until Close
+func (dl *DeadLetterQueue) Start() {
+	dl.mu.Lock()
+	dl.running = true
+	dl.mu.Unlock()
+
+	go func() {
+		defer close(dl.done)
+		for {
+			message, wait, pending := dl.due()
+			if message != nil {
+				dl.retry(message)
+				continue
+			}
+
+			var timer <-chan time.Time
+			if pending {
+				timer = dl.clock.After(wait)
+			}
+			select {
+			case <-dl.stop:
+				return
+			case <-dl.wake:
+			case <-timer:
+			}
+		}
+	}()
+}
+
+// retry runs the message's handler again, recording the outcome. Receive
+// hooks and sinks saw the message on its first delivery and are not run
+// again. A message that expired while it waited is dead rather than retried.
+// A message that asked for a receipt gets it once handled or dead.
+func (dl *DeadLetterQueue) retry(message *UniversalMessage) {
+	var err error
+	if message.Expired(dl.clock.Now()) {
+		err = fmt.Errorf("%w at %s before it could be retried", ErrMessageExpired, message.ExpiresAt)
+	} else {
+		fmt.Printf("🔁 Retrying message %s\n", message.ID)
+		err = dl.bridge.runHandler(message)
+	}
+	if err == nil {
+		dl.succeeded(message.ID)
+		dl.bridge.sendReceipt(message, nil)
+		return
+	}
+	if dl.failed(message, err) {
+		if dl.dead(message.ID) {
+			dl.bridge.sendReceipt(message, err)
+		}
+	} else {
+		// Not recorded; try again after the last delay rather than spinning
+		dl.mu.Lock()
+		if letter, ok := dl.letters[message.ID]; ok {
+			next := dl.clock.Now().Add(dl.delay(letter.Attempts))
+			letter.NextRetry = &next
+		}
+		dl.mu.Unlock()
+	}
+}
+
+// Close stops retrying; messages still waiting are retried after a restart
+func (dl *DeadLetterQueue) Close() {
+	dl.stopOnce.Do(func() { close(dl.stop) })
+	dl.mu.Lock()
+	running := dl.running
+	dl.mu.Unlock()
+	if running {
+		<-dl.done
+	}
+}
+
+// Handler serves /dead-letters: GET lists them, optionally filtered by a
+// status query parameter, and POST /dead-letters/{id}/requeue requeues one
+func (dl *DeadLetterQueue) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dead-letters"), "/")
+		if rest == "" {
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"dead_letters": dl.List(r.URL.Query().Get("status"))})
+			return
+		}
+
+		if !strings.HasSuffix(rest, "/requeue") {
+			writeAPIError(w, http.StatusNotFound, "use /dead-letters/{id}/requeue")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(rest, "/requeue"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad message id: %v", err))
+			return
+		}
+		letter, err := dl.Requeue(id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNoDeadLetter) {
+				status = http.StatusNotFound
+			}
+			writeAPIError(w, status, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, letter)
+	})
+}
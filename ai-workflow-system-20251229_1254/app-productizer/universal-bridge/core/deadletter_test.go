@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyTransport is a MemoryTransport whose first failSends sends fail
+type flakyTransport struct {
+	*MemoryTransport
+	failSends int32
+}
+
+func (ft *flakyTransport) Send(message *UniversalMessage) error {
+	if atomic.AddInt32(&ft.failSends, -1) >= 0 {
+		return fmt.Errorf("peer unreachable")
+	}
+	return ft.MemoryTransport.Send(message)
+}
+
+// newDeadLetterBridge returns a bridge over the transport with a dead
+// letter queue that is retried by hand with retryDue
+func newDeadLetterBridge(t *testing.T, transport Transport, maxAttempts int) (*GoBridge, *ManualClock, *DeadLetterQueue) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("dl")))
+	t.Cleanup(func() { gb.Close() })
+	dl, err := NewDeadLetterQueue(gb, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl.MaxAttempts = maxAttempts
+	return gb, clock, dl
+}
+
+// retryDue runs every retry that comes due as the clock moves on
+func retryDue(dl *DeadLetterQueue, clock *ManualClock) {
+	for i := 0; i < 100; i++ {
+		clock.Advance(dl.MaxBackoff)
+		message, _, pending := dl.due()
+		if !pending {
+			return
+		}
+		if message != nil {
+			dl.retry(message)
+		}
+	}
+}
+
+func TestDeadLetterRetryRunsOnlyTheHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int // handler runs that fail before one succeeds
+		wantRuns   int
+		wantStatus string // of the dead letter left, or "" for none
+	}{
+		{"succeeds on retry", 2, 3, ""},
+		{"dead after MaxAttempts", 10, 4, DeadLetterDead},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewMemoryTransport()
+			gb, clock, dl := newDeadLetterBridge(t, transport, 4)
+
+			var hooks, sunk, runs int32
+			gb.OnReceive(func(*UniversalMessage) { atomic.AddInt32(&hooks, 1) })
+			sink := gb.AddSink("count", SinkConfig{Types: []MessageType{FunctionCall}}, func(*UniversalMessage) error {
+				atomic.AddInt32(&sunk, 1)
+				return nil
+			})
+			gb.OnMessage(FunctionCall, func(*UniversalMessage) error {
+				if int(atomic.AddInt32(&runs, 1)) <= tt.failures {
+					return errors.New("handler failed")
+				}
+				return nil
+			})
+
+			call := gb.NewMessage(FunctionCall, "go", map[string]interface{}{"function_name": "flaky"}, SharedMemory)
+			if err := transport.Inject(call); err != nil {
+				t.Fatal(err)
+			}
+			retryDue(dl, clock)
+			sink.Drain(time.Second)
+
+			if n := atomic.LoadInt32(&runs); int(n) != tt.wantRuns {
+				t.Fatalf("handler ran %d times, want %d", n, tt.wantRuns)
+			}
+			if h, s := atomic.LoadInt32(&hooks), atomic.LoadInt32(&sunk); h != 1 || s != 1 {
+				t.Fatalf("receive hooks ran %d times and the sink got %d messages, want once each", h, s)
+			}
+			letters := dl.List("")
+			switch {
+			case tt.wantStatus == "" && len(letters) != 0:
+				t.Fatalf("dead letters left: %+v", letters)
+			case tt.wantStatus != "" && (len(letters) != 1 || letters[0].Status != tt.wantStatus):
+				t.Fatalf("dead letters %+v, want one %s", letters, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGumroadFunctionReplyRetryDoesNotCallAgain(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, `{"success": true, "products": [{"id": "p1", "name": "Course"}]}`)
+	}))
+	defer server.Close()
+	client := NewGumroadClient()
+	client.BaseURL = server.URL
+	client.AccessToken = "token"
+
+	transport := &flakyTransport{MemoryTransport: NewMemoryTransport(), failSends: 2}
+	gb, clock, dl := newDeadLetterBridge(t, transport, 5)
+	ServeGumroadFunctions(gb, client)
+
+	call := gb.NewMessage(FunctionCall, "go", map[string]interface{}{"function_name": "gumroad.list_products"}, SharedMemory)
+	if err := transport.Inject(call); err != nil {
+		t.Fatal(err)
+	}
+	retryDue(dl, clock)
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Gumroad called %d times, want once", n)
+	}
+	replies := transport.SentOfType(AIResponse)
+	if len(replies) != 1 || !flagArg(replies[0].Payload, "success") {
+		t.Fatalf("replies sent: %v", replies)
+	}
+	if letters := dl.List(""); len(letters) != 0 {
+		t.Fatalf("dead letters left: %+v", letters)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// idempotencyKey names the logical message a received copy is of: its
+// idempotency_key, or its ID when it was sent without one
+func (m *UniversalMessage) idempotencyKey() string {
+	if m.IdempotencyKey != "" {
+		return m.IdempotencyKey
+	}
+	return m.ID
+}
+
+// dedupeState is what the dedupe cache knows about an idempotency key
+type dedupeState int
+
+const (
+	dedupeNew dedupeState = iota
+	dedupeInFlight
+	dedupeCompleted
+)
+
+// dedupeEntry is a completed key as the seen file records it, or a key
+// forgotten since
+type dedupeEntry struct {
+	Key       string    `json:"key"`
+	At        time.Time `json:"at"`
+	Forgotten bool      `json:"forgotten,omitempty"`
+}
+
+// dedupeCache tracks in-flight keys and the keys completed within the
+// window, no more than size of them. With a path, completed keys are
+// appended to it as JSON lines and read back when the cache is opened, so
+// redeliveries are still skipped after a restart; the file is rewritten
+// without expired keys when it has grown to twice what it holds.
+type dedupeCache struct {
+	clock  Clock
+	window time.Duration
+	size   int
+	path   string
+
+	mu        sync.Mutex
+	inFlight  map[string]bool
+	completed map[string]time.Time
+	order     []dedupeEntry // completion order, oldest first
+	file      *os.File
+	lines     int // entries in the file, live or not
+}
+
+// newDedupeCache opens the cache, reading the keys at path, if any. A file
+// that cannot be read is logged and left, and the cache kept in memory.
+func newDedupeCache(clock Clock, window time.Duration, size int, path string) *dedupeCache {
+	c := &dedupeCache{
+		clock:     clock,
+		window:    window,
+		size:      size,
+		path:      path,
+		inFlight:  make(map[string]bool),
+		completed: make(map[string]time.Time),
+	}
+	if path == "" {
+		return c
+	}
+	err := c.load()
+	if err == nil {
+		err = c.compactLocked()
+	}
+	if err != nil {
+		log.Printf("❌ Deduplicating in memory only: %v", err)
+		c.path = ""
+	}
+	return c
+}
+
+// load reads the seen file, keeping the keys still within the window
+func (c *dedupeCache) load() error {
+	file, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read seen keys: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry dedupeEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Key == "" {
+			continue // a line cut short by a crash
+		}
+		if entry.Forgotten {
+			delete(c.completed, entry.Key)
+			continue
+		}
+		c.addLocked(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read seen keys %s: %v", c.path, err)
+	}
+	return nil
+}
+
+// begin marks the key in flight unless it is already known
+func (c *dedupeCache) begin(key string) dedupeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if at, ok := c.completed[key]; ok && c.clock.Now().Sub(at) < c.window {
+		return dedupeCompleted
+	}
+	if c.inFlight[key] {
+		return dedupeInFlight
+	}
+	c.inFlight[key] = true
+	return dedupeNew
+}
+
+// abort forgets an in-flight key so a redelivery can be retried
+func (c *dedupeCache) abort(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inFlight, key)
+}
+
+// complete moves a key from in flight to completed and records it in the
+// seen file. A failed write is logged: the key is still skipped until the
+// bridge restarts.
+func (c *dedupeCache) complete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, key)
+	now := c.clock.Now()
+	if at, ok := c.completed[key]; ok && now.Sub(at) < c.window {
+		return
+	}
+	entry := dedupeEntry{Key: key, At: now.UTC()}
+	c.addLocked(entry)
+	c.recordLocked(entry)
+}
+
+// forget drops a completed key, so the next copy of it is handled again
+func (c *dedupeCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, key)
+	if _, ok := c.completed[key]; !ok {
+		return
+	}
+	delete(c.completed, key)
+	c.recordLocked(dedupeEntry{Key: key, Forgotten: true})
+}
+
+// recordLocked appends an entry to the seen file
+func (c *dedupeCache) recordLocked(entry dedupeEntry) {
+	if c.file == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		c.lines++
+		if c.lines > 2*len(c.order)+1024 {
+			err = c.compactLocked()
+		}
+	}
+	if err != nil {
+		log.Printf("❌ Error recording seen key %s: %v", entry.Key, err)
+	}
+}
+
+// addLocked remembers a completed key, or when it completed again,
+// forgetting keys past the window and the oldest beyond size
+func (c *dedupeCache) addLocked(entry dedupeEntry) {
+	c.completed[entry.Key] = entry.At
+	c.order = append(c.order, entry)
+
+	cutoff := c.clock.Now().Add(-c.window)
+	drop := 0
+	for drop < len(c.order) && (len(c.order)-drop > c.size || !c.order[drop].At.After(cutoff)) {
+		// An older entry of a key completed again leaves the key
+		if oldest := c.order[drop]; c.completed[oldest.Key].Equal(oldest.At) {
+			delete(c.completed, oldest.Key)
+		}
+		drop++
+	}
+	// Appending copies the rest to a new array once this one is full
+	c.order = c.order[drop:]
+}
+
+// compactLocked rewrites the seen file with only the remembered keys and
+// reopens it for appending
+func (c *dedupeCache) compactLocked() error {
+	err := os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create seen keys directory: %v", err)
+	}
+	temp := c.path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("failed to write seen keys: %v", err)
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	lines := 0
+	for _, entry := range c.order {
+		// Only each key's latest completion, and no forgotten keys
+		if !c.completed[entry.Key].Equal(entry.At) {
+			continue
+		}
+		if err = encoder.Encode(entry); err != nil {
+			break
+		}
+		lines++
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, c.path)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("failed to write seen keys: %v", err)
+	}
+
+	appended, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open seen keys: %v", err)
+	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file = appended
+	c.lines = lines
+	return nil
+}
+
+// close closes the seen file
+func (c *dedupeCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedupeCache(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "dedupe", "seen.jsonl")
+	cache := newDedupeCache(clock, time.Hour, 3, path)
+	reopen := func() {
+		cache.close()
+		cache = newDedupeCache(clock, time.Hour, 3, path)
+	}
+	steps := []struct {
+		do   func()
+		id   string
+		want dedupeState
+	}{
+		{nil, "a", dedupeNew},
+		{nil, "a", dedupeInFlight},
+		{func() { cache.abort("a") }, "a", dedupeNew},
+		{func() { cache.complete("a") }, "a", dedupeCompleted},
+		{func() { cache.complete("b"); cache.complete("c"); cache.complete("d") }, "a", dedupeNew}, // d evicted a
+		{nil, "b", dedupeCompleted},
+		// Reopened, the file still knows b, c and d
+		{reopen, "c", dedupeCompleted},
+		{nil, "a", dedupeNew},
+		{func() { clock.Advance(time.Hour) }, "b", dedupeNew},
+		// An hour later, only e is left
+		{func() { cache.complete("e"); reopen() }, "c", dedupeNew},
+		{nil, "e", dedupeCompleted},
+		// A forgotten key stays forgotten
+		{func() { cache.forget("e"); reopen() }, "e", dedupeNew},
+	}
+	for i, step := range steps {
+		if step.do != nil {
+			step.do()
+		}
+		if got := cache.begin(step.id); got != step.want {
+			t.Fatalf("step %d: begin(%q) = %v, want %v", i, step.id, got, step.want)
+		}
+	}
+	cache.close()
+	if cache.lines != 0 {
+		t.Errorf("seen file holds %d keys after reopening, want none", cache.lines)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	config := DefaultPipelineConfig()
+	config.DedupePath = filepath.Join(t.TempDir(), "seen.jsonl")
+	var runs int32
+	open := func() (*GoBridge, *MemoryTransport) {
+		transport := NewMemoryTransport()
+		gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithPipelineConfig(config))
+		gb.OnMessage(DataSync, func(*UniversalMessage) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+		return gb, transport
+	}
+
+	ids := NewSequentialIDs("py")
+	deliver := func(transport *MemoryTransport, key string) {
+		t.Helper()
+		message := newUniversalMessage(clock, ids, DataSync, "python", "go", map[string]interface{}{"order": key}, SharedMemory)
+		message.IdempotencyKey = key
+		if err := transport.Inject(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gb, transport := open()
+	deliver(transport, "order-1")
+	deliver(transport, "order-1") // resent with a new ID
+	deliver(transport, "order-2")
+	deliver(transport, "") // keyed by its ID
+	if n := atomic.LoadInt32(&runs); n != 3 {
+		t.Errorf("handler ran %d times, want 3", n)
+	}
+
+	// The keys outlive the bridge, until the window has passed
+	gb.Close()
+	gb, transport = open()
+	t.Cleanup(func() { gb.Close() })
+	deliver(transport, "order-1")
+	if n := atomic.LoadInt32(&runs); n != 3 {
+		t.Errorf("handler ran %d times after a restart, want 3", n)
+	}
+	clock.Advance(config.DedupeWindow)
+	deliver(transport, "order-2")
+	if n := atomic.LoadInt32(&runs); n != 4 {
+		t.Errorf("handler ran %d times after the window, want 4", n)
+	}
+	if len(transport.Acked()) != 2 {
+		t.Errorf("acked %d copies, want both", len(transport.Acked()))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDiscord records the role changes the bot makes
+type fakeDiscord struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (fd *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bot bot-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	fd.calls = append(fd.calls, r.Method+" "+r.URL.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// waitFor returns the calls once there are n of them
+func (fd *fakeDiscord) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		fd.mu.Lock()
+		calls := append([]string(nil), fd.calls...)
+		fd.mu.Unlock()
+		if len(calls) >= n {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Discord got %v, want %d calls", calls, n)
+		}
+	}
+}
+
+func TestDiscordRolesVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "discord.yaml")
+	err = ioutil.WriteFile(configPath, []byte(fmt.Sprintf("guild_id: \"guild\"\npublic_key: %s\nproducts:\n  community: [\"members\"]\n", hex.EncodeToString(public))), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadDiscordConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	codes := make(map[string]string) // email → code
+	gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		codes[stringArg(message.Payload, "to")] = regexp.MustCompile(`\d{6}`).FindString(stringArg(message.Payload, "text"))
+		return message
+	})
+	sales, err := NewSalesStore(filepath.Join(dir, "sales.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+
+	discord := &fakeDiscord{}
+	server := httptest.NewServer(discord)
+	t.Cleanup(server.Close)
+	dr, err := NewDiscordRoles(gb, config, sales, NewTemplateStore("templates"), "bot-token", filepath.Join(dir, "discord.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.APIURL = server.URL
+
+	ids := NewSequentialIDs("gumroad")
+	event := func(payload map[string]interface{}) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "product_permalink": "community", "email": "ada@example.com", "price": "10", "currency": "usd"})
+
+	handler := dr.Handler()
+	interact := func(body string, sign bool) (int, map[string]interface{}) {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(body))
+		if sign {
+			timestamp := fmt.Sprint(clock.Now().Unix())
+			request.Header.Set("X-Signature-Timestamp", timestamp)
+			request.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(private, []byte(timestamp+body))))
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		var answer map[string]interface{}
+		json.Unmarshal(response.Body.Bytes(), &answer)
+		return response.Code, answer
+	}
+	verify := func(user, guild string, options map[string]string) string {
+		t.Helper()
+		var list []map[string]string
+		for name, value := range options {
+			list = append(list, map[string]string{"name": name, "value": value})
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"type": discordApplicationCommand, "guild_id": guild,
+			"member": map[string]interface{}{"user": map[string]string{"id": user}},
+			"data":   map[string]interface{}{"name": "verify", "options": list},
+		})
+		status, answer := interact(string(body), true)
+		if status != http.StatusOK {
+			t.Fatalf("/verify answered %d: %v", status, answer)
+		}
+		if flags := mapArg(answer, "data")["flags"]; flags != float64(discordEphemeral) {
+			t.Errorf("/verify reply flags %v, want ephemeral", flags)
+		}
+		return stringArg(mapArg(answer, "data"), "content")
+	}
+
+	// Only requests Discord signed are answered
+	if status, _ := interact(`{"type": 1}`, false); status != http.StatusUnauthorized {
+		t.Errorf("unsigned ping answered %d, want 401", status)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(`{"type": 1}`))
+	request.Header.Set("X-Signature-Timestamp", "1")
+	request.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(private, []byte("1{\"type\": 2}"))))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("ping signed over another body answered %d, want 401", response.Code)
+	}
+	if status, answer := interact(`{"type": 1}`, true); status != http.StatusOK || answer["type"] != float64(discordPong) {
+		t.Errorf("ping answered %d %v, want a pong", status, answer)
+	}
+
+	// The reply does not say whether an email bought anything
+	if reply := verify("u1", "other-guild", map[string]string{"email": "ada@example.com"}); !strings.Contains(reply, "community server") {
+		t.Errorf("/verify in another server replied %q", reply)
+	}
+	nobody := verify("u1", "guild", map[string]string{"email": "bob@example.com"})
+	ada := verify("u1", "guild", map[string]string{"email": "Ada@Example.com"})
+	if strings.Replace(nobody, "bob@", "ada@", 1) != ada {
+		t.Errorf("replies differ for a buyer and a stranger: %q and %q", ada, nobody)
+	}
+	if _, ok := codes["bob@example.com"]; ok || len(codes["ada@example.com"]) != 6 {
+		t.Fatalf("emailed codes %v, want one to ada alone", codes)
+	}
+
+	if reply := verify("u1", "guild", map[string]string{"code": "000000x"}); !strings.Contains(reply, "not right") {
+		t.Errorf("a wrong code replied %q", reply)
+	}
+	if reply := verify("u1", "guild", map[string]string{"code": codes["ada@example.com"]}); !strings.HasPrefix(reply, "Verified!") {
+		t.Fatalf("the emailed code replied %q", reply)
+	}
+	if calls := discord.waitFor(t, 1); calls[0] != "PUT /guilds/guild/members/u1/roles/members" {
+		t.Errorf("Discord got %v, want the members role given", calls)
+	}
+	if reply := verify("u1", "guild", map[string]string{"code": codes["ada@example.com"]}); !strings.Contains(reply, "expired") {
+		t.Errorf("a used code replied %q", reply)
+	}
+
+	// A refund takes the role away again
+	event(map[string]interface{}{"resource_name": "refund", "sale_id": "s1", "email": "ada@example.com", "price": "10", "currency": "usd"})
+	if calls := discord.waitFor(t, 2); calls[1] != "DELETE /guilds/guild/members/u1/roles/members" {
+		t.Errorf("Discord got %v, want the members role taken", calls)
+	}
+	members := dr.Members()
+	if len(members) != 1 || members[0].Email != "ada@example.com" || len(members[0].Roles) != 0 {
+		t.Errorf("members %+v, want ada without roles", members)
+	}
+
+	// Codes expire, and too many wrong guesses retire one
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s2", "product_permalink": "community", "email": "ada@example.com", "price": "10", "currency": "usd"})
+	verify("u2", "guild", map[string]string{"email": "ada@example.com"})
+	clock.Advance(discordCodeTTL + time.Second)
+	if reply := verify("u2", "guild", map[string]string{"code": codes["ada@example.com"]}); !strings.Contains(reply, "expired") {
+		t.Errorf("an expired code replied %q", reply)
+	}
+	verify("u2", "guild", map[string]string{"email": "ada@example.com"})
+	for i := 0; i < discordMaxAttempts; i++ {
+		verify("u2", "guild", map[string]string{"code": "guess"})
+	}
+	if reply := verify("u2", "guild", map[string]string{"code": codes["ada@example.com"]}); !strings.Contains(reply, "expired") {
+		t.Errorf("a code after %d wrong guesses replied %q", discordMaxAttempts, reply)
+	}
+
+	// Members survive a restart
+	other := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { other.Close() })
+	reopened, err := NewDiscordRoles(other, config, sales, nil, "bot-token", filepath.Join(dir, "discord.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members := reopened.Members(); len(members) != 1 || members[0].UserID != "u1" {
+		t.Errorf("members after a restart %+v, want u1", members)
+	}
+}
+
+func TestLoadDiscordConfigRejectsInvalidConfigs(t *testing.T) {
+	key := hex.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"no guild", "public_key: " + key + "\nproducts: {community: [r]}\n", "no guild_id"},
+		{"no public key", "guild_id: g\nproducts: {community: [r]}\n", "public_key"},
+		{"short public key", "guild_id: g\npublic_key: abcd\nproducts: {community: [r]}\n", "public_key"},
+		{"no products", "guild_id: g\npublic_key: " + key + "\n", "no products"},
+		{"product without roles", "guild_id: g\npublic_key: " + key + "\nproducts: {community: []}\n", "community has no roles"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "discord.yaml")
+		if err := ioutil.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadDiscordConfig(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: LoadDiscordConfig = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// defaultCurrency is assumed for Gumroad payloads that carry no currency
+const defaultCurrency = "usd"
+
+// Sale is a validated Gumroad sale. Fields keeps the payload as received, for
+// templates and the fields the bridge does not model.
+type Sale struct {
+	ID               string    `json:"sale_id"`
+	Product          string    `json:"product"` // product_id, or the permalink without one
+	Permalink        string    `json:"product_permalink,omitempty"`
+	ProductName      string    `json:"product_name,omitempty"`
+	Email            string    `json:"email,omitempty"`
+	FullName         string    `json:"full_name,omitempty"`
+	Price            Money     `json:"price"`
+	OfferCode        string    `json:"offer_code,omitempty"`
+	SubscriptionID   string    `json:"subscription_id,omitempty"`
+	PayWhatYouWant   bool      `json:"pay_what_you_want,omitempty"`
+	Refunded         bool      `json:"refunded,omitempty"`
+	Gift             bool      `json:"gift,omitempty"`
+	GifterEmail      string    `json:"gifter_email,omitempty"`
+	BundleSaleID     string    `json:"bundle_sale_id,omitempty"`
+	BundleComponents []string  `json:"bundle_components,omitempty"`
+	CreatedAt        time.Time `json:"created_at"` // zero without a sale_timestamp
+
+	Fields map[string]interface{} `json:"-"`
+}
+
+// ParseSale validates a Gumroad sale payload. Gumroad's price is read in the
+// currency's major units, as the ping's other money fields are displayed.
+func ParseSale(payload map[string]interface{}) (Sale, error) {
+	sale := Sale{
+		ID:             stringArg(payload, "sale_id"),
+		Product:        productKey(payload),
+		Permalink:      stringArg(payload, "product_permalink"),
+		ProductName:    stringArg(payload, "product_name"),
+		Email:          NormalizeEmail(stringArg(payload, "email")),
+		FullName:       stringArg(payload, "full_name"),
+		OfferCode:      stringArg(payload, "offer_code"),
+		SubscriptionID: stringArg(payload, "subscription_id"),
+		PayWhatYouWant: flagArg(payload, "pay_what_you_want"),
+		Refunded:       flagArg(payload, "refunded"),
+		Gift:           payload["gift"] == true,
+		GifterEmail:    NormalizeEmail(stringArg(payload, "gifter_email")),
+		BundleSaleID:   stringArg(payload, "bundle_sale_id"),
+		Fields:         payload,
+	}
+	if sale.ID == "" {
+		return Sale{}, fmt.Errorf("sale has no sale_id")
+	}
+	if sale.Email != "" && !strings.Contains(sale.Email, "@") {
+		return Sale{}, fmt.Errorf("sale %s has an invalid email %q", sale.ID, sale.Email)
+	}
+
+	currency, err := parseCurrency(stringArg(payload, "currency"))
+	if err != nil {
+		return Sale{}, fmt.Errorf("sale %s: %v", sale.ID, err)
+	}
+	sale.Price = NewMoney(0, currency)
+	if value, present := payload["price"]; present {
+		sale.Price, err = moneyArg(value, currency)
+		if err != nil || sale.Price.Amount < 0 {
+			return Sale{}, fmt.Errorf("sale %s has an invalid price %v", sale.ID, value)
+		}
+	}
+
+	if value := stringArg(payload, "sale_timestamp"); value != "" {
+		sale.CreatedAt, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return Sale{}, fmt.Errorf("sale %s has an invalid sale_timestamp: %v", sale.ID, err)
+		}
+	}
+	if components, ok := payload["bundle_components"].([]interface{}); ok {
+		for _, component := range components {
+			sale.BundleComponents = append(sale.BundleComponents, stringValue(component))
+		}
+	}
+	return sale, nil
+}
+
+// TemplateData returns Payload with price written from Price in the
+// currency's major units, e.g. "30.00", for emails that show the amount
+func (s Sale) TemplateData() map[string]interface{} {
+	data := s.Payload()
+	data["price"] = s.Price.Decimal()
+	return data
+}
+
+// IsBundle reports whether the sale is a bundle that was split into its
+// component sales; per-product figures count the components instead
+func (s Sale) IsBundle() bool {
+	return isBundleSale(s.Fields)
+}
+
+// Payload returns the received fields with the validated values written
+// back in Gumroad's names plus price_cents, so every reader downstream sees
+// the same normalized sale
+func (s Sale) Payload() map[string]interface{} {
+	payload := mergePayload(s.Fields, map[string]interface{}{
+		"currency":    s.Price.Currency,
+		"price_cents": s.Price.Amount,
+	})
+	if s.Email != "" {
+		payload["email"] = s.Email
+	}
+	if !s.CreatedAt.IsZero() {
+		payload["sale_timestamp"] = s.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return payload
+}
+
+// Refund is a full or partial refund of a sale
+type Refund struct {
+	SaleID     string    `json:"sale_id"`
+	Amount     Money     `json:"amount"`
+	Partial    bool      `json:"partial,omitempty"`
+	RefundedAt time.Time `json:"refunded_at"`
+}
+
+// ParseRefund reads a Gumroad refund ping; without amount_refunded_in_cents
+// the whole price is refunded. Partial is judged against the ping's price,
+// which SalesStore.Refund rechecks against the stored sale.
+func ParseRefund(payload map[string]interface{}, now time.Time) (Refund, error) {
+	sale, err := ParseSale(payload)
+	if err != nil {
+		return Refund{}, err
+	}
+	refund := Refund{SaleID: sale.ID, Amount: sale.Price, RefundedAt: now}
+	if value, present := payload["amount_refunded_in_cents"]; present {
+		amount, ok := toNumber(value)
+		if !ok || amount < 0 || amount != math.Trunc(amount) || amount > math.MaxInt64/2 {
+			return Refund{}, fmt.Errorf("refund of %s has an invalid amount %v", sale.ID, value)
+		}
+		refund.Amount = NewMoney(int64(amount), sale.Price.Currency)
+		refund.Partial = refund.Amount.Amount > 0 && refund.Amount.Amount < sale.Price.Amount
+	}
+	return refund, nil
+}
+
+// Subscription is a membership's latest known state, Status being one of
+// the Membership statuses
+type Subscription struct {
+	ID        string    `json:"id"`
+	Product   string    `json:"product"`
+	Email     string    `json:"email,omitempty"`
+	Tier      string    `json:"tier,omitempty"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ParseSubscriptionEvent reads the subscription a Gumroad payload is about:
+// a membership sale, or a subscription_updated, cancellation,
+// subscription_ended or subscription_restarted event. ok is false for
+// payloads about no subscription. Events carry no status of their own for a
+// plan change, so that leaves Status empty.
+func ParseSubscriptionEvent(payload map[string]interface{}, now time.Time) (subscription Subscription, ok bool) {
+	id := stringArg(payload, "subscription_id")
+	if id == "" {
+		return Subscription{}, false
+	}
+	subscription = Subscription{ID: id, Product: productKey(payload), UpdatedAt: now}
+
+	switch stringArg(payload, "resource_name") {
+	case "sale":
+		subscription.Email = NormalizeEmail(stringArg(payload, "email"))
+		subscription.Tier = saleTier(payload)
+		subscription.Status = MembershipActive
+		switch {
+		case flagArg(payload, "ended"):
+			subscription.Status = MembershipEnded
+		case flagArg(payload, "cancelled"):
+			subscription.Status = MembershipCancelled
+		}
+	case "subscription_updated":
+		subscription.Tier = planTier(payload["new_plan"])
+	case "cancellation":
+		subscription.Status = MembershipCancelled
+	case "subscription_ended":
+		subscription.Status = MembershipEnded
+	case "subscription_restarted":
+		subscription.Status = MembershipActive
+	default:
+		return Subscription{}, false
+	}
+	if subscription.Email == "" {
+		subscription.Email = NormalizeEmail(stringArg(payload, "user_email"))
+	}
+	return subscription, true
+}
+
+// Customer is who bought: identified by normalized email
+type Customer struct {
+	Email  string `json:"email"`
+	Name   string `json:"name,omitempty"`
+	Locale string `json:"locale,omitempty"`
+}
+
+// parseCurrency normalizes an ISO 4217 code to lower case, as Gumroad sends it
+func parseCurrency(code string) (string, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return defaultCurrency, nil
+	}
+	if len(code) != 3 || strings.Trim(code, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return "", fmt.Errorf("invalid currency %q", code)
+	}
+	return code, nil
+}
+
+// flagArg reads a boolean Gumroad sends either as JSON or as "true"
+func flagArg(payload map[string]interface{}, key string) bool {
+	return payload[key] == true || stringArg(payload, key) == "true"
+}
+
+// isGumroadEvent reports whether a message's payload is a Gumroad resource:
+// a SaleEvent from the webhook, or a data_sync relayed by a peer bridge
+func isGumroadEvent(message *UniversalMessage) bool {
+	return message.MessageType == SaleEvent || message.MessageType == DataSync
+}
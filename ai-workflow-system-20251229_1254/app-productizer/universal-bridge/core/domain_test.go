@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSale(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     map[string]interface{}
+		wantProduct string
+		wantPrice   Money
+		wantErr     string
+	}{
+		{"product ID and decimal price", map[string]interface{}{"sale_id": "s1", "product_id": "p1", "product_permalink": "kit", "price": "29.99", "currency": "USD"}, "p1", NewMoney(2999, "usd"), ""},
+		{"permalink and numeric price", map[string]interface{}{"sale_id": "s1", "product_permalink": "kit", "price": 29.99}, "kit", NewMoney(2999, "usd"), ""},
+		{"zero-decimal currency", map[string]interface{}{"sale_id": "s1", "product_id": "p1", "price": "1500", "currency": "jpy"}, "p1", NewMoney(1500, "jpy"), ""},
+		{"free", map[string]interface{}{"sale_id": "s1", "product_id": "p1", "currency": "eur"}, "p1", NewMoney(0, "eur"), ""},
+		{"no sale_id", map[string]interface{}{"product_id": "p1", "price": "10"}, "", Money{}, "no sale_id"},
+		{"invalid email", map[string]interface{}{"sale_id": "s1", "email": "ada.example.com"}, "", Money{}, "invalid email"},
+		{"invalid currency", map[string]interface{}{"sale_id": "s1", "price": "10", "currency": "dollars"}, "", Money{}, "invalid currency"},
+		{"negative price", map[string]interface{}{"sale_id": "s1", "price": "-1"}, "", Money{}, "invalid price"},
+		{"unreadable price", map[string]interface{}{"sale_id": "s1", "price": "ten"}, "", Money{}, "invalid price"},
+		{"invalid timestamp", map[string]interface{}{"sale_id": "s1", "sale_timestamp": "yesterday"}, "", Money{}, "invalid sale_timestamp"},
+	}
+	for _, tt := range tests {
+		sale, err := ParseSale(tt.payload)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: ParseSale = %v, want an error containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ParseSale: %v", tt.name, err)
+			continue
+		}
+		if sale.Product != tt.wantProduct || sale.Price != tt.wantPrice {
+			t.Errorf("%s: product %q price %v, want %q %v", tt.name, sale.Product, sale.Price, tt.wantProduct, tt.wantPrice)
+		}
+	}
+}
+
+func TestParseSaleFields(t *testing.T) {
+	sale, err := ParseSale(map[string]interface{}{
+		"sale_id": "s1", "product_id": "p1", "email": " Ada@Example.com ", "gifter_email": "BOB@example.com",
+		"refunded": "true", "gift": true, "pay_what_you_want": true, "subscription_id": "sub1",
+		"sale_timestamp": "2026-01-15T09:30:00Z", "bundle_components": []interface{}{"s1/a", "s1/b"},
+		"price": "10", "currency": "usd", "custom": "kept",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sale.Email != "ada@example.com" || sale.GifterEmail != "bob@example.com" {
+		t.Errorf("emails %q and %q, want them normalized", sale.Email, sale.GifterEmail)
+	}
+	if !sale.Refunded || !sale.Gift || !sale.PayWhatYouWant || sale.SubscriptionID != "sub1" {
+		t.Errorf("sale %+v, want refunded, gift, pay what you want and sub1", sale)
+	}
+	if !sale.CreatedAt.Equal(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)) || strings.Join(sale.BundleComponents, ",") != "s1/a,s1/b" {
+		t.Errorf("created %v, components %v", sale.CreatedAt, sale.BundleComponents)
+	}
+	if gift, err := ParseSale(map[string]interface{}{"sale_id": "s2", "gift": "true"}); err != nil || gift.Gift {
+		t.Errorf("a gift field of \"true\" made the sale a gift: %v", err)
+	}
+
+	payload := sale.Payload()
+	if payload["email"] != "ada@example.com" || payload["price_cents"] != int64(1000) || payload["currency"] != "usd" ||
+		payload["sale_timestamp"] != "2026-01-15T09:30:00Z" || payload["custom"] != "kept" {
+		t.Errorf("payload %v, want the normalized fields and the rest kept", payload)
+	}
+	if data := sale.TemplateData(); data["price"] != "10.00" {
+		t.Errorf("template price %v, want 10.00", data["price"])
+	}
+	if sale.Fields["email"] != " Ada@Example.com " {
+		t.Errorf("Payload changed the received fields")
+	}
+}
+
+func TestParseRefund(t *testing.T) {
+	now := time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		payload     map[string]interface{}
+		wantAmount  int64
+		wantPartial bool
+		wantErr     bool
+	}{
+		{"whole price", map[string]interface{}{"sale_id": "s1", "price": "30"}, 3000, false, false},
+		{"partial", map[string]interface{}{"sale_id": "s1", "price": "30", "amount_refunded_in_cents": 1000}, 1000, true, false},
+		{"partial as a string", map[string]interface{}{"sale_id": "s1", "price": "30", "amount_refunded_in_cents": "1000"}, 1000, true, false},
+		{"the whole amount", map[string]interface{}{"sale_id": "s1", "price": "30", "amount_refunded_in_cents": 3000}, 3000, false, false},
+		{"fractional cents", map[string]interface{}{"sale_id": "s1", "price": "30", "amount_refunded_in_cents": 10.5}, 0, false, true},
+		{"negative", map[string]interface{}{"sale_id": "s1", "price": "30", "amount_refunded_in_cents": -1}, 0, false, true},
+		{"no sale", map[string]interface{}{"price": "30"}, 0, false, true},
+	}
+	for _, tt := range tests {
+		refund, err := ParseRefund(tt.payload, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseRefund = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (refund.Amount.Amount != tt.wantAmount || refund.Partial != tt.wantPartial || !refund.RefundedAt.Equal(now) || refund.SaleID != "s1") {
+			t.Errorf("%s: refund %+v, want %d cents, partial %t", tt.name, refund, tt.wantAmount, tt.wantPartial)
+		}
+	}
+}
+
+func TestParseSubscriptionEvent(t *testing.T) {
+	now := time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		payload    map[string]interface{}
+		wantOK     bool
+		wantStatus string
+		wantTier   string
+	}{
+		{"membership sale", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "variants": map[string]interface{}{"Tier": "Pro"}}, true, MembershipActive, "Pro"},
+		{"cancelled sale", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "cancelled": "true"}, true, MembershipCancelled, ""},
+		{"ended sale", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "ended": true, "cancelled": true}, true, MembershipEnded, ""},
+		{"plan change", map[string]interface{}{"resource_name": "subscription_updated", "subscription_id": "sub1", "new_plan": map[string]interface{}{"tier": map[string]interface{}{"name": "Team"}}}, true, "", "Team"},
+		{"cancellation", map[string]interface{}{"resource_name": "cancellation", "subscription_id": "sub1"}, true, MembershipCancelled, ""},
+		{"ended", map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub1"}, true, MembershipEnded, ""},
+		{"restarted", map[string]interface{}{"resource_name": "subscription_restarted", "subscription_id": "sub1"}, true, MembershipActive, ""},
+		{"no subscription", map[string]interface{}{"resource_name": "sale"}, false, "", ""},
+		{"refund", map[string]interface{}{"resource_name": "refund", "subscription_id": "sub1"}, false, "", ""},
+	}
+	for _, tt := range tests {
+		tt.payload["user_email"] = "Ada@example.com"
+		subscription, ok := ParseSubscriptionEvent(tt.payload, now)
+		if ok != tt.wantOK {
+			t.Errorf("%s: ok %t, want %t", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && (subscription.Status != tt.wantStatus || subscription.Tier != tt.wantTier || subscription.Email != "ada@example.com" || !subscription.UpdatedAt.Equal(now)) {
+			t.Errorf("%s: subscription %+v, want status %q tier %q", tt.name, subscription, tt.wantStatus, tt.wantTier)
+		}
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{"", defaultCurrency, false},
+		{"usd", "usd", false},
+		{" EUR ", "eur", false},
+		{"us", "", true},
+		{"usd1", "", true},
+		{"u$d", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseCurrency(tt.code)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseCurrency(%q) = %q, %v, want %q", tt.code, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	driveAPIURL    = "https://www.googleapis.com/drive/v3"
+	driveUploadURL = "https://www.googleapis.com/upload/drive/v3"
+	driveScope     = "https://www.googleapis.com/auth/drive.file"
+)
+
+// DriveConfig says which Drive folder each kind of artifact is stored in,
+// loaded from YAML:
+//
+//	folders:
+//	  code_bundle: 1AbC...     # folder IDs, from the folder's URL
+//	  report: 1DeF...
+//	  invoice: 1GhI...
+//	default: 1JkL...           # kinds without a folder of their own
+//	share: anyone              # who the link opens for: anyone, or a domain
+type DriveConfig struct {
+	Folders map[string]string `yaml:"folders"`
+	Default string            `yaml:"default"`
+	Share   string            `yaml:"share"`
+}
+
+// LoadDriveConfig reads the artifact folders
+func LoadDriveConfig(path string) (DriveConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DriveConfig{}, fmt.Errorf("failed to read drive config: %v", err)
+	}
+
+	var config DriveConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return DriveConfig{}, fmt.Errorf("failed to parse drive config %s: %v", path, err)
+	}
+	if config.Default == "" && len(config.Folders) == 0 {
+		return DriveConfig{}, fmt.Errorf("invalid drive config %s: no folders", path)
+	}
+	if config.Share == "" {
+		config.Share = "anyone"
+	}
+	return config, nil
+}
+
+// Artifact is a document a workflow generated, carried in a payload's
+// "artifacts" list until it is uploaded
+type Artifact struct {
+	Name     string
+	Kind     string // code_bundle, report, invoice, ...
+	MimeType string
+	Content  []byte
+}
+
+// parseArtifact reads an artifacts entry: name, kind, mime_type, and the
+// document as text in content or as bytes in content_base64
+func parseArtifact(entry map[string]interface{}) (Artifact, error) {
+	artifact := Artifact{
+		Name:     stringArg(entry, "name"),
+		Kind:     stringArg(entry, "kind"),
+		MimeType: stringArg(entry, "mime_type"),
+	}
+	if artifact.Name == "" {
+		return Artifact{}, fmt.Errorf("artifact has no name")
+	}
+	if encoded := stringArg(entry, "content_base64"); encoded != "" {
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return Artifact{}, fmt.Errorf("artifact %s: bad content_base64: %v", artifact.Name, err)
+		}
+		artifact.Content = content
+	} else {
+		artifact.Content = []byte(stringArg(entry, "content"))
+	}
+	if artifact.MimeType == "" {
+		artifact.MimeType = "text/plain"
+		if _, isBinary := entry["content_base64"]; isBinary {
+			artifact.MimeType = "application/octet-stream"
+		}
+	}
+	return artifact, nil
+}
+
+// DriveUploader stores the artifacts in outgoing messages in Google Drive.
+// Before a message with an "artifacts" list is sent, every entry that still
+// carries content is uploaded to its kind's folder and shared, and its
+// content is replaced by drive_file_id and url, the shareable link. An
+// upload that fails leaves the content inline, with upload_error saying
+// why, so the response still goes out.
+type DriveUploader struct {
+	Config DriveConfig
+	// Retries is how many times a failed API call is retried
+	Retries int
+	// Backoff is the delay before the first retry; each retry doubles it up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// APIURL and UploadURL default to the public API
+	APIURL     string
+	UploadURL  string
+	HTTPClient *http.Client
+
+	account *ServiceAccount
+	clock   Clock
+}
+
+// NewDriveUploader uploads the artifacts of every message the bridge sends,
+// authenticating as the service account, which needs edit access to the
+// folders
+func NewDriveUploader(gb *GoBridge, account *ServiceAccount, config DriveConfig) *DriveUploader {
+	du := &DriveUploader{
+		Config:     config,
+		Retries:    3,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+		APIURL:     driveAPIURL,
+		UploadURL:  driveUploadURL,
+		HTTPClient: &http.Client{Timeout: time.Minute},
+		account:    account,
+		clock:      gb.clock,
+	}
+	gb.OnSend(du.handleSend)
+	return du
+}
+
+func (du *DriveUploader) handleSend(message *UniversalMessage) {
+	entries, ok := message.Payload["artifacts"].([]interface{})
+	if !ok {
+		return
+	}
+
+	uploaded := make([]interface{}, len(entries))
+	changed := false
+	for i, value := range entries {
+		uploaded[i] = value
+		entry, ok := value.(map[string]interface{})
+		if !ok || (entry["content"] == nil && entry["content_base64"] == nil) {
+			continue // already uploaded, or not an artifact
+		}
+		changed = true
+
+		artifact, err := parseArtifact(entry)
+		var link DriveLink
+		if err == nil {
+			link, err = du.Upload(artifact)
+		}
+		if err != nil {
+			log.Printf("❌ Error uploading artifact from %s: %v", message.ID, err)
+			uploaded[i] = mergePayload(entry, map[string]interface{}{"upload_error": err.Error()})
+			continue
+		}
+		fmt.Printf("📎 Uploaded %s to Drive: %s\n", artifact.Name, link.URL)
+		uploaded[i] = map[string]interface{}{
+			"name":          artifact.Name,
+			"kind":          artifact.Kind,
+			"mime_type":     artifact.MimeType,
+			"size":          len(artifact.Content),
+			"drive_file_id": link.FileID,
+			"url":           link.URL,
+		}
+	}
+	if changed {
+		// A copy, so the caller's payload keeps what it built
+		message.Payload = mergePayload(message.Payload, map[string]interface{}{"artifacts": uploaded})
+	}
+}
+
+// DriveLink is an uploaded file and the link it is shared at
+type DriveLink struct {
+	FileID string `json:"id"`
+	URL    string `json:"webViewLink"`
+}
+
+// Folder returns the folder an artifact kind is stored in
+func (du *DriveUploader) Folder(kind string) string {
+	if folder := du.Config.Folders[kind]; folder != "" {
+		return folder
+	}
+	return du.Config.Default
+}
+
+// Upload stores the artifact in its kind's folder and shares it
+func (du *DriveUploader) Upload(artifact Artifact) (DriveLink, error) {
+	folder := du.Folder(artifact.Kind)
+	if folder == "" {
+		return DriveLink{}, fmt.Errorf("no Drive folder for %s artifacts", artifact.Kind)
+	}
+
+	// A multipart/related body carries the metadata and the content in one call
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"name":     artifact.Name,
+		"mimeType": artifact.MimeType,
+		"parents":  []string{folder},
+	})
+	part, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	part.Write(metadata)
+	part, _ = parts.CreatePart(textproto.MIMEHeader{"Content-Type": {artifact.MimeType}})
+	part.Write(artifact.Content)
+	parts.Close()
+
+	var link DriveLink
+	endpoint := strings.TrimSuffix(du.UploadURL, "/") + "/files?uploadType=multipart&supportsAllDrives=true&fields=id,webViewLink"
+	err := du.call(http.MethodPost, endpoint, "multipart/related; boundary="+parts.Boundary(), body.Bytes(), &link)
+	if err != nil {
+		return DriveLink{}, fmt.Errorf("uploading %s: %v", artifact.Name, err)
+	}
+
+	permission := map[string]interface{}{"role": "reader", "type": "anyone"}
+	if du.Config.Share != "anyone" {
+		permission = map[string]interface{}{"role": "reader", "type": "domain", "domain": du.Config.Share}
+	}
+	encoded, _ := json.Marshal(permission)
+	endpoint = strings.TrimSuffix(du.APIURL, "/") + "/files/" + url.PathEscape(link.FileID) + "/permissions?supportsAllDrives=true"
+	err = du.call(http.MethodPost, endpoint, JSONContentType, encoded, nil)
+	if err != nil {
+		return DriveLink{}, fmt.Errorf("sharing %s: %v", artifact.Name, err)
+	}
+	return link, nil
+}
+
+// call makes an API request, retrying 429s, 5xx responses and network
+// errors with exponential backoff, and decodes the JSON response into out
+func (du *DriveUploader) call(method, endpoint, contentType string, body []byte, out interface{}) error {
+	retry := retrier{Retries: du.Retries, Backoff: du.Backoff, MaxBackoff: du.MaxBackoff, Clock: du.clock, Classify: retryGoogle}
+	return retry.do("Drive call", func() error {
+		return du.attempt(method, endpoint, contentType, body, out)
+	})
+}
+
+// attempt makes one API request
+func (du *DriveUploader) attempt(method, endpoint, contentType string, body []byte, out interface{}) error {
+	token, err := du.account.Token(du.HTTPClient, driveScope, du.clock.Now())
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return final(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := du.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(out)
+		if err != nil {
+			return final(fmt.Errorf("bad Drive response: %v", err))
+		}
+		return nil
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		du.account.invalidate(driveScope)
+	}
+	return readAPIError("Drive API", response)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	smtpUsernameEnv = "SMTP_USERNAME"
+	smtpPasswordEnv = "SMTP_PASSWORD"
+)
+
+// EmailSender delivers the "email" sink over SMTP, or through a mail
+// service's HTTP API with a Driver. Every subsystem that emails buyers
+// queues a message whose payload holds the address in to and the rendered
+// template in text; a template's leading "Subject: " line becomes the
+// subject. Payloads with an unsubscribe_url also get a List-Unsubscribe
+// header. The server must offer STARTTLS when a username is set, as
+// net/smtp refuses to authenticate in the clear. Failed deliveries are
+// retried by the sink.
+type EmailSender struct {
+	// Addr is the submission server, as host:port
+	Addr string
+	// From is the sender address, e.g. "Shop <hello@example.com>"
+	From     string
+	Username string
+	Password string
+	// Driver, when set, sends instead of the SMTP server
+	Driver EmailDriver
+
+	bridge *GoBridge
+	from   *mail.Address
+	send   func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// OutgoingEmail is a queued email ready to send
+type OutgoingEmail struct {
+	MessageID   string // without the angle brackets
+	From        *mail.Address
+	To          *mail.Address
+	Subject     string
+	Text        string
+	Unsubscribe string // URL for the List-Unsubscribe header, if any
+}
+
+// EmailDriver sends emails through a mail service such as SendGrid or
+// Mailgun
+type EmailDriver interface {
+	Name() string
+	Send(email OutgoingEmail) error
+}
+
+// NewEmailSender registers the "email" sink, delivering through the SMTP
+// server at addr
+func NewEmailSender(gb *GoBridge, addr, from, username, password string) (*EmailSender, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("SMTP server %q must be host:port: %v", addr, err)
+	}
+	es, err := newEmailSender(gb, from)
+	if err != nil {
+		return nil, err
+	}
+	es.Addr, es.Username, es.Password = addr, username, password
+	return es, nil
+}
+
+// NewDriverEmailSender registers the "email" sink, delivering through the
+// driver
+func NewDriverEmailSender(gb *GoBridge, from string, driver EmailDriver) (*EmailSender, error) {
+	es, err := newEmailSender(gb, from)
+	if err != nil {
+		return nil, err
+	}
+	es.Driver = driver
+	return es, nil
+}
+
+func newEmailSender(gb *GoBridge, from string) (*EmailSender, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("bad sender address %q: %v", from, err)
+	}
+	es := &EmailSender{From: from, bridge: gb, from: sender, send: smtp.SendMail}
+	gb.AddSink("email", SinkConfig{}, es.deliver)
+	return es, nil
+}
+
+// deliver sends one queued email
+func (es *EmailSender) deliver(message *UniversalMessage) error {
+	to, err := mail.ParseAddress(stringArg(message.Payload, "to"))
+	if err != nil {
+		return fmt.Errorf("email %s has a bad recipient: %v", message.ID, err)
+	}
+	subject, text := splitSubject(stringArg(message.Payload, "text"))
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("email %s has no text", message.ID)
+	}
+	email := OutgoingEmail{
+		MessageID:   fmt.Sprintf("%s@%s", message.ID, domainOf(es.from.Address)),
+		From:        es.from,
+		To:          to,
+		Subject:     subject,
+		Text:        text,
+		Unsubscribe: stringArg(message.Payload, "unsubscribe_url"),
+	}
+
+	if es.Driver != nil {
+		err = es.Driver.Send(email)
+		if err != nil {
+			return fmt.Errorf("sending email to %s through %s: %v", to.Address, es.Driver.Name(), err)
+		}
+		fmt.Printf("📧 Emailed %s through %s\n", to.Address, es.Driver.Name())
+		return nil
+	}
+
+	body, err := es.compose(email)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if es.Username != "" {
+		host, _, _ := net.SplitHostPort(es.Addr)
+		auth = smtp.PlainAuth("", es.Username, es.Password, host)
+	}
+	err = es.send(es.Addr, auth, es.from.Address, []string{to.Address}, body)
+	if err != nil {
+		return fmt.Errorf("sending email to %s: %v", to.Address, err)
+	}
+	fmt.Printf("📧 Emailed %s\n", to.Address)
+	return nil
+}
+
+// compose builds the RFC 5322 message for an email
+func (es *EmailSender) compose(email OutgoingEmail) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, headerValue(value))
+	}
+	header("From", email.From.String())
+	header("To", email.To.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", email.Subject))
+	header("Date", es.bridge.clock.Now().Format(time.RFC1123Z))
+	header("Message-ID", "<"+email.MessageID+">")
+	if email.Unsubscribe != "" {
+		header("List-Unsubscribe", "<"+email.Unsubscribe+">")
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	_, err := qp.Write([]byte(strings.ReplaceAll(email.Text, "\n", "\r\n")))
+	if err == nil {
+		err = qp.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding email %s: %v", email.MessageID, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// headerValue keeps a header on its line. Values come from templates and
+// payloads; a line break would let them add headers of their own.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// splitSubject takes a rendered template's leading "Subject: " line off its
+// body
+func splitSubject(text string) (subject, body string) {
+	text = strings.TrimLeft(text, "\r\n")
+	first, rest, _ := strings.Cut(text, "\n")
+	if !strings.HasPrefix(first, "Subject:") {
+		return "", text
+	}
+	subject = strings.TrimSpace(strings.TrimPrefix(first, "Subject:"))
+	return subject, strings.TrimLeft(rest, "\r\n")
+}
+
+// domainOf returns the part of an address after the @
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
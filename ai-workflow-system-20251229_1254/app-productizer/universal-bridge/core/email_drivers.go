@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sendGridAPIURL = "https://api.sendgrid.com"
+	sendGridKeyEnv = "SENDGRID_API_KEY"
+	mailgunAPIURL  = "https://api.mailgun.net"
+	mailgunKeyEnv  = "MAILGUN_API_KEY"
+)
+
+// SendGridDriver sends emails through SendGrid's v3 Mail Send API
+type SendGridDriver struct {
+	APIKey string
+	// APIURL defaults to the public API
+	APIURL     string
+	HTTPClient *http.Client
+}
+
+// NewSendGridDriver sends with the API key, which needs the Mail Send
+// permission
+func NewSendGridDriver(apiKey string) (*SendGridDriver, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("SendGrid needs %s", sendGridKeyEnv)
+	}
+	return &SendGridDriver{APIKey: apiKey, APIURL: sendGridAPIURL, HTTPClient: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Name is "sendgrid"
+func (d *SendGridDriver) Name() string { return "sendgrid" }
+
+// sendGridAddress is an address in a Mail Send request
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// Send posts the email to /v3/mail/send
+func (d *SendGridDriver) Send(email OutgoingEmail) error {
+	request := map[string]interface{}{
+		"personalizations": []map[string]interface{}{{"to": []sendGridAddress{{email.To.Address, email.To.Name}}}},
+		"from":             sendGridAddress{email.From.Address, email.From.Name},
+		"subject":          headerValue(email.Subject),
+		"content":          []map[string]string{{"type": "text/plain", "value": email.Text}},
+		// SendGrid sets its own Message-ID, so ours travels as an argument
+		// that its event webhooks echo back
+		"custom_args": map[string]string{"message_id": email.MessageID},
+	}
+	if email.Unsubscribe != "" {
+		request["headers"] = map[string]string{"List-Unsubscribe": "<" + headerValue(email.Unsubscribe) + ">"}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	post, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(d.APIURL, "/")+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	post.Header.Set("Content-Type", "application/json")
+	post.Header.Set("Authorization", "Bearer "+d.APIKey)
+	return sendEmailRequest(d.HTTPClient, post, "SendGrid")
+}
+
+// MailgunDriver sends emails through Mailgun's messages API from one of
+// its sending domains
+type MailgunDriver struct {
+	Domain string
+	APIKey string
+	// APIURL defaults to the US region; EU domains use
+	// https://api.eu.mailgun.net
+	APIURL     string
+	HTTPClient *http.Client
+}
+
+// NewMailgunDriver sends from the domain with the API key
+func NewMailgunDriver(domain, apiKey string) (*MailgunDriver, error) {
+	if domain == "" {
+		return nil, fmt.Errorf("Mailgun needs a sending domain")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("Mailgun needs %s", mailgunKeyEnv)
+	}
+	return &MailgunDriver{Domain: domain, APIKey: apiKey, APIURL: mailgunAPIURL, HTTPClient: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Name is "mailgun"
+func (d *MailgunDriver) Name() string { return "mailgun" }
+
+// Send posts the email to /v3/{domain}/messages
+func (d *MailgunDriver) Send(email OutgoingEmail) error {
+	form := url.Values{
+		"from":         {email.From.String()},
+		"to":           {email.To.String()},
+		"subject":      {headerValue(email.Subject)},
+		"text":         {email.Text},
+		"h:Message-Id": {"<" + email.MessageID + ">"},
+	}
+	if email.Unsubscribe != "" {
+		form.Set("h:List-Unsubscribe", "<"+headerValue(email.Unsubscribe)+">")
+	}
+	endpoint := strings.TrimSuffix(d.APIURL, "/") + "/v3/" + url.PathEscape(d.Domain) + "/messages"
+	post, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post.SetBasicAuth("api", d.APIKey)
+	return sendEmailRequest(d.HTTPClient, post, "Mailgun")
+}
+
+// sendEmailRequest makes a mail service request, turning any answer but a
+// success into an error
+func sendEmailRequest(client *http.Client, request *http.Request, service string) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s answered %s: %s", service, response.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
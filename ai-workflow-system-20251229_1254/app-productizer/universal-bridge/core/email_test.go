@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sentEmail is one email handed to the SMTP client
+type sentEmail struct {
+	from string
+	to   []string
+	body string
+}
+
+func newTestEmailSender(t *testing.T) (*GoBridge, *EmailSender, *[]sentEmail) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("mail")))
+	t.Cleanup(func() { gb.Close() })
+	sender, err := NewEmailSender(gb, "smtp.example.com:587", "Shop <hello@example.com>", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []sentEmail
+	sender.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, sentEmail{from, to, string(msg)})
+		return nil
+	}
+	return gb, sender, &sent
+}
+
+func TestEmailSenderDeliver(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		wantErr bool
+		want    []string // substrings of the sent message
+	}{
+		{
+			name:    "template with subject",
+			payload: map[string]interface{}{"to": "buyer@example.com", "text": "Subject: Your login link\n\nHi,\nClick here"},
+			want:    []string{"Subject: Your login link\r\n", "To: <buyer@example.com>\r\n", "From: \"Shop\" <hello@example.com>\r\n", "\r\n\r\nHi,\r\nClick here"},
+		},
+		{
+			name:    "non-ASCII subject",
+			payload: map[string]interface{}{"to": "kunde@example.de", "text": "Subject: Deine Quittung für Café\n\nDanke"},
+			want:    []string{"Subject: =?utf-8?q?Deine_Quittung_f=C3=BCr_Caf=C3=A9?=\r\n"},
+		},
+		{
+			name:    "unsubscribe link",
+			payload: map[string]interface{}{"to": "buyer@example.com", "text": "Subject: v2\n\nNew version", "unsubscribe_url": "https://portal.example.com/unsubscribe?t=1"},
+			want:    []string{"List-Unsubscribe: <https://portal.example.com/unsubscribe?t=1>\r\n"},
+		},
+		{
+			name:    "header injection in recipient",
+			payload: map[string]interface{}{"to": "buyer@example.com\r\nBcc: everyone@example.com", "text": "Subject: x\n\nbody"},
+			wantErr: true,
+		},
+		{
+			name:    "no text",
+			payload: map[string]interface{}{"to": "buyer@example.com", "text": "Subject: empty\n\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gb, sender, sent := newTestEmailSender(t)
+			err := sender.deliver(gb.NewMessage(DataSync, "go", tt.payload, FileSystem))
+			if tt.wantErr {
+				if err == nil || len(*sent) != 0 {
+					t.Fatalf("deliver = %v with %d sent, want an error and nothing sent", err, len(*sent))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(*sent) != 1 || (*sent)[0].from != "hello@example.com" {
+				t.Fatalf("sent %+v", *sent)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains((*sent)[0].body, want) {
+					t.Errorf("message lacks %q:\n%s", want, (*sent)[0].body)
+				}
+			}
+		})
+	}
+}
+
+func TestMagicLinkNeedsEmailSink(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	portal := NewPortal(gb, nil, NewTemplateStore("templates"), "https://portal.example.com")
+	if err := portal.sendMagicLink("buyer@example.com", "secret-token", Sale{}); err == nil {
+		t.Fatal("sendMagicLink without an email sink succeeded")
+	}
+
+	gb, _, sent := newTestEmailSender(t)
+	portal = NewPortal(gb, nil, NewTemplateStore("templates"), "https://portal.example.com")
+	if err := portal.sendMagicLink("buyer@example.com", "secret-token", Sale{}); err != nil {
+		t.Fatal(err)
+	}
+	gb.Sink("email").Drain(5 * time.Second)
+	if len(*sent) != 1 || !strings.Contains((*sent)[0].body, "secret-token") {
+		t.Fatalf("login link not emailed: %+v", *sent)
+	}
+}
+
+func TestBroadcastReachesEmailSink(t *testing.T) {
+	dir, err := filepath.Abs("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates := NewTemplateStore(dir)
+	inTempDir(t)
+	gb, _, sent := newTestEmailSender(t)
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []map[string]interface{}{
+		{"sale_id": "s1", "product_id": "ebook", "product_name": "The Ebook", "email": "Buyer@Example.com"},
+		{"sale_id": "s2", "product_id": "ebook", "email": "refunded@example.com", "refunded": true},
+		{"sale_id": "s3", "product_id": "course", "email": "other@example.com"},
+	} {
+		if _, err := sales.Record(sale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	optOuts, err := NewOptOutList("optouts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcaster, err := NewBroadcaster(gb, sales, templates, optOuts, "broadcasts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := broadcaster.Start("ebook", "2.0", "New chapter", "https://example.com/ebook"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for broadcaster.Broadcasts()[0].Status != BroadcastFinished {
+		if time.Now().After(deadline) {
+			t.Fatal("broadcast did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	gb.Sink("email").Drain(5 * time.Second)
+
+	if len(*sent) != 1 || (*sent)[0].to[0] != "buyer@example.com" {
+		t.Fatalf("broadcast not emailed to the one buyer: %+v", *sent)
+	}
+}
+
+func TestBroadcastEmailsCanUnsubscribe(t *testing.T) {
+	gb, _, sent := newTestEmailSender(t)
+	dir := t.TempDir()
+	sales, err := NewSalesStore(filepath.Join(dir, "sales.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	optOuts, err := NewOptOutList(filepath.Join(dir, "optouts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcaster, err := NewBroadcaster(gb, sales, NewTemplateStore("templates"), optOuts, filepath.Join(dir, "broadcasts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcaster.UnsubscribeBase = "https://portal.example.com"
+
+	sale, err := ParseSale(map[string]interface{}{"sale_id": "s1", "product_id": "p1", "product_name": "Kit", "email": "buyer@example.com", "price": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := broadcaster.render(&Broadcast{ID: "b1", Product: "p1", Version: "2.0"}, sale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gb.Sink("email").Enqueue(message); err != nil {
+		t.Fatal(err)
+	}
+	gb.Sink("email").Drain(5 * time.Second)
+
+	want := "List-Unsubscribe: <https://portal.example.com/unsubscribe?email=buyer%40example.com&token=" + optOuts.Token("buyer@example.com") + ">"
+	if len(*sent) != 1 || !strings.Contains((*sent)[0].body, want) {
+		t.Fatalf("broadcast email has no List-Unsubscribe header %q: %+v", want, *sent)
+	}
+}
+
+func TestEmailDrivers(t *testing.T) {
+	var got *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(content)
+		if strings.Contains(body, "bounce") {
+			http.Error(w, `{"message": "rejected"}`, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+
+	sendGrid, err := NewSendGridDriver("sg-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendGrid.APIURL = server.URL
+	mailgun, err := NewMailgunDriver("mg.example.com", "mg-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailgun.APIURL = server.URL
+
+	tests := []struct {
+		driver   EmailDriver
+		wantPath string
+		wantAuth string
+		want     []string // substrings of the request body
+	}{
+		{sendGrid, "/v3/mail/send", "Bearer sg-key", []string{
+			`"personalizations":[{"to":[{"email":"buyer@example.com","name":"Ada"}]}]`,
+			`"from":{"email":"hello@example.com","name":"Shop"}`,
+			`"subject":"Your receipt"`,
+			`"content":[{"type":"text/plain","value":"Thanks!\nSee you"}]`,
+			`"headers":{"List-Unsubscribe":"\u003chttps://portal.example.com/unsubscribe\u003e"}`,
+		}},
+		{mailgun, "/v3/mg.example.com/messages", "Basic " + base64.StdEncoding.EncodeToString([]byte("api:mg-key")), []string{
+			"from=%22Shop%22+%3Chello%40example.com%3E",
+			"to=%22Ada%22+%3Cbuyer%40example.com%3E",
+			"subject=Your+receipt",
+			"text=Thanks%21%0ASee+you",
+			"h%3AMessage-Id=%3Cmail-000001%40example.com%3E",
+			"h%3AList-Unsubscribe=%3Chttps%3A%2F%2Fportal.example.com%2Funsubscribe%3E",
+		}},
+	}
+	for _, tt := range tests {
+		gb := NewGoBridge("", WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("mail")))
+		t.Cleanup(func() { gb.Close() })
+		sender, err := NewDriverEmailSender(gb, "Shop <hello@example.com>", tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = sender.deliver(gb.NewMessage(DataSync, "go", map[string]interface{}{"to": "Ada <buyer@example.com>",
+			"text": "Subject: Your receipt\n\nThanks!\nSee you", "unsubscribe_url": "https://portal.example.com/unsubscribe"}, FileSystem))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.driver.Name(), err)
+		}
+		if got.URL.Path != tt.wantPath || got.Header.Get("Authorization") != tt.wantAuth {
+			t.Errorf("%s: sent %s with %q", tt.driver.Name(), got.URL.Path, got.Header.Get("Authorization"))
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: request lacks %s:\n%s", tt.driver.Name(), want, body)
+			}
+		}
+
+		// A refused email fails for the sink to retry
+		err = sender.deliver(gb.NewMessage(DataSync, "go", map[string]interface{}{"to": "buyer@example.com", "text": "Subject: bounce\n\nx"}, FileSystem))
+		if err == nil || !strings.Contains(err.Error(), "400 Bad Request") {
+			t.Errorf("%s: refused email = %v", tt.driver.Name(), err)
+		}
+	}
+}
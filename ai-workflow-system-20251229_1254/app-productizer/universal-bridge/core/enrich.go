@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	companyLookupKeyEnv = "COMPANY_LOOKUP_KEY"
+	// ClearbitCompanyURL is Clearbit's Company API; {domain} is replaced
+	ClearbitCompanyURL = "https://company.clearbit.com/v2/companies/find?domain={domain}"
+)
+
+// Email domain types
+const (
+	DomainCorporate  = "corporate"
+	DomainFree       = "free"
+	DomainEducation  = "education"
+	DomainDisposable = "disposable"
+)
+
+// freeEmailDomains are webmail providers anyone can sign up to
+var freeEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "yahoo.co.uk": true, "yahoo.fr": true,
+	"ymail.com": true, "outlook.com": true, "hotmail.com": true, "hotmail.co.uk": true, "hotmail.fr": true,
+	"live.com": true, "msn.com": true, "icloud.com": true, "me.com": true, "mac.com": true, "aol.com": true,
+	"proton.me": true, "protonmail.com": true, "pm.me": true, "gmx.com": true, "gmx.de": true, "gmx.net": true,
+	"web.de": true, "t-online.de": true, "mail.com": true, "yandex.com": true, "yandex.ru": true,
+	"mail.ru": true, "qq.com": true, "163.com": true, "126.com": true, "naver.com": true, "fastmail.com": true,
+	"hey.com": true, "zoho.com": true, "tutanota.com": true, "tuta.io": true, "orange.fr": true,
+	"free.fr": true, "libero.it": true, "btinternet.com": true, "comcast.net": true, "verizon.net": true,
+}
+
+// disposableEmailDomains hand out throwaway inboxes
+var disposableEmailDomains = map[string]bool{
+	"mailinator.com": true, "guerrillamail.com": true, "10minutemail.com": true, "tempmail.com": true,
+	"temp-mail.org": true, "yopmail.com": true, "trashmail.com": true, "sharklasers.com": true,
+	"getnada.com": true, "dispostable.com": true, "maildrop.cc": true, "throwawaymail.com": true,
+}
+
+// countryZones is each country's name and the time zone most of its people
+// live in, for countries that span several
+var countryZones = map[string]struct{ name, zone string }{
+	"AE": {"United Arab Emirates", "Asia/Dubai"}, "AR": {"Argentina", "America/Argentina/Buenos_Aires"},
+	"AT": {"Austria", "Europe/Vienna"}, "AU": {"Australia", "Australia/Sydney"},
+	"BE": {"Belgium", "Europe/Brussels"}, "BG": {"Bulgaria", "Europe/Sofia"},
+	"BR": {"Brazil", "America/Sao_Paulo"}, "CA": {"Canada", "America/Toronto"},
+	"CH": {"Switzerland", "Europe/Zurich"}, "CL": {"Chile", "America/Santiago"},
+	"CN": {"China", "Asia/Shanghai"}, "CO": {"Colombia", "America/Bogota"},
+	"CZ": {"Czech Republic", "Europe/Prague"}, "DE": {"Germany", "Europe/Berlin"},
+	"DK": {"Denmark", "Europe/Copenhagen"}, "EE": {"Estonia", "Europe/Tallinn"},
+	"EG": {"Egypt", "Africa/Cairo"}, "ES": {"Spain", "Europe/Madrid"},
+	"FI": {"Finland", "Europe/Helsinki"}, "FR": {"France", "Europe/Paris"},
+	"GB": {"United Kingdom", "Europe/London"}, "GR": {"Greece", "Europe/Athens"},
+	"HK": {"Hong Kong", "Asia/Hong_Kong"}, "HR": {"Croatia", "Europe/Zagreb"},
+	"HU": {"Hungary", "Europe/Budapest"}, "ID": {"Indonesia", "Asia/Jakarta"},
+	"IE": {"Ireland", "Europe/Dublin"}, "IL": {"Israel", "Asia/Jerusalem"},
+	"IN": {"India", "Asia/Kolkata"}, "IS": {"Iceland", "Atlantic/Reykjavik"},
+	"IT": {"Italy", "Europe/Rome"}, "JP": {"Japan", "Asia/Tokyo"},
+	"KE": {"Kenya", "Africa/Nairobi"}, "KR": {"South Korea", "Asia/Seoul"},
+	"LT": {"Lithuania", "Europe/Vilnius"}, "LU": {"Luxembourg", "Europe/Luxembourg"},
+	"LV": {"Latvia", "Europe/Riga"}, "MX": {"Mexico", "America/Mexico_City"},
+	"MY": {"Malaysia", "Asia/Kuala_Lumpur"}, "NG": {"Nigeria", "Africa/Lagos"},
+	"NL": {"Netherlands", "Europe/Amsterdam"}, "NO": {"Norway", "Europe/Oslo"},
+	"NZ": {"New Zealand", "Pacific/Auckland"}, "PE": {"Peru", "America/Lima"},
+	"PH": {"Philippines", "Asia/Manila"}, "PK": {"Pakistan", "Asia/Karachi"},
+	"PL": {"Poland", "Europe/Warsaw"}, "PT": {"Portugal", "Europe/Lisbon"},
+	"RO": {"Romania", "Europe/Bucharest"}, "RS": {"Serbia", "Europe/Belgrade"},
+	"RU": {"Russia", "Europe/Moscow"}, "SA": {"Saudi Arabia", "Asia/Riyadh"},
+	"SE": {"Sweden", "Europe/Stockholm"}, "SG": {"Singapore", "Asia/Singapore"},
+	"SI": {"Slovenia", "Europe/Ljubljana"}, "SK": {"Slovakia", "Europe/Bratislava"},
+	"TH": {"Thailand", "Asia/Bangkok"}, "TR": {"Turkey", "Europe/Istanbul"},
+	"TW": {"Taiwan", "Asia/Taipei"}, "UA": {"Ukraine", "Europe/Kyiv"},
+	"US": {"United States", "America/New_York"}, "VN": {"Vietnam", "Asia/Ho_Chi_Minh"},
+	"ZA": {"South Africa", "Africa/Johannesburg"},
+}
+
+// BuyerProfile is what enrichment derives about a sale's buyer, stored in
+// the sale's "buyer" field for rules, scripts and segments to read
+type BuyerProfile struct {
+	Domain     string       `json:"domain,omitempty"`
+	DomainType string       `json:"domain_type,omitempty"`
+	Country    string       `json:"country,omitempty"` // ISO 3166 code
+	Timezone   string       `json:"timezone,omitempty"`
+	Company    *CompanyInfo `json:"company,omitempty"`
+}
+
+// CompanyInfo is a company found for a corporate email domain
+type CompanyInfo struct {
+	Name           string `json:"name"`
+	Domain         string `json:"domain,omitempty"`
+	Industry       string `json:"industry,omitempty"`
+	Employees      int    `json:"employees,omitempty"`
+	EmployeesRange string `json:"employees_range,omitempty"`
+	Country        string `json:"country,omitempty"`
+}
+
+// ClassifyDomain returns the email's domain and whether it is corporate,
+// free webmail, a school's or disposable
+func ClassifyDomain(email string) (domain, kind string) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", ""
+	}
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(email[at+1:])), ".")
+	switch {
+	case domain == "":
+		return "", ""
+	case disposableEmailDomains[domain]:
+		return domain, DomainDisposable
+	case freeEmailDomains[domain]:
+		return domain, DomainFree
+	case strings.HasSuffix(domain, ".edu") || strings.Contains(domain+".", ".edu.") || strings.Contains(domain+".", ".ac."):
+		return domain, DomainEducation
+	}
+	return domain, DomainCorporate
+}
+
+// BuyerCountry reads the buyer's country from a sale payload as an ISO
+// code, accepting the country names Gumroad sends in ip_country
+func BuyerCountry(payload map[string]interface{}) string {
+	for _, field := range []string{"country", "customer.country", "ip_country"} {
+		value, ok := lookupField(payload, field)
+		if !ok {
+			continue
+		}
+		country := strings.TrimSpace(stringValue(value))
+		if _, known := countryZones[strings.ToUpper(country)]; known {
+			return strings.ToUpper(country)
+		}
+		for code, zone := range countryZones {
+			if strings.EqualFold(zone.name, country) {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// CountryTimezone returns the IANA time zone most of a country lives in
+func CountryTimezone(country string) string {
+	return countryZones[strings.ToUpper(country)].zone
+}
+
+// CompanyLookup finds the company behind an email domain with a
+// Clearbit-style API: a GET of URL with {domain} replaced, authorized with
+// a bearer key, answered with the company or 404
+type CompanyLookup struct {
+	URL        string
+	APIKey     string
+	Retries    int
+	HTTPClient *http.Client
+
+	clock Clock
+}
+
+// NewCompanyLookup creates a lookup against urlTemplate
+func NewCompanyLookup(urlTemplate, apiKey string, clock Clock) *CompanyLookup {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &CompanyLookup{
+		URL:        urlTemplate,
+		APIKey:     apiKey,
+		Retries:    2,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		clock:      clock,
+	}
+}
+
+// errLookupQueued is the API answering that it is still looking; a later
+// sale from the domain asks again
+var errLookupQueued = fmt.Errorf("company lookup queued by the API")
+
+// Find returns the domain's company, or nil when the API knows none
+func (cl *CompanyLookup) Find(domain string) (*CompanyInfo, error) {
+	var company *CompanyInfo
+	retry := retrier{Retries: cl.Retries, Backoff: time.Second, MaxBackoff: 30 * time.Second, Clock: cl.clock, Classify: retryTransient}
+	err := retry.do("company lookup of "+domain, func() error {
+		var err error
+		company, err = cl.find(domain)
+		return err
+	})
+	return company, err
+}
+
+func (cl *CompanyLookup) find(domain string) (*CompanyInfo, error) {
+	request, err := http.NewRequest(http.MethodGet, strings.ReplaceAll(cl.URL, "{domain}", url.QueryEscape(domain)), nil)
+	if err != nil {
+		return nil, final(err)
+	}
+	request.Header.Set("Accept", "application/json")
+	if cl.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+cl.APIKey)
+	}
+	response, err := cl.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case response.StatusCode == http.StatusAccepted:
+		return nil, final(errLookupQueued)
+	case response.StatusCode >= 300:
+		return nil, readAPIError("company lookup", response)
+	}
+
+	var found struct {
+		Name     string `json:"name"`
+		Domain   string `json:"domain"`
+		Category struct {
+			Industry string `json:"industry"`
+		} `json:"category"`
+		Metrics struct {
+			Employees      int    `json:"employees"`
+			EmployeesRange string `json:"employeesRange"`
+		} `json:"metrics"`
+		Geo struct {
+			CountryCode string `json:"countryCode"`
+		} `json:"geo"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&found); err != nil {
+		return nil, final(fmt.Errorf("bad company lookup response: %v", err))
+	}
+	if found.Name == "" {
+		return nil, nil
+	}
+	return &CompanyInfo{
+		Name:           found.Name,
+		Domain:         found.Domain,
+		Industry:       found.Category.Industry,
+		Employees:      found.Metrics.Employees,
+		EmployeesRange: found.Metrics.EmployeesRange,
+		Country:        found.Geo.CountryCode,
+	}, nil
+}
+
+// companyEntry is a cached lookup; a nil Company is a domain the API knew
+// nothing about
+type companyEntry struct {
+	Company   *CompanyInfo `json:"company,omitempty"`
+	CheckedAt time.Time    `json:"checked_at"`
+}
+
+// companyRequest asks for a sale's company to be looked up and added
+type companyRequest struct {
+	saleID string
+	domain string
+}
+
+// BuyerEnrichment adds a buyer profile to every received sale before it is
+// recorded. The email domain, its type, the country and time zone are
+// derived on the spot. Companies are looked up in the background for
+// corporate domains, and the stored sale is updated when one is found, so
+// a slow API never holds up a sale. Lookups are cached per domain at path.
+type BuyerEnrichment struct {
+	// Lookup finds companies for corporate domains; nil skips them
+	Lookup *CompanyLookup
+	// Recheck is how long a domain with no company waits before it is
+	// looked up again
+	Recheck time.Duration
+
+	bridge *GoBridge
+	sales  *SalesStore
+	path   string
+
+	mu        sync.Mutex
+	companies map[string]*companyEntry // by domain
+	queued    map[string]bool          // domains waiting for the worker
+	queue     chan companyRequest
+	stop      chan struct{}
+	done      chan struct{}
+	started   bool
+}
+
+// NewBuyerEnrichment opens the company cache at path and enriches the
+// bridge's received sales. Register it before RecordSales.
+func NewBuyerEnrichment(gb *GoBridge, sales *SalesStore, path string) (*BuyerEnrichment, error) {
+	be := &BuyerEnrichment{
+		Recheck:   30 * 24 * time.Hour,
+		bridge:    gb,
+		sales:     sales,
+		path:      path,
+		companies: make(map[string]*companyEntry),
+		queued:    make(map[string]bool),
+		queue:     make(chan companyRequest, 1000),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read company cache: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &be.companies)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse company cache %s: %v", path, err)
+		}
+	}
+
+	gb.OnReceive(be.handleMessage)
+	return be, nil
+}
+
+// Start looks companies up in the background
+func (be *BuyerEnrichment) Start() {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if be.started || be.Lookup == nil {
+		return
+	}
+	be.started = true
+	go be.run()
+}
+
+func (be *BuyerEnrichment) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+		return
+	}
+	profile := be.Profile(message.Payload)
+	if buyer, ok := normalizeJSON(profile).(map[string]interface{}); ok {
+		message.Payload["buyer"] = buyer
+	}
+	if profile.DomainType == DomainCorporate && profile.Company == nil && be.needsLookup(profile.Domain) {
+		be.request(stringArg(message.Payload, "sale_id"), profile.Domain)
+	}
+}
+
+// Profile derives the buyer profile of a sale payload, with the company
+// if its domain has been looked up
+func (be *BuyerEnrichment) Profile(payload map[string]interface{}) BuyerProfile {
+	var profile BuyerProfile
+	profile.Domain, profile.DomainType = ClassifyDomain(stringArg(payload, "email"))
+	profile.Country = BuyerCountry(payload)
+	profile.Timezone = CountryTimezone(profile.Country)
+	if profile.DomainType == DomainCorporate {
+		be.mu.Lock()
+		if entry, ok := be.companies[profile.Domain]; ok {
+			profile.Company = entry.Company
+		}
+		be.mu.Unlock()
+	}
+	return profile
+}
+
+// needsLookup reports whether a domain has never been looked up, or found
+// nothing long enough ago to ask again
+func (be *BuyerEnrichment) needsLookup(domain string) bool {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if be.Lookup == nil || be.queued[domain] {
+		return false
+	}
+	entry, ok := be.companies[domain]
+	return !ok || (entry.Company == nil && be.bridge.clock.Now().Sub(entry.CheckedAt) >= be.Recheck)
+}
+
+func (be *BuyerEnrichment) request(saleID, domain string) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	select {
+	case be.queue <- companyRequest{saleID: saleID, domain: domain}:
+		be.queued[domain] = true
+	default:
+		fmt.Printf("⚠️ Company lookups are backed up; not looking up %s for sale %s\n", domain, saleID)
+	}
+}
+
+func (be *BuyerEnrichment) run() {
+	defer close(be.done)
+	for {
+		select {
+		case <-be.stop:
+			return
+		case request := <-be.queue:
+			be.lookup(request)
+		}
+	}
+}
+
+// lookup finds the request's company, caches the answer, and adds the
+// company to the stored sale. A failed lookup is not cached, so the next
+// sale from the domain tries again.
+func (be *BuyerEnrichment) lookup(request companyRequest) {
+	company, err := be.Lookup.Find(request.domain)
+	be.mu.Lock()
+	delete(be.queued, request.domain)
+	if err != nil {
+		be.mu.Unlock()
+		if err != errLookupQueued {
+			log.Printf("❌ Company lookup for %s failed: %v", request.domain, err)
+		}
+		return
+	}
+	be.companies[request.domain] = &companyEntry{Company: company, CheckedAt: be.bridge.clock.Now()}
+	err = writeJSONFile(be.path, be.companies)
+	be.mu.Unlock()
+	if err != nil {
+		log.Printf("❌ Error saving company cache: %v", err)
+	}
+	if company == nil {
+		return
+	}
+
+	sale, ok := be.sales.Sale(request.saleID)
+	if !ok {
+		return
+	}
+	payload := copyPayload(sale.Fields)
+	if err = setField(payload, "buyer.company", normalizeJSON(company)); err == nil {
+		_, err = be.sales.Record(payload)
+	}
+	if err != nil {
+		log.Printf("❌ Error adding %s to sale %s: %v", company.Name, request.saleID, err)
+		return
+	}
+	fmt.Printf("🏢 Sale %s is from %s\n", request.saleID, company.Name)
+}
+
+// Close stops the background lookups; ones queued are dropped
+func (be *BuyerEnrichment) Close() {
+	be.mu.Lock()
+	started := be.started
+	be.mu.Unlock()
+	close(be.stop)
+	if started {
+		<-be.done
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifyDomain(t *testing.T) {
+	tests := []struct {
+		email, domain, kind string
+	}{
+		{"ada@Acme.io", "acme.io", DomainCorporate},
+		{"bo@gmail.com", "gmail.com", DomainFree},
+		{"cy@cs.stanford.edu", "cs.stanford.edu", DomainEducation},
+		{"di@ox.ac.uk", "ox.ac.uk", DomainEducation},
+		{"ed@unimelb.edu.au", "unimelb.edu.au", DomainEducation},
+		{"fa@mailinator.com", "mailinator.com", DomainDisposable},
+		{"not-an-email", "", ""},
+	}
+	for _, test := range tests {
+		domain, kind := ClassifyDomain(test.email)
+		if domain != test.domain || kind != test.kind {
+			t.Errorf("ClassifyDomain(%q) = %q, %q, want %q, %q", test.email, domain, kind, test.domain, test.kind)
+		}
+	}
+}
+
+func TestBuyerCountry(t *testing.T) {
+	tests := []struct {
+		payload map[string]interface{}
+		country string
+		zone    string
+	}{
+		{map[string]interface{}{"country": "de"}, "DE", "Europe/Berlin"},
+		{map[string]interface{}{"ip_country": "United States"}, "US", "America/New_York"},
+		{map[string]interface{}{"customer": map[string]interface{}{"country": "JP"}}, "JP", "Asia/Tokyo"},
+		{map[string]interface{}{"ip_country": "Atlantis"}, "", ""},
+	}
+	for _, test := range tests {
+		country := BuyerCountry(test.payload)
+		if country != test.country || CountryTimezone(country) != test.zone {
+			t.Errorf("BuyerCountry(%v) = %q in %q, want %q in %q", test.payload, country, CountryTimezone(country), test.country, test.zone)
+		}
+	}
+}
+
+func TestBuyerEnrichment(t *testing.T) {
+	var lookups int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("domain") != "acme.io" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name":"Acme","domain":"acme.io","category":{"industry":"Software"},"metrics":{"employees":120,"employeesRange":"51-250"},"geo":{"countryCode":"US"}}`))
+	}))
+	defer api.Close()
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "companies.json")
+	enrichment, err := NewBuyerEnrichment(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrichment.Lookup = NewCompanyLookup(api.URL+"/find?domain={domain}", "sk_test", clock)
+	enrichment.Start()
+	RecordSales(gb, sales)
+
+	ids := NewSequentialIDs("py")
+	sale := func(id, email string) {
+		t.Helper()
+		payload := map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": "app", "email": email, "price": 29, "ip_country": "Germany"}
+		if reason := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+	sale("s1", "ada@acme.io")
+	sale("s2", "bo@gmail.com")
+	sale("s3", "cy@unknown.example")
+
+	stored, _ := sales.Sale("s2")
+	if domainType, _ := lookupField(stored.Fields, "buyer.domain_type"); domainType != DomainFree {
+		t.Errorf("s2 buyer %v", stored.Fields["buyer"])
+	}
+	if zone, _ := lookupField(stored.Fields, "buyer.timezone"); zone != "Europe/Berlin" {
+		t.Errorf("s2 buyer %v", stored.Fields["buyer"])
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, _ = sales.Sale("s1")
+		enrichment.mu.Lock()
+		cached := len(enrichment.companies)
+		enrichment.mu.Unlock()
+		if name, _ := lookupField(stored.Fields, "buyer.company.name"); name == "Acme" && cached == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("s1 never got its company: %v", stored.Fields["buyer"])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if employees, _ := lookupField(stored.Fields, "buyer.company.employees"); employees != 120.0 {
+		t.Errorf("s1 company %v", stored.Fields["buyer"])
+	}
+	enrichment.Close()
+	if got := atomic.LoadInt32(&lookups); got != 2 {
+		t.Errorf("%d lookups, want acme.io and unknown.example", got)
+	}
+
+	// Cached companies are added as the sale is recorded, without a lookup
+	other := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { other.Close() })
+	reopened, err := NewBuyerEnrichment(other, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := reopened.Profile(map[string]interface{}{"email": "dee@acme.io"})
+	if profile.Company == nil || profile.Company.Industry != "Software" {
+		t.Errorf("cached profile %+v", profile)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Membership statuses. A cancelled membership keeps its features until
+// Gumroad reports the subscription ended at the end of the billing period.
+const (
+	MembershipActive    = "active"
+	MembershipCancelled = "cancelled"
+	MembershipEnded     = "ended"
+)
+
+// EntitlementConfig maps each membership product's tiers to the features
+// they unlock, loaded from YAML:
+//
+//	products:
+//	  membership:              # product ID or permalink
+//	    tiers:
+//	      Basic: [downloads]
+//	      Pro: [downloads, community, source_files]
+type EntitlementConfig struct {
+	Products map[string]struct {
+		Tiers map[string][]string `yaml:"tiers"`
+	} `yaml:"products"`
+}
+
+// LoadEntitlementConfig reads the tier → features table
+func LoadEntitlementConfig(path string) (*EntitlementConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entitlements: %v", err)
+	}
+
+	var config EntitlementConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse entitlements %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// features returns what a tier of a product unlocks, matching the tier name
+// without regard to case
+func (c *EntitlementConfig) features(product, tier string) ([]string, bool) {
+	tiers, ok := c.Products[product]
+	if !ok {
+		return nil, false
+	}
+	for name, features := range tiers.Tiers {
+		if strings.EqualFold(name, tier) {
+			return features, true
+		}
+	}
+	return nil, true
+}
+
+// Membership is one subscription's current tier
+type Membership struct {
+	SubscriptionID string    `json:"subscription_id"`
+	Email          string    `json:"email"`
+	Product        string    `json:"product"`
+	Tier           string    `json:"tier"`
+	Status         string    `json:"status"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// EntitlementGrant is everything an email is entitled to right now
+type EntitlementGrant struct {
+	Email       string       `json:"email"`
+	Features    []string     `json:"features"`
+	Memberships []Membership `json:"memberships"`
+}
+
+// Entitlements keeps the table of memberships, updated from Gumroad sale and
+// subscription events, and answers which features an email has
+type Entitlements struct {
+	config *EntitlementConfig
+	path   string
+	clock  Clock
+
+	mu          sync.RWMutex
+	memberships map[string]*Membership // subscription ID → membership
+}
+
+// NewEntitlements opens the membership table at path, creating it if needed
+func NewEntitlements(config *EntitlementConfig, path string, clock Clock) (*Entitlements, error) {
+	e := &Entitlements{config: config, path: path, clock: clock, memberships: make(map[string]*Membership)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read memberships: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &e.memberships)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse memberships %s: %v", path, err)
+		}
+	}
+	return e, nil
+}
+
+// Apply updates the table from a Gumroad payload: a membership sale, or a
+// subscription_updated (upgrade or downgrade), cancellation,
+// subscription_ended or subscription_restarted event. It reports whether
+// anything changed.
+func (e *Entitlements) Apply(payload map[string]interface{}) (bool, error) {
+	event, ok := ParseSubscriptionEvent(payload, e.clock.Now())
+	if !ok {
+		return false, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	membership, known := e.memberships[event.ID]
+	next := Membership{SubscriptionID: event.ID}
+	if known {
+		next = *membership
+	}
+
+	// A sale describes the whole membership; events change part of it, and
+	// only fill in who and what for a subscription not seen before
+	if stringArg(payload, "resource_name") == "sale" || !known {
+		next.Email = event.Email
+		next.Product = event.Product
+	}
+	if event.Tier != "" {
+		next.Tier = event.Tier
+	}
+	switch {
+	case event.Status != "":
+		next.Status = event.Status
+	case !known:
+		next.Status = MembershipActive
+	}
+
+	if next.Email == "" || next.Product == "" {
+		return false, nil
+	}
+	if _, configured := e.config.features(next.Product, next.Tier); !configured {
+		return false, nil
+	}
+	if known && next.Tier == membership.Tier && next.Status == membership.Status && next.Email == membership.Email {
+		return false, nil
+	}
+
+	next.UpdatedAt = event.UpdatedAt
+	e.memberships[event.ID] = &next
+	fmt.Printf("🎟️ %s: %s tier %s is %s\n", next.Email, next.Product, next.Tier, next.Status)
+	return true, writeJSONFile(e.path, e.memberships)
+}
+
+// For returns the memberships on record for an email and the features the
+// active and cancelled-but-not-ended ones unlock
+func (e *Entitlements) For(email string) EntitlementGrant {
+	email = NormalizeEmail(email)
+	grant := EntitlementGrant{Email: email, Features: []string{}, Memberships: []Membership{}}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	features := make(map[string]bool)
+	for _, membership := range e.memberships {
+		if membership.Email != email {
+			continue
+		}
+		grant.Memberships = append(grant.Memberships, *membership)
+		if membership.Status == MembershipEnded {
+			continue
+		}
+		unlocked, _ := e.config.features(membership.Product, membership.Tier)
+		for _, feature := range unlocked {
+			features[feature] = true
+		}
+	}
+
+	for feature := range features {
+		grant.Features = append(grant.Features, feature)
+	}
+	sort.Strings(grant.Features)
+	sort.Slice(grant.Memberships, func(i, j int) bool {
+		return grant.Memberships[i].SubscriptionID < grant.Memberships[j].SubscriptionID
+	})
+	return grant
+}
+
+// Handler serves GET /entitlements/{email} for the seller's app
+func (e *Entitlements) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		email, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/entitlements/"))
+		if err != nil || !strings.Contains(email, "@") {
+			writeAPIError(w, http.StatusBadRequest, "expected /entitlements/{email}")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, e.For(email))
+	})
+}
+
+// TrackEntitlements applies every received data_sync message to the table
+func TrackEntitlements(gb *GoBridge, entitlements *Entitlements) {
+	gb.OnReceive(func(message *UniversalMessage) {
+		if !isGumroadEvent(message) {
+			return
+		}
+		_, err := entitlements.Apply(message.Payload)
+		if err != nil {
+			log.Printf("❌ Error saving memberships: %v", err)
+		}
+	})
+}
+
+// saleTier reads the tier a membership sale was bought at; Gumroad sends it
+// as a variant
+func saleTier(sale map[string]interface{}) string {
+	for _, field := range []string{"tier", "variants.Tier", "variants.tier"} {
+		if value, ok := lookupField(sale, field); ok {
+			if tier := planTier(value); tier != "" {
+				return tier
+			}
+		}
+	}
+	return ""
+}
+
+// planTier reads a tier name from either a string or Gumroad's
+// {"tier": {"name": ...}} plan object
+func planTier(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if tier, ok := lookupField(v, "tier.name"); ok {
+			return stringValue(tier)
+		}
+		if name, ok := v["name"]; ok {
+			return stringValue(name)
+		}
+	}
+	return ""
+}
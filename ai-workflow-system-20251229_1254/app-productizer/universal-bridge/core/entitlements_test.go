@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testEntitlements is a club membership with two tiers
+func testEntitlements(t *testing.T) *EntitlementConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "entitlements.yaml")
+	content := `products:
+  club:
+    tiers:
+      Basic: [downloads]
+      Pro: [downloads, community, source_files]
+  mentoring:
+    tiers:
+      Monthly: [calls]
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadEntitlementConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestPlanTier(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"string", "Pro", "Pro"},
+		{"plan object", map[string]interface{}{"tier": map[string]interface{}{"name": "Gold"}}, "Gold"},
+		{"named object", map[string]interface{}{"name": "Silver"}, "Silver"},
+		{"empty object", map[string]interface{}{}, ""},
+		{"number", 3.0, ""},
+		{"missing", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := planTier(tt.value); got != tt.want {
+			t.Errorf("planTier(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaleTier(t *testing.T) {
+	tests := []struct {
+		name string
+		sale map[string]interface{}
+		want string
+	}{
+		{"tier field", map[string]interface{}{"tier": "Pro"}, "Pro"},
+		{"variant", map[string]interface{}{"variants": map[string]interface{}{"Tier": "Basic"}}, "Basic"},
+		{"lower-case variant", map[string]interface{}{"variants": map[string]interface{}{"tier": "Basic"}}, "Basic"},
+		{"empty tier falls through", map[string]interface{}{"tier": "", "variants": map[string]interface{}{"Tier": "Pro"}}, "Pro"},
+		{"no tier", map[string]interface{}{"variants": map[string]interface{}{"Color": "Red"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := saleTier(tt.sale); got != tt.want {
+			t.Errorf("saleTier(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntitlementsApply(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "memberships.json")
+	entitlements, err := NewEntitlements(testEntitlements(t), path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name        string
+		payload     map[string]interface{}
+		wantChanged bool
+		wantFeature []string
+	}{
+		{"not a membership", map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "product_id": "club", "email": "ada@example.com"}, false, []string{}},
+		{"unknown product", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub0", "product_id": "ebook", "email": "ada@example.com", "tier": "Pro"}, false, []string{}},
+		{"basic sale", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "product_id": "club", "email": "Ada@Example.com", "variants": map[string]interface{}{"Tier": "basic"}}, true, []string{"downloads"}},
+		{"same sale again", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "product_id": "club", "email": "ada@example.com", "variants": map[string]interface{}{"Tier": "basic"}}, false, []string{"downloads"}},
+		{"upgrade", map[string]interface{}{"resource_name": "subscription_updated", "subscription_id": "sub1", "new_plan": map[string]interface{}{"tier": map[string]interface{}{"name": "Pro"}}}, true, []string{"community", "downloads", "source_files"}},
+		{"second membership", map[string]interface{}{"resource_name": "sale", "subscription_id": "sub2", "product_id": "mentoring", "email": "ada@example.com", "tier": "Monthly"}, true, []string{"calls", "community", "downloads", "source_files"}},
+		{"cancelled keeps features", map[string]interface{}{"resource_name": "cancellation", "subscription_id": "sub1"}, true, []string{"calls", "community", "downloads", "source_files"}},
+		{"ended drops them", map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub1"}, true, []string{"calls"}},
+		{"restarted", map[string]interface{}{"resource_name": "subscription_restarted", "subscription_id": "sub1"}, true, []string{"calls", "community", "downloads", "source_files"}},
+		{"downgrade", map[string]interface{}{"resource_name": "subscription_updated", "subscription_id": "sub1", "new_plan": "Basic"}, true, []string{"calls", "downloads"}},
+		{"event for an unseen subscription", map[string]interface{}{"resource_name": "cancellation", "subscription_id": "sub9"}, false, []string{"calls", "downloads"}},
+		{"unknown event", map[string]interface{}{"resource_name": "dispute", "subscription_id": "sub1"}, false, []string{"calls", "downloads"}},
+	}
+	for _, step := range steps {
+		clock.Advance(time.Hour)
+		changed, err := entitlements.Apply(step.payload)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if changed != step.wantChanged {
+			t.Errorf("%s: changed = %t, want %t", step.name, changed, step.wantChanged)
+		}
+		if got := entitlements.For("ada@example.com").Features; !reflect.DeepEqual(got, step.wantFeature) {
+			t.Errorf("%s: features %v, want %v", step.name, got, step.wantFeature)
+		}
+	}
+
+	grant := entitlements.For(" ADA@example.com ")
+	if grant.Email != "ada@example.com" || len(grant.Memberships) != 2 || grant.Memberships[0].SubscriptionID != "sub1" || grant.Memberships[1].SubscriptionID != "sub2" {
+		t.Fatalf("grant %+v, want sub1 and sub2 for ada", grant)
+	}
+	if sub1 := grant.Memberships[0]; sub1.Tier != "Basic" || sub1.Status != MembershipActive || !sub1.UpdatedAt.Equal(time.Date(2026, 1, 15, 19, 30, 0, 0, time.UTC)) {
+		t.Errorf("sub1 %+v, want active Basic updated at the downgrade", sub1)
+	}
+	if nobody := entitlements.For("bob@example.com"); len(nobody.Features) != 0 || len(nobody.Memberships) != 0 {
+		t.Errorf("bob %+v, want nothing", nobody)
+	}
+
+	reopened, err := NewEntitlements(testEntitlements(t), path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.For("ada@example.com"); !reflect.DeepEqual(got, grant) {
+		t.Errorf("after reopening %+v, want %+v", got, grant)
+	}
+}
+
+func TestTrackEntitlements(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	entitlements, err := NewEntitlements(testEntitlements(t), filepath.Join(t.TempDir(), "memberships.json"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TrackEntitlements(gb, entitlements)
+
+	ids := NewSequentialIDs("gumroad")
+	receive := func(messageType MessageType, payload map[string]interface{}) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, messageType, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(SaleEvent, map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "subscription_id": "sub1", "product_id": "club", "email": "ada@example.com", "price": "5", "tier": "Pro"})
+	receive(DataSync, map[string]interface{}{"resource_name": "subscription_updated", "subscription_id": "sub1", "new_plan": "Basic"})
+	receive(AIRequest, map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub1"}) // not a Gumroad event
+
+	if got := entitlements.For("ada@example.com").Features; !reflect.DeepEqual(got, []string{"downloads"}) {
+		t.Errorf("features %v, want the downgraded tier's", got)
+	}
+}
+
+func TestEntitlementsHandler(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	entitlements, err := NewEntitlements(testEntitlements(t), filepath.Join(t.TempDir(), "memberships.json"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entitlements.Apply(map[string]interface{}{"resource_name": "sale", "subscription_id": "sub1", "product_id": "club", "email": "ada+club@example.com", "tier": "Pro"}); err != nil {
+		t.Fatal(err)
+	}
+	handler := entitlements.Handler()
+
+	tests := []struct {
+		method, target string
+		wantStatus     int
+		wantFeatures   int
+	}{
+		{http.MethodGet, "/entitlements/ada%2Bclub@example.com", http.StatusOK, 3},
+		{http.MethodGet, "/entitlements/bob@example.com", http.StatusOK, 0},
+		{http.MethodGet, "/entitlements/bob", http.StatusBadRequest, 0},
+		{http.MethodPost, "/entitlements/ada@example.com", http.StatusMethodNotAllowed, 0},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(tt.method, tt.target, nil))
+		if response.Code != tt.wantStatus {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.target, response.Code, tt.wantStatus)
+			continue
+		}
+		if response.Code != http.StatusOK {
+			continue
+		}
+		var grant EntitlementGrant
+		if err := json.Unmarshal(response.Body.Bytes(), &grant); err != nil {
+			t.Fatal(err)
+		}
+		if len(grant.Features) != tt.wantFeatures {
+			t.Errorf("%s %s features %v, want %d", tt.method, tt.target, grant.Features, tt.wantFeatures)
+		}
+	}
+}
+
+func TestLoadEntitlementConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadEntitlementConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("loaded a missing file")
+	}
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("products: [club"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEntitlementConfig(bad); err == nil {
+		t.Error("loaded invalid YAML")
+	}
+	corrupt := filepath.Join(dir, "memberships.json")
+	if err := os.WriteFile(corrupt, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEntitlements(&EntitlementConfig{}, corrupt, NewManualClock(time.Now())); err == nil {
+		t.Error("opened a corrupt membership table")
+	}
+}
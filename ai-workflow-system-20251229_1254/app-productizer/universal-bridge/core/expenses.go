@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Expense recurrences; an expense with neither is spent once
+const (
+	ExpenseMonthly = "monthly"
+	ExpenseYearly  = "yearly"
+)
+
+// ExpenseAI is the category of the AI spend the bridge records itself
+const ExpenseAI = "ai"
+
+// expenseDate is the layout of expense and report dates
+const expenseDate = "2006-01-02"
+
+// Expense is money the business spent: once on Date, or every month or
+// year from Date until it is stopped. A monthly expense dated the 31st falls
+// on the last day of shorter months.
+type Expense struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	Amount      Money  `json:"amount"`
+	Date        string `json:"date"`                // 2006-01-02; a recurring expense's first
+	Recurring   string `json:"recurring,omitempty"` // monthly or yearly
+	Until       string `json:"until,omitempty"`     // the last date a recurring expense can fall on
+	// AI is the usage behind a day's AI spend on one provider and model
+	AI *AISpend `json:"ai,omitempty"`
+}
+
+// AISpend is a day's generations with one provider's model
+type AISpend struct {
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	Requests     int    `json:"requests"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	// Cost is in minor units and not rounded, so calls costing fractions
+	// of a cent add up to the expense's amount
+	Cost float64 `json:"cost"`
+}
+
+// AIPrice is what a provider charges, in a currency's major units per
+// million tokens
+type AIPrice struct {
+	Input    float64
+	Output   float64
+	Currency string
+}
+
+// ExpenseCategory totals a category's expenses in a report
+type ExpenseCategory struct {
+	Category string  `json:"category"`
+	Amount   []Money `json:"amount"`
+}
+
+// ProfitReport sets revenue against expenses over a range of days. Refunds
+// count on the day they were made, like the sales they take back from.
+type ProfitReport struct {
+	From       string            `json:"from"`
+	To         string            `json:"to"` // included
+	Sales      int               `json:"sales"`
+	Refunds    int               `json:"refunds"`
+	Gross      []Money           `json:"gross"`
+	Refunded   []Money           `json:"refunded"`
+	Revenue    []Money           `json:"revenue"` // gross less refunds
+	Expenses   []Money           `json:"expenses"`
+	NetProfit  []Money           `json:"net_profit"` // revenue less expenses, per currency
+	Categories []ExpenseCategory `json:"categories"`
+}
+
+// Expenses is the seller's ledger of expenses, persisted as JSON at path,
+// with the profit reports made from it and the sales store
+type Expenses struct {
+	// Location is the timezone expense dates and report days are in
+	Location *time.Location
+
+	bridge *GoBridge
+	sales  *SalesStore
+	path   string
+
+	mu       sync.Mutex
+	expenses map[string]*Expense
+}
+
+// NewExpenses opens the expenses persisted at path, in UTC until Location
+// is changed
+func NewExpenses(gb *GoBridge, sales *SalesStore, path string) (*Expenses, error) {
+	ex := &Expenses{Location: time.UTC, bridge: gb, sales: sales, path: path, expenses: make(map[string]*Expense)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read expenses: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &ex.expenses)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse expenses %s: %v", path, err)
+		}
+	}
+	return ex, nil
+}
+
+// Add validates and saves an expense, giving it an ID. The category
+// defaults to "other".
+func (ex *Expenses) Add(expense Expense) (Expense, error) {
+	expense.Description = strings.TrimSpace(expense.Description)
+	expense.Category = strings.ToLower(strings.TrimSpace(expense.Category))
+	if expense.Category == "" {
+		expense.Category = "other"
+	}
+	currency, err := parseCurrency(expense.Amount.Currency)
+	if err != nil {
+		return Expense{}, err
+	}
+	expense.Amount.Currency = currency
+	switch {
+	case expense.Description == "":
+		return Expense{}, fmt.Errorf("expense needs a description")
+	case expense.Amount.Amount <= 0:
+		return Expense{}, fmt.Errorf("expense amount must be positive")
+	case expense.Recurring != "" && expense.Recurring != ExpenseMonthly && expense.Recurring != ExpenseYearly:
+		return Expense{}, fmt.Errorf("recurring is %q, not %s or %s", expense.Recurring, ExpenseMonthly, ExpenseYearly)
+	case expense.Until != "" && expense.Recurring == "":
+		return Expense{}, fmt.Errorf("until is for recurring expenses")
+	}
+	date, err := time.Parse(expenseDate, expense.Date)
+	if err != nil {
+		return Expense{}, fmt.Errorf("expense date %q is not like 2026-01-31", expense.Date)
+	}
+	if expense.Until != "" {
+		until, err := time.Parse(expenseDate, expense.Until)
+		if err != nil {
+			return Expense{}, fmt.Errorf("until %q is not like 2026-01-31", expense.Until)
+		}
+		if until.Before(date) {
+			return Expense{}, fmt.Errorf("expense stops before its first date")
+		}
+	}
+	expense.ID = ex.bridge.ids.NewID()
+	expense.AI = nil
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.expenses[expense.ID] = &expense
+	err = ex.saveLocked()
+	if err != nil {
+		delete(ex.expenses, expense.ID)
+		return Expense{}, err
+	}
+	fmt.Printf("💸 Logged expense %s: %s\n", expense.Description, expense.Amount)
+	return expense, nil
+}
+
+// Remove deletes an expense; ok is false when there is none with the ID
+func (ex *Expenses) Remove(id string) (ok bool, err error) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	expense, ok := ex.expenses[id]
+	if !ok {
+		return false, nil
+	}
+	delete(ex.expenses, id)
+	err = ex.saveLocked()
+	if err != nil {
+		ex.expenses[id] = expense
+		return true, err
+	}
+	return true, nil
+}
+
+// List returns every expense, by date
+func (ex *Expenses) List() []Expense {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	list := make([]Expense, 0, len(ex.expenses))
+	for _, expense := range ex.expenses {
+		list = append(list, *expense)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Date != list[j].Date {
+			return list[i].Date < list[j].Date
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// RecordAISpend adds a generation's cost to the day's AI expense for its
+// provider and model
+func (ex *Expenses) RecordAISpend(provider string, result AIResult, price AIPrice) error {
+	currency, err := parseCurrency(price.Currency)
+	if err != nil {
+		return err
+	}
+	scale := math.Pow10(currencyExponent(currency))
+	cost := (float64(result.InputTokens)*price.Input + float64(result.OutputTokens)*price.Output) / 1e6 * scale
+
+	day := ex.bridge.clock.Now().In(ex.Location).Format(expenseDate)
+	id := "ai-" + day + "-" + provider + "-" + result.Model
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	expense, ok := ex.expenses[id]
+	if !ok {
+		expense = &Expense{ID: id, Description: strings.TrimSpace("AI: " + provider + " " + result.Model), Category: ExpenseAI,
+			Amount: NewMoney(0, currency), Date: day, AI: &AISpend{Provider: provider, Model: result.Model}}
+		ex.expenses[id] = expense
+	}
+	expense.AI.Requests++
+	expense.AI.InputTokens += result.InputTokens
+	expense.AI.OutputTokens += result.OutputTokens
+	expense.AI.Cost += cost
+	expense.Amount.Amount = int64(math.Round(expense.AI.Cost))
+	return ex.saveLocked()
+}
+
+// occurrences returns how many times an expense falls on a day in [from, to)
+func (ex *Expenses) occurrences(expense Expense, from, to time.Time) int {
+	first, err := time.ParseInLocation(expenseDate, expense.Date, ex.Location)
+	if err != nil {
+		return 0
+	}
+	last := to
+	if expense.Until != "" {
+		until, err := time.ParseInLocation(expenseDate, expense.Until, ex.Location)
+		if err == nil && until.AddDate(0, 0, 1).Before(last) {
+			last = until.AddDate(0, 0, 1)
+		}
+	}
+	if expense.Recurring == "" {
+		if !first.Before(from) && first.Before(to) {
+			return 1
+		}
+		return 0
+	}
+
+	count := 0
+	for i := 0; ; i++ {
+		var at time.Time
+		if expense.Recurring == ExpenseYearly {
+			at = dayOfMonth(first.Year()+i, first.Month(), first.Day(), ex.Location)
+		} else {
+			at = dayOfMonth(first.Year(), first.Month()+time.Month(i), first.Day(), ex.Location)
+		}
+		if !at.Before(last) {
+			return count
+		}
+		if !at.Before(from) {
+			count++
+		}
+	}
+}
+
+// dayOfMonth is the day in the month, or the month's last day when it is
+// shorter
+func dayOfMonth(year int, month time.Month, day int, location *time.Location) time.Time {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, location)
+	if last := start.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return start.AddDate(0, 0, day-1)
+}
+
+// Profit reports the days from from through to, dates like 2026-01-31
+func (ex *Expenses) Profit(from, to string) (ProfitReport, error) {
+	start, end, err := parseDayRange(from, to, ex.Location)
+	if err != nil {
+		return ProfitReport{}, err
+	}
+	in := func(at time.Time) bool { return !at.Before(start) && at.Before(end) }
+
+	report := ProfitReport{From: from, To: to}
+	gross, refunded, revenue, spent, net := MoneyTotals{}, MoneyTotals{}, MoneyTotals{}, MoneyTotals{}, MoneyTotals{}
+	for _, sale := range ex.sales.All() {
+		// A bundle's components carry its price between them
+		if sale.IsBundle() || sale.CreatedAt.IsZero() {
+			continue
+		}
+		if in(sale.CreatedAt) {
+			report.Sales++
+			gross.Add(sale.Price)
+			revenue.Add(sale.Price)
+			net.Add(sale.Price)
+		}
+		if amount, at, ok := refundOf(sale); ok && in(at) {
+			report.Refunds++
+			refunded.Add(amount)
+			taken := Money{Amount: -amount.Amount, Currency: amount.Currency}
+			revenue.Add(taken)
+			net.Add(taken)
+		}
+	}
+
+	categories := make(map[string]MoneyTotals)
+	for _, expense := range ex.List() {
+		n := ex.occurrences(expense, start, end)
+		if n == 0 {
+			continue
+		}
+		amount, err := expense.Amount.Mul(int64(n))
+		if err != nil {
+			return ProfitReport{}, fmt.Errorf("expense %s: %v", expense.ID, err)
+		}
+		if categories[expense.Category] == nil {
+			categories[expense.Category] = MoneyTotals{}
+		}
+		categories[expense.Category].Add(amount)
+		spent.Add(amount)
+		net.Add(Money{Amount: -amount.Amount, Currency: amount.Currency})
+	}
+
+	report.Gross, report.Refunded, report.Revenue = gross.List(), refunded.List(), revenue.List()
+	report.Expenses, report.NetProfit = spent.List(), net.List()
+	report.Categories = make([]ExpenseCategory, 0, len(categories))
+	for category, totals := range categories {
+		report.Categories = append(report.Categories, ExpenseCategory{Category: category, Amount: totals.List()})
+	}
+	sort.Slice(report.Categories, func(i, j int) bool { return report.Categories[i].Category < report.Categories[j].Category })
+	return report, nil
+}
+
+// Handler serves /expenses: GET lists the expenses, POST logs the expense
+// in the JSON body, and DELETE /expenses/{id} removes one
+func (ex *Expenses) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/expenses"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"expenses": ex.List()})
+		case id == "" && r.Method == http.MethodPost:
+			var expense Expense
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&expense)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad expense: %v", err))
+				return
+			}
+			added, err := ex.Add(expense)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusCreated, added)
+		case id != "" && r.Method == http.MethodDelete:
+			ok, err := ex.Remove(id)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no expense %s", id))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case id == "":
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "use DELETE")
+		}
+	})
+}
+
+// ProfitHandler serves /metrics/profit, the report from ?from= through
+// ?to=, this month so far by default
+func (ex *Expenses) ProfitHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		from, to := dayRangeQuery(r, ex.bridge.clock.Now(), ex.Location)
+		report, err := ex.Profit(from, to)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, report)
+	})
+}
+
+func (ex *Expenses) saveLocked() error {
+	return writeJSONFile(ex.path, ex.expenses)
+}
+
+// RegisterExpenseJobs adds the profit_digest job handler. It renders the
+// profit_digest template with the report of the args.days days (default 7)
+// up to yesterday and queues it on the args.sink sink (default "slack").
+func RegisterExpenseJobs(s *Scheduler, gb *GoBridge, ex *Expenses, templates *TemplateStore) {
+	s.Handle("profit_digest", func(job Job) error {
+		sinkName := stringArg(job.Args, "sink")
+		if sinkName == "" {
+			sinkName = "slack"
+		}
+		sink := gb.Sink(sinkName)
+		if sink == nil {
+			return fmt.Errorf("no sink named %s", sinkName)
+		}
+
+		days := 7
+		if n, ok := toNumber(job.Args["days"]); ok && n > 0 {
+			days = int(n)
+		}
+		today := gb.clock.Now().In(ex.Location)
+		report, err := ex.Profit(today.AddDate(0, 0, -days).Format(expenseDate), today.AddDate(0, 0, -1).Format(expenseDate))
+		if err != nil {
+			return err
+		}
+
+		var categories []interface{}
+		for _, category := range report.Categories {
+			categories = append(categories, map[string]interface{}{"category": category.Category, "amount": amountsText(category.Amount)})
+		}
+		text, err := templates.Render("profit_digest", "", map[string]interface{}{
+			"from":       report.From,
+			"to":         report.To,
+			"sales":      report.Sales,
+			"refunds":    report.Refunds,
+			"gross":      amountsText(report.Gross),
+			"refunded":   amountsText(report.Refunded),
+			"revenue":    amountsText(report.Revenue),
+			"expenses":   amountsText(report.Expenses),
+			"net_profit": amountsText(report.NetProfit),
+			"categories": categories,
+		})
+		if err != nil {
+			return err
+		}
+		return sink.Enqueue(gb.NewMessage(DataSync, "go", map[string]interface{}{"text": text}, FileSystem))
+	})
+}
+
+// amountsText lists amounts for a digest, e.g. "120.00 USD, -4.00 EUR"
+func amountsText(amounts []Money) string {
+	if len(amounts) == 0 {
+		return "0"
+	}
+	texts := make([]string, len(amounts))
+	for i, amount := range amounts {
+		texts[i] = amount.String()
+	}
+	return strings.Join(texts, ", ")
+}
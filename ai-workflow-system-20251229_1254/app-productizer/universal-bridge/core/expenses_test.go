@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// meteredProvider answers every prompt with the same token counts
+type meteredProvider struct{}
+
+func (meteredProvider) Name() string { return "anthropic" }
+
+func (meteredProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	return AIResult{Text: "hi", Provider: "anthropic", Model: "haiku", InputTokens: 1000, OutputTokens: 500}, nil
+}
+
+func TestProfitReport(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var digests []string
+	gb.AddSink("slack", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		digests = append(digests, stringArg(message.Payload, "text"))
+		return message
+	})
+
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []struct {
+		id    string
+		price float64
+		at    string
+	}{
+		{"s1", 100, "2026-01-27T12:00:00Z"},
+		{"s2", 50, "2026-01-30T12:00:00Z"},
+		{"s3", 30, "2026-01-20T12:00:00Z"}, // the week before
+	} {
+		_, err := sales.Record(map[string]interface{}{"sale_id": sale.id, "product_id": "course", "price": sale.price,
+			"currency": "usd", "email": sale.id + "@example.com", "sale_timestamp": sale.at})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sales.Refund(Refund{SaleID: "s2", Amount: NewMoney(5000, "usd"), RefundedAt: time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "expenses.json")
+	expenses, err := NewExpenses(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expense := range []Expense{
+		{Description: "Hosting", Category: "hosting", Amount: NewMoney(2000, "usd"), Date: "2025-11-30", Recurring: ExpenseMonthly},
+		{Description: "Design tool", Category: "Software", Amount: NewMoney(12000, "usd"), Date: "2025-01-28", Recurring: ExpenseYearly},
+		{Description: "Old plan", Category: "software", Amount: NewMoney(500, "usd"), Date: "2025-06-15", Recurring: ExpenseMonthly, Until: "2026-01-15"},
+		{Description: "Ads", Amount: NewMoney(1500, "usd"), Date: "2026-01-29"},
+		{Description: "Ads", Amount: NewMoney(1500, "usd"), Date: "2026-02-02"}, // today, after the week
+	} {
+		if _, err := expenses.Add(expense); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1.05 cents a call at $3 and $15 per million tokens
+	ai := NewAIServer(gb, meteredProvider{})
+	ai.Expenses = expenses
+	ai.Prices["anthropic"] = AIPrice{Input: 3, Output: 15, Currency: "usd"}
+	for i := 0; i < 10; i++ {
+		if _, err := ai.Generate("", AIPrompt{Prompt: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(45 * time.Hour)
+
+	report, err := expenses.Profit("2026-01-26", "2026-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usd := func(cents int64) []Money { return []Money{NewMoney(cents, "usd")} }
+	want := ProfitReport{From: "2026-01-26", To: "2026-02-01", Sales: 2, Refunds: 1,
+		Gross: usd(15000), Refunded: usd(5000), Revenue: usd(10000), Expenses: usd(15511), NetProfit: usd(-5511),
+		Categories: []ExpenseCategory{{"ai", usd(11)}, {"hosting", usd(2000)}, {"other", usd(1500)}, {"software", usd(12000)}}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report %+v\nwant %+v", report, want)
+	}
+	// A monthly expense dated the 30th falls on February's last day
+	if report, err := expenses.Profit("2026-02-01", "2026-02-28"); err != nil || !reflect.DeepEqual(report.Categories[0], ExpenseCategory{"hosting", usd(2000)}) {
+		t.Errorf("february %+v: %v", report.Categories, err)
+	}
+
+	reopened, err := NewExpenses(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reopened.List(); len(list) != 6 || list[len(list)-2].AI == nil || list[len(list)-2].AI.Requests != 10 {
+		t.Errorf("reopened %+v", list)
+	}
+
+	scheduler := NewScheduler("", clock)
+	RegisterExpenseJobs(scheduler, gb, expenses, NewTemplateStore("templates"))
+	if err := scheduler.handlers["profit_digest"](Job{Args: map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 1 || !strings.Contains(digests[0], "100.00 USD (150.00 USD from 2 sales, less 50.00 USD refunded)") || !strings.Contains(digests[0], "Net profit: -55.11 USD") {
+		t.Errorf("digests %q", digests)
+	}
+
+	var added Expense
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+		contains           string
+	}{
+		{http.MethodPost, "/expenses", `{"description": "Mic", "category": "equipment", "amount": {"amount": 9900, "currency": "usd"}, "date": "2026-02-02"}`, http.StatusCreated, `"id"`},
+		{http.MethodPost, "/expenses", `{"description": "Mic", "amount": {"amount": 9900}, "date": "yesterday"}`, http.StatusBadRequest, "date"},
+		{http.MethodGet, "/expenses", "", http.StatusOK, `"Mic"`},
+		{http.MethodDelete, "/expenses/{id}", "", http.StatusNoContent, ""},
+		{http.MethodDelete, "/expenses/{id}", "", http.StatusNotFound, "no expense"},
+		{http.MethodDelete, "/expenses", "", http.StatusMethodNotAllowed, "GET or POST"},
+		{http.MethodGet, "/metrics/profit?from=2026-01-26&to=2026-02-01", "", http.StatusOK, `"net_profit":[{"amount":-5511,"currency":"usd"}]`},
+		{http.MethodGet, "/metrics/profit", "", http.StatusOK, `"from":"2026-02-01","to":"2026-02-02"`},
+		{http.MethodGet, "/metrics/profit?to=2026-01-01", "", http.StatusBadRequest, "before"},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(test.method, strings.Replace(test.path, "{id}", added.ID, 1), strings.NewReader(test.body))
+		handler := expenses.Handler()
+		if strings.HasPrefix(test.path, "/metrics/profit") {
+			handler = expenses.ProfitHandler()
+		}
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.status || !strings.Contains(recorder.Body.String(), test.contains) {
+			t.Errorf("%s %s answered %d, want %d with %q: %s", test.method, test.path, recorder.Code, test.status, test.contains, recorder.Body)
+		}
+		if test.status == http.StatusCreated {
+			json.Unmarshal(recorder.Body.Bytes(), &added)
+		}
+	}
+}
+
+func TestInvalidExpense(t *testing.T) {
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	expenses, err := NewExpenses(gb, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usd := NewMoney(1000, "usd")
+	tests := []struct {
+		name    string
+		expense Expense
+	}{
+		{"no description", Expense{Amount: usd, Date: "2026-01-01"}},
+		{"no amount", Expense{Description: "Hosting", Amount: NewMoney(0, "usd"), Date: "2026-01-01"}},
+		{"currency", Expense{Description: "Hosting", Amount: NewMoney(1000, "dollars"), Date: "2026-01-01"}},
+		{"date", Expense{Description: "Hosting", Amount: usd, Date: "01/01/2026"}},
+		{"recurring", Expense{Description: "Hosting", Amount: usd, Date: "2026-01-01", Recurring: "weekly"}},
+		{"until without recurring", Expense{Description: "Hosting", Amount: usd, Date: "2026-01-01", Until: "2026-06-01"}},
+		{"until before date", Expense{Description: "Hosting", Amount: usd, Date: "2026-01-01", Recurring: ExpenseMonthly, Until: "2025-12-01"}},
+	}
+	for _, test := range tests {
+		if _, err := expenses.Add(test.expense); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+	if list := expenses.List(); len(list) != 0 {
+		t.Errorf("kept %+v", list)
+	}
+}
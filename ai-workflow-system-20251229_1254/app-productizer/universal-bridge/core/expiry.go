@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// What becomes of a received message past its expires_at
+const (
+	ExpiryDeadLetter = "dead_letter" // kept in the dead letter queue as dead
+	ExpiryDrop       = "drop"        // deleted
+)
+
+// ErrMessageExpired matches the reason an expired message was not handled
+var ErrMessageExpired = errors.New("message expired")
+
+// expiry parses ExpiresAt; ok is false for a message that never expires
+func (m *UniversalMessage) expiry() (at time.Time, ok bool, err error) {
+	if m.ExpiresAt == "" {
+		return time.Time{}, false, nil
+	}
+	at, err = time.Parse(time.RFC3339Nano, m.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expires_at %q is not an RFC 3339 time", m.ExpiresAt)
+	}
+	return at, true, nil
+}
+
+// Expired reports whether the message's expires_at has passed. One that
+// cannot be parsed has expired, since nothing can tell it has not.
+func (m *UniversalMessage) Expired(now time.Time) bool {
+	at, ok, err := m.expiry()
+	return err != nil || (ok && !now.Before(at))
+}
+
+// ExpireAfter sets expires_at ttl after the message's timestamp
+func (m *UniversalMessage) ExpireAfter(ttl time.Duration) error {
+	created, err := time.Parse(time.RFC3339Nano, m.Timestamp)
+	if err != nil {
+		return fmt.Errorf("message %s has no RFC 3339 timestamp to expire after", m.ID)
+	}
+	m.ExpiresAt = created.Add(ttl).UTC().Format(time.RFC3339)
+	return nil
+}
+
+// expire refuses a received message past its expires_at: it is dead-lettered
+// as dead or, with ExpiryDrop or no dead letter queue, dropped. It reports
+// false, so the transport keeps the message, if the dead letter could not
+// be written.
+func (gb *GoBridge) expire(message *UniversalMessage) bool {
+	gb.mu.RLock()
+	deadLetters := gb.deadLetters
+	store := gb.store
+	gb.mu.RUnlock()
+
+	reason := fmt.Errorf("%w at %s", ErrMessageExpired, message.ExpiresAt)
+	if store != nil {
+		store.received(message)
+		store.handled(message, StatusExpired, reason)
+	}
+	gb.metrics.expiredMessage(message)
+	if gb.pipeline.expiredAction == ExpiryDeadLetter && deadLetters != nil {
+		return deadLetters.failed(message, reason)
+	}
+	fmt.Printf("⌛ Dropping message %s (%s), which expired at %s\n", message.ID, message.MessageType, message.ExpiresAt)
+	return true
+}
+
+// ExpirySweeper clears expired messages out of the file transport's
+// directories, so a backlog left while this bridge or a peer was down is not
+// worked through hours later. Expired files in the inbox are refused as the
+// pipeline refuses them, dead-lettered or dropped. Those in the outbox and
+// mirrors were sent by this bridge and are the peer's to refuse, so they are
+// moved to an expired/ directory beside them, or deleted with ExpiryDrop.
+// A message that expires after a sweep is still refused when dispatched.
+type ExpirySweeper struct {
+	bridge    *GoBridge
+	transport *FileTransport
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewExpirySweeper sweeps the directories of the bridge's file transport
+func NewExpirySweeper(gb *GoBridge, transport *FileTransport) *ExpirySweeper {
+	return &ExpirySweeper{bridge: gb, transport: transport, stop: make(chan struct{})}
+}
+
+// Sweep checks every waiting file once and returns how many had expired
+func (es *ExpirySweeper) Sweep() int {
+	ft := es.transport
+	now := es.bridge.clock.Now()
+	expired := es.sweepDir(ft.inboxDir, now, func(path string, message *UniversalMessage) error {
+		// Claimed first, so the transport cannot deliver it meanwhile
+		claimed := filepath.Join(ft.claimedDir, filepath.Base(path))
+		err := os.Rename(path, claimed)
+		if err != nil {
+			return err
+		}
+		message.receivedOn = FileSystem
+		if !es.bridge.expire(message) {
+			return os.Rename(claimed, path)
+		}
+		return os.Remove(claimed)
+	})
+	for _, dir := range append([]string{ft.outboxDir}, ft.extraDirs...) {
+		expired += es.sweepDir(dir, now, func(path string, message *UniversalMessage) error {
+			if es.bridge.pipeline.expiredAction == ExpiryDrop {
+				fmt.Printf("⌛ Deleting %s, which expired unread at %s\n", path, message.ExpiresAt)
+				return os.Remove(path)
+			}
+			expiredDir := filepath.Join(dir, "expired")
+			err := os.MkdirAll(expiredDir, 0755)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("⌛ Moving %s to %s, as it expired unread at %s\n", path, expiredDir, message.ExpiresAt)
+			return os.Rename(path, filepath.Join(expiredDir, filepath.Base(path)))
+		})
+	}
+	return expired
+}
+
+// sweepDir hands each expired message file in dir to remove. Files that
+// cannot be decoded are left for whoever reads the directory to reject.
+func (es *ExpirySweeper) sweepDir(dir string, now time.Time, remove func(path string, message *UniversalMessage) error) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	expired := 0
+	for _, entry := range entries {
+		contentType, ok := contentTypeForFile(entry.Name())
+		if entry.IsDir() || !ok {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		file, err := os.Open(path)
+		if err != nil {
+			continue // claimed or read since the listing
+		}
+		message, err := decodeEnvelope(file, contentType, es.bridge.pipeline.limitFor(FileSystem))
+		file.Close()
+		if err != nil || message.ExpiresAt == "" || !message.Expired(now) {
+			continue
+		}
+		err = remove(path, message)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("❌ Error clearing expired message %s: %v", path, err)
+			continue
+		}
+		expired++
+	}
+	return expired
+}
+
+// Start sweeps every interval until Close
+func (es *ExpirySweeper) Start(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-es.stop:
+				return
+			case <-es.bridge.clock.After(interval):
+				es.Sweep()
+			}
+		}
+	}()
+}
+
+// Close stops the sweeper
+func (es *ExpirySweeper) Close() {
+	es.stopOnce.Do(func() { close(es.stop) })
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpiredMessagesAreNotHandled(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		expiresIn  time.Duration // from now; zero sets no expires_at
+		wantRuns   int32
+		wantLetter bool
+	}{
+		{"no expiry", ExpiryDeadLetter, 0, 1, false},
+		{"not yet expired", ExpiryDeadLetter, time.Minute, 1, false},
+		{"expired, dead-lettered", ExpiryDeadLetter, -time.Minute, 0, true},
+		{"expired, dropped", ExpiryDrop, -time.Minute, 0, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+			transport := NewMemoryTransport()
+			config := DefaultPipelineConfig()
+			config.ExpiredAction = tt.action
+			gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithPipelineConfig(config))
+			t.Cleanup(func() { gb.Close() })
+			dl, err := NewDeadLetterQueue(gb, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var runs int32
+			gb.OnMessage(AIRequest, func(*UniversalMessage) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			})
+			request := newUniversalMessage(clock, NewSequentialIDs("py"), AIRequest, "python", "go", map[string]interface{}{"prompt": "hi"}, SharedMemory)
+			if tt.expiresIn != 0 {
+				request.ExpiresAt = clock.Now().Add(tt.expiresIn).Format(time.RFC3339)
+			}
+			if err := transport.Inject(request); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := atomic.LoadInt32(&runs); n != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", n, tt.wantRuns)
+			}
+			letters := dl.List("")
+			if tt.wantLetter != (len(letters) == 1) {
+				t.Fatalf("dead letters %+v", letters)
+			}
+			if tt.wantLetter && letters[0].Status != DeadLetterDead {
+				t.Errorf("expired message is %s, want dead", letters[0].Status)
+			}
+		})
+	}
+}
+
+func TestExpiryOnSend(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	config := DefaultPipelineConfig()
+	config.TTL = map[MessageType]time.Duration{AIRequest: time.Hour}
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithPipelineConfig(config))
+	t.Cleanup(func() { gb.Close() })
+
+	request := gb.NewMessage(AIRequest, "python", map[string]interface{}{"prompt": "hi"}, FileSystem)
+	if _, err := gb.SendMessage(request); err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.Sent()) != 1 || transport.Sent()[0].ExpiresAt != "2026-01-15T10:30:00Z" {
+		t.Errorf("sent %+v, want it to expire an hour on", transport.Sent())
+	}
+
+	// Other types keep no expiry, and an explicit one wins
+	sync := gb.NewMessage(DataSync, "python", nil, FileSystem)
+	if _, err := gb.SendMessage(sync); err != nil || sync.ExpiresAt != "" {
+		t.Errorf("data_sync sent with expires_at %q: %v", sync.ExpiresAt, err)
+	}
+	stale := gb.NewMessage(AIRequest, "python", nil, FileSystem)
+	stale.ExpiresAt = clock.Now().Add(-time.Second).Format(time.RFC3339)
+	if _, err := gb.SendMessage(stale); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("sending an expired message: %v", err)
+	}
+	if len(transport.Sent()) != 2 {
+		t.Errorf("sent %d messages, want the expired one refused", len(transport.Sent()))
+	}
+
+	bad := newUniversalMessage(clock, NewSequentialIDs("py"), AIRequest, "python", "go", nil, SharedMemory)
+	bad.ExpiresAt = "tomorrow"
+	if err := transport.Inject(bad); err == nil {
+		t.Error("accepted a message whose expires_at is not a time")
+	}
+}
+
+func TestExpirySweeper(t *testing.T) {
+	inTempDir(t)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	dl, err := NewDeadLetterQueue(gb, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := NewFileTransport("go", clock)
+	if err := ft.ensureDirectories(); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := NewSequentialIDs("py")
+	write := func(dir string, expiresIn time.Duration) string {
+		t.Helper()
+		message := newUniversalMessage(clock, ids, AIRequest, "python", "go", map[string]interface{}{"prompt": "hi"}, FileSystem)
+		if expiresIn != 0 {
+			message.ExpiresAt = clock.Now().Add(expiresIn).Format(time.RFC3339)
+		}
+		content, err := message.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, message.ID+".json")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	stale := write(ft.inboxDir, time.Minute)
+	fresh := write(ft.inboxDir, time.Hour)
+	forever := write(ft.inboxDir, 0)
+	unread := write(ft.outboxDir, time.Minute)
+	mirrored := write(ft.extraDirs[0], time.Minute)
+	corrupt := filepath.Join(ft.inboxDir, "corrupt.json")
+	if err := ioutil.WriteFile(corrupt, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sweeper := NewExpirySweeper(gb, ft)
+	if n := sweeper.Sweep(); n != 0 {
+		t.Fatalf("swept %d messages before any expired", n)
+	}
+	clock.Advance(2 * time.Minute)
+	if n := sweeper.Sweep(); n != 3 {
+		t.Fatalf("swept %d messages, want 3", n)
+	}
+
+	for _, path := range []string{fresh, forever, corrupt} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was swept: %v", path, err)
+		}
+	}
+	for _, path := range []string{stale, unread, mirrored} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was left: %v", path, err)
+		}
+	}
+	if n := countFiles(t, ft.claimedDir); n != 0 {
+		t.Errorf("claimed/ holds %d files", n)
+	}
+	for _, dir := range []string{ft.outboxDir, ft.extraDirs[0]} {
+		if n := countFiles(t, filepath.Join(dir, "expired")); n != 1 {
+			t.Errorf("%s/expired holds %d files, want 1", dir, n)
+		}
+	}
+	if letters := dl.List(DeadLetterDead); len(letters) != 1 || letters[0].Channel != FileSystem {
+		t.Errorf("dead letters %+v, want the expired inbox message", letters)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultFileBatchSize is how many inbox entries are read and claimed per batch
+const DefaultFileBatchSize = 256
+
+// pollInterval is how often the inbox is scanned when it cannot be watched
+const pollInterval = time.Second
+
+// FileTransport exchanges messages as JSON files, or files in a registered
+// encoding, in the bridge_messages directory.
+// Inbox files are claimed in batches by renaming them into a claimed/ directory,
+// so rescans never redeliver a file that is still in flight, and acked files
+// are moved to processed/ together at the end of each batch. The inbox is
+// scanned when fsnotify reports a new file, with a periodic sweep for
+// events the watcher missed; where it cannot be watched, it is polled.
+type FileTransport struct {
+	// BatchSize bounds directory reads, claims, and ack flushes; set before Start
+	BatchSize int
+	// RecoverClaimed returns files left in claimed/ to the inbox at Start, as
+	// a previous run that died never finished them. Defaults to true; a
+	// process handed over to must not, since its predecessor is still
+	// draining them.
+	RecoverClaimed bool
+	// Settle is how long after a file event the inbox is scanned, so a burst
+	// of files is claimed in one pass
+	Settle time.Duration
+	// Reconcile is the interval of the full sweep that catches missed events
+	Reconcile time.Duration
+	// RetryDelay is how long a nacked file waits before it is rescanned
+	RetryDelay time.Duration
+	// Serializer encodes sent messages; nil is JSON. Inbound files are decoded
+	// by whichever registered serializer their extension belongs to.
+	Serializer Serializer
+
+	inboxDir     string
+	claimedDir   string
+	processedDir string
+	outboxDir    string
+	extraDirs    []string
+	clock        Clock
+
+	ackMu   sync.Mutex
+	pending []string // claimed file names acked but not yet moved
+	running bool     // the watcher was started
+	closed  bool     // acks are moved at once rather than batched
+
+	tracer *Tracer // set by the bridge using the transport
+
+	lag int64 // nanoseconds the last claimed file sat in the inbox; atomic
+
+	unclaimMu sync.Mutex
+	unclaimed map[string]bool // nacked files put back, whose Create event is not a new file
+	retry     chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{} // closed when the watcher exits
+}
+
+// NewFileTransport creates a filesystem transport for the given language
+func NewFileTransport(language string, clock Clock) *FileTransport {
+	if clock == nil {
+		clock = defaultClock
+	}
+
+	inboxDir := filepath.Join(DefaultDataDir, language)
+	return &FileTransport{
+		BatchSize:      DefaultFileBatchSize,
+		Settle:         10 * time.Millisecond,
+		Reconcile:      10 * time.Second,
+		RetryDelay:     time.Second,
+		RecoverClaimed: true,
+		inboxDir:       inboxDir,
+		claimedDir:     filepath.Join(inboxDir, "claimed"),
+		processedDir:   filepath.Join(inboxDir, "processed"),
+		outboxDir:      filepath.Join(DefaultDataDir, "incoming"),
+		extraDirs:      []string{filepath.Join(DefaultDataDir, "outgoing")},
+		clock:          clock,
+		unclaimed:      make(map[string]bool),
+		retry:          make(chan struct{}, 1),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+}
+
+// Channel returns FileSystem
+func (ft *FileTransport) Channel() CommunicationChannel {
+	return FileSystem
+}
+
+// Start creates the message directories and starts the file watcher
+func (ft *FileTransport) Start(deliver func(*Envelope)) error {
+	err := ft.ensureDirectories()
+	if err != nil {
+		return err
+	}
+	if ft.BatchSize <= 0 {
+		ft.BatchSize = DefaultFileBatchSize
+	}
+
+	if ft.RecoverClaimed {
+		ft.recoverClaimed()
+	}
+	ft.ackMu.Lock()
+	ft.running = true
+	ft.ackMu.Unlock()
+	go func() {
+		defer close(ft.stopped)
+		ft.startFileWatcher(deliver)
+	}()
+	return nil
+}
+
+// ensureDirectories creates necessary directories
+func (ft *FileTransport) ensureDirectories() error {
+	dirs := append([]string{ft.inboxDir, ft.claimedDir, ft.processedDir, ft.outboxDir}, ft.extraDirs...)
+
+	for _, dir := range dirs {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recoverClaimed returns files claimed by a previous run that never finished
+func (ft *FileTransport) recoverClaimed() {
+	entries, err := os.ReadDir(ft.claimedDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if _, ok := contentTypeForFile(entry.Name()); ok && !entry.IsDir() {
+			os.Rename(filepath.Join(ft.claimedDir, entry.Name()), filepath.Join(ft.inboxDir, entry.Name()))
+		}
+	}
+}
+
+// startFileWatcher scans the inbox whenever a file arrives in it, every
+// Reconcile, and RetryDelay after a nack
+func (ft *FileTransport) startFileWatcher(deliver func(*Envelope)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(ft.inboxDir)
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("❌ Cannot watch %s, polling every %s instead: %v", ft.inboxDir, pollInterval, err)
+		ft.pollInbox(deliver)
+		return
+	}
+	defer watcher.Close()
+
+	ft.processIncomingMessages(deliver)
+	var scan <-chan time.Time
+	sweep := ft.clock.After(ft.Reconcile)
+	schedule := func(delay time.Duration) {
+		if scan == nil {
+			scan = ft.clock.After(delay)
+		}
+	}
+
+	for {
+		select {
+		case <-ft.stop:
+			return
+		case event := <-watcher.Events:
+			if ft.arrived(event) {
+				schedule(ft.Settle)
+			}
+		case err := <-watcher.Errors:
+			// Usually an event queue overflow: events were lost, so look
+			log.Printf("❌ Watching %s: %v", ft.inboxDir, err)
+			schedule(ft.Settle)
+		case <-ft.retry:
+			schedule(ft.RetryDelay)
+		case <-scan:
+			scan = nil
+			ft.processIncomingMessages(deliver)
+		case <-sweep:
+			sweep = ft.clock.After(ft.Reconcile)
+			ft.processIncomingMessages(deliver)
+		}
+	}
+}
+
+// pollInbox scans the inbox every pollInterval until Close
+func (ft *FileTransport) pollInbox(deliver func(*Envelope)) {
+	for {
+		select {
+		case <-ft.stop:
+			return
+		case <-ft.clock.After(pollInterval):
+			ft.processIncomingMessages(deliver)
+		}
+	}
+}
+
+// arrived reports whether a watcher event is a message file to claim. Files
+// claimed away show up as renames and are ignored, as are nacked files put
+// back, which wait for RetryDelay rather than being rescanned at once.
+func (ft *FileTransport) arrived(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	if _, ok := contentTypeForFile(name); !ok {
+		return false
+	}
+
+	ft.unclaimMu.Lock()
+	defer ft.unclaimMu.Unlock()
+	if ft.unclaimed[name] {
+		delete(ft.unclaimed, name)
+		return false
+	}
+	return true
+}
+
+// processIncomingMessages claims and delivers inbox files one batch at a
+// time, stopping between batches once receiving stops
+func (ft *FileTransport) processIncomingMessages(deliver func(*Envelope)) {
+	dir, err := os.Open(ft.inboxDir)
+	if err != nil {
+		return // Directory might not exist yet
+	}
+	defer dir.Close()
+
+	for {
+		entries, err := dir.ReadDir(ft.BatchSize)
+		for _, envelope := range ft.claimBatch(entries) {
+			deliver(envelope)
+		}
+		ft.flushAcked()
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("❌ Error reading %s: %v", ft.inboxDir, err)
+			}
+			return
+		}
+		select {
+		case <-ft.stop:
+			return
+		default:
+		}
+	}
+}
+
+// claimBatch moves a batch of inbox files into claimed/ and wraps each one
+// in an envelope. Files another process claimed first are skipped.
+func (ft *FileTransport) claimBatch(entries []os.DirEntry) []*Envelope {
+	envelopes := make([]*Envelope, 0, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		contentType, ok := contentTypeForFile(name)
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		// Stat before the rename, which the entry's lazy Info cannot follow
+		info, infoErr := entry.Info()
+		claimedPath := filepath.Join(ft.claimedDir, name)
+		err := os.Rename(filepath.Join(ft.inboxDir, name), claimedPath)
+		if err != nil {
+			continue
+		}
+		if infoErr == nil {
+			atomic.StoreInt64(&ft.lag, int64(ft.clock.Now().Sub(info.ModTime())))
+		}
+
+		// Stream the file at decode time; the size limit is enforced while reading
+		open := func() (io.ReadCloser, error) {
+			return os.Open(claimedPath)
+		}
+		envelope := NewStreamEnvelope(FileSystem, claimedPath, -1, open,
+			func() error {
+				ft.markAcked(name)
+				return nil
+			},
+			func(error) {
+				// Unclaim so a later scan retries it
+				ft.unclaimMu.Lock()
+				ft.unclaimed[name] = true
+				ft.unclaimMu.Unlock()
+				os.Rename(claimedPath, filepath.Join(ft.inboxDir, name))
+				select {
+				case ft.retry <- struct{}{}:
+				default:
+				}
+			},
+		)
+		envelope.ContentType = contentType
+		envelopes = append(envelopes, envelope)
+	}
+
+	return envelopes
+}
+
+// Lag returns how long the last claimed file waited in the inbox, from
+// being written to being claimed
+func (ft *FileTransport) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&ft.lag))
+}
+
+// markAcked queues a claimed file for the next processed/ move
+func (ft *FileTransport) markAcked(name string) {
+	ft.ackMu.Lock()
+	ft.pending = append(ft.pending, name)
+	full := len(ft.pending) >= ft.BatchSize || ft.closed
+	ft.ackMu.Unlock()
+
+	if full {
+		ft.flushAcked()
+	}
+}
+
+// flushAcked moves every acked file from claimed/ to processed/ in one pass
+func (ft *FileTransport) flushAcked() {
+	ft.ackMu.Lock()
+	names := ft.pending
+	ft.pending = nil
+	ft.ackMu.Unlock()
+
+	for _, name := range names {
+		err := os.Rename(filepath.Join(ft.claimedDir, name), filepath.Join(ft.processedDir, name))
+		if err != nil {
+			log.Printf("❌ Error moving %s to processed: %v", name, err)
+		}
+	}
+}
+
+// Send writes the message into the shared incoming directory
+func (ft *FileTransport) Send(message *UniversalMessage) error {
+	span := ft.tracer.Start("file.write", SpanInternal, message.traceContext())
+	span.SetAttribute("file.directory", ft.outboxDir)
+	err := ft.write(message)
+	span.Finish(err)
+	return err
+}
+
+func (ft *FileTransport) write(message *UniversalMessage) error {
+	if ft.Serializer != nil && ft.Serializer.ContentType() != JSONContentType {
+		return ft.sendEncoded(message)
+	}
+
+	outgoingPath := filepath.Join(ft.outboxDir, message.ID+".json")
+	file, err := os.OpenFile(outgoingPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = message.WriteJSON(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sendEncoded writes the message with a custom serializer, named with the
+// extension it was registered under
+func (ft *FileTransport) sendEncoded(message *UniversalMessage) error {
+	contentType := ft.Serializer.ContentType()
+	extension, ok := serializerExtension(contentType)
+	if !ok {
+		return fmt.Errorf("serializer %s is not registered", contentType)
+	}
+	data, err := ft.Serializer.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s as %s: %v", message.ID, contentType, err)
+	}
+	return ioutil.WriteFile(filepath.Join(ft.outboxDir, message.ID+extension), data, 0644)
+}
+
+// StopReceiving stops the file watcher and waits for it to deliver its last
+// claimed batch. Files delivered earlier can still be acked until Close.
+func (ft *FileTransport) StopReceiving() {
+	ft.stopOnce.Do(func() { close(ft.stop) })
+	ft.ackMu.Lock()
+	running := ft.running
+	ft.ackMu.Unlock()
+	if running {
+		<-ft.stopped
+	}
+}
+
+// Close stops receiving and moves every acked file to processed/. Files
+// acked after Close are moved at once.
+func (ft *FileTransport) Close() error {
+	ft.StopReceiving()
+	ft.ackMu.Lock()
+	ft.closed = true
+	ft.ackMu.Unlock()
+	ft.flushAcked()
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countFiles returns how many message files dir holds
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			n++
+		}
+	}
+	return n
+}
+
+// dropMessage writes a message into the Go bridge's inbox as a peer would
+func dropMessage(t *testing.T, message *UniversalMessage) {
+	t.Helper()
+	data, err := message.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join("bridge_messages", "go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join("bridge_messages", "go", message.ID+".json"), []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileTransportCloseRecordsDrainAcks(t *testing.T) {
+	inTempDir(t)
+	var handled int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := func(*UniversalMessage) error {
+		atomic.AddInt32(&handled, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+
+	gb := NewGoBridge("")
+	gb.OnMessage(FunctionCall, handler)
+	dropMessage(t, gb.NewMessage(FunctionCall, "go", map[string]interface{}{"function_name": "slow"}, FileSystem))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message never handled")
+	}
+
+	// Close while the handler is still running; the drain acks it
+	closed := make(chan error, 1)
+	go func() { closed <- gb.Close() }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if claimed, processed := countFiles(t, "bridge_messages/go/claimed"), countFiles(t, "bridge_messages/go/processed"); claimed != 0 || processed != 1 {
+		t.Fatalf("after Close claimed=%d processed=%d, want 0 and 1", claimed, processed)
+	}
+
+	// A restart does not handle it again
+	restarted := NewGoBridge("")
+	restarted.OnMessage(FunctionCall, handler)
+	time.Sleep(100 * time.Millisecond)
+	restarted.Close()
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Fatalf("handled %d times, want once", n)
+	}
+}
+
+func TestFileTransportRecoverClaimed(t *testing.T) {
+	tests := []struct {
+		name          string
+		recover       bool
+		wantClaimed   int
+		wantDelivered int32
+	}{
+		{"cold start recovers", true, 0, 1},
+		{"handed over leaves them", false, 1, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			claimed := filepath.Join("bridge_messages", "go", "claimed")
+			if err := os.MkdirAll(claimed, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(claimed, "left.json"), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var delivered int32
+			ft := NewFileTransport("go", nil)
+			ft.RecoverClaimed = tt.recover
+			err := ft.Start(func(envelope *Envelope) {
+				atomic.AddInt32(&delivered, 1)
+				envelope.Nack(nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			ft.Close()
+
+			if n := countFiles(t, claimed); n != tt.wantClaimed {
+				t.Fatalf("claimed/ holds %d files, want %d", n, tt.wantClaimed)
+			}
+			if n := atomic.LoadInt32(&delivered); n != tt.wantDelivered {
+				t.Fatalf("delivered %d files, want %d", n, tt.wantDelivered)
+			}
+		})
+	}
+}
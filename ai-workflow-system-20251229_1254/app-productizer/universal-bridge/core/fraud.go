@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoHeldPurchase is returned for a message ID that is not held
+var ErrNoHeldPurchase = errors.New("no such held purchase")
+
+// disposableDomains are throwaway email providers held without configuring
+var disposableDomains = []string{
+	"10minutemail.com", "discard.email", "dispostable.com", "getnada.com", "guerrillamail.com",
+	"maildrop.cc", "mailinator.com", "mintemail.com", "sharklasers.com", "temp-mail.org",
+	"tempmail.com", "throwawaymail.com", "trashmail.com", "yopmail.com",
+}
+
+// FraudConfig is the -fraud file; fields left out take the defaults shown:
+//
+//	window: 1h             # how far back purchases are counted
+//	max_purchases: 3       # allowed from one IP or email pattern in the window
+//	quick_refund: 48h      # a refund this soon after its purchase is quick
+//	max_quick_refunds: 1   # a buyer with this many quick refunds is held
+//	disposable_domains:    # added to the built-in throwaway providers
+//	  - example-temp.com
+//	notify: slack          # sink told about every hold
+type FraudConfig struct {
+	Window            time.Duration `yaml:"window"`
+	MaxPurchases      int           `yaml:"max_purchases"`
+	QuickRefund       time.Duration `yaml:"quick_refund"`
+	MaxQuickRefunds   int           `yaml:"max_quick_refunds"`
+	DisposableDomains []string      `yaml:"disposable_domains"`
+	Notify            string        `yaml:"notify"`
+}
+
+// LoadFraudConfig reads the fraud heuristics' thresholds
+func LoadFraudConfig(path string) (FraudConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return FraudConfig{}, fmt.Errorf("failed to read fraud config: %v", err)
+	}
+
+	var config FraudConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return FraudConfig{}, fmt.Errorf("failed to parse fraud config %s: %v", path, err)
+	}
+	if config.Window < 0 || config.MaxPurchases < 0 || config.QuickRefund < 0 || config.MaxQuickRefunds < 0 {
+		return FraudConfig{}, fmt.Errorf("invalid fraud config %s: thresholds cannot be negative", path)
+	}
+	return config, nil
+}
+
+// HeldPurchase is a sale held back from everything that fulfils it until
+// it is reviewed
+type HeldPurchase struct {
+	ID      string            `json:"id"` // the message's
+	Email   string            `json:"email"`
+	Product string            `json:"product"`
+	Price   interface{}       `json:"price,omitempty"`
+	Reasons []string          `json:"reasons"`
+	HeldAt  time.Time         `json:"held_at"`
+	Message *UniversalMessage `json:"message"`
+}
+
+// recentPurchase is a purchase counted towards the velocity check
+type recentPurchase struct {
+	at      time.Time
+	ip      string
+	pattern string
+}
+
+// FraudGuard holds suspicious purchases before the receive hooks, sinks
+// and handlers that fulfil them: many purchases from one IP or email
+// pattern in a short while, a buyer with a history of refunding soon after
+// buying, or a disposable email address. Held purchases are persisted at
+// path and wait for the seller to release or reject them. Purchases are
+// counted in memory, so the velocity check starts afresh on restart.
+type FraudGuard struct {
+	Config FraudConfig
+
+	bridge *GoBridge
+	sales  *SalesStore
+	path   string
+
+	mu       sync.Mutex
+	recent   []recentPurchase // oldest first
+	held     map[string]*HeldPurchase
+	released map[string]bool
+}
+
+// NewFraudGuard opens the purchases held at path and checks every sale the
+// bridge receives. Register it after the script hooks, so purchases they
+// skip are not held.
+func NewFraudGuard(gb *GoBridge, sales *SalesStore, config FraudConfig, path string) (*FraudGuard, error) {
+	if config.Window == 0 {
+		config.Window = time.Hour
+	}
+	if config.MaxPurchases == 0 {
+		config.MaxPurchases = 3
+	}
+	if config.QuickRefund == 0 {
+		config.QuickRefund = 48 * time.Hour
+	}
+	if config.MaxQuickRefunds == 0 {
+		config.MaxQuickRefunds = 1
+	}
+	fg := &FraudGuard{
+		Config:   config,
+		bridge:   gb,
+		sales:    sales,
+		path:     path,
+		held:     make(map[string]*HeldPurchase),
+		released: make(map[string]bool),
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read held purchases: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &fg.held)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse held purchases %s: %v", path, err)
+		}
+	}
+	gb.OnFilter(fg.filter)
+	return fg, nil
+}
+
+// filter lets through everything but suspicious sales, which it holds
+func (fg *FraudGuard) filter(message *UniversalMessage) bool {
+	if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+		return true
+	}
+
+	fg.mu.Lock()
+	if fg.released[message.ID] {
+		delete(fg.released, message.ID)
+		fg.mu.Unlock()
+		return true
+	}
+	reasons := fg.checkLocked(message.Payload)
+	if len(reasons) == 0 {
+		fg.mu.Unlock()
+		return true
+	}
+	purchase := &HeldPurchase{
+		ID:      message.ID,
+		Email:   NormalizeEmail(stringArg(message.Payload, "email")),
+		Product: productKey(message.Payload),
+		Price:   message.Payload["price"],
+		Reasons: reasons,
+		HeldAt:  fg.bridge.clock.Now().UTC(),
+		Message: message,
+	}
+	fg.held[message.ID] = purchase
+	err := fg.saveLocked()
+	fg.mu.Unlock()
+
+	if err != nil {
+		// Better fulfilled than lost on a restart
+		log.Printf("❌ Not holding sale %s, as it could not be saved: %v", message.ID, err)
+		fg.mu.Lock()
+		delete(fg.held, message.ID)
+		fg.mu.Unlock()
+		return true
+	}
+	fmt.Printf("🚩 Holding sale %s from %s for review: %s\n", message.ID, purchase.Email, strings.Join(reasons, "; "))
+	fg.notify(purchase)
+	return false
+}
+
+// checkLocked counts the purchase and returns why it is suspicious, if it is
+func (fg *FraudGuard) checkLocked(payload map[string]interface{}) []string {
+	now := fg.bridge.clock.Now()
+	email := NormalizeEmail(stringArg(payload, "email"))
+	pattern := emailPattern(email)
+	ip := stringArg(payload, "ip_address")
+
+	cutoff := now.Add(-fg.Config.Window)
+	drop := 0
+	for drop < len(fg.recent) && !fg.recent[drop].at.After(cutoff) {
+		drop++
+	}
+	fg.recent = fg.recent[drop:]
+	fromIP, fromPattern := 0, 0
+	for _, purchase := range fg.recent {
+		if ip != "" && purchase.ip == ip {
+			fromIP++
+		}
+		if pattern != "" && purchase.pattern == pattern {
+			fromPattern++
+		}
+	}
+	fg.recent = append(fg.recent, recentPurchase{at: now, ip: ip, pattern: pattern})
+
+	var reasons []string
+	if fromIP >= fg.Config.MaxPurchases {
+		reasons = append(reasons, fmt.Sprintf("%d earlier purchases from IP %s within %s", fromIP, ip, fg.Config.Window))
+	}
+	if fromPattern >= fg.Config.MaxPurchases {
+		reasons = append(reasons, fmt.Sprintf("%d earlier purchases from addresses like %s within %s", fromPattern, pattern, fg.Config.Window))
+	}
+	if quick := fg.quickRefunds(pattern); quick >= fg.Config.MaxQuickRefunds {
+		reasons = append(reasons, fmt.Sprintf("%d earlier purchases refunded within %s of being made", quick, fg.Config.QuickRefund))
+	}
+	if domain := domainOf(email); email != "" && fg.disposable(domain) {
+		reasons = append(reasons, fmt.Sprintf("disposable email domain %s", domain))
+	}
+	return reasons
+}
+
+// quickRefunds counts the sales to addresses like pattern that were
+// refunded soon after they were made
+func (fg *FraudGuard) quickRefunds(pattern string) int {
+	if pattern == "" || fg.sales == nil {
+		return 0
+	}
+	quick := 0
+	for _, sale := range fg.sales.Sales(func(sale Sale) bool { return emailPattern(sale.Email) == pattern }) {
+		if _, at, ok := refundOf(sale); ok && !sale.CreatedAt.IsZero() && at.Sub(sale.CreatedAt) <= fg.Config.QuickRefund {
+			quick++
+		}
+	}
+	return quick
+}
+
+// disposable reports whether the domain, or one it is under, is a
+// throwaway provider
+func (fg *FraudGuard) disposable(domain string) bool {
+	for _, list := range [][]string{disposableDomains, fg.Config.DisposableDomains} {
+		for _, throwaway := range list {
+			throwaway = strings.ToLower(strings.TrimSpace(throwaway))
+			if domain == throwaway || strings.HasSuffix(domain, "."+throwaway) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emailPattern is the mailbox an address delivers to, with +tags dropped
+// and, for Gmail, which ignores them, dots; "A.Da+1@googlemail.com" and
+// "ada@gmail.com" share a pattern
+func emailPattern(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return email
+	}
+	local, domain := strings.ToLower(email[:at]), strings.ToLower(email[at+1:])
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// notify tells the Notify sink about a hold
+func (fg *FraudGuard) notify(purchase *HeldPurchase) {
+	if fg.Config.Notify == "" {
+		return
+	}
+	sink := fg.bridge.Sink(fg.Config.Notify)
+	if sink == nil {
+		log.Printf("❌ No sink named %s to tell about held sale %s", fg.Config.Notify, purchase.ID)
+		return
+	}
+	text := fmt.Sprintf("🚩 Held a sale of %s to %s for review: %s", purchase.Product, purchase.Email, strings.Join(purchase.Reasons, "; "))
+	err := sink.Enqueue(fg.bridge.NewMessage(DataSync, "go", map[string]interface{}{"text": text, "held_sale": purchase.ID}, FileSystem))
+	if err != nil {
+		log.Printf("❌ Error telling %s about held sale %s: %v", fg.Config.Notify, purchase.ID, err)
+	}
+}
+
+// Held returns the purchases awaiting review, oldest first
+func (fg *FraudGuard) Held() []HeldPurchase {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	list := make([]HeldPurchase, 0, len(fg.held))
+	for _, purchase := range fg.held {
+		list = append(list, *purchase)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].HeldAt.Equal(list[j].HeldAt) {
+			return list[i].HeldAt.Before(list[j].HeldAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// take removes a held purchase for release or rejection
+func (fg *FraudGuard) take(id string) (*HeldPurchase, error) {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	purchase, ok := fg.held[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoHeldPurchase, id)
+	}
+	delete(fg.held, id)
+	err := fg.saveLocked()
+	if err != nil {
+		fg.held[id] = purchase
+		return nil, err
+	}
+	return purchase, nil
+}
+
+// Release passes a held purchase on to be recorded and fulfilled, as if
+// it had just arrived. Its receipt was sent when it was held, and having
+// been reviewed it no longer expires.
+func (fg *FraudGuard) Release(id string) (HeldPurchase, error) {
+	purchase, err := fg.take(id)
+	if err != nil {
+		return HeldPurchase{}, err
+	}
+	message := purchase.Message
+	delete(message.Headers, ackHeader)
+	message.ExpiresAt = ""
+	fg.mu.Lock()
+	fg.released[id] = true
+	fg.mu.Unlock()
+
+	fmt.Printf("✅ Releasing held sale %s\n", id)
+	err = fg.bridge.dispatchIncoming(message)
+	if err != nil {
+		return *purchase, fmt.Errorf("released sale %s failed: %v", id, err)
+	}
+	return *purchase, nil
+}
+
+// Reject drops a held purchase without fulfilling it
+func (fg *FraudGuard) Reject(id string) (HeldPurchase, error) {
+	purchase, err := fg.take(id)
+	if err != nil {
+		return HeldPurchase{}, err
+	}
+	fmt.Printf("🗑️ Rejected held sale %s from %s\n", id, purchase.Email)
+	return *purchase, nil
+}
+
+// Handler serves /fraud/held: GET lists the held purchases, and POST
+// /fraud/held/{id}/release or /fraud/held/{id}/reject reviews one
+func (fg *FraudGuard) Handler() http.Handler {
+	actions := map[string]func(string) (HeldPurchase, error){
+		"release": fg.Release,
+		"reject":  fg.Reject,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/fraud/held"), "/")
+		if rest == "" {
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"held": fg.Held()})
+			return
+		}
+
+		slash := strings.LastIndex(rest, "/")
+		action, ok := actions[rest[slash+1:]]
+		if slash < 0 || !ok {
+			writeAPIError(w, http.StatusNotFound, "use /fraud/held/{id}/release or /fraud/held/{id}/reject")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		id, err := url.PathUnescape(rest[:slash])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad held purchase id: %v", err))
+			return
+		}
+		purchase, err := action(id)
+		if errors.Is(err, ErrNoHeldPurchase) {
+			writeAPIError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil && purchase.ID == "" {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err != nil {
+			// Released, and dead-lettered for retrying like any failure
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, purchase)
+	})
+}
+
+func (fg *FraudGuard) saveLocked() error {
+	return writeJSONFile(fg.path, fg.held)
+}
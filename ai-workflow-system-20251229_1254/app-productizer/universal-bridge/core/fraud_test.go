@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailPattern(t *testing.T) {
+	tests := []struct {
+		email, want string
+	}{
+		{"ada@example.com", "ada@example.com"},
+		{"Ada+course@Example.com", "ada@example.com"},
+		{"a.da@example.com", "a.da@example.com"},
+		{"A.Da+1@googlemail.com", "ada@gmail.com"},
+		{"+ada@example.com", "+ada@example.com"},
+		{"not-an-address", "not-an-address"},
+	}
+	for _, tt := range tests {
+		if got := emailPattern(tt.email); got != tt.want {
+			t.Errorf("emailPattern(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestFraudGuard(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var notes []string
+	gb.AddSink("slack", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		if text, ok := message.Payload["text"].(string); ok {
+			notes = append(notes, text)
+		}
+		return message
+	})
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A buyer who refunded the day after buying
+	_, err = sales.Record(map[string]interface{}{"sale_id": "old", "product_id": "course", "price": 100, "currency": "usd",
+		"email": "r.efunder+old@gmail.com", "sale_timestamp": "2026-01-02T12:00:00Z"})
+	if err == nil {
+		err = sales.Refund(Refund{SaleID: "old", Amount: NewMoney(100, "usd"), RefundedAt: time.Date(2026, 1, 3, 12, 0, 0, 0, time.UTC)})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "held.json")
+	guard, err := NewFraudGuard(gb, sales, FraudConfig{MaxPurchases: 2, DisposableDomains: []string{"burner.test"}, Notify: "slack"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+
+	ids := NewSequentialIDs("gumroad")
+	tests := []struct {
+		email, ip  string
+		advance    time.Duration
+		wantHeld   bool
+		wantReason string
+	}{
+		{"ada@example.com", "10.0.0.1", 0, false, ""},
+		{"bob@mailinator.com", "10.0.0.2", 0, true, "disposable email domain mailinator.com"},
+		{"cy@mx.burner.test", "10.0.0.3", 0, true, "disposable email domain mx.burner.test"},
+		{"refunder@googlemail.com", "10.0.0.4", 0, true, "1 earlier purchases refunded within 48h0m0s"},
+		// Three from one IP, and then three to one Gmail mailbox
+		{"dee@example.com", "10.0.0.9", 0, false, ""},
+		{"eve@example.com", "10.0.0.9", 0, false, ""},
+		{"fay@example.com", "10.0.0.9", 0, true, "2 earlier purchases from IP 10.0.0.9"},
+		{"gus@gmail.com", "10.0.1.1", 0, false, ""},
+		{"g.us+2@gmail.com", "10.0.1.2", 0, false, ""},
+		{"gus+3@googlemail.com", "10.0.1.3", 0, true, "2 earlier purchases from addresses like gus@gmail.com"},
+		// A window later the IP is clean again
+		{"hal@example.com", "10.0.0.9", time.Hour, false, ""},
+	}
+	var held []string
+	saleOf := make(map[string]string)
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		message := newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", map[string]interface{}{
+			"resource_name": "sale", "product_id": "course", "price": 100, "currency": "usd",
+			"email": tt.email, "ip_address": tt.ip, "sale_id": fmt.Sprintf("sale-%d", i)}, HTTP)
+		saleOf[message.ID] = fmt.Sprintf("sale-%d", i)
+		if err := transport.Inject(message); err != nil {
+			t.Fatal(err)
+		}
+		_, recorded := sales.Sale(saleOf[message.ID])
+		if recorded == tt.wantHeld {
+			t.Errorf("sale from %s at %s: recorded %v, want held %v", tt.email, tt.ip, recorded, tt.wantHeld)
+		}
+		if tt.wantHeld {
+			held = append(held, message.ID)
+		}
+		if tt.wantReason == "" {
+			continue
+		}
+		list := guard.Held()
+		if len(list) == 0 || list[len(list)-1].ID != message.ID || !strings.Contains(strings.Join(list[len(list)-1].Reasons, "; "), tt.wantReason) {
+			t.Errorf("sale from %s: held %+v, want the reason %q", tt.email, list, tt.wantReason)
+		}
+	}
+	if len(notes) != len(held) || !strings.HasPrefix(notes[0], "🚩 Held a sale of course to bob@mailinator.com") {
+		t.Errorf("notified %q", notes)
+	}
+
+	// The holds survive a restart
+	other := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { other.Close() })
+	reopened, err := NewFraudGuard(other, sales, FraudConfig{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reopened.Held(); len(list) != len(held) || list[0].ID != held[0] || list[0].Message == nil {
+		t.Errorf("held after a restart %+v", list)
+	}
+
+	handler := guard.Handler()
+	call := func(method, target string, wantStatus int) string {
+		t.Helper()
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+		if response.Code != wantStatus {
+			t.Errorf("%s %s = %d, want %d: %s", method, target, response.Code, wantStatus, response.Body)
+		}
+		return response.Body.String()
+	}
+	var listed struct {
+		Held []HeldPurchase `json:"held"`
+	}
+	json.Unmarshal([]byte(call("GET", "/fraud/held", http.StatusOK)), &listed)
+	if len(listed.Held) != len(held) || listed.Held[0].Email != "bob@mailinator.com" {
+		t.Errorf("listed %+v", listed.Held)
+	}
+
+	// A released sale is recorded as if it had just arrived; a rejected one never is
+	call("POST", "/fraud/held/"+held[0]+"/release", http.StatusOK)
+	if _, ok := sales.Sale(saleOf[held[0]]); !ok {
+		t.Errorf("released sale %s was not recorded", held[0])
+	}
+	call("POST", "/fraud/held/"+held[0]+"/release", http.StatusNotFound)
+	call("POST", "/fraud/held/"+held[1]+"/reject", http.StatusOK)
+	if _, ok := sales.Sale(saleOf[held[1]]); ok {
+		t.Errorf("rejected sale %s was recorded", held[1])
+	}
+	call("GET", "/fraud/held/"+held[2]+"/release", http.StatusMethodNotAllowed)
+	call("POST", "/fraud/held/"+held[2]+"/refund", http.StatusNotFound)
+	if list := guard.Held(); len(list) != len(held)-2 {
+		t.Errorf("still held %+v", list)
+	}
+}
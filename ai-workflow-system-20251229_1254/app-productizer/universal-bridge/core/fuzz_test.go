@@ -0,0 +1,131 @@
+package main
+
+// Native fuzz targets. Run one with, for example:
+//
+//	go test -run '^$' -fuzz FuzzFromJSON -fuzztime 1m
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// addContractSeeds seeds the corpus with the shared contract messages
+func addContractSeeds(f *testing.F, add func(data []byte)) {
+	paths, _ := filepath.Glob(filepath.Join("..", "contract", "cases", "*.json"))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err == nil {
+			add(content)
+		}
+	}
+}
+
+func FuzzFromJSON(f *testing.F) {
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{"payload":null,"checksum":""}`))
+	f.Add([]byte(`{"payload":{"a":[1,2,{"b":null}]}}`))
+	encoded, _ := NewUniversalMessage(AIRequest, "go", "python", map[string]interface{}{"prompt": "hi"}, FileSystem).ToJSON()
+	f.Add([]byte(encoded))
+	addContractSeeds(f, func(data []byte) { f.Add(data) })
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := FromJSON(string(data))
+		if err != nil {
+			return
+		}
+
+		// Anything accepted must survive a round trip unchanged
+		out, err := msg.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON after successful FromJSON: %v", err)
+		}
+		again, err := FromJSON(out)
+		if err != nil {
+			t.Fatalf("re-decoding own output: %v", err)
+		}
+		if again.Checksum != msg.Checksum || again.ID != msg.ID {
+			t.Fatalf("round trip changed message: %+v != %+v", again, msg)
+		}
+	})
+}
+
+func FuzzFromBinary(f *testing.F) {
+	frame, _ := NewUniversalMessage(HealthCheck, "go", "python", nil, BinarySocket).ToBinary()
+	f.Add(frame)
+	f.Add([]byte{0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 2, '{', '}'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fromBytes, errBytes := FromBinary(data)
+		fromStream, errStream := ReadBinaryMessage(bytes.NewReader(data))
+
+		// Both decoders must agree on what is a valid frame
+		if (errBytes == nil) != (errStream == nil) {
+			t.Fatalf("decoders disagree: FromBinary=%v ReadBinaryMessage=%v", errBytes, errStream)
+		}
+		if errBytes != nil {
+			return
+		}
+		if fromBytes.Checksum != fromStream.Checksum {
+			t.Fatalf("decoders returned different messages")
+		}
+		if length := binary.BigEndian.Uint32(data); length > MaxBinaryFrameSize {
+			t.Fatalf("accepted oversized frame of %d bytes", length)
+		}
+	})
+}
+
+func FuzzWebhookConvert(f *testing.F) {
+	f.Add("sale_id=s1&email=a%40example.com&price=2999&currency=usd&product_id=p1")
+	f.Add("resource_name=refund&sale_id=s1&refunded=true&price=0&currency=jpy")
+	f.Add("variants%5BTier%5D=Pro&variants%5BSeats%5D=5&price=100&currency=kwd")
+	f.Add("resource_name=dispute&price=-500&currency=eur&test=false")
+	f.Add("price=1e3&currency=usd")
+	f.Add("resource_name=coupon")
+	f.Add("a[=1&[b]=2&c[]=3")
+	wh := NewGumroadWebhook(NewGoBridge("", WithTransport(NewMemoryTransport())))
+
+	f.Fuzz(func(t *testing.T, body string) {
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return
+		}
+		message, err := wh.convert(form, []byte(body))
+		if err != nil {
+			return
+		}
+		if !gumroadResources[stringArg(message.Payload, "resource_name")] {
+			t.Fatalf("accepted resource_name %v", message.Payload["resource_name"])
+		}
+
+		// Prices in cents come out in major units that read back exactly
+		if cents := form.Get("price"); cents != "" {
+			want, _ := strconv.ParseInt(cents, 10, 64)
+			price, err := moneyArg(message.Payload["price"], form.Get("currency"))
+			if err != nil || price.Amount != want {
+				t.Fatalf("price %s cents became %v (%v)", cents, message.Payload["price"], err)
+			}
+		}
+
+		// Gumroad's retries of the same ping are deduplicated by ID
+		again, _ := wh.convert(form, []byte(body))
+		if again == nil || again.ID != message.ID {
+			t.Fatalf("the same ping converted to a different message ID")
+		}
+
+		encoded, err := message.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON of a converted ping: %v", err)
+		}
+		if _, err := FromJSON(encoded); err != nil {
+			t.Fatalf("converted ping does not decode: %v", err)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Gift is who bought a gift purchase and who it is for
+type Gift struct {
+	Gifter    string
+	Recipient string
+	Note      string
+}
+
+// DetectGift reports whether a sale is a gift. Gumroad marks the recipient's
+// copy with is_gift_receiver_purchase and gifter_email, and the gifter's
+// copy with giftee_email. Sales already linked by HandleGifts carry gift.
+func DetectGift(sale map[string]interface{}) (Gift, bool) {
+	gift := Gift{Note: stringArg(sale, "gift_note")}
+	switch {
+	case sale["gift"] == true,
+		sale["is_gift_receiver_purchase"] == true || stringArg(sale, "is_gift_receiver_purchase") == "true":
+		gift.Recipient = stringArg(sale, "email")
+		gift.Gifter = stringArg(sale, "gifter_email")
+	case stringArg(sale, "giftee_email") != "":
+		gift.Gifter = stringArg(sale, "email")
+		gift.Recipient = stringArg(sale, "giftee_email")
+	default:
+		return Gift{}, false
+	}
+	gift.Gifter = NormalizeEmail(gift.Gifter)
+	gift.Recipient = NormalizeEmail(gift.Recipient)
+	return gift, gift.Recipient != ""
+}
+
+// linkGift rewrites a gift sale so the recipient owns it: email becomes the
+// recipient, so the license, portal and product updates follow them, and
+// gifter_email keeps the buyer linked to it
+func linkGift(sale map[string]interface{}, gift Gift) {
+	sale["gift"] = true
+	sale["email"] = gift.Recipient
+	sale["gifter_email"] = gift.Gifter
+}
+
+// HandleGifts links gift sales to their recipient and sends two emails
+// through the "email" sink instead of the usual receipt: gift_delivery with
+// the license and download to the recipient, and gift_receipt to the gifter.
+// Register it before RecordSales so the store sees the linked sale; rules can
+// skip gifts with a "gift not exists" condition.
+func HandleGifts(gb *GoBridge, templates *TemplateStore) {
+	gb.OnReceive(func(message *UniversalMessage) {
+		if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+			return
+		}
+		gift, ok := DetectGift(message.Payload)
+		if !ok {
+			return
+		}
+		linkGift(message.Payload, gift)
+		fmt.Printf("🎁 Gift of %s from %s to %s\n", stringArg(message.Payload, "product_name"), gift.Gifter, gift.Recipient)
+
+		// A gifted bundle sends one receipt for the bundle and one
+		// delivery per component, each with its own license
+		if !isBundleSale(message.Payload) {
+			err := sendGiftEmail(gb, templates, "gift_delivery", gift.Recipient, message.Payload)
+			if err != nil {
+				log.Printf("❌ Error sending gift to %s: %v", gift.Recipient, err)
+			}
+		}
+		if gift.Gifter == "" || stringArg(message.Payload, "bundle_sale_id") != "" {
+			return
+		}
+		err := sendGiftEmail(gb, templates, "gift_receipt", gift.Gifter, message.Payload)
+		if err != nil {
+			log.Printf("❌ Error sending gift receipt to %s: %v", gift.Gifter, err)
+		}
+	})
+}
+
+func sendGiftEmail(gb *GoBridge, templates *TemplateStore, name, to string, sale map[string]interface{}) error {
+	sink := gb.Sink("email")
+	if sink == nil {
+		return fmt.Errorf("no email sink")
+	}
+
+	// Receipts show the price as Money formats it; a sale that does not
+	// validate is rendered as received and rejected by RecordSales
+	data := sale
+	if parsed, err := ParseSale(sale); err == nil {
+		data = parsed.TemplateData()
+	}
+	locale := DetectLocale(sale)
+	text, err := templates.RenderLocale(name, stringArg(sale, "product"), locale, data)
+	if err != nil {
+		return err
+	}
+	message := gb.NewMessage(DataSync, "go", mergePayload(sale, map[string]interface{}{
+		"to":     to,
+		"text":   text,
+		"locale": locale,
+	}), FileSystem)
+	return sink.Enqueue(message)
+}
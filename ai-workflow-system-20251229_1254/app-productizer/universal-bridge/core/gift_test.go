@@ -0,0 +1,111 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDetectGift(t *testing.T) {
+	tests := []struct {
+		name   string
+		sale   map[string]interface{}
+		want   Gift
+		wantOK bool
+	}{
+		{"recipient's copy", map[string]interface{}{"is_gift_receiver_purchase": true, "email": "Bo@example.com", "gifter_email": "Ada@example.com", "gift_note": "Enjoy"},
+			Gift{Gifter: "ada@example.com", Recipient: "bo@example.com", Note: "Enjoy"}, true},
+		{"recipient's copy from a form ping", map[string]interface{}{"is_gift_receiver_purchase": "true", "email": "bo@example.com", "gifter_email": "ada@example.com"},
+			Gift{Gifter: "ada@example.com", Recipient: "bo@example.com"}, true},
+		{"gifter's copy", map[string]interface{}{"email": "ada@example.com", "giftee_email": "bo@example.com"},
+			Gift{Gifter: "ada@example.com", Recipient: "bo@example.com"}, true},
+		{"already linked", map[string]interface{}{"gift": true, "email": "bo@example.com", "gifter_email": "ada@example.com"},
+			Gift{Gifter: "ada@example.com", Recipient: "bo@example.com"}, true},
+		{"not a gift", map[string]interface{}{"email": "ada@example.com", "is_gift_receiver_purchase": false}, Gift{}, false},
+		{"no recipient", map[string]interface{}{"is_gift_receiver_purchase": true, "gifter_email": "ada@example.com"}, Gift{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := DetectGift(tt.sale)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("%s: DetectGift = %+v, %t, want %+v, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHandleGifts(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	catalog := &BundleCatalog{}
+	if err := yaml.Unmarshal([]byte("bundles:\n  kit:\n    components: [{product: icons}, {product: fonts}]\n"), catalog); err != nil {
+		t.Fatal(err)
+	}
+	DecomposeBundles(gb, catalog)
+	HandleGifts(gb, NewTemplateStore("templates"))
+	sales, err := NewSalesStore(filepath.Join(t.TempDir(), "sales.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	type email struct{ to, subject, product string }
+	var emails []email
+	gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		text := stringArg(message.Payload, "text")
+		emails = append(emails, email{stringArg(message.Payload, "to"), strings.SplitN(text, "\n", 2)[0], stringArg(message.Payload, "product_id")})
+		return message
+	})
+
+	ids := NewSequentialIDs("gumroad")
+	sale := func(payload map[string]interface{}) {
+		t.Helper()
+		payload["resource_name"] = "sale"
+		payload["price"] = "25"
+		payload["currency"] = "usd"
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sale(map[string]interface{}{"sale_id": "s1", "product_id": "ebook", "product_name": "The Ebook", "email": "ada@example.com"})
+	if len(emails) != 0 {
+		t.Fatalf("emailed %v about a sale that is no gift", emails)
+	}
+
+	sale(map[string]interface{}{"sale_id": "s2", "product_id": "ebook", "product_name": "The Ebook", "email": "ada@example.com", "giftee_email": "Bo@example.com",
+		"gift_note": "Happy birthday", "license_key": "KEY-2"})
+	want := []email{
+		{"bo@example.com", "Subject: ada@example.com sent you The Ebook", "ebook"},
+		{"ada@example.com", "Subject: Your gift receipt for The Ebook", "ebook"},
+	}
+	if len(emails) != 2 || emails[0] != want[0] || emails[1] != want[1] {
+		t.Fatalf("emailed %+v, want %+v", emails, want)
+	}
+	if stored, ok := sales.Sale("s2"); !ok || stored.Email != "bo@example.com" || stored.GifterEmail != "ada@example.com" || !stored.Gift {
+		t.Errorf("stored %+v, want the sale owned by the recipient", stored)
+	}
+	if gifts := sales.GiftsFrom("ada@example.com"); len(gifts) != 1 || gifts[0].ID != "s2" {
+		t.Errorf("gifts from ada %+v, want s2", gifts)
+	}
+
+	// A gifted bundle delivers each component and sends one receipt
+	emails = nil
+	sale(map[string]interface{}{"sale_id": "s3", "product_id": "kit", "product_name": "The Kit", "email": "bo@example.com", "gifter_email": "ada@example.com",
+		"is_gift_receiver_purchase": "true"})
+	want = []email{
+		{"bo@example.com", "Subject: ada@example.com sent you icons", "icons"},
+		{"bo@example.com", "Subject: ada@example.com sent you fonts", "fonts"},
+		{"ada@example.com", "Subject: Your gift receipt for The Kit", "kit"},
+	}
+	if len(emails) != len(want) {
+		t.Fatalf("emailed %+v, want %+v", emails, want)
+	}
+	for i := range want {
+		if emails[i] != want[i] {
+			t.Errorf("email %d %+v, want %+v", i, emails[i], want[i])
+		}
+	}
+}
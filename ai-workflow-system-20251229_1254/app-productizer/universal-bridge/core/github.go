@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	githubAPIURL   = "https://api.github.com"
+	githubTokenEnv = "GITHUB_TOKEN"
+)
+
+// GitHub grant statuses. A grant is pending until its invitation has gone
+// out; the buyer still has to accept it on GitHub.
+const (
+	GitHubPending = "pending"
+	GitHubGranted = "granted"
+	GitHubRevoked = "revoked"
+)
+
+var githubUsername = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,37}[A-Za-z0-9])?$`)
+
+// GitHubTarget is what buying a product gives access to: a repository the
+// buyer is invited to as a collaborator, or an organization team
+type GitHubTarget struct {
+	Repo string `yaml:"repo"` // owner/name
+	// Permission is the collaborator's role on Repo; it defaults to pull
+	Permission string `yaml:"permission"`
+	Org        string `yaml:"org"`
+	Team       string `yaml:"team"` // the team's slug
+}
+
+// String names the target in logs
+func (t GitHubTarget) String() string {
+	if t.Repo != "" {
+		return t.Repo
+	}
+	return t.Org + "/" + t.Team
+}
+
+// GitHubConfig says which products give source code access, loaded from
+// YAML:
+//
+//	username_field: GitHub username   # the checkout custom field; the default
+//	products:
+//	  source-kit:                     # product ID or permalink
+//	    repo: acme/source-kit
+//	    permission: pull
+//	  pro-bundle:
+//	    org: acme
+//	    team: pro-customers
+type GitHubConfig struct {
+	UsernameField string                  `yaml:"username_field"`
+	Products      map[string]GitHubTarget `yaml:"products"`
+}
+
+// LoadGitHubConfig reads the product → repository or team table
+func LoadGitHubConfig(path string) (GitHubConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return GitHubConfig{}, fmt.Errorf("failed to read github config: %v", err)
+	}
+
+	var config GitHubConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return GitHubConfig{}, fmt.Errorf("failed to parse github config %s: %v", path, err)
+	}
+	if config.UsernameField == "" {
+		config.UsernameField = "GitHub username"
+	}
+	if len(config.Products) == 0 {
+		return GitHubConfig{}, fmt.Errorf("invalid github config %s: no products", path)
+	}
+	for product, target := range config.Products {
+		switch {
+		case target.Repo != "" && (target.Org != "" || target.Team != ""):
+			return GitHubConfig{}, fmt.Errorf("invalid github config %s: %s has both a repo and a team", path, product)
+		case target.Repo != "":
+			if strings.Count(target.Repo, "/") != 1 || strings.HasPrefix(target.Repo, "/") || strings.HasSuffix(target.Repo, "/") {
+				return GitHubConfig{}, fmt.Errorf("invalid github config %s: %s repo %q is not owner/name", path, product, target.Repo)
+			}
+			if target.Permission == "" {
+				target.Permission = "pull"
+			}
+		case target.Org == "" || target.Team == "":
+			return GitHubConfig{}, fmt.Errorf("invalid github config %s: %s needs a repo, or an org and team", path, product)
+		}
+		config.Products[product] = target
+	}
+	return config, nil
+}
+
+// githubUsernameOf reads the buyer's GitHub username from the sale's custom
+// field, accepting "@name" and profile URLs. Gumroad sends custom fields in
+// custom_fields or, on older pings, as top-level fields.
+func githubUsernameOf(payload map[string]interface{}, field string) (string, error) {
+	value := stringArg(mapArg(payload, "custom_fields"), field)
+	if value == "" {
+		value = stringArg(payload, field)
+	}
+	if value == "" {
+		for key := range payload {
+			if strings.EqualFold(key, field) {
+				value = stringArg(payload, key)
+				break
+			}
+		}
+	}
+
+	username := strings.TrimSpace(value)
+	for _, prefix := range []string{"https://", "http://", "www.", "github.com/", "@"} {
+		username = strings.TrimPrefix(username, prefix)
+	}
+	username = strings.TrimSuffix(username, "/")
+	if username == "" {
+		return "", fmt.Errorf("no %q custom field", field)
+	}
+	if !githubUsername.MatchString(username) || strings.Contains(username, "--") {
+		return "", fmt.Errorf("%q is not a GitHub username", value)
+	}
+	return username, nil
+}
+
+// GitHubGrant is the access one sale gave
+type GitHubGrant struct {
+	SaleID       string       `json:"sale_id"`
+	Product      string       `json:"product"`
+	Email        string       `json:"email"`
+	Username     string       `json:"username"`
+	Target       GitHubTarget `json:"target"`
+	Status       string       `json:"status"`
+	InvitationID int64        `json:"invitation_id,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	GrantedAt    time.Time    `json:"granted_at"`
+	RevokedAt    *time.Time   `json:"revoked_at,omitempty"`
+}
+
+// GitHubAccess invites buyers of source code access products to a private
+// repository or organization team, using the GitHub username they gave at
+// checkout, and removes them again when the sale is fully refunded. Calls
+// to GitHub go through the "github" sink, one at a time and in order, so an
+// invite and its revocation cannot cross and outages are retried behind
+// its circuit breaker; calls still outstanding at shutdown are made again
+// on the next start. Access a buyer has through another sale of the same
+// product is kept.
+type GitHubAccess struct {
+	Config GitHubConfig
+	Token  string
+	// APIURL defaults to the public API
+	APIURL     string
+	HTTPClient *http.Client
+
+	bridge *GoBridge
+	clock  Clock
+	sink   *Sink
+	path   string
+
+	mu     sync.Mutex
+	grants map[string]*GitHubGrant // sale ID → grant
+}
+
+// NewGitHubAccess grants and revokes access for the bridge's sales with the
+// token, which needs admin rights on the repositories or teams. Grants are
+// persisted at path.
+func NewGitHubAccess(gb *GoBridge, config GitHubConfig, token, path string) (*GitHubAccess, error) {
+	if token == "" {
+		return nil, fmt.Errorf("GitHub access needs %s", githubTokenEnv)
+	}
+	ga := &GitHubAccess{
+		Config:     config,
+		Token:      token,
+		APIURL:     githubAPIURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		bridge:     gb,
+		clock:      gb.clock,
+		path:       path,
+		grants:     make(map[string]*GitHubGrant),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read GitHub grants: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &ga.grants)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse GitHub grants %s: %v", path, err)
+		}
+	}
+
+	ga.sink = gb.AddSink("github", SinkConfig{}, ga.deliver)
+	gb.OnReceive(ga.handleMessage)
+
+	// Calls that had not gone through when the bridge last stopped
+	for _, grant := range ga.Grants() {
+		switch {
+		case grant.RevokedAt != nil && grant.Status != GitHubRevoked:
+			ga.enqueue("revoke", grant.SaleID)
+		case grant.RevokedAt == nil && grant.Status == GitHubPending:
+			ga.enqueue("grant", grant.SaleID)
+		}
+	}
+	return ga, nil
+}
+
+func (ga *GitHubAccess) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+	switch stringArg(message.Payload, "resource_name") {
+	case "sale":
+		sale, err := ParseSale(message.Payload)
+		if err != nil {
+			return
+		}
+		if sale.Refunded {
+			ga.revoke(sale.ID)
+			return
+		}
+		ga.grant(sale)
+	case "refund":
+		refund, err := ParseRefund(message.Payload, ga.clock.Now())
+		if err != nil || refund.Partial {
+			return
+		}
+		ga.revoke(refund.SaleID)
+	}
+}
+
+// targetFor returns the access a product gives, matching by ID or permalink
+func (ga *GitHubAccess) targetFor(sale Sale) (GitHubTarget, bool) {
+	if target, ok := ga.Config.Products[sale.Product]; ok {
+		return target, true
+	}
+	target, ok := ga.Config.Products[sale.Permalink]
+	return target, ok && sale.Permalink != ""
+}
+
+// grant records a new grant for a sale of a configured product and queues
+// the invitation
+func (ga *GitHubAccess) grant(sale Sale) {
+	target, ok := ga.targetFor(sale)
+	if !ok {
+		return
+	}
+	username, err := githubUsernameOf(sale.Fields, ga.Config.UsernameField)
+	if err != nil {
+		log.Printf("❌ Not granting %s access for sale %s: %v", target, sale.ID, err)
+		return
+	}
+
+	ga.mu.Lock()
+	if _, known := ga.grants[sale.ID]; known {
+		ga.mu.Unlock()
+		return
+	}
+	ga.grants[sale.ID] = &GitHubGrant{
+		SaleID:    sale.ID,
+		Product:   sale.Product,
+		Email:     sale.Email,
+		Username:  username,
+		Target:    target,
+		Status:    GitHubPending,
+		GrantedAt: ga.clock.Now(),
+	}
+	ga.mu.Unlock()
+	ga.save()
+	ga.enqueue("grant", sale.ID)
+}
+
+// revoke queues the removal of a sale's access, if it gave any
+func (ga *GitHubAccess) revoke(saleID string) {
+	ga.mu.Lock()
+	grant, ok := ga.grants[saleID]
+	if !ok || grant.RevokedAt != nil {
+		ga.mu.Unlock()
+		return
+	}
+	now := ga.clock.Now()
+	grant.RevokedAt = &now
+	ga.mu.Unlock()
+	ga.save()
+	ga.enqueue("revoke", saleID)
+}
+
+// enqueue queues a GitHub call on the github sink
+func (ga *GitHubAccess) enqueue(action, saleID string) {
+	message := ga.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+		"action":  action,
+		"sale_id": saleID,
+	}, FileSystem)
+	err := ga.sink.Enqueue(message)
+	if err != nil {
+		log.Printf("❌ Error queueing GitHub %s for sale %s: %v", action, saleID, err)
+	}
+}
+
+// Grants returns every grant, oldest first
+func (ga *GitHubAccess) Grants() []GitHubGrant {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	grants := make([]GitHubGrant, 0, len(ga.grants))
+	for _, grant := range ga.grants {
+		grants = append(grants, *grant)
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].GrantedAt.Before(grants[j].GrantedAt) })
+	return grants
+}
+
+func (ga *GitHubAccess) save() {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	err := writeJSONFile(ga.path, ga.grants)
+	if err != nil {
+		log.Printf("❌ Error saving GitHub grants: %v", err)
+	}
+}
+
+// deliver makes one queued grant or revocation
+func (ga *GitHubAccess) deliver(message *UniversalMessage) error {
+	action := stringArg(message.Payload, "action")
+	saleID := stringArg(message.Payload, "sale_id")
+	ga.mu.Lock()
+	grant, ok := ga.grants[saleID]
+	var snapshot GitHubGrant
+	if ok {
+		snapshot = *grant
+	}
+	ga.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no GitHub grant for sale %s", saleID)
+	}
+
+	var err error
+	switch action {
+	case "grant":
+		if snapshot.RevokedAt != nil {
+			// Refunded before the invitation went out
+			return nil
+		}
+		var invitation int64
+		invitation, err = ga.invite(snapshot)
+		if err == nil {
+			ga.update(saleID, func(g *GitHubGrant) {
+				g.Status = GitHubGranted
+				g.InvitationID = invitation
+				g.Error = ""
+			})
+			fmt.Printf("🔑 Invited %s to %s for sale %s\n", snapshot.Username, snapshot.Target, saleID)
+		}
+	case "revoke":
+		if ga.stillEntitled(snapshot) {
+			fmt.Printf("🔑 Keeping %s on %s, another sale still grants it\n", snapshot.Username, snapshot.Target)
+		} else {
+			err = ga.remove(snapshot)
+		}
+		if err == nil {
+			ga.update(saleID, func(g *GitHubGrant) {
+				g.Status = GitHubRevoked
+				g.Error = ""
+			})
+			fmt.Printf("🔒 Revoked %s's access to %s for refunded sale %s\n", snapshot.Username, snapshot.Target, saleID)
+		}
+	default:
+		return fmt.Errorf("unknown GitHub action %q", action)
+	}
+	if err != nil {
+		ga.update(saleID, func(g *GitHubGrant) { g.Error = err.Error() })
+	}
+	return err
+}
+
+// update changes a grant and saves the table
+func (ga *GitHubAccess) update(saleID string, change func(*GitHubGrant)) {
+	ga.mu.Lock()
+	if grant, ok := ga.grants[saleID]; ok {
+		change(grant)
+	}
+	ga.mu.Unlock()
+	ga.save()
+}
+
+// stillEntitled reports whether another unrefunded sale gives the same
+// username the same access
+func (ga *GitHubAccess) stillEntitled(revoked GitHubGrant) bool {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	for _, grant := range ga.grants {
+		if grant.SaleID != revoked.SaleID && grant.RevokedAt == nil &&
+			strings.EqualFold(grant.Username, revoked.Username) && grant.Target == revoked.Target {
+			return true
+		}
+	}
+	return false
+}
+
+// invite adds the username to the repository or team, returning the
+// repository invitation's ID when one was created
+func (ga *GitHubAccess) invite(grant GitHubGrant) (int64, error) {
+	username := url.PathEscape(grant.Username)
+	target := grant.Target
+	if target.Repo != "" {
+		var invitation struct {
+			ID int64 `json:"id"`
+		}
+		status, err := ga.call(http.MethodPut, "/repos/"+target.Repo+"/collaborators/"+username,
+			map[string]string{"permission": target.Permission}, &invitation)
+		if err != nil {
+			return 0, err
+		}
+		if status == http.StatusNoContent {
+			// Already a collaborator
+			return 0, nil
+		}
+		return invitation.ID, nil
+	}
+	_, err := ga.call(http.MethodPut, "/orgs/"+url.PathEscape(target.Org)+"/teams/"+url.PathEscape(target.Team)+"/memberships/"+username,
+		map[string]string{"role": "member"}, nil)
+	return 0, err
+}
+
+// remove takes the username off the repository or team, withdrawing an
+// invitation not yet accepted. Access that is already gone is not an error.
+func (ga *GitHubAccess) remove(grant GitHubGrant) error {
+	username := url.PathEscape(grant.Username)
+	target := grant.Target
+	if target.Repo == "" {
+		_, err := ga.call(http.MethodDelete, "/orgs/"+url.PathEscape(target.Org)+"/teams/"+url.PathEscape(target.Team)+"/memberships/"+username, nil, nil)
+		return err
+	}
+	if grant.InvitationID != 0 {
+		_, err := ga.call(http.MethodDelete, fmt.Sprintf("/repos/%s/invitations/%d", target.Repo, grant.InvitationID), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := ga.call(http.MethodDelete, "/repos/"+target.Repo+"/collaborators/"+username, nil, nil)
+	return err
+}
+
+// call makes a GitHub REST request, decoding a JSON response into out. A
+// 404 on DELETE counts as success, since what it would remove is gone.
+func (ga *GitHubAccess) call(method, path string, body interface{}, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(ga.APIURL, "/")+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	request.Header.Set("Authorization", "Bearer "+ga.Token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := ga.HTTPClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("GitHub %s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return response.StatusCode, fmt.Errorf("failed to read GitHub response: %v", err)
+	}
+	if method == http.MethodDelete && response.StatusCode == http.StatusNotFound {
+		return response.StatusCode, nil
+	}
+	if response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("GitHub %s %s returned %s: %s", method, path, response.Status, strings.TrimSpace(string(content)))
+	}
+	if out != nil && len(content) > 0 {
+		err = json.Unmarshal(content, out)
+		if err != nil {
+			return response.StatusCode, fmt.Errorf("bad GitHub %s %s response: %v", method, path, err)
+		}
+	}
+	return response.StatusCode, nil
+}
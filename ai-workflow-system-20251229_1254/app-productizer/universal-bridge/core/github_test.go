@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHub answers the collaborator, invitation and team membership calls
+// and records them
+type fakeGitHub struct {
+	mu       sync.Mutex
+	calls    []string
+	failures int // calls to answer 502 before succeeding
+}
+
+func (fg *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer gh-token" || r.Header.Get("Accept") != "application/vnd.github+json" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if fg.failures > 0 {
+		fg.failures--
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	call := r.Method + " " + r.URL.Path
+	if len(body) > 0 {
+		call += " " + string(body)
+	}
+	fg.calls = append(fg.calls, call)
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/repos/"):
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id": %d}`, 40+len(fg.calls))
+	case r.Method == http.MethodDelete && strings.Contains(r.URL.Path, "/collaborators/"):
+		w.WriteHeader(http.StatusNotFound) // the invitation was never accepted
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Write([]byte(`{"state": "pending"}`))
+	}
+}
+
+func (fg *fakeGitHub) recorded() []string {
+	fg.mu.Lock()
+	defer fg.mu.Unlock()
+	return append([]string(nil), fg.calls...)
+}
+
+func TestGitHubAccess(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "github.yaml")
+	err := ioutil.WriteFile(configPath, []byte("products:\n  kit:\n    repo: acme/kit\n  pro:\n    org: acme\n    team: pro-customers\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadGitHubConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	github := &fakeGitHub{failures: 1}
+	server := httptest.NewServer(github)
+	t.Cleanup(server.Close)
+	grantsPath := filepath.Join(dir, "github.json")
+	ga, err := NewGitHubAccess(gb, config, "gh-token", grantsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ga.APIURL = server.URL
+
+	ids := NewSequentialIDs("gumroad")
+	event := func(payload map[string]interface{}) {
+		t.Helper()
+		payload["currency"] = "usd"
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// waitSettled waits for every grant to leave pending and every
+	// revocation to go through, then returns the grants by sale
+	waitSettled := func() map[string]GitHubGrant {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			grants := make(map[string]GitHubGrant)
+			settled := true
+			for _, grant := range ga.Grants() {
+				grants[grant.SaleID] = grant
+				if grant.Status == GitHubPending || (grant.RevokedAt != nil && grant.Status != GitHubRevoked) {
+					settled = false
+				}
+			}
+			if settled {
+				return grants
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("grants never settled: %+v", grants)
+			}
+		}
+	}
+
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "product_permalink": "kit", "email": "ada@example.com", "price": "49",
+		"custom_fields": map[string]interface{}{"GitHub username": "@ada-l"}})
+	clock.Advance(time.Minute)
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s2", "product_permalink": "pro", "email": "bob@example.com", "price": "99",
+		"GitHub Username": "https://github.com/bob/"})
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s3", "product_permalink": "kit", "email": "cy@example.com", "price": "49",
+		"custom_fields": map[string]interface{}{"GitHub username": "not a user!"}})
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s4", "product_permalink": "ebook", "email": "ada@example.com", "price": "9",
+		"custom_fields": map[string]interface{}{"GitHub username": "ada-l"}})
+	clock.Advance(time.Minute)
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s5", "product_permalink": "kit", "email": "ada@example.com", "price": "49",
+		"custom_fields": map[string]interface{}{"GitHub username": "ada-l"}})
+
+	grants := waitSettled()
+	if len(grants) != 3 {
+		t.Fatalf("grants %+v, want s1, s2 and s5 alone", grants)
+	}
+	if g := grants["s1"]; g.Status != GitHubGranted || g.Username != "ada-l" || g.InvitationID != 41 || g.Error != "" {
+		t.Errorf("s1 grant %+v, want ada-l invited after the retried 502", g)
+	}
+	if g := grants["s2"]; g.Status != GitHubGranted || g.Username != "bob" || g.Target.Team != "pro-customers" {
+		t.Errorf("s2 grant %+v, want bob on the team", g)
+	}
+	want := []string{
+		`PUT /repos/acme/kit/collaborators/ada-l {"permission":"pull"}`,
+		`PUT /orgs/acme/teams/pro-customers/memberships/bob {"role":"member"}`,
+		`PUT /repos/acme/kit/collaborators/ada-l {"permission":"pull"}`,
+	}
+	if calls := github.recorded(); strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GitHub got\n%s\nwant\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Refunding one of ada's two kit sales keeps her access; refunding the
+	// other takes it away. A partial refund of bob's sale keeps his.
+	event(map[string]interface{}{"resource_name": "refund", "sale_id": "s1", "product_permalink": "kit", "price": "49"})
+	event(map[string]interface{}{"resource_name": "refund", "sale_id": "s2", "product_permalink": "pro", "price": "99", "amount_refunded_in_cents": 1000})
+	waitSettled()
+	event(map[string]interface{}{"resource_name": "sale", "sale_id": "s5", "product_permalink": "kit", "email": "ada@example.com", "price": "49", "refunded": true})
+	grants = waitSettled()
+	if grants["s1"].Status != GitHubRevoked || grants["s5"].Status != GitHubRevoked || grants["s2"].Status != GitHubGranted {
+		t.Errorf("grants %+v, want s1 and s5 revoked and s2 kept", grants)
+	}
+	want = append(want,
+		"DELETE /repos/acme/kit/invitations/43",
+		"DELETE /repos/acme/kit/collaborators/ada-l",
+	)
+	if calls := github.recorded(); strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GitHub got\n%s\nwant\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Grants survive a restart, with nothing left to call
+	other := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { other.Close() })
+	reopened, err := NewGitHubAccess(other, config, "gh-token", grantsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Grants(); len(got) != 3 || got[0].SaleID != "s1" || got[0].Status != GitHubRevoked {
+		t.Errorf("grants after a restart %+v", got)
+	}
+}
+
+func TestGitHubUsernameOf(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"ada-l", "ada-l", false},
+		{" @ada-l ", "ada-l", false},
+		{"https://github.com/ada-l/", "ada-l", false},
+		{"www.github.com/ada-l", "ada-l", false},
+		{"", "", true},
+		{"-ada", "", true},
+		{"ada--l", "", true},
+		{"ada l", "", true},
+		{strings.Repeat("a", 40), "", true},
+	}
+	for _, tt := range tests {
+		got, err := githubUsernameOf(map[string]interface{}{"custom_fields": map[string]interface{}{"GitHub username": tt.value}}, "GitHub username")
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("githubUsernameOf(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+// Golden encodings of representative messages. Other language bridges decode
+// these same files, so any byte change here is a wire-format change. Every
+// registered Serializer must have a format here; the tree registers JSON,
+// the protobuf of contract/universal_bridge.proto and MessagePack, plus the
+// length-prefixed binary framing of ToBinary. There is no CBOR serializer,
+// so there are no CBOR goldens. Regenerate intentionally with:
+//
+//	go test -run TestGolden -update
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/golden")
+
+// goldenFormat is one serialization format under golden test.
+// contentType is the Serializer it covers, if any.
+type goldenFormat struct {
+	ext         string
+	contentType string
+	encode      func(*UniversalMessage) ([]byte, error)
+	decode      func([]byte) (*UniversalMessage, error)
+}
+
+var goldenFormats = map[string]goldenFormat{
+	"json": {
+		ext:         ".json",
+		contentType: JSONContentType,
+		encode: func(m *UniversalMessage) ([]byte, error) {
+			s, err := m.ToJSON()
+			return []byte(s), err
+		},
+		decode: func(data []byte) (*UniversalMessage, error) { return FromJSON(string(data)) },
+	},
+	"protobuf": {
+		ext:         ".pb",
+		contentType: ProtobufContentType,
+		encode:      ProtobufSerializer{}.Marshal,
+		decode:      ProtobufSerializer{}.Unmarshal,
+	},
+	"msgpack": {
+		ext:         ".msgpack",
+		contentType: MsgpackContentType,
+		encode:      MsgpackSerializer{}.Marshal,
+		decode:      MsgpackSerializer{}.Unmarshal,
+	},
+	"binary": {
+		ext:    ".bin",
+		encode: func(m *UniversalMessage) ([]byte, error) { return m.ToBinary() },
+		decode: FromBinary,
+	},
+}
+
+// goldenMessages builds the representative messages with a fixed clock and IDs
+func goldenMessages() map[string]*UniversalMessage {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	ids := NewSequentialIDs("golden")
+	build := func(messageType MessageType, target string, payload map[string]interface{}, channel CommunicationChannel) *UniversalMessage {
+		clock.Advance(time.Second)
+		return newUniversalMessage(clock, ids, messageType, "go", target, payload, channel)
+	}
+
+	return map[string]*UniversalMessage{
+		"ai_request": build(AIRequest, "universal", map[string]interface{}{
+			"action":       "generate_content",
+			"prompt":       "Create a Go function that validates email addresses",
+			"instructions": "Use standard library and include error handling",
+			"context":      map[string]interface{}{"project": "App Productizer", "priority": "high"},
+		}, FileSystem),
+		"function_call": build(FunctionCall, "python", map[string]interface{}{
+			"function_name": "generate_documentation",
+			"args":          []interface{}{"func main() {}", 42.0, true, nil},
+			"kwargs":        map[string]interface{}{"format": "markdown", "include_examples": true},
+		}, HTTP),
+		"health_check": build(HealthCheck, "universal", nil, WebSocket),
+		"unicode": build(AIResponse, "javascript", map[string]interface{}{
+			"content": "Café ☕ — déjà vu",
+		}, FileSystem),
+	}
+}
+
+func TestGolden(t *testing.T) {
+	messages := goldenMessages()
+
+	for formatName, format := range goldenFormats {
+		for name, msg := range messages {
+			formatName, format, name, msg := formatName, format, name, msg
+			t.Run(formatName+"/"+name, func(t *testing.T) {
+				path := filepath.Join("testdata", "golden", name+format.ext)
+
+				encoded, err := format.encode(msg)
+				if err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				if *updateGolden {
+					if err := ioutil.WriteFile(path, encoded, 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				golden, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatalf("reading golden file (run with -update to create): %v", err)
+				}
+				if !bytes.Equal(encoded, golden) {
+					t.Errorf("encoding of %s changed; diff against %s", name, path)
+				}
+
+				decoded, err := format.decode(golden)
+				if err != nil {
+					t.Fatalf("decode golden: %v", err)
+				}
+				if !reflect.DeepEqual(decoded, msg) {
+					t.Errorf("decoded golden message differs\n got: %+v\nwant: %+v", decoded, msg)
+				}
+			})
+		}
+	}
+}
+
+func TestGoldenCoversSerializers(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, format := range goldenFormats {
+		covered[format.contentType] = true
+	}
+	for _, contentType := range ContentTypes() {
+		if !covered[contentType] {
+			t.Errorf("serializer %s has no golden files; add it to goldenFormats", contentType)
+		}
+	}
+}
@@ -0,0 +1,1395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A small GraphQL engine: queries with variables, aliases, arguments and
+// fragments run against object types declared in Go. Mutations,
+// subscriptions, directives, interfaces and introspection are left out;
+// the schema's SDL documents the types instead.
+
+const (
+	maxGraphQLQuery = 64 << 10 // bytes of query text
+	maxGraphQLDepth = 12       // nested selection sets
+)
+
+// graphQLScalars are the leaf types; Time is an RFC 3339 string and JSON
+// any JSON value
+var graphQLScalars = map[string]string{
+	"ID":      "",
+	"String":  "",
+	"Int":     "",
+	"Float":   "",
+	"Boolean": "",
+	"Time":    "An RFC 3339 timestamp",
+	"JSON":    "Any JSON value",
+}
+
+// GraphQLType is an object type
+type GraphQLType struct {
+	Name        string
+	Description string
+	Fields      []GraphQLField
+}
+
+// GraphQLField is a field of an object type. Type is written as in SDL,
+// e.g. "[Sale!]!". A nil Resolve reads the parent's map key or struct
+// field with the field's JSON name.
+type GraphQLField struct {
+	Name        string
+	Type        string
+	Description string
+	Args        []GraphQLArg
+	Resolve     func(parent interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// GraphQLArg is an argument of a field. Resolvers get it coerced to its
+// Go type (int, float64, string, bool, time.Time or a slice of them), or
+// its Default when the query leaves it out; a missing argument with no
+// default is absent from the map.
+type GraphQLArg struct {
+	Name        string
+	Type        string
+	Default     interface{}
+	Description string
+}
+
+// GraphQLError is an error in the response, with the path of the field
+// that failed
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResult is a response: Data is nil when the request never ran
+type GraphQLResult struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLSchema is a set of object types with a query root
+type GraphQLSchema struct {
+	Query string
+	types map[string]*GraphQLType
+	order []string
+}
+
+// NewGraphQLSchema creates a schema whose query root is the type named
+// query, and checks every field's type exists
+func NewGraphQLSchema(query string, types ...*GraphQLType) (*GraphQLSchema, error) {
+	schema := &GraphQLSchema{
+		Query: query,
+		types: make(map[string]*GraphQLType),
+	}
+	for _, t := range types {
+		if _, dup := schema.types[t.Name]; dup {
+			return nil, fmt.Errorf("graphql type %s is declared twice", t.Name)
+		}
+		schema.types[t.Name] = t
+		schema.order = append(schema.order, t.Name)
+	}
+	if schema.types[query] == nil {
+		return nil, fmt.Errorf("graphql query type %s is not declared", query)
+	}
+	for _, t := range types {
+		for _, field := range t.Fields {
+			if !schema.known(gqlNamedType(field.Type)) {
+				return nil, fmt.Errorf("graphql field %s.%s has unknown type %s", t.Name, field.Name, field.Type)
+			}
+			for _, arg := range field.Args {
+				if _, scalar := graphQLScalars[gqlNamedType(arg.Type)]; !scalar {
+					return nil, fmt.Errorf("graphql argument %s.%s(%s) is not a scalar", t.Name, field.Name, arg.Name)
+				}
+			}
+		}
+	}
+	return schema, nil
+}
+
+func (s *GraphQLSchema) known(name string) bool {
+	_, scalar := graphQLScalars[name]
+	return scalar || s.types[name] != nil
+}
+
+func (t *GraphQLType) field(name string) (GraphQLField, bool) {
+	for _, field := range t.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return GraphQLField{}, false
+}
+
+// SDL writes the schema in GraphQL's schema definition language
+func (s *GraphQLSchema) SDL() string {
+	var b strings.Builder
+	description := func(indent, text string) {
+		if text != "" {
+			fmt.Fprintf(&b, "%s%s\n", indent, strconv.Quote(text))
+		}
+	}
+
+	var scalars []string
+	for name, text := range graphQLScalars {
+		if text != "" {
+			scalars = append(scalars, name)
+		}
+	}
+	sort.Strings(scalars)
+	for _, name := range scalars {
+		description("", graphQLScalars[name])
+		fmt.Fprintf(&b, "scalar %s\n\n", name)
+	}
+	fmt.Fprintf(&b, "schema {\n  query: %s\n}\n", s.Query)
+
+	for _, name := range s.order {
+		t := s.types[name]
+		b.WriteString("\n")
+		description("", t.Description)
+		fmt.Fprintf(&b, "type %s {\n", t.Name)
+		for _, field := range t.Fields {
+			description("  ", field.Description)
+			b.WriteString("  " + field.Name)
+			if len(field.Args) > 0 {
+				var args []string
+				for _, arg := range field.Args {
+					text := arg.Name + ": " + arg.Type
+					if arg.Default != nil {
+						value, _ := json.Marshal(arg.Default)
+						text += " = " + string(value)
+					}
+					args = append(args, text)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.Type + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// Execute runs a query document. variables are the decoded JSON values of
+// its variables; operationName picks an operation when the document holds
+// several.
+func (s *GraphQLSchema) Execute(query string, variables map[string]interface{}, operationName string) GraphQLResult {
+	fail := func(err error) GraphQLResult {
+		return GraphQLResult{Errors: []GraphQLError{{Message: err.Error()}}}
+	}
+	if len(query) > maxGraphQLQuery {
+		return fail(fmt.Errorf("query is over %d bytes", maxGraphQLQuery))
+	}
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return fail(err)
+	}
+	op, err := doc.operation(operationName)
+	if err != nil {
+		return fail(err)
+	}
+	ex := &gqlExecution{schema: s, fragments: doc.fragments, defined: make(map[string]bool)}
+	for _, def := range op.variables {
+		ex.defined[def.name] = true
+	}
+	ex.variables, err = op.coerceVariables(variables)
+	if err != nil {
+		return fail(err)
+	}
+	if errs := ex.validate(s.types[s.Query], op.selections, 1, nil); len(errs) > 0 {
+		result := GraphQLResult{}
+		for _, err := range errs {
+			result.Errors = append(result.Errors, GraphQLError{Message: err.Error()})
+		}
+		return result
+	}
+
+	data := ex.selectObject(s.types[s.Query], nil, op.selections, nil)
+	return GraphQLResult{Data: data, Errors: ex.errors}
+}
+
+// gqlNamedType strips the list and non-null wrappers off a type
+func gqlNamedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// gqlObject is a response object, keeping its fields in selection order
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, exists := o.values[key]; !exists {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// MarshalJSON writes the fields in order
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Lexing
+
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func lexGraphQL(src string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	isName := func(c byte, first bool) bool {
+		return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case strings.HasPrefix(src[i:], "\ufeff"):
+			i += len("\ufeff")
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.IndexByte("!$&()[]{}:=@|", c) >= 0:
+			tokens = append(tokens, gqlToken{gqlPunct, string(c), i})
+			i++
+		case c == '.':
+			if !strings.HasPrefix(src[i:], "...") {
+				return nil, fmt.Errorf("syntax error at %d: unexpected .", i)
+			}
+			tokens = append(tokens, gqlToken{gqlPunct, "...", i})
+			i += 3
+		case isName(c, true):
+			start := i
+			for i < len(src) && isName(src[i], false) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{gqlName, src[start:i], start})
+		case c == '-' || isDigit(c):
+			start, kind := i, gqlInt
+			if c == '-' {
+				i++
+			}
+			digits := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i == digits {
+				return nil, fmt.Errorf("syntax error at %d: bad number", start)
+			}
+			if i < len(src) && src[i] == '.' {
+				kind, i = gqlFloat, i+1
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind, i = gqlFloat, i+1
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (isName(src[i], true) || src[i] == '.') {
+				return nil, fmt.Errorf("syntax error at %d: bad number", start)
+			}
+			tokens = append(tokens, gqlToken{kind, src[start:i], start})
+		case strings.HasPrefix(src[i:], `"""`):
+			end := -1
+			for j := i + 3; j < len(src); {
+				k := strings.Index(src[j:], `"""`)
+				if k < 0 {
+					break
+				}
+				if k > 0 && src[j+k-1] == '\\' {
+					j += k + 3
+					continue
+				}
+				end = j + k
+				break
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("syntax error at %d: unterminated string", i)
+			}
+			text := strings.ReplaceAll(src[i+3:end], `\"""`, `"""`)
+			tokens = append(tokens, gqlToken{gqlString, gqlBlockString(text), i})
+			i = end + 3
+		case c == '"':
+			text, n, err := gqlUnquote(src[i:])
+			if err != nil {
+				return nil, fmt.Errorf("syntax error at %d: %v", i, err)
+			}
+			tokens = append(tokens, gqlToken{gqlString, text, i})
+			i += n
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, fmt.Errorf("syntax error at %d: unexpected %q", i, r)
+		}
+	}
+	return append(tokens, gqlToken{gqlEOF, "", len(src)}), nil
+}
+
+// gqlUnquote decodes the quoted string at the start of src, returning it
+// and the bytes it took
+func gqlUnquote(src string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\n', '\r':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			if i+1 >= len(src) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			i++
+			switch e := src[i]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, fmt.Errorf("bad unicode escape")
+				}
+				code, err := strconv.ParseUint(src[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("bad unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("bad escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// gqlBlockString removes a block string's common indentation and its
+// blank first and last lines
+func gqlBlockString(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = ""
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parsing
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name       string
+	variables  []gqlVariableDef
+	selections []gqlSelection
+}
+
+type gqlVariableDef struct {
+	name       string
+	typ        string
+	defaultVal interface{}
+	hasDefault bool
+}
+
+type gqlFragment struct {
+	name       string
+	on         string
+	selections []gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread set) or an inline
+// fragment (inline set, on its optional type condition)
+type gqlSelection struct {
+	alias, name string
+	args        []gqlArgument
+	selections  []gqlSelection
+	spread      string
+	inline      bool
+	on          string
+}
+
+// key is the field's name in the response
+func (sel gqlSelection) key() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+type gqlArgument struct {
+	name  string
+	value interface{}
+}
+
+// gqlVariable is a $variable in an argument value
+type gqlVariable string
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	tokens, err := lexGraphQL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &gqlParser{tokens: tokens}
+	doc := &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.peek().kind != gqlEOF {
+		token := p.peek()
+		switch {
+		case token.kind == gqlPunct && token.value == "{":
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{selections: selections})
+		case token.kind == gqlName && token.value == "query":
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case token.kind == gqlName && (token.value == "mutation" || token.value == "subscription"):
+			return nil, fmt.Errorf("%ss are not supported; the bridge only answers queries", token.value)
+		case token.kind == gqlName && token.value == "fragment":
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[fragment.name] != nil {
+				return nil, fmt.Errorf("fragment %s is defined twice", fragment.name)
+			}
+			doc.fragments[fragment.name] = fragment
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no query")
+	}
+	return doc, nil
+}
+
+// operation finds the operation to run
+func (doc *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("the document has %d queries; name one with operationName", len(doc.operations))
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("no query named %s", name)
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	token := p.tokens[p.pos]
+	if token.kind != gqlEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *gqlParser) unexpected() error {
+	token := p.peek()
+	if token.kind == gqlEOF {
+		return fmt.Errorf("syntax error: unexpected end of query")
+	}
+	return fmt.Errorf("syntax error at %d: unexpected %q", token.pos, token.value)
+}
+
+// punct consumes the punctuator if it is next
+func (p *gqlParser) punct(value string) bool {
+	if token := p.peek(); token.kind == gqlPunct && token.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(value string) error {
+	if !p.punct(value) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.peek().kind != gqlName {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func (p *gqlParser) noDirectives() error {
+	if token := p.peek(); token.kind == gqlPunct && token.value == "@" {
+		return fmt.Errorf("syntax error at %d: directives are not supported", token.pos)
+	}
+	return nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	p.next()
+	op := &gqlOperation{}
+	if p.peek().kind == gqlName {
+		op.name = p.next().value
+	}
+	if p.punct("(") {
+		for !p.punct(")") {
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			def := gqlVariableDef{}
+			var err error
+			if def.name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+			if def.typ, err = p.typeRef(); err != nil {
+				return nil, err
+			}
+			if p.punct("=") {
+				def.hasDefault = true
+				if def.defaultVal, err = p.value(true); err != nil {
+					return nil, err
+				}
+			}
+			op.variables = append(op.variables, def)
+		}
+	}
+	if err := p.noDirectives(); err != nil {
+		return nil, err
+	}
+	var err error
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	p.next()
+	fragment := &gqlFragment{}
+	var err error
+	if fragment.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if fragment.name == "on" {
+		return nil, fmt.Errorf("a fragment cannot be named on")
+	}
+	if on, err := p.name(); err != nil || on != "on" {
+		return nil, fmt.Errorf("fragment %s needs a type condition", fragment.name)
+	}
+	if fragment.on, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.noDirectives(); err != nil {
+		return nil, err
+	}
+	fragment.selections, err = p.selectionSet()
+	return fragment, err
+}
+
+func (p *gqlParser) typeRef() (string, error) {
+	var typ string
+	if p.punct("[") {
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err = p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.punct("!") {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []gqlSelection
+	for !p.punct("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("syntax error: empty selection set")
+	}
+	return selections, nil
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	var sel gqlSelection
+	var err error
+	if p.punct("...") {
+		if token := p.peek(); token.kind == gqlName && token.value != "on" {
+			sel.spread = p.next().value
+			return sel, p.noDirectives()
+		}
+		sel.inline = true
+		if token := p.peek(); token.kind == gqlName {
+			p.next()
+			if sel.on, err = p.name(); err != nil {
+				return sel, err
+			}
+		}
+		if err = p.noDirectives(); err != nil {
+			return sel, err
+		}
+		sel.selections, err = p.selectionSet()
+		return sel, err
+	}
+
+	if sel.name, err = p.name(); err != nil {
+		return sel, err
+	}
+	if p.punct(":") {
+		sel.alias = sel.name
+		if sel.name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if p.punct("(") {
+		for !p.punct(")") {
+			arg := gqlArgument{}
+			if arg.name, err = p.name(); err != nil {
+				return sel, err
+			}
+			if err = p.expect(":"); err != nil {
+				return sel, err
+			}
+			if arg.value, err = p.value(false); err != nil {
+				return sel, err
+			}
+			sel.args = append(sel.args, arg)
+		}
+	}
+	if err = p.noDirectives(); err != nil {
+		return sel, err
+	}
+	if token := p.peek(); token.kind == gqlPunct && token.value == "{" {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+// value parses a literal: int64, float64, string, bool, nil, a list, an
+// object, an enum value as its string, or a gqlVariable unless constant
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	token := p.next()
+	switch token.kind {
+	case gqlInt:
+		n, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at %d: %s is out of range", token.pos, token.value)
+		}
+		return n, nil
+	case gqlFloat:
+		f, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at %d: bad number %s", token.pos, token.value)
+		}
+		return f, nil
+	case gqlString:
+		return token.value, nil
+	case gqlName:
+		switch token.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return token.value, nil
+	case gqlPunct:
+		switch token.value {
+		case "$":
+			if constant {
+				return nil, fmt.Errorf("syntax error at %d: a default cannot use a variable", token.pos)
+			}
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.punct("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, nil
+		case "{":
+			object := make(map[string]interface{})
+			for !p.punct("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expect(":"); err != nil {
+					return nil, err
+				}
+				if object[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return object, nil
+		}
+	}
+	p.pos--
+	return nil, p.unexpected()
+}
+
+// Validation and execution
+
+type gqlExecution struct {
+	schema    *GraphQLSchema
+	fragments map[string]*gqlFragment
+	variables map[string]interface{}
+	defined   map[string]bool
+	errors    []GraphQLError
+}
+
+// coerceVariables checks the supplied variables against the operation's
+// definitions, filling in defaults
+func (op *gqlOperation) coerceVariables(supplied map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, def := range op.variables {
+		if _, scalar := graphQLScalars[gqlNamedType(def.typ)]; !scalar {
+			return nil, fmt.Errorf("variable $%s has unknown type %s", def.name, def.typ)
+		}
+		value, ok := supplied[def.name]
+		if !ok && def.hasDefault {
+			value, ok = def.defaultVal, true
+		}
+		if !ok || value == nil {
+			if strings.HasSuffix(def.typ, "!") {
+				return nil, fmt.Errorf("variable $%s of type %s is required", def.name, def.typ)
+			}
+			if ok {
+				values[def.name] = nil
+			}
+			continue
+		}
+		coerced, err := coerceGraphQLInput(def.typ, value, nil)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", def.name, err)
+		}
+		values[def.name] = coerced
+	}
+	return values, nil
+}
+
+// validate checks a selection set against its type before anything runs
+func (ex *gqlExecution) validate(t *GraphQLType, selections []gqlSelection, depth int, spreading []string) []error {
+	if depth > maxGraphQLDepth {
+		return []error{fmt.Errorf("the query nests deeper than %d levels", maxGraphQLDepth)}
+	}
+	var errs []error
+	for _, sel := range selections {
+		switch {
+		case sel.spread != "":
+			fragment := ex.fragments[sel.spread]
+			if fragment == nil {
+				errs = append(errs, fmt.Errorf("unknown fragment %s", sel.spread))
+				continue
+			}
+			for _, name := range spreading {
+				if name == sel.spread {
+					return append(errs, fmt.Errorf("fragment %s spreads itself", sel.spread))
+				}
+			}
+			if fragment.on != t.Name {
+				errs = append(errs, fmt.Errorf("fragment %s on %s cannot be spread on %s", fragment.name, fragment.on, t.Name))
+				continue
+			}
+			errs = append(errs, ex.validate(t, fragment.selections, depth, append(spreading, sel.spread))...)
+		case sel.inline:
+			if sel.on != "" && sel.on != t.Name {
+				errs = append(errs, fmt.Errorf("a fragment on %s cannot be spread on %s", sel.on, t.Name))
+				continue
+			}
+			errs = append(errs, ex.validate(t, sel.selections, depth, spreading)...)
+		case sel.name == "__typename":
+			if sel.selections != nil || sel.args != nil {
+				errs = append(errs, fmt.Errorf("__typename takes no arguments or selections"))
+			}
+		default:
+			field, ok := t.field(sel.name)
+			if !ok {
+				errs = append(errs, fmt.Errorf("cannot query field %s on type %s", sel.name, t.Name))
+				continue
+			}
+			errs = append(errs, ex.validateArgs(t, field, sel.args)...)
+			object := ex.schema.types[gqlNamedType(field.Type)]
+			switch {
+			case object == nil && sel.selections != nil:
+				errs = append(errs, fmt.Errorf("field %s.%s is a %s and has no fields to select", t.Name, field.Name, field.Type))
+			case object != nil && sel.selections == nil:
+				errs = append(errs, fmt.Errorf("field %s.%s of type %s needs a selection of its fields", t.Name, field.Name, field.Type))
+			case object != nil:
+				errs = append(errs, ex.validate(object, sel.selections, depth+1, spreading)...)
+			}
+		}
+	}
+	return errs
+}
+
+func (ex *gqlExecution) validateArgs(t *GraphQLType, field GraphQLField, args []gqlArgument) []error {
+	var errs []error
+	given := make(map[string]bool)
+	for _, arg := range args {
+		known := false
+		for _, def := range field.Args {
+			known = known || def.Name == arg.name
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("unknown argument %s on field %s.%s", arg.name, t.Name, field.Name))
+		}
+		for _, name := range gqlVariablesIn(arg.value) {
+			if !ex.defined[name] {
+				errs = append(errs, fmt.Errorf("variable $%s is not defined by the query", name))
+			}
+		}
+		if given[arg.name] {
+			errs = append(errs, fmt.Errorf("argument %s is given twice on field %s.%s", arg.name, t.Name, field.Name))
+		}
+		given[arg.name] = true
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if _, err := ex.coerceArgs(field, args); err != nil {
+		errs = append(errs, fmt.Errorf("field %s.%s: %v", t.Name, field.Name, err))
+	}
+	return errs
+}
+
+// gqlVariablesIn lists the variables an argument value refers to
+func gqlVariablesIn(value interface{}) []string {
+	switch v := value.(type) {
+	case gqlVariable:
+		return []string{string(v)}
+	case []interface{}:
+		var names []string
+		for _, item := range v {
+			names = append(names, gqlVariablesIn(item)...)
+		}
+		return names
+	case map[string]interface{}:
+		var names []string
+		for _, item := range v {
+			names = append(names, gqlVariablesIn(item)...)
+		}
+		return names
+	}
+	return nil
+}
+
+// coerceArgs turns the query's arguments into the resolver's
+func (ex *gqlExecution) coerceArgs(field GraphQLField, args []gqlArgument) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, def := range field.Args {
+		var value interface{}
+		given := false
+		for _, arg := range args {
+			if arg.name == def.Name {
+				value, given = arg.value, true
+			}
+		}
+		if variable, ok := value.(gqlVariable); ok {
+			value, given = ex.variables[string(variable)]
+		}
+		if !given && def.Default != nil {
+			value, given = def.Default, true
+		}
+		if !given || value == nil {
+			if strings.HasSuffix(def.Type, "!") {
+				return nil, fmt.Errorf("argument %s of type %s is required", def.Name, def.Type)
+			}
+			continue
+		}
+		coerced, err := coerceGraphQLInput(def.Type, value, ex.variables)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %v", def.Name, err)
+		}
+		values[def.Name] = coerced
+	}
+	return values, nil
+}
+
+// coerceGraphQLInput converts a literal or a decoded JSON variable to the
+// Go type an input of typ takes
+func coerceGraphQLInput(typ string, value interface{}, variables map[string]interface{}) (interface{}, error) {
+	if variable, ok := value.(gqlVariable); ok {
+		value = variables[string(variable)]
+	}
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("null for non-null %s!", typ)
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		inner := typ[1 : len(typ)-1]
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced, err := coerceGraphQLInput(inner, item, variables)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	}
+
+	bad := func() (interface{}, error) {
+		return nil, fmt.Errorf("%v is not a valid %s", gqlDescribe(value), typ)
+	}
+	switch typ {
+	case "Int":
+		switch n := value.(type) {
+		case int:
+			return n, nil
+		case int64:
+			if n == int64(int32(n)) {
+				return int(n), nil
+			}
+		case float64:
+			if n == float64(int32(n)) {
+				return int(n), nil
+			}
+		case json.Number:
+			if i, err := n.Int64(); err == nil && i == int64(int32(i)) {
+				return int(i), nil
+			}
+		}
+		return bad()
+	case "Float":
+		switch n := value.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		case json.Number:
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+		return bad()
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return bad()
+	case "ID":
+		switch id := value.(type) {
+		case string:
+			return id, nil
+		case int64:
+			return strconv.FormatInt(id, 10), nil
+		case float64:
+			if id == float64(int64(id)) {
+				return strconv.FormatInt(int64(id), 10), nil
+			}
+		}
+		return bad()
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return bad()
+	case "Time":
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, nil
+			}
+		}
+		return bad()
+	case "JSON":
+		return value, nil
+	}
+	return bad()
+}
+
+func gqlDescribe(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	if encoded, err := json.Marshal(value); err == nil {
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// collect flattens a selection set's fragments into its fields, merging
+// the sub-selections of fields that share a response key
+func (ex *gqlExecution) collect(t *GraphQLType, selections []gqlSelection, fields []gqlSelection) []gqlSelection {
+	for _, sel := range selections {
+		switch {
+		case sel.spread != "":
+			fields = ex.collect(t, ex.fragments[sel.spread].selections, fields)
+		case sel.inline:
+			fields = ex.collect(t, sel.selections, fields)
+		default:
+			merged := false
+			for i := range fields {
+				if fields[i].key() == sel.key() {
+					fields[i].selections = append(append([]gqlSelection(nil), fields[i].selections...), sel.selections...)
+					merged = true
+				}
+			}
+			if !merged {
+				fields = append(fields, sel)
+			}
+		}
+	}
+	return fields
+}
+
+func (ex *gqlExecution) fail(path []interface{}, err error) {
+	ex.errors = append(ex.errors, GraphQLError{
+		Message: err.Error(),
+		Path:    append([]interface{}(nil), path...),
+	})
+}
+
+func (ex *gqlExecution) selectObject(t *GraphQLType, parent interface{}, selections []gqlSelection, path []interface{}) *gqlObject {
+	object := &gqlObject{values: make(map[string]interface{})}
+	for _, sel := range ex.collect(t, selections, nil) {
+		fieldPath := append(path[:len(path):len(path)], sel.key())
+		if sel.name == "__typename" {
+			object.set(sel.key(), t.Name)
+			continue
+		}
+		field, _ := t.field(sel.name)
+		args, err := ex.coerceArgs(field, sel.args)
+		var value interface{}
+		if err == nil {
+			if field.Resolve != nil {
+				value, err = field.Resolve(parent, args)
+			} else {
+				value = gqlReadField(parent, field.Name)
+			}
+		}
+		if err != nil {
+			ex.fail(fieldPath, err)
+			object.set(sel.key(), nil)
+			continue
+		}
+		object.set(sel.key(), ex.complete(field.Type, value, sel.selections, fieldPath))
+	}
+	return object
+}
+
+// complete shapes a resolved value to its field's type
+func (ex *gqlExecution) complete(typ string, value interface{}, selections []gqlSelection, path []interface{}) interface{} {
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface || v.Kind() == reflect.Map) && v.IsNil() {
+		if nonNull {
+			ex.fail(path, fmt.Errorf("non-null %s! resolved to null", typ))
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			ex.fail(path, fmt.Errorf("%s resolved to a %s", typ, v.Type()))
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = ex.complete(typ[1:len(typ)-1], v.Index(i).Interface(), selections, append(path[:len(path):len(path)], i))
+		}
+		return list
+	}
+	if t := ex.schema.types[typ]; t != nil {
+		return ex.selectObject(t, value, selections, path)
+	}
+	scalar, err := gqlSerialize(typ, v)
+	if err != nil {
+		ex.fail(path, err)
+		return nil
+	}
+	if scalar == nil && nonNull {
+		ex.fail(path, fmt.Errorf("non-null %s! resolved to null", typ))
+	}
+	return scalar
+}
+
+// gqlSerialize converts a resolved leaf to its scalar's JSON value
+func gqlSerialize(typ string, v reflect.Value) (interface{}, error) {
+	switch typ {
+	case "Time":
+		if t, ok := v.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return nil, nil
+			}
+			return t.Format(time.RFC3339Nano), nil
+		}
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+	case "Int":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v.Uint(), nil
+		case reflect.Float32, reflect.Float64:
+			if f := v.Float(); f == float64(int64(f)) {
+				return int64(f), nil
+			}
+		}
+	case "Float":
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(v.Int()), nil
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), nil
+		}
+	case "String", "ID":
+		if v.Kind() == reflect.String {
+			return v.String(), nil
+		}
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String(), nil
+		}
+		if typ == "ID" && v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64 {
+			return strconv.FormatInt(v.Int(), 10), nil
+		}
+	case "Boolean":
+		if v.Kind() == reflect.Bool {
+			return v.Bool(), nil
+		}
+	case "JSON":
+		return v.Interface(), nil
+	}
+	return nil, fmt.Errorf("%s resolved to a %s", typ, v.Type())
+}
+
+// gqlFieldIndexes caches, per struct type, the index of each field by its
+// JSON name
+var gqlFieldIndexes sync.Map
+
+// gqlReadField is the default resolver: the map key, or the struct field
+// with this JSON name
+func gqlReadField(parent interface{}, name string) interface{} {
+	v := reflect.ValueOf(parent)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !value.IsValid() {
+			return nil
+		}
+		return value.Interface()
+	case reflect.Struct:
+		cached, ok := gqlFieldIndexes.Load(v.Type())
+		if !ok {
+			indexes := make(map[string][]int)
+			for _, f := range reflect.VisibleFields(v.Type()) {
+				if !f.IsExported() || f.Anonymous {
+					continue
+				}
+				tag := strings.Split(f.Tag.Get("json"), ",")[0]
+				if tag == "-" {
+					continue
+				}
+				if tag == "" {
+					tag = f.Name
+				}
+				if _, taken := indexes[tag]; !taken || len(f.Index) == 1 {
+					indexes[tag] = f.Index
+				}
+			}
+			cached, _ = gqlFieldIndexes.LoadOrStore(v.Type(), indexes)
+		}
+		index, ok := cached.(map[string][]int)[name]
+		if !ok {
+			return nil
+		}
+		return v.FieldByIndex(index).Interface()
+	}
+	return nil
+}
+
+// GraphQLRequest is the body of a POST to the GraphQL endpoint
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+// Handler serves POST with a GraphQLRequest body, or an application/graphql
+// query, and GET with query, variables and operationName parameters. A GET
+// without a query returns the schema's SDL. Requests that never run, such
+// as a syntax error, answer 400.
+func (s *GraphQLSchema) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		switch r.Method {
+		case http.MethodGet:
+			params := r.URL.Query()
+			req.Query = params.Get("query")
+			if req.Query == "" {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				io.WriteString(w, s.SDL())
+				return
+			}
+			req.OperationName = params.Get("operationName")
+			if variables := params.Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad variables: %v", err))
+					return
+				}
+			}
+		case http.MethodPost:
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 2*maxGraphQLQuery))
+			if err != nil {
+				writeAPIError(w, http.StatusRequestEntityTooLarge, "request body is too large")
+				return
+			}
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/graphql" {
+				req.Query = string(body)
+			} else if err = json.Unmarshal(body, &req); err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad request body: %v", err))
+				return
+			}
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET or POST")
+			return
+		}
+
+		result := s.Execute(req.Query, req.Variables, req.OperationName)
+		status := http.StatusOK
+		if result.Data == nil {
+			status = http.StatusBadRequest
+		}
+		writeAPIJSON(w, status, result)
+	})
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGraphQLPage = 50
+	maxGraphQLPage     = 500
+)
+
+// graphQLProduct is a product as its sales describe it
+type graphQLProduct struct {
+	ID          string      `json:"id"`
+	Permalink   string      `json:"permalink"`
+	Name        string      `json:"name"`
+	SaleCount   int         `json:"sale_count"`
+	RefundCount int         `json:"refund_count"`
+	Revenue     MoneyTotals `json:"-"` // unrefunded, per currency
+	FirstSale   time.Time   `json:"first_sale"`
+	LastSale    time.Time   `json:"last_sale"`
+	buyers      map[string]bool
+}
+
+// graphQLPage is one page of a connection
+type graphQLPage struct {
+	total   int
+	offset  int
+	nodes   []interface{}
+	hasNext bool
+}
+
+type graphQLEdge struct {
+	cursor string
+	node   interface{}
+}
+
+// graphQLCursor is the opaque cursor of the item at an offset
+func graphQLCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// graphQLPageArgs reads first and after, returning the offset to start at
+func graphQLPageArgs(args map[string]interface{}) (first, offset int, err error) {
+	first, _ = args["first"].(int)
+	if first < 0 || first > maxGraphQLPage {
+		return 0, 0, fmt.Errorf("first must be between 0 and %d", maxGraphQLPage)
+	}
+	if after, ok := args["after"].(string); ok {
+		decoded, err := base64.RawURLEncoding.DecodeString(after)
+		n, convErr := strconv.Atoi(strings.TrimPrefix(string(decoded), "offset:"))
+		if err != nil || convErr != nil || !strings.HasPrefix(string(decoded), "offset:") || n < 0 {
+			return 0, 0, fmt.Errorf("bad cursor %q", after)
+		}
+		offset = n + 1
+	}
+	return first, offset, nil
+}
+
+// paginate pages items, assumed to be a slice of nodes in order
+func paginate(items []interface{}, args map[string]interface{}) (*graphQLPage, error) {
+	first, offset, err := graphQLPageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	page := &graphQLPage{total: len(items), offset: offset}
+	if offset < len(items) {
+		end := offset + first
+		if end > len(items) {
+			end = len(items)
+		}
+		page.nodes = items[offset:end]
+		page.hasNext = end < len(items)
+	}
+	return page, nil
+}
+
+func salesNodes(sales []Sale) []interface{} {
+	nodes := make([]interface{}, len(sales))
+	for i, sale := range sales {
+		nodes[i] = sale
+	}
+	return nodes
+}
+
+var graphQLPageArgList = []GraphQLArg{
+	{Name: "first", Type: "Int", Default: defaultGraphQLPage, Description: fmt.Sprintf("At most %d", maxGraphQLPage)},
+	{Name: "after", Type: "String", Description: "The end_cursor of the previous page"},
+}
+
+// graphQLConnection declares the connection and edge types for a node type
+func graphQLConnection(node string) []*GraphQLType {
+	return []*GraphQLType{
+		{
+			Name:        node + "Connection",
+			Description: "A page of " + node + " results",
+			Fields: []GraphQLField{
+				{Name: "total_count", Type: "Int!", Description: "Results across every page",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						return parent.(*graphQLPage).total, nil
+					}},
+				{Name: "nodes", Type: "[" + node + "!]!",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						return append([]interface{}{}, parent.(*graphQLPage).nodes...), nil
+					}},
+				{Name: "edges", Type: "[" + node + "Edge!]!",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						page := parent.(*graphQLPage)
+						edges := make([]graphQLEdge, len(page.nodes))
+						for i, node := range page.nodes {
+							edges[i] = graphQLEdge{cursor: graphQLCursor(page.offset + i), node: node}
+						}
+						return edges, nil
+					}},
+				{Name: "page_info", Type: "PageInfo!",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						page := parent.(*graphQLPage)
+						info := map[string]interface{}{"has_next_page": page.hasNext, "end_cursor": nil}
+						if len(page.nodes) > 0 {
+							info["end_cursor"] = graphQLCursor(page.offset + len(page.nodes) - 1)
+						}
+						return info, nil
+					}},
+			},
+		},
+		{
+			Name: node + "Edge",
+			Fields: []GraphQLField{
+				{Name: "cursor", Type: "String!",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						return parent.(graphQLEdge).cursor, nil
+					}},
+				{Name: "node", Type: node + "!",
+					Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+						return parent.(graphQLEdge).node, nil
+					}},
+			},
+		},
+	}
+}
+
+// graphQLSaleFilter is the filter the sales arguments describe
+func graphQLSaleFilter(args map[string]interface{}) func(Sale) bool {
+	product, _ := args["product"].(string)
+	email, _ := args["email"].(string)
+	email = NormalizeEmail(email)
+	refunded, filterRefunds := args["refunded"].(bool)
+	since, _ := args["since"].(time.Time)
+	until, _ := args["until"].(time.Time)
+	return func(sale Sale) bool {
+		switch {
+		case product != "" && sale.Product != product && sale.Permalink != product:
+			return false
+		case email != "" && sale.Email != email:
+			return false
+		case filterRefunds && sale.Refunded != refunded:
+			return false
+		case !since.IsZero() && sale.CreatedAt.Before(since):
+			return false
+		case !until.IsZero() && !sale.CreatedAt.Before(until):
+			return false
+		}
+		return true
+	}
+}
+
+var graphQLSaleArgs = append([]GraphQLArg{
+	{Name: "product", Type: "String", Description: "A product ID or permalink"},
+	{Name: "email", Type: "String"},
+	{Name: "refunded", Type: "Boolean"},
+	{Name: "since", Type: "Time", Description: "Sales created at or after"},
+	{Name: "until", Type: "Time", Description: "Sales created before"},
+}, graphQLPageArgList...)
+
+// graphQLProducts sums the sales of every product, in the order each was
+// first sold
+func graphQLProducts(sales []Sale) []*graphQLProduct {
+	var products []*graphQLProduct
+	byID := make(map[string]*graphQLProduct)
+	for _, sale := range sales {
+		product := byID[sale.Product]
+		if product == nil {
+			product = &graphQLProduct{ID: sale.Product, Revenue: MoneyTotals{}, buyers: make(map[string]bool)}
+			byID[sale.Product] = product
+			products = append(products, product)
+		}
+		if sale.Permalink != "" {
+			product.Permalink = sale.Permalink
+		}
+		if sale.ProductName != "" {
+			product.Name = sale.ProductName
+		}
+		product.SaleCount++
+		if sale.Refunded {
+			product.RefundCount++
+		} else if !sale.IsBundle() {
+			product.Revenue.Add(sale.Price)
+		}
+		if sale.Email != "" {
+			product.buyers[sale.Email] = true
+		}
+		if !sale.CreatedAt.IsZero() {
+			if product.FirstSale.IsZero() || sale.CreatedAt.Before(product.FirstSale) {
+				product.FirstSale = sale.CreatedAt
+			}
+			if sale.CreatedAt.After(product.LastSale) {
+				product.LastSale = sale.CreatedAt
+			}
+		}
+	}
+	return products
+}
+
+// NewGraphQLAPI serves sales, customers, products and the message history
+// as GraphQL, so a dashboard can fetch the shape it needs in one request.
+// A nil store leaves messages unavailable.
+func NewGraphQLAPI(sales *SalesStore, customers *Customers, store *MessageStore) *GraphQLSchema {
+	profile := func(email string) (interface{}, error) {
+		if email == "" {
+			return nil, nil
+		}
+		if profile, ok := customers.Profile(email); ok {
+			return profile, nil
+		}
+		return nil, nil
+	}
+	messageQuery := func(args map[string]interface{}) MessageQuery {
+		q := MessageQuery{}
+		messageType, _ := args["type"].(string)
+		q.MessageType = MessageType(messageType)
+		q.Direction, _ = args["direction"].(string)
+		q.Status, _ = args["status"].(string)
+		q.CorrelationID, _ = args["correlation_id"].(string)
+		q.Since, _ = args["since"].(time.Time)
+		q.Until, _ = args["until"].(time.Time)
+		return q
+	}
+
+	query := &GraphQLType{
+		Name: "Query",
+		Fields: []GraphQLField{
+			{Name: "sales", Type: "SaleConnection!", Args: graphQLSaleArgs, Description: "Sales in the order first seen",
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					return paginate(salesNodes(sales.Sales(graphQLSaleFilter(args))), args)
+				}},
+			{Name: "sale", Type: "Sale", Args: []GraphQLArg{{Name: "id", Type: "ID!"}},
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					if sale, ok := sales.Sale(args["id"].(string)); ok {
+						return sale, nil
+					}
+					return nil, nil
+				}},
+			{Name: "customers", Type: "CustomerConnection!", Description: "Customers sorted by email",
+				Args: append([]GraphQLArg{{Name: "search", Type: "String", Description: "Part of an email or name, ignoring case"}}, graphQLPageArgList...),
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					search, _ := args["search"].(string)
+					search = strings.ToLower(search)
+					// Profiles are built only for the page, unless a search
+					// needs their names
+					var emails []interface{}
+					for _, email := range customers.Emails() {
+						if search == "" || strings.Contains(email, search) {
+							emails = append(emails, email)
+						} else if p, ok := customers.Profile(email); ok && strings.Contains(strings.ToLower(p.Name), search) {
+							emails = append(emails, email)
+						}
+					}
+					page, err := paginate(emails, args)
+					if err != nil {
+						return nil, err
+					}
+					for i, email := range page.nodes {
+						page.nodes[i], _ = profile(email.(string))
+					}
+					return page, nil
+				}},
+			{Name: "customer", Type: "Customer", Args: []GraphQLArg{{Name: "email", Type: "String!"}},
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					return profile(args["email"].(string))
+				}},
+			{Name: "products", Type: "ProductConnection!", Args: graphQLPageArgList, Description: "Products in the order first sold",
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					var nodes []interface{}
+					for _, product := range graphQLProducts(sales.All()) {
+						nodes = append(nodes, product)
+					}
+					return paginate(nodes, args)
+				}},
+			{Name: "product", Type: "Product", Args: []GraphQLArg{{Name: "id", Type: "ID!", Description: "A product ID or permalink"}},
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					products := graphQLProducts(sales.ByProduct(args["id"].(string)))
+					if len(products) == 0 {
+						return nil, nil
+					}
+					return products[0], nil
+				}},
+			{Name: "messages", Type: "MessageConnection!", Description: "Sent and received messages, newest first",
+				Args: append([]GraphQLArg{
+					{Name: "type", Type: "String"},
+					{Name: "direction", Type: "String", Description: "sent or received"},
+					{Name: "status", Type: "String"},
+					{Name: "correlation_id", Type: "String"},
+					{Name: "since", Type: "Time"},
+					{Name: "until", Type: "Time"},
+				}, graphQLPageArgList...),
+				Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+					if store == nil {
+						return nil, fmt.Errorf("the message store is off")
+					}
+					first, offset, err := graphQLPageArgs(args)
+					if err != nil {
+						return nil, err
+					}
+					q := messageQuery(args)
+					total, err := store.Count(q)
+					if err != nil {
+						return nil, err
+					}
+					page := &graphQLPage{total: total, offset: offset}
+					if first > 0 && offset < total {
+						q.Limit, q.Offset = first, offset
+						messages, err := store.Query(q)
+						if err != nil {
+							return nil, err
+						}
+						for _, message := range messages {
+							page.nodes = append(page.nodes, message)
+						}
+					}
+					page.hasNext = offset+len(page.nodes) < total
+					return page, nil
+				}},
+		},
+	}
+
+	money := &GraphQLType{
+		Name:        "Money",
+		Description: "An amount in a currency's minor units",
+		Fields: []GraphQLField{
+			{Name: "amount", Type: "Int!", Description: "Minor units, e.g. cents"},
+			{Name: "currency", Type: "String!", Description: "Lower-case ISO 4217 code"},
+			{Name: "decimal", Type: "String!", Description: "Major units, e.g. 12.50",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return parent.(Money).Decimal(), nil
+				}},
+			{Name: "display", Type: "String!", Description: "e.g. 12.50 USD",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return parent.(Money).String(), nil
+				}},
+		},
+	}
+
+	sale := &GraphQLType{
+		Name: "Sale",
+		Fields: []GraphQLField{
+			{Name: "sale_id", Type: "ID!"},
+			{Name: "product", Type: "String!", Description: "The product ID, or its permalink without one"},
+			{Name: "product_permalink", Type: "String"},
+			{Name: "product_name", Type: "String"},
+			{Name: "email", Type: "String"},
+			{Name: "full_name", Type: "String"},
+			{Name: "price", Type: "Money!"},
+			{Name: "offer_code", Type: "String"},
+			{Name: "subscription_id", Type: "String"},
+			{Name: "pay_what_you_want", Type: "Boolean!"},
+			{Name: "refunded", Type: "Boolean!"},
+			{Name: "gift", Type: "Boolean!"},
+			{Name: "gifter_email", Type: "String"},
+			{Name: "bundle_sale_id", Type: "String"},
+			{Name: "bundle_components", Type: "[String!]!"},
+			{Name: "created_at", Type: "Time"},
+			{Name: "customer", Type: "Customer",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return profile(parent.(Sale).Email)
+				}},
+			{Name: "product_details", Type: "Product", Description: "Totals across every sale of the product",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					products := graphQLProducts(sales.ByProduct(parent.(Sale).Product))
+					if len(products) == 0 {
+						return nil, nil
+					}
+					return products[0], nil
+				}},
+		},
+	}
+
+	customer := &GraphQLType{
+		Name: "Customer",
+		Fields: []GraphQLField{
+			{Name: "email", Type: "String!"},
+			{Name: "name", Type: "String"},
+			{Name: "locale", Type: "String"},
+			{Name: "first_seen", Type: "Time"},
+			{Name: "last_seen", Type: "Time"},
+			{Name: "spend", Type: "[Money!]!", Description: "Unrefunded purchases, per currency",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return parent.(CustomerProfile).Spend.List(), nil
+				}},
+			{Name: "purchases", Type: "SaleConnection!", Args: graphQLPageArgList,
+				Resolve: func(parent interface{}, args map[string]interface{}) (interface{}, error) {
+					return paginate(salesNodes(parent.(CustomerProfile).Purchases), args)
+				}},
+			{Name: "subscriptions", Type: "[CustomerSubscription!]!"},
+			{Name: "support_messages", Type: "[SupportMessage!]!"},
+			{Name: "lists", Type: "[String!]!", Description: "Mailing lists, and waitlist:<product>"},
+			{Name: "opted_out", Type: "Boolean!"},
+		},
+	}
+
+	product := &GraphQLType{
+		Name:        "Product",
+		Description: "A product, summed from its sales",
+		Fields: []GraphQLField{
+			{Name: "id", Type: "ID!"},
+			{Name: "permalink", Type: "String"},
+			{Name: "name", Type: "String"},
+			{Name: "sale_count", Type: "Int!"},
+			{Name: "refund_count", Type: "Int!"},
+			{Name: "buyer_count", Type: "Int!",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return len(parent.(*graphQLProduct).buyers), nil
+				}},
+			{Name: "revenue", Type: "[Money!]!", Description: "Unrefunded sales, per currency",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return parent.(*graphQLProduct).Revenue.List(), nil
+				}},
+			{Name: "first_sale", Type: "Time"},
+			{Name: "last_sale", Type: "Time"},
+			{Name: "sales", Type: "SaleConnection!", Args: graphQLSaleArgs[1:],
+				Resolve: func(parent interface{}, args map[string]interface{}) (interface{}, error) {
+					product := parent.(*graphQLProduct)
+					filter := graphQLSaleFilter(args)
+					matched := sales.Sales(func(sale Sale) bool {
+						return sale.Product == product.ID && filter(sale)
+					})
+					return paginate(salesNodes(matched), args)
+				}},
+		},
+	}
+
+	message := &GraphQLType{
+		Name: "Message",
+		Fields: []GraphQLField{
+			{Name: "id", Type: "ID!"},
+			{Name: "direction", Type: "String!"},
+			{Name: "message_type", Type: "String!"},
+			{Name: "source_language", Type: "String"},
+			{Name: "target_language", Type: "String"},
+			{Name: "channel", Type: "String"},
+			{Name: "correlation_id", Type: "String"},
+			{Name: "status", Type: "String!"},
+			{Name: "attempts", Type: "Int!"},
+			{Name: "error", Type: "String"},
+			{Name: "timestamp", Type: "String"},
+			{Name: "payload", Type: "JSON"},
+			{Name: "first_seen", Type: "Time!"},
+			{Name: "last_seen", Type: "Time!"},
+			{Name: "conversation", Type: "[Message!]!", Description: "The request and replies sharing its correlation ID, oldest first",
+				Resolve: func(parent interface{}, _ map[string]interface{}) (interface{}, error) {
+					return store.ByCorrelation(parent.(StoredMessage).CorrelationID)
+				}},
+		},
+	}
+
+	types := []*GraphQLType{query, sale, money, customer, product, message,
+		{
+			Name: "CustomerSubscription",
+			Fields: []GraphQLField{
+				{Name: "id", Type: "ID!"},
+				{Name: "product", Type: "String!"},
+				{Name: "tier", Type: "String"},
+				{Name: "status", Type: "String!"},
+				{Name: "updated_at", Type: "Time"},
+			},
+		},
+		{
+			Name: "SupportMessage",
+			Fields: []GraphQLField{
+				{Name: "id", Type: "ID"},
+				{Name: "channel", Type: "String"},
+				{Name: "subject", Type: "String"},
+				{Name: "text", Type: "String!"},
+				{Name: "received_at", Type: "Time"},
+			},
+		},
+		{
+			Name: "PageInfo",
+			Fields: []GraphQLField{
+				{Name: "has_next_page", Type: "Boolean!"},
+				{Name: "end_cursor", Type: "String", Description: "Pass as after for the next page"},
+			},
+		},
+	}
+	for _, node := range []string{"Sale", "Customer", "Product", "Message"} {
+		types = append(types, graphQLConnection(node)...)
+	}
+	schema, err := NewGraphQLSchema("Query", types...)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGraphQLTestAPI records three sales of two products, one refunded,
+// and the messages that carried them
+func newGraphQLTestAPI(t *testing.T) *GraphQLSchema {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	store, err := NewMessageStore(gb, filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	customers, err := NewCustomers(gb, sales, filepath.Join(t.TempDir(), "customers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := NewSequentialIDs("py")
+	sale := func(id, product, email, name string, price float64, refunded bool) {
+		t.Helper()
+		payload := map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": product, "product_name": name,
+			"email": email, "price": price, "refunded": refunded, "sale_timestamp": clock.Now().Format(time.RFC3339)}
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+		clock.Advance(time.Hour)
+	}
+	sale("s1", "app", "ada@example.com", "ShotKit", 29, false)
+	sale("s2", "book", "Ada@Example.com", "The Book", 12.5, false)
+	sale("s3", "app", "bo@example.com", "ShotKit", 29, true)
+	return NewGraphQLAPI(sales, customers, store)
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	schema := newGraphQLTestAPI(t)
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		want      string
+	}{
+		{
+			"filtered sales",
+			`{ sales(product: "app", refunded: false) { total_count nodes { sale_id price { display } customer { email } } } }`,
+			nil,
+			`{"sales":{"total_count":1,"nodes":[{"sale_id":"s1","price":{"display":"29.00 USD"},"customer":{"email":"ada@example.com"}}]}}`,
+		},
+		{
+			"pages with cursors",
+			`query Page($after: String) { sales(first: 2, after: $after) { edges { cursor node { sale_id } } page_info { has_next_page end_cursor } } }`,
+			map[string]interface{}{"after": graphQLCursor(0)},
+			`{"sales":{"edges":[{"cursor":"` + graphQLCursor(1) + `","node":{"sale_id":"s2"}},{"cursor":"` + graphQLCursor(2) + `","node":{"sale_id":"s3"}}],` +
+				`"page_info":{"has_next_page":false,"end_cursor":"` + graphQLCursor(2) + `"}}}`,
+		},
+		{
+			"time range",
+			`{ sales(since: "2026-01-15T10:30:00Z", until: "2026-01-15T11:30:00Z") { nodes { sale_id created_at } } }`,
+			nil,
+			`{"sales":{"nodes":[{"sale_id":"s2","created_at":"2026-01-15T10:30:00Z"}]}}`,
+		},
+		{
+			"customer with purchases",
+			`{ customer(email: "ADA@example.com") { email spend { currency amount } purchases(first: 1) { total_count nodes { product_name } } } }`,
+			nil,
+			`{"customer":{"email":"ada@example.com","spend":[{"currency":"usd","amount":4150}],"purchases":{"total_count":2,"nodes":[{"product_name":"ShotKit"}]}}}`,
+		},
+		{
+			"unknown customer",
+			`{ customer(email: "nobody@example.com") { email } }`,
+			nil,
+			`{"customer":null}`,
+		},
+		{
+			"customer search",
+			`{ customers(search: "BO") { nodes { email } } }`,
+			nil,
+			`{"customers":{"nodes":[{"email":"bo@example.com"}]}}`,
+		},
+		{
+			"products",
+			`{ products { nodes { id name sale_count refund_count buyer_count revenue { decimal } sales(refunded: true) { nodes { sale_id } } } } }`,
+			nil,
+			`{"products":{"nodes":[` +
+				`{"id":"app","name":"ShotKit","sale_count":2,"refund_count":1,"buyer_count":2,"revenue":[{"decimal":"29.00"}],"sales":{"nodes":[{"sale_id":"s3"}]}},` +
+				`{"id":"book","name":"The Book","sale_count":1,"refund_count":0,"buyer_count":1,"revenue":[{"decimal":"12.50"}],"sales":{"nodes":[]}}]}}`,
+		},
+		{
+			"messages newest first",
+			`{ messages(direction: "received", first: 2) { total_count nodes { message_type payload } page_info { has_next_page } } }`,
+			nil,
+			`{"messages":{"total_count":3,"nodes":[` +
+				`{"message_type":"data_sync","payload":{"email":"bo@example.com","price":29,"product_id":"app","product_name":"ShotKit","refunded":true,"resource_name":"sale","sale_id":"s3","sale_timestamp":"2026-01-15T11:30:00Z"}},` +
+				`{"message_type":"data_sync","payload":{"email":"Ada@Example.com","price":12.5,"product_id":"book","product_name":"The Book","refunded":false,"resource_name":"sale","sale_id":"s2","sale_timestamp":"2026-01-15T10:30:00Z"}}],` +
+				`"page_info":{"has_next_page":true}}}`,
+		},
+		{
+			"aliases and fragments",
+			`query { first: sale(id: "s1") { ...Line } missing: sale(id: "s9") { ...Line } }
+			 fragment Line on Sale { __typename product ... on Sale { refunded } }`,
+			nil,
+			`{"first":{"__typename":"Sale","product":"app","refunded":false},"missing":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := schema.Execute(tt.query, tt.variables, "")
+			if len(result.Errors) > 0 {
+				t.Fatalf("errors %+v", result.Errors)
+			}
+			got, err := json.Marshal(result.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("data\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	schema := newGraphQLTestAPI(t)
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		ran       bool // whether the query ran, returning data
+		want      string
+	}{
+		{"syntax", `{ sales { nodes { sale_id } }`, nil, false, "unexpected end of query"},
+		{"unknown field", `{ sales { nodes { price_cents } } }`, nil, false, "cannot query field price_cents on type Sale"},
+		{"scalar selection", `{ sale(id: "s1") { product { id } } }`, nil, false, "has no fields to select"},
+		{"missing selection", `{ sale(id: "s1") }`, nil, false, "needs a selection of its fields"},
+		{"required argument", `{ sale { product } }`, nil, false, "argument id of type ID! is required"},
+		{"argument type", `{ sales(first: "ten") { total_count } }`, nil, false, `"ten" is not a valid Int`},
+		{"bad time", `{ sales(since: "yesterday") { total_count } }`, nil, false, `"yesterday" is not a valid Time`},
+		{"undefined variable", `{ sales(after: $cursor) { total_count } }`, nil, false, "variable $cursor is not defined"},
+		{"required variable", `query ($id: ID!) { sale(id: $id) { product } }`, nil, false, "variable $id of type ID! is required"},
+		{"fragment cycle", `{ sale(id: "s1") { ...A } } fragment A on Sale { ...A }`, nil, false, "fragment A spreads itself"},
+		{"mutation", `mutation { refund(id: "s1") }`, nil, false, "mutations are not supported"},
+		{"directive", `{ sales @cached { total_count } }`, nil, false, "directives are not supported"},
+		{"too deep", `{ sale(id: "s1") ` + strings.Repeat("{ customer { purchases { nodes ", 4) + "{ email }" + strings.Repeat(" } } }", 4) + " }", nil, false, "deeper than 12 levels"},
+		{"page size", `{ sales(first: 501) { total_count } }`, nil, true, "first must be between 0 and 500"},
+		{"bad cursor", `{ sales(after: "nope") { total_count } }`, nil, true, `bad cursor "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := schema.Execute(tt.query, tt.variables, "")
+			if len(result.Errors) == 0 || !strings.Contains(result.Errors[0].Message, tt.want) {
+				t.Fatalf("errors %+v, want %q", result.Errors, tt.want)
+			}
+			if ran := result.Data != nil; ran != tt.ran {
+				t.Errorf("data %v, want the query to have run: %v", result.Data, tt.ran)
+			}
+			if tt.ran && len(result.Errors[0].Path) == 0 {
+				t.Errorf("error %+v has no path", result.Errors[0])
+			}
+		})
+	}
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	handler := newGraphQLTestAPI(t).Handler()
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	sdl := serve(http.MethodGet, "/graphql", "", "")
+	if sdl.Code != http.StatusOK || !strings.Contains(sdl.Body.String(), "sales(product: String, email: String") {
+		t.Errorf("SDL %d:\n%s", sdl.Code, sdl.Body)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{"post", http.MethodPost, "/graphql", "application/json",
+			`{"query":"query Count($refunded: Boolean) { sales(refunded: $refunded) { total_count } }","variables":{"refunded":true},"operationName":"Count"}`,
+			http.StatusOK, `{"data":{"sales":{"total_count":1}}}`},
+		{"graphql body", http.MethodPost, "/graphql", "application/graphql", `{ sale(id: "s2") { product } }`,
+			http.StatusOK, `{"data":{"sale":{"product":"book"}}}`},
+		{"get", http.MethodGet, `/graphql?query={sales{total_count}}`, "", "",
+			http.StatusOK, `{"data":{"sales":{"total_count":3}}}`},
+		{"field error", http.MethodPost, "/graphql", "application/graphql", `{ sales(first: 1000) { total_count } }`,
+			http.StatusOK, `{"data":{"sales":null},"errors":[{"message":"first must be between 0 and 500","path":["sales"]}]}`},
+		{"invalid", http.MethodPost, "/graphql", "application/graphql", `{ nope }`,
+			http.StatusBadRequest, `{"errors":[{"message":"cannot query field nope on type Query"}]}`},
+		{"bad json", http.MethodPost, "/graphql", "application/json", `{"query":`,
+			http.StatusBadRequest, `"error":"bad request body`},
+		{"method", http.MethodDelete, "/graphql", "", "",
+			http.StatusMethodNotAllowed, `"error":"use GET or POST"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(tt.method, tt.target, tt.contentType, tt.body)
+			if recorder.Code != tt.status || !strings.Contains(recorder.Body.String(), tt.want) {
+				t.Errorf("%d %s, want %d %s", recorder.Code, recorder.Body, tt.status, tt.want)
+			}
+		})
+	}
+}
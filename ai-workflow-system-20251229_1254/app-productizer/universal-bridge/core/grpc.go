@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes the bridge uses
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcAborted           = 10
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// grpcError is a call that ended with a non-OK status
+type grpcError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.Code, e.Message)
+}
+
+// grpcContentType is what gRPC requests and responses are sent as
+const grpcContentType = "application/grpc"
+
+// h2cProtocols is unencrypted HTTP/2 with prior knowledge, which gRPC
+// clients use for plaintext connections
+func h2cProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// writeGRPCFrame writes one length-prefixed, uncompressed message
+func writeGRPCFrame(w io.Writer, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	_, err := w.Write(append(frame, message...))
+	return err
+}
+
+// readGRPCFrame reads one message of at most limit bytes; io.EOF means the
+// stream ended between messages
+func readGRPCFrame(r io.Reader, limit int) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated gRPC frame")
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{Code: grpcUnimplemented, Message: "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if limit > 0 && int64(size) > int64(limit) {
+		return nil, &grpcError{Code: grpcResourceExhausted, Message: fmt.Sprintf("message of %d bytes is over the %d byte limit", size, limit)}
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("truncated gRPC frame: %v", err)
+	}
+	return message, nil
+}
+
+// setGRPCStatus sets the call's status as trailers, sent when the handler
+// returns
+func setGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(message))
+	}
+}
+
+// grpcStatus reads the status of a response whose body has been read to
+// the end; a call that failed before sending anything has it in the headers
+func grpcStatus(resp *http.Response) error {
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		return &grpcError{Code: grpcInternal, Message: "response has no grpc-status"}
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return &grpcError{Code: grpcInternal, Message: fmt.Sprintf("bad grpc-status %q", status)}
+	}
+	if code == grpcOK {
+		return nil
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return &grpcError{Code: code, Message: message}
+}
+
+// grpcEscape percent-encodes a status message as the gRPC spec asks
+func grpcEscape(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	grpcSendMethod      = "/universalbridge.v1.Bridge/Send"
+	grpcSubscribeMethod = "/universalbridge.v1.Bridge/Subscribe"
+	// grpcMaxMessageSize is gRPC's default receive limit
+	grpcMaxMessageSize = 4 << 20
+	grpcCallTimeout    = 30 * time.Second
+	// grpcSubscriberQueue is how many messages wait for a slow subscriber
+	// before it is skipped
+	grpcSubscriberQueue = 256
+)
+
+// GRPCTransport serves the Bridge service of contract/universal_bridge.proto
+// over plaintext HTTP/2, for services on the same host. They call Send to
+// hand the bridge a message and Subscribe to receive the messages it sends
+// to their language, which are pushed as they are sent instead of waiting
+// for a file poll.
+//
+// A bridge with Peer set is a client of another bridge's listener: it
+// sends there what no local subscriber takes, and subscribes there for its
+// own language. Messages streamed to a subscriber are delivered at most
+// once; one lost with its stream is not sent again.
+type GRPCTransport struct {
+	// Peer is the host:port of a bridge's gRPC listener
+	Peer string
+	// Token, when set, must be sent as "authorization: Bearer <token>"
+	// metadata on inbound calls, and is sent on calls to Peer
+	Token string
+	// Retries is how many times a call to Peer is retried
+	Retries int
+	// MaxMessageSize bounds each message sent and received
+	MaxMessageSize int
+	HTTPClient     *http.Client
+
+	language string
+	clock    Clock
+
+	mu          sync.Mutex
+	deliver     func(*Envelope)
+	server      *http.Server
+	subscribers map[string][]*grpcSubscriber // by language
+	turn        map[string]int               // the next subscriber of each language
+	running     bool
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+// grpcSubscriber is one open Subscribe stream
+type grpcSubscriber struct {
+	remote string
+	frames chan []byte
+}
+
+// NewGRPCTransport creates a gRPC transport for the language's bridge that
+// calls peer, if set
+func NewGRPCTransport(peer, language string, clock Clock) *GRPCTransport {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &GRPCTransport{
+		Peer:           peer,
+		Retries:        3,
+		MaxMessageSize: grpcMaxMessageSize,
+		HTTPClient:     &http.Client{Transport: &http.Transport{Protocols: h2cProtocols()}},
+		language:       language,
+		clock:          clock,
+		subscribers:    make(map[string][]*grpcSubscriber),
+		turn:           make(map[string]int),
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+}
+
+// Channel returns GRPC
+func (gt *GRPCTransport) Channel() CommunicationChannel {
+	return GRPC
+}
+
+// Start records the bridge's delivery callback and subscribes to Peer
+func (gt *GRPCTransport) Start(deliver func(*Envelope)) error {
+	gt.mu.Lock()
+	gt.deliver = deliver
+	start := gt.Peer != "" && !gt.running
+	gt.running = gt.running || start
+	gt.mu.Unlock()
+	if start {
+		go gt.subscribeToPeer()
+		fmt.Printf("📡 gRPC transport subscribed to %s for %s\n", gt.Peer, gt.language)
+	}
+	return nil
+}
+
+// Serve serves the Bridge service on the listener until Close
+func (gt *GRPCTransport) Serve(listener net.Listener) {
+	server := &http.Server{Handler: gt, ReadHeaderTimeout: 10 * time.Second, Protocols: h2cProtocols()}
+	gt.mu.Lock()
+	gt.server = server
+	gt.mu.Unlock()
+	fmt.Printf("🌐 gRPC transport on %s\n", listener.Addr())
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ gRPC transport stopped: %v", err)
+		}
+	}()
+}
+
+// ServeHTTP answers the Bridge service's calls
+func (gt *GRPCTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+	if gt.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(gt.Token)) != 1 {
+			setGRPCStatus(w, grpcUnauthenticated, "missing or invalid token")
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case grpcSendMethod:
+		gt.serveSend(w, r)
+	case grpcSubscribeMethod:
+		gt.serveSubscribe(w, r)
+	default:
+		setGRPCStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	}
+}
+
+func (gt *GRPCTransport) serveSend(w http.ResponseWriter, r *http.Request) {
+	data, err := readGRPCFrame(r.Body, gt.MaxMessageSize)
+	if err != nil {
+		setGRPCStatus(w, grpcStatusCode(err), err.Error())
+		return
+	}
+	gt.mu.Lock()
+	deliver := gt.deliver
+	gt.mu.Unlock()
+	if deliver == nil {
+		setGRPCStatus(w, grpcUnavailable, "bridge not started")
+		return
+	}
+
+	// The call is answered once the pipeline acks or nacks the message
+	done := make(chan error, 1)
+	envelope := NewEnvelope(GRPC, "grpc:"+r.RemoteAddr, data, func() error {
+		done <- nil
+		return nil
+	}, func(reason error) {
+		if reason == nil {
+			reason = fmt.Errorf("message rejected")
+		}
+		done <- reason
+	})
+	envelope.ContentType = ProtobufContentType
+	deliver(envelope)
+
+	if reason := <-done; reason != nil {
+		setGRPCStatus(w, grpcStatusFor(reason), reason.Error())
+		return
+	}
+	var reply []byte
+	protoFields(data, func(field, _ int, _ uint64, bytes []byte) error {
+		if field == protoFieldID {
+			reply = protoAppendString(nil, 1, string(bytes))
+		}
+		return nil
+	})
+	writeGRPCFrame(w, reply)
+	setGRPCStatus(w, grpcOK, "")
+}
+
+// grpcStatusFor maps a nack reason to the Send call's status
+func grpcStatusFor(reason error) int {
+	switch {
+	case errors.Is(reason, ErrPayloadTooLarge):
+		return grpcResourceExhausted
+	case errors.Is(reason, ErrDuplicateInFlight):
+		return grpcAborted
+	case errors.Is(reason, ErrPipelineClosed), errors.Is(reason, ErrPipelineBusy):
+		return grpcUnavailable
+	}
+	return grpcInvalidArgument
+}
+
+// grpcStatusCode is the status of a failed read
+func grpcStatusCode(err error) int {
+	var status *grpcError
+	if errors.As(err, &status) {
+		return status.Code
+	}
+	return grpcInvalidArgument
+}
+
+func (gt *GRPCTransport) serveSubscribe(w http.ResponseWriter, r *http.Request) {
+	data, err := readGRPCFrame(r.Body, gt.MaxMessageSize)
+	if err != nil {
+		setGRPCStatus(w, grpcStatusCode(err), err.Error())
+		return
+	}
+	var language string
+	protoFields(data, func(field, _ int, _ uint64, bytes []byte) error {
+		if field == 1 {
+			language = string(bytes)
+		}
+		return nil
+	})
+	if language == "" {
+		setGRPCStatus(w, grpcInvalidArgument, "subscribe needs a language")
+		return
+	}
+
+	subscriber := &grpcSubscriber{remote: r.RemoteAddr, frames: make(chan []byte, grpcSubscriberQueue)}
+	gt.mu.Lock()
+	gt.subscribers[language] = append(gt.subscribers[language], subscriber)
+	gt.mu.Unlock()
+	defer gt.unsubscribe(language, subscriber)
+	fmt.Printf("📡 gRPC subscriber %s receiving %s\n", r.RemoteAddr, language)
+
+	// Send the headers now, so the subscriber knows it is listening
+	flusher := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case frame := <-subscriber.frames:
+			if err := writeGRPCFrame(w, frame); err != nil {
+				log.Printf("❌ gRPC subscriber %s lost a message: %v", r.RemoteAddr, err)
+				return
+			}
+			if err := flusher.Flush(); err != nil {
+				log.Printf("❌ gRPC subscriber %s lost a message: %v", r.RemoteAddr, err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-gt.stop:
+			setGRPCStatus(w, grpcUnavailable, "bridge shutting down")
+			return
+		}
+	}
+}
+
+func (gt *GRPCTransport) unsubscribe(language string, subscriber *grpcSubscriber) {
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+	subscribers := gt.subscribers[language]
+	for i, s := range subscribers {
+		if s == subscriber {
+			gt.subscribers[language] = append(subscribers[:i:i], subscribers[i+1:]...)
+			break
+		}
+	}
+	if len(gt.subscribers[language]) == 0 {
+		delete(gt.subscribers, language)
+	}
+	if lost := len(subscriber.frames); lost > 0 {
+		log.Printf("❌ gRPC subscriber %s left with %d messages unread", subscriber.remote, lost)
+	}
+}
+
+// push queues the message on the language's subscribers in turn, skipping
+// those too far behind. It reports false when the language has none.
+func (gt *GRPCTransport) push(language string, body []byte) (bool, error) {
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+	subscribers := gt.subscribers[language]
+	if len(subscribers) == 0 {
+		return false, nil
+	}
+	for i := 0; i < len(subscribers); i++ {
+		next := (gt.turn[language] + i) % len(subscribers)
+		select {
+		case subscribers[next].frames <- body:
+			gt.turn[language] = next + 1
+			return true, nil
+		default:
+		}
+	}
+	return true, fmt.Errorf("gRPC subscribers of %s are %d messages behind", language, grpcSubscriberQueue)
+}
+
+// Send streams the message to a subscriber of its target language, or
+// calls Peer's Send when there is none
+func (gt *GRPCTransport) Send(message *UniversalMessage) error {
+	body, err := marshalProtoMessage(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", message.ID, err)
+	}
+	if len(body) > gt.MaxMessageSize {
+		return &PayloadTooLargeError{MessageID: message.ID, Channel: GRPC, Size: int64(len(body)), Limit: int64(gt.MaxMessageSize)}
+	}
+	if pushed, err := gt.push(message.TargetLanguage, body); pushed || err != nil {
+		return err
+	}
+	if gt.Peer == "" {
+		return fmt.Errorf("no gRPC subscriber for %s and no peer to send %s to", message.TargetLanguage, message.ID)
+	}
+
+	retry := retrier{Retries: gt.Retries, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second, Clock: gt.clock, Classify: retryGRPC}
+	err = retry.do("Send of "+message.ID, func() error {
+		return gt.call(body)
+	})
+	if err != nil {
+		return fmt.Errorf("sending %s to %s: %v", message.ID, gt.Peer, err)
+	}
+	return nil
+}
+
+// retryGRPC retries network failures, an unavailable peer, and a duplicate
+// the peer is still handling
+func retryGRPC(err error) bool {
+	var status *grpcError
+	if errors.As(err, &status) {
+		return status.Code == grpcUnavailable || status.Code == grpcAborted
+	}
+	return !errors.As(err, new(finalError))
+}
+
+// request starts a call to one of Peer's methods
+func (gt *GRPCTransport) request(ctx context.Context, method string, message []byte) (*http.Response, error) {
+	var body bytes.Buffer
+	writeGRPCFrame(&body, message)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+gt.Peer+method, &body)
+	if err != nil {
+		return nil, final(err)
+	}
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("TE", "trailers")
+	if gt.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+gt.Token)
+	}
+	resp, err := gt.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &grpcError{Code: grpcUnknown, Message: "HTTP " + resp.Status}
+	}
+	return resp, nil
+}
+
+// call makes one Send call
+func (gt *GRPCTransport) call(message []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcCallTimeout)
+	defer cancel()
+	resp, err := gt.request(ctx, grpcSendMethod, message)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	return grpcStatus(resp)
+}
+
+// subscribeToPeer delivers the messages Peer streams to the bridge's
+// language, reconnecting with backoff until Close
+func (gt *GRPCTransport) subscribeToPeer() {
+	defer close(gt.stopped)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-gt.stop
+		cancel()
+	}()
+	delay := time.Second
+	for {
+		received, err := gt.stream(ctx)
+		select {
+		case <-gt.stop:
+			return
+		default:
+		}
+		if received {
+			delay = time.Second
+		}
+		log.Printf("❌ gRPC subscription to %s ended, resubscribing in %s: %v", gt.Peer, delay, err)
+		select {
+		case <-gt.stop:
+			return
+		case <-gt.clock.After(delay):
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// stream runs one Subscribe call, reporting whether it got anything
+func (gt *GRPCTransport) stream(ctx context.Context) (bool, error) {
+	resp, err := gt.request(ctx, grpcSubscribeMethod, protoAppendString(nil, 1, gt.language))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	gt.mu.Lock()
+	deliver := gt.deliver
+	gt.mu.Unlock()
+
+	received := false
+	for {
+		data, err := readGRPCFrame(resp.Body, gt.MaxMessageSize)
+		if err == io.EOF {
+			if err = grpcStatus(resp); err == nil {
+				err = fmt.Errorf("stream closed")
+			}
+			return received, err
+		}
+		if err != nil {
+			return received, err
+		}
+		received = true
+		envelope := NewEnvelope(GRPC, "grpc:"+gt.Peer, data, nil, func(reason error) {
+			log.Printf("❌ gRPC cannot redeliver a message from %s, dropping it: %v", gt.Peer, reason)
+		})
+		envelope.ContentType = ProtobufContentType
+		deliver(envelope)
+	}
+}
+
+// Close ends the subscriber streams and the subscription to Peer, then
+// stops the listener, letting Send calls in flight finish
+func (gt *GRPCTransport) Close() error {
+	gt.mu.Lock()
+	select {
+	case <-gt.stop:
+	default:
+		close(gt.stop)
+	}
+	server, running := gt.server, gt.running
+	gt.server = nil
+	gt.mu.Unlock()
+
+	if running {
+		<-gt.stopped
+	}
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGRPCBridge starts a Go bridge serving the Bridge service, returning
+// its address
+func newGRPCBridge(t *testing.T, clock Clock) (*GoBridge, *GRPCTransport, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGRPCTransport("", "go", clock)
+	server.Token = "s3cret"
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithChannelTransport(server), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	server.Serve(listener)
+	return gb, server, listener.Addr().String()
+}
+
+// newGRPCClient subscribes to the server as a co-located Python service
+// would, decoding what it is streamed
+func newGRPCClient(t *testing.T, addr, token string) (*GRPCTransport, chan *UniversalMessage) {
+	t.Helper()
+	client := NewGRPCTransport(addr, "python", nil)
+	client.Token = token
+	client.Retries = 0
+	received := make(chan *UniversalMessage, 10)
+	client.Start(func(envelope *Envelope) {
+		message, err := ProtobufSerializer{}.Unmarshal(envelope.Data)
+		if err != nil {
+			t.Errorf("streamed message: %v", err)
+			return
+		}
+		received <- message
+	})
+	t.Cleanup(func() { client.Close() })
+	return client, received
+}
+
+func TestGRPCTransport(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb, server, addr := newGRPCBridge(t, clock)
+	handled := make(chan *UniversalMessage, 1)
+	gb.OnMessage(FunctionCall, func(message *UniversalMessage) error {
+		handled <- message
+		_, err := gb.Reply(message, DataSync, map[string]interface{}{"result": "done"})
+		return err
+	})
+	python, received := newGRPCClient(t, addr, "s3cret")
+
+	// The reply is streamed back, so wait until the subscription is open
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		subscribed := len(server.subscribers["python"]) > 0
+		server.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("python never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ids := NewSequentialIDs("py")
+	payload := map[string]interface{}{
+		"function_name": "lookup",
+		"args":          []interface{}{"ada@example.com", 2.0, true, nil},
+		"kwargs":        map[string]interface{}{"fields": []interface{}{"tier"}},
+	}
+	request := newUniversalMessage(clock, ids, FunctionCall, "python", "go", payload, GRPC)
+	request.Headers = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	if err := python.Send(request); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-handled:
+		if !reflect.DeepEqual(got.Payload, payload) || got.Headers["traceparent"] != request.Headers["traceparent"] {
+			t.Errorf("handled %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not handled")
+	}
+	select {
+	case reply := <-received:
+		if reply.MessageType != DataSync || reply.Payload["original_message_id"] != request.ID || reply.Payload["result"] != "done" {
+			t.Errorf("reply %+v", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply was not streamed")
+	}
+
+	server.MaxMessageSize = 1024
+	big := newUniversalMessage(clock, ids, DataSync, "python", "go", map[string]interface{}{"blob": strings.Repeat("x", 2000)}, GRPC)
+	if err := python.Send(big); err == nil || !strings.Contains(err.Error(), "gRPC status 8: message of") {
+		t.Errorf("oversized message: %v", err)
+	}
+	if err := server.Send(newUniversalMessage(clock, ids, DataSync, "go", "javascript", nil, GRPC)); err == nil || !strings.Contains(err.Error(), "no gRPC subscriber for javascript") {
+		t.Errorf("send without a subscriber: %v", err)
+	}
+}
+
+func TestGRPCTransportRefusesBadToken(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	_, _, addr := newGRPCBridge(t, clock)
+	client := NewGRPCTransport(addr, "python", nil)
+	client.Token = "wrong"
+	defer client.Close()
+	message := newUniversalMessage(clock, NewSequentialIDs("py"), DataSync, "python", "go", nil, GRPC)
+	if err := client.Send(message); err == nil || !strings.Contains(err.Error(), "gRPC status 16: missing or invalid token") {
+		t.Errorf("error %v", err)
+	}
+}
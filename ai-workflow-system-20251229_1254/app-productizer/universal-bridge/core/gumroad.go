@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gumroadAPIURL      = "https://api.gumroad.com/v2"
+	gumroadTokenEnv    = "GUMROAD_ACCESS_TOKEN"
+	gumroadHTTPTimeout = 30 * time.Second
+)
+
+// ErrGumroadNotFound is returned when Gumroad answers 404: no such product,
+// subscriber, offer code or license key
+var ErrGumroadNotFound = errors.New("not found on Gumroad")
+
+// GumroadClient calls the Gumroad v2 API, for the changes the bridge makes
+// on the seller's behalf and for the gumroad.* functions peers can call.
+// Requests that are rate limited or meet a 5xx are retried, waiting as long
+// as Retry-After says or Backoff doubling up to MaxBackoff.
+type GumroadClient struct {
+	// BaseURL defaults to the public API
+	BaseURL string
+	// AccessToken defaults to $GUMROAD_ACCESS_TOKEN
+	AccessToken string
+	HTTPClient  *http.Client
+	// Retries is how many times a rate-limited or failed request is retried
+	Retries int
+	// Backoff is the delay before the first retry when the API gives none
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	clock Clock
+}
+
+// NewGumroadClient creates a client using the access token from the environment
+func NewGumroadClient() *GumroadClient {
+	return &GumroadClient{
+		BaseURL:     gumroadAPIURL,
+		AccessToken: os.Getenv(gumroadTokenEnv),
+		HTTPClient:  &http.Client{Timeout: gumroadHTTPTimeout},
+		Retries:     3,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		clock:       defaultClock,
+	}
+}
+
+// GumroadOfferCode is an offer code as the API returns it
+type GumroadOfferCode struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	AmountCents      float64 `json:"amount_cents,omitempty"`
+	PercentOff       float64 `json:"percent_off,omitempty"`
+	MaxPurchaseCount int     `json:"max_purchase_count,omitempty"`
+	Universal        bool    `json:"universal"`
+	TimesUsed        int     `json:"times_used"`
+}
+
+// CreateOfferCode creates a code on a product. offerType is "cents" or
+// "percent"; maxUses 0 means unlimited.
+func (c *GumroadClient) CreateOfferCode(product, code string, amountOff float64, offerType string, maxUses int) (GumroadOfferCode, error) {
+	form := url.Values{
+		"name":       {code},
+		"amount_off": {fmt.Sprint(amountOff)},
+		"offer_type": {offerType},
+	}
+	if maxUses > 0 {
+		form.Set("max_purchase_count", fmt.Sprint(maxUses))
+	}
+
+	var response struct {
+		OfferCode GumroadOfferCode `json:"offer_code"`
+	}
+	err := c.call(http.MethodPost, "/products/"+url.PathEscape(product)+"/offer_codes", form, &response)
+	return response.OfferCode, err
+}
+
+// LimitOfferCode caps how many times a code can be used in total; capping it
+// at its current uses disables it while keeping its redemption history
+func (c *GumroadClient) LimitOfferCode(product, id string, maxUses int) error {
+	form := url.Values{"max_purchase_count": {fmt.Sprint(maxUses)}}
+	return c.call(http.MethodPut, "/products/"+url.PathEscape(product)+"/offer_codes/"+url.PathEscape(id), form, nil)
+}
+
+// DeleteOfferCode removes a code from a product
+func (c *GumroadClient) DeleteOfferCode(product, id string) error {
+	return c.call(http.MethodDelete, "/products/"+url.PathEscape(product)+"/offer_codes/"+url.PathEscape(id), nil, nil)
+}
+
+// OfferCodes lists a product's offer codes
+func (c *GumroadClient) OfferCodes(product string) ([]GumroadOfferCode, error) {
+	var response struct {
+		OfferCodes []GumroadOfferCode `json:"offer_codes"`
+	}
+	err := c.call(http.MethodGet, "/products/"+url.PathEscape(product)+"/offer_codes", nil, &response)
+	return response.OfferCodes, err
+}
+
+// GumroadProduct is the part of a product the bridge reads and changes.
+// Price is in the currency's minor unit.
+type GumroadProduct struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	ShortURL        string `json:"short_url"`
+	CustomPermalink string `json:"custom_permalink"`
+	Price           int64  `json:"price"`
+	Currency        string `json:"currency"`
+	Published       bool   `json:"published"`
+	SalesCount      int    `json:"sales_count"`
+	// IsTieredMembership marks a membership, which has subscribers
+	IsTieredMembership bool `json:"is_tiered_membership"`
+}
+
+// Products lists the seller's products
+func (c *GumroadClient) Products() ([]GumroadProduct, error) {
+	var response struct {
+		Products []GumroadProduct `json:"products"`
+	}
+	err := c.call(http.MethodGet, "/products", nil, &response)
+	return response.Products, err
+}
+
+// Product fetches a product
+func (c *GumroadClient) Product(id string) (GumroadProduct, error) {
+	var response struct {
+		Product GumroadProduct `json:"product"`
+	}
+	err := c.call(http.MethodGet, "/products/"+url.PathEscape(id), nil, &response)
+	return response.Product, err
+}
+
+// UpdateProductDescription replaces a product's description
+func (c *GumroadClient) UpdateProductDescription(id, description string) error {
+	form := url.Values{"description": {description}}
+	return c.call(http.MethodPut, "/products/"+url.PathEscape(id), form, nil)
+}
+
+// GumroadSale is a sale as the sales API returns it. Price is in the
+// currency's minor unit; the API names the currency only by its symbol.
+type GumroadSale struct {
+	ID                  string `json:"id"`
+	Email               string `json:"email"`
+	SellerID            string `json:"seller_id"`
+	ProductID           string `json:"product_id"`
+	ProductName         string `json:"product_name"`
+	ProductPermalink    string `json:"product_permalink"`
+	Price               int64  `json:"price"`
+	CurrencySymbol      string `json:"currency_symbol"`
+	FormattedTotalPrice string `json:"formatted_total_price"`
+	Quantity            int    `json:"quantity"`
+	CreatedAt           string `json:"created_at"`
+	OrderID             int64  `json:"order_id"`
+	LicenseKey          string `json:"license_key"`
+	SubscriptionID      string `json:"subscription_id"`
+	Refunded            bool   `json:"refunded"`
+	PartiallyRefunded   bool   `json:"partially_refunded"`
+	Chargedback         bool   `json:"chargedback"`
+	Disputed            bool   `json:"disputed"`
+}
+
+// GumroadSalesQuery filters the sales API; zero fields match everything.
+// After and Before are dates, YYYY-MM-DD.
+type GumroadSalesQuery struct {
+	After     string
+	Before    string
+	ProductID string
+	Email     string
+	OrderID   string
+	// PageKey continues from an earlier page's NextPageKey
+	PageKey string
+}
+
+// GumroadSalesPage is one page of sales, newest first
+type GumroadSalesPage struct {
+	Sales       []GumroadSale `json:"sales"`
+	NextPageKey string        `json:"next_page_key"`
+}
+
+// SalesPage fetches one page of sales
+func (c *GumroadClient) SalesPage(query GumroadSalesQuery) (GumroadSalesPage, error) {
+	form := url.Values{}
+	for key, value := range map[string]string{
+		"after":      query.After,
+		"before":     query.Before,
+		"product_id": query.ProductID,
+		"email":      query.Email,
+		"order_id":   query.OrderID,
+		"page_key":   query.PageKey,
+	} {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	var page GumroadSalesPage
+	err := c.call(http.MethodGet, "/sales", form, &page)
+	return page, err
+}
+
+// Sales fetches every page of sales matching the query, stopping after
+// maxPages pages when maxPages is positive
+func (c *GumroadClient) Sales(query GumroadSalesQuery, maxPages int) ([]GumroadSale, error) {
+	var sales []GumroadSale
+	for pages := 0; maxPages <= 0 || pages < maxPages; pages++ {
+		page, err := c.SalesPage(query)
+		if err != nil {
+			return sales, err
+		}
+		sales = append(sales, page.Sales...)
+		if page.NextPageKey == "" || page.NextPageKey == query.PageKey {
+			break
+		}
+		query.PageKey = page.NextPageKey
+	}
+	return sales, nil
+}
+
+// GumroadLicense is the purchase a license key belongs to, and how many
+// times the key has been verified with increment
+type GumroadLicense struct {
+	Uses     int `json:"uses"`
+	Purchase struct {
+		SaleID                  string `json:"sale_id"`
+		ProductID               string `json:"product_id"`
+		ProductName             string `json:"product_name"`
+		Email                   string `json:"email"`
+		Price                   int64  `json:"price"`
+		Currency                string `json:"currency"`
+		Quantity                int    `json:"quantity"`
+		LicenseKey              string `json:"license_key"`
+		SaleTimestamp           string `json:"sale_timestamp"`
+		SubscriptionID          string `json:"subscription_id"`
+		Refunded                bool   `json:"refunded"`
+		Disputed                bool   `json:"disputed"`
+		Chargebacked            bool   `json:"chargebacked"`
+		SubscriptionEndedAt     string `json:"subscription_ended_at"`
+		SubscriptionCancelledAt string `json:"subscription_cancelled_at"`
+		SubscriptionFailedAt    string `json:"subscription_failed_at"`
+	} `json:"purchase"`
+}
+
+// Valid reports whether the license's purchase still entitles its owner:
+// not refunded, charged back or disputed, and any subscription not ended,
+// cancelled or failed
+func (l GumroadLicense) Valid() bool {
+	p := l.Purchase
+	return !p.Refunded && !p.Chargebacked && !p.Disputed &&
+		p.SubscriptionEndedAt == "" && p.SubscriptionCancelledAt == "" && p.SubscriptionFailedAt == ""
+}
+
+// VerifyLicense looks up a license key of a product. With increment, the
+// key's use count goes up by one, for limiting activations.
+func (c *GumroadClient) VerifyLicense(product, licenseKey string, increment bool) (GumroadLicense, error) {
+	form := url.Values{
+		"product_id":           {product},
+		"license_key":          {licenseKey},
+		"increment_uses_count": {strconv.FormatBool(increment)},
+	}
+	var license GumroadLicense
+	err := c.call(http.MethodPost, "/licenses/verify", form, &license)
+	return license, err
+}
+
+// GumroadSubscriber is a membership or subscription product's subscriber
+type GumroadSubscriber struct {
+	ID                          string   `json:"id"`
+	ProductID                   string   `json:"product_id"`
+	ProductName                 string   `json:"product_name"`
+	UserID                      string   `json:"user_id"`
+	UserEmail                   string   `json:"user_email"`
+	PurchaseIDs                 []string `json:"purchase_ids"`
+	Recurrence                  string   `json:"recurrence"`
+	Status                      string   `json:"status"`
+	ChargeOccurrenceCount       int      `json:"charge_occurrence_count"`
+	CreatedAt                   string   `json:"created_at"`
+	UserRequestedCancellationAt string   `json:"user_requested_cancellation_at"`
+	CancelledAt                 string   `json:"cancelled_at"`
+	EndedAt                     string   `json:"ended_at"`
+	FailedAt                    string   `json:"failed_at"`
+	FreeTrialEndsAt             string   `json:"free_trial_ends_at"`
+}
+
+// Subscribers lists a product's subscribers, all of them or only those with
+// the given email, following every page
+func (c *GumroadClient) Subscribers(product, email string) ([]GumroadSubscriber, error) {
+	form := url.Values{"paginated": {"true"}}
+	if email != "" {
+		form.Set("email", email)
+	}
+
+	var subscribers []GumroadSubscriber
+	for {
+		var page struct {
+			Subscribers []GumroadSubscriber `json:"subscribers"`
+			NextPageKey string              `json:"next_page_key"`
+		}
+		err := c.call(http.MethodGet, "/products/"+url.PathEscape(product)+"/subscribers", form, &page)
+		if err != nil {
+			return subscribers, err
+		}
+		subscribers = append(subscribers, page.Subscribers...)
+		if page.NextPageKey == "" || page.NextPageKey == form.Get("page_key") {
+			return subscribers, nil
+		}
+		form.Set("page_key", page.NextPageKey)
+	}
+}
+
+// Subscriber fetches one subscriber
+func (c *GumroadClient) Subscriber(id string) (GumroadSubscriber, error) {
+	var response struct {
+		Subscriber GumroadSubscriber `json:"subscriber"`
+	}
+	err := c.call(http.MethodGet, "/subscribers/"+url.PathEscape(id), nil, &response)
+	return response.Subscriber, err
+}
+
+// call sends a form request and decodes the JSON response into out,
+// turning {"success": false} into an error. Rate-limited and 5xx
+// responses are retried.
+func (c *GumroadClient) call(method, path string, form url.Values, out interface{}) error {
+	if c.AccessToken == "" {
+		return fmt.Errorf("no Gumroad access token; set %s", gumroadTokenEnv)
+	}
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("access_token", c.AccessToken)
+	clock := c.clock
+	if clock == nil {
+		clock = defaultClock
+	}
+
+	delay := c.Backoff
+	for attempt := 0; ; attempt++ {
+		wait, err := c.attempt(method, path, form, out)
+		if wait < 0 || attempt >= c.Retries {
+			return err
+		}
+		if wait == 0 {
+			wait = delay
+			delay *= 2
+			if c.MaxBackoff > 0 && delay > c.MaxBackoff {
+				delay = c.MaxBackoff
+			}
+		}
+		log.Printf("❌ %v; retrying in %s", err, wait)
+		<-clock.After(wait)
+	}
+}
+
+// attempt makes one request. A negative wait means done, successfully or
+// not; otherwise the request should be retried after wait, or after the
+// backoff when wait is 0.
+func (c *GumroadClient) attempt(method, path string, form url.Values, out interface{}) (time.Duration, error) {
+	endpoint := strings.TrimSuffix(c.BaseURL, "/") + path
+	var body *strings.Reader
+	if method == http.MethodGet || method == http.MethodDelete {
+		endpoint += "?" + form.Encode()
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	request, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return -1, err
+	}
+	if body.Len() > 0 {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("Gumroad %s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		var wait time.Duration
+		if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		return wait, fmt.Errorf("Gumroad %s %s returned %s", method, path, response.Status)
+	}
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read Gumroad response: %v", err)
+	}
+	var status struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	err = json.Unmarshal(content, &status)
+	if err != nil {
+		return -1, fmt.Errorf("Gumroad %s %s returned %s: %v", method, path, response.Status, err)
+	}
+	if !status.Success {
+		if response.StatusCode == http.StatusNotFound {
+			return -1, fmt.Errorf("Gumroad %s %s failed: %w: %s", method, path, ErrGumroadNotFound, status.Message)
+		}
+		return -1, fmt.Errorf("Gumroad %s %s failed: %s", method, path, status.Message)
+	}
+	if out == nil {
+		return -1, nil
+	}
+	err = json.Unmarshal(content, out)
+	if err != nil {
+		return -1, fmt.Errorf("bad Gumroad %s %s response: %v", method, path, err)
+	}
+	return -1, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// gumroadFunction is one gumroad.* function, taking the call's kwargs
+type gumroadFunction func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error)
+
+// gumroadFunctions are the Gumroad API calls peers can make with
+// CallFunction, by name
+var gumroadFunctions = map[string]gumroadFunction{
+	"gumroad.list_products": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		return c.Products()
+	},
+	"gumroad.get_product": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		product, err := requiredArg(kwargs, "product_id")
+		if err != nil {
+			return nil, err
+		}
+		return c.Product(product)
+	},
+	"gumroad.list_sales": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		query := GumroadSalesQuery{
+			After:     stringArg(kwargs, "after"),
+			Before:    stringArg(kwargs, "before"),
+			ProductID: stringArg(kwargs, "product_id"),
+			Email:     stringArg(kwargs, "email"),
+			OrderID:   stringArg(kwargs, "order_id"),
+			PageKey:   stringArg(kwargs, "page_key"),
+		}
+		// One page unless asked for more, so a peer cannot start an
+		// unbounded walk of the seller's history by accident
+		pages := 1
+		if value, ok := toNumber(kwargs["max_pages"]); ok {
+			pages = int(value)
+		}
+		if pages == 1 {
+			return c.SalesPage(query)
+		}
+		sales, err := c.Sales(query, pages)
+		return GumroadSalesPage{Sales: sales}, err
+	},
+	"gumroad.verify_license": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		product, err := requiredArg(kwargs, "product_id")
+		if err != nil {
+			return nil, err
+		}
+		key, err := requiredArg(kwargs, "license_key")
+		if err != nil {
+			return nil, err
+		}
+		increment, _ := kwargs["increment_uses_count"].(bool)
+		license, err := c.VerifyLicense(product, key, increment)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"uses": license.Uses, "purchase": license.Purchase, "valid": license.Valid()}, nil
+	},
+	"gumroad.list_subscribers": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		product, err := requiredArg(kwargs, "product_id")
+		if err != nil {
+			return nil, err
+		}
+		return c.Subscribers(product, stringArg(kwargs, "email"))
+	},
+	"gumroad.get_subscriber": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		id, err := requiredArg(kwargs, "subscriber_id")
+		if err != nil {
+			return nil, err
+		}
+		return c.Subscriber(id)
+	},
+	"gumroad.list_offer_codes": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		product, err := requiredArg(kwargs, "product_id")
+		if err != nil {
+			return nil, err
+		}
+		return c.OfferCodes(product)
+	},
+	"gumroad.create_offer_code": func(c *GumroadClient, kwargs map[string]interface{}) (interface{}, error) {
+		product, err := requiredArg(kwargs, "product_id")
+		if err != nil {
+			return nil, err
+		}
+		code, err := requiredArg(kwargs, "name")
+		if err != nil {
+			return nil, err
+		}
+		amountOff, ok := toNumber(kwargs["amount_off"])
+		if !ok || amountOff <= 0 {
+			return nil, fmt.Errorf("amount_off must be a positive number")
+		}
+		offerType := stringArg(kwargs, "offer_type")
+		if offerType == "" {
+			offerType = "cents"
+		}
+		if offerType != "cents" && offerType != "percent" {
+			return nil, fmt.Errorf("offer_type must be cents or percent, not %q", offerType)
+		}
+		maxUses := 0
+		if value, ok := toNumber(kwargs["max_purchase_count"]); ok {
+			maxUses = int(value)
+		}
+		return c.CreateOfferCode(product, code, amountOff, offerType, maxUses)
+	},
+}
+
+// requiredArg returns a kwarg that must be given
+func requiredArg(kwargs map[string]interface{}, key string) (string, error) {
+	value := stringArg(kwargs, key)
+	if value == "" {
+		return "", fmt.Errorf("missing %s", key)
+	}
+	return value, nil
+}
+
+// ServeGumroadFunctions answers function calls to the gumroad.* functions
+// with the client. A result is replied as an AI response carrying
+// function_result, a failure as an error message, the way the Python
+// bridge answers its own functions. Calls to other functions are answered
+// with an error too, since the bridge has one function call handler.
+// A reply that cannot be sent fails the call, so it is retried, but the
+// retry only resends the reply: calls such as gumroad.create_offer_code
+// are not safe to run twice.
+func ServeGumroadFunctions(gb *GoBridge, client *GumroadClient) {
+	var mu sync.Mutex
+	unsent := make(map[string]functionReply) // call message ID → its reply
+
+	gb.OnMessage(FunctionCall, func(message *UniversalMessage) error {
+		mu.Lock()
+		reply, retrying := unsent[message.ID]
+		mu.Unlock()
+		if !retrying {
+			reply = callGumroadFunction(client, message)
+		}
+
+		_, err := gb.Reply(message, reply.messageType, reply.payload)
+		mu.Lock()
+		if err != nil {
+			unsent[message.ID] = reply
+		} else {
+			delete(unsent, message.ID)
+		}
+		mu.Unlock()
+		return err
+	})
+}
+
+// functionReply is the answer to a function call
+type functionReply struct {
+	messageType MessageType
+	payload     map[string]interface{}
+}
+
+// callGumroadFunction runs the function a call names and builds its reply
+func callGumroadFunction(client *GumroadClient, message *UniversalMessage) functionReply {
+	name := stringArg(message.Payload, "function_name")
+	kwargs := mapArg(message.Payload, "kwargs")
+	if kwargs == nil {
+		kwargs = map[string]interface{}{}
+	}
+
+	function, ok := gumroadFunctions[name]
+	if !ok {
+		return functionError(fmt.Errorf("unknown function %q; this bridge serves %s", name, strings.Join(gumroadFunctionNames(), ", ")))
+	}
+	fmt.Printf("🛒 Calling %s for %s\n", name, message.SourceLanguage)
+	result, err := function(client, kwargs)
+	if err != nil {
+		log.Printf("❌ %s failed: %v", name, err)
+		return functionError(fmt.Errorf("%s: %v", name, err))
+	}
+	return functionReply{AIResponse, map[string]interface{}{
+		"function_result": result,
+		"success":         true,
+	}}
+}
+
+// functionError is the reply telling the caller its function call failed
+func functionError(callErr error) functionReply {
+	return functionReply{Error, map[string]interface{}{
+		"error":   callErr.Error(),
+		"success": false,
+	}}
+}
+
+// gumroadFunctionNames lists the functions ServeGumroadFunctions answers, sorted
+func gumroadFunctionNames() []string {
+	names := make([]string, 0, len(gumroadFunctions))
+	for name := range gumroadFunctions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
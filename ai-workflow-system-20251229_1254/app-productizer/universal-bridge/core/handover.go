@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenersEnv names the listeners passed to a replacement process. The
+// listener named at position i arrives as file descriptor 3+i.
+const listenersEnv = "BRIDGE_LISTENERS"
+
+// ListenerSet owns the bridge's network listeners so they can be handed to a
+// new process during a restart. Connections that arrive while the new binary
+// starts wait in the shared socket's backlog instead of being refused.
+type ListenerSet struct {
+	mu        sync.Mutex
+	names     []string
+	listeners map[string]net.Listener
+	inherited map[string]net.Listener
+	handedTo  bool // this process was started by a Handover
+}
+
+// NewListenerSet creates a set, adopting any listeners inherited from the
+// process that started this one
+func NewListenerSet() (*ListenerSet, error) {
+	ls := &ListenerSet{
+		listeners: make(map[string]net.Listener),
+		inherited: make(map[string]net.Listener),
+	}
+
+	names, handedTo := os.LookupEnv(listenersEnv)
+	if !handedTo {
+		return ls, nil
+	}
+	os.Unsetenv(listenersEnv)
+	ls.handedTo = true
+	if names == "" {
+		return ls, nil
+	}
+
+	for i, name := range strings.Split(names, ",") {
+		file := os.NewFile(uintptr(3+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit listener %s: %v", name, err)
+		}
+		ls.inherited[name] = listener
+	}
+	return ls, nil
+}
+
+// HandedOver reports whether this process was started by another's
+// Handover, which may still be draining
+func (ls *ListenerSet) HandedOver() bool {
+	return ls.handedTo
+}
+
+// Listen returns the inherited listener with this name, or opens a new one
+func (ls *ListenerSet) Listen(name, network, address string) (net.Listener, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if _, exists := ls.listeners[name]; exists {
+		return nil, fmt.Errorf("listener %s already registered", name)
+	}
+
+	listener, ok := ls.inherited[name]
+	if ok {
+		delete(ls.inherited, name)
+		fmt.Printf("♻️ Inherited listener %s on %s\n", name, listener.Addr())
+	} else {
+		var err error
+		listener, err = net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ls.names = append(ls.names, name)
+	ls.listeners[name] = listener
+	return listener, nil
+}
+
+// Handover starts a copy of the current binary with the same arguments and
+// passes it every listener. The caller should drain and exit afterwards.
+func (ls *ListenerSet) Handover() (*os.Process, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	files := make([]*os.File, 0, len(ls.names))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for _, name := range ls.names {
+		filer, ok := ls.listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s cannot be handed over", name)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("failed to duplicate listener %s: %v", name, err)
+		}
+		files = append(files, file)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %v", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), listenersEnv+"="+strings.Join(ls.names, ","))
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start replacement process: %v", err)
+	}
+
+	fmt.Printf("🔄 Handed %d listeners to process %d\n", len(files), cmd.Process.Pid)
+	return cmd.Process, nil
+}
+
+// Close closes every listener, including inherited ones nobody claimed.
+// After a handover this only releases this process's copies.
+func (ls *ListenerSet) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var firstErr error
+	for _, listener := range ls.listeners {
+		if err := listener.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, listener := range ls.inherited {
+		listener.Close()
+	}
+	ls.listeners = make(map[string]net.Listener)
+	ls.inherited = make(map[string]net.Listener)
+	ls.names = nil
+	return firstErr
+}
+
+// Serve keeps the bridge running until SIGINT, SIGTERM or Close, then drains it.
+// On SIGHUP it hands the listeners to a freshly started copy of the binary
+// and drains, so deploys replace the process without refusing connections.
+func Serve(gb *GoBridge, listeners *ListenerSet) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	fmt.Println("🚀 Serving; send SIGHUP to restart, SIGINT to stop")
+serving:
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				_, err := listeners.Handover()
+				if err != nil {
+					// Keep serving; a failed restart must not take the bridge down
+					log.Printf("❌ Handover failed: %v", err)
+					continue
+				}
+			}
+			break serving
+		case <-gb.Done():
+			break serving
+		}
+	}
+
+	err := gb.Close()
+	listeners.Close()
+	return err
+}
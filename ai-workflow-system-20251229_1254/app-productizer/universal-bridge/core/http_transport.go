@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	httpTokenEnv    = "BRIDGE_HTTP_TOKEN"
+	httpSendTimeout = 30 * time.Second
+)
+
+// HTTPTransport exchanges messages with a peer bridge over HTTP. Inbound
+// messages are POSTed to /messages and answered once handled: 202 when
+// acked, an error status with the nack reason otherwise. Outbound messages
+// are POSTed to the peer's BaseURL + "/messages", retrying network errors,
+// 409s, 429s and 5xx responses with exponential backoff.
+type HTTPTransport struct {
+	// BaseURL is the peer bridge that Send posts to, e.g. http://localhost:8090
+	BaseURL string
+	// Headers are added to every outbound request, e.g. Authorization
+	Headers http.Header
+	// Token, when set, must be sent as "Authorization: Bearer <token>" on
+	// inbound requests
+	Token string
+	// Retries is how many times a failed send is retried
+	Retries int
+	// Backoff is the delay before the first retry; each retry doubles it up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Serializer encodes sent messages; nil is JSON
+	Serializer Serializer
+	HTTPClient *http.Client
+
+	clock Clock
+
+	mu      sync.RWMutex
+	deliver func(*Envelope)
+	server  *http.Server
+}
+
+// NewHTTPTransport creates an HTTP transport that sends to baseURL
+func NewHTTPTransport(baseURL string, clock Clock) *HTTPTransport {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &HTTPTransport{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Headers:    make(http.Header),
+		Retries:    3,
+		Backoff:    500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		HTTPClient: &http.Client{Timeout: httpSendTimeout},
+		clock:      clock,
+	}
+}
+
+// Channel returns HTTP
+func (ht *HTTPTransport) Channel() CommunicationChannel {
+	return HTTP
+}
+
+// Start records the bridge's delivery callback; requests that arrive before
+// it are refused with 503
+func (ht *HTTPTransport) Start(deliver func(*Envelope)) error {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+	ht.deliver = deliver
+	return nil
+}
+
+// Handler serves POST /messages
+func (ht *HTTPTransport) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/messages", ht.handleMessages)
+	return mux
+}
+
+// Serve serves Handler on the listener until Close
+func (ht *HTTPTransport) Serve(listener net.Listener) {
+	server := &http.Server{Handler: ht.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	ht.mu.Lock()
+	ht.server = server
+	ht.mu.Unlock()
+	fmt.Printf("🌐 HTTP transport on %s\n", listener.Addr())
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ HTTP transport stopped: %v", err)
+		}
+	}()
+}
+
+func (ht *HTTPTransport) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if ht.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ht.Token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+	}
+
+	contentType := ""
+	if header := r.Header.Get("Content-Type"); header != "" {
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			writeAPIError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("bad Content-Type: %v", err))
+			return
+		}
+		if mediaType != JSONContentType {
+			contentType = mediaType
+		}
+	}
+	if _, err := SerializerFor(contentType); err != nil {
+		writeAPIError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	ht.mu.RLock()
+	deliver := ht.deliver
+	ht.mu.RUnlock()
+	if deliver == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "bridge not started")
+		return
+	}
+
+	// The body is streamed straight into the decoder, which stops at the
+	// size limit; the handler blocks until the pipeline acks or nacks
+	done := make(chan error, 1)
+	envelope := NewStreamEnvelope(HTTP, r.RemoteAddr, r.ContentLength, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(r.Body), nil
+	}, func() error {
+		done <- nil
+		return nil
+	}, func(reason error) {
+		if reason == nil {
+			reason = fmt.Errorf("message rejected")
+		}
+		done <- reason
+	})
+	envelope.ContentType = contentType
+	deliver(envelope)
+
+	// Wait even if the peer hangs up: the decoder may still be reading r.Body
+	reason := <-done
+	if reason != nil {
+		writeAPIError(w, httpStatusFor(reason), reason.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// httpStatusFor maps a nack reason to the inbound response status
+func httpStatusFor(reason error) int {
+	switch {
+	case errors.Is(reason, ErrPayloadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(reason, ErrDuplicateInFlight):
+		return http.StatusConflict
+	case errors.Is(reason, ErrPipelineClosed), errors.Is(reason, ErrPipelineBusy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// Send posts the message to the peer, retrying retryable failures
+func (ht *HTTPTransport) Send(message *UniversalMessage) error {
+	if ht.BaseURL == "" {
+		return fmt.Errorf("http transport has no base URL")
+	}
+	serializer := ht.Serializer
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	body, err := serializer.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", message.ID, err)
+	}
+
+	retry := retrier{Retries: ht.Retries, Backoff: ht.Backoff, MaxBackoff: ht.MaxBackoff, Clock: ht.clock, Classify: retryDelivery}
+	err = retry.do("Send of "+message.ID, func() error {
+		return ht.post(body, serializer.ContentType())
+	})
+	if err != nil {
+		return fmt.Errorf("sending %s to %s: %v", message.ID, ht.BaseURL, err)
+	}
+	return nil
+}
+
+// retryDelivery retries what retryTransient does and 409s, which are a
+// duplicate the peer is still handling; once it finishes, the retry is
+// acked as already done
+func retryDelivery(err error) bool {
+	var status *apiError
+	if errors.As(err, &status) && status.StatusCode == http.StatusConflict {
+		return true
+	}
+	return retryTransient(err)
+}
+
+// post makes one delivery attempt
+func (ht *HTTPTransport) post(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, ht.BaseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return final(err)
+	}
+	for name, values := range ht.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := ht.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	return readAPIError("peer", resp)
+}
+
+// Close stops the inbound server, letting in-flight requests finish
+func (ht *HTTPTransport) Close() error {
+	ht.mu.Lock()
+	server := ht.server
+	ht.server = nil
+	ht.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransportReceives(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	ht := NewHTTPTransport("", clock)
+	ht.Token = "s3cret"
+	gb := NewGoBridge("", WithClock(clock), WithTransport(ht), WithPipelineConfig(PipelineConfig{MaxMessageBytes: 1024}))
+	t.Cleanup(func() { gb.Close() })
+
+	var mu sync.Mutex
+	var handled []string
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		if message.Payload["fail"] == true {
+			return errors.New("sheet is locked")
+		}
+		mu.Lock()
+		handled = append(handled, message.ID)
+		mu.Unlock()
+		return nil
+	})
+	server := httptest.NewServer(ht.Handler())
+	t.Cleanup(server.Close)
+
+	ids := NewSequentialIDs("py")
+	encode := func(payload map[string]interface{}) string {
+		t.Helper()
+		body, err := JSONSerializer{}.Marshal(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, HTTP))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		token       string
+		contentType string
+		body        string
+		chunked     bool // sent without a Content-Length
+		wantStatus  int
+		wantBody    string
+	}{
+		{"acked", "POST", "s3cret", "application/json", encode(map[string]interface{}{"n": 1}), false, http.StatusAccepted, ""},
+		{"no content type", "POST", "s3cret", "", encode(map[string]interface{}{"n": 2}), false, http.StatusAccepted, ""},
+		{"handler fails", "POST", "s3cret", "application/json", encode(map[string]interface{}{"fail": true}), false, http.StatusUnprocessableEntity, "sheet is locked"},
+		{"not JSON", "POST", "s3cret", "application/json", "{", false, http.StatusUnprocessableEntity, ""},
+		{"too large", "POST", "s3cret", "application/json", encode(map[string]interface{}{"note": strings.Repeat("x", 2048)}), false, http.StatusRequestEntityTooLarge, "1024 byte limit"},
+		{"too large, chunked", "POST", "s3cret", "application/json", encode(map[string]interface{}{"note": strings.Repeat("x", 2048)}), true, http.StatusRequestEntityTooLarge, "1024 byte limit"},
+		{"unknown content type", "POST", "s3cret", "application/xml", "<message/>", false, http.StatusUnsupportedMediaType, ""},
+		{"no token", "POST", "", "application/json", encode(map[string]interface{}{"n": 3}), false, http.StatusUnauthorized, "token"},
+		{"wrong token", "POST", "guess", "application/json", encode(map[string]interface{}{"n": 4}), false, http.StatusUnauthorized, "token"},
+		{"not a POST", "GET", "s3cret", "", "", false, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		var body io.Reader = strings.NewReader(tt.body)
+		if tt.chunked {
+			body = io.MultiReader(body)
+		}
+		request, err := http.NewRequest(tt.method, server.URL+"/messages", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if tt.contentType != "" {
+			request.Header.Set("Content-Type", tt.contentType)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		answer, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tt.wantStatus || !strings.Contains(string(answer), tt.wantBody) {
+			t.Errorf("%s: status %d %s, want %d with %q", tt.name, response.StatusCode, answer, tt.wantStatus, tt.wantBody)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 2 {
+		t.Errorf("handled %v, want the two acked messages", handled)
+	}
+}
+
+func TestHTTPTransportSendRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int // answers to successive posts; the last repeats
+		wantPosts int
+		wantErr   bool
+	}{
+		{"accepted", []int{http.StatusAccepted}, 1, false},
+		{"unavailable, then accepted", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusAccepted}, 3, false},
+		{"duplicate in flight, then accepted", []int{http.StatusConflict, http.StatusAccepted}, 2, false},
+		{"rejected", []int{http.StatusUnprocessableEntity}, 1, true},
+		{"unauthorized", []int{http.StatusUnauthorized}, 1, true},
+		{"always unavailable", []int{http.StatusServiceUnavailable}, 4, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			posts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				status := tt.statuses[len(tt.statuses)-1]
+				if posts < len(tt.statuses) {
+					status = tt.statuses[posts]
+				}
+				posts++
+				mu.Unlock()
+				if r.URL.Path != "/messages" || r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("Content-Type") != JSONContentType {
+					status = http.StatusBadRequest
+				}
+				w.WriteHeader(status)
+			}))
+			t.Cleanup(server.Close)
+
+			clock := &waitRecorder{ManualClock: NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))}
+			ht := NewHTTPTransport(server.URL+"/", clock)
+			ht.Headers.Set("Authorization", "Bearer s3cret")
+			err := ht.Send(newUniversalMessage(clock, NewSequentialIDs("go"), DataSync, "go", "python", map[string]interface{}{"n": 1}, HTTP))
+
+			if (err != nil) != tt.wantErr || posts != tt.wantPosts {
+				t.Fatalf("Send = %v after %d posts, want error %t after %d", err, posts, tt.wantErr, tt.wantPosts)
+			}
+			if len(clock.waits) != tt.wantPosts-1 {
+				t.Errorf("waited %v between %d posts", clock.waits, posts)
+			}
+		})
+	}
+}
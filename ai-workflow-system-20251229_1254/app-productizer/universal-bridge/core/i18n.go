@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+)
+
+// DefaultLocale is used when nothing in the payload identifies the buyer's language
+const DefaultLocale = "en"
+
+// countryLocales maps ISO country codes to the language most buyers there read
+var countryLocales = map[string]string{
+	"AT": "de", "CH": "de", "DE": "de", "LI": "de",
+	"FR": "fr", "LU": "fr", "MC": "fr",
+	"BE": "nl", "NL": "nl",
+	"ES": "es", "MX": "es", "AR": "es", "CO": "es", "CL": "es",
+	"IT": "it", "SM": "it",
+	"PT": "pt", "BR": "pt",
+	"PL": "pl", "SE": "sv", "DK": "da", "NO": "nb", "FI": "fi",
+	"CZ": "cs", "GR": "el", "HU": "hu", "RO": "ro",
+}
+
+// DetectLocale picks a locale for the buyer in a sale payload. An explicit
+// locale wins, then the buyer's language (as in an Accept-Language header),
+// then their country.
+func DetectLocale(payload map[string]interface{}) string {
+	for _, field := range []string{"locale", "customer.locale"} {
+		if value, ok := lookupField(payload, field); ok {
+			if locale := normalizeLocale(stringValue(value)); locale != "" {
+				return locale
+			}
+		}
+	}
+
+	for _, field := range []string{"language", "customer.language"} {
+		if value, ok := lookupField(payload, field); ok {
+			// "de-AT,de;q=0.9,en;q=0.8" → the first, most preferred entry
+			first := strings.Split(stringValue(value), ",")[0]
+			if locale := normalizeLocale(strings.Split(first, ";")[0]); locale != "" {
+				return locale
+			}
+		}
+	}
+
+	for _, field := range []string{"country", "customer.country", "ip_country"} {
+		if value, ok := lookupField(payload, field); ok {
+			if locale, ok := countryLocales[strings.ToUpper(strings.TrimSpace(stringValue(value)))]; ok {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// LocaleFallbacks returns the variants to try for a locale, most specific
+// first: "pt-BR" gives ["pt-br", "pt"]
+func LocaleFallbacks(locale string) []string {
+	locale = normalizeLocale(locale)
+	if locale == "" {
+		return nil
+	}
+	fallbacks := []string{locale}
+	if dash := strings.Index(locale, "-"); dash > 0 {
+		fallbacks = append(fallbacks, locale[:dash])
+	}
+	return fallbacks
+}
+
+// normalizeLocale lowercases a tag and uses dashes, rejecting anything that
+// is not letters and dashes so a locale can safely form part of a file name
+func normalizeLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(strings.Replace(tag, "_", "-", -1)))
+	if tag == "" || tag == "*" {
+		return ""
+	}
+	for _, r := range tag {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return ""
+		}
+	}
+	return tag
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultLaunchSalesEvery is how many sales apart sales milestones are
+const defaultLaunchSalesEvery = 10
+
+// Launch milestone kinds
+const (
+	LaunchSalesMilestone   = "sales"
+	LaunchRevenueMilestone = "revenue"
+)
+
+// ErrNoLaunch is returned when stopping launch mode while it is off
+var ErrNoLaunch = errors.New("no launch is running")
+
+// ErrLaunchRunning is returned when starting a launch while one runs
+var ErrLaunchRunning = errors.New("a launch is already running")
+
+// LaunchConfig is what launch mode changes while a launch runs, loaded from
+// YAML:
+//
+//	sales_every: 10           # a milestone every this many sales; 10 if unset
+//	revenue:                  # a milestone as launch revenue passes each
+//	  usd: ["500.00", "1000.00", "5000.00"]
+//	live_metrics_every: 10s   # the live feed's snapshots, a minute otherwise
+//	ends_after: 24h           # stop and report on its own; unset runs until stopped
+//	jobs:                     # jobs that poll more often during the launch
+//	  site_feed: "* * * * *"
+//	  evaluate_segments: "*/2 * * * *"
+type LaunchConfig struct {
+	SalesEvery       int                 `yaml:"sales_every"`
+	Revenue          map[string][]string `yaml:"revenue"`
+	LiveMetricsEvery time.Duration       `yaml:"live_metrics_every"`
+	EndsAfter        time.Duration       `yaml:"ends_after"`
+	Jobs             map[string]string   `yaml:"jobs"`
+
+	revenue map[string][]Money // thresholds per currency, ascending
+}
+
+// LaunchMilestone is a sales count or revenue threshold a launch passed
+type LaunchMilestone struct {
+	Kind    string    `json:"kind"`
+	At      time.Time `json:"at"`
+	Sales   int       `json:"sales"`
+	Revenue *Money    `json:"revenue,omitempty"` // the threshold passed
+	Text    string    `json:"text"`
+}
+
+// LaunchSession is a running launch as launch mode persists it
+type LaunchSession struct {
+	Name       string            `json:"name"`
+	StartedAt  time.Time         `json:"started_at"`
+	SaleIDs    []string          `json:"sale_ids"` // received since it started, bundle components included
+	Milestones []LaunchMilestone `json:"milestones"`
+	Passed     map[string]int    `json:"passed,omitempty"`    // currency → revenue thresholds passed
+	Schedules  map[string]string `json:"schedules,omitempty"` // job ID → schedule to restore
+}
+
+// LaunchReport sums up a launch, or a running one so far. Refunds counts
+// the launch's sales refunded since; Revenue is what the rest took.
+type LaunchReport struct {
+	Name       string            `json:"name"`
+	StartedAt  time.Time         `json:"started_at"`
+	EndedAt    *time.Time        `json:"ended_at,omitempty"`
+	Duration   string            `json:"duration"`
+	Sales      int               `json:"sales"`
+	Refunds    int               `json:"refunds"`
+	Revenue    []Money           `json:"revenue"`
+	Products   []LaunchProduct   `json:"products"`
+	OfferCodes map[string]int    `json:"offer_codes,omitempty"` // sales per code
+	BestHour   *LaunchHour       `json:"best_hour,omitempty"`
+	Milestones []LaunchMilestone `json:"milestones"`
+}
+
+// LaunchProduct is one product's part of a launch, best sellers first
+type LaunchProduct struct {
+	Product     string  `json:"product"`
+	ProductName string  `json:"product_name,omitempty"`
+	Sales       int     `json:"sales"`
+	Refunds     int     `json:"refunds"`
+	Revenue     []Money `json:"revenue"`
+}
+
+// LaunchHour is the hour of the launch, counted from its start, with the
+// most sales
+type LaunchHour struct {
+	Start time.Time `json:"start"`
+	Sales int       `json:"sales"`
+}
+
+// LaunchStatus is whether a launch is running and how it is going
+type LaunchStatus struct {
+	Active bool          `json:"active"`
+	Report *LaunchReport `json:"report,omitempty"`
+}
+
+// LoadLaunchConfig reads and validates the launch mode file
+func LoadLaunchConfig(path string) (LaunchConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return LaunchConfig{}, fmt.Errorf("failed to read launch config: %v", err)
+	}
+
+	var config LaunchConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return LaunchConfig{}, fmt.Errorf("failed to parse launch config %s: %v", path, err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return LaunchConfig{}, fmt.Errorf("invalid launch config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate parses the thresholds and schedules and fills in defaults
+func (c *LaunchConfig) Validate() error {
+	if c.SalesEvery < 0 {
+		return fmt.Errorf("sales_every must not be negative")
+	}
+	if c.SalesEvery == 0 {
+		c.SalesEvery = defaultLaunchSalesEvery
+	}
+	if c.LiveMetricsEvery < 0 || (c.LiveMetricsEvery > 0 && c.LiveMetricsEvery < time.Second) {
+		return fmt.Errorf("live_metrics_every must be at least 1s")
+	}
+	if c.EndsAfter < 0 {
+		return fmt.Errorf("ends_after must not be negative")
+	}
+
+	c.revenue = make(map[string][]Money)
+	for currency, amounts := range c.Revenue {
+		for _, amount := range amounts {
+			threshold, err := ParseMoney(amount, currency)
+			if err != nil {
+				return fmt.Errorf("revenue %s: %v", currency, err)
+			}
+			c.revenue[threshold.Currency] = append(c.revenue[threshold.Currency], threshold)
+		}
+	}
+	for _, thresholds := range c.revenue {
+		sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].Amount < thresholds[j].Amount })
+	}
+	for id, schedule := range c.Jobs {
+		if _, err := ParseCron(schedule); err != nil {
+			return fmt.Errorf("jobs %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// LaunchMode is the command center for a launch day. While a launch runs it
+// counts the sales received, pushes a milestone every sales_every sales and
+// as revenue passes each threshold, shows milestones on the live feed and
+// sends its metrics more often, and runs the config's jobs on their faster
+// schedules. Stopping it puts the schedules back and writes the post-launch
+// report. Create it after RecordSales, which its totals are read from.
+type LaunchMode struct {
+	Config LaunchConfig
+
+	bridge    *GoBridge
+	sales     *SalesStore
+	scheduler *Scheduler
+	live      *LiveFeed
+	push      *PushNotifier
+	dir       string
+
+	mu        sync.Mutex
+	launch    *LaunchSession // nil while off
+	seen      map[string]bool
+	liveEvery time.Duration // the live feed's own interval, put back on Stop
+}
+
+// NewLaunchMode keeps its state and reports in dir, resuming a launch that
+// was running when the bridge stopped. The live feed and push notifier may
+// be nil.
+func NewLaunchMode(gb *GoBridge, sales *SalesStore, scheduler *Scheduler, live *LiveFeed, push *PushNotifier, config LaunchConfig, dir string) (*LaunchMode, error) {
+	lm := &LaunchMode{
+		Config:    config,
+		bridge:    gb,
+		sales:     sales,
+		scheduler: scheduler,
+		live:      live,
+		push:      push,
+		dir:       dir,
+		seen:      make(map[string]bool),
+	}
+	content, err := ioutil.ReadFile(lm.statePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read launch: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &lm.launch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse launch %s: %v", lm.statePath(), err)
+		}
+	}
+	if lm.launch != nil {
+		if lm.launch.Passed == nil {
+			lm.launch.Passed = make(map[string]int)
+		}
+		for _, id := range lm.launch.SaleIDs {
+			lm.seen[id] = true
+		}
+		lm.speedUpLive()
+		fmt.Printf("🚀 Resuming launch %s, %d sales in\n", lm.launch.Name, len(lm.launch.SaleIDs))
+	}
+	gb.OnReceive(lm.handleMessage)
+	return lm, nil
+}
+
+func (lm *LaunchMode) statePath() string {
+	return filepath.Join(lm.dir, "launch.json")
+}
+
+// Start begins a launch now
+func (lm *LaunchMode) Start(name string) (LaunchReport, error) {
+	if !languageName.MatchString(name) {
+		return LaunchReport{}, fmt.Errorf("launch name %q is not a lowercase name such as spring_course", name)
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.launch != nil {
+		return LaunchReport{}, fmt.Errorf("%w: %s", ErrLaunchRunning, lm.launch.Name)
+	}
+	now := lm.bridge.clock.Now()
+	launch := &LaunchSession{Name: name, StartedAt: now, SaleIDs: []string{}, Milestones: []LaunchMilestone{},
+		Passed: make(map[string]int), Schedules: make(map[string]string)}
+
+	ids := make([]string, 0, len(lm.Config.Jobs))
+	for id := range lm.Config.Jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		previous, err := lm.scheduler.Reschedule(id, lm.Config.Jobs[id])
+		if err != nil {
+			fmt.Printf("⚠️ Launch %s leaves job %s as it is: %v\n", name, id, err)
+			continue
+		}
+		launch.Schedules[id] = previous
+	}
+	if lm.Config.EndsAfter > 0 {
+		_, err := lm.scheduler.ScheduleOnce("launch_stop", "launch_stop", now.Add(lm.Config.EndsAfter),
+			map[string]interface{}{"started_at": now.Format(time.RFC3339Nano)})
+		if err != nil {
+			fmt.Printf("⚠️ Launch %s will not stop on its own: %v\n", name, err)
+		}
+	}
+
+	lm.launch = launch
+	lm.seen = make(map[string]bool)
+	lm.speedUpLive()
+	err := writeJSONFile(lm.statePath(), launch)
+	if err != nil {
+		log.Printf("❌ Error saving launch %s: %v", name, err)
+	}
+	fmt.Printf("🚀 Launch %s started\n", name)
+	return lm.reportLocked(now), nil
+}
+
+// speedUpLive sends live metrics at the launch's pace; call with the launch set
+func (lm *LaunchMode) speedUpLive() {
+	if lm.live == nil || lm.Config.LiveMetricsEvery == 0 {
+		return
+	}
+	lm.liveEvery = lm.live.metricsEvery()
+	lm.live.SetMetricsEvery(lm.Config.LiveMetricsEvery)
+}
+
+// Stop ends the running launch, restoring the job schedules and the live
+// feed's pace, and writes and returns its report
+func (lm *LaunchMode) Stop() (LaunchReport, error) {
+	lm.mu.Lock()
+	if lm.launch == nil {
+		lm.mu.Unlock()
+		return LaunchReport{}, ErrNoLaunch
+	}
+	now := lm.bridge.clock.Now()
+	report := lm.reportLocked(now)
+	report.EndedAt = &now
+
+	ids := make([]string, 0, len(lm.launch.Schedules))
+	for id := range lm.launch.Schedules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if _, err := lm.scheduler.Reschedule(id, lm.launch.Schedules[id]); err != nil {
+			log.Printf("❌ Error restoring the schedule of job %s: %v", id, err)
+		}
+	}
+	if lm.live != nil && lm.liveEvery > 0 {
+		lm.live.SetMetricsEvery(lm.liveEvery)
+	}
+	lm.launch = nil
+	err := os.Remove(lm.statePath())
+	lm.mu.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("❌ Error clearing launch %s: %v", report.Name, err)
+	}
+
+	path := filepath.Join(lm.dir, "reports", fmt.Sprintf("%s-%s.json", report.Name, report.StartedAt.UTC().Format("20060102T150405")))
+	err = writeJSONFile(path, report)
+	if err != nil {
+		return report, fmt.Errorf("failed to save launch report: %v", err)
+	}
+	fmt.Printf("🏁 Launch %s ended: %d sales, %s; report in %s\n", report.Name, report.Sales, revenueText(report.Revenue), path)
+	if lm.push != nil {
+		body := fmt.Sprintf("%d sales, %d refunded, %s over %s", report.Sales, report.Refunds, revenueText(report.Revenue), report.Duration)
+		if len(report.Products) > 0 {
+			best := report.Products[0]
+			if best.ProductName != "" {
+				body += "; best seller " + best.ProductName
+			} else {
+				body += "; best seller " + best.Product
+			}
+		}
+		lm.push.Notify(PushMilestone, "Launch report: "+report.Name, body)
+	}
+	return report, nil
+}
+
+// Status reports the running launch so far
+func (lm *LaunchMode) Status() LaunchStatus {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.launch == nil {
+		return LaunchStatus{}
+	}
+	report := lm.reportLocked(lm.bridge.clock.Now())
+	return LaunchStatus{Active: true, Report: &report}
+}
+
+// Reports returns the reports of finished launches, oldest first
+func (lm *LaunchMode) Reports() ([]LaunchReport, error) {
+	paths, err := filepath.Glob(filepath.Join(lm.dir, "reports", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]LaunchReport, 0, len(paths))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read launch report: %v", err)
+		}
+		var report LaunchReport
+		err = json.Unmarshal(content, &report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse launch report %s: %v", path, err)
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].StartedAt.Before(reports[j].StartedAt) })
+	return reports, nil
+}
+
+func (lm *LaunchMode) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+		return
+	}
+	sale, err := ParseSale(message.Payload)
+	if err != nil {
+		return
+	}
+
+	lm.mu.Lock()
+	if lm.launch == nil || lm.seen[sale.ID] {
+		lm.mu.Unlock()
+		return
+	}
+	lm.seen[sale.ID] = true
+	lm.launch.SaleIDs = append(lm.launch.SaleIDs, sale.ID)
+	milestones := lm.milestonesLocked(sale, lm.bridge.clock.Now())
+	lm.launch.Milestones = append(lm.launch.Milestones, milestones...)
+	err = writeJSONFile(lm.statePath(), lm.launch)
+	name := lm.launch.Name
+	lm.mu.Unlock()
+	if err != nil {
+		log.Printf("❌ Error saving launch %s: %v", name, err)
+	}
+
+	for _, milestone := range milestones {
+		fmt.Printf("🎉 %s\n", milestone.Text)
+		if lm.push != nil {
+			title := fmt.Sprintf("Launch milestone: %d sales", milestone.Sales)
+			if milestone.Revenue != nil {
+				title = "Launch milestone: " + milestone.Revenue.String()
+			}
+			lm.push.Notify(PushMilestone, title, milestone.Text)
+		}
+		if lm.live != nil {
+			milestone := milestone
+			lm.live.publish(LiveEvent{Type: LiveMilestoneEvent, At: milestone.At, Milestone: &milestone}, "")
+		}
+	}
+}
+
+// milestonesLocked returns the milestones the launch passed with this sale
+func (lm *LaunchMode) milestonesLocked(sale Sale, now time.Time) []LaunchMilestone {
+	report := lm.reportLocked(now)
+	var milestones []LaunchMilestone
+	if sale.BundleSaleID == "" && report.Sales%lm.Config.SalesEvery == 0 {
+		milestones = append(milestones, LaunchMilestone{Kind: LaunchSalesMilestone, At: now, Sales: report.Sales,
+			Text: fmt.Sprintf("Launch %s made %d sales, %s", report.Name, report.Sales, revenueText(report.Revenue))})
+	}
+	for _, revenue := range report.Revenue {
+		thresholds := lm.Config.revenue[revenue.Currency]
+		for lm.launch.Passed[revenue.Currency] < len(thresholds) {
+			threshold := thresholds[lm.launch.Passed[revenue.Currency]]
+			if revenue.Amount < threshold.Amount {
+				break
+			}
+			lm.launch.Passed[revenue.Currency]++
+			milestones = append(milestones, LaunchMilestone{Kind: LaunchRevenueMilestone, At: now, Sales: report.Sales, Revenue: &threshold,
+				Text: fmt.Sprintf("Launch %s passed %s in %d sales", report.Name, threshold, report.Sales)})
+		}
+	}
+	return milestones
+}
+
+// reportLocked totals the launch's sales as the store has them now. As on
+// the live feed, a bundle's sale is the buyer's purchase and its revenue its
+// components'; the components count toward their own products.
+func (lm *LaunchMode) reportLocked(now time.Time) LaunchReport {
+	launch := lm.launch
+	report := LaunchReport{
+		Name:       launch.Name,
+		StartedAt:  launch.StartedAt,
+		Duration:   now.Sub(launch.StartedAt).Round(time.Second).String(),
+		Revenue:    []Money{},
+		Products:   []LaunchProduct{},
+		Milestones: append([]LaunchMilestone{}, launch.Milestones...),
+	}
+	revenue := MoneyTotals{}
+	products := make(map[string]*LaunchProduct)
+	productRevenue := make(map[string]MoneyTotals)
+	hours := make(map[int]int)
+	for _, id := range launch.SaleIDs {
+		sale, ok := lm.sales.Sale(id)
+		if !ok {
+			continue
+		}
+		product := products[sale.Product]
+		if product == nil {
+			product = &LaunchProduct{Product: sale.Product, ProductName: sale.ProductName}
+			products[sale.Product] = product
+			productRevenue[sale.Product] = MoneyTotals{}
+		}
+		product.Sales++
+		if sale.Refunded {
+			product.Refunds++
+		} else if !sale.IsBundle() {
+			revenue.Add(sale.Price)
+			productRevenue[sale.Product].Add(sale.Price)
+		}
+		if sale.BundleSaleID != "" {
+			continue
+		}
+		report.Sales++
+		if sale.Refunded {
+			report.Refunds++
+		}
+		if sale.OfferCode != "" {
+			if report.OfferCodes == nil {
+				report.OfferCodes = make(map[string]int)
+			}
+			report.OfferCodes[sale.OfferCode]++
+		}
+		if !sale.CreatedAt.IsZero() {
+			hour := 0
+			if sale.CreatedAt.After(launch.StartedAt) {
+				hour = int(sale.CreatedAt.Sub(launch.StartedAt) / time.Hour)
+			}
+			hours[hour]++
+		}
+	}
+
+	report.Revenue = revenue.List()
+	for key, product := range products {
+		product.Revenue = productRevenue[key].List()
+		report.Products = append(report.Products, *product)
+	}
+	sort.Slice(report.Products, func(i, j int) bool {
+		a, b := report.Products[i], report.Products[j]
+		if a.Sales != b.Sales {
+			return a.Sales > b.Sales
+		}
+		return a.Product < b.Product
+	})
+	best := -1
+	for hour, sales := range hours {
+		// The earliest of equally good hours
+		if best < 0 || sales > hours[best] || (sales == hours[best] && hour < best) {
+			best = hour
+		}
+	}
+	if best >= 0 {
+		report.BestHour = &LaunchHour{Start: launch.StartedAt.Add(time.Duration(best) * time.Hour), Sales: hours[best]}
+	}
+	return report
+}
+
+// revenueText lists amounts for a notification, e.g. "120.00 USD and 40.00 EUR"
+func revenueText(revenue []Money) string {
+	if len(revenue) == 0 {
+		return "no revenue yet"
+	}
+	amounts := make([]string, len(revenue))
+	for i, amount := range revenue {
+		amounts[i] = amount.String()
+	}
+	return strings.Join(amounts, " and ")
+}
+
+// Handler serves the launch: GET /launch is its status, POST
+// /launch/start with {"name": ...} starts one, POST /launch/stop ends it
+// and returns the report, and GET /launch/reports lists past reports
+func (lm *LaunchMode) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/launch"), "/")
+		method := http.MethodPost
+		if rest == "" || rest == "reports" {
+			method = http.MethodGet
+		}
+		if rest != "" && rest != "reports" && rest != "start" && rest != "stop" {
+			writeAPIError(w, http.StatusNotFound, "use /launch, /launch/start, /launch/stop or /launch/reports")
+			return
+		}
+		if r.Method != method {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use "+method)
+			return
+		}
+
+		switch rest {
+		case "":
+			writeAPIJSON(w, http.StatusOK, lm.Status())
+		case "reports":
+			reports, err := lm.Reports()
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"reports": reports})
+		case "start":
+			var body struct {
+				Name string `json:"name"`
+			}
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad launch: %v", err))
+				return
+			}
+			report, err := lm.Start(body.Name)
+			if errors.Is(err, ErrLaunchRunning) {
+				writeAPIError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusCreated, report)
+		case "stop":
+			report, err := lm.Stop()
+			if errors.Is(err, ErrNoLaunch) {
+				writeAPIError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, report)
+		}
+	})
+}
+
+// RegisterLaunchJobs adds the launch_stop handler that ends a launch after
+// the config's ends_after. It only stops the launch that scheduled it.
+func RegisterLaunchJobs(s *Scheduler, lm *LaunchMode) {
+	s.Handle("launch_stop", func(job Job) error {
+		lm.mu.Lock()
+		running := lm.launch != nil && lm.launch.StartedAt.Format(time.RFC3339Nano) == stringArg(job.Args, "started_at")
+		lm.mu.Unlock()
+		if !running {
+			return nil
+		}
+		_, err := lm.Stop()
+		if errors.Is(err, ErrNoLaunch) {
+			return nil
+		}
+		return err
+	})
+}
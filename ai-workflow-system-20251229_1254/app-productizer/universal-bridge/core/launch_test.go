@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLaunchMode(t *testing.T) {
+	ntfy := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(ntfy.Close)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	push, err := NewPushNotifier(gb, PushConfig{Ntfy: &NtfyTarget{Topic: "shop", Server: ntfy.URL, Events: []string{PushMilestone}}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recorded as they are queued, since the sink delivers in the background
+	var pushed []string
+	push.sink.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		pushed = append(pushed, stringArg(message.Payload, "title"))
+		return message
+	})
+	live := NewLiveFeed(gb, sales)
+	t.Cleanup(live.Close)
+	scheduler := NewScheduler("", clock)
+	scheduler.Handle("site_feed", func(Job) error { return nil })
+	if _, err := scheduler.Schedule("site_feed", "site_feed", "0 * * * *", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadLaunchConfig("launch.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SalesEvery = 3
+	config.Validate()
+	dir := t.TempDir()
+	launch, err := NewLaunchMode(gb, sales, scheduler, live, push, config, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterLaunchJobs(scheduler, launch)
+	jobs := func() map[string]Job {
+		byID := make(map[string]Job)
+		for _, job := range scheduler.List() {
+			byID[job.ID] = job
+		}
+		return byID
+	}
+
+	ids := NewSequentialIDs("py")
+	sale := func(id, product string, price float64, extra map[string]interface{}) {
+		t.Helper()
+		payload := map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": product, "product_name": strings.Title(product),
+			"email": id + "@example.com", "price": price, "currency": "usd", "sale_timestamp": clock.Now().Format(time.RFC3339)}
+		for key, value := range extra {
+			payload[key] = value
+		}
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+
+	// Sales before the launch are not its own
+	sale("s0", "course", 100, nil)
+	if _, err := launch.Start("Spring course"); err == nil {
+		t.Error("started a launch with a name that is not a lowercase name")
+	}
+	if _, err := launch.Start("spring_course"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := launch.Start("spring_course"); err == nil {
+		t.Error("started a second launch")
+	}
+	if schedule := jobs()["site_feed"].Schedule; schedule != "* * * * *" {
+		t.Errorf("site_feed runs on %q during the launch", schedule)
+	}
+	if stop := jobs()["launch_stop"].RunAt; stop == nil || !stop.Equal(clock.Now().Add(24*time.Hour)) {
+		t.Errorf("launch stops on its own at %v", stop)
+	}
+	if every := live.metricsEvery(); every != 10*time.Second {
+		t.Errorf("live metrics every %s during the launch", every)
+	}
+
+	sale("s1", "course", 200, map[string]interface{}{"offer_code": "EARLY"})
+	sale("s2", "course", 200, map[string]interface{}{"offer_code": "EARLY"})
+	sale("s2", "course", 200, map[string]interface{}{"offer_code": "EARLY"}) // redelivered
+	clock.Advance(90 * time.Minute)
+	sale("s3", "templates", 50, nil)
+	sale("s4", "course", 200, nil)
+	sale("s5", "templates", 50, map[string]interface{}{"refunded": true})
+	sale("s6", "course", 200, nil)
+
+	want := []string{"Launch milestone: 3 sales", "Launch milestone: 500.00 USD", "Launch milestone: 6 sales"}
+	if !reflect.DeepEqual(pushed, want) {
+		t.Errorf("pushed %q, want %q", pushed, want)
+	}
+
+	// A restarted bridge carries on with the launch
+	reopened, err := NewLaunchMode(gb, sales, scheduler, nil, nil, config, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := reopened.Status(); !status.Active || status.Report.Sales != 6 || len(status.Report.Milestones) != 3 {
+		t.Fatalf("reopened %+v", status.Report)
+	}
+	reopened.mu.Lock()
+	reopened.launch = nil
+	reopened.mu.Unlock()
+
+	pushed = nil
+	clock.Advance(30 * time.Minute)
+	report, err := launch.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sales != 6 || report.Refunds != 1 || !reflect.DeepEqual(report.Revenue, []Money{NewMoney(85000, "usd")}) {
+		t.Errorf("report %d sales, %d refunds, %v", report.Sales, report.Refunds, report.Revenue)
+	}
+	products := []LaunchProduct{
+		{Product: "course", ProductName: "Course", Sales: 4, Revenue: []Money{NewMoney(80000, "usd")}},
+		{Product: "templates", ProductName: "Templates", Sales: 2, Refunds: 1, Revenue: []Money{NewMoney(5000, "usd")}},
+	}
+	if !reflect.DeepEqual(report.Products, products) {
+		t.Errorf("products %+v", report.Products)
+	}
+	if report.Duration != "2h0m0s" || report.OfferCodes["EARLY"] != 2 || report.BestHour == nil || report.BestHour.Sales != 4 {
+		t.Errorf("report %s, %v, %+v", report.Duration, report.OfferCodes, report.BestHour)
+	}
+	if len(pushed) != 1 || pushed[0] != "Launch report: spring_course" {
+		t.Errorf("pushed %q at the end", pushed)
+	}
+	if schedule := jobs()["site_feed"].Schedule; schedule != "0 * * * *" {
+		t.Errorf("site_feed runs on %q after the launch", schedule)
+	}
+	if every := live.metricsEvery(); every != time.Minute {
+		t.Errorf("live metrics every %s after the launch", every)
+	}
+
+	// Sales after it are not counted, and the report is kept
+	sale("s7", "course", 200, nil)
+	reports, err := launch.Reports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Sales != 6 || reports[0].EndedAt == nil {
+		t.Errorf("reports %+v", reports)
+	}
+	if _, err := launch.Stop(); err != ErrNoLaunch {
+		t.Errorf("stopping twice: %v", err)
+	}
+
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/launch", "", http.StatusOK},
+		{http.MethodPost, "/launch/stop", "", http.StatusConflict},
+		{http.MethodPost, "/launch/start", `{"name": "summer"}`, http.StatusCreated},
+		{http.MethodPost, "/launch/start", `{"name": "autumn"}`, http.StatusConflict},
+		{http.MethodGet, "/launch/start", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/launch/stop", "", http.StatusOK},
+		{http.MethodGet, "/launch/reports", "", http.StatusOK},
+		{http.MethodGet, "/launch/milestones", "", http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		launch.Handler().ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		if recorder.Code != test.status {
+			t.Errorf("%s %s answered %d, want %d: %s", test.method, test.path, recorder.Code, test.status, recorder.Body)
+		}
+	}
+}
+
+func TestInvalidLaunchConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LaunchConfig
+	}{
+		{"negative sales_every", LaunchConfig{SalesEvery: -1}},
+		{"live too fast", LaunchConfig{LiveMetricsEvery: time.Millisecond}},
+		{"negative ends_after", LaunchConfig{EndsAfter: -time.Hour}},
+		{"revenue", LaunchConfig{Revenue: map[string][]string{"usd": {"lots"}}}},
+		{"job schedule", LaunchConfig{Jobs: map[string]string{"site_feed": "every minute"}}},
+	}
+	for _, test := range tests {
+		if err := test.config.Validate(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LicenseCheck is the answer to whether a license key is good for a product.
+// Reason says why it is not. Stale is set when Gumroad could not be reached
+// and the answer is the last one it gave.
+type LicenseCheck struct {
+	ProductID   string    `json:"product_id"`
+	Valid       bool      `json:"valid"`
+	Reason      string    `json:"reason,omitempty"`
+	Uses        int       `json:"uses"`
+	ProductName string    `json:"product_name,omitempty"`
+	Quantity    int       `json:"quantity,omitempty"`
+	Subscribed  bool      `json:"subscribed"` // the key belongs to a subscription
+	CheckedAt   time.Time `json:"checked_at"`
+	Cached      bool      `json:"cached"`
+	Stale       bool      `json:"stale,omitempty"`
+}
+
+// licenseCacheEntry is a check, the purchase it was for, and when it stops
+// being fresh
+type licenseCacheEntry struct {
+	check          LicenseCheck
+	saleID         string
+	subscriptionID string
+	expires        time.Time
+}
+
+// LicenseVerifier checks license keys with Gumroad's license API for the
+// apps the seller sells, caching each answer for TTL so an app checking on
+// every launch does not cost a Gumroad request each time. Only keys Gumroad
+// knows a purchase for are cached, at most MaxEntries of them, and a
+// refund, dispute or subscription ping for the purchase drops its entry.
+// Activations, which count a use, always go to Gumroad and fail when it
+// cannot be reached. When a plain check cannot reach Gumroad, the last
+// answer for the key is given again, marked stale.
+type LicenseVerifier struct {
+	TTL        time.Duration
+	MaxEntries int
+
+	client *GumroadClient
+	clock  Clock
+
+	mu    sync.Mutex
+	cache map[string]licenseCacheEntry // product + key → check
+}
+
+// NewLicenseVerifier checks keys with the client, caching answers for an
+// hour until the bridge receives an event for the purchase
+func NewLicenseVerifier(gb *GoBridge, client *GumroadClient) *LicenseVerifier {
+	lv := &LicenseVerifier{
+		TTL:        time.Hour,
+		MaxEntries: 10000,
+		client:     client,
+		clock:      gb.clock,
+		cache:      make(map[string]licenseCacheEntry),
+	}
+	gb.OnReceive(lv.handleMessage)
+	return lv
+}
+
+// VerifyLicense reports whether the key is good for the product, from the
+// cache when it has a fresh answer
+func (lv *LicenseVerifier) VerifyLicense(productID, licenseKey string) (LicenseCheck, error) {
+	return lv.check(productID, licenseKey, false)
+}
+
+// Activate verifies the key and counts a use of it, for apps that limit how
+// many machines a key activates
+func (lv *LicenseVerifier) Activate(productID, licenseKey string) (LicenseCheck, error) {
+	return lv.check(productID, licenseKey, true)
+}
+
+func (lv *LicenseVerifier) check(productID, licenseKey string, increment bool) (LicenseCheck, error) {
+	if productID == "" || licenseKey == "" {
+		return LicenseCheck{}, fmt.Errorf("a product_id and license_key are needed")
+	}
+	key := productID + "\x00" + licenseKey
+	now := lv.clock.Now()
+
+	lv.mu.Lock()
+	entry, cached := lv.cache[key]
+	lv.mu.Unlock()
+	if cached && !increment && now.Before(entry.expires) {
+		check := entry.check
+		check.Cached = true
+		return check, nil
+	}
+
+	license, err := lv.client.VerifyLicense(productID, licenseKey, increment)
+	check := LicenseCheck{ProductID: productID, CheckedAt: now}
+	switch {
+	case errors.Is(err, ErrGumroadNotFound):
+		// Not cached, so made-up keys cannot fill the cache
+		lv.forget(key)
+		check.Reason = "unknown license key"
+		return check, nil
+	case err != nil:
+		if cached && !increment {
+			log.Printf("❌ Gumroad license check failed, answering from cache: %v", err)
+			check := entry.check
+			check.Cached = true
+			check.Stale = true
+			return check, nil
+		}
+		return LicenseCheck{}, fmt.Errorf("failed to verify license: %v", err)
+	}
+
+	purchase := license.Purchase
+	check.Valid = license.Valid()
+	check.Uses = license.Uses
+	check.ProductName = purchase.ProductName
+	check.Quantity = purchase.Quantity
+	check.Subscribed = purchase.SubscriptionID != ""
+	switch {
+	case purchase.Refunded:
+		check.Reason = "refunded"
+	case purchase.Chargebacked:
+		check.Reason = "charged back"
+	case purchase.Disputed:
+		check.Reason = "disputed"
+	case purchase.SubscriptionEndedAt != "" || purchase.SubscriptionCancelledAt != "" || purchase.SubscriptionFailedAt != "":
+		check.Reason = "subscription inactive"
+	}
+
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if _, ok := lv.cache[key]; !ok && len(lv.cache) >= lv.MaxEntries {
+		lv.evictLocked(now)
+	}
+	lv.cache[key] = licenseCacheEntry{
+		check:          check,
+		saleID:         purchase.SaleID,
+		subscriptionID: purchase.SubscriptionID,
+		expires:        now.Add(lv.TTL),
+	}
+	return check, nil
+}
+
+// evictLocked makes room for an entry: expired entries go, or failing
+// that the one closest to expiring
+func (lv *LicenseVerifier) evictLocked(now time.Time) {
+	oldest := ""
+	for key, entry := range lv.cache {
+		if !now.Before(entry.expires) {
+			delete(lv.cache, key)
+			continue
+		}
+		if oldest == "" || entry.expires.Before(lv.cache[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(lv.cache) >= lv.MaxEntries && oldest != "" {
+		delete(lv.cache, oldest)
+	}
+}
+
+func (lv *LicenseVerifier) forget(key string) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	delete(lv.cache, key)
+}
+
+// handleMessage drops the cached checks of a purchase Gumroad sent an event
+// for, since a refund, dispute or subscription change can make a valid key
+// invalid; the next check asks Gumroad
+func (lv *LicenseVerifier) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+	saleID := stringArg(message.Payload, "sale_id")
+	subscriptionID := stringArg(message.Payload, "subscription_id")
+	if saleID == "" && subscriptionID == "" {
+		return
+	}
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	for key, entry := range lv.cache {
+		if (saleID != "" && entry.saleID == saleID) || (subscriptionID != "" && entry.subscriptionID == subscriptionID) {
+			delete(lv.cache, key)
+		}
+	}
+}
+
+// Handler serves /licenses/verify for sold apps: product_id and license_key
+// as query or form parameters, and increment_uses_count=true to count an
+// activation. It answers 200 with the check whether or not the key is valid.
+func (lv *LicenseVerifier) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET or POST")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-store")
+
+		product := r.FormValue("product_id")
+		licenseKey := r.FormValue("license_key")
+		if product == "" || licenseKey == "" {
+			writeAPIError(w, http.StatusBadRequest, "product_id and license_key are required")
+			return
+		}
+		increment, _ := strconv.ParseBool(r.FormValue("increment_uses_count"))
+		if increment && r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "activate with POST")
+			return
+		}
+
+		var check LicenseCheck
+		var err error
+		if increment {
+			check, err = lv.Activate(product, licenseKey)
+		} else {
+			check, err = lv.VerifyLicense(product, licenseKey)
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, check)
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLicenseAPI answers /licenses/verify for the keys it knows, counting
+// the calls made to it
+type fakeLicenseAPI struct {
+	mu       sync.Mutex
+	refunded map[string]bool // key → refunded
+	down     bool
+	calls    int
+}
+
+func (f *fakeLicenseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	key := r.FormValue("license_key")
+	refunded, known := f.refunded[key]
+	switch {
+	case f.down:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"success":false,"message":"unavailable"}`)
+	case !known:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success":false,"message":"That license does not exist"}`)
+	default:
+		fmt.Fprintf(w, `{"success":true,"uses":1,"purchase":{"sale_id":"sale-%s","product_id":"p1","refunded":%t}}`, key, refunded)
+	}
+}
+
+func (f *fakeLicenseAPI) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func newTestLicenses(t *testing.T) (*LicenseVerifier, *fakeLicenseAPI, *GoBridge, *ManualClock) {
+	t.Helper()
+	api := &fakeLicenseAPI{refunded: map[string]bool{"good": false, "other": false}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("lic")))
+	t.Cleanup(func() { gb.Close() })
+	client := NewGumroadClient()
+	client.BaseURL = server.URL
+	client.AccessToken = "token"
+	client.Retries = 0
+	return NewLicenseVerifier(gb, client), api, gb, clock
+}
+
+func TestLicenseCache(t *testing.T) {
+	t.Run("answers from cache until the TTL", func(t *testing.T) {
+		lv, api, _, clock := newTestLicenses(t)
+		lv.VerifyLicense("p1", "good")
+		check, _ := lv.VerifyLicense("p1", "good")
+		if !check.Valid || !check.Cached || api.calls != 1 {
+			t.Fatalf("second check %+v after %d calls, want a cached valid answer", check, api.calls)
+		}
+		clock.Advance(lv.TTL)
+		if check, _ := lv.VerifyLicense("p1", "good"); check.Cached || api.calls != 2 {
+			t.Fatalf("check after the TTL %+v", check)
+		}
+	})
+
+	t.Run("unknown keys are not cached", func(t *testing.T) {
+		lv, _, _, _ := newTestLicenses(t)
+		for i := 0; i < 5; i++ {
+			check, err := lv.VerifyLicense("p1", fmt.Sprintf("made-up-%d", i))
+			if err != nil || check.Valid || check.Reason != "unknown license key" {
+				t.Fatalf("check of an unknown key = %+v, %v", check, err)
+			}
+		}
+		if len(lv.cache) != 0 {
+			t.Fatalf("%d unknown keys cached", len(lv.cache))
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		lv, api, _, clock := newTestLicenses(t)
+		lv.MaxEntries = 1
+		lv.VerifyLicense("p1", "good")
+		clock.Advance(time.Minute)
+		lv.VerifyLicense("p1", "other")
+		if len(lv.cache) != 1 {
+			t.Fatalf("%d entries cached, want 1", len(lv.cache))
+		}
+		if check, _ := lv.VerifyLicense("p1", "other"); !check.Cached || api.calls != 2 {
+			t.Fatalf("newest entry was evicted: %+v", check)
+		}
+	})
+
+	t.Run("refund ping evicts", func(t *testing.T) {
+		lv, api, gb, _ := newTestLicenses(t)
+		lv.VerifyLicense("p1", "good")
+		api.set(func() { api.refunded["good"] = true })
+		refund := gb.NewMessage(SaleEvent, "go", map[string]interface{}{"resource_name": "refund", "sale_id": "sale-good"}, HTTP)
+		if err := gb.handleIncomingMessage(refund); err != nil {
+			t.Fatal(err)
+		}
+		check, _ := lv.VerifyLicense("p1", "good")
+		if check.Valid || check.Cached || check.Reason != "refunded" {
+			t.Fatalf("check after the refund %+v, want Gumroad's refunded answer", check)
+		}
+	})
+
+	t.Run("stale answer only for plain checks", func(t *testing.T) {
+		lv, api, _, clock := newTestLicenses(t)
+		lv.VerifyLicense("p1", "good")
+		clock.Advance(lv.TTL)
+		api.set(func() { api.down = true })
+		if check, err := lv.VerifyLicense("p1", "good"); err != nil || !check.Stale {
+			t.Fatalf("VerifyLicense with Gumroad down = %+v, %v; want a stale answer", check, err)
+		}
+		if check, err := lv.Activate("p1", "good"); err == nil {
+			t.Fatalf("Activate with Gumroad down = %+v, want an error", check)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// BridgeState is where a GoBridge is in its lifecycle
+type BridgeState string
+
+const (
+	// BridgeIdle bridges have not started receiving; sends fail
+	BridgeIdle BridgeState = "idle"
+	// BridgeRunning bridges receive, handle and send
+	BridgeRunning BridgeState = "running"
+	// BridgeDraining bridges have stopped receiving and are finishing the
+	// messages in flight; handlers may still send replies
+	BridgeDraining BridgeState = "draining"
+	// BridgeClosed bridges are done and cannot be started again
+	BridgeClosed BridgeState = "closed"
+)
+
+var (
+	// ErrBridgeNotStarted is returned by sends before Start
+	ErrBridgeNotStarted = errors.New("bridge not started")
+	// ErrBridgeClosed is returned by sends and Start once the bridge closed
+	ErrBridgeClosed = errors.New("bridge closed")
+)
+
+// WithManualStart leaves the bridge idle until Start, so a service can
+// register handlers and sinks before the first message arrives
+func WithManualStart() BridgeOption {
+	return func(gb *GoBridge) {
+		gb.manualStart = true
+	}
+}
+
+// State reports where the bridge is in its lifecycle
+func (gb *GoBridge) State() BridgeState {
+	gb.mu.RLock()
+	defer gb.mu.RUnlock()
+	return gb.state
+}
+
+// Done is closed once the bridge has finished closing
+func (gb *GoBridge) Done() <-chan struct{} {
+	return gb.closed
+}
+
+// Start begins receiving. Cancelling ctx stops the bridge as Stop would,
+// without a deadline. Starting a running bridge does nothing.
+func (gb *GoBridge) Start(ctx context.Context) error {
+	switch gb.State() {
+	case BridgeRunning:
+		return nil
+	case BridgeDraining, BridgeClosed:
+		return ErrBridgeClosed
+	}
+	err := gb.connect()
+	if err != nil {
+		return err
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				gb.Close()
+			case <-gb.closed:
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop closes the bridge, draining in-flight messages, and returns ctx's
+// error if it ends first. The drain carries on in the background, and
+// Done is closed when it finishes.
+func (gb *GoBridge) Stop(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() { result <- gb.Close() }()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("bridge still draining: %w", ctx.Err())
+	}
+}
+
+// Run starts the bridge if it is idle and serves until SIGINT, SIGTERM or
+// ctx ends, then drains it
+func (gb *GoBridge) Run(ctx context.Context) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if gb.State() == BridgeIdle {
+		err := gb.Start(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case sig := <-signals:
+		fmt.Printf("🛑 Received %v\n", sig)
+	case <-ctx.Done():
+	case <-gb.closed:
+	}
+	return gb.Close()
+}
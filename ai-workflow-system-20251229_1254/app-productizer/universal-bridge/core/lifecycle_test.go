@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBridgeLifecycleDrainsInFlightMessages(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithManualStart())
+	t.Cleanup(func() { gb.Close() })
+
+	if state := gb.State(); state != BridgeIdle {
+		t.Fatalf("state %s before Start", state)
+	}
+	if _, err := gb.SendMessage(gb.NewMessage(HealthCheck, "python", nil, SharedMemory)); !errors.Is(err, ErrBridgeNotStarted) {
+		t.Fatalf("send before Start = %v", err)
+	}
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	replyErr := make(chan error, 1)
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		close(entered)
+		<-release
+		_, err := gb.SendMessage(gb.NewMessage(HealthCheck, "python", nil, SharedMemory))
+		replyErr <- err
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := gb.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := gb.Start(ctx); err != nil {
+		t.Fatalf("second Start = %v", err)
+	}
+
+	handled := make(chan error, 1)
+	go func() {
+		handled <- transport.Inject(newUniversalMessage(clock, NewSequentialIDs("py"), DataSync, "python", "go", map[string]interface{}{}, SharedMemory))
+	}()
+	<-entered
+
+	// Cancelling the start context stops the bridge, draining the message in flight
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for gb.State() != BridgeDraining && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if state := gb.State(); state != BridgeDraining {
+		t.Fatalf("state %s after cancel", state)
+	}
+	short, stop := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer stop()
+	if err := gb.Stop(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop with a handler still running = %v", err)
+	}
+	if err := transport.Inject(newUniversalMessage(clock, NewSequentialIDs("late"), DataSync, "python", "go", map[string]interface{}{}, SharedMemory)); err == nil {
+		t.Fatal("a draining bridge accepted a new message")
+	}
+
+	close(release)
+	<-gb.Done()
+	if err := <-handled; err != nil {
+		t.Fatalf("in-flight message nacked: %v", err)
+	}
+	if err := <-replyErr; err != nil {
+		t.Fatalf("reply sent while draining failed: %v", err)
+	}
+	if len(transport.SentOfType(HealthCheck)) != 1 {
+		t.Fatalf("sent %v", transport.Sent())
+	}
+
+	if state := gb.State(); state != BridgeClosed {
+		t.Fatalf("state %s after drain", state)
+	}
+	if err := gb.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+	if err := gb.Start(context.Background()); !errors.Is(err, ErrBridgeClosed) {
+		t.Fatalf("Start after Close = %v", err)
+	}
+	if _, err := gb.SendMessage(gb.NewMessage(HealthCheck, "python", nil, SharedMemory)); !errors.Is(err, ErrBridgeClosed) {
+		t.Fatalf("send after Close = %v", err)
+	}
+}
+
+func TestBridgeRunStopsWithContext(t *testing.T) {
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()), WithManualStart())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- gb.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for gb.State() != BridgeRunning && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if state := gb.State(); state != BridgeClosed {
+		t.Fatalf("state %s after Run", state)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPayloadTooLarge matches every PayloadTooLargeError with errors.Is
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// PayloadTooLargeError reports a message whose encoding is over the limit
+// for its channel. Large content such as file drops should be sent as a
+// chunked stream, or as a reference to the file, rather than one message.
+type PayloadTooLargeError struct {
+	MessageID string // empty when the message could not be decoded
+	Channel   CommunicationChannel
+	Size      int64 // encoded size in bytes, -1 if reading stopped at the limit
+	Limit     int64
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	size := "more than the"
+	if e.Size >= 0 {
+		size = fmt.Sprintf("%d bytes, over the", e.Size)
+	}
+	subject := "message"
+	if e.MessageID != "" {
+		subject = "message " + e.MessageID
+	}
+	channel := ""
+	if e.Channel != "" {
+		channel = " for " + string(e.Channel)
+	}
+	return fmt.Sprintf("%s is %s %d byte limit%s; send large content as chunked streams instead", subject, size, e.Limit, channel)
+}
+
+// Is makes errors.Is(err, ErrPayloadTooLarge) true
+func (e *PayloadTooLargeError) Is(target error) bool {
+	return target == ErrPayloadTooLarge
+}
+
+// limitFor returns a channel's size cap: its ChannelMaxBytes entry if set,
+// otherwise MaxMessageBytes
+func (p *Pipeline) limitFor(channel CommunicationChannel) int64 {
+	if limit, ok := p.channelBytes[channel]; ok && limit > 0 {
+		return limit
+	}
+	return p.maxBytes
+}
+
+// EncodedSize returns the length of the message's JSON encoding
+func (m *UniversalMessage) EncodedSize() (int64, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := m.encodeIndented(buf)
+	if err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+// CheckPayloadSize fails with a PayloadTooLargeError if the message is over
+// the limit of the channel it would be sent on, the same limit the receiving
+// side enforces
+func (gb *GoBridge) CheckPayloadSize(message *UniversalMessage) error {
+	return gb.checkPayloadSize(message, gb.transportFor(message).Channel())
+}
+
+func (gb *GoBridge) checkPayloadSize(message *UniversalMessage, channel CommunicationChannel) error {
+	limit := gb.pipeline.limitFor(channel)
+	size, err := message.EncodedSize()
+	if err != nil {
+		return err
+	}
+	if size > limit {
+		return &PayloadTooLargeError{MessageID: message.ID, Channel: channel, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// BuildMessage is NewMessage for payloads that might be large: it fails
+// with a PayloadTooLargeError instead of returning a message SendMessage
+// would refuse
+func (gb *GoBridge) BuildMessage(messageType MessageType, targetLanguage string, payload map[string]interface{}, responseChannel CommunicationChannel) (*UniversalMessage, error) {
+	message := gb.NewMessage(messageType, targetLanguage, payload, responseChannel)
+	err := gb.CheckPayloadSize(message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
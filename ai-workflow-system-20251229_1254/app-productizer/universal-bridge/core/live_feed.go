@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveTokenEnv holds a token that opens only the live feed, for overlays
+// whose URL is pasted into streaming software
+const liveTokenEnv = "BRIDGE_LIVE_TOKEN"
+
+// Live feed event types
+const (
+	LiveSaleEvent    = "sale"
+	LiveRefundEvent  = "refund"
+	LiveMetricsEvent = "metrics"
+	// LiveMilestoneEvent is a launch milestone, sent only in launch mode
+	LiveMilestoneEvent = "milestone"
+)
+
+// maxLiveSeen bounds how many sale and refund keys are remembered to keep
+// Gumroad's redelivered pings from popping up twice
+const maxLiveSeen = 10000
+
+// LiveEvent is one JSON text message on the live feed. Sale, Refund,
+// Metrics or Milestone is set to match Type.
+type LiveEvent struct {
+	Type      string           `json:"type"`
+	At        time.Time        `json:"at"`
+	Sale      *LiveSale        `json:"sale,omitempty"`
+	Refund    *LiveRefund      `json:"refund,omitempty"`
+	Metrics   *LiveMetrics     `json:"metrics,omitempty"`
+	Milestone *LaunchMilestone `json:"milestone,omitempty"`
+}
+
+// LiveSale is a new sale as an overlay shows it. The buyer is named by first
+// name only and never by email, since overlays are shown on stream.
+type LiveSale struct {
+	SaleID      string `json:"sale_id"`
+	Product     string `json:"product"`
+	ProductName string `json:"product_name,omitempty"`
+	Price       Money  `json:"price"`
+	Display     string `json:"display"` // the price formatted, e.g. "29.99 USD"
+	Buyer       string `json:"buyer,omitempty"`
+	OfferCode   string `json:"offer_code,omitempty"`
+	Gift        bool   `json:"gift,omitempty"`
+}
+
+// LiveRefund is a full or partial refund of a sale
+type LiveRefund struct {
+	SaleID      string `json:"sale_id"`
+	Product     string `json:"product"`
+	ProductName string `json:"product_name,omitempty"`
+	Amount      Money  `json:"amount"`
+	Display     string `json:"display"`
+	Partial     bool   `json:"partial,omitempty"`
+}
+
+// LiveMetrics is a snapshot of the store's sales, sent on connect, after
+// every sale or refund and every LiveFeed.MetricsEvery
+type LiveMetrics struct {
+	Today    LiveTotals `json:"today"`
+	LastHour LiveTotals `json:"last_hour"`
+	AllTime  LiveTotals `json:"all_time"`
+}
+
+// LiveTotals counts the sales made in a window; Refunds is how many of them
+// have since been refunded, and Revenue what the rest took per currency
+type LiveTotals struct {
+	Sales   int     `json:"sales"`
+	Refunds int     `json:"refunds"`
+	Revenue []Money `json:"revenue"`
+}
+
+// LiveFeed streams sale, refund and metrics events to WebSocket clients as
+// the bridge receives Gumroad pings, for the seller's dashboards and stream
+// overlays. Clients choose events with ?events=sale,refund,metrics,milestone
+// and a product with ?product=; the feed never replays what they missed.
+type LiveFeed struct {
+	// MetricsEvery is how often a metrics snapshot is sent between sales;
+	// change it with SetMetricsEvery once clients may be connected
+	MetricsEvery time.Duration
+	// PingEvery is how often clients are pinged, keeping proxies from
+	// closing quiet connections
+	PingEvery time.Duration
+	// Buffer is how many events a client may fall behind before it is
+	// disconnected
+	Buffer int
+	// Location is the timezone today's totals are counted in
+	Location *time.Location
+
+	bridge *GoBridge
+	sales  *SalesStore
+
+	mu        sync.Mutex
+	clients   map[*liveClient]bool
+	seen      map[string]bool
+	seenOrder []string
+	closed    bool
+	tickOnce  sync.Once
+	retick    chan struct{} // wakes tick when MetricsEvery changes
+	stop      chan struct{}
+}
+
+// liveClient is one connected WebSocket and the events queued for it
+type liveClient struct {
+	ws       *webSocket
+	events   map[string]bool
+	product  string
+	queue    chan []byte
+	gone     chan struct{}
+	goneOnce sync.Once
+	code     uint16 // close code, once gone
+	reason   string
+}
+
+// NewLiveFeed creates the feed and registers it to see every received
+// message; register it after RecordSales so its metrics count each sale
+func NewLiveFeed(gb *GoBridge, sales *SalesStore) *LiveFeed {
+	lf := &LiveFeed{
+		MetricsEvery: time.Minute,
+		PingEvery:    30 * time.Second,
+		Buffer:       64,
+		Location:     time.Local,
+		bridge:       gb,
+		sales:        sales,
+		clients:      make(map[*liveClient]bool),
+		seen:         make(map[string]bool),
+		retick:       make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+	gb.OnReceive(lf.receive)
+	return lf
+}
+
+func (lf *LiveFeed) receive(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+	now := lf.bridge.clock.Now()
+	switch stringArg(message.Payload, "resource_name") {
+	case "sale":
+		sale, err := ParseSale(message.Payload)
+		// A bundle's component sales are part of the bundle's own sale
+		if err != nil || sale.BundleSaleID != "" || !lf.firstSight("sale:"+sale.ID) {
+			return
+		}
+		lf.publish(LiveEvent{Type: LiveSaleEvent, At: now, Sale: &LiveSale{
+			SaleID:      sale.ID,
+			Product:     sale.Product,
+			ProductName: sale.ProductName,
+			Price:       sale.Price,
+			Display:     sale.Price.String(),
+			Buyer:       firstName(sale.FullName),
+			OfferCode:   sale.OfferCode,
+			Gift:        sale.Gift,
+		}}, sale.Product, sale.Permalink)
+	case "refund":
+		sale, err := ParseSale(message.Payload)
+		if err != nil {
+			return
+		}
+		refund, err := ParseRefund(message.Payload, now)
+		if err != nil || !lf.firstSight(fmt.Sprintf("refund:%s:%d", refund.SaleID, refund.Amount.Amount)) {
+			return
+		}
+		lf.publish(LiveEvent{Type: LiveRefundEvent, At: now, Refund: &LiveRefund{
+			SaleID:      refund.SaleID,
+			Product:     sale.Product,
+			ProductName: sale.ProductName,
+			Amount:      refund.Amount,
+			Display:     refund.Amount.String(),
+			Partial:     refund.Partial,
+		}}, sale.Product, sale.Permalink)
+	default:
+		return
+	}
+	lf.publish(lf.metricsEvent(), "")
+}
+
+// firstSight reports whether the key is new, remembering it
+func (lf *LiveFeed) firstSight(key string) bool {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.seen[key] {
+		return false
+	}
+	lf.seen[key] = true
+	lf.seenOrder = append(lf.seenOrder, key)
+	if len(lf.seenOrder) > maxLiveSeen {
+		delete(lf.seen, lf.seenOrder[0])
+		lf.seenOrder = lf.seenOrder[1:]
+	}
+	return true
+}
+
+// firstName is the first word of a buyer's full name
+func firstName(fullName string) string {
+	if fields := strings.Fields(fullName); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// Metrics totals the store's sales today, in the last hour and ever
+func (lf *LiveFeed) Metrics() LiveMetrics {
+	now := lf.bridge.clock.Now().In(lf.Location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, lf.Location)
+	hourAgo := now.Add(-time.Hour)
+
+	windows := []struct {
+		since   time.Time
+		totals  *LiveTotals
+		revenue MoneyTotals
+	}{{midnight, new(LiveTotals), MoneyTotals{}}, {hourAgo, new(LiveTotals), MoneyTotals{}}, {time.Time{}, new(LiveTotals), MoneyTotals{}}}
+	for _, sale := range lf.sales.All() {
+		for _, window := range windows {
+			if !window.since.IsZero() && sale.CreatedAt.Before(window.since) {
+				continue
+			}
+			// As for products, a bundle's revenue is its components' and
+			// its sale the buyer's purchase
+			if sale.BundleSaleID == "" {
+				window.totals.Sales++
+				if sale.Refunded {
+					window.totals.Refunds++
+				}
+			}
+			if !sale.Refunded && !sale.IsBundle() {
+				window.revenue.Add(sale.Price)
+			}
+		}
+	}
+	for _, window := range windows {
+		window.totals.Revenue = window.revenue.List()
+	}
+	return LiveMetrics{Today: *windows[0].totals, LastHour: *windows[1].totals, AllTime: *windows[2].totals}
+}
+
+func (lf *LiveFeed) metricsEvent() LiveEvent {
+	metrics := lf.Metrics()
+	return LiveEvent{Type: LiveMetricsEvent, At: lf.bridge.clock.Now(), Metrics: &metrics}
+}
+
+// publish queues the event for every client that wants it. The products
+// are the keys a ?product= filter may match; metrics and milestones match
+// every filter.
+// A client whose queue is full is disconnected rather than held up for.
+func (lf *LiveFeed) publish(event LiveEvent, products ...string) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("⚠️ Not sending live %s event: %v\n", event.Type, err)
+		return
+	}
+
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	for client := range lf.clients {
+		if !client.wants(event.Type, products) {
+			continue
+		}
+		select {
+		case client.queue <- encoded:
+		default:
+			delete(lf.clients, client)
+			client.leave(wsCloseTryAgain, "too slow to keep up with the feed")
+		}
+	}
+}
+
+func (c *liveClient) wants(eventType string, products []string) bool {
+	if !c.events[eventType] {
+		return false
+	}
+	if c.product == "" || eventType == LiveMetricsEvent || eventType == LiveMilestoneEvent {
+		return true
+	}
+	for _, product := range products {
+		if product != "" && product == c.product {
+			return true
+		}
+	}
+	return false
+}
+
+// leave tells the client's goroutines to close with the code; the first
+// code given is the one sent
+func (c *liveClient) leave(code uint16, reason string) {
+	c.goneOnce.Do(func() {
+		c.code, c.reason = code, reason
+		close(c.gone)
+	})
+}
+
+// Handler upgrades GET requests to a WebSocket and streams events to it
+// until either side closes
+func (lf *LiveFeed) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		events := map[string]bool{LiveSaleEvent: true, LiveRefundEvent: true, LiveMetricsEvent: true, LiveMilestoneEvent: true}
+		if list := query.Get("events"); list != "" {
+			events = make(map[string]bool)
+			for _, name := range strings.Split(list, ",") {
+				name = strings.TrimSpace(name)
+				if name != LiveSaleEvent && name != LiveRefundEvent && name != LiveMetricsEvent && name != LiveMilestoneEvent {
+					writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q; use sale, refund, metrics or milestone", name))
+					return
+				}
+				events[name] = true
+			}
+		}
+
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		client := &liveClient{ws: ws, events: events, product: query.Get("product"),
+			queue: make(chan []byte, lf.Buffer+1), gone: make(chan struct{})}
+		if events[LiveMetricsEvent] {
+			if encoded, err := json.Marshal(lf.metricsEvent()); err == nil {
+				client.queue <- encoded
+			}
+		}
+		if !lf.join(client) {
+			ws.Close(wsCloseGoingAway, "the bridge is shutting down")
+			return
+		}
+		lf.tickOnce.Do(func() { go lf.tick() })
+		fmt.Printf("📡 Live feed client connected from %s\n", r.RemoteAddr)
+		go lf.read(client)
+		lf.write(client)
+
+		lf.mu.Lock()
+		delete(lf.clients, client)
+		lf.mu.Unlock()
+		ws.Close(client.code, client.reason)
+		fmt.Printf("📡 Live feed client %s disconnected: %s\n", r.RemoteAddr, client.reason)
+	})
+}
+
+func (lf *LiveFeed) join(client *liveClient) bool {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return false
+	}
+	lf.clients[client] = true
+	return true
+}
+
+// write sends queued events and pings until the client is gone
+func (lf *LiveFeed) write(client *liveClient) {
+	ping := time.NewTicker(lf.PingEvery)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-client.gone:
+			return
+		case encoded := <-client.queue:
+			err = client.ws.WriteText(encoded)
+		case <-ping.C:
+			err = client.ws.writeFrame(wsPing, nil)
+		}
+		if err != nil {
+			client.leave(wsCloseGoingAway, fmt.Sprintf("write failed: %v", err))
+			return
+		}
+	}
+}
+
+// read answers pings and the client's close. The feed takes no messages,
+// so data frames are read and dropped.
+func (lf *LiveFeed) read(client *liveClient) {
+	for {
+		opcode, payload, err := client.ws.ReadFrame()
+		switch {
+		case err == errWebSocketProtocol:
+			client.leave(wsCloseProtocol, err.Error())
+			return
+		case err == errWebSocketTooBig:
+			client.leave(wsCloseTooBig, err.Error())
+			return
+		case err != nil:
+			client.leave(wsCloseGoingAway, "connection lost")
+			return
+		}
+		switch opcode {
+		case wsPing:
+			client.ws.writeFrame(wsPong, payload)
+		case wsClose:
+			// Echo the client's code, as the closing handshake expects
+			code := uint16(wsCloseNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			client.leave(code, "closed by the client")
+			return
+		}
+	}
+}
+
+// tick sends a metrics snapshot every MetricsEvery while clients are connected
+func (lf *LiveFeed) tick() {
+	for {
+		select {
+		case <-lf.stop:
+			return
+		case <-lf.retick:
+			continue
+		case <-lf.bridge.clock.After(lf.metricsEvery()):
+		}
+		lf.mu.Lock()
+		connected := len(lf.clients)
+		lf.mu.Unlock()
+		if connected > 0 {
+			lf.publish(lf.metricsEvent(), "")
+		}
+	}
+}
+
+// SetMetricsEvery changes how often metrics snapshots are sent, starting
+// the next wait from now
+func (lf *LiveFeed) SetMetricsEvery(every time.Duration) {
+	lf.mu.Lock()
+	lf.MetricsEvery = every
+	lf.mu.Unlock()
+	select {
+	case lf.retick <- struct{}{}:
+	default:
+	}
+}
+
+func (lf *LiveFeed) metricsEvery() time.Duration {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.MetricsEvery
+}
+
+// Close disconnects every client and refuses new ones; http.Server.Shutdown
+// does not wait for upgraded connections
+func (lf *LiveFeed) Close() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return
+	}
+	lf.closed = true
+	close(lf.stop)
+	for client := range lf.clients {
+		client.leave(wsCloseGoingAway, "the bridge is shutting down")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestClient is the client end of a live feed connection
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialLive opens the WebSocket handshake at path, failing unless it is
+// accepted
+func dialLive(t *testing.T, server *httptest.Server, path string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The sample key and accept value from RFC 6455 section 1.3
+	request := "GET " + path + " HTTP/1.1\r\nHost: bridge\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err = conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake %s %v", response.Status, response.Header)
+	}
+	return &wsTestClient{conn: conn, reader: reader}
+}
+
+// send writes one masked frame, as clients must
+func (c *wsTestClient) send(t *testing.T, opcode byte, payload []byte) {
+	t.Helper()
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame := append([]byte{0x80 | opcode, 0x80 | byte(len(payload))}, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next reads one unmasked server frame, skipping pings
+func (c *wsTestClient) next(t *testing.T) (byte, []byte) {
+	t.Helper()
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			t.Fatal(err)
+		}
+		if head[1]&0x80 != 0 {
+			t.Fatal("server frame is masked")
+		}
+		length := uint64(head[1])
+		switch length {
+		case 126:
+			var extended [2]byte
+			io.ReadFull(c.reader, extended[:])
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			io.ReadFull(c.reader, extended[:])
+			length = binary.BigEndian.Uint64(extended[:])
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		if opcode := head[0] & 0x0F; opcode != wsPing {
+			return opcode, payload
+		}
+	}
+}
+
+// event reads the next text message as a live event
+func (c *wsTestClient) event(t *testing.T) (LiveEvent, string) {
+	t.Helper()
+	opcode, payload := c.next(t)
+	if opcode != wsText {
+		t.Fatalf("opcode %d %q, want a text message", opcode, payload)
+	}
+	var event LiveEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	return event, string(payload)
+}
+
+func TestLiveFeed(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	feed := NewLiveFeed(gb, sales)
+	feed.Location = time.UTC
+	t.Cleanup(feed.Close)
+
+	api := NewAPI(gb, NewPricingAnalytics(sales))
+	api.Token = "secret"
+	api.LiveToken = "overlay"
+	api.Handle("/live", feed.Handler())
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/live", http.StatusUnauthorized},
+		{"/metrics?token=overlay", http.StatusUnauthorized},
+		{"/live?token=secret", http.StatusUpgradeRequired},
+		{"/live?token=overlay&events=sale,visits", http.StatusBadRequest},
+	} {
+		response, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tt.want {
+			t.Errorf("GET %s: %d, want %d", tt.path, response.StatusCode, tt.want)
+		}
+	}
+
+	ids := NewSequentialIDs("py")
+	ping := func(payload map[string]interface{}) {
+		t.Helper()
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+	sale := func(id, product string) map[string]interface{} {
+		return map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": product, "product_name": "ShotKit",
+			"email": "ada@example.com", "full_name": "Ada Lovelace", "price": 29, "sale_timestamp": clock.Now().Format(time.RFC3339)}
+	}
+
+	all := dialLive(t, server, "/live?token=overlay")
+	if event, _ := all.event(t); event.Type != LiveMetricsEvent || event.Metrics.AllTime.Sales != 0 {
+		t.Errorf("first event %+v, want empty metrics", event)
+	}
+	onlyBooks := dialLive(t, server, "/live?token=secret&events=sale&product=book")
+	// Wait for both to join, since the feed never replays
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		feed.mu.Lock()
+		joined := len(feed.clients)
+		feed.mu.Unlock()
+		if joined == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients joined", joined)
+		}
+	}
+
+	ping(sale("s1", "app"))
+	ping(sale("s1", "app")) // Gumroad redelivering the ping
+	refund := sale("s1", "app")
+	refund["resource_name"] = "refund"
+	refund["amount_refunded_in_cents"] = 900
+	ping(refund)
+	ping(sale("s2", "book"))
+
+	event, raw := all.event(t)
+	if event.Type != LiveSaleEvent || event.Sale.SaleID != "s1" || event.Sale.Buyer != "Ada" || event.Sale.Display != "29.00 USD" {
+		t.Errorf("sale event %s", raw)
+	}
+	if strings.Contains(raw, "ada@example.com") || strings.Contains(raw, "Lovelace") {
+		t.Errorf("sale event %s names the buyer beyond their first name", raw)
+	}
+	if event, raw = all.event(t); event.Type != LiveMetricsEvent || event.Metrics.Today.Sales != 1 || len(event.Metrics.Today.Revenue) != 1 || event.Metrics.Today.Revenue[0].Amount != 2900 {
+		t.Errorf("metrics after the sale %s", raw)
+	}
+	if event, raw = all.event(t); event.Type != LiveRefundEvent || event.Refund.SaleID != "s1" || !event.Refund.Partial || event.Refund.Amount.Amount != 900 {
+		t.Errorf("refund event %s", raw)
+	}
+	if event, raw = all.event(t); event.Type != LiveMetricsEvent {
+		t.Errorf("metrics after the refund %s", raw)
+	}
+	if event, raw = all.event(t); event.Type != LiveSaleEvent || event.Sale.SaleID != "s2" {
+		t.Errorf("second sale event %s", raw)
+	}
+	if event, raw = onlyBooks.event(t); event.Type != LiveSaleEvent || event.Sale.SaleID != "s2" {
+		t.Errorf("filtered client's first event %s, want sale s2", raw)
+	}
+
+	onlyBooks.send(t, wsPing, []byte("hi"))
+	if opcode, payload := onlyBooks.next(t); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("ping answered with %d %q", opcode, payload)
+	}
+	onlyBooks.send(t, wsClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal))
+	if opcode, payload := onlyBooks.next(t); opcode != wsClose || binary.BigEndian.Uint16(payload) != wsCloseNormal {
+		t.Errorf("close answered with %d %q", opcode, payload)
+	}
+
+	feed.Close()
+	for {
+		opcode, payload := all.next(t)
+		if opcode == wsClose {
+			if code := binary.BigEndian.Uint16(payload); code != wsCloseGoingAway {
+				t.Errorf("closed with %d, want going away", code)
+			}
+			break
+		}
+	}
+}
+
+func TestLiveFeedDropsSlowClients(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	sales, _ := NewSalesStore("")
+	feed := NewLiveFeed(gb, sales)
+
+	// A client whose writer is stuck takes one event, then is let go
+	client := &liveClient{events: map[string]bool{LiveMetricsEvent: true}, queue: make(chan []byte, 1), gone: make(chan struct{})}
+	feed.clients[client] = true
+	feed.publish(feed.metricsEvent())
+	feed.publish(feed.metricsEvent())
+
+	select {
+	case <-client.gone:
+	default:
+		t.Fatal("slow client still connected")
+	}
+	if feed.clients[client] || client.code != wsCloseTryAgain {
+		t.Errorf("slow client left with code %d", client.code)
+	}
+}
+
+func TestLiveMetrics(t *testing.T) {
+	now := time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	sales, _ := NewSalesStore("")
+	feed := NewLiveFeed(gb, sales)
+	feed.Location = time.UTC
+
+	for _, sale := range []map[string]interface{}{
+		{"sale_id": "yesterday", "product_id": "app", "price": 10, "sale_timestamp": now.Add(-24 * time.Hour)},
+		{"sale_id": "morning", "product_id": "app", "price": 20, "sale_timestamp": now.Add(-3 * time.Hour)},
+		{"sale_id": "refunded", "product_id": "app", "price": 40, "refunded": true, "sale_timestamp": now.Add(-30 * time.Minute)},
+		{"sale_id": "euros", "product_id": "app", "price": 5, "currency": "eur", "sale_timestamp": now.Add(-10 * time.Minute)},
+	} {
+		sale["sale_timestamp"] = sale["sale_timestamp"].(time.Time).Format(time.RFC3339)
+		if _, err := sales.Record(sale); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metrics := feed.Metrics()
+	tests := []struct {
+		name    string
+		got     LiveTotals
+		sales   int
+		refunds int
+		revenue string
+	}{
+		{"today", metrics.Today, 3, 1, "5.00 EUR, 20.00 USD"},
+		{"last hour", metrics.LastHour, 2, 1, "5.00 EUR"},
+		{"all time", metrics.AllTime, 4, 1, "5.00 EUR, 30.00 USD"},
+	}
+	for _, tt := range tests {
+		var revenue []string
+		for _, amount := range tt.got.Revenue {
+			revenue = append(revenue, amount.String())
+		}
+		if tt.got.Sales != tt.sales || tt.got.Refunds != tt.refunds || strings.Join(revenue, ", ") != tt.revenue {
+			t.Errorf("%s: %+v, want %d sales, %d refunds and %s", tt.name, tt.got, tt.sales, tt.refunds, tt.revenue)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryTransport is an in-process transport for unit tests. Injected messages
+// are delivered synchronously and everything sent is recorded for inspection.
+type MemoryTransport struct {
+	// Serializer encodes injected messages; nil is JSON
+	Serializer Serializer
+
+	mu      sync.Mutex
+	deliver func(*Envelope)
+	sent    []*UniversalMessage
+	acked   []string
+	nacked  []string
+	closed  bool
+	stopped bool
+
+	onSend    func(*UniversalMessage)
+	onDeliver func(*Envelope)
+}
+
+// NewMemoryTransport creates an empty in-memory transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Channel returns SharedMemory
+func (mt *MemoryTransport) Channel() CommunicationChannel {
+	return SharedMemory
+}
+
+// Start records the bridge's delivery callback
+func (mt *MemoryTransport) Start(deliver func(*Envelope)) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.deliver = deliver
+	return nil
+}
+
+// Send records the message instead of delivering it anywhere
+func (mt *MemoryTransport) Send(message *UniversalMessage) error {
+	mt.mu.Lock()
+	if mt.closed {
+		mt.mu.Unlock()
+		return fmt.Errorf("memory transport closed")
+	}
+	mt.sent = append(mt.sent, message)
+	hook := mt.onSend
+	mt.mu.Unlock()
+
+	if hook != nil {
+		hook(message)
+	}
+	return nil
+}
+
+// StopReceiving refuses further injected messages; sends still work until Close
+func (mt *MemoryTransport) StopReceiving() {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.stopped = true
+}
+
+// Close marks the transport closed; further sends and injects fail
+func (mt *MemoryTransport) Close() error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.closed = true
+	return nil
+}
+
+// Inject delivers a message to the bridge and waits until it is acked or
+// nacked, returning the nack reason if handling failed
+func (mt *MemoryTransport) Inject(message *UniversalMessage) error {
+	serializer := mt.Serializer
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	data, err := serializer.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return mt.inject(message.ID, data, serializer.ContentType())
+}
+
+// InjectRaw delivers raw JSON bytes to the bridge, for testing malformed input
+func (mt *MemoryTransport) InjectRaw(source string, data []byte) error {
+	return mt.inject(source, data, "")
+}
+
+func (mt *MemoryTransport) inject(source string, data []byte, contentType string) error {
+	mt.mu.Lock()
+	deliver := mt.deliver
+	hook := mt.onDeliver
+	closed := mt.closed || mt.stopped
+	mt.mu.Unlock()
+
+	if closed {
+		return fmt.Errorf("memory transport closed")
+	}
+	if deliver == nil {
+		return fmt.Errorf("memory transport not started")
+	}
+
+	done := make(chan error, 1)
+	envelope := NewEnvelope(SharedMemory, source, data, func() error {
+		mt.mu.Lock()
+		mt.acked = append(mt.acked, source)
+		mt.mu.Unlock()
+		done <- nil
+		return nil
+	}, func(reason error) {
+		mt.mu.Lock()
+		mt.nacked = append(mt.nacked, source)
+		mt.mu.Unlock()
+		if reason == nil {
+			reason = fmt.Errorf("message %s rejected", source)
+		}
+		done <- reason
+	})
+
+	envelope.ContentType = contentType
+
+	if hook != nil {
+		hook(envelope)
+	}
+	deliver(envelope)
+	return <-done
+}
+
+// OnSend registers a hook called for every sent message
+func (mt *MemoryTransport) OnSend(hook func(*UniversalMessage)) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.onSend = hook
+}
+
+// OnDeliver registers a hook called before every injected envelope is delivered
+func (mt *MemoryTransport) OnDeliver(hook func(*Envelope)) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.onDeliver = hook
+}
+
+// Sent returns a copy of all sent messages in order
+func (mt *MemoryTransport) Sent() []*UniversalMessage {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return append([]*UniversalMessage(nil), mt.sent...)
+}
+
+// SentOfType returns sent messages of the given type
+func (mt *MemoryTransport) SentOfType(messageType MessageType) []*UniversalMessage {
+	var matched []*UniversalMessage
+	for _, message := range mt.Sent() {
+		if message.MessageType == messageType {
+			matched = append(matched, message)
+		}
+	}
+	return matched
+}
+
+// Acked returns the sources of all acked envelopes
+func (mt *MemoryTransport) Acked() []string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return append([]string(nil), mt.acked...)
+}
+
+// Nacked returns the sources of all nacked envelopes
+func (mt *MemoryTransport) Nacked() []string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return append([]string(nil), mt.nacked...)
+}
+
+// Reset clears recorded messages and acks
+func (mt *MemoryTransport) Reset() {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.sent = nil
+	mt.acked = nil
+	mt.nacked = nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Message directions in the store
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// Message statuses in the store. A sent message is sent or failed; a
+// received one is received until its handler returns, then handled, failed
+// or unhandled when no handler is registered for its type, filtered when
+// a filter such as a script hook dropped it first, or expired when it
+// arrived past its expires_at.
+const (
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusReceived  = "received"
+	StatusHandled   = "handled"
+	StatusUnhandled = "unhandled"
+	StatusFiltered  = "filtered"
+	StatusExpired   = "expired"
+)
+
+const messageStoreSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id              TEXT NOT NULL,
+	direction       TEXT NOT NULL,
+	message_type    TEXT NOT NULL,
+	source_language TEXT NOT NULL,
+	target_language TEXT NOT NULL,
+	channel         TEXT NOT NULL,
+	correlation_id  TEXT NOT NULL,
+	status          TEXT NOT NULL,
+	attempts        INTEGER NOT NULL,
+	error           TEXT NOT NULL DEFAULT '',
+	timestamp       TEXT NOT NULL,
+	payload         TEXT NOT NULL,
+	first_seen      INTEGER NOT NULL,
+	last_seen       INTEGER NOT NULL,
+	PRIMARY KEY (id, direction)
+);
+CREATE INDEX IF NOT EXISTS messages_type_seen ON messages (message_type, first_seen);
+CREATE INDEX IF NOT EXISTS messages_correlation ON messages (correlation_id);
+CREATE INDEX IF NOT EXISTS messages_seen ON messages (first_seen);
+`
+
+// StoredMessage is a message as the store recorded it. FirstSeen is when it
+// was first sent or received and LastSeen its latest attempt or status
+// change; Attempts counts sends, or deliveries for received messages.
+type StoredMessage struct {
+	ID             string                 `json:"id"`
+	Direction      string                 `json:"direction"`
+	MessageType    MessageType            `json:"message_type"`
+	SourceLanguage string                 `json:"source_language"`
+	TargetLanguage string                 `json:"target_language"`
+	Channel        CommunicationChannel   `json:"channel"`
+	CorrelationID  string                 `json:"correlation_id"`
+	Status         string                 `json:"status"`
+	Attempts       int                    `json:"attempts"`
+	Error          string                 `json:"error,omitempty"`
+	Timestamp      string                 `json:"timestamp"`
+	Payload        map[string]interface{} `json:"payload"`
+	FirstSeen      time.Time              `json:"first_seen"`
+	LastSeen       time.Time              `json:"last_seen"`
+}
+
+// MessageQuery filters the stored messages; zero fields match everything.
+// Since and Until bound FirstSeen, Since inclusive and Until exclusive.
+type MessageQuery struct {
+	MessageType   MessageType
+	Direction     string
+	Status        string
+	CorrelationID string
+	Since         time.Time
+	Until         time.Time
+	// Limit caps the results, newest first; it defaults to 100
+	Limit int
+	// Offset skips that many of the newest matches, for paging
+	Offset int
+}
+
+// MessageStore records every message the bridge sends and receives in a
+// SQLite database, with its status and attempts, so activity survives
+// restarts and can be audited. A request and its replies share a
+// correlation ID: the payload's correlation_id when set, otherwise the
+// original_message_id a reply names, otherwise the message's own ID.
+// Recording errors are logged and never fail a send or a receive.
+type MessageStore struct {
+	db    *sql.DB
+	clock Clock
+	mu    sync.Mutex // serializes writes, which SQLite takes one at a time
+}
+
+// OpenMessageStore opens or creates the database at path
+func OpenMessageStore(path string, clock Clock) (*MessageStore, error) {
+	if clock == nil {
+		clock = defaultClock
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message store directory: %v", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message store %s: %v", path, err)
+	}
+	_, err = db.Exec(messageStoreSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create message store schema in %s: %v", path, err)
+	}
+	return &MessageStore{db: db, clock: clock}, nil
+}
+
+// NewMessageStore opens the database at path and records the bridge's
+// messages in it. A bridge has one store.
+func NewMessageStore(gb *GoBridge, path string) (*MessageStore, error) {
+	ms, err := OpenMessageStore(path, gb.clock)
+	if err != nil {
+		return nil, err
+	}
+	gb.mu.Lock()
+	gb.store = ms
+	gb.mu.Unlock()
+	return ms, nil
+}
+
+// correlationID returns the ID a message's conversation is filed under
+func correlationID(message *UniversalMessage) string {
+	if id := stringArg(message.Payload, "correlation_id"); id != "" {
+		return id
+	}
+	if id := stringArg(message.Payload, "original_message_id"); id != "" {
+		return id
+	}
+	return message.ID
+}
+
+// sent records a send attempt and its outcome
+func (ms *MessageStore) sent(message *UniversalMessage, channel CommunicationChannel, sendErr error) {
+	status, errText := StatusSent, ""
+	if sendErr != nil {
+		status, errText = StatusFailed, sendErr.Error()
+	}
+	ms.record(message, DirectionSent, channel, status, errText)
+}
+
+// received records a delivery of an incoming message
+func (ms *MessageStore) received(message *UniversalMessage) {
+	ms.record(message, DirectionReceived, message.receivedOn, StatusReceived, "")
+}
+
+// handled records what became of a received message
+func (ms *MessageStore) handled(message *UniversalMessage, status string, handlerErr error) {
+	errText := ""
+	if handlerErr != nil {
+		errText = handlerErr.Error()
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, err := ms.db.Exec(`UPDATE messages SET status = ?, error = ?, last_seen = ? WHERE id = ? AND direction = ?`,
+		status, errText, ms.clock.Now().UnixNano(), message.ID, DirectionReceived)
+	if err != nil {
+		log.Printf("❌ Error recording %s as %s: %v", message.ID, status, err)
+	}
+}
+
+// record adds the message, or counts another attempt at one already stored
+func (ms *MessageStore) record(message *UniversalMessage, direction string, channel CommunicationChannel, status, errText string) {
+	payload, err := json.Marshal(message.Payload)
+	if err != nil {
+		log.Printf("❌ Error recording %s: %v", message.ID, err)
+		return
+	}
+	now := ms.clock.Now().UnixNano()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, err = ms.db.Exec(`
+		INSERT INTO messages (id, direction, message_type, source_language, target_language, channel,
+			correlation_id, status, attempts, error, timestamp, payload, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, direction) DO UPDATE SET
+			channel = excluded.channel,
+			status = excluded.status,
+			attempts = messages.attempts + 1,
+			error = excluded.error,
+			payload = excluded.payload,
+			last_seen = excluded.last_seen`,
+		message.ID, direction, string(message.MessageType), message.SourceLanguage, message.TargetLanguage, string(channel),
+		correlationID(message), status, errText, message.Timestamp, string(payload), now, now)
+	if err != nil {
+		log.Printf("❌ Error recording %s message %s: %v", direction, message.ID, err)
+	}
+}
+
+// where is the SQL condition q filters on, empty when it matches everything
+func (q MessageQuery) where() (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	match := func(clause string, value interface{}) {
+		where = append(where, clause)
+		args = append(args, value)
+	}
+	if q.MessageType != "" {
+		match("message_type = ?", string(q.MessageType))
+	}
+	if q.Direction != "" {
+		match("direction = ?", q.Direction)
+	}
+	if q.Status != "" {
+		match("status = ?", q.Status)
+	}
+	if q.CorrelationID != "" {
+		match("correlation_id = ?", q.CorrelationID)
+	}
+	if !q.Since.IsZero() {
+		match("first_seen >= ?", q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		match("first_seen < ?", q.Until.UnixNano())
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// Count returns how many messages match q, ignoring its Limit and Offset
+func (ms *MessageStore) Count(q MessageQuery) (int, error) {
+	where, args := q.where()
+	var count int
+	err := ms.db.QueryRow("SELECT COUNT(*) FROM messages"+where, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %v", err)
+	}
+	return count, nil
+}
+
+// Query returns the messages matching q, newest first
+func (ms *MessageStore) Query(q MessageQuery) ([]StoredMessage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	where, args := q.where()
+	query := `SELECT id, direction, message_type, source_language, target_language, channel, correlation_id,
+		status, attempts, error, timestamp, payload, first_seen, last_seen FROM messages` + where
+	query += " ORDER BY first_seen DESC, id LIMIT ? OFFSET ?"
+	args = append(args, limit, q.Offset)
+
+	rows, err := ms.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []StoredMessage
+	for rows.Next() {
+		var m StoredMessage
+		var messageType, channel, payload string
+		var firstSeen, lastSeen int64
+		err = rows.Scan(&m.ID, &m.Direction, &messageType, &m.SourceLanguage, &m.TargetLanguage, &channel, &m.CorrelationID,
+			&m.Status, &m.Attempts, &m.Error, &m.Timestamp, &payload, &firstSeen, &lastSeen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %v", err)
+		}
+		m.MessageType = MessageType(messageType)
+		m.Channel = CommunicationChannel(channel)
+		m.FirstSeen = time.Unix(0, firstSeen).UTC()
+		m.LastSeen = time.Unix(0, lastSeen).UTC()
+		err = json.Unmarshal([]byte(payload), &m.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("bad stored payload for %s: %v", m.ID, err)
+		}
+		messages = append(messages, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	return messages, nil
+}
+
+// ByCorrelation returns a conversation, a request and its replies, oldest first
+func (ms *MessageStore) ByCorrelation(id string) ([]StoredMessage, error) {
+	messages, err := ms.Query(MessageQuery{CorrelationID: id, Limit: 1000})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// Handler serves GET /messages, filtered by the type, direction, status,
+// correlation_id, since and until (RFC 3339) and limit query parameters
+func (ms *MessageStore) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		params := r.URL.Query()
+		q := MessageQuery{
+			MessageType:   MessageType(params.Get("type")),
+			Direction:     params.Get("direction"),
+			Status:        params.Get("status"),
+			CorrelationID: params.Get("correlation_id"),
+		}
+		for name, bound := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			value := params.Get(name)
+			if value == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad %s: %v", name, err))
+				return
+			}
+			*bound = parsed
+		}
+		if value := params.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "limit must be a positive number")
+				return
+			}
+			q.Limit = limit
+		}
+
+		messages, err := ms.Query(q)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if messages == nil {
+			messages = []StoredMessage{}
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"messages": messages})
+	})
+}
+
+// Close closes the database
+func (ms *MessageStore) Close() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.db.Close()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the handler latency histogram's upper bounds, in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// BridgeMetrics counts messages sent, received, expired and failed by type, and
+// times handlers. Gauges such as queue depth are read from the bridge when
+// scraped, so only counters live here.
+type BridgeMetrics struct {
+	mu          sync.Mutex
+	sent        map[messageLabels]uint64
+	sendFailed  map[messageLabels]uint64
+	received    map[messageLabels]uint64
+	expired     map[messageLabels]uint64
+	failed      map[MessageType]uint64
+	handlerTime map[MessageType]*histogram
+}
+
+// messageLabels are the labels of the per-message counters
+type messageLabels struct {
+	messageType MessageType
+	channel     CommunicationChannel
+}
+
+// histogram is a Prometheus histogram; counts[i] is the number of
+// observations up to latencyBuckets[i], not cumulative
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newBridgeMetrics() *BridgeMetrics {
+	return &BridgeMetrics{
+		sent:        make(map[messageLabels]uint64),
+		sendFailed:  make(map[messageLabels]uint64),
+		received:    make(map[messageLabels]uint64),
+		expired:     make(map[messageLabels]uint64),
+		failed:      make(map[MessageType]uint64),
+		handlerTime: make(map[MessageType]*histogram),
+	}
+}
+
+// sentMessage counts a send attempt, and whether it failed
+func (bm *BridgeMetrics) sentMessage(message *UniversalMessage, channel CommunicationChannel, err error) {
+	labels := messageLabels{message.MessageType, channel}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if err != nil {
+		bm.sendFailed[labels]++
+		return
+	}
+	bm.sent[labels]++
+}
+
+// receivedMessage counts a message that reached the handlers
+func (bm *BridgeMetrics) receivedMessage(message *UniversalMessage) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.received[messageLabels{message.MessageType, message.receivedOn}]++
+}
+
+// expiredMessage counts a message refused for being past its expires_at
+func (bm *BridgeMetrics) expiredMessage(message *UniversalMessage) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.expired[messageLabels{message.MessageType, message.receivedOn}]++
+}
+
+// handled records how long a message type's handler took, and whether it failed
+func (bm *BridgeMetrics) handled(messageType MessageType, took time.Duration, err error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if err != nil {
+		bm.failed[messageType]++
+	}
+
+	h := bm.handlerTime[messageType]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		bm.handlerTime[messageType] = h
+	}
+	seconds := took.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// MessageStats is one message type's counters, summed over channels, for
+// dashboards that cannot read the Prometheus format
+type MessageStats struct {
+	MessageType   MessageType `json:"message_type"`
+	Sent          uint64      `json:"sent"`
+	SendFailed    uint64      `json:"send_failed"`
+	Received      uint64      `json:"received"`
+	Expired       uint64      `json:"expired"`
+	Handled       uint64      `json:"handled"` // handler runs, including failed ones
+	Failed        uint64      `json:"failed"`
+	HandlerMillis float64     `json:"handler_ms"` // average handler run
+}
+
+// MessageStats returns every message type's counters, sorted by type
+func (gb *GoBridge) MessageStats() []MessageStats {
+	bm := gb.metrics
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	byType := make(map[MessageType]*MessageStats)
+	stats := func(messageType MessageType) *MessageStats {
+		if byType[messageType] == nil {
+			byType[messageType] = &MessageStats{MessageType: messageType}
+		}
+		return byType[messageType]
+	}
+	for labels, count := range bm.sent {
+		stats(labels.messageType).Sent += count
+	}
+	for labels, count := range bm.sendFailed {
+		stats(labels.messageType).SendFailed += count
+	}
+	for labels, count := range bm.received {
+		stats(labels.messageType).Received += count
+	}
+	for labels, count := range bm.expired {
+		stats(labels.messageType).Expired += count
+	}
+	for messageType, count := range bm.failed {
+		stats(messageType).Failed = count
+	}
+	for messageType, h := range bm.handlerTime {
+		stats(messageType).Handled = h.count
+		if h.count > 0 {
+			stats(messageType).HandlerMillis = h.sum * 1000 / float64(h.count)
+		}
+	}
+
+	list := make([]MessageStats, 0, len(byType))
+	for _, s := range byType {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MessageType < list[j].MessageType })
+	return list
+}
+
+// MetricsHandler serves the bridge's metrics in the Prometheus text format
+func (gb *GoBridge) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		gb.writeMetrics(out)
+		out.Flush()
+	})
+}
+
+// writeMetrics writes every metric, with series sorted so scrapes diff cleanly
+func (gb *GoBridge) writeMetrics(out *bufio.Writer) {
+	bm := gb.metrics
+	bm.mu.Lock()
+	writeMessageCounter(out, "bridge_messages_sent_total", "Messages sent, by type and channel.", bm.sent)
+	writeMessageCounter(out, "bridge_messages_send_failed_total", "Messages that could not be sent, by type and channel.", bm.sendFailed)
+	writeMessageCounter(out, "bridge_messages_received_total", "Messages received, by type and the channel they arrived on.", bm.received)
+	writeMessageCounter(out, "bridge_messages_expired_total", "Messages received past their expires_at and not handled, by type and channel.", bm.expired)
+
+	writeHeader(out, "bridge_messages_failed_total", "counter", "Messages whose handler returned an error, by type.")
+	for _, messageType := range sortedTypes(bm.failed) {
+		writeSample(out, "bridge_messages_failed_total", labelPairs("message_type", string(messageType)), float64(bm.failed[messageType]))
+	}
+
+	writeHeader(out, "bridge_handler_duration_seconds", "histogram", "Time spent in message handlers, by type.")
+	types := make([]MessageType, 0, len(bm.handlerTime))
+	for messageType := range bm.handlerTime {
+		types = append(types, messageType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, messageType := range types {
+		h := bm.handlerTime[messageType]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			writeSample(out, "bridge_handler_duration_seconds_bucket", labelPairs("message_type", string(messageType), "le", formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(out, "bridge_handler_duration_seconds_bucket", labelPairs("message_type", string(messageType), "le", "+Inf"), float64(h.count))
+		writeSample(out, "bridge_handler_duration_seconds_sum", labelPairs("message_type", string(messageType)), h.sum)
+		writeSample(out, "bridge_handler_duration_seconds_count", labelPairs("message_type", string(messageType)), float64(h.count))
+	}
+	bm.mu.Unlock()
+
+	stages := gb.PipelineStats()
+	writeHeader(out, "bridge_pipeline_queue_depth", "gauge", "Messages waiting for each inbound pipeline stage.")
+	for _, stage := range stages {
+		writeSample(out, "bridge_pipeline_queue_depth", labelPairs("stage", stage.Name), float64(stage.QueueDepth))
+	}
+	writeHeader(out, "bridge_pipeline_failed_total", "counter", "Messages each inbound pipeline stage failed, such as ones that could not be decoded.")
+	for _, stage := range stages {
+		writeSample(out, "bridge_pipeline_failed_total", labelPairs("stage", stage.Name), float64(stage.Failed))
+	}
+
+	sinks := gb.SinkStats()
+	writeHeader(out, "bridge_sink_queue_depth", "gauge", "Messages queued for each sink.")
+	for _, sink := range sinks {
+		writeSample(out, "bridge_sink_queue_depth", labelPairs("sink", sink.Name), float64(sink.Queued))
+	}
+	writeHeader(out, "bridge_sink_delivered_total", "counter", "Messages each sink delivered.")
+	for _, sink := range sinks {
+		writeSample(out, "bridge_sink_delivered_total", labelPairs("sink", sink.Name), float64(sink.Delivered))
+	}
+	writeHeader(out, "bridge_sink_dropped_total", "counter", "Messages each sink gave up on.")
+	for _, sink := range sinks {
+		writeSample(out, "bridge_sink_dropped_total", labelPairs("sink", sink.Name), float64(sink.Dropped))
+	}
+
+	writeHeader(out, "bridge_transport_lag_seconds", "gauge", "How long the last message a transport picked up had waited, such as a file in the watched inbox.")
+	for _, transport := range gb.transports() {
+		if reporter, ok := transport.(LagReporter); ok {
+			writeSample(out, "bridge_transport_lag_seconds", labelPairs("channel", string(transport.Channel())), reporter.Lag().Seconds())
+		}
+	}
+}
+
+func writeMessageCounter(out *bufio.Writer, name, help string, counts map[messageLabels]uint64) {
+	writeHeader(out, name, "counter", help)
+	labels := make([]messageLabels, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].messageType != labels[j].messageType {
+			return labels[i].messageType < labels[j].messageType
+		}
+		return labels[i].channel < labels[j].channel
+	})
+	for _, label := range labels {
+		writeSample(out, name, labelPairs("message_type", string(label.messageType), "channel", string(label.channel)), float64(counts[label]))
+	}
+}
+
+func sortedTypes(counts map[MessageType]uint64) []MessageType {
+	types := make([]MessageType, 0, len(counts))
+	for messageType := range counts {
+		types = append(types, messageType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func writeHeader(out *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(out *bufio.Writer, name, labels string, value float64) {
+	fmt.Fprintf(out, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+// labelPairs formats name/value pairs as Prometheus labels
+func labelPairs(pairs ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+escape.Replace(pairs[i+1])+`"`)
+	}
+	return strings.Join(labels, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
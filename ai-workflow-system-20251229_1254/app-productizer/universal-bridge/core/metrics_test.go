@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	gb.OnMessage(FunctionCall, func(*UniversalMessage) error {
+		clock.Advance(30 * time.Millisecond)
+		return nil
+	})
+	gb.OnMessage(DataSync, func(*UniversalMessage) error { return errors.New("sheet is gone") })
+
+	ids := NewSequentialIDs("py")
+	for _, messageType := range []MessageType{FunctionCall, FunctionCall, DataSync} {
+		transport.Inject(newUniversalMessage(clock, ids, messageType, "python", "go", nil, SharedMemory))
+	}
+	transport.InjectRaw("garbage", []byte("not a message"))
+	if _, err := gb.SendMessage(gb.NewMessage(HealthCheck, "python", nil, SharedMemory)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewAPI(gb, nil).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("status %d, content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`bridge_messages_received_total{message_type="function_call",channel="shared_memory"} 2`,
+		`bridge_messages_received_total{message_type="data_sync",channel="shared_memory"} 1`,
+		`bridge_messages_sent_total{message_type="health_check",channel="shared_memory"} 1`,
+		`bridge_messages_failed_total{message_type="data_sync"} 1`,
+		`bridge_handler_duration_seconds_bucket{message_type="function_call",le="0.025"} 0`,
+		`bridge_handler_duration_seconds_bucket{message_type="function_call",le="0.05"} 2`,
+		`bridge_handler_duration_seconds_bucket{message_type="function_call",le="+Inf"} 2`,
+		`bridge_handler_duration_seconds_sum{message_type="function_call"} 0.06`,
+		`bridge_handler_duration_seconds_count{message_type="function_call"} 2`,
+		`bridge_pipeline_failed_total{stage="decode"} 1`,
+		"# TYPE bridge_handler_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s:\n%s", want, body)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	NewAPI(gb, nil).Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics/messages", nil))
+	body = recorder.Body.String()
+	for _, want := range []string{
+		`{"message_type":"data_sync","sent":0,"send_failed":0,"received":1,"expired":0,"handled":1,"failed":1,`,
+		`{"message_type":"function_call","sent":0,"send_failed":0,"received":2,"expired":0,"handled":2,"failed":0,"handler_ms":30}`,
+		`{"message_type":"health_check","sent":1,`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("message metrics are missing %s:\n%s", want, body)
+		}
+	}
+}
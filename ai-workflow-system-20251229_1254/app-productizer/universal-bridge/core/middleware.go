@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrMessageRejected matches errors from middleware that refused a message
+// outright, such as one from an unknown source or with a bad payload.
+// Retrying cannot help, so the dead letter queue marks them dead at once.
+var ErrMessageRejected = errors.New("message rejected")
+
+// MessageHandler handles a received message
+type MessageHandler func(*UniversalMessage) error
+
+// Middleware wraps a handler, running code before or after it, or instead
+// of it
+type Middleware func(next MessageHandler) MessageHandler
+
+// Use adds middleware around every registered handler, those registered
+// later included. The first middleware added is the outermost, so it sees
+// each message first and its handler's error last.
+func (gb *GoBridge) Use(middleware ...Middleware) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	gb.middleware = append(gb.middleware, middleware...)
+}
+
+// wrapHandler wraps handler in the middleware, outermost first
+func wrapHandler(middleware []Middleware, handler MessageHandler) MessageHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// RecoverMiddleware turns a handler panic into an error, so one bad message
+// cannot take down the bridge; the message is retried like any failure
+func RecoverMiddleware() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(message *UniversalMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("handler panic: %v", r)
+				}
+			}()
+			return next(message)
+		}
+	}
+}
+
+// LoggingMiddleware logs each handled message with how long it took, and
+// each failure with its error
+func LoggingMiddleware(clock Clock) Middleware {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return func(next MessageHandler) MessageHandler {
+		return func(message *UniversalMessage) error {
+			start := clock.Now()
+			err := next(message)
+			took := clock.Now().Sub(start)
+			if err != nil {
+				log.Printf("❌ Handling %s (%s) from %s failed after %v: %v", message.ID, message.MessageType, message.SourceLanguage, took, err)
+				return err
+			}
+			fmt.Printf("✅ Handled %s (%s) from %s in %v\n", message.ID, message.MessageType, message.SourceLanguage, took)
+			return nil
+		}
+	}
+}
+
+// MetricsMiddleware reports each handled message's type, duration and
+// error to observe, for counters beyond the bridge's own /metrics series
+func MetricsMiddleware(clock Clock, observe func(message *UniversalMessage, took time.Duration, err error)) Middleware {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return func(next MessageHandler) MessageHandler {
+		return func(message *UniversalMessage) error {
+			start := clock.Now()
+			err := next(message)
+			observe(message, clock.Now().Sub(start), err)
+			return err
+		}
+	}
+}
+
+// AuthMiddleware refuses messages authorize returns an error for
+func AuthMiddleware(authorize func(*UniversalMessage) error) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(message *UniversalMessage) error {
+			err := authorize(message)
+			if err != nil {
+				return fmt.Errorf("%w: unauthorized: %v", ErrMessageRejected, err)
+			}
+			return next(message)
+		}
+	}
+}
+
+// AllowSources authorizes messages from the source languages listed, such
+// as python or gumroad
+func AllowSources(sources ...string) func(*UniversalMessage) error {
+	allowed := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		allowed[strings.TrimSpace(source)] = true
+	}
+	return func(message *UniversalMessage) error {
+		if !allowed[message.SourceLanguage] {
+			return fmt.Errorf("source %q is not allowed", message.SourceLanguage)
+		}
+		return nil
+	}
+}
+
+// ValidationMiddleware checks payloads with the validator for their message
+// type, refusing those that fail; types without one pass through
+func ValidationMiddleware(validators map[MessageType]func(payload map[string]interface{}) error) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(message *UniversalMessage) error {
+			if validate := validators[message.MessageType]; validate != nil {
+				err := validate(message.Payload)
+				if err != nil {
+					return fmt.Errorf("%w: invalid %s payload: %v", ErrMessageRejected, message.MessageType, err)
+				}
+			}
+			return next(message)
+		}
+	}
+}
+
+// RequireFields is a payload validator that needs each field to be set
+func RequireFields(fields ...string) func(payload map[string]interface{}) error {
+	return func(payload map[string]interface{}) error {
+		var missing []string
+		for _, field := range fields {
+			if value, ok := payload[field]; !ok || value == nil || value == "" {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
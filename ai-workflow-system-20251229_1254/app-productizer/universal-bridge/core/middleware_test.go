@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(message *UniversalMessage) error {
+				calls = append(calls, name+" in")
+				err := next(message)
+				calls = append(calls, name+" out")
+				return err
+			}
+		}
+	}
+	observed := map[MessageType]error{}
+	gb.Use(trace("outer"), RecoverMiddleware())
+	gb.Use(
+		MetricsMiddleware(clock, func(message *UniversalMessage, took time.Duration, err error) { observed[message.MessageType] = err }),
+		AuthMiddleware(AllowSources("python")),
+		ValidationMiddleware(map[MessageType]func(map[string]interface{}) error{DataSync: RequireFields("resource_name")}),
+		trace("inner"),
+	)
+
+	// Registered after Use, and still wrapped
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	gb.OnMessage(HealthCheck, func(message *UniversalMessage) error {
+		panic("boom")
+	})
+
+	ids := NewSequentialIDs("py")
+	inject := func(messageType MessageType, source string, payload map[string]interface{}) error {
+		return transport.Inject(newUniversalMessage(clock, ids, messageType, source, "go", payload, SharedMemory))
+	}
+
+	if err := inject(DataSync, "python", map[string]interface{}{"resource_name": "sale"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, ", "); got != "outer in, inner in, handler, inner out, outer out" {
+		t.Errorf("calls %s", got)
+	}
+	if err, seen := observed[DataSync]; !seen || err != nil {
+		t.Errorf("metrics observed %v", observed)
+	}
+
+	tests := []struct {
+		name        string
+		messageType MessageType
+		source      string
+		payload     map[string]interface{}
+		want        string
+		rejected    bool
+	}{
+		{"panic", HealthCheck, "python", map[string]interface{}{}, "handler panic: boom", false},
+		{"unknown source", DataSync, "ruby", map[string]interface{}{"resource_name": "sale"}, `unauthorized: source "ruby" is not allowed`, true},
+		{"bad payload", DataSync, "python", map[string]interface{}{}, "invalid data_sync payload: missing resource_name", true},
+	}
+	for _, tt := range tests {
+		calls = nil
+		err := inject(tt.messageType, tt.source, tt.payload)
+		if err == nil || !strings.Contains(err.Error(), tt.want) || errors.Is(err, ErrMessageRejected) != tt.rejected {
+			t.Errorf("%s: nacked with %v, want %q", tt.name, err, tt.want)
+		}
+		for _, call := range calls {
+			if call == "handler" {
+				t.Errorf("%s: handler ran", tt.name)
+			}
+		}
+	}
+}
+
+func TestRejectedMessagesAreDeadAtOnce(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	dl, err := NewDeadLetterQueue(gb, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb.Use(AuthMiddleware(AllowSources("python")))
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error { return nil })
+
+	if err := transport.Inject(newUniversalMessage(clock, NewSequentialIDs("rb"), DataSync, "ruby", "go", map[string]interface{}{}, SharedMemory)); err != nil {
+		t.Fatalf("dead-lettered message nacked: %v", err)
+	}
+	dead := dl.List(DeadLetterDead)
+	if len(dead) != 1 || dead[0].Attempts != 1 {
+		t.Fatalf("dead letters %+v", dl.List(""))
+	}
+}
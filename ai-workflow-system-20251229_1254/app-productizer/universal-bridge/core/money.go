@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money arithmetic errors
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrMoneyOverflow    = errors.New("money amount out of range")
+	ErrDivideByZero     = errors.New("money divided by zero")
+)
+
+// currencyDigits lists the ISO 4217 currencies whose minor unit is not a
+// hundredth; every other currency has two decimal places
+var currencyDigits = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "isk": 0, "jpy": 0, "kmf": 0, "krw": 0,
+	"pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0, "vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "iqd": 3, "jod": 3, "kwd": 3, "lyd": 3, "omr": 3, "tnd": 3,
+}
+
+// Money is an amount in a currency's minor units (cents for USD, yen for
+// JPY) with its lower-case ISO 4217 code. Amounts in different currencies
+// never mix: arithmetic on them fails with ErrCurrencyMismatch.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// NewMoney creates an amount of minor units
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToLower(currency)}
+}
+
+// ParseMoney reads a decimal amount in major units, such as "29.99", exactly.
+// Digits beyond the currency's minor unit are rounded half away from zero.
+func ParseMoney(amount, currency string) (Money, error) {
+	currency, err := parseCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	value.Mul(value, new(big.Rat).SetInt(pow10(currencyExponent(currency))))
+	minor, err := roundRat(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// MoneyFromMajor converts a number in major units, as JSON payloads carry
+// prices, going through its shortest decimal form so 29.99 is 2999 cents
+func MoneyFromMajor(amount float64, currency string) (Money, error) {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return Money{}, fmt.Errorf("invalid amount %v", amount)
+	}
+	return ParseMoney(strconv.FormatFloat(amount, 'f', -1, 64), currency)
+}
+
+// moneyArg reads a payload amount given as a number or a decimal string
+func moneyArg(value interface{}, currency string) (Money, error) {
+	switch v := value.(type) {
+	case string:
+		return ParseMoney(v, currency)
+	default:
+		number, ok := toNumber(value)
+		if !ok {
+			return Money{}, fmt.Errorf("invalid amount %v", value)
+		}
+		return MoneyFromMajor(number, currency)
+	}
+}
+
+func currencyExponent(currency string) int {
+	if digits, ok := currencyDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
+
+// roundRat rounds half away from zero to an int64
+func roundRat(value *big.Rat) (int64, error) {
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	if twice.Cmp(value.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(value.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return quotient.Int64(), nil
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul returns m × n
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// Div returns m ÷ n rounded half away from zero
+func (m Money) Div(n int64) (Money, error) {
+	if n == 0 {
+		return Money{}, ErrDivideByZero
+	}
+	value := new(big.Rat).SetFrac(big.NewInt(m.Amount), big.NewInt(n))
+	amount, err := roundRat(value) // only the lowest amount ÷ -1 is out of range
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
+
+// Cmp compares two amounts in the same currency: -1, 0 or +1
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// FloorMajor rounds down to a whole major unit, e.g. $12.75 → $12.00
+func (m Money) FloorMajor() Money {
+	unit := pow10(currencyExponent(m.Currency)).Int64()
+	floored := m.Amount - m.Amount%unit
+	if m.Amount < 0 && m.Amount%unit != 0 {
+		floored -= unit
+	}
+	return Money{Amount: floored, Currency: m.Currency}
+}
+
+// Allocate splits m by weight so the parts add up to m exactly. Each part
+// gets its proportional share rounded down; the minor units left over go
+// one each to the parts with the largest remainders, earlier parts first on
+// ties.
+func (m Money) Allocate(weights []float64) ([]Money, error) {
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("allocate needs at least one weight")
+	}
+	ratios := make([]*big.Rat, len(weights))
+	total := new(big.Rat)
+	for i, weight := range weights {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weight %v", weight)
+		}
+		ratios[i] = new(big.Rat).SetFloat64(weight)
+		total.Add(total, ratios[i])
+	}
+	if total.Sign() == 0 {
+		return nil, fmt.Errorf("allocate needs a positive weight")
+	}
+
+	amount := big.NewInt(m.Amount)
+	negative := m.Amount < 0
+	if negative {
+		amount.Neg(amount)
+	}
+	parts := make([]Money, len(weights))
+	remainders := make([]*big.Rat, len(weights))
+	left := new(big.Int).Set(amount)
+	for i, ratio := range ratios {
+		exact := new(big.Rat).Mul(new(big.Rat).SetInt(amount), ratio)
+		exact.Quo(exact, total)
+		share := new(big.Int).Quo(exact.Num(), exact.Denom())
+		remainders[i] = exact.Sub(exact, new(big.Rat).SetInt(share))
+		left.Sub(left, share)
+		parts[i] = Money{Amount: share.Int64(), Currency: m.Currency}
+	}
+
+	order := make([]int, len(parts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]].Cmp(remainders[order[b]]) > 0 })
+	for i := int64(0); i < left.Int64(); i++ {
+		parts[order[int(i)%len(order)]].Amount++
+	}
+
+	if negative {
+		for i := range parts {
+			parts[i].Amount = -parts[i].Amount
+		}
+	}
+	return parts, nil
+}
+
+// Decimal formats the amount in major units without the currency, e.g. "29.99"
+func (m Money) Decimal() string {
+	exponent := currencyExponent(m.Currency)
+	digits := strconv.FormatInt(m.Amount, 10)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// Major is the amount in major units, for payload fields and templates that
+// show prices as plain numbers; don't do arithmetic on it
+func (m Money) Major() float64 {
+	major, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return major
+}
+
+// String formats the amount with its code, e.g. "29.99 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + strings.ToUpper(m.Currency)
+}
+
+// MoneyTotals sums amounts per currency, in minor units keyed by currency
+type MoneyTotals map[string]int64
+
+// Add adds an amount to its currency's total
+func (t MoneyTotals) Add(m Money) error {
+	sum, err := NewMoney(t[m.Currency], m.Currency).Add(m)
+	if err != nil {
+		return err
+	}
+	t[m.Currency] = sum.Amount
+	return nil
+}
+
+// List returns the totals sorted by currency
+func (t MoneyTotals) List() []Money {
+	list := make([]Money, 0, len(t))
+	for currency, amount := range t {
+		list = append(list, Money{Amount: amount, Currency: currency})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
+
+// UnmarshalJSON also accepts a bare number, read as US dollars, which is
+// how revenue was stored before it was kept per currency
+func (t *MoneyTotals) UnmarshalJSON(data []byte) error {
+	var legacy float64
+	if json.Unmarshal(data, &legacy) == nil {
+		*t = MoneyTotals{}
+		if legacy == 0 {
+			return nil
+		}
+		amount, err := MoneyFromMajor(legacy, defaultCurrency)
+		if err != nil {
+			return err
+		}
+		(*t)[defaultCurrency] = amount.Amount
+		return nil
+	}
+	var totals map[string]int64
+	err := json.Unmarshal(data, &totals)
+	*t = totals
+	return err
+}
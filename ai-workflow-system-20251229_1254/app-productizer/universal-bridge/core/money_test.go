@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{"29.99", "usd", 2999, false},
+		{"29.99", "USD", 2999, false},
+		{"0.005", "usd", 1, false},
+		{"0.0049", "usd", 0, false},
+		{"-0.005", "usd", -1, false},
+		{"1.5", "jpy", 2, false},
+		{"1.2345", "kwd", 1235, false},
+		{" 10 ", "eur", 1000, false},
+		{"1e2", "usd", 10000, false},
+		{"ten", "usd", 0, true},
+		{"1", "", 100, false}, // the default currency
+		{"1", "dollars", 0, true},
+		{"1e30", "usd", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.amount, tt.currency)
+		if (err != nil) != tt.wantErr || (err == nil && got.Amount != tt.want) {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v; want %d", tt.amount, tt.currency, got.Amount, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyFromMajor(t *testing.T) {
+	tests := []struct {
+		amount  float64
+		want    int64
+		wantErr bool
+	}{
+		{29.99, 2999, false},
+		{0.1 + 0.2, 30, false},
+		{19.995, 2000, false},
+		{math.NaN(), 0, true},
+		{math.Inf(1), 0, true},
+	}
+	for _, tt := range tests {
+		got, err := MoneyFromMajor(tt.amount, "usd")
+		if (err != nil) != tt.wantErr || (err == nil && got.Amount != tt.want) {
+			t.Errorf("MoneyFromMajor(%v) = %v, %v; want %d", tt.amount, got.Amount, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(2999, "usd"), "29.99"},
+		{NewMoney(5, "usd"), "0.05"},
+		{NewMoney(-5, "usd"), "-0.05"},
+		{NewMoney(0, "usd"), "0.00"},
+		{NewMoney(1500, "jpy"), "1500"},
+		{NewMoney(1, "kwd"), "0.001"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", tt.money, got, tt.want)
+		}
+		parsed, err := ParseMoney(tt.want, tt.money.Currency)
+		if err != nil || parsed != tt.money {
+			t.Errorf("ParseMoney(%q) = %v, %v; want it back", tt.want, parsed, err)
+		}
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  int64
+		weights []float64
+		want    []int64
+		wantErr bool
+	}{
+		{"even split", 100, []float64{1, 1, 1}, []int64{34, 33, 33}, false},
+		{"largest remainder", 100, []float64{1, 2}, []int64{33, 67}, false},
+		{"exact", 90, []float64{1, 2}, []int64{30, 60}, false},
+		{"refund", -100, []float64{1, 1, 1}, []int64{-34, -33, -33}, false},
+		{"fractional weights", 1000, []float64{0.3, 0.3, 0.4}, []int64{300, 300, 400}, false},
+		{"more parts than cents", 2, []float64{1, 1, 1}, []int64{1, 1, 0}, false},
+		{"zero weight part", 100, []float64{0, 1}, []int64{0, 100}, false},
+		{"no weights", 100, nil, nil, true},
+		{"all zero", 100, []float64{0, 0}, nil, true},
+		{"negative weight", 100, []float64{1, -1}, nil, true},
+		{"NaN weight", 100, []float64{1, math.NaN()}, nil, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := NewMoney(tt.amount, "usd").Allocate(tt.weights)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Allocate(%v) = %v, want an error", tt.weights, parts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int64, len(parts))
+			var sum int64
+			for i, part := range parts {
+				got[i] = part.Amount
+				sum += part.Amount
+				if part.Currency != "usd" {
+					t.Fatalf("part %d in %q", i, part.Currency)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) || sum != tt.amount {
+				t.Fatalf("Allocate(%v) of %d = %v, want %v", tt.weights, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	usd := func(amount int64) Money { return NewMoney(amount, "usd") }
+
+	if _, err := usd(1).Add(NewMoney(1, "eur")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add across currencies = %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := usd(math.MaxInt64).Add(usd(1)); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Add overflow = %v, want ErrMoneyOverflow", err)
+	}
+	if _, err := usd(math.MaxInt64 / 2).Mul(3); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Mul overflow = %v, want ErrMoneyOverflow", err)
+	}
+
+	divisions := []struct {
+		amount, by, want int64
+	}{
+		{100, 3, 33},
+		{101, 2, 51},
+		{-101, 2, -51},
+		{5, -2, -3},
+	}
+	for _, d := range divisions {
+		if got, err := usd(d.amount).Div(d.by); got.Amount != d.want || err != nil {
+			t.Errorf("%d.Div(%d) = %d, %v; want %d", d.amount, d.by, got.Amount, err, d.want)
+		}
+	}
+	if _, err := usd(100).Div(0); !errors.Is(err, ErrDivideByZero) {
+		t.Errorf("Div(0) = %v, want ErrDivideByZero", err)
+	}
+	if _, err := usd(math.MinInt64).Div(-1); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("Div overflow = %v, want ErrMoneyOverflow", err)
+	}
+
+	if got := usd(-1275).FloorMajor(); got.Amount != -1300 {
+		t.Errorf("FloorMajor(-12.75) = %v, want -13.00", got)
+	}
+}
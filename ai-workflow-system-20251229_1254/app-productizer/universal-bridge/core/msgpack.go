@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MsgpackContentType is the message as a MessagePack map with the same keys
+// as its JSON
+const MsgpackContentType = "application/msgpack"
+
+var errMsgpackTruncated = errors.New("truncated msgpack")
+
+// MsgpackSerializer encodes messages as MessagePack. A message decodes to
+// the same map its JSON does, so a peer can unpack it with any msgpack
+// library and hand the result to its JSON code.
+type MsgpackSerializer struct{}
+
+// Marshal encodes the message; payload values that are not JSON types go
+// through their JSON form
+func (MsgpackSerializer) Marshal(message *UniversalMessage) ([]byte, error) {
+	fields := 8
+	if message.Sequence != 0 {
+		fields++
+	}
+	if len(message.Headers) > 0 {
+		fields++
+	}
+	if message.ContentEncoding != "" {
+		fields++
+	}
+	if message.ExpiresAt != "" {
+		fields++
+	}
+	if message.Priority != "" {
+		fields++
+	}
+	if message.IdempotencyKey != "" {
+		fields++
+	}
+	if message.DeliverAt != "" {
+		fields++
+	}
+	b := msgpackAppendMapHeader(nil, fields)
+	for _, field := range [][2]string{
+		{"id", message.ID},
+		{"timestamp", message.Timestamp},
+		{"message_type", string(message.MessageType)},
+		{"source_language", message.SourceLanguage},
+		{"target_language", message.TargetLanguage},
+	} {
+		b = msgpackAppendString(b, field[0])
+		b = msgpackAppendString(b, field[1])
+	}
+	b = msgpackAppendString(b, "payload")
+	if message.Payload == nil {
+		b = append(b, 0xc0)
+	} else {
+		var err error
+		b, err = msgpackAppendValue(b, message.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("payload: %v", err)
+		}
+	}
+	b = msgpackAppendString(b, "response_channel")
+	b = msgpackAppendString(b, string(message.ResponseChannel))
+	b = msgpackAppendString(b, "checksum")
+	b = msgpackAppendString(b, message.Checksum)
+	if message.Sequence != 0 {
+		b = msgpackAppendString(b, "sequence")
+		b = msgpackAppendUint(b, message.Sequence)
+	}
+	if len(message.Headers) > 0 {
+		names := make([]string, 0, len(message.Headers))
+		for name := range message.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b = msgpackAppendString(b, "headers")
+		b = msgpackAppendMapHeader(b, len(names))
+		for _, name := range names {
+			b = msgpackAppendString(b, name)
+			b = msgpackAppendString(b, message.Headers[name])
+		}
+	}
+	if message.ContentEncoding != "" {
+		b = msgpackAppendString(b, "content_encoding")
+		b = msgpackAppendString(b, message.ContentEncoding)
+	}
+	if message.ExpiresAt != "" {
+		b = msgpackAppendString(b, "expires_at")
+		b = msgpackAppendString(b, message.ExpiresAt)
+	}
+	if message.Priority != "" {
+		b = msgpackAppendString(b, "priority")
+		b = msgpackAppendString(b, message.Priority)
+	}
+	if message.IdempotencyKey != "" {
+		b = msgpackAppendString(b, "idempotency_key")
+		b = msgpackAppendString(b, message.IdempotencyKey)
+	}
+	if message.DeliverAt != "" {
+		b = msgpackAppendString(b, "deliver_at")
+		b = msgpackAppendString(b, message.DeliverAt)
+	}
+	return b, nil
+}
+
+// Unmarshal decodes one message. Numbers in the payload decode as float64,
+// as they do from JSON; keys the message does not have are skipped.
+func (MsgpackSerializer) Unmarshal(data []byte) (*UniversalMessage, error) {
+	value, rest, err := msgpackDecode(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes after the message", len(rest))
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("message is not a map")
+	}
+
+	message := &UniversalMessage{}
+	text := func(key string) (string, error) {
+		switch v := fields[key].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		}
+		return "", fmt.Errorf("%s is not a string", key)
+	}
+	for key, target := range map[string]*string{
+		"id":               &message.ID,
+		"timestamp":        &message.Timestamp,
+		"source_language":  &message.SourceLanguage,
+		"target_language":  &message.TargetLanguage,
+		"checksum":         &message.Checksum,
+		"content_encoding": &message.ContentEncoding,
+		"expires_at":       &message.ExpiresAt,
+		"priority":         &message.Priority,
+		"idempotency_key":  &message.IdempotencyKey,
+		"deliver_at":       &message.DeliverAt,
+	} {
+		if *target, err = text(key); err != nil {
+			return nil, err
+		}
+	}
+	messageType, err := text("message_type")
+	if err != nil {
+		return nil, err
+	}
+	message.MessageType = MessageType(messageType)
+	channel, err := text("response_channel")
+	if err != nil {
+		return nil, err
+	}
+	message.ResponseChannel = CommunicationChannel(channel)
+
+	switch payload := fields["payload"].(type) {
+	case nil:
+	case map[string]interface{}:
+		message.Payload = payload
+	default:
+		return nil, fmt.Errorf("payload is not a map")
+	}
+	if sequence, ok := fields["sequence"]; ok {
+		number, ok := sequence.(float64)
+		if !ok || number < 0 || number != math.Trunc(number) {
+			return nil, fmt.Errorf("sequence is not a whole number")
+		}
+		message.Sequence = uint64(number)
+	}
+	switch headers := fields["headers"].(type) {
+	case nil:
+	case map[string]interface{}:
+		message.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("header %s is not a string", name)
+			}
+			message.Headers[name] = s
+		}
+	default:
+		return nil, fmt.Errorf("headers is not a map")
+	}
+	return message, nil
+}
+
+// ContentType returns application/msgpack
+func (MsgpackSerializer) ContentType() string {
+	return MsgpackContentType
+}
+
+// msgpackAppendValue appends a JSON-like value, map keys in order so
+// equal payloads encode the same
+func msgpackAppendValue(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return msgpackAppendString(b, v), nil
+	case int:
+		return msgpackAppendInt(b, int64(v)), nil
+	case int32:
+		return msgpackAppendInt(b, int64(v)), nil
+	case int64:
+		return msgpackAppendInt(b, v), nil
+	case uint64:
+		return msgpackAppendUint(b, v), nil
+	case float32:
+		return msgpackAppendFloat(b, float64(v))
+	case float64:
+		return msgpackAppendFloat(b, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = msgpackAppendMapHeader(b, len(keys))
+		for _, key := range keys {
+			b = msgpackAppendString(b, key)
+			var err error
+			b, err = msgpackAppendValue(b, v[key])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		return b, nil
+	case []interface{}:
+		b = msgpackAppendArrayHeader(b, len(v))
+		for i, item := range v {
+			var err error
+			b, err = msgpackAppendValue(b, item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+		return b, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	return msgpackAppendValue(b, decoded)
+}
+
+// msgpackAppendFloat writes whole numbers as integers, which is how JSON
+// writes them and what the checksum expects a peer to see
+func msgpackAppendFloat(b []byte, f float64) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%v is not a JSON number", f)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return msgpackAppendInt(b, int64(f)), nil
+	}
+	b = append(b, 0xcb)
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+}
+
+func msgpackAppendInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return msgpackAppendUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+func msgpackAppendUint(b []byte, n uint64) []byte {
+	switch {
+	case n < 128:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
+
+func msgpackAppendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func msgpackAppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func msgpackAppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// msgpackMaxDepth bounds nesting so a crafted message cannot exhaust the stack
+const msgpackMaxDepth = 100
+
+// msgpackSizes is the size of the number or length that follows each of
+// the remaining type bytes
+var msgpackSizes = map[byte]int{
+	0xc4: 1, 0xc5: 2, 0xc6: 4, 0xca: 4, 0xcb: 8, 0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8,
+	0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8, 0xd9: 1, 0xda: 2, 0xdb: 4, 0xdc: 2, 0xdd: 4, 0xde: 2, 0xdf: 4,
+}
+
+// msgpackDecode decodes the value at the start of data, returning what
+// follows it. Numbers are float64, binary is []byte, and map keys must be
+// strings; extension types are refused.
+func msgpackDecode(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > msgpackMaxDepth {
+		return nil, nil, fmt.Errorf("msgpack nested over %d deep", msgpackMaxDepth)
+	}
+	if len(data) == 0 {
+		return nil, nil, errMsgpackTruncated
+	}
+	head, data := data[0], data[1:]
+	switch {
+	case head <= 0x7f:
+		return float64(head), data, nil
+	case head >= 0xe0:
+		return float64(int8(head)), data, nil
+	case head&0xf0 == 0x80:
+		return msgpackDecodeMap(data, int(head&0x0f), depth)
+	case head&0xf0 == 0x90:
+		return msgpackDecodeArray(data, int(head&0x0f), depth)
+	case head&0xe0 == 0xa0:
+		return msgpackDecodeString(data, int(head&0x1f))
+	}
+
+	switch head {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	}
+	size, ok := msgpackSizes[head]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported msgpack type 0x%02x", head)
+	}
+	if len(data) < size {
+		return nil, nil, errMsgpackTruncated
+	}
+	var n uint64
+	for _, c := range data[:size] {
+		n = n<<8 | uint64(c)
+	}
+	data = data[size:]
+	switch head {
+	case 0xc4, 0xc5, 0xc6:
+		if uint64(len(data)) < n {
+			return nil, nil, errMsgpackTruncated
+		}
+		return append([]byte(nil), data[:n]...), data[n:], nil
+	case 0xca:
+		return float64(math.Float32frombits(uint32(n))), data, nil
+	case 0xcb:
+		return math.Float64frombits(n), data, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return float64(n), data, nil
+	case 0xd0:
+		return float64(int8(n)), data, nil
+	case 0xd1:
+		return float64(int16(n)), data, nil
+	case 0xd2:
+		return float64(int32(n)), data, nil
+	case 0xd3:
+		return float64(int64(n)), data, nil
+	case 0xd9, 0xda, 0xdb:
+		return msgpackDecodeString(data, int(n))
+	case 0xdc, 0xdd:
+		return msgpackDecodeArray(data, int(n), depth)
+	}
+	return msgpackDecodeMap(data, int(n), depth)
+}
+
+func msgpackDecodeString(data []byte, n int) (interface{}, []byte, error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, errMsgpackTruncated
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func msgpackDecodeArray(data []byte, n, depth int) (interface{}, []byte, error) {
+	// Every item takes at least a byte, so a bigger count is a lie
+	if n < 0 || n > len(data) {
+		return nil, nil, errMsgpackTruncated
+	}
+	list := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item, rest, err := msgpackDecode(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, item)
+		data = rest
+	}
+	return list, data, nil
+}
+
+func msgpackDecodeMap(data []byte, n, depth int) (interface{}, []byte, error) {
+	if n < 0 || 2*n > len(data) {
+		return nil, nil, errMsgpackTruncated
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := msgpackDecode(data, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("msgpack map key %v is not a string", key)
+		}
+		object[name], data, err = msgpackDecode(rest, depth+1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return object, data, nil
+}
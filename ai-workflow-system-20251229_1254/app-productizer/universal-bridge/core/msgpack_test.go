@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A peer unpacking the msgpack with any library gets what it would have
+// parsed from the JSON
+func TestMsgpackMatchesJSON(t *testing.T) {
+	for name, message := range goldenMessages() {
+		message.Sequence = 7
+		message.Headers = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+		data, err := MsgpackSerializer{}.Marshal(message)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		unpacked, rest, err := msgpackDecode(data, 0)
+		if err != nil || len(rest) > 0 {
+			t.Fatalf("%s: %v, %d bytes left", name, err, len(rest))
+		}
+		text, err := message.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(unpacked, parsed) {
+			t.Errorf("%s unpacks to\n%v\nwant\n%v", name, unpacked, parsed)
+		}
+	}
+}
+
+func TestMsgpackUnmarshal(t *testing.T) {
+	// Encodings other libraries pick: str8 keys, int8, uint16, float32 and bin
+	foreign := []byte{0x82,
+		0xd9, 0x07, 'p', 'a', 'y', 'l', 'o', 'a', 'd', 0x84,
+		0xa1, 'a', 0xd0, 0x9c,
+		0xa1, 'b', 0xcd, 0x01, 0x00,
+		0xa1, 'c', 0xca, 0x3f, 0xc0, 0x00, 0x00,
+		0xa1, 'd', 0xc4, 0x02, 'h', 'i',
+		0xa2, 'i', 'd', 0xa3, 'm', '-', '1',
+	}
+	message, err := MsgpackSerializer{}.Unmarshal(foreign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"a": -100.0, "b": 256.0, "c": 1.5, "d": []byte("hi")}
+	if message.ID != "m-1" || !reflect.DeepEqual(message.Payload, want) {
+		t.Errorf("decoded %+v", message)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"truncated", []byte{0x81, 0xa2, 'i'}, "truncated msgpack"},
+		{"huge map", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, "truncated msgpack"},
+		{"not a map", []byte{0x93, 0x01, 0x02, 0x03}, "message is not a map"},
+		{"integer key", []byte{0x81, 0x01, 0x02}, "msgpack map key 1 is not a string"},
+		{"extension", []byte{0x81, 0xa1, 'x', 0xd4, 0x01, 0x00}, "unsupported msgpack type 0xd4"},
+		{"bad id", []byte{0x81, 0xa2, 'i', 'd', 0x2a}, "id is not a string"},
+		{"trailing", []byte{0x80, 0xc0}, "1 bytes after the message"},
+	}
+	for _, test := range tests {
+		if _, err := (MsgpackSerializer{}).Unmarshal(test.data); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsTimeout bounds connecting, each write, and waiting for a PONG
+const natsTimeout = 10 * time.Second
+
+// natsOptions is where a NATS server is and how to log in, parsed from a
+// nats:// or tls:// URL
+type natsOptions struct {
+	Addr     string
+	User     string
+	Password string
+	Token    string
+	TLS      bool
+}
+
+// parseNATSURL reads nats://[user:password@|token@]host[:port]; tls://
+// connects with TLS
+func parseNATSURL(raw string) (natsOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return natsOptions{}, fmt.Errorf("bad NATS URL: %v", err)
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return natsOptions{}, fmt.Errorf("bad NATS URL %q: use nats://host:port or tls://", u.Redacted())
+	}
+	options := natsOptions{Addr: u.Host, TLS: u.Scheme == "tls"}
+	if u.Port() == "" {
+		options.Addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options.User, options.Password = u.User.Username(), password
+		} else {
+			options.Token = u.User.Username()
+		}
+	}
+	return options, nil
+}
+
+// natsInfo is the part of the server's INFO the client uses
+type natsInfo struct {
+	ServerID    string `json:"server_id"`
+	MaxPayload  int64  `json:"max_payload"`
+	Headers     bool   `json:"headers"`
+	TLSRequired bool   `json:"tls_required"`
+}
+
+// natsMsg is one message delivered on a subscription
+type natsMsg struct {
+	Subject string
+	SID     int
+	Reply   string
+	Header  textproto.MIMEHeader
+	Data    []byte
+}
+
+// natsConn is one connection speaking the NATS client protocol. Writes are
+// safe for concurrent use; read must run on one goroutine for PINGs to be
+// answered and flush to return.
+type natsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	info   natsInfo
+
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	pongs   chan struct{}
+}
+
+// dialNATS connects, upgrades to TLS when asked or required, and logs in.
+// A PING answered before anything else confirms the server took CONNECT.
+func dialNATS(options natsOptions, name string) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", options.Addr, natsTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach NATS at %s: %v", options.Addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	nc := &natsConn{conn: conn, reader: bufio.NewReader(conn), pongs: make(chan struct{}, 8)}
+	fail := func(format string, err error) (*natsConn, error) {
+		conn.Close()
+		return nil, fmt.Errorf(format, options.Addr, err)
+	}
+
+	line, err := nc.readLine()
+	if err != nil {
+		return fail("no INFO from NATS at %s: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail("NATS at %s: %v", fmt.Errorf("expected INFO, got %q", line))
+	}
+	if err = json.Unmarshal([]byte(line[5:]), &nc.info); err != nil {
+		return fail("bad INFO from NATS at %s: %v", err)
+	}
+	if options.TLS || nc.info.TLSRequired {
+		host, _, _ := net.SplitHostPort(options.Addr)
+		secure := tls.Client(conn, &tls.Config{ServerName: host})
+		if err = secure.Handshake(); err != nil {
+			return fail("TLS with NATS at %s failed: %v", err)
+		}
+		nc.conn, nc.reader = secure, bufio.NewReader(secure)
+	}
+	nc.writer = bufio.NewWriter(nc.conn)
+
+	connect, _ := json.Marshal(map[string]interface{}{
+		"verbose": false, "pedantic": false, "lang": "go", "version": "universal-bridge", "protocol": 1,
+		"headers": nc.info.Headers, "name": name,
+		"user": options.User, "pass": options.Password, "auth_token": options.Token,
+	})
+	if err = nc.write("CONNECT "+string(connect)+"\r\nPING\r\n", nil); err != nil {
+		return fail("failed to log in to NATS at %s: %v", err)
+	}
+	for {
+		line, err = nc.readLine()
+		if err != nil {
+			return fail("failed to log in to NATS at %s: %v", err)
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fail("failed to log in to NATS at %s: %v", fmt.Errorf("%s", strings.Trim(line[4:], " '")))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return nc, nil
+}
+
+func (nc *natsConn) readLine() (string, error) {
+	line, err := nc.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// write sends a protocol line and, when data is not nil, its payload
+func (nc *natsConn) write(line string, data []byte) error {
+	nc.writeMu.Lock()
+	defer nc.writeMu.Unlock()
+	nc.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	nc.writer.WriteString(line)
+	if data != nil {
+		nc.writer.Write(data)
+		nc.writer.WriteString("\r\n")
+	}
+	return nc.writer.Flush()
+}
+
+// publish sends data to subject, asking for answers on reply when it is
+// set. Headers need a server that supports them.
+func (nc *natsConn) publish(subject, reply string, header map[string]string, data []byte) error {
+	if reply != "" {
+		subject += " " + reply
+	}
+	if len(header) == 0 {
+		return nc.write(fmt.Sprintf("PUB %s %d\r\n", subject, len(data)), data)
+	}
+	if !nc.info.Headers {
+		return fmt.Errorf("NATS server %s does not support headers", nc.info.ServerID)
+	}
+	var block bytes.Buffer
+	block.WriteString("NATS/1.0\r\n")
+	for name, value := range header {
+		fmt.Fprintf(&block, "%s: %s\r\n", name, value)
+	}
+	block.WriteString("\r\n")
+	headerLen := block.Len()
+	block.Write(data)
+	return nc.write(fmt.Sprintf("HPUB %s %d %d\r\n", subject, headerLen, block.Len()), block.Bytes())
+}
+
+// subscribe starts delivering subject's messages with the subscription ID.
+// Subscribers sharing a queue group each get a share of the messages.
+func (nc *natsConn) subscribe(subject, queue string, sid int) error {
+	if queue != "" {
+		subject += " " + queue
+	}
+	return nc.write(fmt.Sprintf("SUB %s %d\r\n", subject, sid), nil)
+}
+
+func (nc *natsConn) unsubscribe(sid int) error {
+	return nc.write(fmt.Sprintf("UNSUB %d\r\n", sid), nil)
+}
+
+// flush waits until the server has processed everything written so far
+func (nc *natsConn) flush() error {
+	if err := nc.write("PING\r\n", nil); err != nil {
+		return err
+	}
+	select {
+	case <-nc.pongs:
+		return nil
+	case <-time.After(natsTimeout):
+		return fmt.Errorf("NATS did not answer a PING within %s", natsTimeout)
+	}
+}
+
+// read hands each message to handle until the connection fails, answering
+// the server's PINGs. A -ERR ends it, since the server closes the
+// connection after most of them.
+func (nc *natsConn) read(handle func(natsMsg)) error {
+	for {
+		line, err := nc.readLine()
+		if err != nil {
+			return err
+		}
+		verb, rest, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "PING":
+			if err = nc.write("PONG\r\n", nil); err != nil {
+				return err
+			}
+		case "PONG":
+			select {
+			case nc.pongs <- struct{}{}:
+			default:
+			}
+		case "-ERR":
+			return fmt.Errorf("NATS error: %s", strings.Trim(rest, " '"))
+		case "MSG", "HMSG":
+			msg, err := nc.readMsg(verb == "HMSG", strings.Fields(rest))
+			if err != nil {
+				return err
+			}
+			handle(msg)
+		}
+	}
+}
+
+// readMsg reads a message after its MSG subject sid [reply] size or HMSG
+// subject sid [reply] header-size total-size line
+func (nc *natsConn) readMsg(headers bool, fields []string) (natsMsg, error) {
+	sizes := 1
+	if headers {
+		sizes = 2
+	}
+	if len(fields) != 2+sizes && len(fields) != 3+sizes {
+		return natsMsg{}, fmt.Errorf("bad NATS message line %q", strings.Join(fields, " "))
+	}
+	msg := natsMsg{Subject: fields[0]}
+	if len(fields) == 3+sizes {
+		msg.Reply = fields[2]
+	}
+	var err error
+	msg.SID, err = strconv.Atoi(fields[1])
+	headerLen, totalLen := 0, 0
+	if err == nil {
+		totalLen, err = strconv.Atoi(fields[len(fields)-1])
+	}
+	if err == nil && headers {
+		headerLen, err = strconv.Atoi(fields[len(fields)-2])
+	}
+	if err != nil || headerLen > totalLen {
+		return natsMsg{}, fmt.Errorf("bad NATS message line %q", strings.Join(fields, " "))
+	}
+
+	data := make([]byte, totalLen+2)
+	if _, err = io.ReadFull(nc.reader, data); err != nil {
+		return natsMsg{}, err
+	}
+	if headerLen > 0 {
+		reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data[:headerLen])))
+		if _, err = reader.ReadLine(); err == nil {
+			msg.Header, err = reader.ReadMIMEHeader()
+		}
+		if err != nil && err != io.EOF {
+			return natsMsg{}, fmt.Errorf("bad NATS message headers: %v", err)
+		}
+	}
+	msg.Data = data[headerLen:totalLen]
+	return msg, nil
+}
+
+// Close closes the connection
+func (nc *natsConn) Close() error {
+	return nc.conn.Close()
+}
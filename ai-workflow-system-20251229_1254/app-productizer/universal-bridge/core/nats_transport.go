@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultNATSPrefix = "bridge"
+	// natsReplyTTL is how long a request's reply subject is kept for its answer
+	natsReplyTTL = 10 * time.Minute
+	// natsInboxSID is the subscription for answers to this bridge's requests;
+	// the subjects it receives are numbered after it
+	natsInboxSID = 1
+)
+
+// NATSTransport carries messages over NATS subjects named
+// <prefix>.<target language>.<message type>, so other services can watch
+// one type across languages with bridge.*.sale_event, or everything with
+// bridge.>. Bridges of one language subscribe in a queue group named after
+// it and share the messages between them.
+//
+// Every message the bridge sends asks for answers on an inbox subject only
+// it subscribes to, and a reply to a received message goes to the inbox
+// its request named. So a reply reaches the instance that sent the request
+// and is waiting for it, not whichever of its peers the queue group picks.
+//
+// NATS delivers at most once: an envelope that is nacked is logged and
+// lost. Use the Redis transport where messages must survive restarts.
+type NATSTransport struct {
+	// Prefix is the first token of every subject
+	Prefix string
+	// Subjects are what the bridge receives; <prefix>.<language>.> by
+	// default
+	Subjects []string
+	// Queue is the queue group the subjects are subscribed in; the language
+	// by default, empty for every bridge to get every message
+	Queue string
+	// Name identifies the connection in the server's monitoring
+	Name string
+	// Retries is how many times a send is retried while reconnecting
+	Retries int
+	// Serializer encodes sent messages; nil is JSON
+	Serializer Serializer
+
+	options  natsOptions
+	language string
+	clock    Clock
+	inbox    string
+
+	mu         sync.Mutex
+	conn       *natsConn
+	connected  chan struct{} // closed while conn is set
+	deliver    func(*Envelope)
+	receiving  bool
+	subscribed *natsConn            // the connection the subjects are subscribed on
+	replies    map[string]natsReply // by request ID
+	running    bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// natsReply is where a received request asked to be answered
+type natsReply struct {
+	subject  string
+	received time.Time
+}
+
+// NewNATSTransport creates a transport on the NATS server at rawURL that
+// receives the language's messages
+func NewNATSTransport(rawURL, language string, clock Clock) (*NATSTransport, error) {
+	options, err := parseNATSURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if clock == nil {
+		clock = defaultClock
+	}
+	host, _ := os.Hostname()
+	return &NATSTransport{
+		Prefix:    defaultNATSPrefix,
+		Queue:     language,
+		Name:      fmt.Sprintf("%s bridge on %s", language, host),
+		Retries:   3,
+		options:   options,
+		language:  language,
+		clock:     clock,
+		inbox:     "_INBOX." + strings.ReplaceAll(uuid.NewString(), "-", ""),
+		connected: make(chan struct{}),
+		replies:   make(map[string]natsReply),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}, nil
+}
+
+// Channel returns NATS
+func (nt *NATSTransport) Channel() CommunicationChannel {
+	return NATS
+}
+
+func (nt *NATSTransport) subjects() []string {
+	if len(nt.Subjects) > 0 {
+		return nt.Subjects
+	}
+	return []string{nt.Prefix + "." + nt.language + ".>"}
+}
+
+// Subject returns the subject a message is published to
+func (nt *NATSTransport) Subject(message *UniversalMessage) (string, error) {
+	for _, token := range []string{message.TargetLanguage, string(message.MessageType)} {
+		if token == "" || strings.ContainsAny(token, ".*> \t\r\n") {
+			return "", fmt.Errorf("cannot route %s over NATS: %q is not a subject token", message.ID, token)
+		}
+	}
+	return nt.Prefix + "." + message.TargetLanguage + "." + string(message.MessageType), nil
+}
+
+// Start subscribes and keeps the connection up in the background
+func (nt *NATSTransport) Start(deliver func(*Envelope)) error {
+	nt.mu.Lock()
+	nt.deliver, nt.receiving = deliver, true
+	conn := nt.conn
+	nt.mu.Unlock()
+	if conn != nil {
+		// Send connected first
+		nt.subscribe(conn)
+	}
+	nt.run()
+	fmt.Printf("📡 NATS transport receiving %s in queue %q on %s\n", strings.Join(nt.subjects(), ", "), nt.Queue, nt.options.Addr)
+	return nil
+}
+
+// run starts the connection loop once
+func (nt *NATSTransport) run() {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	if nt.running {
+		return
+	}
+	nt.running = true
+	go nt.loop()
+}
+
+func (nt *NATSTransport) loop() {
+	defer close(nt.stopped)
+	delay := time.Second
+	for {
+		conn, err := dialNATS(nt.options, nt.Name)
+		if err == nil {
+			delay = time.Second
+			nt.mu.Lock()
+			nt.conn = conn
+			close(nt.connected)
+			nt.mu.Unlock()
+			if err = conn.subscribe(nt.inbox+".>", "", natsInboxSID); err == nil {
+				err = nt.subscribe(conn)
+			}
+			if err == nil {
+				err = conn.read(nt.received)
+			}
+			conn.Close()
+			nt.mu.Lock()
+			nt.conn = nil
+			nt.connected = make(chan struct{})
+			nt.mu.Unlock()
+		}
+		select {
+		case <-nt.stop:
+			return
+		default:
+		}
+		log.Printf("❌ NATS transport lost %s, reconnecting in %s: %v", nt.options.Addr, delay, err)
+		select {
+		case <-nt.stop:
+			return
+		case <-nt.clock.After(delay):
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// subscribe subscribes to the bridge's subjects while it is receiving
+func (nt *NATSTransport) subscribe(conn *natsConn) error {
+	nt.mu.Lock()
+	if !nt.receiving || nt.subscribed == conn {
+		nt.mu.Unlock()
+		return nil
+	}
+	nt.subscribed = conn
+	nt.mu.Unlock()
+	for i, subject := range nt.subjects() {
+		if err := conn.subscribe(subject, nt.Queue, natsInboxSID+1+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// received delivers a message, remembering where a request wants its reply
+func (nt *NATSTransport) received(msg natsMsg) {
+	nt.mu.Lock()
+	deliver, receiving := nt.deliver, nt.receiving
+	nt.mu.Unlock()
+	// After StopReceiving only answers to the bridge's own requests are let
+	// through, for the handlers still running
+	if deliver == nil || (!receiving && msg.SID != natsInboxSID) {
+		return
+	}
+
+	contentType := msg.Header.Get("Content-Type")
+	if msg.Reply != "" {
+		if serializer, err := SerializerFor(contentType); err == nil {
+			if request, err := serializer.Unmarshal(msg.Data); err == nil {
+				nt.rememberReply(request.ID, msg.Reply)
+			}
+		}
+	}
+	envelope := NewEnvelope(NATS, "nats:"+msg.Subject, msg.Data, nil, func(reason error) {
+		log.Printf("❌ NATS cannot redeliver a message on %s, dropping it: %v", msg.Subject, reason)
+	})
+	envelope.ContentType = contentType
+	deliver(envelope)
+}
+
+func (nt *NATSTransport) rememberReply(requestID, subject string) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	now := nt.clock.Now()
+	nt.replies[requestID] = natsReply{subject: subject, received: now}
+	// Requests answered some other way, or never, are forgotten in time
+	if len(nt.replies) > 1000 {
+		for id, reply := range nt.replies {
+			if now.Sub(reply.received) > natsReplyTTL {
+				delete(nt.replies, id)
+			}
+		}
+	}
+}
+
+// replySubject returns and forgets the inbox a message answers, if any
+func (nt *NATSTransport) replySubject(message *UniversalMessage) string {
+	requestID := stringArg(message.Payload, "original_message_id")
+	if requestID == "" || message.MessageType == Ack || message.MessageType == Nack {
+		// Receipts leave the inbox to the reply
+		return ""
+	}
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	reply, ok := nt.replies[requestID]
+	delete(nt.replies, requestID)
+	if !ok || nt.clock.Now().Sub(reply.received) > natsReplyTTL {
+		return ""
+	}
+	return reply.subject
+}
+
+// connection waits for the connection, connecting a transport that has
+// not started receiving
+func (nt *NATSTransport) connection() (*natsConn, error) {
+	nt.run()
+	nt.mu.Lock()
+	conn, connected := nt.conn, nt.connected
+	nt.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	select {
+	case <-connected:
+	case <-nt.stop:
+		return nil, fmt.Errorf("NATS transport is closed")
+	case <-time.After(natsTimeout):
+		return nil, fmt.Errorf("not connected to NATS at %s", nt.options.Addr)
+	}
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	if nt.conn == nil {
+		return nil, fmt.Errorf("not connected to NATS at %s", nt.options.Addr)
+	}
+	return nt.conn, nil
+}
+
+// Send publishes the message to its subject, or straight back to the
+// instance that sent the request it answers
+func (nt *NATSTransport) Send(message *UniversalMessage) error {
+	serializer := nt.Serializer
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	body, err := serializer.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", message.ID, err)
+	}
+	var header map[string]string
+	if contentType := serializer.ContentType(); contentType != JSONContentType {
+		header = map[string]string{"Content-Type": contentType}
+	}
+
+	subject, reply := nt.replySubject(message), ""
+	if subject == "" {
+		if subject, err = nt.Subject(message); err != nil {
+			return err
+		}
+		reply = nt.inbox + "." + message.ID
+	}
+	retry := retrier{Retries: nt.Retries, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second, Clock: nt.clock,
+		Classify: func(err error) bool { _, tooLarge := err.(*PayloadTooLargeError); return !tooLarge }}
+	err = retry.do("Send of "+message.ID, func() error {
+		conn, err := nt.connection()
+		if err != nil {
+			return err
+		}
+		if limit := conn.info.MaxPayload; limit > 0 && int64(len(body)) > limit {
+			return &PayloadTooLargeError{MessageID: message.ID, Channel: NATS, Size: int64(len(body)), Limit: limit}
+		}
+		return conn.publish(subject, reply, header, body)
+	})
+	if err != nil {
+		return fmt.Errorf("sending %s to %s: %v", message.ID, subject, err)
+	}
+	return nil
+}
+
+// StopReceiving leaves the queue group, so peers take its share, while
+// answers to the bridge's requests still arrive
+func (nt *NATSTransport) StopReceiving() {
+	nt.mu.Lock()
+	wasReceiving := nt.receiving
+	nt.receiving = false
+	conn := nt.conn
+	nt.mu.Unlock()
+	if wasReceiving && conn != nil {
+		for i := range nt.subjects() {
+			conn.unsubscribe(natsInboxSID + 1 + i)
+		}
+	}
+}
+
+// Close flushes what was published and disconnects
+func (nt *NATSTransport) Close() error {
+	nt.StopReceiving()
+	nt.mu.Lock()
+	conn, running := nt.conn, nt.running
+	select {
+	case <-nt.stop:
+	default:
+		close(nt.stop)
+	}
+	nt.mu.Unlock()
+	if !running {
+		return nil
+	}
+	var err error
+	if conn != nil {
+		err = conn.flush()
+		conn.Close()
+	}
+	<-nt.stopped
+	return err
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Offer code statuses
+const (
+	OfferActive    = "active"
+	OfferExpired   = "expired"   // retired at ExpiresAt
+	OfferExhausted = "exhausted" // retired after MaxUses redemptions
+)
+
+// What to do with a code once it expires or is used up
+const (
+	OfferDisable = "disable" // cap it at its current uses, keeping it on Gumroad
+	OfferDelete  = "delete"
+)
+
+// OfferCode is a Gumroad offer code created through the bridge
+type OfferCode struct {
+	ID        string      `json:"id"` // Gumroad's offer code ID
+	Product   string      `json:"product"`
+	Code      string      `json:"code"`
+	Campaign  string      `json:"campaign,omitempty"`
+	AmountOff float64     `json:"amount_off"`
+	OfferType string      `json:"offer_type"` // "cents" or "percent"
+	MaxUses   int         `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	OnRetire  string      `json:"on_retire"`
+	Status    string      `json:"status"`
+	Uses      int         `json:"uses"`
+	Revenue   MoneyTotals `json:"revenue"`
+	CreatedAt time.Time   `json:"created_at"`
+	RetiredAt *time.Time  `json:"retired_at,omitempty"`
+	// RetireError is set while retiring keeps failing; the sweep retries it
+	RetireError string `json:"retire_error,omitempty"`
+}
+
+// CampaignStats sums redemptions across a campaign's offer codes
+type CampaignStats struct {
+	Campaign string      `json:"campaign"`
+	Codes    int         `json:"codes"`
+	Active   int         `json:"active"`
+	Uses     int         `json:"uses"`
+	Revenue  MoneyTotals `json:"revenue"`
+}
+
+// OfferCodes creates offer codes on Gumroad, counts their redemptions from
+// sales and retires them on expiry or once they reach their maximum uses
+type OfferCodes struct {
+	client *GumroadClient
+	path   string
+	clock  Clock
+
+	mu    sync.Mutex
+	codes map[string]*OfferCode // product + code → offer code
+}
+
+// NewOfferCodes opens the tracked codes persisted at path
+func NewOfferCodes(client *GumroadClient, path string, clock Clock) (*OfferCodes, error) {
+	oc := &OfferCodes{client: client, path: path, clock: clock, codes: make(map[string]*OfferCode)}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read offer codes: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &oc.codes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse offer codes %s: %v", path, err)
+		}
+	}
+	return oc, nil
+}
+
+func offerKey(product, code string) string {
+	return product + "/" + strings.ToLower(code)
+}
+
+// Create makes the code on Gumroad and starts tracking it. Product, Code,
+// AmountOff are required; OfferType defaults to "cents" and OnRetire to
+// "disable".
+func (oc *OfferCodes) Create(offer OfferCode) (OfferCode, error) {
+	if offer.Product == "" || offer.Code == "" || offer.AmountOff <= 0 {
+		return OfferCode{}, fmt.Errorf("offer code needs a product, code and amount off")
+	}
+	if offer.OfferType == "" {
+		offer.OfferType = "cents"
+	}
+	if offer.OnRetire == "" {
+		offer.OnRetire = OfferDisable
+	}
+	if offer.OnRetire != OfferDisable && offer.OnRetire != OfferDelete {
+		return OfferCode{}, fmt.Errorf("unknown on_retire %q", offer.OnRetire)
+	}
+
+	created, err := oc.client.CreateOfferCode(offer.Product, offer.Code, offer.AmountOff, offer.OfferType, offer.MaxUses)
+	if err != nil {
+		return OfferCode{}, err
+	}
+	offer.ID = created.ID
+	offer.Status = OfferActive
+	offer.Uses = 0
+	offer.Revenue = MoneyTotals{}
+	offer.CreatedAt = oc.clock.Now()
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.codes[offerKey(offer.Product, offer.Code)] = &offer
+	fmt.Printf("🏷️ Created offer code %s on %s\n", offer.Code, offer.Product)
+	return offer, oc.saveLocked()
+}
+
+// Redeem counts a sale that used one of the tracked codes, retiring the code
+// when it reaches its maximum uses
+func (oc *OfferCodes) Redeem(sale Sale) error {
+	if sale.OfferCode == "" {
+		return nil
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	offer := oc.codes[offerKey(sale.Product, sale.OfferCode)]
+	if offer == nil {
+		offer = oc.codes[offerKey(sale.Permalink, sale.OfferCode)]
+	}
+	if offer == nil {
+		return nil
+	}
+	offer.Uses++
+	if offer.Revenue == nil {
+		offer.Revenue = MoneyTotals{}
+	}
+	err := offer.Revenue.Add(sale.Price)
+	if err != nil {
+		return err
+	}
+	if offer.Status == OfferActive && offer.MaxUses > 0 && offer.Uses >= offer.MaxUses {
+		oc.retireLocked(offer, OfferExhausted)
+	}
+	return oc.saveLocked()
+}
+
+// Retire retires an active code now, ahead of its expiry
+func (oc *OfferCodes) Retire(product, code string) error {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	offer := oc.codes[offerKey(product, code)]
+	if offer == nil {
+		return fmt.Errorf("offer code %s on %s is not tracked", code, product)
+	}
+	if offer.Status == OfferActive {
+		oc.retireLocked(offer, OfferExpired)
+	}
+	return oc.saveLocked()
+}
+
+// Sweep retires active codes past their expiry and retries failed retirements
+func (oc *OfferCodes) Sweep() error {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	now := oc.clock.Now()
+	for _, offer := range oc.codes {
+		switch {
+		case offer.Status == OfferActive && offer.ExpiresAt != nil && !now.Before(*offer.ExpiresAt):
+			oc.retireLocked(offer, OfferExpired)
+		case offer.Status != OfferActive && offer.RetireError != "":
+			oc.retireLocked(offer, offer.Status)
+		}
+	}
+	return oc.saveLocked()
+}
+
+// retireLocked disables or deletes the code on Gumroad. A failure is kept on
+// the code for the next sweep to retry.
+func (oc *OfferCodes) retireLocked(offer *OfferCode, status string) {
+	var err error
+	if offer.OnRetire == OfferDelete {
+		err = oc.client.DeleteOfferCode(offer.Product, offer.ID)
+	} else {
+		err = oc.client.LimitOfferCode(offer.Product, offer.ID, offer.Uses)
+	}
+
+	offer.Status = status
+	offer.RetireError = ""
+	if err != nil {
+		offer.RetireError = err.Error()
+		log.Printf("❌ Error retiring offer code %s: %v", offer.Code, err)
+		return
+	}
+	now := oc.clock.Now()
+	offer.RetiredAt = &now
+	fmt.Printf("🏷️ Offer code %s on %s %s (%s after %d uses)\n", offer.Code, offer.Product, offer.OnRetire+"d", status, offer.Uses)
+}
+
+// List returns the tracked codes, newest first
+func (oc *OfferCodes) List() []OfferCode {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	codes := make([]OfferCode, 0, len(oc.codes))
+	for _, offer := range oc.codes {
+		codes = append(codes, *offer)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].CreatedAt.After(codes[j].CreatedAt) })
+	return codes
+}
+
+// Campaigns returns redemption totals per campaign; codes without one are
+// grouped under their product
+func (oc *OfferCodes) Campaigns() []CampaignStats {
+	totals := make(map[string]*CampaignStats)
+	for _, offer := range oc.List() {
+		campaign := offer.Campaign
+		if campaign == "" {
+			campaign = offer.Product
+		}
+		stats := totals[campaign]
+		if stats == nil {
+			stats = &CampaignStats{Campaign: campaign, Revenue: MoneyTotals{}}
+			totals[campaign] = stats
+		}
+		stats.Codes++
+		if offer.Status == OfferActive {
+			stats.Active++
+		}
+		stats.Uses += offer.Uses
+		for _, revenue := range offer.Revenue.List() {
+			stats.Revenue.Add(revenue)
+		}
+	}
+
+	campaigns := make([]CampaignStats, 0, len(totals))
+	for _, stats := range totals {
+		campaigns = append(campaigns, *stats)
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].Campaign < campaigns[j].Campaign })
+	return campaigns
+}
+
+// Handler serves GET /metrics/offer-codes with per-campaign redemption stats
+// and every tracked code
+func (oc *OfferCodes) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+			"campaigns": oc.Campaigns(),
+			"codes":     oc.List(),
+		})
+	})
+}
+
+func (oc *OfferCodes) saveLocked() error {
+	return writeJSONFile(oc.path, oc.codes)
+}
+
+// TrackOfferCodes counts redemptions of tracked codes in received sales
+func TrackOfferCodes(gb *GoBridge, offers *OfferCodes) {
+	gb.OnReceive(func(message *UniversalMessage) {
+		if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+			return
+		}
+		sale, err := ParseSale(message.Payload)
+		if err == nil {
+			err = offers.Redeem(sale)
+		}
+		if err != nil {
+			log.Printf("❌ Error counting offer code redemption from %s: %v", message.ID, err)
+		}
+	})
+}
+
+// RegisterOfferCodeJobs adds two job handlers:
+//
+//	offer_code_sweep   retires expired codes
+//	create_offer_code  creates the code described by args: product, code,
+//	                   amount_off, offer_type, max_uses, campaign, on_retire
+//	                   and expires_in (a duration such as 72h)
+func RegisterOfferCodeJobs(s *Scheduler, offers *OfferCodes) {
+	s.Handle("offer_code_sweep", func(job Job) error {
+		return offers.Sweep()
+	})
+
+	s.Handle("create_offer_code", func(job Job) error {
+		offer, err := offerFromArgs(job.Args, offers.clock.Now())
+		if err != nil {
+			return err
+		}
+		_, err = offers.Create(offer)
+		return err
+	})
+}
+
+// offerFromArgs reads an offer code from job or action arguments
+func offerFromArgs(args map[string]interface{}, now time.Time) (OfferCode, error) {
+	offer := OfferCode{
+		Product:   stringArg(args, "product"),
+		Code:      stringArg(args, "code"),
+		Campaign:  stringArg(args, "campaign"),
+		OfferType: stringArg(args, "offer_type"),
+		OnRetire:  stringArg(args, "on_retire"),
+	}
+	offer.AmountOff, _ = toNumber(args["amount_off"])
+	if maxUses, ok := toNumber(args["max_uses"]); ok {
+		offer.MaxUses = int(maxUses)
+	}
+	if value := stringArg(args, "expires_in"); value != "" {
+		expiresIn, err := time.ParseDuration(value)
+		if err != nil {
+			return OfferCode{}, fmt.Errorf("bad expires_in: %v", err)
+		}
+		expiresAt := now.Add(expiresIn)
+		offer.ExpiresAt = &expiresAt
+	}
+	return offer, nil
+}
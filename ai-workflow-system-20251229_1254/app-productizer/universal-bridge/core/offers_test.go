@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOfferAPI creates, caps and deletes offer codes, recording each call
+type fakeOfferAPI struct {
+	mu    sync.Mutex
+	calls []string // method, path and max_purchase_count
+	down  bool
+	next  int
+}
+
+func (f *fakeOfferAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.ParseForm()
+	f.calls = append(f.calls, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, r.Form.Get("max_purchase_count")))
+	if f.down {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"success":false,"message":"unavailable"}`)
+		return
+	}
+	if r.Method == http.MethodPost {
+		f.next++
+		fmt.Fprintf(w, `{"success":true,"offer_code":{"id":"oc%d","name":%q}}`, f.next, r.Form.Get("name"))
+		return
+	}
+	fmt.Fprint(w, `{"success":true}`)
+}
+
+func (f *fakeOfferAPI) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeOfferAPI) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func newTestOfferCodes(t *testing.T, path string, clock Clock) (*OfferCodes, *fakeOfferAPI) {
+	t.Helper()
+	api := &fakeOfferAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client := NewGumroadClient()
+	client.BaseURL = server.URL
+	client.AccessToken = "token"
+	client.Retries = 0
+	offers, err := NewOfferCodes(client, path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offers, api
+}
+
+func TestOfferCodeCreate(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	offers, api := newTestOfferCodes(t, filepath.Join(t.TempDir(), "offers.json"), clock)
+
+	invalid := []OfferCode{
+		{Code: "SPRING", AmountOff: 10},
+		{Product: "ebook", AmountOff: 10},
+		{Product: "ebook", Code: "SPRING"},
+		{Product: "ebook", Code: "SPRING", AmountOff: 10, OnRetire: "archive"},
+	}
+	for _, offer := range invalid {
+		if _, err := offers.Create(offer); err == nil {
+			t.Errorf("created %+v", offer)
+		}
+	}
+	if calls := api.take(); len(calls) != 0 {
+		t.Errorf("invalid codes called Gumroad: %v", calls)
+	}
+
+	created, err := offers.Create(OfferCode{Product: "ebook", Code: "SPRING", AmountOff: 200, MaxUses: 5, Uses: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "oc1" || created.OfferType != "cents" || created.OnRetire != OfferDisable || created.Status != OfferActive || created.Uses != 0 || !created.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("created %+v, want oc1 active in cents, disabled on retire, with no uses", created)
+	}
+	if calls := api.take(); !reflect.DeepEqual(calls, []string{"POST /products/ebook/offer_codes 5"}) {
+		t.Errorf("calls %v", calls)
+	}
+
+	api.set(func() { api.down = true })
+	if _, err := offers.Create(OfferCode{Product: "ebook", Code: "SUMMER", AmountOff: 200}); err == nil {
+		t.Error("created a code Gumroad refused")
+	}
+	if list := offers.List(); len(list) != 1 {
+		t.Errorf("tracking %d codes, want only the one Gumroad created", len(list))
+	}
+}
+
+func TestOfferCodeRetirement(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "offers.json")
+	offers, api := newTestOfferCodes(t, path, clock)
+	expiresAt := clock.Now().Add(72 * time.Hour)
+	for _, offer := range []OfferCode{
+		{Product: "ebook", Code: "LAUNCH", AmountOff: 200, MaxUses: 2, Campaign: "launch"},
+		{Product: "course", Code: "EARLY", AmountOff: 25, OfferType: "percent", ExpiresAt: &expiresAt, OnRetire: OfferDelete, Campaign: "launch"},
+		{Product: "kit", Code: "FRIENDS", AmountOff: 100},
+	} {
+		if _, err := offers.Create(offer); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+	api.take()
+
+	sale := func(id, product, permalink, code, price string) Sale {
+		t.Helper()
+		sale, err := ParseSale(map[string]interface{}{"sale_id": id, "product_id": product, "product_permalink": permalink, "offer_code": code, "price": price, "currency": "usd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sale
+	}
+	for _, s := range []Sale{
+		sale("s1", "ebook", "", "launch", "7"), // codes match without regard to case
+		sale("s2", "p-course", "course", "EARLY", "30"),
+		sale("s3", "ebook", "", "", "9"),
+		sale("s4", "ebook", "", "UNKNOWN", "9"),
+	} {
+		if err := offers.Redeem(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := api.take(); len(calls) != 0 {
+		t.Errorf("retired a code early: %v", calls)
+	}
+
+	if err := offers.Redeem(sale("s5", "ebook", "", "LAUNCH", "7")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.take(); !reflect.DeepEqual(calls, []string{"PUT /products/ebook/offer_codes/oc1 2"}) {
+		t.Errorf("after the last use calls %v, want LAUNCH capped at 2", calls)
+	}
+
+	clock.Advance(71 * time.Hour)
+	if err := offers.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.take(); len(calls) != 0 {
+		t.Errorf("swept before expiry: %v", calls)
+	}
+	api.set(func() { api.down = true })
+	clock.Advance(time.Hour)
+	if err := offers.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	api.take()
+	statuses := func() map[string]OfferCode {
+		codes := make(map[string]OfferCode)
+		for _, offer := range offers.List() {
+			codes[offer.Code] = offer
+		}
+		return codes
+	}
+	if early := statuses()["EARLY"]; early.Status != OfferExpired || early.RetireError == "" || early.RetiredAt != nil {
+		t.Errorf("EARLY %+v, want expired with the failure kept for the next sweep", early)
+	}
+
+	api.set(func() { api.down = false })
+	if err := offers.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.take(); !reflect.DeepEqual(calls, []string{"DELETE /products/course/offer_codes/oc2 "}) {
+		t.Errorf("retry calls %v, want EARLY deleted", calls)
+	}
+	if err := offers.Retire("kit", "friends"); err != nil {
+		t.Fatal(err)
+	}
+	if err := offers.Retire("kit", "NOPE"); err == nil {
+		t.Error("retired an untracked code")
+	}
+	if err := offers.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.take(); !reflect.DeepEqual(calls, []string{"PUT /products/kit/offer_codes/oc3 0"}) {
+		t.Errorf("calls %v, want FRIENDS capped once at 0", calls)
+	}
+
+	codes := statuses()
+	for code, want := range map[string]string{"LAUNCH": OfferExhausted, "EARLY": OfferExpired, "FRIENDS": OfferExpired} {
+		offer := codes[code]
+		if offer.Status != want || offer.RetireError != "" || offer.RetiredAt == nil {
+			t.Errorf("%s %+v, want %s and retired", code, offer, want)
+		}
+	}
+	if launch := codes["LAUNCH"]; launch.Uses != 2 || launch.Revenue["usd"] != 1400 {
+		t.Errorf("LAUNCH %d uses for %v, want 2 for 1400", launch.Uses, launch.Revenue)
+	}
+	if list := offers.List(); list[0].Code != "FRIENDS" || list[2].Code != "LAUNCH" {
+		t.Errorf("listed %s, %s, %s; want newest first", list[0].Code, list[1].Code, list[2].Code)
+	}
+
+	want := []CampaignStats{
+		{Campaign: "kit", Codes: 1, Revenue: MoneyTotals{}},
+		{Campaign: "launch", Codes: 2, Uses: 3, Revenue: MoneyTotals{"usd": 4400}},
+	}
+	if got := offers.Campaigns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("campaigns %+v, want %+v", got, want)
+	}
+
+	reopened, err := NewOfferCodes(NewGumroadClient(), path, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Campaigns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopening campaigns %+v, want %+v", got, want)
+	}
+}
+
+func TestTrackOfferCodes(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	offers, _ := newTestOfferCodes(t, filepath.Join(t.TempDir(), "offers.json"), clock)
+	if _, err := offers.Create(OfferCode{Product: "ebook", Code: "LAUNCH", AmountOff: 200}); err != nil {
+		t.Fatal(err)
+	}
+	TrackOfferCodes(gb, offers)
+
+	ids := NewSequentialIDs("gumroad")
+	for _, payload := range []map[string]interface{}{
+		{"resource_name": "sale", "sale_id": "s1", "product_id": "ebook", "offer_code": "LAUNCH", "price": "7", "currency": "usd"},
+		{"resource_name": "refund", "sale_id": "s1", "product_id": "ebook", "offer_code": "LAUNCH", "price": "7", "currency": "usd"},
+		{"resource_name": "sale", "product_id": "ebook", "offer_code": "LAUNCH", "price": "7"}, // no sale_id
+	} {
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := offers.Handler()
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics/offer-codes", nil))
+	var body struct {
+		Campaigns []CampaignStats `json:"campaigns"`
+		Codes     []OfferCode     `json:"codes"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Campaigns) != 1 || body.Campaigns[0].Uses != 1 || len(body.Codes) != 1 || body.Codes[0].Revenue["usd"] != 700 {
+		t.Errorf("metrics %s, want the one valid sale counted", response.Body.String())
+	}
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/metrics/offer-codes", nil))
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want 405", response.Code)
+	}
+}
+
+func TestOfferFromArgs(t *testing.T) {
+	now := time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC)
+	offer, err := offerFromArgs(map[string]interface{}{
+		"product": "ebook", "code": "LAUNCH", "campaign": "launch", "amount_off": 25, "offer_type": "percent",
+		"max_uses": 100.0, "on_retire": "delete", "expires_in": "72h",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.Product != "ebook" || offer.Code != "LAUNCH" || offer.Campaign != "launch" || offer.AmountOff != 25 || offer.OfferType != "percent" ||
+		offer.MaxUses != 100 || offer.OnRetire != OfferDelete || offer.ExpiresAt == nil || !offer.ExpiresAt.Equal(now.Add(72*time.Hour)) {
+		t.Errorf("offer %+v", offer)
+	}
+
+	offer, err = offerFromArgs(map[string]interface{}{"product": "ebook", "code": "LAUNCH", "amount_off": "200"}, now)
+	if err != nil || offer.MaxUses != 0 || offer.ExpiresAt != nil || offer.AmountOff != 200 {
+		t.Errorf("offer %+v (%v), want no limit or expiry", offer, err)
+	}
+	if _, err := offerFromArgs(map[string]interface{}{"expires_in": "soon"}, now); err == nil {
+		t.Error("accepted a bad expires_in")
+	}
+}
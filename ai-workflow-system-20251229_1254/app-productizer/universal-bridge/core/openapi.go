@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIParam is a path, query or form parameter of an operation
+type APIParam struct {
+	Name        string
+	In          string // "path", "query" or "form"
+	Type        string // "integer" or "boolean"; a string when empty
+	Required    bool
+	Description string
+}
+
+// APIOperation describes one endpoint the bridge serves. The OpenAPI spec
+// and the generated clients are built from these, with request and
+// response schemas reflected from the Go types the handlers encode.
+type APIOperation struct {
+	Server      string // "api", "portal", "webhook" or "peer"
+	Method      string
+	Path        string
+	ID          string // operationId, and the generated client method's name
+	Summary     string
+	Auth        string // "bearer", "webhook", "discord", or empty for public
+	Params      []APIParam
+	Body        interface{} // a value of the JSON request body's type
+	Status      int         // success status; 200 when zero
+	Response    interface{} // a value of the JSON response's type
+	ContentType string      // the response's type when it is not JSON
+	AnyFields   bool        // the form may carry fields beyond Params
+	TextErrors  bool        // errors are plain text rather than JSON
+	Browser     bool        // called by browsers or Discord, so left out of the generated clients
+}
+
+// apiServers are the listeners operations are served on, in spec order
+var apiServers = []struct{ Name, Description string }{
+	{"api", "Seller API, on -api"},
+	{"portal", "Customer portal and the public endpoints sold apps call, on -portal"},
+	{"webhook", "Gumroad ping receiver, on -webhook"},
+	{"peer", "Inbound messages from peer bridges, on -http"},
+}
+
+func queryParam(name, description string) APIParam {
+	return APIParam{Name: name, In: "query", Description: description}
+}
+
+func formParam(name string, required bool, description string) APIParam {
+	return APIParam{Name: name, In: "form", Required: required, Description: description}
+}
+
+func pathParam(name, description string) APIParam {
+	return APIParam{Name: name, In: "path", Required: true, Description: description}
+}
+
+// APIOperations lists every endpoint, in the order the spec and clients
+// list them
+func APIOperations() []APIOperation {
+	dayRangeParams := func(extra ...APIParam) []APIParam {
+		return append([]APIParam{queryParam("from", "first day, e.g. 2026-01-01; the first of this month by default"), queryParam("to", "last day; today by default")}, extra...)
+	}
+	licenseParams := func(in string) []APIParam {
+		return []APIParam{
+			{Name: "product_id", In: in, Required: true, Description: "product ID or custom permalink"},
+			{Name: "license_key", In: in, Required: true},
+		}
+	}
+	return []APIOperation{
+		{Server: "api", Method: "GET", Path: "/metrics", ID: "getMetrics", Summary: "Prometheus metrics for bridge throughput and latency", Auth: "bearer", ContentType: "text/plain"},
+		{Server: "api", Method: "GET", Path: "/metrics/sinks", ID: "listSinkMetrics", Summary: "Each sink's queue and delivery counts", Auth: "bearer",
+			Response: struct {
+				Sinks []SinkStats `json:"sinks"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/messages", ID: "listMessageMetrics", Summary: "Each message type's send, receive and handler counts", Auth: "bearer",
+			Response: struct {
+				Messages []MessageStats `json:"messages"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/pricing", ID: "listPricing", Summary: "Paid price distribution of every pay-what-you-want product", Auth: "bearer",
+			Response: struct {
+				Products []PriceStats `json:"products"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/pricing/{product}", ID: "getProductPricing", Summary: "Paid price distribution of one product, per currency", Auth: "bearer",
+			Params: []APIParam{pathParam("product", "product ID")},
+			Response: struct {
+				Product    string       `json:"product"`
+				Currencies []PriceStats `json:"currencies"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/offer-codes", ID: "listOfferCodes", Summary: "Tracked offer codes and their campaigns", Auth: "bearer",
+			Response: struct {
+				Campaigns []CampaignStats `json:"campaigns"`
+				Codes     []OfferCode     `json:"codes"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/waitlists", ID: "listWaitlists", Summary: "Signups and conversions of each waitlist", Auth: "bearer",
+			Response: struct {
+				Waitlists []WaitlistStats `json:"waitlists"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/checkouts", ID: "listCheckoutMetrics", Summary: "How each product's tracked views converted", Auth: "bearer",
+			Response: struct {
+				Products []CheckoutStats `json:"products"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/winback", ID: "listWinBackMetrics", Summary: "Enrollments and conversions of each win-back campaign", Auth: "bearer",
+			Response: struct {
+				Campaigns []WinBackStats `json:"campaigns"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/splits/statements", ID: "listSplitStatements", Summary: "Every collaborator's revenue split statement for a month", Auth: "bearer",
+			Params: []APIParam{queryParam("month", "e.g. 2026-01; the current month so far by default")},
+			Response: struct {
+				Month      string           `json:"month"`
+				Statements []SplitStatement `json:"statements"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/splits/statements/{collaborator}", ID: "getSplitStatement", Summary: "One collaborator's revenue split statement for a month, as JSON or CSV", Auth: "bearer",
+			Params:   []APIParam{pathParam("collaborator", "name in the splits config"), queryParam("month", "e.g. 2026-01; the current month so far by default"), queryParam("format", "csv for the statement's lines as CSV")},
+			Response: SplitStatement{}},
+		{Server: "api", Method: "GET", Path: "/metrics/usage", ID: "listUsageMetrics", Summary: "Activation and engagement of each licensed product", Auth: "bearer",
+			Response: struct {
+				Products []UsageStats `json:"products"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/trials", ID: "listTrialMetrics", Summary: "How each product's trials converted", Auth: "bearer",
+			Response: struct {
+				Products []TrialStats `json:"products"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/profit", ID: "getProfit", Summary: "Revenue, expenses and net profit over a range of days", Auth: "bearer",
+			Params: dayRangeParams(), Response: ProfitReport{}},
+		{Server: "api", Method: "GET", Path: "/metrics/snapshots", ID: "listAccountSnapshots", Summary: "Account snapshots taken over a range of days: products, sales counts, subscribers and the estimated balance", Auth: "bearer",
+			Params: dayRangeParams(), Response: struct {
+				Snapshots []AccountSnapshot `json:"snapshots"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/metrics/price-watch", ID: "listPriceWatch", Summary: "Each watched product page's last price, description and check", Auth: "bearer",
+			Response: struct {
+				Pages []PageState `json:"pages"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/sales/summary", ID: "getSalesSummary", Summary: "Sales, refunds, net revenue and average order over a range of days", Auth: "bearer",
+			Params: dayRangeParams(queryParam("product", "product ID or permalink; every product by default")), Response: SalesSummary{}},
+		{Server: "api", Method: "GET", Path: "/sales/by-product", ID: "listSalesByProduct", Summary: "Each product's sales, refunds and net revenue over a range of days", Auth: "bearer",
+			Params: dayRangeParams(), Response: struct {
+				From     string         `json:"from"`
+				To       string         `json:"to"`
+				Products []ProductSales `json:"products"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/sales/daily", ID: "listDailySales", Summary: "Sales, refunds and net revenue of every day in a range of up to a year", Auth: "bearer",
+			Params: dayRangeParams(queryParam("product", "product ID or permalink; every product by default")), Response: struct {
+				From string     `json:"from"`
+				To   string     `json:"to"`
+				Days []DaySales `json:"days"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/sales/recent", ID: "listRecentSales", Summary: "The latest sales, newest first", Auth: "bearer",
+			Params: []APIParam{queryParam("limit", "how many, up to 100; 20 by default"), queryParam("product", "product ID or permalink; every product by default")}, Response: struct {
+				Sales []Sale `json:"sales"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/expenses", ID: "listExpenses", Summary: "Logged expenses, with the AI spend recorded per day", Auth: "bearer",
+			Response: struct {
+				Expenses []Expense `json:"expenses"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/expenses", ID: "addExpense", Summary: "Log a one-off, monthly or yearly expense", Auth: "bearer",
+			Body: Expense{}, Status: http.StatusCreated, Response: Expense{}},
+		{Server: "api", Method: "DELETE", Path: "/expenses/{id}", ID: "deleteExpense", Summary: "Remove an expense", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "expense ID")}, Status: http.StatusNoContent},
+		{Server: "api", Method: "GET", Path: "/launch", ID: "getLaunch", Summary: "Whether launch mode is on and the running launch's report so far", Auth: "bearer",
+			Response: LaunchStatus{}},
+		{Server: "api", Method: "POST", Path: "/launch/start", ID: "startLaunch", Summary: "Turn on launch mode", Auth: "bearer",
+			Body: struct {
+				Name string `json:"name"`
+			}{}, Status: http.StatusCreated, Response: LaunchReport{}},
+		{Server: "api", Method: "POST", Path: "/launch/stop", ID: "stopLaunch", Summary: "Turn off launch mode and write the post-launch report", Auth: "bearer",
+			Response: LaunchReport{}},
+		{Server: "api", Method: "GET", Path: "/launch/reports", ID: "listLaunchReports", Summary: "Reports of finished launches", Auth: "bearer",
+			Response: struct {
+				Reports []LaunchReport `json:"reports"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/customers", ID: "listCustomers", Summary: "A summary of every customer", Auth: "bearer",
+			Response: struct {
+				Customers []map[string]interface{} `json:"customers"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/customers/{email}", ID: "getCustomer", Summary: "A customer's full profile", Auth: "bearer",
+			Params: []APIParam{pathParam("email", "")}, Response: CustomerProfile{}},
+		{Server: "api", Method: "GET", Path: "/segments", ID: "listSegments", Summary: "Every customer segment and its size", Auth: "bearer",
+			Response: struct {
+				Segments []SegmentSummary `json:"segments"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/segments/{name}", ID: "getSegment", Summary: "The customers in a segment", Auth: "bearer",
+			Params: []APIParam{pathParam("name", "segment name")}, Response: struct {
+				Segment string          `json:"segment"`
+				Members []SegmentMember `json:"members"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/seasonal-sales", ID: "listSeasonalSales", Summary: "Scheduled, running and finished seasonal sales", Auth: "bearer",
+			Response: struct {
+				Sales []SeasonalSale `json:"sales"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/seasonal-sales", ID: "scheduleSeasonalSale", Summary: "Schedule a seasonal sale", Auth: "bearer",
+			Body: SeasonalSale{}, Status: http.StatusCreated, Response: SeasonalSale{}},
+		{Server: "api", Method: "GET", Path: "/stream/subscribers", ID: "listStreamSubscribers", Summary: "The sale event stream's length and each subscriber's cursor", Auth: "bearer",
+			Response: struct {
+				Events      int               `json:"events"`
+				Subscribers []SubscriberStats `json:"subscribers"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/calendar.ics", ID: "getCalendar", Summary: "Launches, seasonal sales and payout dates as an iCalendar feed", Auth: "bearer", ContentType: "text/calendar"},
+		{Server: "api", Method: "GET", Path: "/dead-letters", ID: "listDeadLetters", Summary: "Messages whose handler failed", Auth: "bearer",
+			Params: []APIParam{queryParam("status", "retrying or dead; empty for both")},
+			Response: struct {
+				DeadLetters []DeadLetter `json:"dead_letters"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/dead-letters/{id}/requeue", ID: "requeueDeadLetter", Summary: "Retry a dead letter now", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "message ID")}, Response: DeadLetter{}},
+		{Server: "api", Method: "GET", Path: "/licenses/verify", ID: "verifyLicense", Summary: "Check a license key", Auth: "bearer",
+			Params: licenseParams("query"), Response: LicenseCheck{}},
+		{Server: "api", Method: "POST", Path: "/licenses/verify", ID: "activateLicense", Summary: "Check a license key, counting an activation with increment_uses_count", Auth: "bearer",
+			Params: append(licenseParams("form"), APIParam{Name: "increment_uses_count", In: "form", Type: "boolean", Description: "count an activation"}), Response: LicenseCheck{}},
+		{Server: "api", Method: "GET", Path: "/jobs", ID: "listJobs", Summary: "Scheduled jobs", Auth: "bearer",
+			Response: struct {
+				Jobs []Job `json:"jobs"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/jobs/{id}/pause", ID: "pauseJob", Summary: "Stop a job running on schedule", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "job ID")}, Response: Job{}},
+		{Server: "api", Method: "POST", Path: "/jobs/{id}/resume", ID: "resumeJob", Summary: "Put a paused job back on schedule", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "job ID")}, Response: Job{}},
+		{Server: "api", Method: "POST", Path: "/jobs/{id}/trigger", ID: "triggerJob", Summary: "Run a job now", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "job ID")}, Response: Job{}},
+		{Server: "api", Method: "GET", Path: "/automations", ID: "listAutomations", Summary: "Drafted and approved automations", Auth: "bearer",
+			Response: struct {
+				Automations []Automation `json:"automations"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/automations", ID: "draftAutomation", Summary: "Have the AI provider draft the rules of an automation described in plain English", Auth: "bearer",
+			Body: struct {
+				Description string `json:"description"`
+			}{}, Status: http.StatusCreated, Response: Automation{}},
+		{Server: "api", Method: "PUT", Path: "/automations/{id}", ID: "editAutomation", Summary: "Replace a draft's rules", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "automation ID")}, Body: struct {
+				Rules string `json:"rules"`
+			}{}, Response: Automation{}},
+		{Server: "api", Method: "POST", Path: "/automations/{id}/approve", ID: "approveAutomation", Summary: "Put a draft's rules in force", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "automation ID")}, Response: Automation{}},
+		{Server: "api", Method: "DELETE", Path: "/automations/{id}", ID: "deleteAutomation", Summary: "Remove an automation, taking its rules out of force", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "automation ID")}, Status: http.StatusNoContent},
+		{Server: "api", Method: "GET", Path: "/fraud/held", ID: "listHeldPurchases", Summary: "Suspicious sales held back from fulfilment for review", Auth: "bearer",
+			Response: struct {
+				Held []HeldPurchase `json:"held"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/fraud/held/{id}/release", ID: "releaseHeldPurchase", Summary: "Pass a held sale on to be recorded and fulfilled", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "held sale's message ID")}, Response: HeldPurchase{}},
+		{Server: "api", Method: "POST", Path: "/fraud/held/{id}/reject", ID: "rejectHeldPurchase", Summary: "Drop a held sale without fulfilling it", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "held sale's message ID")}, Response: HeldPurchase{}},
+		{Server: "api", Method: "GET", Path: "/approvals", ID: "listApprovals", Summary: "Actions waiting for a person to approve them, oldest first", Auth: "bearer",
+			Response: struct {
+				Approvals []Approval `json:"approvals"`
+			}{}},
+		{Server: "api", Method: "POST", Path: "/approvals/{id}/approve", ID: "approveApproval", Summary: "Run a waiting action; one that fails stays waiting", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "approval ID")}, Response: Approval{}},
+		{Server: "api", Method: "POST", Path: "/approvals/{id}/reject", ID: "rejectApproval", Summary: "Drop a waiting action without running it", Auth: "bearer",
+			Params: []APIParam{pathParam("id", "approval ID")}, Response: Approval{}},
+		{Server: "api", Method: "GET", Path: "/messages", ID: "queryMessages", Summary: "Sent and received messages from the message store", Auth: "bearer",
+			Params: []APIParam{
+				queryParam("type", "message type"),
+				queryParam("direction", "sent or received"),
+				queryParam("status", ""),
+				queryParam("correlation_id", "messages of one request and its replies"),
+				queryParam("since", "RFC 3339 time, inclusive"),
+				queryParam("until", "RFC 3339 time, exclusive"),
+				{Name: "limit", In: "query", Type: "integer", Description: "most messages returned"},
+			},
+			Response: struct {
+				Messages []StoredMessage `json:"messages"`
+			}{}},
+		{Server: "api", Method: "GET", Path: "/graphql", ID: "getGraphqlSchema", Summary: "The GraphQL schema, in SDL; with a query parameter it runs the query instead", Auth: "bearer", ContentType: "text/plain"},
+		{Server: "api", Method: "POST", Path: "/graphql", ID: "runGraphqlQuery", Summary: "Run a GraphQL query over sales, customers, products and messages", Auth: "bearer",
+			Body: GraphQLRequest{}, Response: GraphQLResult{}},
+		{Server: "api", Method: "GET", Path: "/live", ID: "openLiveFeed", Summary: "Upgrade to a WebSocket that streams sale, refund and metrics events, each a JSON text message", Auth: "bearer", Browser: true,
+			Params: []APIParam{queryParam("events", "comma-separated sale, refund and metrics; all three when empty"), queryParam("product", "only sales and refunds of this product ID or permalink")},
+			Status: http.StatusSwitchingProtocols, Response: LiveEvent{}},
+		{Server: "api", Method: "GET", Path: "/entitlements/{email}", ID: "getEntitlements", Summary: "The features a member's tier grants", Auth: "bearer",
+			Params: []APIParam{pathParam("email", "")}, Response: EntitlementGrant{}},
+		{Server: "api", Method: "GET", Path: "/dashboard/", ID: "getDashboard", Summary: "The seller dashboard, which asks for the API token itself", ContentType: "text/html", Browser: true},
+		{Server: "api", Method: "GET", Path: "/openapi.json", ID: "getOpenAPISpec", Summary: "This specification", Auth: "bearer", Response: map[string]interface{}{}},
+
+		{Server: "portal", Method: "GET", Path: "/", ID: "portalHome", Summary: "The logged-in buyer's purchases, or the login form", ContentType: "text/html", Browser: true},
+		{Server: "portal", Method: "POST", Path: "/login", ID: "portalLogin", Summary: "Email a magic login link", ContentType: "text/html", Browser: true,
+			Params: []APIParam{formParam("email", true, "")}, Status: http.StatusSeeOther},
+		{Server: "portal", Method: "GET", Path: "/auth", ID: "portalAuth", Summary: "Log in with a magic link", ContentType: "text/html", Browser: true,
+			Params: []APIParam{{Name: "token", In: "query", Required: true}}, Status: http.StatusSeeOther},
+		{Server: "portal", Method: "POST", Path: "/logout", ID: "portalLogout", Summary: "End the portal session", ContentType: "text/html", Browser: true, Status: http.StatusSeeOther},
+		{Server: "portal", Method: "GET", Path: "/thanks", ID: "portalThanks", Summary: "The post-purchase thank-you page", ContentType: "text/html", Browser: true,
+			Params: []APIParam{{Name: "sale_id", In: "query", Required: true}}},
+		{Server: "portal", Method: "POST", Path: "/activations/reset", ID: "portalResetActivations", Summary: "Reset a license key's activations", ContentType: "text/html", Browser: true,
+			Params: []APIParam{formParam("sale_id", true, ""), formParam("csrf", true, "")}, Status: http.StatusSeeOther},
+		{Server: "portal", Method: "GET", Path: "/unsubscribe", ID: "portalUnsubscribe", Summary: "Opt out of broadcast emails", ContentType: "text/html", Browser: true,
+			Params: []APIParam{{Name: "email", In: "query", Required: true}, {Name: "token", In: "query", Required: true}}},
+		{Server: "portal", Method: "POST", Path: "/waitlist", ID: "joinWaitlist", Summary: "Join a product's waitlist", ContentType: "text/html", Browser: true,
+			Params: []APIParam{formParam("product", true, ""), formParam("email", true, "")}},
+		{Server: "portal", Method: "GET", Path: "/track", ID: "trackCheckoutPixel", Summary: "Record a product view, answering with a transparent pixel for an <img>", ContentType: "image/gif", TextErrors: true, Browser: true,
+			Params: []APIParam{{Name: "email", In: "query", Required: true}, {Name: "product", In: "query", Required: true}, queryParam("source", "")}},
+		{Server: "portal", Method: "POST", Path: "/track", ID: "trackCheckoutView", Summary: "Record a product view for abandoned checkout follow-ups", TextErrors: true,
+			Params: []APIParam{formParam("email", true, ""), formParam("product", true, "product ID or custom permalink"), formParam("source", false, "")}, Status: http.StatusNoContent},
+		{Server: "portal", Method: "GET", Path: "/licenses/verify", ID: "checkLicense", Summary: "Check a license key from a sold app",
+			Params: licenseParams("query"), Response: LicenseCheck{}},
+		{Server: "portal", Method: "POST", Path: "/licenses/verify", ID: "activateAppLicense", Summary: "Check a license key from a sold app, counting an activation with increment_uses_count",
+			Params: append(licenseParams("form"), APIParam{Name: "increment_uses_count", In: "form", Type: "boolean", Description: "count an activation"}), Response: LicenseCheck{}},
+		{Server: "portal", Method: "POST", Path: "/usage", ID: "reportUsage", Summary: "Report an anonymous usage ping; without a license_key it starts the install's trial",
+			Params: []APIParam{
+				formParam("product_id", true, "product ID or custom permalink"),
+				formParam("license_key", false, ""),
+				formParam("install_id", true, "a random ID the app makes once per machine"),
+				formParam("event", false, "defaults to launch"),
+				formParam("version", false, ""),
+			},
+			Status: http.StatusNoContent},
+		{Server: "portal", Method: "POST", Path: "/discord/interactions", ID: "discordInteraction", Summary: "Discord interactions for the /verify command", Auth: "discord",
+			Body: map[string]interface{}{}, Response: map[string]interface{}{}, Browser: true},
+
+		{Server: "webhook", Method: "POST", Path: "/webhook/gumroad", ID: "gumroadPing", Summary: "A Gumroad ping, as the form Gumroad posts", Auth: "webhook", AnyFields: true,
+			Params: []APIParam{formParam("resource_name", false, "sale when absent")},
+			Response: struct {
+				ID string `json:"id"`
+			}{}},
+
+		{Server: "peer", Method: "POST", Path: "/messages", ID: "postMessage", Summary: "Deliver a message from a peer bridge; answers once it is handled", Auth: "bearer",
+			Body: UniversalMessage{}, Status: http.StatusAccepted},
+	}
+}
+
+// apiSchema is an OpenAPI 3.0 schema object
+type apiSchema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	Items                *apiSchema            `json:"items,omitempty"`
+	Properties           map[string]*apiSchema `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties *apiSchema            `json:"additionalProperties,omitempty"`
+
+	order []string // property names in struct field order, for the clients
+}
+
+// refName returns the component a $ref points to
+func (s *apiSchema) refName() string {
+	return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor reflects a Go type's JSON encoding, adding named structs to
+// components and referring to them
+func schemaFor(t reflect.Type, components map[string]*apiSchema) *apiSchema {
+	switch {
+	case t == timeType:
+		return &apiSchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := *schemaFor(t.Elem(), components)
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return &schema
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := components[t.Name()]; !ok {
+			components[t.Name()] = nil // a placeholder, in case the type refers to itself
+			components[t.Name()] = structSchema(t, components)
+		}
+		return &apiSchema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t, components)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &apiSchema{Type: "string", Format: "byte"}
+		}
+		return &apiSchema{Type: "array", Items: schemaFor(t.Elem(), components)}
+	case reflect.Map:
+		return &apiSchema{Type: "object", AdditionalProperties: schemaFor(t.Elem(), components)}
+	case reflect.String:
+		return &apiSchema{Type: "string"}
+	case reflect.Bool:
+		return &apiSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &apiSchema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &apiSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &apiSchema{Type: "number"}
+	}
+	return &apiSchema{}
+}
+
+func structSchema(t reflect.Type, components map[string]*apiSchema) *apiSchema {
+	schema := &apiSchema{Type: "object", Properties: make(map[string]*apiSchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// encoding/json promotes an untagged embedded struct's fields
+		embedded := field.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			promoted := structSchema(embedded, components)
+			for _, name := range promoted.order {
+				schema.Properties[name] = promoted.Properties[name]
+				schema.order = append(schema.order, name)
+			}
+			schema.Required = append(schema.Required, promoted.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaFor(field.Type, components)
+		schema.order = append(schema.order, name)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// apiDocument is the operations with their schemas reflected. Named
+// structs land in components; anonymous response envelopes are named after
+// their operation, as listSinkMetrics answers ListSinkMetricsResponse.
+type apiDocument struct {
+	operations []APIOperation
+	bodies     map[string]*apiSchema // by operation ID
+	responses  map[string]*apiSchema
+	components map[string]*apiSchema
+}
+
+func buildAPIDocument() apiDocument {
+	doc := apiDocument{
+		operations: APIOperations(),
+		bodies:     make(map[string]*apiSchema),
+		responses:  make(map[string]*apiSchema),
+		components: make(map[string]*apiSchema),
+	}
+	schemaFor(reflect.TypeOf(ErrorBody{}), doc.components)
+	for _, op := range doc.operations {
+		if op.Body != nil {
+			doc.bodies[op.ID] = schemaFor(reflect.TypeOf(op.Body), doc.components)
+		}
+		if op.Response != nil {
+			t := reflect.TypeOf(op.Response)
+			if t.Kind() == reflect.Struct && t.Name() == "" {
+				name := exportedName(op.ID) + "Response"
+				doc.components[name] = structSchema(t, doc.components)
+				doc.responses[op.ID] = &apiSchema{Ref: "#/components/schemas/" + name}
+				continue
+			}
+			doc.responses[op.ID] = schemaFor(t, doc.components)
+		}
+	}
+	return doc
+}
+
+// exportedName capitalizes an operation ID
+func exportedName(id string) string {
+	return strings.ToUpper(id[:1]) + id[1:]
+}
+
+// paramSchema is the schema of a parameter's value
+func paramSchema(param APIParam) *apiSchema {
+	if param.Type == "" {
+		return &apiSchema{Type: "string"}
+	}
+	return &apiSchema{Type: param.Type}
+}
+
+// OpenAPISpec builds the OpenAPI 3.0 document for every operation
+func OpenAPISpec() map[string]interface{} {
+	doc := buildAPIDocument()
+	errorContent := map[string]interface{}{JSONContentType: map[string]interface{}{"schema": &apiSchema{Ref: "#/components/schemas/ErrorBody"}}}
+	paths := make(map[string]map[string]interface{})
+	for _, op := range doc.operations {
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{op.Server},
+		}
+		switch op.Auth {
+		case "bearer":
+			scheme := "bridgeToken"
+			if op.Server == "peer" {
+				scheme = "peerToken"
+			}
+			operation["security"] = []interface{}{map[string]interface{}{scheme: []string{}}}
+		case "webhook":
+			operation["security"] = []interface{}{map[string]interface{}{"webhookSignature": []string{}}, map[string]interface{}{"webhookSecret": []string{}}}
+		case "discord":
+			operation["security"] = []interface{}{map[string]interface{}{"discordSignature": []string{}}}
+		}
+
+		var parameters []interface{}
+		formFields := &apiSchema{Type: "object", Properties: make(map[string]*apiSchema)}
+		if op.AnyFields {
+			formFields.AdditionalProperties = &apiSchema{Type: "string"}
+		}
+		for _, param := range op.Params {
+			if param.In == "form" {
+				formFields.Properties[param.Name] = paramSchema(param)
+				if param.Required {
+					formFields.Required = append(formFields.Required, param.Name)
+				}
+				continue
+			}
+			parameter := map[string]interface{}{
+				"name":     param.Name,
+				"in":       param.In,
+				"required": param.Required,
+				"schema":   paramSchema(param),
+			}
+			if param.Description != "" {
+				parameter["description"] = param.Description
+			}
+			parameters = append(parameters, parameter)
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		switch {
+		case doc.bodies[op.ID] != nil:
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{JSONContentType: map[string]interface{}{"schema": doc.bodies[op.ID]}},
+			}
+		case len(formFields.Properties) > 0:
+			operation["requestBody"] = map[string]interface{}{
+				"required": len(formFields.Required) > 0,
+				"content":  map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{"schema": formFields}},
+			}
+		}
+
+		response := map[string]interface{}{"description": http.StatusText(op.status())}
+		switch {
+		case doc.responses[op.ID] != nil:
+			response["content"] = map[string]interface{}{JSONContentType: map[string]interface{}{"schema": doc.responses[op.ID]}}
+		case op.ContentType != "" && op.status() == http.StatusOK:
+			response["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{"schema": &apiSchema{Type: "string"}}}
+		}
+		responses := map[string]interface{}{strconv.Itoa(op.status()): response}
+		if !op.Browser && !op.TextErrors {
+			responses["default"] = map[string]interface{}{"description": "An error", "content": errorContent}
+		}
+		operation["responses"] = responses
+
+		key := "/" + op.Server + op.Path
+		if paths[key] == nil {
+			paths[key] = make(map[string]interface{})
+		}
+		paths[key][strings.ToLower(op.Method)] = operation
+	}
+
+	var tags []interface{}
+	for _, server := range apiServers {
+		tags = append(tags, map[string]interface{}{"name": server.Name, "description": server.Description})
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Universal Bridge",
+			"version":     "1",
+			"description": "Every HTTP endpoint the Go bridge serves. Each tag is a separate listener, and paths are prefixed with it: /api/metrics is GET /metrics on the -api listener.",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": doc.components,
+			"securitySchemes": map[string]interface{}{
+				"bridgeToken":      map[string]interface{}{"type": "http", "scheme": "bearer", "description": apiTokenEnv + ", also accepted as a token query parameter; " + liveTokenEnv + " opens only /live"},
+				"peerToken":        map[string]interface{}{"type": "http", "scheme": "bearer", "description": httpTokenEnv},
+				"webhookSignature": map[string]interface{}{"type": "apiKey", "in": "header", "name": gumroadSignatureHeader, "description": "hex HMAC-SHA256 of the body with the ping secret"},
+				"webhookSecret":    map[string]interface{}{"type": "apiKey", "in": "query", "name": "secret"},
+				"discordSignature": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Signature-Ed25519"},
+			},
+		},
+	}
+}
+
+// ErrorBody is the body of every JSON error response
+type ErrorBody struct {
+	Error string `json:"error"`
+}
+
+func (op APIOperation) status() int {
+	if op.Status == 0 {
+		return http.StatusOK
+	}
+	return op.Status
+}
+
+// OpenAPIHandler serves GET /openapi.json
+func OpenAPIHandler() http.Handler {
+	spec, err := json.MarshalIndent(OpenAPISpec(), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", JSONContentType)
+		w.Write(spec)
+	})
+}
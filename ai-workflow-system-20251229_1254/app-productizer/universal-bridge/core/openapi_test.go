@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// routeServers maps the files that register HTTP routes to the listener
+// they register them on
+var routeServers = map[string]string{
+	"api.go":            "api",
+	"bridge.go":         "api",
+	"portal.go":         "portal",
+	"webhook.go":        "webhook",
+	"http_transport.go": "peer",
+}
+
+var routePattern = regexp.MustCompile(`Handle(?:Func)?\("(/[^"]*)"`)
+
+// TestOpenAPICoversEveryRoute keeps APIOperations in step with the routes
+// the handlers register: each route needs an operation, and each operation
+// a route
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	registered := make(map[string][]string)
+	for file, server := range routeServers {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range routePattern.FindAllStringSubmatch(string(content), -1) {
+			registered[server] = append(registered[server], match[1])
+		}
+	}
+
+	// A pattern ending in / also serves the paths below it
+	serves := func(pattern, path string) bool {
+		if strings.HasSuffix(pattern, "/") && pattern != "/" {
+			return strings.HasPrefix(path, pattern)
+		}
+		return pattern == path
+	}
+	ops := APIOperations()
+	for server, patterns := range registered {
+		for _, pattern := range patterns {
+			covered := false
+			for _, op := range ops {
+				if op.Server == server && serves(pattern, op.Path) {
+					covered = true
+				}
+			}
+			if !covered {
+				t.Errorf("%s route %s has no APIOperation", server, pattern)
+			}
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, op := range ops {
+		if ids[op.ID] {
+			t.Errorf("operation ID %s is used twice", op.ID)
+		}
+		ids[op.ID] = true
+		routed := false
+		for _, pattern := range registered[op.Server] {
+			if serves(pattern, op.Path) {
+				routed = true
+			}
+		}
+		if !routed {
+			t.Errorf("%s %s on %s is not a registered route", op.Method, op.Path, op.Server)
+		}
+	}
+}
+
+// TestGeneratedClientsAreCurrent fails when the published spec or clients
+// differ from what the operations generate. Regenerate them with:
+//
+//	go run . -openapi ../clients
+func TestGeneratedClientsAreCurrent(t *testing.T) {
+	files, err := APIClientFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join("..", "clients", name))
+		if err != nil {
+			t.Fatalf("%v (run go run . -openapi ../clients)", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("../clients/%s is stale; run go run . -openapi ../clients", name)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	api := NewAPI(gb, nil)
+	api.Token = "secret"
+
+	request := httptest.NewRequest(http.MethodGet, "/openapi.json?token=secret", nil)
+	recorder := httptest.NewRecorder()
+	api.Handler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := spec.Paths["/api/licenses/verify"]["post"]; !ok {
+		t.Errorf("no POST /licenses/verify among %d paths", len(spec.Paths))
+	}
+	if _, ok := spec.Paths["/webhook/webhook/gumroad"]["post"]; !ok {
+		t.Errorf("no Gumroad ping among %d paths", len(spec.Paths))
+	}
+
+	// Every $ref resolves to a component
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(recorder.Body.String(), -1) {
+		if _, ok := spec.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("dangling $ref %s", ref[1])
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PendingRequest is a sent request still waiting for its reply
+type PendingRequest struct {
+	ID       string      `json:"id"`
+	Type     MessageType `json:"message_type"`
+	Target   string      `json:"target_language"`
+	SentAt   time.Time   `json:"sent_at"`
+	Deadline time.Time   `json:"deadline"`
+
+	channel CommunicationChannel
+	done    chan *UniversalMessage
+}
+
+// PendingRequests tracks the AI requests and function calls the bridge has
+// sent until a reply naming them in original_message_id arrives. Requests
+// still unanswered at their deadline are failed by the sweeper with a
+// timeout Error message, dispatched as if the peer had sent it, so handlers
+// and waiters hear back even when the peer has died.
+type PendingRequests struct {
+	// Timeout is the default time a request is given to be answered
+	Timeout time.Duration
+	// Types are the message types tracked when sent
+	Types []MessageType
+
+	bridge *GoBridge
+
+	mu        sync.Mutex
+	pending   map[string]*PendingRequest
+	onTimeout []func(PendingRequest)
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewPendingRequests starts tracking the AI requests and function calls the
+// bridge sends, with the given default timeout. A bridge has one tracker.
+func NewPendingRequests(gb *GoBridge, timeout time.Duration) *PendingRequests {
+	pr := &PendingRequests{
+		Timeout: timeout,
+		Types:   []MessageType{AIRequest, FunctionCall},
+		bridge:  gb,
+		pending: make(map[string]*PendingRequest),
+		stop:    make(chan struct{}),
+	}
+	gb.mu.Lock()
+	gb.pending = pr
+	gb.mu.Unlock()
+	gb.OnReceive(pr.handleReceived)
+	return pr
+}
+
+// sending is called by SendMessage before a message goes out
+func (pr *PendingRequests) sending(message *UniversalMessage) {
+	for _, messageType := range pr.Types {
+		if message.MessageType == messageType {
+			pr.Expect(message, pr.bridge.clock.Now().Add(pr.Timeout))
+			return
+		}
+	}
+}
+
+// forget stops tracking a request whose send failed, as SendMessage's error
+// already told the caller, or whose stream finished
+func (pr *PendingRequests) forget(id string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.pending, id)
+}
+
+func (pr *PendingRequests) handleReceived(message *UniversalMessage) {
+	id := stringArg(message.Payload, "original_message_id")
+	if id == "" || message.MessageType == Ack || message.MessageType == Nack {
+		// A receipt says the request arrived, not what it was answered
+		return
+	}
+
+	if message.MessageType == StreamChunk {
+		// A streamed reply: each chunk gives the request another Timeout.
+		// Even the done chunk leaves it pending, since chunks before it may
+		// still be missing; the AIStream forgets it once reassembled.
+		pr.mu.Lock()
+		if request := pr.pending[id]; request != nil {
+			request.Deadline = pr.bridge.clock.Now().Add(pr.Timeout)
+		}
+		pr.mu.Unlock()
+		return
+	}
+
+	pr.mu.Lock()
+	request := pr.pending[id]
+	delete(pr.pending, id)
+	pr.mu.Unlock()
+
+	if request != nil {
+		request.done <- message
+		close(request.done)
+	}
+}
+
+// Expect tracks a sent message until a reply arrives or the deadline passes.
+// Messages of the tracked Types are expected automatically; use this for
+// other types or a deadline other than Timeout.
+func (pr *PendingRequests) Expect(message *UniversalMessage, deadline time.Time) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if existing := pr.pending[message.ID]; existing != nil {
+		existing.Deadline = deadline
+		return
+	}
+	pr.pending[message.ID] = &PendingRequest{
+		ID:       message.ID,
+		Type:     message.MessageType,
+		Target:   message.TargetLanguage,
+		SentAt:   pr.bridge.clock.Now(),
+		Deadline: deadline,
+		channel:  message.ResponseChannel,
+		done:     make(chan *UniversalMessage, 1),
+	}
+}
+
+// Await returns a channel that receives the request's reply, or its timeout
+// Error message, then closes. ok is false for an ID that is not pending.
+func (pr *PendingRequests) Await(id string) (reply <-chan *UniversalMessage, ok bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	request := pr.pending[id]
+	if request == nil {
+		return nil, false
+	}
+	return request.done, true
+}
+
+// Pending returns the unanswered requests, soonest deadline first
+func (pr *PendingRequests) Pending() []PendingRequest {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	list := make([]PendingRequest, 0, len(pr.pending))
+	for _, request := range pr.pending {
+		list = append(list, *request)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Deadline.Before(list[j].Deadline) })
+	return list
+}
+
+// OnTimeout registers a hook called for every request that times out,
+// before its timeout Error message is dispatched
+func (pr *PendingRequests) OnTimeout(hook func(PendingRequest)) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.onTimeout = append(pr.onTimeout, hook)
+}
+
+// Sweep fails every request past its deadline and returns how many it failed
+func (pr *PendingRequests) Sweep() int {
+	now := pr.bridge.clock.Now()
+
+	pr.mu.Lock()
+	var expired []*PendingRequest
+	for id, request := range pr.pending {
+		if !now.Before(request.Deadline) {
+			expired = append(expired, request)
+			delete(pr.pending, id)
+		}
+	}
+	hooks := pr.onTimeout
+	pr.mu.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Deadline.Before(expired[j].Deadline) })
+	for _, request := range expired {
+		fmt.Printf("⏰ Request %s to %s timed out after %s\n", request.ID, request.Target, request.Deadline.Sub(request.SentAt))
+		for _, hook := range hooks {
+			hook(*request)
+		}
+
+		timeout := newUniversalMessage(pr.bridge.clock, pr.bridge.ids, Error, request.Target, "go", map[string]interface{}{
+			"original_message_id": request.ID,
+			"error":               fmt.Sprintf("no reply from %s within %s", request.Target, request.Deadline.Sub(request.SentAt)),
+			"success":             false,
+			"timeout":             true,
+		}, request.channel)
+		request.done <- timeout
+		close(request.done)
+		err := pr.bridge.handleIncomingMessage(timeout)
+		if err != nil {
+			log.Printf("❌ Error handling timeout of %s: %v", request.ID, err)
+		}
+	}
+	return len(expired)
+}
+
+// Start sweeps every interval until Close
+func (pr *PendingRequests) Start(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-pr.stop:
+				return
+			case <-pr.bridge.clock.After(interval):
+				pr.Sweep()
+			}
+		}
+	}()
+}
+
+// Close stops the sweeper; requests still pending are left unanswered
+func (pr *PendingRequests) Close() {
+	pr.stopOnce.Do(func() { close(pr.stop) })
+}
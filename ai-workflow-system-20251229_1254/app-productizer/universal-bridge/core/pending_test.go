@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingRequestTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		deadline    time.Duration // 0 for the tracker's Timeout
+		advance     time.Duration
+		reply       bool
+		wantTimeout bool
+	}{
+		{"answered", 0, time.Minute, true, false},
+		{"before the deadline", 0, 9 * time.Second, false, false},
+		{"at the deadline", 0, 10 * time.Second, false, true},
+		{"past the deadline", 0, time.Minute, false, true},
+		{"own deadline", 2 * time.Second, 3 * time.Second, false, true},
+		{"own longer deadline", time.Minute, 30 * time.Second, false, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+			memory := NewMemoryTransport()
+			gb := NewGoBridge("", WithClock(clock), WithTransport(memory), WithIDGenerator(NewSequentialIDs("req")))
+			t.Cleanup(func() { gb.Close() })
+			pending := NewPendingRequests(gb, 10*time.Second)
+
+			var timedOut []PendingRequest
+			pending.OnTimeout(func(request PendingRequest) { timedOut = append(timedOut, request) })
+			var failures []*UniversalMessage
+			gb.OnMessage(Error, func(message *UniversalMessage) error {
+				failures = append(failures, message)
+				return nil
+			})
+
+			request := gb.NewMessage(AIRequest, "python", map[string]interface{}{"prompt": "hi"}, FileSystem)
+			if _, err := gb.SendMessage(request); err != nil {
+				t.Fatal(err)
+			}
+			if tt.deadline != 0 {
+				pending.Expect(request, clock.Now().Add(tt.deadline))
+			}
+			reply, ok := pending.Await(request.ID)
+			if !ok {
+				t.Fatal("sent AI request is not pending")
+			}
+			if tt.reply {
+				answer := gb.NewMessage(AIResponse, "go", map[string]interface{}{"original_message_id": request.ID}, FileSystem)
+				if err := gb.handleIncomingMessage(answer); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clock.Advance(tt.advance)
+			swept := pending.Sweep()
+			if tt.wantTimeout != (swept == 1) || len(timedOut) != swept || len(failures) != swept {
+				t.Fatalf("Sweep failed %d requests, %d hooks and %d Error messages; want timeout %t", swept, len(timedOut), len(failures), tt.wantTimeout)
+			}
+
+			switch {
+			case tt.wantTimeout:
+				message := <-reply
+				if message.MessageType != Error || !flagArg(message.Payload, "timeout") || stringArg(message.Payload, "original_message_id") != request.ID {
+					t.Fatalf("Await got %+v, want a timeout Error", message)
+				}
+			case tt.reply:
+				if message := <-reply; message.MessageType != AIResponse {
+					t.Fatalf("Await got %s, want the reply", message.MessageType)
+				}
+			default:
+				if len(pending.Pending()) != 1 {
+					t.Fatal("request no longer pending before its deadline")
+				}
+			}
+		})
+	}
+}
+
+func TestPendingRequestsFailedSend(t *testing.T) {
+	memory := NewMemoryTransport()
+	gb := NewGoBridge("", WithTransport(memory))
+	t.Cleanup(func() { gb.Close() })
+	pending := NewPendingRequests(gb, 10*time.Second)
+
+	memory.Close()
+	if _, err := gb.SendMessage(gb.NewMessage(FunctionCall, "python", nil, FileSystem)); err == nil {
+		t.Fatal("send over a closed transport succeeded")
+	}
+	if n := len(pending.Pending()); n != 0 {
+		t.Fatalf("%d requests pending after their send failed", n)
+	}
+}
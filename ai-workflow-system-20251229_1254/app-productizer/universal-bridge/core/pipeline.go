@@ -0,0 +1,724 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDuplicateInFlight is the nack reason for a redelivered message that is still being processed
+var ErrDuplicateInFlight = errors.New("duplicate of a message still in flight")
+
+// ErrPipelineClosed is the nack reason for envelopes submitted after Close
+var ErrPipelineClosed = errors.New("pipeline closed")
+
+// ErrPipelineBusy is the nack reason for a bulk message decoded while the
+// bulk lane is full and no decode worker may wait for it; transports
+// redeliver it later
+var ErrPipelineBusy = errors.New("pipeline busy")
+
+// Pipeline stage names, in processing order
+const (
+	StageDecode   = "decode"
+	StageValidate = "validate"
+	StageDedupe   = "dedupe"
+	StageDispatch = "dispatch"
+	StageAck      = "ack"
+)
+
+// PriorityStagePrefix names the priority lane's stages, e.g. "priority_dispatch"
+const PriorityStagePrefix = "priority_"
+
+// Message priorities; a message without one takes its type's
+const (
+	PriorityHigh   = "high"   // the priority lane
+	PriorityNormal = "normal" // the bulk lane, even for PriorityTypes
+	PriorityLow    = "low"    // the bulk lane, once normal messages are dispatched
+)
+
+// PipelineConfig tunes the inbound processing pipeline
+type PipelineConfig struct {
+	BufferSize      int // capacity of the channel in front of each stage
+	DecodeWorkers   int // parses in parallel, so arrival order is not preserved
+	DispatchWorkers int
+	DedupeSize      int   // most idempotency keys remembered at once
+	MaxMessageBytes int64 // encoded size cap for every message, sent or received
+
+	// DedupeWindow is how long a handled message's idempotency key, its ID
+	// unless it was sent with one, keeps copies of it from being handled
+	// again. DedupePath, when set, is the file the keys are kept in across
+	// restarts.
+	DedupeWindow time.Duration
+	DedupePath   string
+
+	// ChannelMaxBytes overrides MaxMessageBytes for individual channels
+	ChannelMaxBytes map[CommunicationChannel]int64
+
+	// Compression is the content encoding, such as gzip, that sent payloads
+	// over CompressOver bytes of canonical JSON travel in; empty sends every
+	// payload as it is. Received payloads are decompressed regardless.
+	Compression  string
+	CompressOver int64
+
+	// PriorityTypes are decoded into a lane of their own, with its own queues
+	// and PriorityWorkers dispatch workers, so they never wait behind bulk
+	// messages such as slow AI requests
+	PriorityTypes   []MessageType
+	PriorityWorkers int
+
+	// LowPriorityTypes wait in the bulk lane until no normal message does,
+	// as do messages sent with PriorityLow, except that one dispatch in
+	// every LowPriorityEvery takes a waiting low-priority message first, so
+	// a steady stream of normal messages cannot starve them
+	LowPriorityTypes []MessageType
+	LowPriorityEvery int
+
+	// TypeConcurrency gives message types dispatch workers of their own, so
+	// no more than that many of the type are handled at once and they never
+	// occupy DispatchWorkers or PriorityWorkers
+	TypeConcurrency map[MessageType]int
+
+	// ExpiredAction is what becomes of a message received past its
+	// expires_at: ExpiryDeadLetter, the default, or ExpiryDrop
+	ExpiredAction string
+
+	// TTL expires messages of a type sent without an expires_at that long
+	// after their timestamp
+	TTL map[MessageType]time.Duration
+}
+
+// DefaultPipelineConfig returns the settings used when none are given
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		BufferSize:      64,
+		DecodeWorkers:   runtime.NumCPU(),
+		DispatchWorkers: 1,
+		DedupeSize:      100000,
+		DedupeWindow:    24 * time.Hour,
+		MaxMessageBytes: 32 << 20,
+		CompressOver:    256 << 10,
+		PriorityTypes:   []MessageType{Error, HealthCheck, SaleEvent},
+		PriorityWorkers: 1,
+
+		LowPriorityTypes: []MessageType{CodeTranslation},
+		LowPriorityEvery: 8,
+
+		ExpiredAction: ExpiryDeadLetter,
+	}
+}
+
+// WithPipelineConfig overrides the inbound pipeline settings
+func WithPipelineConfig(config PipelineConfig) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.pipelineConfig = config
+	}
+}
+
+// StageStats is a snapshot of one pipeline stage's counters
+type StageStats struct {
+	Name          string
+	Processed     uint64
+	Failed        uint64
+	Skipped       uint64
+	QueueDepth    int
+	QueueCapacity int
+	TotalTime     time.Duration
+}
+
+// AverageTime returns the mean time spent per processed item
+func (s StageStats) AverageTime() time.Duration {
+	total := s.Processed + s.Failed + s.Skipped
+	if total == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(total)
+}
+
+// pipelineItem carries one envelope through the stages
+type pipelineItem struct {
+	envelope *Envelope
+	message  *UniversalMessage
+}
+
+// stageOutcome is what a stage function decided for an item
+type stageOutcome int
+
+const (
+	outcomeForward stageOutcome = iota // pass to the next stage
+	outcomeDone                        // finished early, e.g. an acked duplicate
+	outcomeFailed                      // envelope was nacked
+)
+
+type pipelineStage struct {
+	name    string
+	in      chan *pipelineItem
+	workers int
+	process func(*pipelineItem) stageOutcome
+
+	// forward hands an item to the next stage, reporting whether it was
+	// taken; nil for the last stage
+	forward func(*pipelineItem) bool
+	// low, on the bulk dispatch stage, queues low-priority items, taken
+	// after in's except for one take in every lowEvery
+	low      chan *pipelineItem
+	lowEvery uint64
+	takes    uint64 // atomic
+	// downstream are the stages this stage feeds; a stage's input closes once
+	// the workers of every stage feeding it have exited
+	downstream []*pipelineStage
+	upstreams  int
+
+	mu    sync.Mutex
+	stats StageStats
+}
+
+func (s *pipelineStage) record(outcome stageOutcome, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch outcome {
+	case outcomeForward:
+		s.stats.Processed++
+	case outcomeDone:
+		s.stats.Skipped++
+	case outcomeFailed:
+		s.stats.Failed++
+	}
+	s.stats.TotalTime += elapsed
+}
+
+// Pipeline processes inbound envelopes through decode → validate → dedupe →
+// dispatch → ack stages connected by bounded channels. After decoding,
+// PriorityTypes continue through a second validate → dedupe → dispatch → ack
+// lane, as do messages sent with PriorityHigh, so a backlog in the bulk
+// lane's dispatch does not hold them up. The bulk lane dispatches
+// low-priority messages after its others, one in LowPriorityEvery aside.
+// Decoding is shared, so it keeps one worker more than DecodeWorkers and
+// only DecodeWorkers may wait for room in a full bulk lane; the spare keeps
+// decoding, nacking bulk messages with ErrPipelineBusy while every other
+// worker waits.
+type Pipeline struct {
+	stages   []*pipelineStage
+	dispatch func(*UniversalMessage) error
+	clock    Clock
+	tracer   *Tracer // times file reads; set before the transports start
+	// duplicate, when set, is told of each copy skipped as already handled
+	duplicate func(*UniversalMessage)
+	seen      *dedupeCache
+	maxBytes  int64
+	priority  map[MessageType]bool
+	low       map[MessageType]bool
+	parking   chan struct{} // one slot per decode worker allowed to wait on the bulk lane
+
+	channelBytes map[CommunicationChannel]int64
+	compression  string
+	compressOver int64
+
+	expiredAction string
+	ttl           map[MessageType]time.Duration
+
+	submitMu sync.RWMutex
+	closed   bool
+	done     chan struct{}
+}
+
+// NewPipeline builds and starts a pipeline that hands messages to dispatch
+func NewPipeline(config PipelineConfig, clock Clock, dispatch func(*UniversalMessage) error) *Pipeline {
+	defaults := DefaultPipelineConfig()
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaults.BufferSize
+	}
+	if config.DecodeWorkers <= 0 {
+		config.DecodeWorkers = defaults.DecodeWorkers
+	}
+	if config.DispatchWorkers <= 0 {
+		config.DispatchWorkers = defaults.DispatchWorkers
+	}
+	if config.DedupeSize <= 0 {
+		config.DedupeSize = defaults.DedupeSize
+	}
+	if config.DedupeWindow <= 0 {
+		config.DedupeWindow = defaults.DedupeWindow
+	}
+	if config.MaxMessageBytes <= 0 {
+		config.MaxMessageBytes = defaults.MaxMessageBytes
+	}
+	if config.PriorityWorkers <= 0 {
+		config.PriorityWorkers = defaults.PriorityWorkers
+	}
+	if config.LowPriorityEvery <= 0 {
+		config.LowPriorityEvery = defaults.LowPriorityEvery
+	}
+	if config.CompressOver <= 0 {
+		config.CompressOver = defaults.CompressOver
+	}
+	if config.ExpiredAction == "" {
+		config.ExpiredAction = defaults.ExpiredAction
+	}
+	if clock == nil {
+		clock = defaultClock
+	}
+
+	p := &Pipeline{
+		dispatch: dispatch,
+		clock:    clock,
+		seen:     newDedupeCache(clock, config.DedupeWindow, config.DedupeSize, config.DedupePath),
+		maxBytes: config.MaxMessageBytes,
+		priority: make(map[MessageType]bool),
+		low:      make(map[MessageType]bool),
+		done:     make(chan struct{}),
+
+		channelBytes: config.ChannelMaxBytes,
+		compression:  config.Compression,
+		compressOver: config.CompressOver,
+
+		expiredAction: config.ExpiredAction,
+		ttl:           config.TTL,
+	}
+
+	for _, messageType := range config.PriorityTypes {
+		p.priority[messageType] = true
+	}
+	for _, messageType := range config.LowPriorityTypes {
+		p.low[messageType] = true
+	}
+
+	decode := &pipelineStage{name: StageDecode, workers: config.DecodeWorkers, process: p.decode}
+	bulkLimits := make(map[MessageType]int)
+	priorityLimits := make(map[MessageType]int)
+	for messageType, limit := range config.TypeConcurrency {
+		if limit <= 0 {
+			continue
+		}
+		if p.priority[messageType] {
+			priorityLimits[messageType] = limit
+		} else {
+			bulkLimits[messageType] = limit
+		}
+	}
+
+	bulk := p.lane("", config.DispatchWorkers, bulkLimits)
+	p.stages = append([]*pipelineStage{decode}, bulk...)
+
+	// Any message may ask for the priority lane, so there always is one
+	priority := p.lane(PriorityStagePrefix, config.PriorityWorkers, priorityLimits)
+	p.stages = append(p.stages, priority...)
+	decode.workers = config.DecodeWorkers + 1
+	decode.downstream = []*pipelineStage{bulk[0], priority[0]}
+	bulk[0].upstreams++
+	priority[0].upstreams++
+	p.parking = make(chan struct{}, config.DecodeWorkers)
+	decode.forward = func(item *pipelineItem) bool {
+		if p.prioritized(item.message) {
+			priority[0].in <- item
+			return true
+		}
+		return p.forwardBulk(item, bulk[0])
+	}
+
+	for _, stage := range p.stages {
+		stage.in = make(chan *pipelineItem, config.BufferSize)
+		stage.stats = StageStats{Name: stage.name, QueueCapacity: config.BufferSize}
+	}
+	bulkDispatch := bulk[2]
+	bulkDispatch.low = make(chan *pipelineItem, config.BufferSize)
+	bulkDispatch.lowEvery = uint64(config.LowPriorityEvery)
+	bulkDispatch.stats.QueueCapacity += config.BufferSize
+
+	p.start()
+	return p
+}
+
+// lane builds the validate → dedupe → dispatch → ack stages that follow
+// decode, chained in order. Each limited type gets a dispatch stage of its
+// own, named like "dispatch_ai_request", between dedupe and ack. Others go
+// to the low queue of the dispatch stage when it has one and they are
+// low-priority.
+func (p *Pipeline) lane(prefix string, dispatchWorkers int, limits map[MessageType]int) []*pipelineStage {
+	stages := []*pipelineStage{
+		{name: prefix + StageValidate, workers: 1, process: p.validate},
+		{name: prefix + StageDedupe, workers: 1, process: p.dedupe},
+		{name: prefix + StageDispatch, workers: dispatchWorkers, process: p.dispatchItem},
+		{name: prefix + StageAck, workers: 1, process: p.ack},
+	}
+	for i := 0; i+1 < len(stages); i++ {
+		chain(stages[i], stages[i+1])
+	}
+
+	dedupe, dispatch, ack := stages[1], stages[2], stages[3]
+	types := make([]string, 0, len(limits))
+	for messageType := range limits {
+		types = append(types, string(messageType))
+	}
+	sort.Strings(types)
+
+	limited := make(map[MessageType]*pipelineStage, len(limits))
+	for _, name := range types {
+		messageType := MessageType(name)
+		stage := &pipelineStage{name: prefix + StageDispatch + "_" + name, workers: limits[messageType], process: p.dispatchItem}
+		chain(stage, ack)
+		dedupe.downstream = append(dedupe.downstream, stage)
+		stage.upstreams++
+		limited[messageType] = stage
+		stages = append(stages[:len(stages)-1], stage, ack)
+	}
+	dedupe.forward = func(item *pipelineItem) bool {
+		if stage := limited[item.message.MessageType]; stage != nil {
+			stage.in <- item
+		} else if dispatch.low != nil && p.lowPriority(item.message) {
+			dispatch.low <- item
+		} else {
+			dispatch.in <- item
+		}
+		return true
+	}
+	return stages
+}
+
+// prioritized reports whether a message takes the priority lane
+func (p *Pipeline) prioritized(message *UniversalMessage) bool {
+	switch message.Priority {
+	case PriorityHigh:
+		return true
+	case PriorityNormal, PriorityLow:
+		return false
+	}
+	return p.priority[message.MessageType]
+}
+
+// lowPriority reports whether a bulk message waits for the others
+func (p *Pipeline) lowPriority(message *UniversalMessage) bool {
+	if message.Priority != "" {
+		return message.Priority == PriorityLow
+	}
+	return p.low[message.MessageType]
+}
+
+// take returns the stage's next item, false once its input is closed and
+// drained. A stage with a low queue prefers in, but one take in every
+// lowEvery looks at low first.
+func (s *pipelineStage) take() (*pipelineItem, bool) {
+	if s.low == nil {
+		item, ok := <-s.in
+		return item, ok
+	}
+	first, second := s.in, s.low
+	if atomic.AddUint64(&s.takes, 1)%s.lowEvery == 0 {
+		first, second = s.low, s.in
+	}
+	// A nil channel is one found closed, which is never ready
+	for first != nil || second != nil {
+		select {
+		case item, ok := <-first:
+			if ok {
+				return item, true
+			}
+			first = nil
+			continue
+		default:
+		}
+		select {
+		case item, ok := <-second:
+			if ok {
+				return item, true
+			}
+			second = nil
+			continue
+		default:
+		}
+		select {
+		case item, ok := <-first:
+			if ok {
+				return item, true
+			}
+			first = nil
+		case item, ok := <-second:
+			if ok {
+				return item, true
+			}
+			second = nil
+		}
+	}
+	return nil, false
+}
+
+// chain makes next the only stage that stage forwards to
+func chain(stage, next *pipelineStage) {
+	stage.forward = func(item *pipelineItem) bool {
+		next.in <- item
+		return true
+	}
+	stage.downstream = []*pipelineStage{next}
+	next.upstreams++
+}
+
+// forwardBulk hands a decoded bulk message to the bulk lane, waiting for
+// room only while a parking slot is free, so a full bulk lane never ties up
+// every decode worker
+func (p *Pipeline) forwardBulk(item *pipelineItem, bulk *pipelineStage) bool {
+	select {
+	case bulk.in <- item:
+		return true
+	default:
+	}
+
+	select {
+	case p.parking <- struct{}{}:
+	default:
+		log.Printf("❌ Deferring message %s: %v", item.message.ID, ErrPipelineBusy)
+		item.envelope.Nack(ErrPipelineBusy)
+		return false
+	}
+	bulk.in <- item
+	<-p.parking
+	return true
+}
+
+// start launches every stage's workers; a stage's input is closed once all
+// the workers of the stages feeding it have exited, and the pipeline is done
+// when every lane's last stage has finished
+func (p *Pipeline) start() {
+	var lanes sync.WaitGroup
+	var closing sync.Mutex
+	for _, stage := range p.stages {
+		var wg sync.WaitGroup
+		for w := 0; w < stage.workers; w++ {
+			wg.Add(1)
+			go p.runStage(stage, &wg)
+		}
+
+		if len(stage.downstream) == 0 {
+			lanes.Add(1)
+		}
+		go func(stage *pipelineStage) {
+			wg.Wait()
+			closing.Lock()
+			for _, next := range stage.downstream {
+				next.upstreams--
+				if next.upstreams == 0 {
+					close(next.in)
+					if next.low != nil {
+						close(next.low)
+					}
+				}
+			}
+			closing.Unlock()
+			if len(stage.downstream) == 0 {
+				lanes.Done()
+			}
+		}(stage)
+	}
+
+	go func() {
+		lanes.Wait()
+		close(p.done)
+	}()
+}
+
+func (p *Pipeline) runStage(stage *pipelineStage, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		item, ok := stage.take()
+		if !ok {
+			return
+		}
+		started := p.clock.Now()
+		outcome := stage.process(item)
+		elapsed := p.clock.Now().Sub(started)
+		forwarded := false
+		if outcome == outcomeForward && stage.forward != nil {
+			forwarded = stage.forward(item)
+			if !forwarded {
+				outcome = outcomeFailed
+			}
+		}
+		stage.record(outcome, elapsed)
+
+		if !forwarded {
+			putPipelineItem(item)
+		}
+	}
+}
+
+// Submit queues an envelope, blocking while the decode stage is full.
+// Envelopes submitted after Close are nacked.
+func (p *Pipeline) Submit(envelope *Envelope) {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+
+	if p.closed {
+		envelope.Nack(ErrPipelineClosed)
+		return
+	}
+	p.stages[0].in <- getPipelineItem(envelope)
+}
+
+// Close stops accepting envelopes and waits for queued ones to finish
+func (p *Pipeline) Close() {
+	p.submitMu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.stages[0].in)
+	}
+	p.submitMu.Unlock()
+	<-p.done
+	p.seen.close()
+}
+
+// Stats returns a snapshot of every stage's counters in processing order,
+// the bulk lane's stages before the priority lane's
+func (p *Pipeline) Stats() []StageStats {
+	stats := make([]StageStats, 0, len(p.stages))
+	for _, stage := range p.stages {
+		stage.mu.Lock()
+		snapshot := stage.stats
+		stage.mu.Unlock()
+		snapshot.QueueDepth = len(stage.in) + len(stage.low)
+		stats = append(stats, snapshot)
+	}
+	return stats
+}
+
+// fail nacks the envelope and logs why
+func (p *Pipeline) fail(item *pipelineItem, format string, err error) stageOutcome {
+	log.Printf(format, item.envelope.Source, err)
+	item.envelope.Nack(err)
+	return outcomeFailed
+}
+
+func (p *Pipeline) decode(item *pipelineItem) stageOutcome {
+	envelope := item.envelope
+	limit := p.limitFor(envelope.Channel)
+	if envelope.Size > limit {
+		return p.fail(item, "❌ Rejecting message %s: %v", &PayloadTooLargeError{Channel: envelope.Channel, Size: envelope.Size, Limit: limit})
+	}
+
+	start := p.clock.Now()
+	r, err := envelope.Open()
+	if err != nil {
+		return p.fail(item, "❌ Error reading message %s: %v", err)
+	}
+	message, err := decodeEnvelope(r, envelope.ContentType, limit)
+	r.Close()
+	if err == nil && envelope.Channel == FileSystem && p.tracer != nil {
+		// The read is only known to belong to a trace once it is decoded
+		span := p.tracer.Start("file.read", SpanInternal, message.traceContext())
+		span.Start = start
+		span.SetAttribute("file.path", envelope.Source)
+		span.Finish(nil)
+	}
+	var tooLarge *PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		tooLarge.Channel = envelope.Channel
+		return p.fail(item, "❌ Rejecting message %s: %v", err)
+	}
+	if err != nil {
+		return p.fail(item, "❌ Error parsing message %s: %v", err)
+	}
+	message.receivedOn = envelope.Channel
+	item.message = message
+	return outcomeForward
+}
+
+func (p *Pipeline) validate(item *pipelineItem) stageOutcome {
+	message := item.message
+	var missing []string
+	if message.ID == "" {
+		missing = append(missing, "id")
+	}
+	if message.MessageType == "" {
+		missing = append(missing, "message_type")
+	}
+	if message.SourceLanguage == "" {
+		missing = append(missing, "source_language")
+	}
+	if len(missing) > 0 {
+		return p.fail(item, "❌ Invalid message %s: %v", fmt.Errorf("missing required fields %v", missing))
+	}
+	if _, _, err := message.expiry(); err != nil {
+		return p.fail(item, "❌ Invalid message %s: %v", err)
+	}
+	if _, _, err := message.deliveryTime(); err != nil {
+		return p.fail(item, "❌ Invalid message %s: %v", err)
+	}
+	switch message.Priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+	default:
+		return p.fail(item, "❌ Invalid message %s: %v", fmt.Errorf("unknown priority %q", message.Priority))
+	}
+	return outcomeForward
+}
+
+func (p *Pipeline) dedupe(item *pipelineItem) stageOutcome {
+	switch p.seen.begin(item.message.idempotencyKey()) {
+	case dedupeCompleted:
+		fmt.Printf("♻️ Skipping duplicate message: %s (%s)\n", item.message.ID, item.message.idempotencyKey())
+		if err := item.envelope.Ack(); err != nil {
+			log.Printf("❌ Error acknowledging duplicate %s: %v", item.message.ID, err)
+		}
+		if p.duplicate != nil {
+			p.duplicate(item.message)
+		}
+		return outcomeDone
+	case dedupeInFlight:
+		item.envelope.Nack(ErrDuplicateInFlight)
+		return outcomeDone
+	}
+	return outcomeForward
+}
+
+func (p *Pipeline) dispatchItem(item *pipelineItem) stageOutcome {
+	err := p.dispatch(item.message)
+	if err != nil {
+		p.seen.abort(item.message.idempotencyKey())
+		log.Printf("❌ Error handling message %s: %v", item.message.ID, err)
+		item.envelope.Nack(err)
+		return outcomeFailed
+	}
+	return outcomeForward
+}
+
+func (p *Pipeline) ack(item *pipelineItem) stageOutcome {
+	if item.message.nacked {
+		p.seen.abort(item.message.idempotencyKey())
+	} else {
+		p.seen.complete(item.message.idempotencyKey())
+	}
+
+	err := item.envelope.Ack()
+	if err != nil {
+		log.Printf("❌ Error acknowledging message %s: %v", item.message.ID, err)
+		return outcomeFailed
+	}
+	return outcomeForward
+}
+
+// parseTypeConcurrency reads per-type dispatch limits written as
+// type=workers pairs separated by commas
+func parseTypeConcurrency(list string) (map[MessageType]int, error) {
+	limits := make(map[MessageType]int)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, count := field, ""
+		if eq := strings.Index(field, "="); eq >= 0 {
+			name, count = field[:eq], field[eq+1:]
+		}
+		workers, err := strconv.Atoi(count)
+		if name == "" || err != nil || workers <= 0 {
+			return nil, fmt.Errorf("bad limit %q, want type=workers", field)
+		}
+		limits[MessageType(name)] = workers
+	}
+	return limits, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineDedupe(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	tests := []struct {
+		name       string
+		dedupeSize int
+		deliveries []string // IDs in delivery order
+		failures   []error  // handler results in call order; nil past the end
+		wantCalls  int
+		wantNacks  int
+	}{
+		{"redelivery after success is acked", 0, []string{"a", "a", "a"}, nil, 1, 0},
+		{"distinct messages", 0, []string{"a", "b", "c"}, nil, 3, 0},
+		{"redelivery after failure is handled again", 0, []string{"a", "a"}, []error{errHandler}, 2, 1},
+		{"oldest completed ID is forgotten", 2, []string{"a", "b", "c", "a", "c"}, nil, 4, 0},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+			memory := NewMemoryTransport()
+			config := DefaultPipelineConfig()
+			config.DedupeSize = tt.dedupeSize
+			gb := NewGoBridge("", WithClock(clock), WithTransport(memory), WithPipelineConfig(config))
+			t.Cleanup(func() { gb.Close() })
+
+			calls := 0
+			gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+
+			messages := make(map[string]*UniversalMessage)
+			for _, id := range tt.deliveries {
+				message := messages[id]
+				if message == nil {
+					message = newUniversalMessage(clock, NewSequentialIDs(id), DataSync, "python", "go", map[string]interface{}{"id": id}, SharedMemory)
+					messages[id] = message
+				}
+				// Inject waits for the ack or nack, so deliveries do not overlap
+				memory.Inject(message)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			if nacks := len(memory.Nacked()); nacks != tt.wantNacks {
+				t.Fatalf("%d nacks, want %d", nacks, tt.wantNacks)
+			}
+			if acks := len(memory.Acked()); acks != len(tt.deliveries)-tt.wantNacks {
+				t.Fatalf("%d acks, want %d", acks, len(tt.deliveries)-tt.wantNacks)
+			}
+		})
+	}
+}
+
+func TestPipelinePriority(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	config := DefaultPipelineConfig()
+	config.DecodeWorkers = 1
+	config.LowPriorityEvery = 3
+
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	p := NewPipeline(config, clock, func(message *UniversalMessage) error {
+		if message.ID == "blocker" {
+			close(blocked)
+			<-release
+		}
+		mu.Lock()
+		order = append(order, message.ID)
+		mu.Unlock()
+		return nil
+	})
+	t.Cleanup(p.Close)
+
+	depth := func(stage string) int {
+		for _, stats := range p.Stats() {
+			if stats.Name == stage {
+				return stats.QueueDepth
+			}
+		}
+		return -1
+	}
+	submit := func(id string, messageType MessageType, priority string) {
+		t.Helper()
+		message := newUniversalMessage(clock, NewSequentialIDs(id), messageType, "python", "go", nil, SharedMemory)
+		message.ID = id
+		message.Priority = priority
+		message.Checksum = message.calculateChecksum()
+		data, err := message.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Submit(NewEnvelope(SharedMemory, id, []byte(data), func() error { return nil }, func(reason error) {
+			t.Errorf("%s nacked: %v", id, reason)
+		}))
+	}
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		for start := time.Now(); !done(); time.Sleep(time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	handled := func(id string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, handled := range order {
+				if handled == id {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	// The bulk lane's only worker is busy, so the rest queue behind it
+	submit("blocker", DataSync, "")
+	<-blocked
+	queued := 0
+	for _, m := range []struct {
+		id          string
+		messageType MessageType
+		priority    string
+	}{
+		{"translate1", CodeTranslation, ""},
+		{"translate2", CodeTranslation, ""},
+		{"report", AIRequest, PriorityLow},
+		{"sync1", DataSync, ""},
+		{"health", HealthCheck, PriorityNormal},
+		{"sync2", DataSync, ""},
+		{"sync3", DataSync, ""},
+	} {
+		submit(m.id, m.messageType, m.priority)
+		queued++
+		waitFor(m.id+" to queue", func() bool { return depth(StageDispatch) == queued })
+	}
+
+	// High priority skips the queue, by type or by asking for it
+	submit("sale", SaleEvent, "")
+	submit("urgent", DataSync, PriorityHigh)
+	waitFor("the priority lane", handled("urgent"))
+	waitFor("the priority lane", handled("sale"))
+	if depth(StageDispatch) != queued {
+		t.Fatalf("bulk dispatch holds %d messages, want %d", depth(StageDispatch), queued)
+	}
+
+	close(release)
+	waitFor("the bulk lane", handled("report"))
+	mu.Lock()
+	defer mu.Unlock()
+	// One take in three looks at the low queue first
+	want := []string{"blocker", "sync1", "translate1", "health", "sync2", "translate2", "sync3", "report"}
+	if got := order[2:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("bulk lane handled %v, want %v", got, want)
+	}
+}
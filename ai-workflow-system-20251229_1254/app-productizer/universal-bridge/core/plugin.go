@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PluginProtocolVersion is the version of the RPC contract plugins speak.
+// It changes only when an existing method or field changes meaning; new
+// optional fields keep it.
+const PluginProtocolVersion = 1
+
+const (
+	// pluginMagicEnv is set for every plugin, so a plugin run by hand can
+	// say it is one instead of waiting on stdin
+	pluginMagicEnv   = "BRIDGE_PLUGIN_MAGIC"
+	pluginMagicValue = "universal-bridge-plugin-3f7c1a"
+
+	defaultPluginTimeout  = 30 * time.Second
+	pluginStopGrace       = 5 * time.Second
+	maxPluginRestartDelay = time.Minute
+)
+
+// ErrPluginClosed is returned for calls after Close
+var ErrPluginClosed = errors.New("plugin closed")
+
+var pluginName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// pluginBaseEnv is what a plugin inherits from the bridge's environment.
+// The bridge's secrets reach a plugin only through its env setting.
+var pluginBaseEnv = []string{"PATH", "HOME", "TMPDIR", "LANG", "TZ", "SYSTEMROOT"}
+
+// PluginConfig lists the plugins to run, loaded from YAML:
+//
+//	plugins:
+//	  - name: crm
+//	    command: ./plugins/crm          # relative to this file
+//	    args: [--region, eu]
+//	    env:
+//	      CRM_API_KEY: ${CRM_API_KEY}   # expanded from the bridge's environment
+//	    timeout: 30s                    # per call, the default
+//	    workers: 2                      # for its sink; sink defaults otherwise
+//	    queue_size: 500
+//	    max_attempts: 5
+//
+// Each plugin says in its handshake which message types it takes as a sink
+// and which it handles; see the plugin section of the protocol contract.
+type PluginConfig struct {
+	Plugins []PluginSpec `yaml:"plugins"`
+}
+
+// PluginSpec is how one plugin is started
+type PluginSpec struct {
+	Name        string            `yaml:"name"`
+	Command     string            `yaml:"command"`
+	Args        []string          `yaml:"args"`
+	Dir         string            `yaml:"dir"` // working directory, relative to the config file
+	Env         map[string]string `yaml:"env"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	Workers     int               `yaml:"workers"`
+	QueueSize   int               `yaml:"queue_size"`
+	MaxAttempts int               `yaml:"max_attempts"`
+}
+
+// LoadPluginConfig reads the plugin list. Relative commands containing a
+// slash and relative directories are resolved against the file's directory.
+func LoadPluginConfig(path string) (PluginConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PluginConfig{}, fmt.Errorf("failed to read plugin config: %v", err)
+	}
+
+	var config PluginConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return PluginConfig{}, fmt.Errorf("failed to parse plugin config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (PluginConfig, error) {
+		return PluginConfig{}, fmt.Errorf("invalid plugin config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	if len(config.Plugins) == 0 {
+		return invalid("no plugins")
+	}
+	base := filepath.Dir(path)
+	seen := make(map[string]bool)
+	for i := range config.Plugins {
+		spec := &config.Plugins[i]
+		if !pluginName.MatchString(spec.Name) {
+			return invalid("plugin name %q is not a lowercase name such as crm", spec.Name)
+		}
+		if seen[spec.Name] {
+			return invalid("plugin %s is listed twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Command == "" {
+			return invalid("plugin %s has no command", spec.Name)
+		}
+		if spec.Timeout < 0 || spec.Workers < 0 || spec.QueueSize < 0 || spec.MaxAttempts < 0 {
+			return invalid("plugin %s has a negative timeout, workers, queue_size or max_attempts", spec.Name)
+		}
+		if spec.Timeout == 0 {
+			spec.Timeout = defaultPluginTimeout
+		}
+		if strings.ContainsRune(spec.Command, '/') && !filepath.IsAbs(spec.Command) {
+			spec.Command = filepath.Join(base, spec.Command)
+		}
+		if spec.Dir != "" && !filepath.IsAbs(spec.Dir) {
+			spec.Dir = filepath.Join(base, spec.Dir)
+		}
+	}
+	return config, nil
+}
+
+// PluginHello is the bridge's side of the handshake, the params of
+// Plugin.Handshake
+type PluginHello struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Name            string `json:"name"` // the plugin's name in the config
+}
+
+// PluginManifest is the plugin's answer to the handshake
+type PluginManifest struct {
+	ProtocolVersion int           `json:"protocol_version"`
+	Sinks           []MessageType `json:"sinks,omitempty"`    // delivered to Plugin.Deliver
+	Handlers        []MessageType `json:"handlers,omitempty"` // handled by Plugin.Handle
+}
+
+// PluginMessage is the params of Plugin.Deliver and Plugin.Handle
+type PluginMessage struct {
+	Message *UniversalMessage `json:"message"`
+}
+
+// Plugin is a plugin process, spoken to with JSON-RPC over its stdin and
+// stdout; what it writes to stderr is logged. A plugin that exits or stops
+// answering is started again on the next call, waiting longer after each
+// start that fails.
+type Plugin struct {
+	Spec     PluginSpec
+	Manifest PluginManifest
+
+	mu      sync.Mutex
+	process *pluginProcess
+	delay   time.Duration
+	retryAt time.Time
+	closed  bool
+}
+
+// pluginProcess is one run of a plugin
+type pluginProcess struct {
+	cmd      *exec.Cmd
+	client   *rpc.Client
+	exited   chan struct{}
+	stopping atomic.Bool // set when the bridge stops it, so its exit is expected
+}
+
+// pluginPipe joins the plugin's stdout and stdin into one connection
+type pluginPipe struct {
+	io.ReadCloser
+	stdin io.WriteCloser
+}
+
+func (pp pluginPipe) Write(p []byte) (int, error) {
+	return pp.stdin.Write(p)
+}
+
+func (pp pluginPipe) Close() error {
+	err := pp.stdin.Close()
+	pp.ReadCloser.Close()
+	return err
+}
+
+// RegisterPlugins starts every plugin and registers the sinks and handlers
+// their handshakes declare. A plugin handler may not replace a handler the
+// bridge already has. On error the plugins already started are stopped.
+func RegisterPlugins(gb *GoBridge, config PluginConfig) ([]*Plugin, error) {
+	var plugins []*Plugin
+	fail := func(err error) ([]*Plugin, error) {
+		for _, plugin := range plugins {
+			plugin.Close()
+		}
+		return nil, err
+	}
+
+	for _, spec := range config.Plugins {
+		plugin := &Plugin{Spec: spec}
+		if _, err := plugin.running(); err != nil {
+			return fail(err)
+		}
+		plugins = append(plugins, plugin)
+		manifest := plugin.Manifest
+		if len(manifest.Sinks) == 0 && len(manifest.Handlers) == 0 {
+			return fail(fmt.Errorf("plugin %s declares no sinks or handlers", spec.Name))
+		}
+
+		for _, messageType := range manifest.Handlers {
+			gb.mu.Lock()
+			_, taken := gb.messageHandlers[messageType]
+			gb.mu.Unlock()
+			if taken {
+				return fail(fmt.Errorf("plugin %s handles %s, which already has a handler", spec.Name, messageType))
+			}
+			gb.OnMessage(messageType, func(message *UniversalMessage) error {
+				return plugin.call("Plugin.Handle", message)
+			})
+		}
+		if len(manifest.Sinks) > 0 {
+			gb.AddSink("plugin_"+spec.Name, SinkConfig{
+				Types:       manifest.Sinks,
+				Workers:     spec.Workers,
+				QueueSize:   spec.QueueSize,
+				MaxAttempts: spec.MaxAttempts,
+			}, func(message *UniversalMessage) error {
+				return plugin.call("Plugin.Deliver", message)
+			})
+		}
+		fmt.Printf("🔌 Loaded plugin %s: sinks %v, handlers %v\n", spec.Name, manifest.Sinks, manifest.Handlers)
+	}
+	return plugins, nil
+}
+
+// call sends a message to the plugin. An error from the plugin fails the
+// message; a call that times out or loses the process also stops the
+// plugin, to be started again.
+func (p *Plugin) call(method string, message *UniversalMessage) error {
+	process, err := p.running()
+	if err != nil {
+		return err
+	}
+	var ack struct{}
+	err = p.invoke(process, method, PluginMessage{Message: message}, &ack)
+	if _, failed := err.(rpc.ServerError); err != nil && !failed {
+		p.discard(process)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", p.Spec.Name, err)
+	}
+	return nil
+}
+
+// invoke makes one call, giving up after the plugin's timeout
+func (p *Plugin) invoke(process *pluginProcess, method string, args, reply interface{}) error {
+	call := process.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(p.Spec.Timeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return fmt.Errorf("%s timed out after %s", method, p.Spec.Timeout)
+	}
+}
+
+// running returns the plugin's process, starting it when it is not running
+func (p *Plugin) running() (*pluginProcess, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPluginClosed
+	}
+	if p.process != nil {
+		select {
+		case <-p.process.exited:
+			p.process = nil
+		default:
+			return p.process, nil
+		}
+	}
+
+	now := time.Now()
+	if now.Before(p.retryAt) {
+		return nil, fmt.Errorf("plugin %s is down; starting it again in %s", p.Spec.Name, p.retryAt.Sub(now).Round(time.Second))
+	}
+	process, manifest, err := p.start()
+	if err != nil {
+		p.delay *= 2
+		if p.delay == 0 {
+			p.delay = time.Second
+		}
+		if p.delay > maxPluginRestartDelay {
+			p.delay = maxPluginRestartDelay
+		}
+		p.retryAt = now.Add(p.delay)
+		return nil, err
+	}
+	p.delay = 0
+
+	// Sinks and handlers are registered from the first handshake only
+	if p.Manifest.ProtocolVersion != 0 && (fmt.Sprint(manifest.Sinks) != fmt.Sprint(p.Manifest.Sinks) || fmt.Sprint(manifest.Handlers) != fmt.Sprint(p.Manifest.Handlers)) {
+		fmt.Printf("⚠️ Plugin %s changed its sinks or handlers on restart; restart the bridge to register them\n", p.Spec.Name)
+	}
+	if p.Manifest.ProtocolVersion == 0 {
+		p.Manifest = manifest
+	}
+	p.process = process
+	return process, nil
+}
+
+// start runs the plugin and completes the handshake
+func (p *Plugin) start() (*pluginProcess, PluginManifest, error) {
+	name := p.Spec.Name
+	cmd := exec.Command(p.Spec.Command, p.Spec.Args...)
+	cmd.Dir = p.Spec.Dir
+	cmd.Env = p.environment()
+
+	// Pipes of our own, since Wait closes the ones exec makes while they
+	// may still be read
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, PluginManifest{}, fmt.Errorf("failed to start plugin %s: %v", name, err)
+	}
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutReader.Close()
+		stdoutWriter.Close()
+		return nil, PluginManifest{}, fmt.Errorf("failed to start plugin %s: %v", name, err)
+	}
+	cmd.Stdout, cmd.Stderr = stdoutWriter, stderrWriter
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdoutReader.Close()
+		stderrReader.Close()
+		return nil, PluginManifest{}, fmt.Errorf("failed to start plugin %s: %v", name, err)
+	}
+
+	process := &pluginProcess{
+		cmd:    cmd,
+		client: rpc.NewClientWithCodec(jsonrpc.NewClientCodec(pluginPipe{stdoutReader, stdin})),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderrReader)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			fmt.Printf("🔌 [%s] %s\n", name, scanner.Text())
+		}
+		stderrReader.Close()
+	}()
+	go func() {
+		err := cmd.Wait()
+		if !process.stopping.Load() {
+			fmt.Printf("⚠️ Plugin %s exited: %v\n", name, err)
+		}
+		close(process.exited)
+	}()
+
+	var manifest PluginManifest
+	hello := PluginHello{ProtocolVersion: PluginProtocolVersion, Name: name}
+	err = p.invoke(process, "Plugin.Handshake", hello, &manifest)
+	if err == nil && manifest.ProtocolVersion != PluginProtocolVersion {
+		err = fmt.Errorf("it speaks protocol version %d; the bridge speaks %d", manifest.ProtocolVersion, PluginProtocolVersion)
+	}
+	if err != nil {
+		process.stop(0)
+		return nil, PluginManifest{}, fmt.Errorf("plugin %s failed its handshake: %v", name, err)
+	}
+	return process, manifest, nil
+}
+
+// environment is the base variables, the magic cookie and the plugin's own
+// env, expanded from the bridge's environment
+func (p *Plugin) environment() []string {
+	env := []string{pluginMagicEnv + "=" + pluginMagicValue}
+	for _, name := range pluginBaseEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	names := make([]string, 0, len(p.Spec.Env))
+	for name := range p.Spec.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+os.ExpandEnv(p.Spec.Env[name]))
+	}
+	return env
+}
+
+// discard stops a process that failed a call, unless it was already replaced
+func (p *Plugin) discard(process *pluginProcess) {
+	p.mu.Lock()
+	if p.process == process {
+		p.process = nil
+	}
+	p.mu.Unlock()
+	go process.stop(0)
+}
+
+// stop closes the plugin's stdin, which asks it to exit, and kills it
+// after the grace period
+func (pp *pluginProcess) stop(grace time.Duration) {
+	pp.stopping.Store(true)
+	pp.client.Close()
+	select {
+	case <-pp.exited:
+		return
+	case <-time.After(grace):
+	}
+	pp.cmd.Process.Kill()
+	<-pp.exited
+}
+
+// Close stops the plugin; calls after it fail with ErrPluginClosed
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	process := p.process
+	p.process = nil
+	p.closed = true
+	p.mu.Unlock()
+	if process != nil {
+		process.stop(pluginStopGrace)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperPlugin is the plugin the tests run, the test binary started
+// again with GO_WANT_HELPER_PLUGIN set. It serves the contract with Go's own
+// JSON-RPC package, as a third-party plugin in Go would.
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PLUGIN") != "1" {
+		return
+	}
+	if os.Getenv(pluginMagicEnv) != pluginMagicValue {
+		fmt.Fprintln(os.Stderr, "not started by a bridge")
+		os.Exit(1)
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Plugin", helperPlugin{})
+	server.ServeCodec(jsonrpc.NewServerCodec(stdio{os.Stdin, os.Stdout}))
+	os.Exit(0)
+}
+
+type stdio struct {
+	io.Reader
+	io.WriteCloser
+}
+
+type helperPlugin struct{}
+
+func (helperPlugin) Handshake(hello PluginHello, manifest *PluginManifest) error {
+	version := PluginProtocolVersion
+	if v := os.Getenv("HELPER_PLUGIN_VERSION"); v != "" {
+		version, _ = strconv.Atoi(v)
+	}
+	*manifest = PluginManifest{ProtocolVersion: version, Sinks: []MessageType{DataSync}, Handlers: []MessageType{"crm_lookup"}}
+	fmt.Fprintf(os.Stderr, "hello from %s\n", hello.Name)
+	return nil
+}
+
+func (helperPlugin) Deliver(in PluginMessage, ack *struct{}) error {
+	return helperAct(in.Message)
+}
+
+func (helperPlugin) Handle(in PluginMessage, ack *struct{}) error {
+	return helperAct(in.Message)
+}
+
+// helperAct does what the message's action says
+func helperAct(message *UniversalMessage) error {
+	switch message.Payload["action"] {
+	case "fail":
+		return fmt.Errorf("refused %s", message.ID)
+	case "env":
+		name := message.Payload["name"].(string)
+		return fmt.Errorf("%s=%q", name, os.Getenv(name))
+	case "crash":
+		os.Exit(3)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+	return nil
+}
+
+func writePluginConfig(t *testing.T, content string) PluginConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugins.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadPluginConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func helperPluginConfig(t *testing.T, extraEnv string) PluginConfig {
+	return writePluginConfig(t, fmt.Sprintf(`plugins:
+  - name: crm
+    command: %q
+    args: ["-test.run=^TestHelperPlugin$"]
+    timeout: 2s
+    env:
+      GO_WANT_HELPER_PLUGIN: "1"
+      GREETING: "hi ${HELPER_PLUGIN_NAME}"
+%s`, os.Args[0], extraEnv))
+}
+
+func TestPlugins(t *testing.T) {
+	t.Setenv("HELPER_PLUGIN_NAME", "ada")
+	t.Setenv(apiTokenEnv, "bridge-secret")
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+
+	plugins, err := RegisterPlugins(gb, helperPluginConfig(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := plugins[0]
+	t.Cleanup(plugin.Close)
+
+	gb.mu.Lock()
+	_, handled := gb.messageHandlers["crm_lookup"]
+	var sinks []string
+	for _, sink := range gb.sinks {
+		sinks = append(sinks, sink.Name())
+	}
+	gb.mu.Unlock()
+	if !handled || strings.Join(sinks, ",") != "plugin_crm" {
+		t.Fatalf("handler registered %v, sinks %v", handled, sinks)
+	}
+
+	ids := NewSequentialIDs("py")
+	call := func(payload map[string]interface{}) error {
+		return plugin.call("Plugin.Handle", newUniversalMessage(clock, ids, "crm_lookup", "python", "go", payload, SharedMemory))
+	}
+	if err = call(map[string]interface{}{}); err != nil {
+		t.Errorf("plain message: %v", err)
+	}
+	if err = call(map[string]interface{}{"action": "fail"}); err == nil || !strings.Contains(err.Error(), "plugin crm: refused py-") {
+		t.Errorf("failed message: %v", err)
+	}
+	for name, want := range map[string]string{"GREETING": "hi ada", apiTokenEnv: ""} {
+		err = call(map[string]interface{}{"action": "env", "name": name})
+		if err == nil || !strings.HasSuffix(err.Error(), fmt.Sprintf("%s=%q", name, want)) {
+			t.Errorf("plugin sees %v, want %s=%q", err, name, want)
+		}
+	}
+
+	// A crash fails the call; the next starts the plugin again
+	if err = call(map[string]interface{}{"action": "crash"}); err == nil {
+		t.Error("crashed plugin answered")
+	}
+	if err = call(map[string]interface{}{}); err != nil {
+		t.Errorf("after the crash: %v", err)
+	}
+	// So does one that stops answering
+	if err = call(map[string]interface{}{"action": "hang"}); err == nil || !strings.Contains(err.Error(), "timed out after 2s") {
+		t.Errorf("hung plugin: %v", err)
+	}
+	if err = call(map[string]interface{}{}); err != nil {
+		t.Errorf("after the hang: %v", err)
+	}
+
+	plugin.Close()
+	if err = call(map[string]interface{}{}); err != ErrPluginClosed {
+		t.Errorf("after Close: %v", err)
+	}
+}
+
+func TestPluginHandshakeFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		setup   func(gb *GoBridge)
+		wantErr string
+	}{
+		{"protocol version", `      HELPER_PLUGIN_VERSION: "2"`, nil, "plugin crm failed its handshake: it speaks protocol version 2; the bridge speaks 1"},
+		{"handler taken", "", func(gb *GoBridge) { gb.OnMessage("crm_lookup", func(*UniversalMessage) error { return nil }) },
+			"plugin crm handles crm_lookup, which already has a handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+			t.Cleanup(func() { gb.Close() })
+			if tt.setup != nil {
+				tt.setup(gb)
+			}
+			_, err := RegisterPlugins(gb, helperPluginConfig(t, tt.env))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	config := writePluginConfig(t, "plugins:\n  - name: missing\n    command: ./no-such-plugin\n")
+	if _, err := RegisterPlugins(gb, config); err == nil || !strings.Contains(err.Error(), "failed to start plugin missing") {
+		t.Errorf("missing command: %v", err)
+	}
+}
+
+func TestLoadPluginConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "plugins.yaml")
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"no plugins", "plugins: []\n", "no plugins"},
+		{"bad name", "plugins:\n  - name: CRM\n    command: crm\n", `plugin name "CRM" is not a lowercase name`},
+		{"twice", "plugins:\n  - name: crm\n    command: crm\n  - name: crm\n    command: crm2\n", "plugin crm is listed twice"},
+		{"no command", "plugins:\n  - name: crm\n", "plugin crm has no command"},
+		{"negative", "plugins:\n  - name: crm\n    command: crm\n    workers: -1\n", "negative"},
+		{"ok", "plugins:\n  - name: crm\n    command: ./bin/crm\n    dir: work\n  - name: lookup\n    command: lookup-plugin\n    timeout: 5s\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadPluginConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			crm, lookup := config.Plugins[0], config.Plugins[1]
+			if crm.Command != filepath.Join(dir, "bin", "crm") || crm.Dir != filepath.Join(dir, "work") || crm.Timeout != defaultPluginTimeout {
+				t.Errorf("crm %+v", crm)
+			}
+			// A bare command is looked up on PATH
+			if lookup.Command != "lookup-plugin" || lookup.Timeout != 5*time.Second {
+				t.Errorf("lookup %+v", lookup)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledBuffer keeps one giant message from pinning its buffer in the pool forever
+const maxPooledBuffer = 4 << 20
+
+// encodeBuffer is a pooled buffer with JSON encoders bound to it. Keeping the
+// encoders lets encoding/json reuse its internal indent scratch space too.
+type encodeBuffer struct {
+	bytes.Buffer
+	compact  *json.Encoder
+	indented *json.Encoder
+}
+
+// bufferPool recycles encode buffers used for checksums and JSON output
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := new(encodeBuffer)
+		buf.compact = json.NewEncoder(&buf.Buffer)
+		// The canonical checksum payload leaves <, > and & unescaped
+		buf.compact.SetEscapeHTML(false)
+		buf.indented = json.NewEncoder(&buf.Buffer)
+		buf.indented.SetIndent("", "  ")
+		return buf
+	},
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *encodeBuffer {
+	buf := bufferPool.Get().(*encodeBuffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to the pool unless it has grown too large
+func putBuffer(buf *encodeBuffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// itemPool recycles pipeline items, which never outlive their trip through the stages
+var itemPool = sync.Pool{
+	New: func() interface{} { return new(pipelineItem) },
+}
+
+func getPipelineItem(envelope *Envelope) *pipelineItem {
+	item := itemPool.Get().(*pipelineItem)
+	item.envelope = envelope
+	return item
+}
+
+func putPipelineItem(item *pipelineItem) {
+	*item = pipelineItem{}
+	itemPool.Put(item)
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	portalCookie     = "portal_session"
+	magicLinkTTL     = 15 * time.Minute
+	portalSessionTTL = 7 * 24 * time.Hour
+	// thankYouTTL is how long after checkout the thank-you page shows the
+	// purchase; after that its link only points to the portal login
+	thankYouTTL = 24 * time.Hour
+)
+
+// Portal is the customer-facing web portal. Buyers log in with a magic link
+// sent to their purchase email, then see their purchases from the sales
+// store: download links, license keys, activations and subscription status.
+type Portal struct {
+	// BaseURL is the portal's public address, used in magic links
+	BaseURL string
+	// OptOuts, when set, enables the /unsubscribe links in broadcast emails
+	OptOuts *OptOutList
+	// Waitlists, when set, enables the public /waitlist signup form
+	Waitlists *Waitlists
+	// Checkouts, when set, enables the public /track endpoint for product views
+	Checkouts *AbandonedCheckouts
+	// Licenses, when set, enables the public /licenses/verify endpoint sold
+	// apps validate their license keys with
+	Licenses *LicenseVerifier
+	// Usage, when set, enables the public /usage endpoint sold apps report
+	// usage pings to
+	Usage *UsageTelemetry
+	// Discord, when set, enables /discord/interactions, the endpoint the
+	// Discord application's /verify command is sent to
+	Discord *DiscordRoles
+
+	bridge    *GoBridge
+	sales     *SalesStore
+	templates *TemplateStore
+	server    *http.Server
+
+	mu       sync.Mutex
+	links    map[string]portalGrant // magic link token → email
+	sessions map[string]portalGrant // session ID → email
+}
+
+// portalGrant is an email a token or session was issued for
+type portalGrant struct {
+	email   string
+	csrf    string
+	expires time.Time
+}
+
+// NewPortal creates a portal over the sales store
+func NewPortal(gb *GoBridge, sales *SalesStore, templates *TemplateStore, baseURL string) *Portal {
+	return &Portal{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		bridge:    gb,
+		sales:     sales,
+		templates: templates,
+		links:     make(map[string]portalGrant),
+		sessions:  make(map[string]portalGrant),
+	}
+}
+
+// Handler returns the portal's HTTP routes
+func (p *Portal) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", p.handleHome)
+	mux.HandleFunc("/login", p.handleLogin)
+	mux.HandleFunc("/auth", p.handleAuth)
+	mux.HandleFunc("/logout", p.handleLogout)
+	mux.HandleFunc("/thanks", p.handleThanks)
+	mux.HandleFunc("/activations/reset", p.handleResetActivations)
+	mux.HandleFunc("/unsubscribe", p.handleUnsubscribe)
+	mux.HandleFunc("/waitlist", p.handleWaitlist)
+	mux.HandleFunc("/track", p.handleTrack)
+	mux.HandleFunc("/licenses/verify", p.handleVerifyLicense)
+	mux.HandleFunc("/usage", p.handleUsage)
+	mux.HandleFunc("/discord/interactions", p.handleDiscordInteraction)
+	return mux
+}
+
+// Serve serves the portal on the listener until Close
+func (p *Portal) Serve(listener net.Listener) {
+	p.server = &http.Server{Handler: p.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	fmt.Printf("🌐 Customer portal on %s\n", listener.Addr())
+
+	go func() {
+		err := p.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ Portal stopped: %v", err)
+		}
+	}()
+}
+
+// Close waits up to timeout for in-flight requests, then stops the portal
+func (p *Portal) Close(timeout time.Duration) error {
+	if p.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func (p *Portal) handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, ok := p.session(r)
+	if !ok {
+		p.render(w, portalLoginPage, map[string]interface{}{"Sent": r.URL.Query().Get("sent") != ""})
+		return
+	}
+
+	var purchases []portalPurchase
+	for _, sale := range p.sales.ByEmail(session.email) {
+		purchases = append(purchases, newPortalPurchase(sale))
+	}
+	var gifts []portalGift
+	for _, sale := range p.sales.GiftsFrom(session.email) {
+		gifts = append(gifts, portalGift{ProductName: sale.ProductName, Recipient: sale.Email})
+	}
+	p.render(w, portalPurchasesPage, map[string]interface{}{
+		"Email":     session.email,
+		"CSRF":      session.csrf,
+		"Purchases": purchases,
+		"Gifts":     gifts,
+	})
+}
+
+// handleLogin emails a magic link when the address has purchases. The
+// response is the same either way so the form cannot be used to find buyers.
+func (p *Portal) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	email := NormalizeEmail(r.FormValue("email"))
+	// Gifters can log in to see the gifts they sent
+	sales := append(p.sales.ByEmail(email), p.sales.GiftsFrom(email)...)
+	if email != "" && len(sales) > 0 {
+		token := randomToken()
+		p.mu.Lock()
+		p.pruneLocked()
+		p.links[token] = portalGrant{email: email, expires: p.bridge.clock.Now().Add(magicLinkTTL)}
+		p.mu.Unlock()
+
+		err := p.sendMagicLink(email, token, sales[len(sales)-1])
+		if err != nil {
+			log.Printf("❌ Error sending portal link to %s: %v", email, err)
+		}
+	}
+	http.Redirect(w, r, "/?sent=1", http.StatusSeeOther)
+}
+
+// handleAuth exchanges a single-use magic link token for a session
+func (p *Portal) handleAuth(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	now := p.bridge.clock.Now()
+
+	p.mu.Lock()
+	grant, ok := p.links[token]
+	delete(p.links, token)
+	if ok && now.Before(grant.expires) {
+		sessionID := randomToken()
+		p.sessions[sessionID] = portalGrant{email: grant.email, csrf: randomToken(), expires: now.Add(portalSessionTTL)}
+		p.mu.Unlock()
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     portalCookie,
+			Value:    sessionID,
+			Path:     "/",
+			Expires:  now.Add(portalSessionTTL),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(p.BaseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	p.mu.Unlock()
+
+	http.Error(w, "This login link has expired. Request a new one from the portal.", http.StatusUnauthorized)
+}
+
+func (p *Portal) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(portalCookie); err == nil {
+		p.mu.Lock()
+		delete(p.sessions, cookie.Value)
+		p.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: portalCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleThanks is the page Gumroad redirects buyers to after checkout, with
+// the sale_id in the query. The sale must be in the sales store, so the page
+// waits for the ping, and it is only shown for thankYouTTL after checkout,
+// as anyone holding the link can see the license key.
+func (p *Portal) handleThanks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	id := r.URL.Query().Get("sale_id")
+	sale, ok := p.sales.Sale(id)
+	if !ok {
+		if id == "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		// Gumroad can redirect the buyer before its ping arrives
+		p.render(w, portalThanksPage, map[string]interface{}{"Pending": true})
+		return
+	}
+
+	age := p.bridge.clock.Now().Sub(sale.CreatedAt)
+	data := map[string]interface{}{
+		"ProductName": sale.ProductName,
+		"ProductURL":  "",
+	}
+	if link := stringArg(sale.Fields, "product_permalink"); strings.HasPrefix(link, "https://") {
+		data["ProductURL"] = link
+	}
+	if sale.Refunded || sale.CreatedAt.IsZero() || age < 0 || age > thankYouTTL {
+		data["Expired"] = true
+		p.render(w, portalThanksPage, data)
+		return
+	}
+
+	// A bundle's redirect names the bundle sale; its products are sales of their own
+	purchases := []portalPurchase{newPortalPurchase(sale)}
+	for _, component := range p.sales.Sales(func(s Sale) bool { return s.BundleSaleID == sale.ID }) {
+		purchases = append(purchases, newPortalPurchase(component))
+	}
+	data["Name"] = sale.FullName
+	data["Purchases"] = purchases
+	data["Steps"] = p.onboardingSteps(sale)
+	p.render(w, portalThanksPage, data)
+}
+
+// onboardingSteps renders the thank_you template for the sale's product,
+// one step per non-empty line
+func (p *Portal) onboardingSteps(sale Sale) []string {
+	text, err := p.templates.RenderLocale("thank_you", sale.Product, DetectLocale(sale.Fields), sale.Fields)
+	if err != nil {
+		log.Printf("❌ Error rendering onboarding steps for %s: %v", sale.ID, err)
+		return nil
+	}
+	var steps []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "-")); line != "" {
+			steps = append(steps, line)
+		}
+	}
+	return steps
+}
+
+// handleResetActivations asks the Gumroad side of the bridge to reset a
+// license's activation count, after checking the license is the buyer's
+func (p *Portal) handleResetActivations(w http.ResponseWriter, r *http.Request) {
+	session, ok := p.session(r)
+	if !ok || r.Method != http.MethodPost || !p.validCSRF(session, r.FormValue("csrf")) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	sale, ok := p.sales.Sale(r.FormValue("sale_id"))
+	licenseKey := stringArg(sale.Fields, "license_key")
+	if !ok || sale.Email != session.email || licenseKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, err := p.bridge.CallFunction("python", "reset_license_activations", nil, map[string]interface{}{
+		"product_id":  stringArg(sale.Fields, "product_id"),
+		"license_key": licenseKey,
+		"sale_id":     sale.ID,
+	})
+	if err != nil {
+		log.Printf("❌ Error requesting activation reset for %s: %v", sale.ID, err)
+		http.Error(w, "Could not reset activations right now, please try again later.", http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleUnsubscribe opts an address out of broadcasts. The link carries a
+// signed token, so no login is needed and links cannot be forged.
+func (p *Portal) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+	if p.OptOuts == nil || email == "" || !p.OptOuts.ValidToken(email, r.FormValue("token")) {
+		http.Error(w, "This unsubscribe link is not valid.", http.StatusBadRequest)
+		return
+	}
+
+	err := p.OptOuts.Add(email, "unsubscribed")
+	if err != nil {
+		log.Printf("❌ Error saving opt-out for %s: %v", email, err)
+		http.Error(w, "Could not unsubscribe right now, please try again later.", http.StatusInternalServerError)
+		return
+	}
+	p.render(w, portalUnsubscribedPage, map[string]interface{}{"Email": NormalizeEmail(email)})
+}
+
+// handleWaitlist shows the signup form for ?product= and adds posted
+// signups. It needs no login, so landing pages can post to it directly.
+func (p *Portal) handleWaitlist(w http.ResponseWriter, r *http.Request) {
+	product := r.FormValue("product")
+	if p.Waitlists == nil || product == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		p.render(w, portalWaitlistPage, map[string]interface{}{"Product": product})
+		return
+	}
+
+	locale := DetectLocale(map[string]interface{}{"language": r.Header.Get("Accept-Language")})
+	err := p.Waitlists.Join(product, r.FormValue("email"), locale)
+	if err != nil {
+		http.Error(w, "Please enter a valid email address.", http.StatusBadRequest)
+		return
+	}
+	p.render(w, portalWaitlistPage, map[string]interface{}{"Product": product, "Joined": true})
+}
+
+// handleVerifyLicense answers a sold app's license check
+func (p *Portal) handleVerifyLicense(w http.ResponseWriter, r *http.Request) {
+	if p.Licenses == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.Licenses.Handler().ServeHTTP(w, r)
+}
+
+// handleUsage passes a sold app's usage ping to the telemetry
+func (p *Portal) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if p.Usage == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.Usage.PingHandler().ServeHTTP(w, r)
+}
+
+// handleDiscordInteraction passes a Discord interaction to the role manager
+func (p *Portal) handleDiscordInteraction(w http.ResponseWriter, r *http.Request) {
+	if p.Discord == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p.Discord.Handler().ServeHTTP(w, r)
+}
+
+// handleTrack records a product view from a landing page or checkout with
+// email, product and source. Products outside the catalog are refused; the
+// name and link follow-ups use come from the catalog. GET answers with a
+// transparent pixel so it can be an <img>; POST answers 204 for sendBeacon
+// and fetch.
+func (p *Portal) handleTrack(w http.ResponseWriter, r *http.Request) {
+	if p.Checkouts == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	err := p.Checkouts.Track(CheckoutView{
+		Email:   r.FormValue("email"),
+		Product: r.FormValue("product"),
+		Source:  r.FormValue("source"),
+	})
+	switch {
+	case errors.Is(err, ErrTooManyVisits), errors.Is(err, ErrNoCheckoutCatalog):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(trackingPixel)
+}
+
+// sendMagicLink renders the portal_login template in the buyer's locale and
+// queues it on the email sink. The link is never logged, since anyone
+// holding it can log in.
+func (p *Portal) sendMagicLink(email, token string, latestSale Sale) error {
+	link := p.BaseURL + "/auth?token=" + url.QueryEscape(token)
+	data := map[string]interface{}{
+		"email":           email,
+		"link":            link,
+		"expires_minutes": int(magicLinkTTL / time.Minute),
+	}
+	locale := DetectLocale(latestSale.Fields)
+
+	text, err := p.templates.RenderLocale("portal_login", "", locale, data)
+	if err != nil {
+		return err
+	}
+
+	sink := p.bridge.Sink("email")
+	if sink == nil {
+		return fmt.Errorf("no email sink; start the bridge with -smtp")
+	}
+	message := p.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+		"to":     email,
+		"text":   text,
+		"locale": locale,
+	}, FileSystem)
+	return sink.Enqueue(message)
+}
+
+// pruneLocked forgets expired magic links and sessions
+func (p *Portal) pruneLocked() {
+	now := p.bridge.clock.Now()
+	for token, grant := range p.links {
+		if !now.Before(grant.expires) {
+			delete(p.links, token)
+		}
+	}
+	for id, session := range p.sessions {
+		if !now.Before(session.expires) {
+			delete(p.sessions, id)
+		}
+	}
+}
+
+// session returns the logged-in buyer for the request, if any
+func (p *Portal) session(r *http.Request) (portalGrant, bool) {
+	cookie, err := r.Cookie(portalCookie)
+	if err != nil {
+		return portalGrant{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session, ok := p.sessions[cookie.Value]
+	if !ok {
+		return portalGrant{}, false
+	}
+	if !p.bridge.clock.Now().Before(session.expires) {
+		delete(p.sessions, cookie.Value)
+		return portalGrant{}, false
+	}
+	return session, true
+}
+
+func (p *Portal) validCSRF(session portalGrant, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(session.csrf), []byte(token)) == 1
+}
+
+func (p *Portal) render(w http.ResponseWriter, page *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := page.Execute(w, data)
+	if err != nil {
+		log.Printf("❌ Error rendering portal page: %v", err)
+	}
+}
+
+// portalPurchase is one sale as shown in the portal
+type portalPurchase struct {
+	SaleID       string
+	ProductName  string
+	Price        string // e.g. "29.99 USD"; empty for free products
+	DownloadURL  string
+	LicenseKey   string
+	Activations  string
+	Subscription string // empty for one-time purchases
+}
+
+// portalGift is a gift the logged-in buyer sent, shown without its license
+type portalGift struct {
+	ProductName string
+	Recipient   string
+}
+
+func newPortalPurchase(sale Sale) portalPurchase {
+	fields := sale.Fields
+	purchase := portalPurchase{
+		SaleID:      sale.ID,
+		ProductName: sale.ProductName,
+		LicenseKey:  stringArg(fields, "license_key"),
+		Activations: stringArg(fields, "license_uses"),
+	}
+	if !sale.Price.IsZero() {
+		purchase.Price = sale.Price.String()
+	}
+	for _, field := range []string{"download_url", "receipt_url", "product_permalink"} {
+		if link := stringArg(fields, field); strings.HasPrefix(link, "https://") {
+			purchase.DownloadURL = link
+			break
+		}
+	}
+
+	if sale.SubscriptionID != "" {
+		switch {
+		case flagArg(fields, "ended"):
+			purchase.Subscription = "Ended"
+		case flagArg(fields, "cancelled"):
+			purchase.Subscription = "Cancelled, access until the end of the billing period"
+		default:
+			purchase.Subscription = "Active"
+			if recurrence := stringArg(fields, "recurrence"); recurrence != "" {
+				purchase.Subscription += " (" + recurrence + ")"
+			}
+		}
+	}
+	return purchase
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var portalLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Your purchases</title></head>
+<body>
+<h1>Your purchases</h1>
+{{if .Sent}}<p>If that email has purchases, a login link is on its way. It works once and expires in 15 minutes.</p>{{end}}
+<form method="post" action="/login">
+  <label>Purchase email <input type="email" name="email" required></label>
+  <button type="submit">Email me a login link</button>
+</form>
+</body></html>
+`))
+
+var portalPurchasesPage = template.Must(template.New("purchases").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Your purchases</title></head>
+<body>
+<h1>Your purchases</h1>
+<p>Logged in as {{.Email}}. <form method="post" action="/logout" style="display:inline"><button type="submit">Log out</button></form></p>
+{{range .Purchases}}
+<section>
+  <h2>{{.ProductName}}</h2>
+  {{if .Price}}<p>Paid {{.Price}}</p>{{end}}
+  {{if .DownloadURL}}<p><a href="{{.DownloadURL}}">Download</a></p>{{end}}
+  {{if .LicenseKey}}
+  <p>License key: <code>{{.LicenseKey}}</code>{{if .Activations}} ({{.Activations}} activations){{end}}</p>
+  <form method="post" action="/activations/reset">
+    <input type="hidden" name="csrf" value="{{$.CSRF}}">
+    <input type="hidden" name="sale_id" value="{{.SaleID}}">
+    <button type="submit">Reset activations</button>
+  </form>
+  {{end}}
+  {{if .Subscription}}<p>Subscription: {{.Subscription}}</p>{{end}}
+</section>
+{{else}}
+<p>No purchases found for this email.</p>
+{{end}}
+{{if .Gifts}}
+<h2>Gifts you sent</h2>
+<ul>
+{{range .Gifts}}  <li>{{.ProductName}} for {{.Recipient}}</li>
+{{end}}</ul>
+{{end}}
+</body></html>
+`))
+
+// portalThanksPage's Open Graph tags only name the product, so a shared
+// link never previews the buyer's details
+var portalThanksPage = template.Must(template.New("thanks").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8">
+{{if .Pending}}<meta http-equiv="refresh" content="5">{{end}}
+<title>{{if .ProductName}}Thanks for buying {{.ProductName}}{{else}}Thank you{{end}}</title>
+{{if .ProductName}}<meta property="og:type" content="website">
+<meta property="og:title" content="I just got {{.ProductName}}">
+<meta property="og:description" content="{{.ProductName}} is available on Gumroad.">
+{{if .ProductURL}}<meta property="og:url" content="{{.ProductURL}}">{{end}}{{end}}
+</head>
+<body>
+{{if .Pending}}
+<h1>Thank you!</h1>
+<p>We're confirming your purchase. This page refreshes by itself in a few seconds.</p>
+{{else if .Expired}}
+<h1>Thank you for your purchase</h1>
+<p>This link has expired. Your downloads and license keys are always in <a href="/">your purchases</a>, where you can log in with your purchase email.</p>
+{{else}}
+<h1>Thanks{{if .Name}}, {{.Name}}{{end}}!</h1>
+{{range .Purchases}}
+<section>
+  <h2>{{.ProductName}}</h2>
+  {{if .DownloadURL}}<p><a href="{{.DownloadURL}}">Download</a></p>{{end}}
+  {{if .LicenseKey}}<p>License key: <code>{{.LicenseKey}}</code></p>{{end}}
+</section>
+{{end}}
+{{if .Steps}}
+<h2>Getting started</h2>
+<ol>
+{{range .Steps}}  <li>{{.}}</li>
+{{end}}</ol>
+{{end}}
+<p>You can come back to your purchases any time from <a href="/">the portal</a>.</p>
+{{end}}
+</body></html>
+`))
+
+var portalWaitlistPage = template.Must(template.New("waitlist").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Join the waitlist</title></head>
+<body>
+<h1>Join the waitlist</h1>
+{{if .Joined}}<p>You're on the list! We'll email you a launch discount the moment it's available.</p>
+{{else}}<form method="post" action="/waitlist">
+  <input type="hidden" name="product" value="{{.Product}}">
+  <label>Email <input type="email" name="email" required></label>
+  <button type="submit">Notify me at launch</button>
+</form>
+{{end}}
+</body></html>
+`))
+
+var portalUnsubscribedPage = template.Must(template.New("unsubscribed").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Unsubscribed</title></head>
+<body>
+<h1>You're unsubscribed</h1>
+<p>{{.Email}} will no longer receive product update emails. Receipts and login links still arrive as usual.</p>
+</body></html>
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPortalThanksPage(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []map[string]interface{}{
+		{"sale_id": "s1", "product_id": "ebook", "product_name": "The Automation Handbook", "product_permalink": "https://seller.gumroad.com/l/handbook",
+			"email": "ada@example.com", "full_name": "Ada", "license_key": "ABCD-1234", "sale_timestamp": "2026-01-15T09:25:00Z", "price": 29},
+		{"sale_id": "old", "product_id": "ebook", "product_name": "The Automation Handbook", "email": "bo@example.com",
+			"license_key": "OLD-KEY", "sale_timestamp": "2026-01-10T09:25:00Z", "price": 29},
+		{"sale_id": "refunded", "product_id": "ebook", "product_name": "The Automation Handbook", "email": "cy@example.com",
+			"license_key": "REFUNDED-KEY", "sale_timestamp": "2026-01-15T09:25:00Z", "refunded": true, "price": 29},
+		{"sale_id": "bundle", "product_id": "bundle", "product_name": "Starter Bundle", "email": "di@example.com", "sale_timestamp": "2026-01-15T09:25:00Z", "price": 49},
+		{"sale_id": "part", "product_id": "kit", "product_name": "Figma UI Kit", "bundle_sale_id": "bundle", "email": "di@example.com",
+			"license_key": "KIT-KEY", "sale_timestamp": "2026-01-15T09:25:00Z", "price": 0},
+	} {
+		if _, err := sales.Record(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	portal := NewPortal(gb, sales, NewTemplateStore("templates"), "https://portal.example.com")
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		want     []string
+		dontWant []string
+	}{
+		{"fresh purchase", "?sale_id=s1", http.StatusOK,
+			[]string{"Thanks, Ada!", "ABCD-1234", "Activate it with your license key", `og:url" content="https://seller.gumroad.com/l/handbook"`},
+			[]string{"ada@example.com", "refresh"}},
+		{"ping not in yet", "?sale_id=s9", http.StatusOK, []string{`http-equiv="refresh"`, "confirming your purchase"}, []string{"og:title"}},
+		{"link expired", "?sale_id=old", http.StatusOK, []string{"This link has expired", `og:title" content="I just got The Automation Handbook"`}, []string{"OLD-KEY"}},
+		{"refunded", "?sale_id=refunded", http.StatusOK, []string{"This link has expired"}, []string{"REFUNDED-KEY"}},
+		{"bundle", "?sale_id=bundle", http.StatusOK, []string{"Starter Bundle", "Figma UI Kit", "KIT-KEY"}, nil},
+		{"no sale", "", http.StatusSeeOther, nil, nil},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		portal.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/thanks"+tt.query, nil))
+		if recorder.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d", tt.name, recorder.Code, tt.wantCode)
+			continue
+		}
+		if tt.wantCode == http.StatusOK && recorder.Header().Get("Cache-Control") != "private, no-store" {
+			t.Errorf("%s: Cache-Control %q", tt.name, recorder.Header().Get("Cache-Control"))
+		}
+		body := recorder.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: page is missing %q:\n%s", tt.name, want, body)
+			}
+		}
+		for _, dontWant := range tt.dontWant {
+			if strings.Contains(body, dontWant) {
+				t.Errorf("%s: page shows %q:\n%s", tt.name, dontWant, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxWatchedPage is the most of a page read
+	maxWatchedPage = 2 << 20
+	// maxPageDescription is how many characters of a description are kept
+	// and compared
+	maxPageDescription = 2000
+)
+
+// Patterns for the product markup public pages carry: JSON-LD, meta tags
+// and the title
+var (
+	pageLDScript = regexp.MustCompile(`(?is)<script[^>]*type=["']application/ld\+json["'][^>]*>(.*?)</script>`)
+	pageMetaTag  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	pageAttr     = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	pageTitle    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// WatchedPage is a public product page, a competitor's or the seller's own
+type WatchedPage struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Own marks the seller's own page, so a change can be told from a
+	// competitor's
+	Own bool `yaml:"own"`
+	// Currency is used when the page does not say
+	Currency string `yaml:"currency"`
+	// PricePattern is a regular expression whose first group is the price,
+	// for pages without product markup
+	PricePattern string `yaml:"price_pattern"`
+
+	pricePattern *regexp.Regexp
+}
+
+// PriceWatchConfig lists the pages watched, loaded from YAML:
+//
+//	schedule: "0 */6 * * *"   # the default
+//	pages:
+//	  - name: rival-course
+//	    url: https://rival.gumroad.com/l/course
+//	  - name: my-course
+//	    url: https://me.gumroad.com/l/course
+//	    own: true
+//	  - name: rival-site
+//	    url: https://rival.example.com/pricing
+//	    currency: usd
+//	    price_pattern: 'Only \$([0-9.]+)'
+type PriceWatchConfig struct {
+	Schedule string        `yaml:"schedule"`
+	Pages    []WatchedPage `yaml:"pages"`
+}
+
+// LoadPriceWatchConfig reads the watched pages
+func LoadPriceWatchConfig(path string) (PriceWatchConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PriceWatchConfig{}, fmt.Errorf("failed to read price watch config: %v", err)
+	}
+
+	var config PriceWatchConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return PriceWatchConfig{}, fmt.Errorf("failed to parse price watch config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (PriceWatchConfig, error) {
+		return PriceWatchConfig{}, fmt.Errorf("invalid price watch config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	if config.Schedule == "" {
+		config.Schedule = "0 */6 * * *"
+	}
+	if _, err := ParseCron(config.Schedule); err != nil {
+		return invalid("schedule: %v", err)
+	}
+	if len(config.Pages) == 0 {
+		return invalid("no pages")
+	}
+	names := make(map[string]bool)
+	for i := range config.Pages {
+		page := &config.Pages[i]
+		if page.Name == "" {
+			return invalid("page %d has no name", i+1)
+		}
+		if names[page.Name] {
+			return invalid("two pages are named %s", page.Name)
+		}
+		names[page.Name] = true
+		if parsed, err := url.Parse(page.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return invalid("%s: url %q is not a URL", page.Name, page.URL)
+		}
+		if page.Currency != "" {
+			page.Currency, err = parseCurrency(page.Currency)
+			if err != nil {
+				return invalid("%s: %v", page.Name, err)
+			}
+		}
+		if page.PricePattern != "" {
+			page.pricePattern, err = regexp.Compile(page.PricePattern)
+			if err == nil && page.pricePattern.NumSubexp() < 1 {
+				err = fmt.Errorf("it has no group for the price")
+			}
+			if err != nil {
+				return invalid("%s: price_pattern: %v", page.Name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// PageState is what a watched page last showed
+type PageState struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Own         bool      `json:"own"`
+	Title       string    `json:"title,omitempty"`
+	Price       *Money    `json:"price,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+	ChangedAt   time.Time `json:"changed_at"`
+	Error       string    `json:"error,omitempty"` // why the last check failed
+}
+
+// PriceWatch fetches public product pages and reports each change of price
+// or description as a data_sync message with resource_name price_watch,
+// delivered into the bridge like a received one: rules, webhooks and
+// peers see it, and the push and chat notifiers post it to targets that
+// opted in to the price event. A page's first check only records it. The
+// last state of each page is persisted as JSON at path.
+type PriceWatch struct {
+	Config     PriceWatchConfig
+	HTTPClient *http.Client
+
+	bridge *GoBridge
+	path   string
+
+	mu    sync.Mutex
+	pages map[string]*PageState
+}
+
+// NewPriceWatch opens the page states persisted at path
+func NewPriceWatch(gb *GoBridge, config PriceWatchConfig, path string) (*PriceWatch, error) {
+	pw := &PriceWatch{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		bridge:     gb,
+		path:       path,
+		pages:      make(map[string]*PageState),
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read price watch: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &pw.pages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse price watch %s: %v", path, err)
+		}
+	}
+	return pw, nil
+}
+
+// Check fetches every page and reports the ones that changed, returning
+// how many did. A page that cannot be fetched or read is skipped and
+// checked again next time; the first such error is returned.
+func (pw *PriceWatch) Check() (int, error) {
+	changed := 0
+	var firstErr error
+	for _, page := range pw.Config.Pages {
+		now := pw.bridge.clock.Now().UTC()
+		current, err := pw.fetch(page)
+		if err != nil {
+			err = fmt.Errorf("price watch %s: %v", page.Name, err)
+			log.Printf("❌ %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			pw.mu.Lock()
+			if state := pw.pages[page.Name]; state != nil {
+				state.CheckedAt, state.Error = now, err.Error()
+			}
+			pw.mu.Unlock()
+			continue
+		}
+		current.Name, current.URL, current.Own = page.Name, page.URL, page.Own
+		current.CheckedAt, current.ChangedAt = now, now
+
+		pw.mu.Lock()
+		previous := pw.pages[page.Name]
+		var changes []string
+		if previous != nil && previous.URL == page.URL {
+			current.ChangedAt = previous.ChangedAt
+			if !samePrice(previous.Price, current.Price) {
+				changes = append(changes, "price")
+			}
+			if previous.Description != current.Description {
+				changes = append(changes, "description")
+			}
+			if len(changes) > 0 {
+				current.ChangedAt = now
+			}
+		}
+		pw.pages[page.Name] = &current
+		pw.mu.Unlock()
+
+		if len(changes) == 0 {
+			continue
+		}
+		changed++
+		err = pw.bridge.Deliver(pw.changeMessage(previous, &current, changes), HTTP)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("price watch %s: failed to report the change: %v", page.Name, err)
+		}
+	}
+
+	pw.mu.Lock()
+	err := writeJSONFile(pw.path, pw.pages)
+	pw.mu.Unlock()
+	if err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to save price watch: %v", err)
+	}
+	if changed > 0 {
+		fmt.Printf("🏷️ %d watched pages changed\n", changed)
+	}
+	return changed, firstErr
+}
+
+// samePrice reports whether two prices, either of which may be unknown,
+// are the same
+func samePrice(a, b *Money) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// changeMessage builds the price_watch message for a page's changes
+func (pw *PriceWatch) changeMessage(previous, current *PageState, changes []string) *UniversalMessage {
+	decimal := func(price *Money) interface{} {
+		if price == nil {
+			return nil
+		}
+		return price.Decimal()
+	}
+	changed := make([]interface{}, len(changes))
+	for i, change := range changes {
+		changed[i] = change
+	}
+	payload := map[string]interface{}{
+		"resource_name":   "price_watch",
+		"page":            current.Name,
+		"url":             current.URL,
+		"own":             current.Own,
+		"title":           current.Title,
+		"changes":         changed,
+		"old_price":       decimal(previous.Price),
+		"new_price":       decimal(current.Price),
+		"old_description": previous.Description,
+		"new_description": current.Description,
+		"checked_at":      current.CheckedAt.Format(time.RFC3339),
+		"text":            priceWatchText(previous, current, changes),
+	}
+	if current.Price != nil {
+		payload["currency"] = current.Price.Currency
+	}
+	return newUniversalMessage(pw.bridge.clock, pw.bridge.ids, DataSync, "pricewatch", "go", payload, HTTP)
+}
+
+// priceWatchText describes a page's changes, e.g.
+//
+//	🏷️ rival-course price: 49.00 USD → 39.00 USD
+//	📝 rival-course description changed
+func priceWatchText(previous, current *PageState, changes []string) string {
+	name := current.Name
+	if current.Own {
+		name += " (yours)"
+	}
+	var lines []string
+	for _, change := range changes {
+		if change == "price" {
+			shown := func(price *Money) string {
+				if price == nil {
+					return "no price"
+				}
+				return price.String()
+			}
+			lines = append(lines, fmt.Sprintf("🏷️ %s price: %s → %s", name, shown(previous.Price), shown(current.Price)))
+		} else {
+			lines = append(lines, fmt.Sprintf("📝 %s description changed", name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fetch reads a page's title, price and description
+func (pw *PriceWatch) fetch(page WatchedPage) (PageState, error) {
+	request, err := http.NewRequest(http.MethodGet, page.URL, nil)
+	if err != nil {
+		return PageState{}, err
+	}
+	request.Header.Set("User-Agent", "universal-bridge-price-watch")
+	request.Header.Set("Accept", "text/html")
+	response, err := pw.HTTPClient.Do(request)
+	if err != nil {
+		return PageState{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return PageState{}, fmt.Errorf("%s answered %s", page.URL, response.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxWatchedPage))
+	if err != nil {
+		return PageState{}, err
+	}
+	return parseProductPage(string(content), page)
+}
+
+// parseProductPage reads a product page's JSON-LD Product, its
+// product:price / og: meta tags and its title, preferring the page's
+// price_pattern when it has one. A page with no price is an error, as a
+// change in its markup would otherwise read as the price disappearing.
+func parseProductPage(content string, page WatchedPage) (PageState, error) {
+	meta := make(map[string]string)
+	for _, tag := range pageMetaTag.FindAllString(content, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range pageAttr.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = html.UnescapeString(attr[2] + attr[3])
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		if key != "" && meta[strings.ToLower(key)] == "" {
+			meta[strings.ToLower(key)] = strings.TrimSpace(attrs["content"])
+		}
+	}
+	first := func(values ...string) string {
+		for _, value := range values {
+			if value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	var product map[string]interface{}
+	for _, script := range pageLDScript.FindAllStringSubmatch(content, -1) {
+		var data interface{}
+		if json.Unmarshal([]byte(strings.TrimSpace(script[1])), &data) == nil {
+			if product = findLDProduct(data); product != nil {
+				break
+			}
+		}
+	}
+	offer := map[string]interface{}{}
+	switch offers := product["offers"].(type) {
+	case map[string]interface{}:
+		offer = offers
+	case []interface{}:
+		if len(offers) > 0 {
+			offer, _ = offers[0].(map[string]interface{})
+		}
+	}
+	ldString := func(fields map[string]interface{}, key string) string {
+		switch value := fields[key].(type) {
+		case string:
+			return strings.TrimSpace(value)
+		case float64:
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+
+	var title string
+	if match := pageTitle.FindStringSubmatch(content); match != nil {
+		title = strings.TrimSpace(html.UnescapeString(match[1]))
+	}
+	state := PageState{
+		Title:       first(ldString(product, "name"), meta["og:title"], title),
+		Description: pageDescription(first(ldString(product, "description"), meta["og:description"], meta["description"])),
+	}
+
+	amount := first(ldString(offer, "price"), meta["product:price:amount"], meta["og:price:amount"])
+	if page.pricePattern != nil {
+		match := page.pricePattern.FindStringSubmatch(html.UnescapeString(content))
+		if match == nil {
+			return PageState{}, fmt.Errorf("price_pattern matches nothing on the page")
+		}
+		amount = match[1]
+	}
+	if amount == "" {
+		return PageState{}, fmt.Errorf("no price on the page; give it a price_pattern")
+	}
+	currency := first(ldString(offer, "priceCurrency"), meta["product:price:currency"], meta["og:price:currency"], page.Currency)
+	if currency == "" {
+		return PageState{}, fmt.Errorf("the page has no currency; give it one")
+	}
+	price, err := ParseMoney(pageAmount(amount), currency)
+	if err != nil {
+		return PageState{}, fmt.Errorf("bad price %q: %v", amount, err)
+	}
+	state.Price = &price
+	return state, nil
+}
+
+// findLDProduct finds the first JSON-LD object of @type Product, looking
+// through lists and @graph
+func findLDProduct(data interface{}) map[string]interface{} {
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if product := findLDProduct(item); product != nil {
+				return product
+			}
+		}
+	case map[string]interface{}:
+		if kind, _ := value["@type"].(string); kind == "Product" {
+			return value
+		}
+		return findLDProduct(value["@graph"])
+	}
+	return nil
+}
+
+// decimalComma is a displayed price such as "12,50"
+var decimalComma = regexp.MustCompile(`^[0-9]+,[0-9]{2}$`)
+
+// pageAmount strips currency symbols and thousands separators from a
+// displayed price, reading "12,50" as a decimal comma
+func pageAmount(amount string) string {
+	amount = strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == ',' {
+			return r
+		}
+		return -1
+	}, amount)
+	if decimalComma.MatchString(amount) {
+		return strings.Replace(amount, ",", ".", 1)
+	}
+	return strings.ReplaceAll(amount, ",", "")
+}
+
+// pageDescription collapses whitespace and keeps the first
+// maxPageDescription characters, so reflowed markup is not a change
+func pageDescription(description string) string {
+	description = strings.Join(strings.Fields(html.UnescapeString(description)), " ")
+	if runes := []rune(description); len(runes) > maxPageDescription {
+		description = string(runes[:maxPageDescription])
+	}
+	return description
+}
+
+// Pages returns the last state of every watched page, by name
+func (pw *PriceWatch) Pages() []PageState {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pages := make([]PageState, 0, len(pw.pages))
+	for _, page := range pw.pages {
+		pages = append(pages, *page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages
+}
+
+// Handler serves GET /metrics/price-watch, each watched page's last state
+func (pw *PriceWatch) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"pages": pw.Pages()})
+	})
+}
+
+// RegisterPriceWatchJobs adds the price_watch job handler, which checks
+// every watched page
+func RegisterPriceWatchJobs(s *Scheduler, watch *PriceWatch) {
+	s.Handle("price_watch", func(job Job) error {
+		_, err := watch.Check()
+		return err
+	})
+}
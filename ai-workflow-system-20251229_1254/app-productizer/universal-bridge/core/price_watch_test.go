@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadPriceWatchConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"defaults", "pages:\n  - name: rival\n    url: https://rival.gumroad.com/l/course\n", ""},
+		{"no pages", "pages: []\n", "no pages"},
+		{"no name", "pages:\n  - url: https://rival.gumroad.com/l/course\n", "page 1 has no name"},
+		{"same name", "pages:\n  - name: a\n    url: https://a.example.com\n  - name: a\n    url: https://b.example.com\n", "two pages are named a"},
+		{"not a URL", "pages:\n  - name: a\n    url: rival.gumroad.com\n", "is not a URL"},
+		{"bad schedule", "schedule: hourly\npages:\n  - name: a\n    url: https://a.example.com\n", "schedule:"},
+		{"pattern without a group", "pages:\n  - name: a\n    url: https://a.example.com\n    price_pattern: 'Only \\$[0-9]+'\n", "no group for the price"},
+		{"bad currency", "pages:\n  - name: a\n    url: https://a.example.com\n    currency: dollars\n", "a:"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "pricewatch.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadPriceWatchConfig(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if config.Schedule != "0 */6 * * *" {
+			t.Errorf("%s: schedule %q, want the default", tt.name, config.Schedule)
+		}
+	}
+}
+
+func TestParseProductPage(t *testing.T) {
+	pattern := WatchedPage{Name: "site", Currency: "EUR", pricePattern: regexp.MustCompile(`Nur ([0-9,]+) €`)}
+	tests := []struct {
+		name            string
+		page            WatchedPage
+		html            string
+		wantPrice       string
+		wantTitle       string
+		wantDescription string
+		wantErr         string
+	}{
+		{"json-ld", WatchedPage{Name: "ld"}, `<title>Ignored</title><script type="application/ld+json">{"@type": "Product", "name": "Course Pro", "description": "Learn\n  Go &amp; more", "offers": {"price": "39.00", "priceCurrency": "USD"}}</script>`,
+			"39.00 USD", "Course Pro", "Learn Go & more", ""},
+		{"json-ld graph", WatchedPage{Name: "graph"}, `<script type='application/ld+json'>{"@graph": [{"@type": "WebPage"}, {"@type": "Product", "offers": [{"price": 1299, "priceCurrency": "jpy"}]}]}</script>`,
+			"1299 JPY", "", "", ""},
+		{"meta tags", WatchedPage{Name: "meta"}, `<head><meta property="og:title" content="The Ebook"><meta name="description" content="A &quot;great&quot; read"><meta property="product:price:amount" content="$1,049.50"><meta property="product:price:currency" content="usd"></head>`,
+			"1049.50 USD", "The Ebook", `A "great" read`, ""},
+		{"price pattern", pattern, `<title>Kurs</title><p>Nur 12,50 € heute</p>`, "12.50 EUR", "Kurs", "", ""},
+		{"no price", WatchedPage{Name: "plain"}, `<title>Course</title>`, "", "", "", "no price on the page"},
+		{"no currency", WatchedPage{Name: "plain"}, `<meta property="og:price:amount" content="10">`, "", "", "", "has no currency"},
+	}
+	for _, tt := range tests {
+		state, err := parseProductPage(tt.html, tt.page)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if state.Price.String() != tt.wantPrice || state.Title != tt.wantTitle || state.Description != tt.wantDescription {
+			t.Errorf("%s: got %s %q %q, want %s %q %q", tt.name, state.Price, state.Title, state.Description, tt.wantPrice, tt.wantTitle, tt.wantDescription)
+		}
+	}
+}
+
+func TestPriceWatch(t *testing.T) {
+	var mu sync.Mutex
+	price, description, status := "49.00", "Learn Go", http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/l/course" {
+			// The chat webhook
+			return
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `<meta property="og:description" content="%s"><meta property="product:price:amount" content="%s"><meta property="product:price:currency" content="USD">`, description, price)
+	}))
+	// Closed after the bridge, which waits for the posts to go out
+	t.Cleanup(server.Close)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var events []map[string]interface{}
+	gb.OnReceive(func(message *UniversalMessage) {
+		if stringArg(message.Payload, "resource_name") == "price_watch" {
+			events = append(events, message.Payload)
+		}
+	})
+	sales, _ := NewSalesStore("")
+	chat := NewChatNotifier(gb, ChatConfig{Targets: []ChatTarget{
+		{Name: "rivals", Slack: server.URL + "/slack", Products: []string{"rival"}, Events: []string{ChatPrice}, PerMinute: 20},
+		{Name: "sales", Slack: server.URL + "/slack", Events: []string{ChatSale}, PerMinute: 20},
+	}}, sales, time.UTC)
+	var posts []string
+	chat.sink.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		posts = append(posts, stringArg(message.Payload, "target")+": "+stringArg(message.Payload, "text"))
+		return message
+	})
+
+	path := filepath.Join(t.TempDir(), "pages.json")
+	config := PriceWatchConfig{Pages: []WatchedPage{{Name: "rival", URL: server.URL + "/l/course"}}}
+	watch, err := NewPriceWatch(gb, config, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		price       string
+		description string
+		status      int
+		wantChanges string
+		wantErr     bool
+	}{
+		{"first check", "49.00", "Learn Go", http.StatusOK, "", false},
+		{"unchanged", "49.00", "Learn   Go", http.StatusOK, "", false},
+		{"price cut", "39.00", "Learn Go", http.StatusOK, "price", false},
+		{"page down", "29.00", "Learn Go", http.StatusBadGateway, "", true},
+		{"both", "29.00", "Learn Go fast", http.StatusOK, "price,description", false},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		price, description, status = tt.price, tt.description, tt.status
+		mu.Unlock()
+		events = nil
+		changed, err := watch.Check()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want an error %v", tt.name, err, tt.wantErr)
+		}
+		var got []string
+		for _, event := range events {
+			got = append(got, strings.Trim(fmt.Sprint(event["changes"]), "[]"))
+		}
+		if strings.Join(got, "|") != strings.Replace(tt.wantChanges, ",", " ", -1) || changed != len(events) {
+			t.Errorf("%s: reported %v (%d changed), want %q", tt.name, got, changed, tt.wantChanges)
+		}
+	}
+
+	want := []string{
+		"rivals: 🏷️ rival price: 49.00 USD → 39.00 USD",
+		"rivals: 🏷️ rival price: 39.00 USD → 29.00 USD\n📝 rival description changed",
+	}
+	if strings.Join(posts, "|") != strings.Join(want, "|") {
+		t.Errorf("posted %q, want %q", posts, want)
+	}
+
+	// The last state survives a restart, so the next check compares with it
+	reopened, err := NewPriceWatch(gb, config, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := reopened.Pages()
+	if len(pages) != 1 || pages[0].Price.String() != "29.00 USD" || pages[0].Description != "Learn Go fast" || pages[0].Error != "" {
+		t.Errorf("reopened %+v, want the last state", pages)
+	}
+	if changed, err := reopened.Check(); changed != 0 || err != nil {
+		t.Errorf("check after a restart: %d changed, %v", changed, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math/big"
+	"sort"
+)
+
+const (
+	// minSalesForSuggestion is how many paid sales a product needs before
+	// the analytics suggest a minimum price
+	minSalesForSuggestion = 10
+	priceBuckets          = 10
+)
+
+// PriceStats is the distribution of prices buyers chose for one
+// pay-what-you-want product in one currency. Interpolated figures are
+// rounded half away from zero to the minor unit.
+type PriceStats struct {
+	Product     string `json:"product"`
+	ProductName string `json:"product_name"`
+	Currency    string `json:"currency"`
+	Sales       int    `json:"sales"`
+	Free        int    `json:"free"` // sales at a price of zero
+	Revenue     Money  `json:"revenue"`
+	Min         Money  `json:"min"`
+	Max         Money  `json:"max"`
+	Mean        Money  `json:"mean"`
+	P10         Money  `json:"p10"`
+	P25         Money  `json:"p25"`
+	Median      Money  `json:"median"`
+	P75         Money  `json:"p75"`
+	P90         Money  `json:"p90"`
+	// SuggestedMinimum is the 25th percentile of paid prices, rounded down
+	// to a whole unit: a floor three quarters of paying buyers already
+	// clear. It is omitted until there are enough paid sales to trust it.
+	SuggestedMinimum *Money        `json:"suggested_minimum,omitempty"`
+	Distribution     []PriceBucket `json:"distribution"`
+}
+
+// PriceBucket counts the sales whose price fell in [From, To)
+type PriceBucket struct {
+	From  Money `json:"from"`
+	To    Money `json:"to"`
+	Sales int   `json:"sales"`
+}
+
+// PricingAnalytics computes price distributions for pay-what-you-want
+// products from the sales store. A product counts as pay-what-you-want when
+// it is listed in Products or any of its sales has pay_what_you_want set.
+type PricingAnalytics struct {
+	// Products are product IDs or permalinks always treated as pay-what-you-want
+	Products map[string]bool
+
+	sales *SalesStore
+}
+
+// NewPricingAnalytics creates pricing analytics over the sales store
+func NewPricingAnalytics(sales *SalesStore) *PricingAnalytics {
+	return &PricingAnalytics{Products: make(map[string]bool), sales: sales}
+}
+
+// All returns stats for every pay-what-you-want product, by product then currency
+func (pa *PricingAnalytics) All() []PriceStats {
+	return pa.compute(func(string) bool { return true })
+}
+
+// Product returns one product's stats, one entry per currency it sold in
+func (pa *PricingAnalytics) Product(product string) []PriceStats {
+	return pa.compute(func(key string) bool { return key == product })
+}
+
+func (pa *PricingAnalytics) compute(include func(product string) bool) []PriceStats {
+	type group struct {
+		stats  PriceStats
+		prices []int64
+	}
+
+	groups := make(map[string]*group)
+	pwyw := make(map[string]bool)
+	for product := range pa.Products {
+		pwyw[product] = true
+	}
+
+	for _, sale := range pa.sales.Sales(nil) {
+		if sale.Product == "" || sale.IsBundle() || !include(sale.Product) && !include(sale.Permalink) {
+			continue
+		}
+		if sale.PayWhatYouWant || pa.Products[sale.Permalink] {
+			pwyw[sale.Product] = true
+		}
+		if _, priced := sale.Fields["price"]; !priced || sale.Refunded {
+			continue
+		}
+
+		key := sale.Product + "\x00" + sale.Price.Currency
+		g := groups[key]
+		if g == nil {
+			g = &group{stats: PriceStats{Product: sale.Product, Currency: sale.Price.Currency}}
+			groups[key] = g
+		}
+		if sale.ProductName != "" {
+			g.stats.ProductName = sale.ProductName
+		}
+		g.prices = append(g.prices, sale.Price.Amount)
+	}
+
+	var all []PriceStats
+	for _, g := range groups {
+		if pwyw[g.stats.Product] {
+			all = append(all, summarizePrices(g.stats, g.prices))
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Product != all[j].Product {
+			return all[i].Product < all[j].Product
+		}
+		return all[i].Currency < all[j].Currency
+	})
+	return all
+}
+
+// summarizePrices fills in the distribution fields from the chosen prices,
+// given in minor units
+func summarizePrices(stats PriceStats, prices []int64) PriceStats {
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+	money := func(amount int64) Money { return Money{Amount: amount, Currency: stats.Currency} }
+	stats.Sales = len(prices)
+	stats.Min = money(prices[0])
+	stats.Max = money(prices[len(prices)-1])
+
+	var paid []int64
+	stats.Revenue = money(0)
+	for _, price := range prices {
+		stats.Revenue.Amount += price // a product's sales cannot overflow int64 cents
+		if price <= 0 {
+			stats.Free++
+		} else {
+			paid = append(paid, price)
+		}
+	}
+	stats.Mean, _ = stats.Revenue.Div(int64(len(prices))) // prices is never empty
+	stats.P10 = money(percentile(prices, 10))
+	stats.P25 = money(percentile(prices, 25))
+	stats.Median = money(percentile(prices, 50))
+	stats.P75 = money(percentile(prices, 75))
+	stats.P90 = money(percentile(prices, 90))
+
+	if len(paid) >= minSalesForSuggestion {
+		suggested := money(percentile(paid, 25)).FloorMajor()
+		stats.SuggestedMinimum = &suggested
+	}
+
+	span := stats.Max.Amount - stats.Min.Amount
+	if span == 0 {
+		stats.Distribution = []PriceBucket{{From: stats.Min, To: stats.Max, Sales: len(prices)}}
+		return stats
+	}
+	// Bucket i covers [min + span·i/n, min + span·(i+1)/n), bounds rounded
+	// down to the minor unit
+	bound := func(i int64) Money { return money(stats.Min.Amount + span*i/priceBuckets) }
+	stats.Distribution = make([]PriceBucket, priceBuckets)
+	for i := range stats.Distribution {
+		stats.Distribution[i].From = bound(int64(i))
+		stats.Distribution[i].To = bound(int64(i + 1))
+	}
+	for _, price := range prices {
+		i := (price - stats.Min.Amount) * priceBuckets / span
+		if i >= priceBuckets {
+			i = priceBuckets - 1 // the maximum closes the last bucket
+		}
+		stats.Distribution[i].Sales++
+	}
+	return stats
+}
+
+// percentile interpolates the p-th percentile of sorted amounts, rounding
+// half away from zero
+func percentile(sorted []int64, p int64) int64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	// rank = p/100 · (n-1), split into its whole part and a fraction over 100
+	scaled := p * int64(len(sorted)-1)
+	lower := scaled / 100
+	fraction := scaled % 100
+	if fraction == 0 {
+		return sorted[lower]
+	}
+	value := new(big.Rat).SetFrac(big.NewInt((sorted[lower+1]-sorted[lower])*fraction), big.NewInt(100))
+	value.Add(value, new(big.Rat).SetInt64(sorted[lower]))
+	rounded, _ := roundRat(value)
+	return rounded
+}
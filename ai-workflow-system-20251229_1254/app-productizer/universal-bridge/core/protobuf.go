@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ProtobufContentType is the UniversalMessage of contract/universal_bridge.proto
+const ProtobufContentType = "application/x-protobuf"
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// UniversalMessage field numbers in contract/universal_bridge.proto
+const (
+	protoFieldID = iota + 1
+	protoFieldTimestamp
+	protoFieldMessageType
+	protoFieldSource
+	protoFieldTarget
+	protoFieldPayload
+	protoFieldResponseChannel
+	protoFieldChecksum
+	protoFieldSequence
+	protoFieldHeaders
+	protoFieldContentEncoding
+	protoFieldExpiresAt
+	protoFieldPriority
+	protoFieldIdempotencyKey
+	protoFieldDeliverAt
+)
+
+// google.protobuf.Value field numbers
+const (
+	protoValueNull = iota + 1
+	protoValueNumber
+	protoValueString
+	protoValueBool
+	protoValueStruct
+	protoValueList
+)
+
+var errProtoTruncated = errors.New("truncated protobuf")
+
+// ProtobufSerializer encodes messages as protobuf, written by hand against
+// the .proto's field numbers so the bridge needs no generated code
+type ProtobufSerializer struct{}
+
+// Marshal encodes the message; payload values that are not JSON types go
+// through their JSON form
+func (ProtobufSerializer) Marshal(message *UniversalMessage) ([]byte, error) {
+	return marshalProtoMessage(message)
+}
+
+// Unmarshal decodes one message
+func (ProtobufSerializer) Unmarshal(data []byte) (*UniversalMessage, error) {
+	return unmarshalProtoMessage(data)
+}
+
+// ContentType returns application/x-protobuf
+func (ProtobufSerializer) ContentType() string {
+	return ProtobufContentType
+}
+
+func marshalProtoMessage(message *UniversalMessage) ([]byte, error) {
+	payload, err := marshalProtoStruct(message.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload: %v", err)
+	}
+	var b []byte
+	b = protoAppendString(b, protoFieldID, message.ID)
+	b = protoAppendString(b, protoFieldTimestamp, message.Timestamp)
+	b = protoAppendString(b, protoFieldMessageType, string(message.MessageType))
+	b = protoAppendString(b, protoFieldSource, message.SourceLanguage)
+	b = protoAppendString(b, protoFieldTarget, message.TargetLanguage)
+	b = protoAppendBytes(b, protoFieldPayload, payload)
+	b = protoAppendString(b, protoFieldResponseChannel, string(message.ResponseChannel))
+	b = protoAppendString(b, protoFieldChecksum, message.Checksum)
+	if message.Sequence != 0 {
+		b = protoAppendTag(b, protoFieldSequence, protoVarint)
+		b = binary.AppendUvarint(b, message.Sequence)
+	}
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var entry []byte
+		entry = protoAppendString(entry, 1, name)
+		entry = protoAppendString(entry, 2, message.Headers[name])
+		b = protoAppendBytes(b, protoFieldHeaders, entry)
+	}
+	if message.ContentEncoding != "" {
+		b = protoAppendString(b, protoFieldContentEncoding, message.ContentEncoding)
+	}
+	if message.ExpiresAt != "" {
+		b = protoAppendString(b, protoFieldExpiresAt, message.ExpiresAt)
+	}
+	if message.Priority != "" {
+		b = protoAppendString(b, protoFieldPriority, message.Priority)
+	}
+	if message.IdempotencyKey != "" {
+		b = protoAppendString(b, protoFieldIdempotencyKey, message.IdempotencyKey)
+	}
+	if message.DeliverAt != "" {
+		b = protoAppendString(b, protoFieldDeliverAt, message.DeliverAt)
+	}
+	return b, nil
+}
+
+func unmarshalProtoMessage(data []byte) (*UniversalMessage, error) {
+	message := &UniversalMessage{Payload: make(map[string]interface{})}
+	err := protoFields(data, func(field, wire int, varint uint64, bytes []byte) error {
+		text := string(bytes)
+		switch field {
+		case protoFieldID:
+			message.ID = text
+		case protoFieldTimestamp:
+			message.Timestamp = text
+		case protoFieldMessageType:
+			message.MessageType = MessageType(text)
+		case protoFieldSource:
+			message.SourceLanguage = text
+		case protoFieldTarget:
+			message.TargetLanguage = text
+		case protoFieldPayload:
+			payload, err := unmarshalProtoStruct(bytes)
+			if err != nil {
+				return fmt.Errorf("payload: %v", err)
+			}
+			message.Payload = payload
+		case protoFieldResponseChannel:
+			message.ResponseChannel = CommunicationChannel(text)
+		case protoFieldChecksum:
+			message.Checksum = text
+		case protoFieldSequence:
+			message.Sequence = varint
+		case protoFieldHeaders:
+			var name, value string
+			err := protoFields(bytes, func(field, _ int, _ uint64, bytes []byte) error {
+				if field == 1 {
+					name = string(bytes)
+				} else if field == 2 {
+					value = string(bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("headers: %v", err)
+			}
+			if message.Headers == nil {
+				message.Headers = make(map[string]string)
+			}
+			message.Headers[name] = value
+		case protoFieldContentEncoding:
+			message.ContentEncoding = text
+		case protoFieldExpiresAt:
+			message.ExpiresAt = text
+		case protoFieldPriority:
+			message.Priority = text
+		case protoFieldIdempotencyKey:
+			message.IdempotencyKey = text
+		case protoFieldDeliverAt:
+			message.DeliverAt = text
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// marshalProtoStruct encodes a payload as a google.protobuf.Struct, its
+// keys in order so equal payloads encode the same
+func marshalProtoStruct(object map[string]interface{}) ([]byte, error) {
+	var b []byte
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := marshalProtoValue(object[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		var entry []byte
+		entry = protoAppendString(entry, 1, key)
+		entry = protoAppendBytes(entry, 2, value)
+		b = protoAppendBytes(b, 1, entry)
+	}
+	return b, nil
+}
+
+func marshalProtoValue(value interface{}) ([]byte, error) {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		b = protoAppendTag(b, protoValueNull, protoVarint)
+		b = append(b, 0)
+	case string:
+		b = protoAppendTag(b, protoValueString, protoBytes)
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		b = append(b, v...)
+	case bool:
+		b = protoAppendTag(b, protoValueBool, protoVarint)
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	case map[string]interface{}:
+		object, err := marshalProtoStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		b = protoAppendTag(b, protoValueStruct, protoBytes)
+		b = binary.AppendUvarint(b, uint64(len(object)))
+		b = append(b, object...)
+	case []interface{}:
+		var list []byte
+		for i, item := range v {
+			encoded, err := marshalProtoValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			list = protoAppendBytes(list, 1, encoded)
+		}
+		b = protoAppendTag(b, protoValueList, protoBytes)
+		b = binary.AppendUvarint(b, uint64(len(list)))
+		b = append(b, list...)
+	default:
+		if number, ok := toNumber(value); ok {
+			if math.IsNaN(number) || math.IsInf(number, 0) {
+				return nil, fmt.Errorf("%v is not a JSON number", number)
+			}
+			b = protoAppendTag(b, protoValueNumber, protoFixed64)
+			return binary.LittleEndian.AppendUint64(b, math.Float64bits(number)), nil
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var decoded interface{}
+		if err = json.Unmarshal(encoded, &decoded); err != nil {
+			return nil, err
+		}
+		return marshalProtoValue(decoded)
+	}
+	return b, nil
+}
+
+func unmarshalProtoStruct(data []byte) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	err := protoFields(data, func(field, wire int, _ uint64, entry []byte) error {
+		if field != 1 || wire != protoBytes {
+			return nil
+		}
+		var key string
+		var value interface{}
+		err := protoFields(entry, func(field, wire int, _ uint64, bytes []byte) error {
+			var err error
+			if field == 1 {
+				key = string(bytes)
+			} else if field == 2 && wire == protoBytes {
+				value, err = unmarshalProtoValue(bytes)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		object[key] = value
+		return nil
+	})
+	return object, err
+}
+
+func unmarshalProtoValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := protoFields(data, func(field, wire int, varint uint64, bytes []byte) error {
+		var err error
+		switch field {
+		case protoValueNull:
+			value = nil
+		case protoValueNumber:
+			value = math.Float64frombits(varint)
+		case protoValueString:
+			value = string(bytes)
+		case protoValueBool:
+			value = varint != 0
+		case protoValueStruct:
+			value, err = unmarshalProtoStruct(bytes)
+		case protoValueList:
+			list := []interface{}{}
+			err = protoFields(bytes, func(field, wire int, _ uint64, item []byte) error {
+				if field != 1 || wire != protoBytes {
+					return nil
+				}
+				decoded, err := unmarshalProtoValue(item)
+				list = append(list, decoded)
+				return err
+			})
+			value = list
+		}
+		return err
+	})
+	return value, err
+}
+
+func protoAppendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// protoAppendString appends a string field, leaving out the proto3 default ""
+func protoAppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protoAppendTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// protoAppendBytes appends an embedded message, even an empty one
+func protoAppendBytes(b []byte, field int, data []byte) []byte {
+	b = protoAppendTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// protoFields calls visit with each field in data: its number and wire
+// type, and its value as varint for varint and fixed fields or as bytes for
+// length-delimited ones. Unknown fields are passed along for visit to skip.
+func protoFields(data []byte, visit func(field, wire int, varint uint64, bytes []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		field, wire := int(key>>3), int(key&7)
+		if field == 0 {
+			return fmt.Errorf("protobuf field number 0")
+		}
+
+		var varint uint64
+		var bytes []byte
+		switch wire {
+		case protoVarint:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errProtoTruncated
+			}
+			bytes, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if err := visit(field, wire, varint, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Templates PurchaseEmails sends
+const (
+	purchaseConfirmationTemplate = "sale_receipt"
+	productDeliveryTemplate      = "product_delivery"
+)
+
+// purchaseEmailMemory is how long a sent email is remembered, well past
+// the days over which Gumroad redelivers a ping
+const purchaseEmailMemory = 30 * 24 * time.Hour
+
+// PurchaseEmails emails every buyer the sale_receipt template when their
+// sale arrives and, for products with their own product_delivery template
+// under products/<product>/, that too. Both go through the "email" sink,
+// which retries failed sends. Each email is sent once per sale; the ones
+// sent are remembered at path, so a redelivered ping or a restart does not
+// send them again. Gifts are left to HandleGifts, and a bundle gets one
+// confirmation and a delivery per component.
+type PurchaseEmails struct {
+	bridge    *GoBridge
+	templates *TemplateStore
+	path      string
+
+	mu   sync.Mutex
+	sent map[string]time.Time // "<sale ID>/<template>" to when it was queued
+}
+
+// NewPurchaseEmails opens the sent emails at path and emails the buyers of
+// every sale the bridge receives
+func NewPurchaseEmails(gb *GoBridge, templates *TemplateStore, path string) (*PurchaseEmails, error) {
+	pe := &PurchaseEmails{bridge: gb, templates: templates, path: path, sent: make(map[string]time.Time)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sent purchase emails: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &pe.sent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sent purchase emails %s: %v", path, err)
+		}
+	}
+	gb.OnReceive(pe.receive)
+	return pe, nil
+}
+
+func (pe *PurchaseEmails) receive(message *UniversalMessage) {
+	if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+		return
+	}
+	if _, gift := DetectGift(message.Payload); gift {
+		return
+	}
+	sale, err := ParseSale(message.Payload)
+	if err != nil {
+		// RecordSales logs the same problem
+		return
+	}
+	if sale.Email == "" {
+		log.Printf("❌ Not emailing sale %s, which has no buyer email", sale.ID)
+		return
+	}
+
+	if sale.BundleSaleID == "" {
+		pe.send(sale, purchaseConfirmationTemplate)
+	}
+	if !sale.IsBundle() && pe.templates.HasProductOverride(productDeliveryTemplate, sale.Product) {
+		pe.send(sale, productDeliveryTemplate)
+	}
+}
+
+// send queues one of the sale's emails, unless it was queued before
+func (pe *PurchaseEmails) send(sale Sale, name string) {
+	key := sale.ID + "/" + name
+	now := pe.bridge.clock.Now()
+	pe.mu.Lock()
+	_, sent := pe.sent[key]
+	if !sent {
+		// Claimed before queueing, so a copy handled at the same time is skipped
+		pe.sent[key] = now
+	}
+	pe.mu.Unlock()
+	if sent {
+		fmt.Printf("⏭️ Already sent %s for sale %s\n", name, sale.ID)
+		return
+	}
+
+	err := pe.enqueue(sale, name)
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if err != nil {
+		delete(pe.sent, key)
+		log.Printf("❌ Error sending %s for sale %s to %s: %v", name, sale.ID, sale.Email, err)
+		return
+	}
+	for old, at := range pe.sent {
+		if now.Sub(at) > purchaseEmailMemory {
+			delete(pe.sent, old)
+		}
+	}
+	err = writeJSONFile(pe.path, pe.sent)
+	if err != nil {
+		log.Printf("❌ Error saving sent purchase emails: %v", err)
+	}
+}
+
+func (pe *PurchaseEmails) enqueue(sale Sale, name string) error {
+	sink := pe.bridge.Sink("email")
+	if sink == nil {
+		return fmt.Errorf("no email sink")
+	}
+	locale := DetectLocale(sale.Fields)
+	text, err := pe.templates.RenderLocale(name, sale.Product, locale, sale.TemplateData())
+	if err != nil {
+		return err
+	}
+	return sink.Enqueue(pe.bridge.NewMessage(DataSync, "go", mergePayload(sale.Payload(), map[string]interface{}{
+		"to":       sale.Email,
+		"text":     text,
+		"locale":   locale,
+		"template": name,
+	}), FileSystem))
+}
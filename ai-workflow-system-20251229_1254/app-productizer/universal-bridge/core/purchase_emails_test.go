@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurchaseEmails(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var emails []map[string]interface{}
+	gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		emails = append(emails, message.Payload)
+		return message
+	})
+	path := filepath.Join(t.TempDir(), "purchases.json")
+	if _, err := NewPurchaseEmails(gb, NewTemplateStore("templates"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := NewSequentialIDs("gumroad")
+	sale := func(transport *MemoryTransport, fields map[string]interface{}) {
+		t.Helper()
+		payload := map[string]interface{}{"resource_name": "sale", "price": 49, "currency": "usd", "email": "ada@example.com"}
+		for key, value := range fields {
+			payload[key] = value
+		}
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	course := map[string]interface{}{"sale_id": "s1", "product_id": "course-pro", "product_name": "Course Pro", "license_key": "KEY-1"}
+	tests := []struct {
+		name       string
+		fields     map[string]interface{}
+		wantEmails []string // subjects, in order
+	}{
+		{"confirmation and the product's delivery", course, []string{"Subject: Welcome to Course Pro!", "Subject: Start here: Course Pro"}},
+		{"redelivered ping", course, nil},
+		{"product without a delivery template", map[string]interface{}{"sale_id": "s2", "product_id": "ebook", "product_name": "The Ebook"}, []string{"Subject: Your receipt for The Ebook"}},
+		{"gift", map[string]interface{}{"sale_id": "s3", "product_id": "ebook", "product_name": "The Ebook", "giftee_email": "bob@example.com"}, nil},
+		{"bundle", map[string]interface{}{"sale_id": "s4", "product_id": "kit", "product_name": "The Kit", "bundle_components": []interface{}{"s4-1"}}, []string{"Subject: Your receipt for The Kit"}},
+		{"bundle component", map[string]interface{}{"sale_id": "s4-1", "product_id": "course-pro", "product_name": "Course Pro", "bundle_sale_id": "s4"}, []string{"Subject: Start here: Course Pro"}},
+		{"German buyer", map[string]interface{}{"sale_id": "s5", "product_id": "ebook", "product_name": "The Ebook", "country": "DE"}, []string{"Subject: Deine Quittung"}},
+	}
+	for _, tt := range tests {
+		emails = nil
+		sale(transport, tt.fields)
+		if len(emails) != len(tt.wantEmails) {
+			t.Errorf("%s: sent %d emails, want %d: %v", tt.name, len(emails), len(tt.wantEmails), emails)
+			continue
+		}
+		for i, want := range tt.wantEmails {
+			if !strings.HasPrefix(stringArg(emails[i], "text"), want) || emails[i]["to"] != "ada@example.com" {
+				t.Errorf("%s: email %d to %v is %q, want %q", tt.name, i, emails[i]["to"], emails[i]["text"], want)
+			}
+		}
+	}
+
+	// What was sent is remembered across a restart
+	otherTransport := NewMemoryTransport()
+	other := NewGoBridge("", WithClock(clock), WithTransport(otherTransport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { other.Close() })
+	other.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		emails = append(emails, message.Payload)
+		return message
+	})
+	if _, err := NewPurchaseEmails(other, NewTemplateStore("templates"), path); err != nil {
+		t.Fatal(err)
+	}
+	emails = nil
+	sale(otherTransport, course)
+	if len(emails) != 0 {
+		t.Errorf("emailed again after a restart: %v", emails)
+	}
+}
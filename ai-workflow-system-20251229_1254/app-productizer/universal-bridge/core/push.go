@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ntfyServerURL    = "https://ntfy.sh"
+	ntfyTokenEnv     = "NTFY_TOKEN"
+	pushoverAPIURL   = "https://api.pushover.net/1/messages.json"
+	pushoverTokenEnv = "PUSHOVER_TOKEN"
+)
+
+// Push event types targets opt in to
+const (
+	PushSale      = "sale"
+	PushFailure   = "failure"
+	PushMilestone = "milestone" // launch milestones and the post-launch report
+	PushPrice     = "price"     // a watched page's price or description changed
+)
+
+var pushEvents = map[string]bool{PushSale: true, PushFailure: true, PushMilestone: true, PushPrice: true}
+
+// NtfyTarget publishes to an ntfy topic, on ntfy.sh or a self-hosted server
+type NtfyTarget struct {
+	Topic  string   `yaml:"topic"`
+	Server string   `yaml:"server"`
+	Events []string `yaml:"events"`
+}
+
+// PushoverTarget notifies a Pushover user or group key
+type PushoverTarget struct {
+	User   string   `yaml:"user"`
+	Device string   `yaml:"device"`
+	Events []string `yaml:"events"`
+}
+
+// PushConfig says where push alerts go, loaded from YAML:
+//
+//	min_sale:                # sales below this, per currency, are not pushed
+//	  usd: "10.00"
+//	ntfy:
+//	  topic: my-shop-alerts
+//	  server: https://ntfy.sh  # the default
+//	  events: [sale, failure, milestone]
+//	pushover:
+//	  user: uQiRzpo4DXghDmr9QzzfQu27cmVRsG
+//	  events: [failure]
+//
+// Either target may be left out. Events default to sale, failure and
+// milestone; price is opt-in.
+type PushConfig struct {
+	MinSale  map[string]string `yaml:"min_sale"`
+	Ntfy     *NtfyTarget       `yaml:"ntfy"`
+	Pushover *PushoverTarget   `yaml:"pushover"`
+
+	minSale map[string]Money
+}
+
+// LoadPushConfig reads the push targets
+func LoadPushConfig(path string) (PushConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PushConfig{}, fmt.Errorf("failed to read push config: %v", err)
+	}
+
+	var config PushConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return PushConfig{}, fmt.Errorf("failed to parse push config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (PushConfig, error) {
+		return PushConfig{}, fmt.Errorf("invalid push config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	config.minSale = make(map[string]Money)
+	for currency, amount := range config.MinSale {
+		threshold, err := ParseMoney(amount, currency)
+		if err != nil {
+			return invalid("min_sale %s: %v", currency, err)
+		}
+		config.minSale[threshold.Currency] = threshold
+	}
+	if config.Ntfy == nil && config.Pushover == nil {
+		return invalid("no ntfy or pushover target")
+	}
+	checkEvents := func(target string, events *[]string) error {
+		if *events == nil {
+			*events = []string{PushSale, PushFailure, PushMilestone}
+		}
+		for _, event := range *events {
+			if !pushEvents[event] {
+				return fmt.Errorf("%s: unknown event %q", target, event)
+			}
+		}
+		return nil
+	}
+	if config.Ntfy != nil {
+		if config.Ntfy.Topic == "" {
+			return invalid("ntfy: no topic")
+		}
+		if config.Ntfy.Server == "" {
+			config.Ntfy.Server = ntfyServerURL
+		}
+		if err := checkEvents("ntfy", &config.Ntfy.Events); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	if config.Pushover != nil {
+		if config.Pushover.User == "" {
+			return invalid("pushover: no user key")
+		}
+		if err := checkEvents("pushover", &config.Pushover.Events); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	return config, nil
+}
+
+// hasEvent reports whether events includes event
+func hasEvent(events []string, event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// PushNotifier sends mobile push alerts through ntfy and Pushover, a
+// lighter alternative to a Slack workspace for a solo seller: every sale at
+// or above min_sale for its currency, failures, the same outages the SMS
+// notifier texts about, and watched pages' price changes. Failures go out
+// at high priority. Alerts are
+// delivered through the "push" sink, so an unreachable service is retried
+// behind its circuit breaker.
+type PushNotifier struct {
+	Config PushConfig
+	// NtfyToken authenticates to a protected ntfy topic; it may be empty
+	NtfyToken string
+	// PushoverToken is the Pushover application's API token
+	PushoverToken string
+	// PushoverURL defaults to the public API
+	PushoverURL string
+	HTTPClient  *http.Client
+
+	bridge *GoBridge
+	sink   *Sink
+
+	mu       sync.Mutex
+	notified map[string]bool // sale IDs already pushed, so redelivered pings push once
+}
+
+// NewPushNotifier pushes the events the bridge receives to the config's
+// targets. A Pushover target needs the application token.
+func NewPushNotifier(gb *GoBridge, config PushConfig, ntfyToken, pushoverToken string) (*PushNotifier, error) {
+	if config.Pushover != nil && pushoverToken == "" {
+		return nil, fmt.Errorf("Pushover needs %s", pushoverTokenEnv)
+	}
+	pn := &PushNotifier{
+		Config:        config,
+		NtfyToken:     ntfyToken,
+		PushoverToken: pushoverToken,
+		PushoverURL:   pushoverAPIURL,
+		HTTPClient:    &http.Client{Timeout: 30 * time.Second},
+		bridge:        gb,
+		notified:      make(map[string]bool),
+	}
+	pn.sink = gb.AddSink("push", SinkConfig{}, pn.deliver)
+	gb.OnReceive(pn.handleMessage)
+	return pn, nil
+}
+
+func (pn *PushNotifier) handleMessage(message *UniversalMessage) {
+	event, title, body := pn.classify(message)
+	if event == "" {
+		return
+	}
+	pn.Notify(event, title, body)
+}
+
+// classify names the push event a message is, if any, with its title and text
+func (pn *PushNotifier) classify(message *UniversalMessage) (event, title, body string) {
+	if isGumroadEvent(message) && stringArg(message.Payload, "resource_name") == "sale" {
+		sale, err := ParseSale(message.Payload)
+		if err != nil || sale.Refunded {
+			return "", "", ""
+		}
+		if threshold, ok := pn.Config.minSale[sale.Price.Currency]; ok {
+			if cmp, err := sale.Price.Cmp(threshold); err != nil || cmp < 0 {
+				return "", "", ""
+			}
+		}
+		if !pn.first(sale.ID) {
+			return "", "", ""
+		}
+		product := sale.ProductName
+		if product == "" {
+			product = sale.Product
+		}
+		return PushSale, "New sale: " + sale.Price.String(), fmt.Sprintf("%s bought %s", sale.Email, product)
+	}
+	if isGumroadEvent(message) && stringArg(message.Payload, "resource_name") == "price_watch" {
+		return PushPrice, "Price watch: " + stringArg(message.Payload, "page"), stringArg(message.Payload, "text")
+	}
+	if text := outageText(message); text != "" {
+		return PushFailure, "Bridge failure", text
+	}
+	return "", "", ""
+}
+
+// first reports whether the sale has not been pushed before
+func (pn *PushNotifier) first(id string) bool {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+	if pn.notified[id] {
+		return false
+	}
+	pn.notified[id] = true
+	return true
+}
+
+// Notify pushes an alert to every target that opted in to the event
+func (pn *PushNotifier) Notify(event, title, body string) {
+	if pn.Config.Ntfy != nil && hasEvent(pn.Config.Ntfy.Events, event) {
+		pn.enqueue("ntfy", event, title, body)
+	}
+	if pn.Config.Pushover != nil && hasEvent(pn.Config.Pushover.Events, event) {
+		pn.enqueue("pushover", event, title, body)
+	}
+}
+
+// enqueue queues an alert for one service on the push sink
+func (pn *PushNotifier) enqueue(service, event, title, body string) {
+	message := pn.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+		"service": service,
+		"event":   event,
+		"title":   title,
+		"body":    body,
+	}, FileSystem)
+	err := pn.sink.Enqueue(message)
+	if err != nil {
+		log.Printf("❌ Error queueing %s push to %s: %v", event, service, err)
+	}
+}
+
+// deliver sends one queued alert to its service
+func (pn *PushNotifier) deliver(message *UniversalMessage) error {
+	service := stringArg(message.Payload, "service")
+	event := stringArg(message.Payload, "event")
+	title := stringArg(message.Payload, "title")
+	body := stringArg(message.Payload, "body")
+
+	var request *http.Request
+	var err error
+	switch service {
+	case "ntfy":
+		target := pn.Config.Ntfy
+		if target == nil {
+			return fmt.Errorf("push %s is for ntfy, which is not configured", message.ID)
+		}
+		endpoint := strings.TrimSuffix(target.Server, "/") + "/" + url.PathEscape(target.Topic)
+		request, err = http.NewRequest(http.MethodPost, endpoint, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Title", title)
+		if event == PushFailure {
+			request.Header.Set("Priority", "high")
+			request.Header.Set("Tags", "rotating_light")
+		} else if event == PushMilestone {
+			request.Header.Set("Tags", "rocket")
+		} else if event == PushPrice {
+			request.Header.Set("Tags", "label")
+		} else {
+			request.Header.Set("Tags", "moneybag")
+		}
+		if pn.NtfyToken != "" {
+			request.Header.Set("Authorization", "Bearer "+pn.NtfyToken)
+		}
+
+	case "pushover":
+		target := pn.Config.Pushover
+		if target == nil {
+			return fmt.Errorf("push %s is for Pushover, which is not configured", message.ID)
+		}
+		priority := 0
+		if event == PushFailure {
+			priority = 1
+		}
+		form := url.Values{
+			"token":    {pn.PushoverToken},
+			"user":     {target.User},
+			"title":    {title},
+			"message":  {body},
+			"priority": {strconv.Itoa(priority)},
+		}
+		if target.Device != "" {
+			form.Set("device", target.Device)
+		}
+		request, err = http.NewRequest(http.MethodPost, pn.PushoverURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	default:
+		return fmt.Errorf("push %s names unknown service %q", message.ID, service)
+	}
+
+	response, err := pn.HTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("pushing to %s: %v", service, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("pushing to %s: answered %s: %s", service, response.Status, strings.TrimSpace(string(detail)))
+	}
+	fmt.Printf("📲 Pushed %s alert to %s\n", event, service)
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ack and Nack are delivery receipts. A message whose ack header is
+// "requested" is answered once the receiving bridge is done with it: with
+// an Ack when a handler processed it, or a Nack carrying the error when the
+// bridge gave up on it. Both name the message in original_message_id.
+const (
+	Ack  MessageType = "ack"
+	Nack MessageType = "nack"
+)
+
+// ackHeader asks the receiver for a receipt when set to ackRequested
+const (
+	ackHeader    = "ack"
+	ackRequested = "requested"
+)
+
+var (
+	// ErrNoReceipt is WaitAck's answer for a message sent without RequestAck
+	ErrNoReceipt = errors.New("message did not ask for a receipt")
+	// ErrNotAcknowledged is why a delivery fails once its sends run out
+	ErrNotAcknowledged = errors.New("message not acknowledged")
+)
+
+// RequestAck asks the receiver for a receipt, so the Delivery SendMessage
+// returns can be waited on and the message is resent until it is acked
+func (m *UniversalMessage) RequestAck() {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[ackHeader] = ackRequested
+}
+
+// ackRequested reports whether the sender wants a receipt; receipts are
+// never answered themselves
+func (m *UniversalMessage) ackRequested() bool {
+	return m.Headers[ackHeader] == ackRequested && m.MessageType != Ack && m.MessageType != Nack
+}
+
+// Delivery is a sent message on its way to a receipt
+type Delivery struct {
+	ID string
+
+	done chan struct{} // nil when no receipt was asked for
+	err  error         // why the delivery failed, once done
+}
+
+// WaitAck blocks until the message is acked, returning nil, or until it is
+// given up on or ctx is done, returning why. A message sent without
+// RequestAck returns ErrNoReceipt at once.
+func (d *Delivery) WaitAck(ctx context.Context) error {
+	if d.done == nil {
+		return ErrNoReceipt
+	}
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Delivery) settle(err error) {
+	d.err = err
+	close(d.done)
+}
+
+// Receipts tracks the sent messages that asked for a receipt. One that is
+// nacked, or not acked within Timeout, is sent again unchanged, up to
+// Attempts sends in all; the receiver's deduplication skips a resend of a
+// message it handled after all, and acks it again.
+type Receipts struct {
+	// Timeout is how long a send waits for its receipt
+	Timeout time.Duration
+	// Attempts is how often a message is sent before it is given up on
+	Attempts int
+
+	bridge *GoBridge
+
+	mu       sync.Mutex
+	waiting  map[string]*awaitedReceipt // message ID → its sends
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type awaitedReceipt struct {
+	delivery  *Delivery
+	message   *UniversalMessage
+	transport Transport
+	attempts  int
+	deadline  time.Time
+}
+
+func newReceipts(gb *GoBridge) *Receipts {
+	return &Receipts{
+		Timeout:  30 * time.Second,
+		Attempts: 5,
+		bridge:   gb,
+		waiting:  make(map[string]*awaitedReceipt),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Receipts returns the bridge's receipt tracker
+func (gb *GoBridge) Receipts() *Receipts {
+	return gb.receipts
+}
+
+// expect tracks a message about to be sent on transport. A message already
+// waiting, being sent again, keeps its delivery; added is false for it.
+func (r *Receipts) expect(message *UniversalMessage, transport Transport) (delivery *Delivery, added bool) {
+	if !message.ackRequested() {
+		return &Delivery{ID: message.ID}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if waiting := r.waiting[message.ID]; waiting != nil {
+		return waiting.delivery, false
+	}
+	delivery = &Delivery{ID: message.ID, done: make(chan struct{})}
+	r.waiting[message.ID] = &awaitedReceipt{
+		delivery:  delivery,
+		message:   message,
+		transport: transport,
+		attempts:  1,
+		deadline:  r.bridge.clock.Now().Add(r.Timeout),
+	}
+	return delivery, true
+}
+
+// forget stops tracking a message whose first send failed, as SendMessage's
+// error already told the caller
+func (r *Receipts) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.waiting, id)
+}
+
+// handleReceipt is the Ack and Nack handler
+func (r *Receipts) handleReceipt(message *UniversalMessage) error {
+	id := stringArg(message.Payload, "original_message_id")
+	r.mu.Lock()
+	waiting := r.waiting[id]
+	if waiting != nil && message.MessageType == Ack {
+		delete(r.waiting, id)
+	}
+	r.mu.Unlock()
+
+	switch {
+	case waiting == nil:
+		fmt.Printf("⚠️ Receipt %s is for no message awaiting one\n", message.ID)
+	case message.MessageType == Ack:
+		fmt.Printf("✅ %s acknowledged by %s\n", id, message.SourceLanguage)
+		waiting.delivery.settle(nil)
+	default:
+		r.retry(waiting, fmt.Errorf("nacked by %s: %s", message.SourceLanguage, stringArg(message.Payload, "error")))
+	}
+	return nil
+}
+
+// retry sends a message again, or gives up on it once its sends run out
+func (r *Receipts) retry(waiting *awaitedReceipt, reason error) {
+	id := waiting.message.ID
+	r.mu.Lock()
+	if r.waiting[id] != waiting {
+		// Settled meanwhile
+		r.mu.Unlock()
+		return
+	}
+	if waiting.attempts >= r.Attempts {
+		delete(r.waiting, id)
+		r.mu.Unlock()
+		log.Printf("❌ Giving up on %s after %d sends: %v", id, waiting.attempts, reason)
+		waiting.delivery.settle(fmt.Errorf("%w after %d sends: %v", ErrNotAcknowledged, waiting.attempts, reason))
+		return
+	}
+	waiting.attempts++
+	waiting.deadline = r.bridge.clock.Now().Add(r.Timeout)
+	r.mu.Unlock()
+
+	fmt.Printf("🔁 Resending %s: %v\n", id, reason)
+	if _, err := r.bridge.sendOn(waiting.transport, waiting.message); err != nil {
+		// The next deadline tries again
+		log.Printf("❌ Error resending %s: %v", id, err)
+	}
+}
+
+// Check resends every message whose receipt is overdue
+func (r *Receipts) Check() {
+	now := r.bridge.clock.Now()
+	r.mu.Lock()
+	var overdue []*awaitedReceipt
+	for _, waiting := range r.waiting {
+		if !now.Before(waiting.deadline) {
+			overdue = append(overdue, waiting)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(overdue, func(i, j int) bool { return overdue[i].deadline.Before(overdue[j].deadline) })
+	for _, waiting := range overdue {
+		r.retry(waiting, fmt.Errorf("no receipt from %s within %s", waiting.message.TargetLanguage, r.Timeout))
+	}
+}
+
+// Waiting returns the IDs of the messages still awaiting a receipt, sorted
+func (r *Receipts) Waiting() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]string, 0, len(r.waiting))
+	for id := range r.waiting {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Start checks for overdue receipts every interval until Close
+func (r *Receipts) Start(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-r.bridge.clock.After(interval):
+				r.Check()
+			}
+		}
+	}()
+}
+
+// Close stops the checker and fails the deliveries still waiting with
+// ErrBridgeClosed
+func (r *Receipts) Close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.mu.Lock()
+	waiting := r.waiting
+	r.waiting = make(map[string]*awaitedReceipt)
+	r.mu.Unlock()
+	for _, w := range waiting {
+		w.delivery.settle(ErrBridgeClosed)
+	}
+}
+
+// sendReceipt answers a message that asked for a receipt: an Ack when err
+// is nil, otherwise a Nack with the error it was given up on for. A Nack
+// also makes deduplication forget the message, so the sender's resend is
+// handled afresh rather than skipped.
+func (gb *GoBridge) sendReceipt(message *UniversalMessage, err error) {
+	if !message.ackRequested() {
+		return
+	}
+	messageType, payload := Ack, map[string]interface{}{}
+	if err != nil {
+		messageType, payload["error"] = Nack, err.Error()
+		message.nacked = true
+		gb.pipeline.seen.forget(message.idempotencyKey())
+	}
+	if _, err := gb.Reply(message, messageType, payload); err != nil {
+		// The sender resends, and the copy is acked or nacked again
+		log.Printf("❌ Error sending receipt for %s: %v", message.ID, err)
+	}
+}
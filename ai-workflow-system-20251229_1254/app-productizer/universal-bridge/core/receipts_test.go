@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReceipts(t *testing.T) {
+	tests := []struct {
+		name     string
+		receipts []MessageType // answered to each send in turn; "" times out
+		sends    int
+		wantErr  error
+	}{
+		{"acked", []MessageType{Ack}, 1, nil},
+		{"nacked then acked", []MessageType{Nack, Ack}, 2, nil},
+		{"acked after a timeout", []MessageType{"", Ack}, 2, nil},
+		{"given up on", []MessageType{Nack, "", Nack}, 3, ErrNotAcknowledged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+			transport := NewMemoryTransport()
+			gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+			t.Cleanup(func() { gb.Close() })
+			gb.Receipts().Attempts = 3
+			peer := NewSequentialIDs("py")
+
+			message := gb.NewMessage(DataSync, "python", map[string]interface{}{"order": 1}, SharedMemory)
+			message.RequestAck()
+			delivery, err := gb.SendMessage(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, receipt := range tt.receipts {
+				if receipt == "" {
+					clock.Advance(gb.Receipts().Timeout)
+					gb.Receipts().Check()
+					continue
+				}
+				err := transport.Inject(newUniversalMessage(clock, peer, receipt, "python", "go", map[string]interface{}{"original_message_id": message.ID, "error": "busy"}, SharedMemory))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err = delivery.WaitAck(ctx)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("WaitAck = %v, want %v", err, tt.wantErr)
+			}
+			sent := transport.SentOfType(DataSync)
+			if len(sent) != tt.sends {
+				t.Errorf("sent %d times, want %d", len(sent), tt.sends)
+			}
+			for _, sent := range sent {
+				if sent.ID != message.ID {
+					t.Errorf("resent as %s, want %s", sent.ID, message.ID)
+				}
+			}
+			if waiting := gb.Receipts().Waiting(); len(waiting) != 0 {
+				t.Errorf("still waiting for %v", waiting)
+			}
+		})
+	}
+
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	delivery, err := gb.SendMessage(gb.NewMessage(DataSync, "python", nil, SharedMemory))
+	if err != nil || !errors.Is(delivery.WaitAck(context.Background()), ErrNoReceipt) {
+		t.Errorf("a message sent without RequestAck waits for a receipt: %v", err)
+	}
+}
+
+func TestAnswerReceipts(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	dl, err := NewDeadLetterQueue(gb, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dl.MaxAttempts = 2
+	runs := 0
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		runs++
+		if message.Payload["fail"] == true {
+			return errors.New("out of stock")
+		}
+		return nil
+	})
+
+	ids := NewSequentialIDs("py")
+	deliver := func(payload map[string]interface{}, ack bool) *UniversalMessage {
+		message := newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)
+		if ack {
+			message.RequestAck()
+		}
+		if err := transport.Inject(message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	receipts := func() []string {
+		var list []string
+		for _, sent := range transport.Sent() {
+			if sent.MessageType == Ack || sent.MessageType == Nack {
+				list = append(list, string(sent.MessageType)+" "+stringArg(sent.Payload, "original_message_id"))
+			}
+		}
+		transport.Reset()
+		return list
+	}
+	expect := func(step string, want ...string) {
+		t.Helper()
+		if got := receipts(); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+			t.Errorf("%s: receipts %q, want %q", step, got, want)
+		}
+	}
+
+	handled := deliver(nil, true)
+	expect("handled", "ack "+handled.ID)
+	transport.Inject(handled)
+	expect("duplicate acked again", "ack "+handled.ID)
+	deliver(nil, false)
+	expect("no receipt asked for")
+
+	failing := deliver(map[string]interface{}{"fail": true}, true)
+	expect("retrying")
+	retryDue(dl, clock)
+	expect("dead", "nack "+failing.ID)
+	before := runs
+	transport.Inject(failing)
+	if runs != before+1 {
+		t.Errorf("a nacked message's resend was skipped")
+	}
+	expect("resent and dead again", "nack "+failing.ID)
+}
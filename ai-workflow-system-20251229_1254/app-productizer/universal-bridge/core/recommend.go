@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultMinSupport is how many shared buyers a pair of products needs
+// before it is used for recommendations
+const DefaultMinSupport = 2
+
+// ProductAffinity says how often buyers of Product also bought Other
+type ProductAffinity struct {
+	Product     string  `json:"product"`
+	ProductName string  `json:"product_name"`
+	Other       string  `json:"other"`
+	OtherName   string  `json:"other_name"`
+	Buyers      int     `json:"buyers"`     // bought both
+	Confidence  float64 `json:"confidence"` // share of Product's buyers who bought Other
+	Lift        float64 `json:"lift"`       // confidence relative to Other's overall popularity
+}
+
+// Recommendation is a product suggested to one customer
+type Recommendation struct {
+	Product     string  `json:"product"`
+	ProductName string  `json:"product_name"`
+	Score       float64 `json:"score"`
+	Because     string  `json:"because"` // name of the owned product that contributed most
+}
+
+// Recommender finds co-purchase patterns in the sales store
+type Recommender struct {
+	// MinSupport filters out pairs with fewer shared buyers
+	MinSupport int
+
+	sales *SalesStore
+}
+
+// NewRecommender creates a recommender over the sales store
+func NewRecommender(sales *SalesStore) *Recommender {
+	return &Recommender{MinSupport: DefaultMinSupport, sales: sales}
+}
+
+// coPurchases is the co-purchase matrix built from the sales store
+type coPurchases struct {
+	buyers map[string]int            // product → distinct buyers
+	pairs  map[string]map[string]int // product → other → buyers of both
+	names  map[string]string
+	owned  map[string]map[string]bool // email → products
+	total  int                        // distinct buyers
+}
+
+func productKey(sale map[string]interface{}) string {
+	if id := stringArg(sale, "product_id"); id != "" {
+		return id
+	}
+	return stringArg(sale, "product_permalink")
+}
+
+// analyze builds the matrix from every non-refunded sale, counting bundles
+// by their components
+func (r *Recommender) analyze() *coPurchases {
+	matrix := &coPurchases{
+		buyers: make(map[string]int),
+		pairs:  make(map[string]map[string]int),
+		names:  make(map[string]string),
+		owned:  make(map[string]map[string]bool),
+	}
+
+	for _, sale := range r.sales.Sales(nil) {
+		if sale.Email == "" || sale.Product == "" || sale.IsBundle() || sale.Refunded {
+			continue
+		}
+		if sale.ProductName != "" {
+			matrix.names[sale.Product] = sale.ProductName
+		}
+		if matrix.owned[sale.Email] == nil {
+			matrix.owned[sale.Email] = make(map[string]bool)
+		}
+		matrix.owned[sale.Email][sale.Product] = true
+	}
+
+	for _, products := range matrix.owned {
+		matrix.total++
+		for product := range products {
+			matrix.buyers[product]++
+			for other := range products {
+				if other == product {
+					continue
+				}
+				if matrix.pairs[product] == nil {
+					matrix.pairs[product] = make(map[string]int)
+				}
+				matrix.pairs[product][other]++
+			}
+		}
+	}
+	return matrix
+}
+
+func (m *coPurchases) name(product string) string {
+	if name, ok := m.names[product]; ok {
+		return name
+	}
+	return product
+}
+
+func (m *coPurchases) affinity(product, other string) ProductAffinity {
+	both := m.pairs[product][other]
+	confidence := float64(both) / float64(m.buyers[product])
+	lift := confidence / (float64(m.buyers[other]) / float64(m.total))
+	return ProductAffinity{
+		Product:     product,
+		ProductName: m.name(product),
+		Other:       other,
+		OtherName:   m.name(other),
+		Buyers:      both,
+		Confidence:  confidence,
+		Lift:        lift,
+	}
+}
+
+// TopPairs returns the strongest "buyers of X often buy Y" patterns, ordered
+// by confidence then shared buyers
+func (r *Recommender) TopPairs(limit int) []ProductAffinity {
+	matrix := r.analyze()
+
+	var pairs []ProductAffinity
+	for product, others := range matrix.pairs {
+		for other, both := range others {
+			if both >= r.MinSupport {
+				pairs = append(pairs, matrix.affinity(product, other))
+			}
+		}
+	}
+
+	sortAffinities(pairs)
+	if limit > 0 && len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	return pairs
+}
+
+// Recommend suggests products the customer does not own, scored by the summed
+// confidence from each product they do own
+func (r *Recommender) Recommend(email string, limit int) []Recommendation {
+	matrix := r.analyze()
+	owned := matrix.owned[NormalizeEmail(email)]
+
+	scores := make(map[string]*Recommendation)
+	best := make(map[string]float64)
+	for product := range owned {
+		for other, both := range matrix.pairs[product] {
+			if owned[other] || both < r.MinSupport {
+				continue
+			}
+			confidence := matrix.affinity(product, other).Confidence
+			rec, ok := scores[other]
+			if !ok {
+				rec = &Recommendation{Product: other, ProductName: matrix.name(other)}
+				scores[other] = rec
+			}
+			rec.Score += confidence
+			if confidence > best[other] {
+				best[other] = confidence
+				rec.Because = matrix.name(product)
+			}
+		}
+	}
+
+	recommendations := make([]Recommendation, 0, len(scores))
+	for _, rec := range scores {
+		recommendations = append(recommendations, *rec)
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].Product < recommendations[j].Product
+	})
+	if limit > 0 && len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+	return recommendations
+}
+
+// recommendationData converts recommendations to plain maps for templates
+func recommendationData(recommendations []Recommendation) []interface{} {
+	data := make([]interface{}, 0, len(recommendations))
+	for _, rec := range recommendations {
+		data = append(data, map[string]interface{}{
+			"product":      rec.Product,
+			"product_name": rec.ProductName,
+			"because":      rec.Because,
+			"score":        rec.Score,
+		})
+	}
+	return data
+}
+
+func sortAffinities(pairs []ProductAffinity) {
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		if a.Buyers != b.Buyers {
+			return a.Buyers > b.Buyers
+		}
+		if a.Product != b.Product {
+			return a.Product < b.Product
+		}
+		return a.Other < b.Other
+	})
+}
+
+// RegisterRecommendationJobs adds the co_purchase_digest job handler. It
+// renders the co_purchase_digest template with the top args.limit pairs
+// (default 10) and queues it on the args.sink sink (default "slack").
+func RegisterRecommendationJobs(s *Scheduler, gb *GoBridge, recommender *Recommender, templates *TemplateStore) {
+	s.Handle("co_purchase_digest", func(job Job) error {
+		sinkName := stringArg(job.Args, "sink")
+		if sinkName == "" {
+			sinkName = "slack"
+		}
+		sink := gb.Sink(sinkName)
+		if sink == nil {
+			return fmt.Errorf("no sink named %s", sinkName)
+		}
+
+		limit := 10
+		if n, ok := toNumber(job.Args["limit"]); ok && n > 0 {
+			limit = int(n)
+		}
+
+		var pairs []interface{}
+		for _, pair := range recommender.TopPairs(limit) {
+			pairs = append(pairs, map[string]interface{}{
+				"product_name": pair.ProductName,
+				"other_name":   pair.OtherName,
+				"buyers":       pair.Buyers,
+				"percent":      int(pair.Confidence*100 + 0.5),
+				"lift":         fmt.Sprintf("%.1f", pair.Lift),
+			})
+		}
+
+		text, err := templates.Render("co_purchase_digest", "", map[string]interface{}{"pairs": pairs})
+		if err != nil {
+			return err
+		}
+		return sink.Enqueue(gb.NewMessage(DataSync, "go", map[string]interface{}{"text": text}, FileSystem))
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisTimeout bounds a command's round trip, beyond any time it blocks
+const redisTimeout = 10 * time.Second
+
+// redisError is an error reply from the server, such as WRONGTYPE; the
+// connection is still usable after one
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisOptions is where a Redis server is and how to log in, parsed from a
+// redis:// or rediss:// URL
+type redisOptions struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	TLS      bool
+}
+
+// parseRedisURL reads redis://[[user]:password@]host[:port][/db]; rediss://
+// connects with TLS
+func parseRedisURL(raw string) (redisOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redisOptions{}, fmt.Errorf("bad Redis URL: %v", err)
+	}
+	if (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return redisOptions{}, fmt.Errorf("bad Redis URL %q: use redis://host:port/db or rediss://", u.Redacted())
+	}
+	options := redisOptions{Addr: u.Host, TLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		options.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		options.Username = u.User.Username()
+		options.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		options.DB, err = strconv.Atoi(db)
+		if err != nil || options.DB < 0 {
+			return redisOptions{}, fmt.Errorf("bad Redis URL %q: database %q is not a number", u.Redacted(), db)
+		}
+	}
+	return options, nil
+}
+
+// redisConn is one connection speaking RESP2, enough for the stream
+// commands the Redis transport uses. It is not safe for concurrent use.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// dialRedis connects, logs in and selects the database
+func dialRedis(options redisOptions) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if options.TLS {
+		host, _, _ := net.SplitHostPort(options.Addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", options.Addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", options.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Redis at %s: %v", options.Addr, err)
+	}
+
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	if options.Password != "" {
+		args := []interface{}{"AUTH", options.Password}
+		if options.Username != "" {
+			args = []interface{}{"AUTH", options.Username, options.Password}
+		}
+		if _, err = rc.do(0, args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to log in to Redis at %s: %v", options.Addr, err)
+		}
+	}
+	if options.DB != 0 {
+		if _, err = rc.do(0, "SELECT", options.DB); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to select Redis database %d: %v", options.DB, err)
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply, allowing block on top of
+// redisTimeout for commands that wait. Replies are string for status
+// replies, int64, []byte for bulk strings, []interface{} for arrays, and nil
+// for null replies.
+func (rc *redisConn) do(block time.Duration, args ...interface{}) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(block + redisTimeout))
+	fmt.Fprintf(rc.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var value []byte
+		switch arg := arg.(type) {
+		case string:
+			value = []byte(arg)
+		case []byte:
+			value = arg
+		case int:
+			value = strconv.AppendInt(nil, int64(arg), 10)
+		case int64:
+			value = strconv.AppendInt(nil, arg, 10)
+		default:
+			return nil, fmt.Errorf("redis: cannot send a %T argument", arg)
+		}
+		fmt.Fprintf(rc.writer, "$%d\r\n", len(value))
+		rc.writer.Write(value)
+		rc.writer.WriteString("\r\n")
+	}
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+func (rc *redisConn) readReply() (interface{}, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		value := make([]byte, n+2)
+		if _, err = io.ReadFull(rc.reader, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = rc.readReply()
+			if _, failed := err.(redisError); err != nil && !failed {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+// Close closes the connection
+func (rc *redisConn) Close() error {
+	return rc.conn.Close()
+}
+
+// redisString reads a status or bulk string reply
+func redisString(reply interface{}) string {
+	switch reply := reply.(type) {
+	case string:
+		return reply
+	case []byte:
+		return string(reply)
+	}
+	return ""
+}
+
+// redisInt reads an integer reply, or a bulk string holding one
+func redisInt(reply interface{}) int64 {
+	switch reply := reply.(type) {
+	case int64:
+		return reply
+	case []byte:
+		n, _ := strconv.ParseInt(string(reply), 10, 64)
+		return n
+	}
+	return 0
+}
+
+// redisEntry is one stream entry
+type redisEntry struct {
+	ID     string
+	Fields map[string][]byte
+}
+
+// redisEntries reads a list of [id, [field, value, ...]] entries. Entries
+// trimmed from the stream come back from XCLAIM with null fields and are
+// skipped.
+func redisEntries(reply interface{}) []redisEntry {
+	items, _ := reply.([]interface{})
+	var entries []redisEntry
+	for _, item := range items {
+		pair, _ := item.([]interface{})
+		if len(pair) != 2 {
+			continue
+		}
+		values, ok := pair[1].([]interface{})
+		if !ok {
+			continue
+		}
+		entry := redisEntry{ID: redisString(pair[0]), Fields: make(map[string][]byte)}
+		for i := 0; i+1 < len(values); i += 2 {
+			value, _ := values[i+1].([]byte)
+			entry.Fields[redisString(values[i])] = value
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
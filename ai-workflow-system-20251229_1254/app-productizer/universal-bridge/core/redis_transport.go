@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRedisPrefix = "bridge:"
+
+// RedisTransport carries messages between bridges on different hosts over
+// Redis streams, one per language. Send XADDs a message to its target's
+// stream; each bridge reads its own through a consumer group and XACKs what
+// it handled. Messages wait in Redis while no bridge of their language
+// runs, and bridges of one language sharing a group split the stream
+// between them. An entry nacked, or held by a bridge that died, is claimed
+// and delivered again once it has gone ClaimIdle without an ack.
+type RedisTransport struct {
+	// Prefix is put before the language to name its stream
+	Prefix string
+	// Group is the consumer group this bridge reads in, shared by bridges of
+	// its language; the language by default
+	Group string
+	// Consumer names this bridge in its group; host name and process ID by
+	// default
+	Consumer string
+	// MaxLen caps each stream, trimming its oldest entries approximately
+	MaxLen int64
+	// Block is how long a read waits for new entries
+	Block time.Duration
+	// BatchSize is how many entries a read takes
+	BatchSize int
+	// ClaimIdle is how long an entry goes unacked before it is delivered
+	// again. Entries this bridge is still handling are kept fresh.
+	ClaimIdle time.Duration
+	// MaxDeliveries is how many times an entry is delivered before it is
+	// moved to the stream's ":dead" stream and acked
+	MaxDeliveries int64
+	// PublishChannel, when set, also PUBLISHes every sent message there,
+	// for listeners such as dashboards that can afford to miss some
+	PublishChannel string
+	// Retries is how many times a send that lost the connection is retried
+	Retries int
+	// Serializer encodes sent messages; nil is JSON
+	Serializer Serializer
+
+	options  redisOptions
+	language string
+	clock    Clock
+
+	sendMu   sync.Mutex
+	sendConn *redisConn
+
+	mu       sync.Mutex
+	readConn *redisConn
+	inFlight map[string]bool // entry IDs delivered and not yet acked or nacked
+	running  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	lag      int64
+}
+
+// NewRedisTransport creates a transport on the Redis server at rawURL that
+// receives the language's stream
+func NewRedisTransport(rawURL, language string, clock Clock) (*RedisTransport, error) {
+	options, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if clock == nil {
+		clock = defaultClock
+	}
+	host, _ := os.Hostname()
+	return &RedisTransport{
+		Prefix:        defaultRedisPrefix,
+		Group:         language,
+		Consumer:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+		MaxLen:        100000,
+		Block:         2 * time.Second,
+		BatchSize:     64,
+		ClaimIdle:     time.Minute,
+		MaxDeliveries: 10,
+		Retries:       3,
+		options:       options,
+		language:      language,
+		clock:         clock,
+		inFlight:      make(map[string]bool),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}, nil
+}
+
+// Channel returns Database
+func (rt *RedisTransport) Channel() CommunicationChannel {
+	return Database
+}
+
+func (rt *RedisTransport) stream(language string) string {
+	return rt.Prefix + language
+}
+
+// Start reads the bridge's stream in the background, reconnecting with
+// backoff whenever the connection is lost
+func (rt *RedisTransport) Start(deliver func(*Envelope)) error {
+	rt.mu.Lock()
+	rt.running = true
+	rt.mu.Unlock()
+	fmt.Printf("🧱 Redis transport reading %s as %s/%s on %s\n", rt.stream(rt.language), rt.Group, rt.Consumer, rt.options.Addr)
+	go rt.receive(deliver)
+	return nil
+}
+
+func (rt *RedisTransport) stopping() bool {
+	select {
+	case <-rt.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rt *RedisTransport) receive(deliver func(*Envelope)) {
+	defer close(rt.stopped)
+	delay := time.Second
+	for !rt.stopping() {
+		conn, err := dialRedis(rt.options)
+		if err == nil {
+			rt.mu.Lock()
+			rt.readConn = conn
+			rt.mu.Unlock()
+			err = rt.read(conn, deliver, func() { delay = time.Second })
+			conn.Close()
+		}
+		if rt.stopping() {
+			return
+		}
+		log.Printf("❌ Redis transport lost %s, reconnecting in %s: %v", rt.options.Addr, delay, err)
+		select {
+		case <-rt.stop:
+			return
+		case <-rt.clock.After(delay):
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// read creates the group if it is missing, then delivers new entries and
+// reclaims idle ones until the connection fails or receiving stops
+func (rt *RedisTransport) read(conn *redisConn, deliver func(*Envelope), connected func()) error {
+	stream := rt.stream(rt.language)
+	// From the start of the stream, so messages sent before any bridge of
+	// this language ran are not lost
+	_, err := conn.do(0, "XGROUP", "CREATE", stream, rt.Group, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(errString(err), "BUSYGROUP") {
+		return err
+	}
+	connected()
+
+	var lastClaim time.Time
+	for !rt.stopping() {
+		if now := rt.clock.Now(); now.Sub(lastClaim) >= rt.ClaimIdle/2 {
+			lastClaim = now
+			if err = rt.reclaim(conn, deliver); err != nil {
+				return err
+			}
+		}
+		reply, err := conn.do(rt.Block, "XREADGROUP", "GROUP", rt.Group, rt.Consumer, "COUNT", rt.BatchSize,
+			"BLOCK", int64(rt.Block/time.Millisecond), "STREAMS", stream, ">")
+		if err != nil {
+			return err
+		}
+		// A null reply is a read that timed out with nothing new
+		streams, _ := reply.([]interface{})
+		for _, item := range streams {
+			pair, _ := item.([]interface{})
+			if len(pair) == 2 {
+				for _, entry := range redisEntries(pair[1]) {
+					rt.deliverEntry(entry, deliver)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// errString is a Redis error reply's text, or empty for other errors
+func errString(err error) string {
+	var reply redisError
+	if errors.As(err, &reply) {
+		return string(reply)
+	}
+	return ""
+}
+
+// reclaim keeps the entries this bridge is handling from going idle, then
+// claims entries idle for ClaimIdle, whoever held them, dead-lettering those
+// delivered MaxDeliveries times
+func (rt *RedisTransport) reclaim(conn *redisConn, deliver func(*Envelope)) error {
+	stream := rt.stream(rt.language)
+	rt.mu.Lock()
+	held := make([]interface{}, 0, len(rt.inFlight))
+	for id := range rt.inFlight {
+		held = append(held, id)
+	}
+	rt.mu.Unlock()
+	if len(held) > 0 {
+		args := append([]interface{}{"XCLAIM", stream, rt.Group, rt.Consumer, 0}, held...)
+		if _, err := conn.do(0, append(args, "JUSTID")...); err != nil {
+			return err
+		}
+	}
+
+	reply, err := conn.do(0, "XPENDING", stream, rt.Group, "-", "+", rt.BatchSize)
+	if err != nil {
+		return err
+	}
+	idle := rt.ClaimIdle.Milliseconds()
+	var claim []interface{}
+	for _, item := range reply.([]interface{}) {
+		// [id, consumer, idle milliseconds, deliveries]
+		pending, _ := item.([]interface{})
+		if len(pending) == 4 && redisInt(pending[2]) >= idle {
+			claim = append(claim, redisString(pending[0]))
+		}
+	}
+	if len(claim) == 0 {
+		return nil
+	}
+	reply, err = conn.do(0, append([]interface{}{"XCLAIM", stream, rt.Group, rt.Consumer, idle}, claim...)...)
+	if err != nil {
+		return err
+	}
+	entries := redisEntries(reply)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// XCLAIM counted this delivery
+	counts := make(map[string]int64)
+	reply, err = conn.do(0, "XPENDING", stream, rt.Group, entries[0].ID, entries[len(entries)-1].ID, len(entries), rt.Consumer)
+	if err != nil {
+		return err
+	}
+	for _, item := range reply.([]interface{}) {
+		if pending, _ := item.([]interface{}); len(pending) == 4 {
+			counts[redisString(pending[0])] = redisInt(pending[3])
+		}
+	}
+	for _, entry := range entries {
+		if rt.MaxDeliveries > 0 && counts[entry.ID] > rt.MaxDeliveries {
+			if err = rt.bury(conn, entry, counts[entry.ID]-1); err != nil {
+				return err
+			}
+			continue
+		}
+		rt.deliverEntry(entry, deliver)
+	}
+	return nil
+}
+
+// bury moves an entry that keeps failing to the dead stream and acks it
+func (rt *RedisTransport) bury(conn *redisConn, entry redisEntry, deliveries int64) error {
+	stream := rt.stream(rt.language)
+	args := []interface{}{"XADD", stream + ":dead", "MAXLEN", "~", rt.MaxLen, "*", "original_id", entry.ID, "deliveries", deliveries}
+	for name, value := range entry.Fields {
+		args = append(args, name, value)
+	}
+	if _, err := conn.do(0, args...); err != nil {
+		return err
+	}
+	if _, err := conn.do(0, "XACK", stream, rt.Group, entry.ID); err != nil {
+		return err
+	}
+	log.Printf("❌ Moved %s to %s:dead after %d failed deliveries", entry.ID, stream, deliveries)
+	return nil
+}
+
+// deliverEntry hands an entry to the bridge; ack XACKs it, nack leaves it
+// pending to be claimed again
+func (rt *RedisTransport) deliverEntry(entry redisEntry, deliver func(*Envelope)) {
+	stream := rt.stream(rt.language)
+	if ms, err := strconv.ParseInt(strings.SplitN(entry.ID, "-", 2)[0], 10, 64); err == nil {
+		atomic.StoreInt64(&rt.lag, int64(rt.clock.Now().Sub(time.UnixMilli(ms))))
+	}
+
+	rt.mu.Lock()
+	if rt.inFlight[entry.ID] {
+		rt.mu.Unlock()
+		return
+	}
+	rt.inFlight[entry.ID] = true
+	rt.mu.Unlock()
+	done := func() {
+		rt.mu.Lock()
+		delete(rt.inFlight, entry.ID)
+		rt.mu.Unlock()
+	}
+
+	data := entry.Fields["message"]
+	if data == nil {
+		// Not a message, so nothing will ever handle it
+		log.Printf("❌ Skipping %s entry %s without a message field", stream, entry.ID)
+		rt.command("XACK", stream, rt.Group, entry.ID)
+		done()
+		return
+	}
+	envelope := NewEnvelope(Database, "redis:"+stream+"/"+entry.ID, data, func() error {
+		defer done()
+		_, err := rt.command("XACK", stream, rt.Group, entry.ID)
+		return err
+	}, func(error) {
+		done()
+	})
+	envelope.ContentType = string(entry.Fields["content_type"])
+	deliver(envelope)
+}
+
+// Lag returns how long the last entry read had been in the stream
+func (rt *RedisTransport) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&rt.lag))
+}
+
+// command runs a command on the connection sends and acks share, dialing
+// again after it fails
+func (rt *RedisTransport) command(args ...interface{}) (interface{}, error) {
+	rt.sendMu.Lock()
+	defer rt.sendMu.Unlock()
+	if rt.sendConn == nil {
+		conn, err := dialRedis(rt.options)
+		if err != nil {
+			return nil, err
+		}
+		rt.sendConn = conn
+	}
+	reply, err := rt.sendConn.do(0, args...)
+	if _, failed := err.(redisError); err != nil && !failed {
+		rt.sendConn.Close()
+		rt.sendConn = nil
+	}
+	return reply, err
+}
+
+// Send XADDs the message to its target language's stream
+func (rt *RedisTransport) Send(message *UniversalMessage) error {
+	serializer := rt.Serializer
+	if serializer == nil {
+		serializer = JSONSerializer{}
+	}
+	body, err := serializer.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", message.ID, err)
+	}
+	stream := rt.stream(message.TargetLanguage)
+	args := []interface{}{"XADD", stream, "MAXLEN", "~", rt.MaxLen, "*", "message", body}
+	if contentType := serializer.ContentType(); contentType != JSONContentType {
+		args = append(args, "content_type", contentType)
+	}
+
+	// Error replies such as WRONGTYPE will not go away by retrying, except
+	// from a server still loading its data or moving the stream's slot
+	retry := retrier{Retries: rt.Retries, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second, Clock: rt.clock,
+		Classify: func(err error) bool {
+			reply := errString(err)
+			return reply == "" || strings.HasPrefix(reply, "LOADING") || strings.HasPrefix(reply, "TRYAGAIN")
+		}}
+	err = retry.do("Send of "+message.ID, func() error {
+		_, err := rt.command(args...)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("sending %s to %s: %v", message.ID, stream, err)
+	}
+	if rt.PublishChannel != "" {
+		if _, err = rt.command("PUBLISH", rt.PublishChannel, body); err != nil {
+			fmt.Printf("⚠️ Failed to publish %s on %s: %v\n", message.ID, rt.PublishChannel, err)
+		}
+	}
+	return nil
+}
+
+// StopReceiving stops reading new entries; acks still go through until
+// Close
+func (rt *RedisTransport) StopReceiving() {
+	rt.stopOnce.Do(func() { close(rt.stop) })
+	rt.mu.Lock()
+	running, conn := rt.running, rt.readConn
+	rt.mu.Unlock()
+	if !running {
+		return
+	}
+	// Cut a blocked read short
+	if conn != nil {
+		conn.Close()
+	}
+	<-rt.stopped
+}
+
+// Close stops receiving and leaves the group if this bridge holds nothing,
+// so consumers of past runs do not pile up
+func (rt *RedisTransport) Close() error {
+	rt.StopReceiving()
+	rt.mu.Lock()
+	running, holding := rt.running, len(rt.inFlight) > 0
+	rt.mu.Unlock()
+	if running && !holding {
+		stream := rt.stream(rt.language)
+		reply, err := rt.command("XPENDING", stream, rt.Group, "-", "+", 1, rt.Consumer)
+		if items, _ := reply.([]interface{}); err == nil && len(items) == 0 {
+			rt.command("XGROUP", "DELCONSUMER", stream, rt.Group, rt.Consumer)
+		}
+	}
+
+	rt.sendMu.Lock()
+	defer rt.sendMu.Unlock()
+	if rt.sendConn != nil {
+		rt.sendConn.Close()
+		rt.sendConn = nil
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Reply answers a received request over the channel the requester asked
+// for in its ResponseChannel, so a request dropped in as a file can be
+// answered over HTTP. When the bridge has no transport for that channel, or
+// sending on it fails, the reply falls back to the channel the request
+// arrived on and then to the default transport. The reply's payload carries
+// original_message_id, as the Python bridge's replies do.
+func (gb *GoBridge) Reply(request *UniversalMessage, messageType MessageType, payload map[string]interface{}) (string, error) {
+	payload = mergePayload(payload, map[string]interface{}{"original_message_id": request.ID})
+	reply := gb.NewMessage(messageType, request.SourceLanguage, payload, request.ResponseChannel)
+	// The reply continues the request's trace, under the handler's span when
+	// this bridge traces and straight under the peer's when it does not
+	parent := request.span.SpanContext()
+	if !parent.IsValid() {
+		parent = request.traceContext()
+	}
+	reply.setTraceContext(parent)
+	if state := request.Headers[tracestateHeader]; state != "" && parent.IsValid() {
+		reply.Headers[tracestateHeader] = state
+	}
+
+	var err error
+	for i, transport := range gb.replyRoute(request) {
+		if i > 0 {
+			log.Printf("❌ Reply %s to %s failed, falling back to %s: %v", reply.ID, request.ID, transport.Channel(), err)
+		}
+		// Whoever receives the reply should answer it the same way it came
+		reply.ResponseChannel = transport.Channel()
+		var id string
+		id, err = gb.sendOn(transport, reply)
+		if err == nil {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("reply to %s: %v", request.ID, err)
+}
+
+// replyRoute lists the transports to try for a reply, in order, without
+// repeats: the requested channel, the arrival channel, the default transport
+func (gb *GoBridge) replyRoute(request *UniversalMessage) []Transport {
+	var route []Transport
+	add := func(transport Transport) {
+		for _, existing := range route {
+			if existing == transport {
+				return
+			}
+		}
+		route = append(route, transport)
+	}
+
+	for _, channel := range []CommunicationChannel{request.ResponseChannel, request.receivedOn} {
+		if transport, ok := gb.channelTransport(channel); ok {
+			add(transport)
+		}
+	}
+	add(gb.transport)
+	return route
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// retrier runs a call again when it fails in a way Classify says is worth
+// retrying, up to Retries times, waiting Backoff and doubling the wait up
+// to MaxBackoff
+type retrier struct {
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Clock      Clock
+	// Classify reports whether a failed attempt should be retried
+	Classify func(error) bool
+}
+
+// do makes the call, retrying it as the retrier allows, and returns the
+// last attempt's error. What names the call in the log.
+func (r retrier) do(what string, call func() error) error {
+	delay := r.Backoff
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if err == nil || !r.Classify(err) || attempt >= r.Retries {
+			return err
+		}
+
+		log.Printf("❌ %s failed, retrying in %s: %v", what, delay, err)
+		<-r.Clock.After(delay)
+		delay *= 2
+		if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+			delay = r.MaxBackoff
+		}
+	}
+}
+
+// apiError is a non-2xx answer from an HTTP API
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// readAPIError describes a non-2xx response as "<service> answered
+// <status>", followed by the start of the body
+func readAPIError(service string, response *http.Response) *apiError {
+	err := &apiError{StatusCode: response.StatusCode, Message: service + " answered " + response.Status}
+	detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	if text := strings.TrimSpace(string(detail)); text != "" {
+		err.Message += ": " + text
+	}
+	return err
+}
+
+// finalError marks a failure that retrying cannot fix, such as a request
+// that could not be built or a response that could not be decoded
+type finalError struct {
+	err error
+}
+
+func (e finalError) Error() string {
+	return e.err.Error()
+}
+
+func (e finalError) Unwrap() error {
+	return e.err
+}
+
+// final marks err as not worth retrying
+func final(err error) error {
+	return finalError{err}
+}
+
+// retryTransient retries network errors, 429s and 5xx responses
+func retryTransient(err error) bool {
+	var status *apiError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	return !errors.As(err, new(finalError))
+}
+
+// retryGoogle retries what retryTransient does and 401s, as Google APIs
+// answer a revoked or expired token; the caller invalidates the token so
+// the retry fetches a new one
+func retryGoogle(err error) bool {
+	var status *apiError
+	if errors.As(err, &status) && status.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	return retryTransient(err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryClassify(t *testing.T) {
+	network := errors.New("connection refused")
+	status := func(code int) error { return &apiError{StatusCode: code, Message: http.StatusText(code)} }
+	tests := []struct {
+		name                        string
+		err                         error
+		transient, google, delivery bool
+	}{
+		{"network error", network, true, true, true},
+		{"final error", final(network), false, false, false},
+		{"429", status(http.StatusTooManyRequests), true, true, true},
+		{"503", status(http.StatusServiceUnavailable), true, true, true},
+		{"400", status(http.StatusBadRequest), false, false, false},
+		{"401", status(http.StatusUnauthorized), false, true, false},
+		{"409", status(http.StatusConflict), false, false, true},
+	}
+	for _, tt := range tests {
+		if got := retryTransient(tt.err); got != tt.transient {
+			t.Errorf("retryTransient(%s) = %t, want %t", tt.name, got, tt.transient)
+		}
+		if got := retryGoogle(tt.err); got != tt.google {
+			t.Errorf("retryGoogle(%s) = %t, want %t", tt.name, got, tt.google)
+		}
+		if got := retryDelivery(tt.err); got != tt.delivery {
+			t.Errorf("retryDelivery(%s) = %t, want %t", tt.name, got, tt.delivery)
+		}
+	}
+}
+
+// waitRecorder is a clock whose waits end at once, recording how long
+// each was
+type waitRecorder struct {
+	*ManualClock
+	waits []time.Duration
+}
+
+func (c *waitRecorder) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.Advance(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.Now()
+	return fired
+}
+
+func TestRetrierBacksOff(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int // attempts that fail before one succeeds
+		retryable bool
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{"succeeds at once", 0, true, 1, nil, false},
+		{"succeeds on a retry", 2, true, 3, []time.Duration{time.Second, 2 * time.Second}, false},
+		{"runs out of retries", 10, true, 4, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, true},
+		{"not retryable", 10, false, 1, nil, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &waitRecorder{ManualClock: NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))}
+			retry := retrier{
+				Retries:    3,
+				Backoff:    time.Second,
+				MaxBackoff: 3 * time.Second,
+				Clock:      clock,
+				Classify:   func(error) bool { return tt.retryable },
+			}
+			calls := 0
+			err := retry.do("test call", func() error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			if (err != nil) != tt.wantErr || calls != tt.wantCalls {
+				t.Fatalf("do = %v after %d calls, want error %t after %d", err, calls, tt.wantErr, tt.wantCalls)
+			}
+			if len(clock.waits) != len(tt.wantWaits) {
+				t.Fatalf("waited %v, want %v", clock.waits, tt.wantWaits)
+			}
+			for i := range clock.waits {
+				if clock.waits[i] != tt.wantWaits[i] {
+					t.Fatalf("waited %v, want %v", clock.waits, tt.wantWaits)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reviewProducts caps the products a weekly review tells the AI about
+const reviewProducts = 10
+
+// reviewInstructions is the system prompt of a weekly review
+const reviewInstructions = `You write the weekly business review of a small online store for its owner.
+From the figures given, write three short paragraphs of plain text: how revenue and profit moved and which products drove it; where refunds are concentrated, if anywhere; and one or two concrete suggestions for the coming week.
+Quote only the figures given and do not work out new ones. Do not use headings or lists.`
+
+// BusinessReview writes the weekly business review: a week's sales,
+// refunds and profit set against the week before's, told by an AI provider
+type BusinessReview struct {
+	// AI writes the narrative; without it a review is the figures alone
+	AI       *AIServer
+	Provider string // empty for the AI server's default
+
+	expenses *Expenses
+}
+
+// ReviewFigures are what a weekly review is written from
+type ReviewFigures struct {
+	This     ProfitReport
+	Last     ProfitReport  // the seven days before
+	Products []ProductWeek // by sales this week, most first
+}
+
+// ProductWeek is one product's week, against the week before
+type ProductWeek struct {
+	Product     string
+	Name        string
+	Sales       int
+	LastSales   int
+	Revenue     []Money // less refunds made in the week
+	LastRevenue []Money
+	Refunds     int
+	Refunded    []Money
+}
+
+// NewBusinessReview reviews the sales and expenses the store knows, in
+// its timezone
+func NewBusinessReview(expenses *Expenses, ai *AIServer) *BusinessReview {
+	return &BusinessReview{AI: ai, expenses: expenses}
+}
+
+// Figures gathers the seven days from from, 2006-01-02, and the seven
+// before them
+func (br *BusinessReview) Figures(from string) (ReviewFigures, error) {
+	start, err := time.ParseInLocation(expenseDate, from, br.expenses.Location)
+	if err != nil {
+		return ReviewFigures{}, fmt.Errorf("from %q is not like 2026-01-31", from)
+	}
+	lastStart, end := start.AddDate(0, 0, -7), start.AddDate(0, 0, 7)
+
+	var figures ReviewFigures
+	figures.This, err = br.expenses.Profit(from, end.AddDate(0, 0, -1).Format(expenseDate))
+	if err != nil {
+		return ReviewFigures{}, err
+	}
+	figures.Last, err = br.expenses.Profit(lastStart.Format(expenseDate), start.AddDate(0, 0, -1).Format(expenseDate))
+	if err != nil {
+		return ReviewFigures{}, err
+	}
+
+	in := func(at, from, to time.Time) bool { return !at.Before(from) && at.Before(to) }
+	type week struct {
+		ProductWeek
+		revenue, lastRevenue, refunded MoneyTotals
+	}
+	weeks := make(map[string]*week)
+	for _, sale := range br.expenses.sales.All() {
+		if sale.IsBundle() || sale.CreatedAt.IsZero() {
+			continue
+		}
+		w := weeks[sale.Product]
+		if w == nil {
+			w = &week{ProductWeek: ProductWeek{Product: sale.Product}, revenue: MoneyTotals{}, lastRevenue: MoneyTotals{}, refunded: MoneyTotals{}}
+			weeks[sale.Product] = w
+		}
+		if sale.ProductName != "" {
+			w.Name = sale.ProductName
+		}
+		switch {
+		case in(sale.CreatedAt, start, end):
+			w.Sales++
+			w.revenue.Add(sale.Price)
+		case in(sale.CreatedAt, lastStart, start):
+			w.LastSales++
+			w.lastRevenue.Add(sale.Price)
+		}
+		if amount, at, ok := refundOf(sale); ok {
+			taken := Money{Amount: -amount.Amount, Currency: amount.Currency}
+			switch {
+			case in(at, start, end):
+				w.Refunds++
+				w.refunded.Add(amount)
+				w.revenue.Add(taken)
+			case in(at, lastStart, start):
+				w.lastRevenue.Add(taken)
+			}
+		}
+	}
+	for _, w := range weeks {
+		if w.Sales == 0 && w.LastSales == 0 && w.Refunds == 0 {
+			continue
+		}
+		w.Revenue, w.LastRevenue, w.Refunded = w.revenue.List(), w.lastRevenue.List(), w.refunded.List()
+		figures.Products = append(figures.Products, w.ProductWeek)
+	}
+	sort.Slice(figures.Products, func(i, j int) bool {
+		a, b := figures.Products[i], figures.Products[j]
+		if a.Sales != b.Sales {
+			return a.Sales > b.Sales
+		}
+		if a.Refunds != b.Refunds {
+			return a.Refunds > b.Refunds
+		}
+		return a.Product < b.Product
+	})
+	return figures, nil
+}
+
+// Text lays the figures out for the AI, and for a review without one.
+// Changes are worked out here so the narrative need not do arithmetic.
+func (f ReviewFigures) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Week %s to %s, against %s to %s\n", f.This.From, f.This.To, f.Last.From, f.Last.To)
+	fmt.Fprintf(&b, "Revenue: %s (%s)\n", amountsText(f.This.Revenue), changeText(f.This.Revenue, f.Last.Revenue))
+	fmt.Fprintf(&b, "Sales: %d (%d the week before)\n", f.This.Sales, f.Last.Sales)
+	fmt.Fprintf(&b, "Refunds: %d for %s (%d the week before)\n", f.This.Refunds, amountsText(f.This.Refunded), f.Last.Refunds)
+	fmt.Fprintf(&b, "Expenses: %s (%s)\n", amountsText(f.This.Expenses), changeText(f.This.Expenses, f.Last.Expenses))
+	fmt.Fprintf(&b, "Net profit: %s (%s)\n", amountsText(f.This.NetProfit), changeText(f.This.NetProfit, f.Last.NetProfit))
+
+	products := f.Products
+	if len(products) > reviewProducts {
+		products = products[:reviewProducts]
+	}
+	if len(products) > 0 {
+		b.WriteString("Products:\n")
+	}
+	for _, p := range products {
+		name := p.Product
+		if p.Name != "" {
+			name = p.Name
+		}
+		fmt.Fprintf(&b, "- %s: sales %d (%d the week before), revenue %s (%s)", name, p.Sales, p.LastSales, amountsText(p.Revenue), changeText(p.Revenue, p.LastRevenue))
+		if p.Refunds > 0 {
+			fmt.Fprintf(&b, ", refunds %d for %s (%d%% of the week's)", p.Refunds, amountsText(p.Refunded), int(math.Round(100*float64(p.Refunds)/float64(f.This.Refunds))))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// changeText describes how amounts moved from last, per currency, e.g.
+// "up 12% from 98.00 USD"
+func changeText(this, last []Money) string {
+	totals := make(map[string][2]int64)
+	for _, m := range this {
+		t := totals[m.Currency]
+		t[0] = m.Amount
+		totals[m.Currency] = t
+	}
+	for _, m := range last {
+		t := totals[m.Currency]
+		t[1] = m.Amount
+		totals[m.Currency] = t
+	}
+	if len(totals) == 0 {
+		return "none either week"
+	}
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	changes := make([]string, len(currencies))
+	for i, currency := range currencies {
+		now, before := totals[currency][0], totals[currency][1]
+		from := NewMoney(before, currency).String()
+		switch {
+		case now == 0 && before == 0:
+			changes[i] = "none either week"
+		case before == 0:
+			changes[i] = "from none"
+		case now == before:
+			changes[i] = "unchanged from " + from
+		default:
+			percent := math.Round(100 * float64(now-before) / math.Abs(float64(before)))
+			direction := "up"
+			if percent < 0 {
+				direction, percent = "down", -percent
+			}
+			changes[i] = fmt.Sprintf("%s %.0f%% from %s", direction, percent, from)
+		}
+	}
+	return strings.Join(changes, "; ")
+}
+
+// Write asks the AI for the narrative of the figures
+func (br *BusinessReview) Write(figures ReviewFigures) (string, error) {
+	if br.AI == nil {
+		return "", fmt.Errorf("no AI provider; start the bridge with -ai")
+	}
+	result, err := br.AI.Generate(br.Provider, AIPrompt{Prompt: figures.Text(), Instructions: reviewInstructions})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// RegisterReviewJobs adds the weekly_review job handler. It reviews the
+// seven days up to yesterday, renders the weekly_review template with the
+// narrative and the figures, and queues it on the args.sink sink (default
+// "slack"). args.provider picks the AI provider. When the AI fails the
+// figures are sent alone rather than not at all.
+func RegisterReviewJobs(s *Scheduler, gb *GoBridge, br *BusinessReview, templates *TemplateStore) {
+	s.Handle("weekly_review", func(job Job) error {
+		sinkName := stringArg(job.Args, "sink")
+		if sinkName == "" {
+			sinkName = "slack"
+		}
+		sink := gb.Sink(sinkName)
+		if sink == nil {
+			return fmt.Errorf("no sink named %s", sinkName)
+		}
+
+		today := gb.clock.Now().In(br.expenses.Location)
+		figures, err := br.Figures(today.AddDate(0, 0, -7).Format(expenseDate))
+		if err != nil {
+			return err
+		}
+		reviewer := *br
+		if provider := stringArg(job.Args, "provider"); provider != "" {
+			reviewer.Provider = provider
+		}
+		narrative, err := reviewer.Write(figures)
+		if err != nil {
+			log.Printf("❌ Weekly review without a narrative: %v", err)
+		}
+
+		text, err := templates.Render("weekly_review", "", map[string]interface{}{
+			"from":      figures.This.From,
+			"to":        figures.This.To,
+			"narrative": narrative,
+			"figures":   strings.TrimSpace(figures.Text()),
+		})
+		if err != nil {
+			return err
+		}
+		return sink.Enqueue(gb.NewMessage(DataSync, "go", map[string]interface{}{"text": text}, FileSystem))
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reviewProvider writes the same narrative for every prompt it keeps
+type reviewProvider struct {
+	prompts []AIPrompt
+}
+
+func (p *reviewProvider) Name() string { return "anthropic" }
+
+func (p *reviewProvider) Generate(prompt AIPrompt) (AIResult, error) {
+	p.prompts = append(p.prompts, prompt)
+	return AIResult{Text: "  The course carried the week.\n", Provider: "anthropic", Model: "haiku"}, nil
+}
+
+func TestWeeklyReview(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 19, 8, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var digests []string
+	gb.AddSink("slack", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		digests = append(digests, stringArg(message.Payload, "text"))
+		return message
+	})
+
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []struct {
+		id, product, name string
+		price             float64
+		at                string
+	}{
+		{"c1", "course", "Course", 100, "2026-01-13T12:00:00Z"},
+		{"c2", "course", "Course", 100, "2026-01-15T12:00:00Z"},
+		{"c3", "course", "Course", 100, "2026-01-06T12:00:00Z"}, // the week before
+		{"t1", "templates", "Templates", 20, "2026-01-14T12:00:00Z"},
+		{"t2", "templates", "Templates", 20, "2026-01-16T12:00:00Z"},
+		{"e1", "ebook", "Ebook", 15, "2026-01-07T12:00:00Z"}, // refunded this week
+	} {
+		_, err := sales.Record(map[string]interface{}{"sale_id": sale.id, "product_id": sale.product, "product_name": sale.name,
+			"price": sale.price, "currency": "usd", "email": sale.id + "@example.com", "sale_timestamp": sale.at})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, refund := range []Refund{
+		{SaleID: "t1", Amount: NewMoney(2000, "usd"), RefundedAt: time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)},
+		{SaleID: "t2", Amount: NewMoney(2000, "usd"), RefundedAt: time.Date(2026, 1, 18, 12, 0, 0, 0, time.UTC)},
+		{SaleID: "e1", Amount: NewMoney(1500, "usd"), RefundedAt: time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)},
+	} {
+		if err := sales.Refund(refund); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expenses, err := NewExpenses(gb, sales, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &reviewProvider{}
+	review := NewBusinessReview(expenses, NewAIServer(gb, provider))
+	figures, err := review.Figures("2026-01-12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := figures.Text()
+	for _, line := range []string{
+		"Week 2026-01-12 to 2026-01-18, against 2026-01-05 to 2026-01-11\n",
+		"Revenue: 185.00 USD (up 61% from 115.00 USD)\n",
+		"Refunds: 3 for 55.00 USD (0 the week before)\n",
+		"- Course: sales 2 (1 the week before), revenue 200.00 USD (up 100% from 100.00 USD)\n",
+		"- Templates: sales 2 (0 the week before), revenue 0.00 USD (none either week), refunds 2 for 40.00 USD (67% of the week's)\n",
+		"- Ebook: sales 0 (1 the week before), revenue -15.00 USD (down 200% from 15.00 USD), refunds 1 for 15.00 USD (33% of the week's)\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("figures lack %q:\n%s", line, text)
+		}
+	}
+
+	scheduler := NewScheduler("", clock)
+	RegisterReviewJobs(scheduler, gb, review, NewTemplateStore("templates"))
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{"narrative", map[string]interface{}{}, "*Weekly review, 2026-01-12 to 2026-01-18*\nThe course carried the week.", false},
+		{"figures when the AI fails", map[string]interface{}{"provider": "bard"}, "Revenue: 185.00 USD (up 61% from 115.00 USD)", false},
+		{"unknown sink", map[string]interface{}{"sink": "discord"}, "", true},
+	}
+	for _, tt := range tests {
+		digests = nil
+		err := scheduler.handlers["weekly_review"](Job{Args: tt.args})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := fmt.Sprint(digests); !strings.Contains(got, tt.want) || (tt.want == "") != (len(digests) == 0) {
+			t.Errorf("%s: digests %q, want one with %q", tt.name, digests, tt.want)
+		}
+	}
+	if len(provider.prompts) != 1 || provider.prompts[0].Instructions != reviewInstructions || provider.prompts[0].Prompt != text {
+		t.Errorf("prompts %+v", provider.prompts)
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleSet is a list of trigger → condition → action rules loaded from YAML:
+//
+//	rules:
+//	  - name: big-sale
+//	    on: sale
+//	    when:
+//	      - field: product
+//	        equals: ebook
+//	      - field: price
+//	        gt: 50
+//	      - segment: whales
+//	    do:
+//	      - sink: slack
+//	      - sink: sheets
+//	      - send: {type: function_call, target: python, payload: {function_name: issue_license}}
+//	      - sink: email
+//	        template: bonus
+//	        after: 72h
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule runs its actions for every message of type On whose payload
+// satisfies all of its conditions
+type Rule struct {
+	Name string          `yaml:"name"`
+	On   MessageType     `yaml:"on"`
+	When []RuleCondition `yaml:"when"`
+	Do   []RuleAction    `yaml:"do"`
+}
+
+// RuleCondition compares the payload value at a dotted field path
+type RuleCondition struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// ruleOperators are the comparisons a condition may use
+var ruleOperators = map[string]func(actual interface{}, present bool, expected interface{}) bool{
+	"equals":     func(a interface{}, ok bool, e interface{}) bool { return ok && ruleEqual(a, e) },
+	"not_equals": func(a interface{}, ok bool, e interface{}) bool { return !ok || !ruleEqual(a, e) },
+	"gt":         ruleOrdering(func(c int) bool { return c > 0 }),
+	"gte":        ruleOrdering(func(c int) bool { return c >= 0 }),
+	"lt":         ruleOrdering(func(c int) bool { return c < 0 }),
+	"lte":        ruleOrdering(func(c int) bool { return c <= 0 }),
+	"contains": func(a interface{}, ok bool, e interface{}) bool {
+		return ok && strings.Contains(fmt.Sprint(a), fmt.Sprint(e))
+	},
+	"in": func(a interface{}, ok bool, e interface{}) bool {
+		list, isList := e.([]interface{})
+		if !ok || !isList {
+			return false
+		}
+		for _, candidate := range list {
+			if ruleEqual(a, candidate) {
+				return true
+			}
+		}
+		return false
+	},
+	"includes": func(a interface{}, ok bool, e interface{}) bool {
+		list, isList := a.([]interface{})
+		if !ok || !isList {
+			return false
+		}
+		for _, item := range list {
+			if ruleEqual(item, e) {
+				return true
+			}
+		}
+		return false
+	},
+	"exists": func(a interface{}, ok bool, e interface{}) bool { return ok == (e != false) },
+}
+
+// UnmarshalYAML reads a condition written as {field: path, <operator>: value},
+// or as {segment: name}, which holds when the message's customer is in the
+// segment
+func (c *RuleCondition) UnmarshalYAML(node *yaml.Node) error {
+	var raw map[string]interface{}
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+
+	if segment, ok := raw["segment"].(string); ok && len(raw) == 1 {
+		c.Field, c.Op, c.Value = "segments", "includes", segment
+		return nil
+	}
+	field, _ := raw["field"].(string)
+	if field == "" {
+		return fmt.Errorf("line %d: condition needs a field", node.Line)
+	}
+	delete(raw, "field")
+	if len(raw) != 1 {
+		return fmt.Errorf("line %d: condition on %s needs exactly one operator", node.Line, field)
+	}
+
+	for op, value := range raw {
+		if _, ok := ruleOperators[op]; !ok {
+			return fmt.Errorf("line %d: unknown operator %q", node.Line, op)
+		}
+		c.Field, c.Op, c.Value = field, op, value
+	}
+	return nil
+}
+
+// Matches reports whether the message payload satisfies the condition
+func (c RuleCondition) Matches(payload map[string]interface{}) bool {
+	actual, present := lookupField(payload, c.Field)
+	return ruleOperators[c.Op](actual, present, c.Value)
+}
+
+// RuleAction is one step of a rule; exactly one field is set
+type RuleAction struct {
+	Sink string                 `yaml:"sink"` // queue the message on a registered sink
+	Send map[string]interface{} `yaml:"send"` // send a message, with the scenario send arguments
+	Log  string                 `yaml:"log"`  // print a line, for trying rules out
+
+	// Template renders with the payload and is added to the sink's copy as "text"
+	Template string `yaml:"template"`
+	// After delays the action, e.g. 72h. Delayed actions are scheduler jobs,
+	// so they still run after a restart; the rule needs a name.
+	After time.Duration `yaml:"after"`
+	// Approve parks the action, once any delay is over, until a person
+	// approves it; the rule needs a name
+	Approve bool `yaml:"approve"`
+}
+
+// LoadRules reads and validates a rules file
+func LoadRules(path string) (*RuleSet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %v", err)
+	}
+
+	rules, err := ParseRules(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v in %s", err, path)
+	}
+	return rules, nil
+}
+
+// ParseRules reads and validates rules written as YAML
+func ParseRules(content []byte) (*RuleSet, error) {
+	var rules RuleSet
+	err := yaml.Unmarshal(content, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %v", err)
+	}
+
+	err = rules.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+	return &rules, nil
+}
+
+// Validate checks every rule has a trigger and well-formed actions, and
+// that no two rules share a name
+func (rs *RuleSet) Validate() error {
+	names := make(map[string]bool)
+	for i, rule := range rs.Rules {
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Name != "" && names[rule.Name] {
+			return fmt.Errorf("%s: another rule has this name", label)
+		}
+		names[rule.Name] = true
+
+		if rule.On == "" {
+			return fmt.Errorf("%s: needs an on message type", label)
+		}
+		if len(rule.Do) == 0 {
+			return fmt.Errorf("%s: needs at least one action", label)
+		}
+		for j, action := range rule.Do {
+			set := 0
+			if action.Sink != "" {
+				set++
+			}
+			if action.Send != nil {
+				set++
+			}
+			if action.Log != "" {
+				set++
+			}
+			if set != 1 {
+				return fmt.Errorf("%s: action %d needs exactly one of sink, send, or log", label, j+1)
+			}
+			if action.Template != "" && action.Sink == "" {
+				return fmt.Errorf("%s: action %d uses a template without a sink", label, j+1)
+			}
+			if action.After < 0 {
+				return fmt.Errorf("%s: action %d has a negative after", label, j+1)
+			}
+			if action.After > 0 && rule.Name == "" {
+				return fmt.Errorf("%s: action %d is delayed, so the rule needs a name", label, j+1)
+			}
+			if action.Approve && rule.Name == "" {
+				return fmt.Errorf("%s: action %d waits for approval, so the rule needs a name", label, j+1)
+			}
+		}
+	}
+	return nil
+}
+
+// RuleEngine evaluates a rule set against every message the bridge receives
+type RuleEngine struct {
+	// Templates renders sink actions that name a template
+	Templates *TemplateStore
+	// Recommender, when set, adds the buyer's recommendations to template data
+	Recommender *Recommender
+
+	bridge    *GoBridge
+	scheduler *Scheduler // runs delayed actions, once RegisterRuleJobs is called
+	approvals *Approvals // holds actions for approval, once RegisterRuleApprovals is called
+
+	mu    sync.RWMutex
+	rules *RuleSet
+}
+
+// NewRuleEngine attaches the rules to the bridge's receive hooks
+func NewRuleEngine(gb *GoBridge, rules *RuleSet) *RuleEngine {
+	engine := &RuleEngine{bridge: gb, rules: rules}
+	gb.OnReceive(engine.Evaluate)
+	fmt.Printf("📝 Loaded %d rules\n", len(rules.Rules))
+	return engine
+}
+
+// Rules returns the rules in force
+func (re *RuleEngine) Rules() []Rule {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	return append([]Rule(nil), re.rules.Rules...)
+}
+
+// Add puts more rules in force, unless they are invalid alongside the
+// rules already in force
+func (re *RuleEngine) Add(rules []Rule) error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	combined := &RuleSet{Rules: append(append([]Rule(nil), re.rules.Rules...), rules...)}
+	err := combined.Validate()
+	if err != nil {
+		return err
+	}
+	re.rules = combined
+	return nil
+}
+
+// Remove takes the named rules out of force. Their delayed actions still
+// to run are skipped.
+func (re *RuleEngine) Remove(names ...string) {
+	remove := make(map[string]bool, len(names))
+	for _, name := range names {
+		remove[name] = true
+	}
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	kept := &RuleSet{}
+	for _, rule := range re.rules.Rules {
+		if !remove[rule.Name] {
+			kept.Rules = append(kept.Rules, rule)
+		}
+	}
+	re.rules = kept
+}
+
+// rule returns the rule in force with this name
+func (re *RuleEngine) rule(name string) (Rule, bool) {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+	for _, rule := range re.rules.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Evaluate runs the actions of every rule the message satisfies
+func (re *RuleEngine) Evaluate(message *UniversalMessage) {
+	re.mu.RLock()
+	rules := re.rules.Rules
+	re.mu.RUnlock()
+
+	for _, rule := range rules {
+		if !rule.Matches(message) {
+			continue
+		}
+
+		fmt.Printf("⚙️ Rule %s matched message %s\n", rule.Name, message.ID)
+		for j, action := range rule.Do {
+			var err error
+			if action.After > 0 {
+				err = re.schedule(rule, j, message)
+			} else {
+				err = re.perform(rule, j, message)
+			}
+			if err != nil {
+				log.Printf("❌ Rule %s action failed for %s: %v", rule.Name, message.ID, err)
+			}
+		}
+	}
+}
+
+// perform runs an action that is due, or parks it for approval when it is
+// marked approve
+func (re *RuleEngine) perform(rule Rule, index int, message *UniversalMessage) error {
+	action := rule.Do[index]
+	if !action.Approve {
+		return re.run(action, message)
+	}
+	if re.approvals == nil {
+		return fmt.Errorf("actions that wait for approval need the approvals queue")
+	}
+	kept, err := keepMessage(message)
+	if err != nil {
+		return err
+	}
+	_, err = re.approvals.Request("rule_action", ruleActionSummary(rule, index, message), map[string]interface{}{
+		"rule":    rule.Name,
+		"action":  index + 1,
+		"message": kept,
+	})
+	return err
+}
+
+// ruleActionSummary says what an action does and for whom, for approvers
+func ruleActionSummary(rule Rule, index int, message *UniversalMessage) string {
+	action := rule.Do[index]
+	var what string
+	switch {
+	case action.Sink != "" && action.Template != "":
+		what = fmt.Sprintf("%s on %s", action.Template, action.Sink)
+	case action.Sink != "":
+		what = "queue on " + action.Sink
+	case action.Send != nil:
+		what = fmt.Sprintf("send %v to %v", action.Send["type"], action.Send["target"])
+	default:
+		what = "log " + action.Log
+	}
+	summary := fmt.Sprintf("rule %s: %s", rule.Name, what)
+	if product := stringArg(message.Payload, "product_name"); product != "" {
+		summary += " about " + product
+	}
+	if email := stringArg(message.Payload, "email"); email != "" {
+		summary += " for " + email
+	}
+	return summary
+}
+
+// schedule queues a delayed action as a rule_action job carrying the
+// message
+func (re *RuleEngine) schedule(rule Rule, index int, message *UniversalMessage) error {
+	if re.scheduler == nil {
+		return fmt.Errorf("delayed actions need the scheduler")
+	}
+	kept, err := keepMessage(message)
+	if err != nil {
+		return err
+	}
+
+	at := re.bridge.clock.Now().Add(rule.Do[index].After)
+	id := fmt.Sprintf("rule:%s:%s:%d", rule.Name, message.ID, index+1)
+	_, err = re.scheduler.ScheduleOnce(id, "rule_action", at, map[string]interface{}{
+		"rule":    rule.Name,
+		"action":  index + 1,
+		"message": kept,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("⏳ Rule %s action %d for %s runs at %s\n", rule.Name, index+1, message.ID, at.Format(time.RFC3339))
+	return nil
+}
+
+// keptRuleAction reads back the rule, action and message of a delayed or
+// held action, or reports false when the rule has since been removed or
+// changed to have fewer actions
+func (re *RuleEngine) keptRuleAction(args map[string]interface{}) (Rule, int, *UniversalMessage, bool, error) {
+	name := stringArg(args, "rule")
+	n, _ := toNumber(args["action"])
+	index := int(n)
+	rule, ok := re.rule(name)
+	if !ok || index < 1 || index > len(rule.Do) {
+		return Rule{}, 0, nil, false, nil
+	}
+	message, err := unkeepMessage(mapArg(args, "message"))
+	if err != nil {
+		return Rule{}, 0, nil, false, err
+	}
+	return rule, index - 1, message, true, nil
+}
+
+// RegisterRuleJobs adds the rule_action job handler, which runs the
+// delayed actions of the engine's rules. A job whose rule has been removed
+// or changed to have fewer actions is skipped. Jobs that ran are removed,
+// so one per delayed action and message does not pile up.
+func RegisterRuleJobs(s *Scheduler, re *RuleEngine) {
+	re.scheduler = s
+	s.Handle("rule_action", func(job Job) error {
+		rule, index, message, ok, err := re.keptRuleAction(job.Args)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", job.ID, err)
+		}
+		if !ok {
+			fmt.Printf("⏭️ Skipping job %s: rule %s no longer has action %v\n", job.ID, stringArg(job.Args, "rule"), job.Args["action"])
+			return s.Remove(job.ID)
+		}
+		err = re.perform(rule, index, message)
+		if err != nil {
+			return err
+		}
+		return s.Remove(job.ID)
+	})
+}
+
+// RegisterRuleApprovals adds the rule_action approval handler, which runs
+// the engine's actions marked approve once they are approved. One whose
+// rule has been removed or changed to have fewer actions is skipped.
+func RegisterRuleApprovals(a *Approvals, re *RuleEngine) {
+	re.approvals = a
+	a.Handle("rule_action", func(args map[string]interface{}) error {
+		rule, index, message, ok, err := re.keptRuleAction(args)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Printf("⏭️ Skipping approved action: rule %s no longer has action %v\n", stringArg(args, "rule"), args["action"])
+			return nil
+		}
+		return re.run(rule.Do[index], message)
+	})
+}
+
+// Matches reports whether the rule fires for the message
+func (r Rule) Matches(message *UniversalMessage) bool {
+	if message.MessageType != r.On {
+		return false
+	}
+	for _, condition := range r.When {
+		if !condition.Matches(message.Payload) {
+			return false
+		}
+	}
+	return true
+}
+
+func (re *RuleEngine) run(action RuleAction, message *UniversalMessage) error {
+	switch {
+	case action.Sink != "":
+		sink := re.bridge.Sink(action.Sink)
+		if sink == nil {
+			return fmt.Errorf("no sink named %s", action.Sink)
+		}
+		if action.Template != "" {
+			rendered, err := re.render(action.Template, message)
+			if err != nil {
+				return err
+			}
+			message = rendered
+		}
+		err := sink.Enqueue(message)
+		if err != nil {
+			return fmt.Errorf("sink %s: %v", action.Sink, err)
+		}
+		return nil
+	case action.Send != nil:
+		_, err := scenarioActions["send"](re.bridge, action.Send)
+		return err
+	default:
+		fmt.Printf("📋 %s: %s\n", message.ID, action.Log)
+		return nil
+	}
+}
+
+// render returns a copy of the message with the template output as "text"
+// and the buyer's detected "locale", using the payload's product for
+// per-product overrides. A payload with the buyer's email but no "to" is
+// addressed to the buyer, as the email sink expects.
+func (re *RuleEngine) render(name string, message *UniversalMessage) (*UniversalMessage, error) {
+	if re.Templates == nil {
+		return nil, fmt.Errorf("template %s used but no template store configured", name)
+	}
+
+	product := stringArg(message.Payload, "product")
+	locale := DetectLocale(message.Payload)
+	data := message.Payload
+	if re.Recommender != nil {
+		recommendations := re.Recommender.Recommend(stringArg(message.Payload, "email"), 3)
+		data = mergePayload(data, map[string]interface{}{"recommendations": recommendationData(recommendations)})
+	}
+	text, err := re.Templates.RenderLocale(name, product, locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := map[string]interface{}{"text": text, "locale": locale}
+	if email := stringArg(message.Payload, "email"); email != "" && stringArg(message.Payload, "to") == "" {
+		extra["to"] = email
+	}
+	rendered := *message
+	rendered.Payload = mergePayload(message.Payload, extra)
+	return &rendered, nil
+}
+
+// lookupField walks a dotted path such as "customer.email" through nested maps
+func lookupField(payload map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// ruleEqual compares numbers by value and everything else structurally,
+// since JSON payloads decode numbers as float64 while YAML gives ints
+func ruleEqual(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			return x == y
+		}
+	}
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// ruleOrdering builds an operator from a test on the comparison result
+func ruleOrdering(test func(int) bool) func(interface{}, bool, interface{}) bool {
+	return func(actual interface{}, present bool, expected interface{}) bool {
+		return present && ruleCompare(actual, expected, test)
+	}
+}
+
+// ruleCompare orders two values numerically when both are numbers and as
+// strings otherwise, then applies the test to the comparison result
+func ruleCompare(a, b interface{}, test func(int) bool) bool {
+	if x, ok := toNumber(a); ok {
+		if y, ok := toNumber(b); ok {
+			switch {
+			case x < y:
+				return test(-1)
+			case x > y:
+				return test(1)
+			}
+			return test(0)
+		}
+	}
+	return test(strings.Compare(fmt.Sprint(a), fmt.Sprint(b)))
+}
+
+// toNumber converts JSON and YAML numbers, and numeric strings, to float64
+func toNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		parsed, err := strconv.ParseFloat(n, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseRulesRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"no trigger", "rules:\n  - name: a\n    do: [{log: hi}]\n", "a: needs an on message type"},
+		{"no actions", "rules:\n  - name: a\n    on: sale_event\n", "a: needs at least one action"},
+		{"unnamed rule is numbered", "rules:\n  - on: sale_event\n", "rule 1: needs at least one action"},
+		{"shared name", "rules:\n  - {name: a, on: sale_event, do: [{log: hi}]}\n  - {name: a, on: error, do: [{log: hi}]}\n", "a: another rule has this name"},
+		{"empty action", "rules:\n  - {name: a, on: sale_event, do: [{}]}\n", "action 1 needs exactly one of sink, send, or log"},
+		{"two kinds of action", "rules:\n  - {name: a, on: sale_event, do: [{sink: slack, log: hi}]}\n", "action 1 needs exactly one of sink, send, or log"},
+		{"template without a sink", "rules:\n  - {name: a, on: sale_event, do: [{log: hi, template: bonus}]}\n", "action 1 uses a template without a sink"},
+		{"negative delay", "rules:\n  - {name: a, on: sale_event, do: [{sink: email, after: -1h}]}\n", "action 1 has a negative after"},
+		{"unnamed delay", "rules:\n  - {on: sale_event, do: [{sink: email, after: 72h}]}\n", "action 1 is delayed, so the rule needs a name"},
+		{"unnamed approval", "rules:\n  - {on: sale_event, do: [{sink: email, approve: true}]}\n", "action 1 waits for approval, so the rule needs a name"},
+		{"condition without a field", "rules:\n  - {name: a, on: sale_event, when: [{equals: 1}], do: [{log: hi}]}\n", "condition needs a field"},
+		{"condition without an operator", "rules:\n  - {name: a, on: sale_event, when: [{field: price}], do: [{log: hi}]}\n", "condition on price needs exactly one operator"},
+		{"two operators", "rules:\n  - {name: a, on: sale_event, when: [{field: price, gt: 1, lt: 9}], do: [{log: hi}]}\n", "condition on price needs exactly one operator"},
+		{"unknown operator", "rules:\n  - {name: a, on: sale_event, when: [{field: price, above: 1}], do: [{log: hi}]}\n", `unknown operator "above"`},
+		{"not YAML", "rules: [\n", "failed to parse rules"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRules([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseRules = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleConditionMatches(t *testing.T) {
+	payload := map[string]interface{}{
+		"product":  "ebook",
+		"price":    float64(59), // as JSON decodes it
+		"quantity": "3",
+		"email":    "ada@example.com",
+		"tags":     []interface{}{"launch", "bundle"},
+		"segments": []interface{}{"whales"},
+		"customer": map[string]interface{}{"country": "DE"},
+		"refunded": false,
+	}
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{"{field: product, equals: ebook}", true},
+		{"{field: product, equals: course}", false},
+		{"{field: price, equals: 59}", true},
+		{"{field: missing, equals: ebook}", false},
+		{"{field: product, not_equals: course}", true},
+		{"{field: missing, not_equals: course}", true},
+		{"{field: price, gt: 50}", true},
+		{"{field: price, gt: 59}", false},
+		{"{field: price, gte: 59}", true},
+		{"{field: price, lt: 59.5}", true},
+		{"{field: price, lte: 58}", false},
+		{"{field: quantity, gt: 2}", true},
+		{"{field: missing, lt: 100}", false},
+		{"{field: email, contains: '@example.com'}", true},
+		{"{field: product, in: [course, ebook]}", true},
+		{"{field: product, in: [course]}", false},
+		{"{field: tags, includes: bundle}", true},
+		{"{field: tags, includes: sale}", false},
+		{"{field: product, includes: ebook}", false},
+		{"{field: customer.country, equals: DE}", true},
+		{"{field: customer.country.code, exists: true}", false},
+		{"{field: refunded, exists: true}", true},
+		{"{field: missing, exists: false}", true},
+		{"{segment: whales}", true},
+		{"{segment: churned}", false},
+	}
+	for _, tt := range tests {
+		var condition RuleCondition
+		if err := yaml.Unmarshal([]byte(tt.condition), &condition); err != nil {
+			t.Fatalf("%s: %v", tt.condition, err)
+		}
+		if got := condition.Matches(payload); got != tt.want {
+			t.Errorf("%s matches = %t, want %t", tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestRuleEngineRunsActions(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var slack, email []map[string]interface{}
+	gb.AddSink("slack", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		slack = append(slack, message.Payload)
+		return message
+	})
+	gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil }).SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		email = append(email, message.Payload)
+		return message
+	})
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "bonus.tmpl"), []byte("Hi, here is the bonus for {{.product}}."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRules([]byte(`rules:
+  - name: big-sale
+    on: sale_event
+    when:
+      - field: price
+        gt: 50
+    do:
+      - sink: slack
+      - log: big sale
+  - name: ebook-bonus
+    on: sale_event
+    when:
+      - field: product
+        equals: ebook
+    do:
+      - sink: email
+        template: bonus
+      - send: {type: function_call, target: python, payload: {function_name: issue_license}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewRuleEngine(gb, rules)
+	engine.Templates = NewTemplateStore(dir)
+
+	ids := NewSequentialIDs("gumroad")
+	sale := func(product string, price float64) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", map[string]interface{}{
+			"product": product, "price": price, "email": "ada@example.com",
+		}, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sale("course", 20)
+	if len(slack) != 0 || len(email) != 0 || len(transport.Sent()) != 0 {
+		t.Fatalf("a sale no rule matches ran actions: slack %v, email %v", slack, email)
+	}
+
+	sale("ebook", 59)
+	if len(slack) != 1 || slack[0]["product"] != "ebook" {
+		t.Errorf("slack got %v, want the big sale", slack)
+	}
+	if len(email) != 1 || email[0]["text"] != "Hi, here is the bonus for ebook." || email[0]["to"] != "ada@example.com" {
+		t.Errorf("email got %v, want the bonus addressed to the buyer", email)
+	}
+	sent := transport.SentOfType(FunctionCall)
+	if len(sent) != 1 || sent[0].TargetLanguage != "python" || sent[0].Payload["function_name"] != "issue_license" {
+		t.Errorf("sent %+v, want the issue_license call", sent)
+	}
+
+	// Rules can be added and removed while the engine runs
+	if err := engine.Add([]Rule{{Name: "big-sale", On: Error, Do: []RuleAction{{Log: "again"}}}}); err == nil {
+		t.Error("added a rule whose name is taken")
+	}
+	engine.Remove("big-sale")
+	sale("ebook", 99)
+	if len(slack) != 1 {
+		t.Errorf("slack got %d messages after big-sale was removed, want 1", len(slack))
+	}
+	if rules := engine.Rules(); len(rules) != 1 || rules[0].Name != "ebook-bonus" {
+		t.Errorf("rules %+v, want ebook-bonus alone", rules)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where a new subscriber's cursor starts
+const (
+	StreamFromStart  = "start"  // replay the whole history
+	StreamFromLatest = "latest" // only events appended after subscribing
+)
+
+// StreamEvent is one Gumroad event in the sale stream
+type StreamEvent struct {
+	Offset     uint64                 `json:"offset"`
+	MessageID  string                 `json:"message_id"`
+	Resource   string                 `json:"resource_name"`
+	ReceivedAt time.Time              `json:"received_at"`
+	Payload    map[string]interface{} `json:"payload"`
+}
+
+// SaleStream is an append-only log of every Gumroad event the bridge
+// receives, read by any number of subscribers. Each subscriber has its own
+// cursor, the offset of the next event it has not yet handled, persisted so
+// it resumes where it stopped. A subscriber added later can start from the
+// beginning of the history without the others noticing. Register it after
+// RecordSales so sales are logged with their normalized fields.
+type SaleStream struct {
+	bridge      *GoBridge
+	path        string
+	cursorsPath string
+
+	mu          sync.Mutex
+	events      []StreamEvent
+	seen        map[string]bool   // message IDs logged, so redeliveries append once
+	cursors     map[string]uint64 // subscriber → next offset
+	subscribers map[string]*StreamSubscriber
+	appended    chan struct{} // closed and replaced on every append
+}
+
+// NewSaleStream opens the stream kept in dir, loading its events and
+// cursors; an empty dir keeps them in memory only
+func NewSaleStream(gb *GoBridge, dir string) (*SaleStream, error) {
+	ss := &SaleStream{
+		bridge:      gb,
+		seen:        make(map[string]bool),
+		cursors:     make(map[string]uint64),
+		subscribers: make(map[string]*StreamSubscriber),
+		appended:    make(chan struct{}),
+	}
+	if dir != "" {
+		ss.path = filepath.Join(dir, "events.jsonl")
+		ss.cursorsPath = filepath.Join(dir, "cursors.json")
+		err := ss.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	gb.OnReceive(ss.handleMessage)
+	return ss, nil
+}
+
+func (ss *SaleStream) load() error {
+	content, err := ioutil.ReadFile(ss.cursorsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read stream cursors: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &ss.cursors)
+		if err != nil {
+			return fmt.Errorf("failed to parse stream cursors %s: %v", ss.cursorsPath, err)
+		}
+	}
+
+	file, err := os.Open(ss.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open sale stream: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var event StreamEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil || event.Offset != uint64(len(ss.events)) {
+			// Offsets must stay dense; a torn final write is the only gap
+			fmt.Printf("⚠️ Skipping bad stream event after offset %d\n", len(ss.events))
+			continue
+		}
+		ss.events = append(ss.events, event)
+		ss.seen[event.MessageID] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read sale stream: %v", err)
+	}
+	fmt.Printf("📂 Loaded %d stream events\n", len(ss.events))
+	return nil
+}
+
+func (ss *SaleStream) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+	resource := stringArg(message.Payload, "resource_name")
+	if resource == "" {
+		return
+	}
+	err := ss.Append(message.ID, resource, message.Payload)
+	if err != nil {
+		log.Printf("❌ Error appending %s to the sale stream: %v", message.ID, err)
+	}
+}
+
+// Append adds an event and wakes the subscribers; an event whose message ID
+// is already in the stream is ignored
+func (ss *SaleStream) Append(messageID, resource string, payload map[string]interface{}) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.seen[messageID] {
+		return nil
+	}
+	event := StreamEvent{
+		Offset:     uint64(len(ss.events)),
+		MessageID:  messageID,
+		Resource:   resource,
+		ReceivedAt: ss.bridge.clock.Now(),
+		Payload:    payload,
+	}
+	if ss.path != "" {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(ss.path), 0755)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(ss.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(append(encoded, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	ss.events = append(ss.events, event)
+	ss.seen[messageID] = true
+	close(ss.appended)
+	ss.appended = make(chan struct{})
+	return nil
+}
+
+// Len returns how many events the stream holds
+func (ss *SaleStream) Len() uint64 {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return uint64(len(ss.events))
+}
+
+// next returns the event at offset, or a channel closed once it exists
+func (ss *SaleStream) next(offset uint64) (StreamEvent, bool, <-chan struct{}) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if offset < uint64(len(ss.events)) {
+		return ss.events[offset], true, nil
+	}
+	return StreamEvent{}, false, ss.appended
+}
+
+// commit records that a subscriber has handled everything before offset
+func (ss *SaleStream) commit(name string, offset uint64) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.cursors[name] = offset
+	return writeJSONFile(ss.cursorsPath, ss.cursors)
+}
+
+// Subscribe starts delivering events to handler, one at a time and in
+// order. A name seen before resumes from its saved cursor; a new one starts
+// at from, StreamFromStart or StreamFromLatest. An event is redelivered,
+// with backoff, until handler returns nil.
+func (ss *SaleStream) Subscribe(name, from string, handler func(StreamEvent) error) (*StreamSubscriber, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if name == "" {
+		return nil, fmt.Errorf("stream subscriber needs a name")
+	}
+	if ss.subscribers[name] != nil {
+		return nil, fmt.Errorf("stream subscriber %s is already running", name)
+	}
+	cursor, ok := ss.cursors[name]
+	if !ok {
+		switch from {
+		case StreamFromStart, "":
+			cursor = 0
+		case StreamFromLatest:
+			cursor = uint64(len(ss.events))
+		default:
+			return nil, fmt.Errorf("stream subscriber %s: from must be %q or %q, not %q", name, StreamFromStart, StreamFromLatest, from)
+		}
+		ss.cursors[name] = cursor
+		err := writeJSONFile(ss.cursorsPath, ss.cursors)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save stream cursors: %v", err)
+		}
+	}
+
+	sub := &StreamSubscriber{
+		Name:       name,
+		Backoff:    time.Second,
+		MaxBackoff: 5 * time.Minute,
+		stream:     ss,
+		handler:    handler,
+		cursor:     cursor,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	ss.subscribers[name] = sub
+	go sub.run()
+	fmt.Printf("📡 Stream subscriber %s starting at offset %d of %d\n", name, cursor, len(ss.events))
+	return sub, nil
+}
+
+// Unsubscribe stops a subscriber and forgets its cursor, so subscribing
+// under the same name starts afresh
+func (ss *SaleStream) Unsubscribe(name string) error {
+	ss.mu.Lock()
+	sub := ss.subscribers[name]
+	delete(ss.subscribers, name)
+	ss.mu.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.cursors, name)
+	return writeJSONFile(ss.cursorsPath, ss.cursors)
+}
+
+// Stats returns every running subscriber's position, by name
+func (ss *SaleStream) Stats() []SubscriberStats {
+	ss.mu.Lock()
+	subscribers := make([]*StreamSubscriber, 0, len(ss.subscribers))
+	for _, sub := range ss.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	length := uint64(len(ss.events))
+	ss.mu.Unlock()
+
+	stats := make([]SubscriberStats, 0, len(subscribers))
+	for _, sub := range subscribers {
+		stats = append(stats, sub.Stats(length))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Handler serves GET /stream/subscribers with each subscriber's cursor and lag
+func (ss *SaleStream) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"events": ss.Len(), "subscribers": ss.Stats()})
+	})
+}
+
+// Close stops every subscriber; their cursors are kept
+func (ss *SaleStream) Close() {
+	ss.mu.Lock()
+	subscribers := ss.subscribers
+	ss.subscribers = make(map[string]*StreamSubscriber)
+	ss.mu.Unlock()
+
+	for _, sub := range subscribers {
+		sub.Close()
+	}
+}
+
+// SubscriberStats is one subscriber's position in the stream
+type SubscriberStats struct {
+	Name      string `json:"name"`
+	Cursor    uint64 `json:"cursor"`
+	Lag       uint64 `json:"lag"` // events not yet handled
+	Delivered uint64 `json:"delivered"`
+	Failures  uint64 `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// StreamSubscriber reads the sale stream from its own cursor
+type StreamSubscriber struct {
+	Name string
+	// Backoff is the delay before redelivering a failed event; each failure
+	// doubles it up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	stream  *SaleStream
+	handler func(StreamEvent) error
+
+	mu        sync.Mutex
+	cursor    uint64
+	delivered uint64
+	failures  uint64
+	lastError string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (sub *StreamSubscriber) run() {
+	defer close(sub.done)
+
+	delay := sub.Backoff
+	for {
+		sub.mu.Lock()
+		cursor := sub.cursor
+		sub.mu.Unlock()
+
+		event, ok, appended := sub.stream.next(cursor)
+		if !ok {
+			select {
+			case <-sub.stop:
+				return
+			case <-appended:
+				continue
+			}
+		}
+
+		err := sub.handler(event)
+		if err != nil {
+			sub.mu.Lock()
+			sub.failures++
+			sub.lastError = err.Error()
+			sub.mu.Unlock()
+			log.Printf("❌ Stream subscriber %s failed event %d, retrying in %s: %v", sub.Name, event.Offset, delay, err)
+			select {
+			case <-sub.stop:
+				return
+			case <-sub.stream.bridge.clock.After(delay):
+			}
+			delay *= 2
+			if sub.MaxBackoff > 0 && delay > sub.MaxBackoff {
+				delay = sub.MaxBackoff
+			}
+			continue
+		}
+		delay = sub.Backoff
+
+		sub.mu.Lock()
+		sub.cursor = event.Offset + 1
+		sub.delivered++
+		sub.lastError = ""
+		sub.mu.Unlock()
+		err = sub.stream.commit(sub.Name, event.Offset+1)
+		if err != nil {
+			log.Printf("❌ Error saving cursor of stream subscriber %s: %v", sub.Name, err)
+		}
+	}
+}
+
+// Stats returns the subscriber's position in a stream of length events
+func (sub *StreamSubscriber) Stats(length uint64) SubscriberStats {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	stats := SubscriberStats{
+		Name:      sub.Name,
+		Cursor:    sub.cursor,
+		Delivered: sub.delivered,
+		Failures:  sub.failures,
+		LastError: sub.lastError,
+	}
+	if length > sub.cursor {
+		stats.Lag = length - sub.cursor
+	}
+	return stats
+}
+
+// Close stops the subscriber after the event it is handling
+func (sub *StreamSubscriber) Close() {
+	sub.stopOnce.Do(func() { close(sub.stop) })
+	<-sub.done
+}
+
+// StreamWebhook is a downstream URL that subscribes to the sale stream
+type StreamWebhook struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"`
+	From  string `yaml:"from"`  // StreamFromStart or StreamFromLatest; defaults to start
+	Token string `yaml:"token"` // sent as "Authorization: Bearer <token>"
+}
+
+// LoadStreamWebhooks reads the webhook subscribers from YAML:
+//
+//	subscribers:
+//	  - name: warehouse
+//	    url: https://example.com/hooks/sales
+//	    from: start
+//	    token: secret
+func LoadStreamWebhooks(path string) ([]StreamWebhook, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream subscribers: %v", err)
+	}
+
+	var config struct {
+		Subscribers []StreamWebhook `yaml:"subscribers"`
+	}
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stream subscribers %s: %v", path, err)
+	}
+	for _, webhook := range config.Subscribers {
+		if webhook.Name == "" || webhook.URL == "" {
+			return nil, fmt.Errorf("invalid stream subscribers %s: every subscriber needs a name and a url", path)
+		}
+	}
+	return config.Subscribers, nil
+}
+
+// SubscribeWebhook subscribes a URL that each event is POSTed to as JSON;
+// any 2xx answer advances its cursor
+func (ss *SaleStream) SubscribeWebhook(webhook StreamWebhook) (*StreamSubscriber, error) {
+	client := &http.Client{Timeout: httpSendTimeout}
+	return ss.Subscribe(webhook.Name, webhook.From, func(event StreamEvent) error {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", JSONContentType)
+		if webhook.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+webhook.Token)
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode < 300 {
+			return nil
+		}
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		if text := strings.TrimSpace(string(detail)); text != "" {
+			return fmt.Errorf("%s answered %s: %s", webhook.URL, response.Status, text)
+		}
+		return fmt.Errorf("%s answered %s", webhook.URL, response.Status)
+	})
+}
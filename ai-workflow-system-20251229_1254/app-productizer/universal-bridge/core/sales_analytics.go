@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAnalyticsDays bounds the range of a daily breakdown
+	maxAnalyticsDays = 366
+	// maxRecentSales bounds how many sales /sales/recent lists
+	maxRecentSales = 100
+)
+
+// SalesFigures are the sales and refunds of a range of days, per currency.
+// Refunds count on the day they were made, like the sales they take back
+// from, so a day's net revenue can be negative.
+type SalesFigures struct {
+	Sales        int     `json:"sales"`
+	Refunds      int     `json:"refunds"`
+	Gross        []Money `json:"gross"`
+	Refunded     []Money `json:"refunded"`
+	NetRevenue   []Money `json:"net_revenue"`   // gross less refunds
+	AverageOrder []Money `json:"average_order"` // gross per sale
+	Buyers       int     `json:"buyers"`        // distinct emails among the sales
+}
+
+// SalesSummary is the figures of the days from From through To
+type SalesSummary struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	SalesFigures
+}
+
+// ProductSales is one product's figures over the range
+type ProductSales struct {
+	Product     string `json:"product"`
+	ProductName string `json:"product_name,omitempty"`
+	SalesFigures
+}
+
+// DaySales is one day's figures
+type DaySales struct {
+	Date string `json:"date"`
+	SalesFigures
+}
+
+// SalesAnalytics reports on the sales store over ranges of days, for
+// dashboards. Bundles are left out, as their components carry the price.
+type SalesAnalytics struct {
+	// Location is the timezone days are in
+	Location *time.Location
+
+	bridge *GoBridge
+	sales  *SalesStore
+}
+
+// NewSalesAnalytics reports on the sales store, in UTC until Location is
+// changed
+func NewSalesAnalytics(gb *GoBridge, sales *SalesStore) *SalesAnalytics {
+	return &SalesAnalytics{Location: time.UTC, bridge: gb, sales: sales}
+}
+
+// salesTally adds sales and refunds up into SalesFigures
+type salesTally struct {
+	figures              SalesFigures
+	gross, refunded, net MoneyTotals
+	salesByCurrency      map[string]int64
+	buyers               map[string]bool
+}
+
+func newSalesTally() *salesTally {
+	return &salesTally{gross: MoneyTotals{}, refunded: MoneyTotals{}, net: MoneyTotals{}, salesByCurrency: make(map[string]int64), buyers: make(map[string]bool)}
+}
+
+func (t *salesTally) sale(sale Sale) {
+	t.figures.Sales++
+	t.gross.Add(sale.Price)
+	t.net.Add(sale.Price)
+	t.salesByCurrency[sale.Price.Currency]++
+	if sale.Email != "" {
+		t.buyers[sale.Email] = true
+	}
+}
+
+func (t *salesTally) refund(amount Money) {
+	t.figures.Refunds++
+	t.refunded.Add(amount)
+	t.net.Add(Money{Amount: -amount.Amount, Currency: amount.Currency})
+}
+
+func (t *salesTally) result() SalesFigures {
+	figures := t.figures
+	figures.Gross, figures.Refunded, figures.NetRevenue = t.gross.List(), t.refunded.List(), t.net.List()
+	figures.AverageOrder = []Money{}
+	for _, total := range figures.Gross {
+		if average, err := total.Div(t.salesByCurrency[total.Currency]); err == nil {
+			figures.AverageOrder = append(figures.AverageOrder, average)
+		}
+	}
+	figures.Buyers = len(t.buyers)
+	return figures
+}
+
+// each calls fn for every sale made and every refund made from from
+// through to, dates like 2026-01-31, of the product when one is given.
+// refund is nil for a sale and the amount refunded for a refund.
+func (sa *SalesAnalytics) each(from, to, product string, fn func(sale Sale, at time.Time, refund *Money)) error {
+	start, end, err := parseDayRange(from, to, sa.Location)
+	if err != nil {
+		return err
+	}
+	in := func(at time.Time) bool { return !at.Before(start) && at.Before(end) }
+	for _, sale := range sa.sales.All() {
+		if sale.IsBundle() || sale.CreatedAt.IsZero() {
+			continue
+		}
+		if product != "" && sale.Product != product && sale.Permalink != product {
+			continue
+		}
+		if in(sale.CreatedAt) {
+			fn(sale, sale.CreatedAt, nil)
+		}
+		if amount, at, ok := refundOf(sale); ok && in(at) {
+			fn(sale, at, &amount)
+		}
+	}
+	return nil
+}
+
+// Summary totals the days from from through to
+func (sa *SalesAnalytics) Summary(from, to, product string) (SalesSummary, error) {
+	tally := newSalesTally()
+	err := sa.each(from, to, product, func(sale Sale, at time.Time, refund *Money) {
+		if refund != nil {
+			tally.refund(*refund)
+		} else {
+			tally.sale(sale)
+		}
+	})
+	if err != nil {
+		return SalesSummary{}, err
+	}
+	return SalesSummary{From: from, To: to, SalesFigures: tally.result()}, nil
+}
+
+// ByProduct totals each product over the days from from through to, the
+// best selling first
+func (sa *SalesAnalytics) ByProduct(from, to string) ([]ProductSales, error) {
+	tallies := make(map[string]*salesTally)
+	names := make(map[string]string)
+	err := sa.each(from, to, "", func(sale Sale, at time.Time, refund *Money) {
+		tally := tallies[sale.Product]
+		if tally == nil {
+			tally = newSalesTally()
+			tallies[sale.Product] = tally
+		}
+		if sale.ProductName != "" {
+			names[sale.Product] = sale.ProductName
+		}
+		if refund != nil {
+			tally.refund(*refund)
+		} else {
+			tally.sale(sale)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]ProductSales, 0, len(tallies))
+	for product, tally := range tallies {
+		products = append(products, ProductSales{Product: product, ProductName: names[product], SalesFigures: tally.result()})
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Sales != products[j].Sales {
+			return products[i].Sales > products[j].Sales
+		}
+		return products[i].Product < products[j].Product
+	})
+	return products, nil
+}
+
+// Daily totals every day from from through to, including days without
+// sales, for ranges of up to maxAnalyticsDays
+func (sa *SalesAnalytics) Daily(from, to, product string) ([]DaySales, error) {
+	start, end, err := parseDayRange(from, to, sa.Location)
+	if err != nil {
+		return nil, err
+	}
+	var dates []string
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if len(dates) == maxAnalyticsDays {
+			return nil, fmt.Errorf("daily figures cover at most %d days", maxAnalyticsDays)
+		}
+		dates = append(dates, day.Format(expenseDate))
+	}
+
+	tallies := make(map[string]*salesTally, len(dates))
+	for _, date := range dates {
+		tallies[date] = newSalesTally()
+	}
+	err = sa.each(from, to, product, func(sale Sale, at time.Time, refund *Money) {
+		tally := tallies[at.In(sa.Location).Format(expenseDate)]
+		if refund != nil {
+			tally.refund(*refund)
+		} else {
+			tally.sale(sale)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]DaySales, len(dates))
+	for i, date := range dates {
+		days[i] = DaySales{Date: date, SalesFigures: tallies[date].result()}
+	}
+	return days, nil
+}
+
+// Recent returns the latest limit sales, newest first, of the product when
+// one is given
+func (sa *SalesAnalytics) Recent(limit int, product string) []Sale {
+	sales := sa.sales.Sales(func(sale Sale) bool {
+		return !sale.IsBundle() && !sale.CreatedAt.IsZero() && (product == "" || sale.Product == product || sale.Permalink == product)
+	})
+	sort.SliceStable(sales, func(i, j int) bool { return sales[i].CreatedAt.After(sales[j].CreatedAt) })
+	if len(sales) > limit {
+		sales = sales[:limit]
+	}
+	return sales
+}
+
+// parseDayRange reads the days from from through to, dates like
+// 2026-01-31, as the instants [start, end)
+func parseDayRange(from, to string, location *time.Location) (start, end time.Time, err error) {
+	start, err = time.ParseInLocation(expenseDate, from, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from %q is not like 2026-01-31", from)
+	}
+	end, err = time.ParseInLocation(expenseDate, to, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to %q is not like 2026-01-31", to)
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to is before from")
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// dayRangeQuery reads ?from= and ?to=, this month so far by default
+func dayRangeQuery(r *http.Request, now time.Time, location *time.Location) (from, to string) {
+	now = now.In(location)
+	from, to = r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if from == "" {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location).Format(expenseDate)
+	}
+	if to == "" {
+		to = now.Format(expenseDate)
+	}
+	return from, to
+}
+
+// Handler serves GET /sales/summary, /sales/by-product and /sales/daily
+// for the days from ?from= through ?to=, this month so far by default, and
+// /sales/recent for the latest ?limit= sales. ?product= narrows all but
+// the breakdown by product to one product.
+func (sa *SalesAnalytics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		from, to := dayRangeQuery(r, sa.bridge.clock.Now(), sa.Location)
+		product := r.URL.Query().Get("product")
+
+		var result interface{}
+		var err error
+		switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/sales"), "/") {
+		case "summary":
+			result, err = sa.Summary(from, to, product)
+		case "by-product":
+			var products []ProductSales
+			products, err = sa.ByProduct(from, to)
+			result = map[string]interface{}{"from": from, "to": to, "products": products}
+		case "daily":
+			var days []DaySales
+			days, err = sa.Daily(from, to, product)
+			result = map[string]interface{}{"from": from, "to": to, "days": days}
+		case "recent":
+			limit := 20
+			if value := r.URL.Query().Get("limit"); value != "" {
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 1 || limit > maxRecentSales {
+					err = fmt.Errorf("limit must be from 1 to %d", maxRecentSales)
+				}
+			}
+			result = map[string]interface{}{"sales": sa.Recent(limit, product)}
+		default:
+			writeAPIError(w, http.StatusNotFound, "use /sales/summary, /sales/by-product, /sales/daily or /sales/recent")
+			return
+		}
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, result)
+	})
+}
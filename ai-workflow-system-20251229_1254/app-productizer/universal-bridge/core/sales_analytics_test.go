@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSalesAnalytics(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 20, 8, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []struct {
+		id, product, name, email, currency string
+		price                              float64
+		at                                 string
+	}{
+		{"c1", "course", "Course", "ada@example.com", "usd", 100, "2026-01-13T12:00:00Z"},
+		{"c2", "course", "Course", "bob@example.com", "usd", 50, "2026-01-13T23:30:00Z"}, // the 14th in Berlin
+		{"c3", "course", "Course", "ada@example.com", "eur", 90, "2026-01-15T12:00:00Z"},
+		{"e1", "ebook", "Ebook", "ada@example.com", "usd", 15, "2026-01-05T12:00:00Z"}, // before the range, refunded in it
+		{"e2", "ebook", "Ebook", "cy@example.com", "usd", 15, "2026-01-16T12:00:00Z"},
+	} {
+		_, err := sales.Record(map[string]interface{}{"sale_id": sale.id, "product_id": sale.product, "product_name": sale.name,
+			"price": sale.price, "currency": sale.currency, "email": sale.email, "sale_timestamp": sale.at})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sales.Refund(Refund{SaleID: "e1", Amount: NewMoney(1500, "usd"), RefundedAt: time.Date(2026, 1, 14, 9, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analytics := NewSalesAnalytics(gb, sales)
+	analytics.Location, err = time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := analytics.Handler()
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       []string
+	}{
+		{"/sales/summary?from=2026-01-12&to=2026-01-18", http.StatusOK, []string{
+			`"sales":4,"refunds":1`,
+			`"gross":[{"amount":9000,"currency":"eur"},{"amount":16500,"currency":"usd"}]`,
+			`"net_revenue":[{"amount":9000,"currency":"eur"},{"amount":15000,"currency":"usd"}]`,
+			`"average_order":[{"amount":9000,"currency":"eur"},{"amount":5500,"currency":"usd"}]`,
+			`"buyers":3`,
+		}},
+		{"/sales/summary?from=2026-01-12&to=2026-01-18&product=ebook", http.StatusOK, []string{`"sales":1,"refunds":1`, `"net_revenue":[{"amount":0,"currency":"usd"}]`}},
+		// This month so far by default
+		{"/sales/summary", http.StatusOK, []string{`"from":"2026-01-01","to":"2026-01-20","sales":5,"refunds":1`}},
+		{"/sales/by-product?from=2026-01-12&to=2026-01-18", http.StatusOK, []string{
+			`"products":[{"product":"course","product_name":"Course","sales":3`,
+			`{"product":"ebook","product_name":"Ebook","sales":1,"refunds":1`,
+		}},
+		{"/sales/daily?from=2026-01-13&to=2026-01-15&product=course", http.StatusOK, []string{
+			`{"date":"2026-01-13","sales":1,"refunds":0,"gross":[{"amount":10000,"currency":"usd"}]`,
+			`{"date":"2026-01-14","sales":1,`,
+			`{"date":"2026-01-15","sales":1,"refunds":0,"gross":[{"amount":9000,"currency":"eur"}]`,
+		}},
+		{"/sales/daily?from=2026-01-16&to=2026-01-16&product=course", http.StatusOK, []string{`"days":[{"date":"2026-01-16","sales":0,"refunds":0,"gross":[],"refunded":[],"net_revenue":[],"average_order":[],"buyers":0}]`}},
+		{"/sales/daily?from=2025-01-01&to=2026-01-20", http.StatusBadRequest, []string{"at most 366 days"}},
+		{"/sales/summary?from=2026-01-18&to=2026-01-12", http.StatusBadRequest, []string{"to is before from"}},
+		{"/sales/recent?limit=2", http.StatusOK, []string{`"sales":[{"sale_id":"e2",`, `},{"sale_id":"c3",`}},
+		{"/sales/recent?product=ebook", http.StatusOK, []string{`{"sale_id":"e2",`, `{"sale_id":"e1",`, `"refunded":true`}},
+		{"/sales/recent?limit=101", http.StatusBadRequest, []string{"limit must be from 1 to 100"}},
+		{"/sales/weekly", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if response.Code != tt.wantStatus {
+			t.Errorf("%s = %d, want %d: %s", tt.target, response.Code, tt.wantStatus, response.Body)
+			continue
+		}
+		body := response.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s lacks %s:\n%s", tt.target, want, body)
+			}
+		}
+	}
+}
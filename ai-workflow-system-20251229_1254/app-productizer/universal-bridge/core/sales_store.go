@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SalesStore keeps every Gumroad sale the bridge has seen, keyed by sale_id,
+// in an append-only JSON lines file. Later lines for the same sale replace
+// earlier ones, so updates such as refunds are just appended. Sales are
+// validated on the way in; the map accessors return their normalized
+// payloads for templates.
+type SalesStore struct {
+	path  string
+	mu    sync.RWMutex
+	sales map[string]Sale
+	order []string // sale IDs in first-seen order
+}
+
+// NewSalesStore opens the store at path, loading any recorded sales; an
+// empty path keeps sales in memory only
+func NewSalesStore(path string) (*SalesStore, error) {
+	store := &SalesStore{
+		path:  path,
+		sales: make(map[string]Sale),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sales store: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var payload map[string]interface{}
+		err := json.Unmarshal(scanner.Bytes(), &payload)
+		var sale Sale
+		if err == nil {
+			sale, err = ParseSale(payload)
+		}
+		if err != nil {
+			// A torn final write must not make the whole store unreadable
+			fmt.Printf("⚠️ Skipping bad sales record on line %d: %v\n", line, err)
+			continue
+		}
+		store.put(sale)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sales store: %v", err)
+	}
+
+	fmt.Printf("📂 Loaded %d sales\n", len(store.sales))
+	return store, nil
+}
+
+// Record validates and adds or updates a sale, returning it as stored
+func (ss *SalesStore) Record(payload map[string]interface{}) (Sale, error) {
+	sale, err := ParseSale(payload)
+	if err != nil {
+		return Sale{}, err
+	}
+	sale.Fields = sale.Payload()
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.path != "" {
+		encoded, err := json.Marshal(sale.Fields)
+		if err != nil {
+			return Sale{}, err
+		}
+		err = os.MkdirAll(filepath.Dir(ss.path), 0755)
+		if err != nil {
+			return Sale{}, err
+		}
+		file, err := os.OpenFile(ss.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return Sale{}, err
+		}
+		_, err = file.Write(append(encoded, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return Sale{}, err
+		}
+	}
+
+	ss.put(sale)
+	return sale, nil
+}
+
+func (ss *SalesStore) put(sale Sale) {
+	if _, exists := ss.sales[sale.ID]; !exists {
+		ss.order = append(ss.order, sale.ID)
+	}
+	ss.sales[sale.ID] = sale
+}
+
+// Sale returns the sale with this ID
+func (ss *SalesStore) Sale(id string) (Sale, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	sale, ok := ss.sales[id]
+	return sale, ok
+}
+
+// All returns every sale in the order first seen
+func (ss *SalesStore) All() []Sale {
+	return ss.Sales(nil)
+}
+
+// ByEmail returns the sales bought with this email, ignoring case
+func (ss *SalesStore) ByEmail(email string) []Sale {
+	email = NormalizeEmail(email)
+	return ss.Sales(func(sale Sale) bool { return sale.Email == email })
+}
+
+// GiftsFrom returns the gift sales this email bought for someone else
+func (ss *SalesStore) GiftsFrom(email string) []Sale {
+	email = NormalizeEmail(email)
+	return ss.Sales(func(sale Sale) bool {
+		return sale.Gift && sale.GifterEmail == email
+	})
+}
+
+// ByProduct returns the sales of one product, matched by ID or permalink
+func (ss *SalesStore) ByProduct(product string) []Sale {
+	return ss.Sales(func(sale Sale) bool {
+		return sale.Product == product || sale.Permalink == product
+	})
+}
+
+// Sales returns the sales for which keep is true, or all of them for a nil
+// keep, in the order first seen
+func (ss *SalesStore) Sales(keep func(Sale) bool) []Sale {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var matched []Sale
+	for _, id := range ss.order {
+		if sale := ss.sales[id]; keep == nil || keep(sale) {
+			matched = append(matched, sale)
+		}
+	}
+	return matched
+}
+
+// Emails returns every distinct buyer email, sorted
+func (ss *SalesStore) Emails() []string {
+	seen := make(map[string]bool)
+	for _, sale := range ss.Sales(nil) {
+		if sale.Email != "" {
+			seen[sale.Email] = true
+		}
+	}
+
+	emails := make([]string, 0, len(seen))
+	for email := range seen {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// RecordSales stores every received data_sync message whose payload is a
+// Gumroad sale, i.e. has resource_name "sale", and applies refund pings to
+// the sale they refund. The message continues with the sale's normalized
+// payload, so hooks registered after it and sinks see validated fields.
+func RecordSales(gb *GoBridge, store *SalesStore) {
+	gb.OnReceive(func(message *UniversalMessage) {
+		if !isGumroadEvent(message) {
+			return
+		}
+		var err error
+		switch stringArg(message.Payload, "resource_name") {
+		case "sale":
+			var sale Sale
+			sale, err = store.Record(message.Payload)
+			if err == nil {
+				message.Payload = sale.Fields
+			}
+		case "refund":
+			var refund Refund
+			refund, err = ParseRefund(message.Payload, gb.clock.Now())
+			if err == nil {
+				err = store.Refund(refund)
+			}
+		default:
+			return
+		}
+		if err != nil {
+			fmt.Printf("⚠️ Not recording sale from %s: %v\n", message.ID, err)
+		}
+	})
+}
+
+// Refund marks a stored sale refunded, recording the amount for partial
+// refunds
+func (ss *SalesStore) Refund(refund Refund) error {
+	ss.mu.RLock()
+	sale, ok := ss.sales[refund.SaleID]
+	ss.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("refund of unknown sale %s", refund.SaleID)
+	}
+
+	update := map[string]interface{}{"refunded_at": refund.RefundedAt.UTC().Format(time.RFC3339)}
+	if refund.Amount.Amount > 0 && refund.Amount.Amount < sale.Price.Amount {
+		update["amount_refunded_cents"] = refund.Amount.Amount
+	} else {
+		update["refunded"] = true
+	}
+	_, err := ss.Record(mergePayload(sale.Fields, update))
+	return err
+}
+
+// NormalizeEmail lowercases and trims an address so lookups ignore case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSalesStoreLookups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sales.jsonl")
+	store, err := NewSalesStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []map[string]interface{}{
+		{"sale_id": "s1", "product_id": "p1", "email": "Ann@Example.com", "price": 29.99, "currency": "usd"},
+		{"sale_id": "s2", "product_id": "p2", "product_permalink": "kit", "email": "ann@example.com", "price": "10", "currency": "eur"},
+		{"sale_id": "s3", "product_id": "p1", "email": "bo@example.com", "gift": true, "gifter_email": "ann@example.com", "price": 5},
+	} {
+		if _, err := store.Record(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Refund(Refund{SaleID: "s1", Amount: NewMoney(2999, "usd"), RefundedAt: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening reads the same sales back from the file
+	reopened, err := NewSalesStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(sales []Sale) []string {
+		var list []string
+		for _, sale := range sales {
+			list = append(list, sale.ID)
+		}
+		return list
+	}
+	for _, s := range []*SalesStore{store, reopened} {
+		tests := []struct {
+			name string
+			got  []Sale
+			want []string
+		}{
+			{"All", s.All(), []string{"s1", "s2", "s3"}},
+			{"ByEmail", s.ByEmail(" ANN@example.com"), []string{"s1", "s2"}},
+			{"GiftsFrom", s.GiftsFrom("ann@example.com"), []string{"s3"}},
+			{"ByProduct by ID", s.ByProduct("p1"), []string{"s1", "s3"}},
+			{"ByProduct by permalink", s.ByProduct("kit"), []string{"s2"}},
+		}
+		for _, tt := range tests {
+			if got := ids(tt.got); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		sale, ok := s.Sale("s1")
+		if !ok || !sale.Refunded || sale.Price != NewMoney(2999, "usd") || sale.Email != "ann@example.com" {
+			t.Errorf("Sale(s1) = %+v, %t", sale, ok)
+		}
+		if sale, _ := s.Sale("s2"); sale.Price != NewMoney(1000, "eur") {
+			t.Errorf("Sale(s2) price %v, want 10.00 EUR", sale.Price)
+		}
+		if _, ok := s.Sale("missing"); ok {
+			t.Error("Sale found an unknown ID")
+		}
+	}
+}
+
+func TestBundleSharesAreExact(t *testing.T) {
+	var catalog BundleCatalog
+	err := yaml.Unmarshal([]byte(`
+bundles:
+  kit:
+    components:
+      - product: a
+      - product: b
+      - product: c
+`), &catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	components := catalog.Decompose(map[string]interface{}{"sale_id": "s1", "product_id": "kit", "price": "100.00", "currency": "usd"})
+	if len(components) != 3 {
+		t.Fatalf("%d components, want 3", len(components))
+	}
+
+	var total int64
+	for i, want := range []int64{3334, 3333, 3333} {
+		sale, err := ParseSale(components[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sale.Price.Amount != want {
+			t.Fatalf("component %d price %v, want %d cents", i, sale.Price, want)
+		}
+		total += sale.Price.Amount
+	}
+	if total != 10000 {
+		t.Fatalf("components add up to %d cents, want 10000", total)
+	}
+}
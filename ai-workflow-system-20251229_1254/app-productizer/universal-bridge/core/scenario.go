@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a scripted sequence of bridge interactions loaded from YAML.
+// Each step performs an action, waits, or expects a message within a deadline.
+type Scenario struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Steps       []ScenarioStep `yaml:"steps"`
+}
+
+// ScenarioStep is one step of a scenario
+type ScenarioStep struct {
+	Name   string                 `yaml:"name"`
+	Action string                 `yaml:"action"`
+	With   map[string]interface{} `yaml:"with"`
+	Wait   string                 `yaml:"wait"`
+	Expect *ScenarioExpectation   `yaml:"expect"`
+}
+
+// ScenarioExpectation describes a message that must arrive in time
+type ScenarioExpectation struct {
+	Type    MessageType            `yaml:"type"`
+	ReplyTo string                 `yaml:"reply_to"` // step name whose message this answers
+	Payload map[string]interface{} `yaml:"payload"`  // subset the payload must contain
+	Within  string                 `yaml:"within"`
+}
+
+// ScenarioStepResult records the outcome of one step
+type ScenarioStepResult struct {
+	Step      string
+	MessageID string
+	Err       error
+	Elapsed   time.Duration
+}
+
+// ScenarioResult is the outcome of a scenario run
+type ScenarioResult struct {
+	Scenario string
+	Steps    []ScenarioStepResult
+}
+
+// scenarioAction performs a step action and returns the sent message ID
+type scenarioAction func(gb *GoBridge, with map[string]interface{}) (string, error)
+
+// scenarioActions maps action names to bridge calls
+var scenarioActions = map[string]scenarioAction{
+	"send": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		channel := CommunicationChannel(stringArg(with, "channel"))
+		if channel == "" {
+			channel = FileSystem
+		}
+		message := gb.NewMessage(MessageType(stringArg(with, "type")), stringArg(with, "target"), mapArg(with, "payload"), channel)
+		message.DeliverAt = stringArg(with, "deliver_at")
+		return gb.send(message)
+	},
+	"request_ai": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		return gb.RequestAI(stringArg(with, "prompt"), stringArg(with, "instructions"), mapArg(with, "context"))
+	},
+	"translate_code": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		return gb.TranslateCode(stringArg(with, "code"), stringArg(with, "target"))
+	},
+	"call_function": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		args, _ := with["args"].([]interface{})
+		return gb.CallFunction(stringArg(with, "target"), stringArg(with, "function"), args, mapArg(with, "kwargs"))
+	},
+	"generate_go_struct": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		var fields []string
+		if list, ok := with["fields"].([]interface{}); ok {
+			for _, field := range list {
+				fields = append(fields, fmt.Sprint(field))
+			}
+		}
+		return gb.GenerateGoStruct(stringArg(with, "description"), fields)
+	},
+	"optimize_go_code": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		return gb.OptimizeGoCode(stringArg(with, "code"))
+	},
+	"generate_go_tests": func(gb *GoBridge, with map[string]interface{}) (string, error) {
+		return gb.GenerateGoTests(stringArg(with, "code"))
+	},
+}
+
+func stringArg(with map[string]interface{}, key string) string {
+	if value, ok := with[key]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+func mapArg(with map[string]interface{}, key string) map[string]interface{} {
+	value, _ := with[key].(map[string]interface{})
+	return value
+}
+
+// LoadScenario reads and validates a scenario file
+func LoadScenario(path string) (*Scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %v", err)
+	}
+
+	var scenario Scenario
+	err = yaml.Unmarshal(content, &scenario)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scenario %s: %v", path, err)
+	}
+
+	err = scenario.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", path, err)
+	}
+	return &scenario, nil
+}
+
+// Validate checks that every step is well formed before anything is sent
+func (s *Scenario) Validate() error {
+	names := make(map[string]bool)
+
+	for i, step := range s.Steps {
+		label := step.Name
+		if label == "" {
+			label = fmt.Sprintf("step %d", i+1)
+		}
+
+		if step.Action == "" && step.Wait == "" && step.Expect == nil {
+			return fmt.Errorf("%s: needs an action, wait, or expect", label)
+		}
+		if step.Action != "" {
+			if _, ok := scenarioActions[step.Action]; !ok {
+				return fmt.Errorf("%s: unknown action %q", label, step.Action)
+			}
+		}
+		if step.Wait != "" {
+			if _, err := time.ParseDuration(step.Wait); err != nil {
+				return fmt.Errorf("%s: bad wait: %v", label, err)
+			}
+		}
+		if step.Expect != nil {
+			if _, err := step.Expect.timeout(); err != nil {
+				return fmt.Errorf("%s: bad within: %v", label, err)
+			}
+			if ref := step.Expect.ReplyTo; ref != "" && !names[ref] && ref != step.Name {
+				return fmt.Errorf("%s: reply_to %q does not name an earlier step", label, ref)
+			}
+		}
+		if step.Name != "" {
+			names[step.Name] = true
+		}
+	}
+	return nil
+}
+
+// timeout returns the expectation deadline, defaulting to 10 seconds
+func (e *ScenarioExpectation) timeout() (time.Duration, error) {
+	if e.Within == "" {
+		return 10 * time.Second, nil
+	}
+	return time.ParseDuration(e.Within)
+}
+
+// matches reports whether a received message satisfies the expectation
+func (e *ScenarioExpectation) matches(message *UniversalMessage, sentIDs map[string]string) bool {
+	if e.Type != "" && message.MessageType != e.Type {
+		return false
+	}
+	if e.ReplyTo != "" {
+		if fmt.Sprint(message.Payload["original_message_id"]) != sentIDs[e.ReplyTo] {
+			return false
+		}
+	}
+	if e.Payload != nil {
+		return payloadContains(message.Payload, normalizeJSON(e.Payload))
+	}
+	return true
+}
+
+// normalizeJSON converts YAML-decoded values to the types encoding/json produces
+func normalizeJSON(value interface{}) interface{} {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	json.Unmarshal(encoded, &normalized)
+	return normalized
+}
+
+// payloadContains reports whether actual contains every key and value in expected
+func payloadContains(actual interface{}, expected interface{}) bool {
+	expectedMap, ok := expected.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(actual, expected)
+	}
+	actualMap, ok := actual.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range expectedMap {
+		if !payloadContains(actualMap[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scenarioInbox buffers received messages so expectations can match
+// messages that arrived before the expect step started
+type scenarioInbox struct {
+	mu       sync.Mutex
+	messages []*UniversalMessage
+	notify   chan struct{}
+}
+
+func (in *scenarioInbox) add(message *UniversalMessage) {
+	in.mu.Lock()
+	in.messages = append(in.messages, message)
+	in.mu.Unlock()
+
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns the first message matching the expectation
+func (in *scenarioInbox) take(expect *ScenarioExpectation, sentIDs map[string]string) *UniversalMessage {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for i, message := range in.messages {
+		if expect.matches(message, sentIDs) {
+			in.messages = append(in.messages[:i], in.messages[i+1:]...)
+			return message
+		}
+	}
+	return nil
+}
+
+// RunScenario executes the scenario's steps in order against the bridge
+func RunScenario(gb *GoBridge, scenario *Scenario) *ScenarioResult {
+	inbox := &scenarioInbox{notify: make(chan struct{}, 1)}
+	gb.OnReceive(inbox.add)
+
+	result := &ScenarioResult{Scenario: scenario.Name}
+	sentIDs := make(map[string]string)
+
+	for i, step := range scenario.Steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step %d", i+1)
+		}
+		fmt.Printf("\n▶️ %s\n", name)
+
+		started := gb.clock.Now()
+		stepResult := ScenarioStepResult{Step: name}
+		stepResult.MessageID, stepResult.Err = gb.runScenarioStep(step, inbox, sentIDs)
+		stepResult.Elapsed = gb.clock.Now().Sub(started)
+		result.Steps = append(result.Steps, stepResult)
+
+		if stepResult.MessageID != "" && step.Name != "" {
+			sentIDs[step.Name] = stepResult.MessageID
+		}
+		if stepResult.Err != nil {
+			break
+		}
+	}
+	return result
+}
+
+func (gb *GoBridge) runScenarioStep(step ScenarioStep, inbox *scenarioInbox, sentIDs map[string]string) (string, error) {
+	var messageID string
+
+	if step.Action != "" {
+		id, err := scenarioActions[step.Action](gb, step.With)
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %v", step.Action, err)
+		}
+		messageID = id
+		if step.Name != "" {
+			sentIDs[step.Name] = id
+		}
+	}
+
+	if step.Wait != "" {
+		wait, _ := time.ParseDuration(step.Wait)
+		<-gb.clock.After(wait)
+	}
+
+	if step.Expect != nil {
+		timeout, _ := step.Expect.timeout()
+		deadline := gb.clock.After(timeout)
+
+		for {
+			if message := inbox.take(step.Expect, sentIDs); message != nil {
+				fmt.Printf("✅ Got expected %s: %s\n", message.MessageType, message.ID)
+				break
+			}
+			select {
+			case <-inbox.notify:
+			case <-deadline:
+				return messageID, fmt.Errorf("no matching %s message within %s", step.Expect.Type, timeout)
+			}
+		}
+	}
+
+	return messageID, nil
+}
+
+// Passed reports whether every step succeeded
+func (r *ScenarioResult) Passed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes a step-by-step summary
+func (r *ScenarioResult) Print() {
+	fmt.Printf("\n📋 Scenario: %s\n", r.Scenario)
+	fmt.Println(strings.Repeat("-", 50))
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			fmt.Printf("❌ %s (%s): %v\n", step.Step, step.Elapsed, step.Err)
+			continue
+		}
+		fmt.Printf("✅ %s (%s)\n", step.Step, step.Elapsed)
+	}
+
+	if r.Passed() {
+		fmt.Println("\n✅ Scenario passed")
+	} else {
+		fmt.Println("\n❌ Scenario failed")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// scenarioClock is a ManualClock that jumps ahead whenever it is waited on,
+// so scenario waits and expectation deadlines pass instantly
+type scenarioClock struct {
+	*ManualClock
+}
+
+func (c scenarioClock) After(d time.Duration) <-chan time.Time {
+	ch := c.ManualClock.After(d)
+	c.Advance(d)
+	return ch
+}
+
+// answerAsPython replies to what the bridge sends the way
+// universal_protocol.py does, standing in for it over the MemoryTransport
+func answerAsPython(t *testing.T, clock Clock, memory *MemoryTransport) {
+	ids := NewSequentialIDs("python")
+	memory.OnSend(func(message *UniversalMessage) {
+		payload := map[string]interface{}{"original_message_id": message.ID}
+		switch message.MessageType {
+		case AIRequest:
+			payload["ai_result"] = "AI processed request from go"
+		case CodeTranslation:
+			payload["translated_code"] = "def greet(): print('hi')"
+			payload["from_language"] = message.SourceLanguage
+			payload["to_language"] = message.TargetLanguage
+		case FunctionCall:
+			payload["function_result"] = "docs"
+			payload["success"] = true
+		default:
+			return
+		}
+		reply := newUniversalMessage(clock, ids, AIResponse, "python", "go", payload, FileSystem)
+		if err := memory.Inject(reply); err != nil {
+			t.Errorf("reply to %s: %v", message.ID, err)
+		}
+	})
+}
+
+func newScenarioBridge(t *testing.T) (*GoBridge, *MemoryTransport) {
+	t.Helper()
+	clock := scenarioClock{NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))}
+	memory := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(memory), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	return gb, memory
+}
+
+func TestScenarios(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("scenarios", "*.yaml"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scenarios found: %v", err)
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			scenario, err := LoadScenario(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gb, memory := newScenarioBridge(t)
+			answerAsPython(t, gb.clock, memory)
+
+			result := RunScenario(gb, scenario)
+			if len(result.Steps) != len(scenario.Steps) {
+				t.Fatalf("ran %d of %d steps", len(result.Steps), len(scenario.Steps))
+			}
+			for _, step := range result.Steps {
+				if step.Err != nil {
+					t.Errorf("%s: %v", step.Step, step.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestScenarioUnansweredExpectation(t *testing.T) {
+	gb, _ := newScenarioBridge(t)
+	scenario := &Scenario{Name: "silent peer", Steps: []ScenarioStep{
+		{Name: "ask", Action: "request_ai", With: map[string]interface{}{"prompt": "hi"}, Expect: &ScenarioExpectation{Type: AIResponse, ReplyTo: "ask", Within: "5s"}},
+		{Name: "never run", Wait: "1s"},
+	}}
+	if err := scenario.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	result := RunScenario(gb, scenario)
+	if result.Passed() || len(result.Steps) != 1 || result.Steps[0].Elapsed != 5*time.Second {
+		t.Fatalf("result %+v, want the first step to fail after 5s", result.Steps)
+	}
+}
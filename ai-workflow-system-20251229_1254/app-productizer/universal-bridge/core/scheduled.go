@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Which way a message held for its deliver_at is going
+const (
+	deliverSend    = "send"    // sent by this bridge once due
+	deliverReceive = "receive" // handled by this bridge once due
+)
+
+// deliveryTime parses DeliverAt; ok is false for a message that is due at once
+func (m *UniversalMessage) deliveryTime() (at time.Time, ok bool, err error) {
+	if m.DeliverAt == "" {
+		return time.Time{}, false, nil
+	}
+	at, err = time.Parse(time.RFC3339Nano, m.DeliverAt)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("deliver_at %q is not an RFC 3339 time", m.DeliverAt)
+	}
+	return at, true, nil
+}
+
+// DeliverAfter sets deliver_at delay after the message's timestamp
+func (m *UniversalMessage) DeliverAfter(delay time.Duration) error {
+	created, err := time.Parse(time.RFC3339Nano, m.Timestamp)
+	if err != nil {
+		return fmt.Errorf("message %s has no RFC 3339 timestamp to deliver after", m.ID)
+	}
+	m.DeliverAt = created.Add(delay).UTC().Format(time.RFC3339)
+	return nil
+}
+
+// deliverLater holds a message whose deliver_at is still ahead as a
+// deliver_message job, which sends or handles it once due, and reports
+// whether it did. Jobs are persisted, so held messages survive a restart.
+func (gb *GoBridge) deliverLater(message *UniversalMessage, direction string) (bool, error) {
+	at, ok, err := message.deliveryTime()
+	if err == nil && (!ok || !at.After(gb.clock.Now())) {
+		return false, nil
+	}
+	gb.mu.RLock()
+	scheduler := gb.scheduler
+	gb.mu.RUnlock()
+
+	if err != nil {
+		return false, err
+	}
+	if direction == deliverReceive && scheduler == nil {
+		// Handled early rather than never
+		log.Printf("❌ Handling %s now: no scheduler to hold it until %s", message.ID, message.DeliverAt)
+		return false, nil
+	}
+	if scheduler == nil {
+		return false, fmt.Errorf("message %s has a deliver_at, which needs the scheduler", message.ID)
+	}
+	if expires, expiring, _ := message.expiry(); expiring && !expires.After(at) {
+		return false, fmt.Errorf("%w at %s, before its deliver_at %s", ErrMessageExpired, message.ExpiresAt, message.DeliverAt)
+	}
+
+	kept, err := keepMessage(message)
+	if err != nil {
+		return false, err
+	}
+	args := map[string]interface{}{"direction": direction, "message": kept}
+	if direction == deliverReceive {
+		args["channel"] = string(message.receivedOn)
+	}
+	_, err = scheduler.ScheduleOnce("deliver:"+direction+":"+message.ID, "deliver_message", at, args)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("⏳ Holding %s (%s) to %s at %s\n", message.ID, message.MessageType, direction, message.DeliverAt)
+	return true, nil
+}
+
+// holdIncoming holds a received message until its deliver_at. One that
+// asked for a receipt is acked now, as the bridge has taken it on, and not
+// again once handled.
+func (gb *GoBridge) holdIncoming(message *UniversalMessage) (bool, error) {
+	if message.DeliverAt == "" {
+		return false, nil
+	}
+	held := *message
+	held.Headers = make(map[string]string, len(message.Headers))
+	for key, value := range message.Headers {
+		if key != ackHeader {
+			held.Headers[key] = value
+		}
+	}
+	later, err := gb.deliverLater(&held, deliverReceive)
+	if later {
+		gb.sendReceipt(message, nil)
+	}
+	return later, err
+}
+
+// deliverDue sends or handles a message held by a deliver_message job
+func (gb *GoBridge) deliverDue(job Job) error {
+	message, err := unkeepMessage(mapArg(job.Args, "message"))
+	if err != nil {
+		return fmt.Errorf("job %s: %v", job.ID, err)
+	}
+	if stringArg(job.Args, "direction") == deliverReceive {
+		message.receivedOn = CommunicationChannel(stringArg(job.Args, "channel"))
+		return gb.dispatchIncoming(message)
+	}
+	_, err = gb.SendMessage(message)
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliverAt(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var handled []string
+	gb.OnMessage(SaleEvent, func(message *UniversalMessage) error {
+		handled = append(handled, message.ID)
+		return nil
+	})
+
+	// Without a scheduler nothing can hold a message
+	early := gb.NewMessage(DataSync, "python", map[string]interface{}{"text": "Day 3"}, FileSystem)
+	if err := early.DeliverAfter(72 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gb.SendMessage(early); err == nil || !strings.Contains(err.Error(), "needs the scheduler") {
+		t.Errorf("sent without a scheduler: %v", err)
+	}
+	scheduler := NewScheduler("", clock)
+	RegisterBridgeJobs(scheduler, gb)
+
+	tests := []struct {
+		name      string
+		deliverAt string
+		expiresAt string
+		wantErr   string
+		wantHeld  bool
+	}{
+		{"due now", clock.Now().Format(time.RFC3339), "", "", false},
+		{"in three days", clock.Now().Add(72 * time.Hour).Format(time.RFC3339), "", "", true},
+		{"expires first", clock.Now().Add(72 * time.Hour).Format(time.RFC3339), clock.Now().Add(time.Hour).Format(time.RFC3339), "before its deliver_at", false},
+		{"not a time", "in three days", "", "not an RFC 3339 time", false},
+	}
+	for _, tt := range tests {
+		message := gb.NewMessage(DataSync, "python", map[string]interface{}{"text": tt.name}, FileSystem)
+		message.DeliverAt, message.ExpiresAt = tt.deliverAt, tt.expiresAt
+		sent := len(transport.Sent())
+		jobs := len(scheduler.List())
+		_, err := gb.SendMessage(message)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		held := len(scheduler.List()) > jobs
+		if held != tt.wantHeld || held == (len(transport.Sent()) > sent) {
+			t.Errorf("%s: held %v with %d sent, want held %v", tt.name, held, len(transport.Sent())-sent, tt.wantHeld)
+		}
+	}
+	if _, err := gb.SendMessage(&UniversalMessage{ID: "x", DeliverAt: "2026-01-18T09:30:00Z", ExpiresAt: "2026-01-15T10:30:00Z"}); !errors.Is(err, ErrMessageExpired) {
+		t.Errorf("got %v, want ErrMessageExpired", err)
+	}
+
+	// A received message is acked when held and handled once due
+	ids := NewSequentialIDs("python")
+	sale := newUniversalMessage(clock, ids, SaleEvent, "python", "go", map[string]interface{}{"sale_id": "s1"}, FileSystem)
+	sale.RequestAck()
+	sale.DeliverAt = clock.Now().Add(time.Hour).Format(time.RFC3339)
+	sent := len(transport.Sent())
+	if err := transport.Inject(sale); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 0 {
+		t.Fatalf("handled %v before its deliver_at", handled)
+	}
+	if acks := transport.Sent()[sent:]; len(acks) != 1 || acks[0].MessageType != Ack || acks[0].Payload["original_message_id"] != sale.ID {
+		t.Errorf("replied %+v, want one ack for %s", acks, sale.ID)
+	}
+	bad := newUniversalMessage(clock, ids, SaleEvent, "python", "go", map[string]interface{}{"sale_id": "s2"}, FileSystem)
+	bad.DeliverAt = "soon"
+	transport.Inject(bad)
+
+	jobs := scheduler.List()
+	if len(jobs) != 2 || jobs[0].ID != "deliver:receive:"+sale.ID {
+		t.Fatalf("jobs %+v, want the sent and the received message held", jobs)
+	}
+	clock.Advance(72 * time.Hour)
+	sent = len(transport.Sent())
+	for _, job := range jobs {
+		if err := scheduler.handlers["deliver_message"](job); err != nil {
+			t.Fatalf("%s: %v", job.ID, err)
+		}
+	}
+	if len(handled) != 1 || handled[0] != sale.ID {
+		t.Errorf("handled %v, want %s once due and not the bad deliver_at", handled, sale.ID)
+	}
+	if got := transport.Sent()[sent:]; len(got) != 1 || got[0].Payload["text"] != "in three days" {
+		t.Errorf("sent %+v once due, want the held message and no second ack", got)
+	}
+	if len(scheduler.List()) != 0 {
+		t.Errorf("kept jobs %+v that ran", scheduler.List())
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoJob is returned for a job ID the scheduler does not hold
+var ErrNoJob = errors.New("no such job")
+
+// ErrJobRunning is returned when triggering a job that has not finished
+var ErrJobRunning = errors.New("job is already running")
+
+// Job is a scheduled unit of work. Recurring jobs have a cron Schedule;
+// one-shot jobs have RunAt and stop being due once they have run.
+type Job struct {
+	ID        string                 `json:"id"`
+	Handler   string                 `json:"handler"`
+	Schedule  string                 `json:"schedule,omitempty"`
+	RunAt     *time.Time             `json:"run_at,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+	Paused    bool                   `json:"paused"`
+	NextRun   *time.Time             `json:"next_run,omitempty"`
+	LastRun   *time.Time             `json:"last_run,omitempty"`
+	LastError string                 `json:"last_error,omitempty"`
+	Runs      int                    `json:"runs"`
+
+	cron    *CronSchedule
+	running bool
+}
+
+// JobFunc runs a job; the returned error is recorded on the job
+type JobFunc func(job Job) error
+
+// Scheduler runs cron and one-shot jobs and persists them to a JSON file so
+// schedules, pauses and pending one-shots survive restarts
+type Scheduler struct {
+	path     string
+	clock    Clock
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	handlers map[string]JobFunc
+	wake     chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler backed by the file at path; an empty
+// path keeps jobs in memory only
+func NewScheduler(path string, clock Clock) *Scheduler {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &Scheduler{
+		path:     path,
+		clock:    clock,
+		jobs:     make(map[string]*Job),
+		handlers: make(map[string]JobFunc),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Handle registers the function that runs jobs naming this handler
+func (s *Scheduler) Handle(name string, fn JobFunc) {
+	s.mu.Lock()
+	s.handlers[name] = fn
+	s.mu.Unlock()
+	fmt.Printf("📝 Registered job handler %s\n", name)
+}
+
+// Schedule adds or replaces a recurring job
+func (s *Scheduler) Schedule(id, handler, cronExpr string, args map[string]interface{}) (Job, error) {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return Job{}, err
+	}
+	return s.add(&Job{ID: id, Handler: handler, Schedule: cronExpr, Args: args, cron: cron})
+}
+
+// ScheduleOnce adds or replaces a job that runs once at the given time
+func (s *Scheduler) ScheduleOnce(id, handler string, at time.Time, args map[string]interface{}) (Job, error) {
+	return s.add(&Job{ID: id, Handler: handler, RunAt: &at, NextRun: &at, Args: args})
+}
+
+// EnsureScheduled adds a recurring job unless one with this ID already
+// exists, so defaults do not undo a persisted pause or a changed schedule.
+// Call it after Start.
+func (s *Scheduler) EnsureScheduled(id, handler, cronExpr string, args map[string]interface{}) error {
+	s.mu.Lock()
+	_, exists := s.jobs[id]
+	s.mu.Unlock()
+	if exists {
+		return nil
+	}
+	_, err := s.Schedule(id, handler, cronExpr, args)
+	return err
+}
+
+func (s *Scheduler) add(job *Job) (Job, error) {
+	if job.ID == "" {
+		return Job{}, fmt.Errorf("job needs an id")
+	}
+
+	s.mu.Lock()
+	if _, ok := s.handlers[job.Handler]; !ok {
+		s.mu.Unlock()
+		return Job{}, fmt.Errorf("unknown job handler %q", job.Handler)
+	}
+	job.NextRun = job.nextAfter(s.clock.Now())
+	s.jobs[job.ID] = job
+	snapshot := *job
+	err := s.saveLocked()
+	s.mu.Unlock()
+
+	s.notify()
+	return snapshot, err
+}
+
+// Start loads persisted jobs and begins running them. Cron jobs resume from
+// their next run after now; one-shot jobs whose time has passed run at once.
+func (s *Scheduler) Start() error {
+	err := s.load()
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Stop stops scheduling and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}
+
+// List returns every job ordered by ID
+func (s *Scheduler) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// Pause stops a job from running on schedule; Trigger still runs it
+func (s *Scheduler) Pause(id string) error {
+	return s.update(id, func(job *Job) {
+		job.Paused = true
+	})
+}
+
+// Resume re-enables a paused job from its next scheduled time
+func (s *Scheduler) Resume(id string) error {
+	return s.update(id, func(job *Job) {
+		job.Paused = false
+		job.NextRun = job.nextAfter(s.clock.Now())
+	})
+}
+
+// Reschedule puts a recurring job on a new cron schedule, keeping its pause
+// and run history, and returns the schedule it had
+func (s *Scheduler) Reschedule(id, cronExpr string) (string, error) {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return "", err
+	}
+	var previous string
+	err = s.update(id, func(job *Job) {
+		if job.cron == nil {
+			return
+		}
+		previous = job.Schedule
+		job.Schedule, job.cron = cronExpr, cron
+		job.NextRun = job.nextAfter(s.clock.Now())
+	})
+	if err == nil && previous == "" {
+		return "", fmt.Errorf("job %s runs once and has no schedule", id)
+	}
+	return previous, err
+}
+
+// Remove deletes a job
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrNoJob, id)
+	}
+	delete(s.jobs, id)
+	return s.saveLocked()
+}
+
+// Trigger runs a job now, outside its schedule, even when paused
+func (s *Scheduler) Trigger(id string) error {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNoJob, id)
+	}
+	if job.running {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrJobRunning, id)
+	}
+	s.launchLocked(job, false)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Scheduler) update(id string, change func(*Job)) error {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNoJob, id)
+	}
+	change(job)
+	err := s.saveLocked()
+	s.mu.Unlock()
+
+	s.notify()
+	return err
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop sleeps until the earliest due job, runs everything due, and repeats
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		now := s.clock.Now()
+		var next time.Time
+		for _, job := range s.jobs {
+			if job.Paused || job.running || job.NextRun == nil {
+				continue
+			}
+			if !job.NextRun.After(now) {
+				s.launchLocked(job, true)
+				continue
+			}
+			if next.IsZero() || job.NextRun.Before(next) {
+				next = *job.NextRun
+			}
+		}
+		s.mu.Unlock()
+
+		// With nothing scheduled, sleep until a job is added or changed
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = s.clock.After(next.Sub(now))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-timer:
+		}
+	}
+}
+
+// launchLocked runs the job in its own goroutine so a slow job never delays
+// the others. Scheduled runs advance NextRun; triggered runs leave it alone.
+func (s *Scheduler) launchLocked(job *Job, scheduled bool) {
+	fn := s.handlers[job.Handler]
+	job.running = true
+	if scheduled {
+		job.NextRun = nil
+		if job.cron != nil {
+			job.NextRun = job.nextAfter(s.clock.Now())
+		}
+	}
+	snapshot := *job
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		fmt.Printf("⏰ Running job %s (%s)\n", snapshot.ID, snapshot.Handler)
+		err := runJob(fn, snapshot)
+		ranAt := s.clock.Now()
+
+		s.mu.Lock()
+		job.running = false
+		job.LastRun = &ranAt
+		job.Runs++
+		job.LastError = ""
+		if err != nil {
+			job.LastError = err.Error()
+			log.Printf("❌ Job %s failed: %v", job.ID, err)
+		}
+		if saveErr := s.saveLocked(); saveErr != nil {
+			log.Printf("❌ Error saving jobs: %v", saveErr)
+		}
+		s.mu.Unlock()
+
+		s.notify()
+	}()
+}
+
+// runJob calls the handler, turning a missing handler or panic into an error
+func runJob(fn JobFunc, job Job) (err error) {
+	if fn == nil {
+		return fmt.Errorf("no handler registered for %q", job.Handler)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panic: %v", r)
+		}
+	}()
+	return fn(job)
+}
+
+// nextAfter returns when a cron job is next due after now, or nil if never.
+// One-shot jobs keep their pending run until it happens.
+func (j *Job) nextAfter(now time.Time) *time.Time {
+	if j.cron == nil {
+		return j.NextRun
+	}
+	next := j.cron.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// load reads the jobs file, if any, and recomputes each job's next run
+func (s *Scheduler) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read jobs: %v", err)
+	}
+
+	var jobs []*Job
+	err = json.Unmarshal(content, &jobs)
+	if err != nil {
+		return fmt.Errorf("failed to parse jobs %s: %v", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for _, job := range jobs {
+		if job.Schedule != "" {
+			job.cron, err = ParseCron(job.Schedule)
+			if err != nil {
+				return fmt.Errorf("job %s: %v", job.ID, err)
+			}
+		}
+		job.NextRun = job.nextAfter(now)
+		s.jobs[job.ID] = job
+	}
+	fmt.Printf("📂 Loaded %d scheduled jobs\n", len(jobs))
+	return nil
+}
+
+// saveLocked writes every job to the jobs file
+func (s *Scheduler) saveLocked() error {
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return writeJSONFile(s.path, jobs)
+}
+
+// Handler serves the job management API: GET /jobs lists every job, and
+// POST /jobs/{id}/pause, /jobs/{id}/resume or /jobs/{id}/trigger changes or
+// runs one
+func (s *Scheduler) Handler() http.Handler {
+	actions := map[string]func(string) error{
+		"pause":   s.Pause,
+		"resume":  s.Resume,
+		"trigger": s.Trigger,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		if rest == "" {
+			if r.Method != http.MethodGet {
+				writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.List()})
+			return
+		}
+
+		slash := strings.LastIndex(rest, "/")
+		action, ok := actions[rest[slash+1:]]
+		if slash < 0 || !ok {
+			writeAPIError(w, http.StatusNotFound, "use /jobs/{id}/pause, /jobs/{id}/resume or /jobs/{id}/trigger")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		id, err := url.PathUnescape(rest[:slash])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad job id: %v", err))
+			return
+		}
+		err = action(id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrNoJob):
+				status = http.StatusNotFound
+			case errors.Is(err, ErrJobRunning):
+				status = http.StatusConflict
+			}
+			writeAPIError(w, status, err.Error())
+			return
+		}
+
+		s.mu.Lock()
+		job, ok := s.jobs[id]
+		var snapshot Job
+		if ok {
+			snapshot = *job
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, fmt.Sprintf("%v: %s", ErrNoJob, id))
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, snapshot)
+	})
+}
+
+// RegisterBridgeJobs adds the built-in job handlers:
+//
+//	send_message     sends args.payload as an args.type message to args.target,
+//	                 the building block for digests, drip emails and backfills
+//	prune_processed  deletes files in args.dir (default the file transport's
+//	                 processed directory) older than args.max_age (default 720h)
+//	deliver_message  sends or handles a message held for its deliver_at; the
+//	                 bridge schedules these itself. One whose send fails is
+//	                 kept with its error, to be run again.
+func RegisterBridgeJobs(s *Scheduler, gb *GoBridge) {
+	gb.mu.Lock()
+	gb.scheduler = s
+	gb.mu.Unlock()
+	s.Handle("deliver_message", func(job Job) error {
+		err := gb.deliverDue(job)
+		if err != nil {
+			return err
+		}
+		return s.Remove(job.ID)
+	})
+
+	s.Handle("send_message", func(job Job) error {
+		_, err := scenarioActions["send"](gb, job.Args)
+		return err
+	})
+
+	s.Handle("prune_processed", func(job Job) error {
+		dir := stringArg(job.Args, "dir")
+		if dir == "" {
+			dir = filepath.Join(DefaultDataDir, "go", "processed")
+			if files, ok := gb.transport.(*FileTransport); ok {
+				dir = files.processedDir
+			}
+		}
+		maxAge := 30 * 24 * time.Hour
+		if value := stringArg(job.Args, "max_age"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("bad max_age: %v", err)
+			}
+			maxAge = parsed
+		}
+		return pruneOlderThan(dir, gb.clock.Now().Add(-maxAge))
+	})
+}
+
+// pruneOlderThan removes regular files in dir last modified before cutoff
+func pruneOlderThan(dir string, cutoff time.Time) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !entry.ModTime().Before(cutoff) {
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+	fmt.Printf("🧹 Pruned %d files from %s\n", removed, dir)
+	return nil
+}
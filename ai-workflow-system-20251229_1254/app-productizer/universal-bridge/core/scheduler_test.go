@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSchedulerHandler(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	scheduler := NewScheduler("", clock)
+	t.Cleanup(scheduler.Stop)
+	ran := make(chan string, 1)
+	release := make(chan struct{})
+	scheduler.Handle("digest", func(job Job) error {
+		ran <- job.ID
+		<-release
+		return nil
+	})
+	if _, err := scheduler.Schedule("daily digest", "digest", "0 9 * * *", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		scheduler.Handler().ServeHTTP(response, httptest.NewRequest(method, path, nil))
+		return response
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"list", http.MethodGet, "/jobs", http.StatusOK},
+		{"list with POST", http.MethodPost, "/jobs", http.StatusMethodNotAllowed},
+		{"pause", http.MethodPost, "/jobs/daily%20digest/pause", http.StatusOK},
+		{"pause with GET", http.MethodGet, "/jobs/daily%20digest/pause", http.StatusMethodNotAllowed},
+		{"unknown job", http.MethodPost, "/jobs/weekly/trigger", http.StatusNotFound},
+		{"unknown action", http.MethodPost, "/jobs/daily%20digest/delete", http.StatusNotFound},
+		{"no action", http.MethodPost, "/jobs/daily%20digest", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if response := serve(tt.method, tt.path); response.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, response.Code, tt.wantStatus, response.Body)
+		}
+	}
+
+	var list struct{ Jobs []Job }
+	if err := json.Unmarshal(serve(http.MethodGet, "/jobs").Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Jobs) != 1 || list.Jobs[0].ID != "daily digest" || !list.Jobs[0].Paused {
+		t.Fatalf("listed %+v, want the paused daily digest", list.Jobs)
+	}
+
+	// A paused job still runs when triggered, but not twice at once
+	if response := serve(http.MethodPost, "/jobs/daily%20digest/trigger"); response.Code != http.StatusOK {
+		t.Fatalf("trigger status %d: %s", response.Code, response.Body)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("triggered job never ran")
+	}
+	if response := serve(http.MethodPost, "/jobs/daily%20digest/trigger"); response.Code != http.StatusConflict {
+		t.Fatalf("trigger while running status %d, want %d", response.Code, http.StatusConflict)
+	}
+	close(release)
+
+	if response := serve(http.MethodPost, "/jobs/daily%20digest/resume"); response.Code != http.StatusOK {
+		t.Fatalf("resume status %d: %s", response.Code, response.Body)
+	}
+	if jobs := scheduler.List(); jobs[0].Paused {
+		t.Fatal("job still paused after resume")
+	}
+}
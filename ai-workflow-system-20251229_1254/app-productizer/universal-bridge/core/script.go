@@ -0,0 +1,846 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Script is a compiled expression in the small language script hooks are
+// written in, close to expr's:
+//
+//	price >= 100 and not (email endsWith "@example.com")
+//	refunded ? 0 : price * quantity
+//	customer.country in ["US", "CA"] ?? false
+//
+// Names are looked up in the variables Eval is given; a missing name or
+// field is nil rather than an error, so ?? supplies defaults. Numbers are
+// float64, with numeric strings converted where arithmetic needs numbers.
+// There are no loops or assignments, so every script finishes.
+type Script struct {
+	source string
+	root   scriptNode
+}
+
+// scriptFunctions are the functions a script may call
+var scriptFunctions = map[string]func(args []interface{}) (interface{}, error){
+	"len": func(args []interface{}) (interface{}, error) {
+		switch value := args[0].(type) {
+		case string:
+			return float64(utf8.RuneCountInString(value)), nil
+		case []interface{}:
+			return float64(len(value)), nil
+		case map[string]interface{}:
+			return float64(len(value)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("len of %s", scriptType(args[0]))
+	},
+	"lower":  stringFunction(strings.ToLower),
+	"upper":  stringFunction(strings.ToUpper),
+	"trim":   stringFunction(strings.TrimSpace),
+	"floor":  numberFunction(math.Floor),
+	"ceil":   numberFunction(math.Ceil),
+	"abs":    numberFunction(math.Abs),
+	"number": numberFunction(func(n float64) float64 { return n }),
+	"round": func(args []interface{}) (interface{}, error) {
+		n, err := scriptNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		places := 0.0
+		if len(args) > 1 {
+			if places, err = scriptNumber(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		scale := math.Pow(10, places)
+		return math.Round(n*scale) / scale, nil
+	},
+	"min": extremeFunction(func(a, b float64) bool { return a < b }),
+	"max": extremeFunction(func(a, b float64) bool { return a > b }),
+	"string": func(args []interface{}) (interface{}, error) {
+		return scriptString(args[0]), nil
+	},
+	"split": func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		sep, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("split needs strings")
+		}
+		var parts []interface{}
+		for _, part := range strings.Split(s, sep) {
+			parts = append(parts, part)
+		}
+		return parts, nil
+	},
+}
+
+// scriptArity is how many arguments each function takes, as min and max
+var scriptArity = map[string][2]int{
+	"len": {1, 1}, "lower": {1, 1}, "upper": {1, 1}, "trim": {1, 1}, "floor": {1, 1}, "ceil": {1, 1},
+	"abs": {1, 1}, "number": {1, 1}, "round": {1, 2}, "min": {1, -1}, "max": {1, -1}, "string": {1, 1}, "split": {2, 2},
+}
+
+func stringFunction(f func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		switch value := args[0].(type) {
+		case string:
+			return f(value), nil
+		case nil:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("expected a string, got %s", scriptType(args[0]))
+	}
+}
+
+func numberFunction(f func(float64) float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		n, err := scriptNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(n), nil
+	}
+}
+
+func extremeFunction(better func(a, b float64) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		// One list argument is compared item by item
+		if list, ok := args[0].([]interface{}); ok && len(args) == 1 {
+			args = list
+		}
+		if len(args) == 0 {
+			return nil, nil
+		}
+		best, err := scriptNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args[1:] {
+			n, err := scriptNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			if better(n, best) {
+				best = n
+			}
+		}
+		return best, nil
+	}
+}
+
+// CompileScript parses source, reporting the column of the first mistake
+func CompileScript(source string) (*Script, error) {
+	tokens, err := scanScript(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &scriptParser{tokens: tokens}
+	root, err := parser.expression()
+	if err != nil {
+		return nil, err
+	}
+	if next := parser.peek(); next.kind != scriptEOF {
+		return nil, fmt.Errorf("col %d: unexpected %s", next.col, next)
+	}
+	return &Script{source: source, root: root}, nil
+}
+
+// Eval runs the script with the variables
+func (s *Script) Eval(vars map[string]interface{}) (interface{}, error) {
+	return s.root.eval(vars)
+}
+
+// EvalBool runs a script that must decide something
+func (s *Script) EvalBool(vars map[string]interface{}) (bool, error) {
+	value, err := s.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	decided, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("result is %s, not true or false", scriptType(value))
+	}
+	return decided, nil
+}
+
+// String returns the script's source
+func (s *Script) String() string {
+	return s.source
+}
+
+type scriptTokenKind int
+
+const (
+	scriptEOF scriptTokenKind = iota
+	scriptNumberToken
+	scriptStringToken
+	scriptName
+	scriptOperator
+)
+
+type scriptToken struct {
+	kind  scriptTokenKind
+	text  string
+	value interface{} // a number or string literal's value
+	col   int
+}
+
+func (t scriptToken) String() string {
+	if t.kind == scriptEOF {
+		return "end of script"
+	}
+	return strconv.Quote(t.text)
+}
+
+// scriptOperators are the symbols, longest first so "==" is not read as "="
+var scriptOperators = []string{"??", "==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", "(", ")", "[", "]", ",", "."}
+
+func scanScript(source string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		col := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.' || source[i] == '_') {
+				i++
+			}
+			text := source[start:i]
+			n, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+			if err != nil {
+				return nil, fmt.Errorf("col %d: bad number %q", col, text)
+			}
+			tokens = append(tokens, scriptToken{kind: scriptNumberToken, text: text, value: n, col: col})
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != c {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("col %d: unterminated string", col)
+			}
+			text := source[i : end+1]
+			quoted := text
+			if c == '\'' {
+				quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("col %d: bad string %s", col, text)
+			}
+			tokens = append(tokens, scriptToken{kind: scriptStringToken, text: text, value: value, col: col})
+			i = end + 1
+		case scriptNameByte(c):
+			start := i
+			for i < len(source) && (scriptNameByte(source[i]) || source[i] >= '0' && source[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, scriptToken{kind: scriptName, text: source[start:i], col: col})
+		default:
+			matched := ""
+			for _, op := range scriptOperators {
+				if strings.HasPrefix(source[i:], op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, fmt.Errorf("col %d: unexpected %q", col, string(c))
+			}
+			tokens = append(tokens, scriptToken{kind: scriptOperator, text: matched, col: col})
+			i += len(matched)
+		}
+	}
+	return append(tokens, scriptToken{kind: scriptEOF, col: len(source) + 1}), nil
+}
+
+// scriptNameByte reports whether c may start a name; payload fields are
+// ASCII
+func scriptNameByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// scriptParser reads tokens by precedence, loosest first: ?:, ??, or, and,
+// comparisons, + -, * / %, unary not and -, then member access and calls
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+}
+
+func (p *scriptParser) peek() scriptToken {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() scriptToken {
+	token := p.tokens[p.pos]
+	if token.kind != scriptEOF {
+		p.pos++
+	}
+	return token
+}
+
+// accept consumes the next token if it is one of the operators or words
+func (p *scriptParser) accept(texts ...string) (string, bool) {
+	token := p.peek()
+	if token.kind != scriptOperator && token.kind != scriptName {
+		return "", false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *scriptParser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		token := p.peek()
+		return fmt.Errorf("col %d: expected %q, found %s", token.col, text, token)
+	}
+	return nil
+}
+
+func (p *scriptParser) expression() (scriptNode, error) {
+	condition, err := p.coalesce()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return condition, nil
+	}
+	then, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return scriptTernary{condition, then, otherwise}, nil
+}
+
+// binaryLevel parses a left-associative run of the operators over operands
+// parsed by operand
+func (p *scriptParser) binaryLevel(operand func() (scriptNode, error), build func(op string, left, right scriptNode) scriptNode, ops ...string) (scriptNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = build(op, left, right)
+	}
+}
+
+func (p *scriptParser) coalesce() (scriptNode, error) {
+	return p.binaryLevel(p.or, func(op string, left, right scriptNode) scriptNode {
+		return scriptCoalesce{left, right}
+	}, "??")
+}
+
+func (p *scriptParser) or() (scriptNode, error) {
+	return p.binaryLevel(p.and, func(op string, left, right scriptNode) scriptNode {
+		return scriptLogical{and: false, left: left, right: right}
+	}, "or", "||")
+}
+
+func (p *scriptParser) and() (scriptNode, error) {
+	return p.binaryLevel(p.comparison, func(op string, left, right scriptNode) scriptNode {
+		return scriptLogical{and: true, left: left, right: right}
+	}, "and", "&&")
+}
+
+// comparison does not chain: a < b < c is a mistake
+func (p *scriptParser) comparison() (scriptNode, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	negate := false
+	if p.peek().text == "not" && p.tokens[p.pos+1].text == "in" {
+		p.pos++
+		negate = true
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in", "contains", "startsWith", "endsWith", "matches")
+	if !ok {
+		if negate {
+			return nil, fmt.Errorf("col %d: expected in after not", p.peek().col)
+		}
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	var node scriptNode = scriptBinary{op, left, right}
+	if op == "matches" {
+		if literal, ok := right.(scriptLiteral); ok {
+			pattern, isString := literal.value.(string)
+			if !isString {
+				return nil, fmt.Errorf("matches needs a regular expression, not %s", scriptType(literal.value))
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("matches: %v", err)
+			}
+			node = scriptMatch{left, compiled}
+		}
+	}
+	if negate {
+		node = scriptNot{node}
+	}
+	return node, nil
+}
+
+func (p *scriptParser) sum() (scriptNode, error) {
+	return p.binaryLevel(p.product, func(op string, left, right scriptNode) scriptNode {
+		return scriptBinary{op, left, right}
+	}, "+", "-")
+}
+
+func (p *scriptParser) product() (scriptNode, error) {
+	return p.binaryLevel(p.unary, func(op string, left, right scriptNode) scriptNode {
+		return scriptBinary{op, left, right}
+	}, "*", "/", "%")
+}
+
+func (p *scriptParser) unary() (scriptNode, error) {
+	if op, ok := p.accept("not", "!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return scriptBinary{"-", scriptLiteral{0.0}, operand}, nil
+		}
+		return scriptNot{operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *scriptParser) postfix() (scriptNode, error) {
+	node, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.peek().text == "." && p.peek().kind == scriptOperator:
+			p.pos++
+			name := p.next()
+			if name.kind != scriptName {
+				return nil, fmt.Errorf("col %d: expected a field name after '.', found %s", name.col, name)
+			}
+			node = scriptIndex{node, scriptLiteral{name.text}}
+		case p.peek().text == "[" && p.peek().kind == scriptOperator:
+			p.pos++
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = scriptIndex{node, index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *scriptParser) primary() (scriptNode, error) {
+	token := p.next()
+	switch token.kind {
+	case scriptNumberToken, scriptStringToken:
+		return scriptLiteral{token.value}, nil
+	case scriptName:
+		switch token.text {
+		case "true":
+			return scriptLiteral{true}, nil
+		case "false":
+			return scriptLiteral{false}, nil
+		case "nil", "null":
+			return scriptLiteral{nil}, nil
+		}
+		if p.peek().text != "(" || p.peek().kind != scriptOperator {
+			return scriptVariable(token.text), nil
+		}
+		function, known := scriptFunctions[token.text]
+		if !known {
+			return nil, fmt.Errorf("col %d: unknown function %s", token.col, token.text)
+		}
+		p.pos++
+		args, err := p.list(")")
+		if err != nil {
+			return nil, err
+		}
+		arity := scriptArity[token.text]
+		if len(args) < arity[0] || (arity[1] >= 0 && len(args) > arity[1]) {
+			return nil, fmt.Errorf("col %d: wrong number of arguments to %s", token.col, token.text)
+		}
+		return scriptCall{token.text, function, args}, nil
+	case scriptOperator:
+		switch token.text {
+		case "(":
+			node, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return scriptList(items), nil
+		}
+	}
+	return nil, fmt.Errorf("col %d: unexpected %s", token.col, token)
+}
+
+// list parses comma-separated expressions up to the closing bracket
+func (p *scriptParser) list(closing string) ([]scriptNode, error) {
+	var items []scriptNode
+	if _, ok := p.accept(closing); ok {
+		return items, nil
+	}
+	for {
+		item, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if _, ok := p.accept(","); !ok {
+			return items, p.expect(closing)
+		}
+	}
+}
+
+// scriptNode is a parsed expression
+type scriptNode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type scriptLiteral struct{ value interface{} }
+
+func (n scriptLiteral) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type scriptVariable string
+
+func (n scriptVariable) eval(vars map[string]interface{}) (interface{}, error) {
+	return vars[string(n)], nil
+}
+
+type scriptList []scriptNode
+
+func (n scriptList) eval(vars map[string]interface{}) (interface{}, error) {
+	items := make([]interface{}, len(n))
+	for i, item := range n {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = value
+	}
+	return items, nil
+}
+
+// scriptIndex reads a field or list item; missing ones are nil
+type scriptIndex struct{ object, index scriptNode }
+
+func (n scriptIndex) eval(vars map[string]interface{}) (interface{}, error) {
+	object, err := n.object.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch object := object.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return object[scriptString(index)], nil
+	case []interface{}:
+		i, err := scriptNumber(index)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(object) || i != math.Trunc(i) {
+			return nil, nil
+		}
+		return object[int(i)], nil
+	}
+	return nil, fmt.Errorf("cannot read %s of %s", scriptString(index), scriptType(object))
+}
+
+type scriptCall struct {
+	name     string
+	function func([]interface{}) (interface{}, error)
+	args     []scriptNode
+}
+
+func (n scriptCall) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	value, err := n.function(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return value, nil
+}
+
+type scriptTernary struct{ condition, then, otherwise scriptNode }
+
+func (n scriptTernary) eval(vars map[string]interface{}) (interface{}, error) {
+	condition, err := evalBool(n.condition, vars, "?")
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type scriptCoalesce struct{ left, right scriptNode }
+
+func (n scriptCoalesce) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.left.eval(vars)
+	if err != nil || value != nil {
+		return value, err
+	}
+	return n.right.eval(vars)
+}
+
+// scriptLogical is and or or, evaluating the right side only when needed
+type scriptLogical struct {
+	and         bool
+	left, right scriptNode
+}
+
+func (n scriptLogical) eval(vars map[string]interface{}) (interface{}, error) {
+	op := "or"
+	if n.and {
+		op = "and"
+	}
+	left, err := evalBool(n.left, vars, op)
+	if err != nil || left != n.and {
+		return left, err
+	}
+	return evalBool(n.right, vars, op)
+}
+
+type scriptNot struct{ operand scriptNode }
+
+func (n scriptNot) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := evalBool(n.operand, vars, "not")
+	return !value, err
+}
+
+func evalBool(node scriptNode, vars map[string]interface{}, op string) (bool, error) {
+	value, err := node.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	decided, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs true or false, got %s", op, scriptType(value))
+	}
+	return decided, nil
+}
+
+// scriptMatch is matches with a pattern compiled along with the script
+type scriptMatch struct {
+	left    scriptNode
+	pattern *regexp.Regexp
+}
+
+func (n scriptMatch) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.left.eval(vars)
+	if err != nil || value == nil {
+		return false, err
+	}
+	return n.pattern.MatchString(scriptString(value)), nil
+}
+
+type scriptBinary struct {
+	op          string
+	left, right scriptNode
+}
+
+func (n scriptBinary) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return scriptEqual(left, right), nil
+	case "!=":
+		return !scriptEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return nil, fmt.Errorf("cannot compare %s %s %s", scriptType(left), n.op, scriptType(right))
+		}
+		return ruleCompare(left, right, map[string]func(int) bool{
+			"<":  func(c int) bool { return c < 0 },
+			"<=": func(c int) bool { return c <= 0 },
+			">":  func(c int) bool { return c > 0 },
+			">=": func(c int) bool { return c >= 0 },
+		}[n.op]), nil
+	case "in":
+		switch right := right.(type) {
+		case []interface{}:
+			for _, item := range right {
+				if scriptEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			_, ok := right[scriptString(left)]
+			return ok, nil
+		case string:
+			return left != nil && strings.Contains(right, scriptString(left)), nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("in needs a list, object or string, got %s", scriptType(right))
+	case "contains", "startsWith", "endsWith", "matches":
+		if left == nil || right == nil {
+			return false, nil
+		}
+		s, pattern := scriptString(left), scriptString(right)
+		switch n.op {
+		case "contains":
+			return strings.Contains(s, pattern), nil
+		case "startsWith":
+			return strings.HasPrefix(s, pattern), nil
+		case "endsWith":
+			return strings.HasSuffix(s, pattern), nil
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("matches: %v", err)
+		}
+		return compiled.MatchString(s), nil
+	case "+":
+		// Two strings join; anything else adds as numbers
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+	}
+
+	l, err := scriptNumber(left)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.op, err)
+	}
+	r, err := scriptNumber(right)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.op, err)
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	}
+	if r == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if n.op == "%" {
+		return math.Mod(l, r), nil
+	}
+	return l / r, nil
+}
+
+func scriptEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return ruleEqual(a, b)
+}
+
+// scriptNumber converts numbers and numeric strings, refusing the rest
+func scriptNumber(value interface{}) (float64, error) {
+	if n, ok := toNumber(value); ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %s", scriptType(value))
+}
+
+// scriptString formats a value as text, whole numbers without a decimal
+// point
+func scriptString(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func scriptType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	if _, ok := toNumber(value); ok {
+		return "the number " + scriptString(value)
+	}
+	return fmt.Sprintf("a %T", value)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptSet is a list of script hooks loaded from YAML. Each runs on the
+// received messages of its type before anything records or handles them,
+// skipping them or setting payload fields:
+//
+//	scripts:
+//	  - name: skip-test-purchases
+//	    on: sale_event
+//	    skip: test == "true" or email endsWith "@mycompany.com"
+//	  - name: deal-size
+//	    on: sale_event
+//	    when: resource_name == "sale"
+//	    set:
+//	      deal_size: 'price >= 10000 ? "large" : "small"'
+//	      net: price - (gumroad_fee ?? 0)
+//
+// Payload fields are variables, with $type, $source and $id for the
+// message's own. Hooks run in order, each seeing the fields set before it.
+type ScriptSet struct {
+	Scripts []ScriptHook `yaml:"scripts"`
+}
+
+// ScriptHook skips or rewrites the messages of type On, every type when
+// empty, for which When holds
+type ScriptHook struct {
+	Name string            `yaml:"name"`
+	On   MessageType       `yaml:"on"`
+	When string            `yaml:"when"`
+	Skip string            `yaml:"skip"`
+	Set  ScriptAssignments `yaml:"set"`
+
+	when, skip *Script
+}
+
+// ScriptAssignment sets a payload field, possibly a dotted path such as
+// "customer.tier", to a script's result
+type ScriptAssignment struct {
+	Field  string
+	Script string
+
+	compiled *Script
+}
+
+// ScriptAssignments keeps the order set's fields are written in, so later
+// ones can use earlier ones
+type ScriptAssignments []ScriptAssignment
+
+// UnmarshalYAML reads a mapping of field: script
+func (a *ScriptAssignments) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: set needs field: script pairs", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		field, script := node.Content[i], node.Content[i+1]
+		if script.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: %s needs a script", script.Line, field.Value)
+		}
+		*a = append(*a, ScriptAssignment{Field: field.Value, Script: script.Value})
+	}
+	return nil
+}
+
+// LoadScripts reads a script hooks file and compiles every script in it
+func LoadScripts(path string) (*ScriptSet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scripts: %v", err)
+	}
+
+	var scripts ScriptSet
+	err = yaml.Unmarshal(content, &scripts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scripts %s: %v", path, err)
+	}
+
+	err = scripts.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid scripts %s: %v", path, err)
+	}
+	return &scripts, nil
+}
+
+// Compile checks every hook does something and compiles its scripts
+func (ss *ScriptSet) Compile() error {
+	seen := make(map[string]bool)
+	for i := range ss.Scripts {
+		hook := &ss.Scripts[i]
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("script %d", i+1)
+		}
+		if seen[hook.Name] {
+			return fmt.Errorf("%s is listed twice", hook.Name)
+		}
+		seen[hook.Name] = true
+		if hook.Skip == "" && len(hook.Set) == 0 {
+			return fmt.Errorf("%s: needs skip or set", hook.Name)
+		}
+
+		var err error
+		if hook.When != "" {
+			if hook.when, err = CompileScript(hook.When); err != nil {
+				return fmt.Errorf("%s: when: %v", hook.Name, err)
+			}
+		}
+		if hook.Skip != "" {
+			if hook.skip, err = CompileScript(hook.Skip); err != nil {
+				return fmt.Errorf("%s: skip: %v", hook.Name, err)
+			}
+		}
+		for j := range hook.Set {
+			assignment := &hook.Set[j]
+			if !scriptFieldPath.MatchString(assignment.Field) {
+				return fmt.Errorf("%s: cannot set %q; use a field name or dotted path", hook.Name, assignment.Field)
+			}
+			if assignment.compiled, err = CompileScript(assignment.Script); err != nil {
+				return fmt.Errorf("%s: set %s: %v", hook.Name, assignment.Field, err)
+			}
+		}
+	}
+	return nil
+}
+
+var scriptFieldPath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// ScriptHooks runs a script set against every message the bridge receives
+type ScriptHooks struct {
+	bridge  *GoBridge
+	scripts *ScriptSet
+}
+
+// NewScriptHooks attaches the scripts to the bridge's receive filters
+func NewScriptHooks(gb *GoBridge, scripts *ScriptSet) *ScriptHooks {
+	hooks := &ScriptHooks{bridge: gb, scripts: scripts}
+	gb.OnFilter(hooks.Apply)
+	fmt.Printf("📝 Loaded %d scripts\n", len(scripts.Scripts))
+	return hooks
+}
+
+// Apply runs every hook for the message, reporting false if one skips it.
+// A hook whose script fails is logged and leaves the message as it is from
+// that point, so a bad script never loses a sale.
+func (sh *ScriptHooks) Apply(message *UniversalMessage) bool {
+	var vars map[string]interface{}
+	for i := range sh.scripts.Scripts {
+		hook := &sh.scripts.Scripts[i]
+		if hook.On != "" && message.MessageType != hook.On {
+			continue
+		}
+		if vars == nil {
+			vars = scriptVars(message)
+		}
+		err := sh.run(hook, message, vars)
+		if err == errScriptSkip {
+			fmt.Printf("⏭️ Script %s skipped message %s\n", hook.Name, message.ID)
+			return false
+		}
+		if err != nil {
+			log.Printf("❌ Script %s failed for %s: %v", hook.Name, message.ID, err)
+		}
+	}
+	return true
+}
+
+// errScriptSkip is how run reports that a hook skips the message
+var errScriptSkip = errors.New("skipped")
+
+func (sh *ScriptHooks) run(hook *ScriptHook, message *UniversalMessage, vars map[string]interface{}) error {
+	if hook.when != nil {
+		matched, err := hook.when.EvalBool(vars)
+		if err != nil {
+			return fmt.Errorf("when: %v", err)
+		}
+		if !matched {
+			return nil
+		}
+	}
+	if hook.skip != nil {
+		skip, err := hook.skip.EvalBool(vars)
+		if err != nil {
+			return fmt.Errorf("skip: %v", err)
+		}
+		if skip {
+			return errScriptSkip
+		}
+	}
+	for _, assignment := range hook.Set {
+		value, err := assignment.compiled.Eval(vars)
+		if err != nil {
+			return fmt.Errorf("set %s: %v", assignment.Field, err)
+		}
+		if message.Payload == nil {
+			message.Payload = make(map[string]interface{})
+		}
+		if err = setField(message.Payload, assignment.Field, value); err != nil {
+			return fmt.Errorf("set %s: %v", assignment.Field, err)
+		}
+		// Nested objects are shared with the payload; top-level fields are
+		// copied into the variables
+		top := strings.SplitN(assignment.Field, ".", 2)[0]
+		vars[top] = message.Payload[top]
+	}
+	return nil
+}
+
+// scriptVars is the payload's fields and the message's own
+func scriptVars(message *UniversalMessage) map[string]interface{} {
+	vars := make(map[string]interface{}, len(message.Payload)+3)
+	for name, value := range message.Payload {
+		vars[name] = value
+	}
+	vars["$type"] = string(message.MessageType)
+	vars["$source"] = message.SourceLanguage
+	vars["$id"] = message.ID
+	return vars
+}
+
+// setField writes value at a dotted path, creating the objects on the way
+func setField(payload map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	object := payload
+	for i, key := range keys[:len(keys)-1] {
+		next, present := object[key]
+		if !present || next == nil {
+			created := make(map[string]interface{})
+			object[key] = created
+			object = created
+			continue
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not an object", strings.Join(keys[:i+1], "."))
+		}
+		object = nested
+	}
+	object[keys[len(keys)-1]] = value
+	return nil
+}
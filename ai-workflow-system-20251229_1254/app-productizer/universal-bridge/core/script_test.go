@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScriptEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"price":    "2900", // Gumroad pings send numbers as strings
+		"quantity": 2.0,
+		"email":    "ada@example.com",
+		"test":     false,
+		"tags":     []interface{}{"vip", "launch"},
+		"customer": map[string]interface{}{"country": "CA", "orders": 3},
+		"$type":    "sale_event",
+	}
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		{"price * quantity / 100", 58.0},
+		{"price >= 10000 ? 'large' : 'small'", "small"},
+		{"1 + 2 * 3 - -4 % 3", 8.0},
+		{`"deal-" + lower("BIG")`, "deal-big"},
+		{"email endsWith '@example.com' and not test", true},
+		{"email startsWith 'bob' || email contains 'ada'", true},
+		{`email matches "^[a-z]+@"`, true},
+		{"customer.country in ['US', 'CA']", true},
+		{"customer.country not in ['US', 'CA']", false},
+		{"'vip' in tags", true},
+		{"tags[1]", "launch"},
+		{"tags[5] ?? 'none'", "none"},
+		{"customer.region ?? customer.country", "CA"},
+		{"missing.deeply.nested", nil},
+		{"missing == nil", true},
+		{"customer['orders'] > 2", true},
+		{"$type == 'sale_event'", true},
+		{"round(10 / 3, 2)", 3.33},
+		{"max(1, price, 5) - min([4, 2, 8])", 2898.0},
+		{"len(tags) + len(email)", 17.0},
+		{"string(price / 100) + ' USD'", "29 USD"},
+		{"split('a,b', ',')", []interface{}{"a", "b"}},
+		{"(price > 1000) == true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			script, err := CompileScript(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := script.Eval(vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		compile string
+		eval    string
+	}{
+		{"price >", "col 8: unexpected end of script", ""},
+		{"price ** 2", `col 8: unexpected "*"`, ""},
+		{"'unterminated", "col 1: unterminated string", ""},
+		{"price = 1", `col 7: unexpected "="`, ""},
+		{"frobnicate(price)", "col 1: unknown function frobnicate", ""},
+		{"round()", "col 1: wrong number of arguments to round", ""},
+		{"email matches '('", "matches: error parsing regexp", ""},
+		{"a ? b", `col 6: expected ":", found end of script`, ""},
+		{"email and true", "", `and needs true or false, got "ada@example.com"`},
+		{"email * 2", "", `*: expected a number, got "ada@example.com"`},
+		{"1 / 0", "", "division by zero"},
+		{"missing > 5", "", "cannot compare nil > the number 5"},
+		{"upper(5)", "", "upper: expected a string, got the number 5"},
+		{"email.domain", "", `cannot read domain of "ada@example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			script, err := CompileScript(tt.source)
+			if tt.compile != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.compile) {
+					t.Fatalf("compile error %v, want %q", err, tt.compile)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = script.Eval(map[string]interface{}{"email": "ada@example.com"})
+			if err == nil || err.Error() != tt.eval {
+				t.Errorf("eval error %v, want %q", err, tt.eval)
+			}
+		})
+	}
+}
+
+func writeScripts(t *testing.T, content string) (*ScriptSet, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scripts.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadScripts(path)
+}
+
+func TestScriptHooks(t *testing.T) {
+	scripts, err := writeScripts(t, `scripts:
+  - name: skip-test-purchases
+    on: sale_event
+    skip: test == "true" or email endsWith "@mycompany.com"
+  - name: deal-size
+    on: sale_event
+    when: resource_name == "sale"
+    set:
+      net: price - (gumroad_fee ?? 0)
+      deal_size: 'net >= 10000 ? "large" : "small"'
+      meta.scored_by: '"script " + $source'
+  - name: broken
+    set:
+      never: price / 0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	NewScriptHooks(gb, scripts)
+	var handled []map[string]interface{}
+	gb.OnMessage(SaleEvent, func(message *UniversalMessage) error {
+		handled = append(handled, message.Payload)
+		return nil
+	})
+
+	ids := NewSequentialIDs("gumroad")
+	for _, payload := range []map[string]interface{}{
+		{"resource_name": "sale", "sale_id": "s1", "email": "ada@example.com", "price": "12900", "gumroad_fee": 1300},
+		{"resource_name": "sale", "sale_id": "s2", "email": "qa@mycompany.com", "price": "2900"},
+		{"resource_name": "sale", "sale_id": "s3", "email": "bob@example.com", "price": "2900", "test": "true"},
+		{"resource_name": "refund", "sale_id": "s1", "email": "ada@example.com", "price": "12900"},
+	} {
+		if reason := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+
+	if len(handled) != 2 || handled[0]["sale_id"] != "s1" || handled[1]["resource_name"] != "refund" {
+		t.Fatalf("handled %v, want sale s1 and its refund", handled)
+	}
+	sale := handled[0]
+	if sale["net"] != 11600.0 || sale["deal_size"] != "large" || !reflect.DeepEqual(sale["meta"], map[string]interface{}{"scored_by": "script gumroad"}) {
+		t.Errorf("sale payload %v", sale)
+	}
+	// The failing hook set nothing, and the refund did not match when
+	if _, set := sale["never"]; set {
+		t.Error("a failing script set its field")
+	}
+	if _, set := handled[1]["deal_size"]; set {
+		t.Error("refund was scored")
+	}
+}
+
+func TestLoadScripts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"nothing to do", "scripts:\n  - name: idle\n    when: 'true'\n", "idle: needs skip or set"},
+		{"twice", "scripts:\n  - name: a\n    skip: 'true'\n  - name: a\n    skip: 'false'\n", "a is listed twice"},
+		{"bad field", "scripts:\n  - set:\n      'deal size': '1'\n", `script 1: cannot set "deal size"`},
+		{"bad script", "scripts:\n  - name: a\n    set:\n      x: 'price +'\n", "a: set x: col 8: unexpected end of script"},
+		{"not a mapping", "scripts:\n  - name: a\n    set: [x]\n", "set needs field: script pairs"},
+		{"unknown", "scripts:\n  - name: a\n    skip: frob()\n", "a: skip: col 1: unknown function frob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writeScripts(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Seasonal sale statuses
+const (
+	SeasonalScheduled = "scheduled"
+	SeasonalRunning   = "running"
+	SeasonalFinished  = "finished"
+)
+
+// SeasonalSale is a time-boxed discount across several products. At
+// StartsAt the bridge creates the offer code on each product, adds a banner
+// to their descriptions and announces the sale on the Announce sinks; at
+// EndsAt it deletes the codes and restores the descriptions.
+type SeasonalSale struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Products  []string  `json:"products"`
+	Code      string    `json:"code"`
+	AmountOff float64   `json:"amount_off"`
+	OfferType string    `json:"offer_type"` // "cents" or "percent"
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Announce  []string  `json:"announce,omitempty"` // sink names
+	Status    string    `json:"status"`
+	// Descriptions holds each product's description from before the sale
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+	Errors       []string          `json:"errors,omitempty"`
+}
+
+// SeasonalSales schedules seasonal sales and runs them through the Gumroad API
+type SeasonalSales struct {
+	bridge    *GoBridge
+	client    *GumroadClient
+	offers    *OfferCodes
+	templates *TemplateStore
+	scheduler *Scheduler
+	path      string
+
+	mu    sync.Mutex
+	sales map[string]*SeasonalSale
+}
+
+// NewSeasonalSales opens the sales persisted at path and registers the
+// seasonal_sale_start and seasonal_sale_end job handlers on the scheduler,
+// which runs each sale's start and end as one-shot jobs
+func NewSeasonalSales(gb *GoBridge, client *GumroadClient, offers *OfferCodes, templates *TemplateStore, scheduler *Scheduler, path string) (*SeasonalSales, error) {
+	ss := &SeasonalSales{
+		bridge:    gb,
+		client:    client,
+		offers:    offers,
+		templates: templates,
+		scheduler: scheduler,
+		path:      path,
+		sales:     make(map[string]*SeasonalSale),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read seasonal sales: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &ss.sales)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse seasonal sales %s: %v", path, err)
+		}
+	}
+
+	scheduler.Handle("seasonal_sale_start", func(job Job) error { return ss.start(stringArg(job.Args, "sale_id")) })
+	scheduler.Handle("seasonal_sale_end", func(job Job) error { return ss.end(stringArg(job.Args, "sale_id")) })
+	return ss, nil
+}
+
+// Schedule saves the sale and schedules its start and end
+func (ss *SeasonalSales) Schedule(sale SeasonalSale) (SeasonalSale, error) {
+	switch {
+	case sale.Name == "" || len(sale.Products) == 0 || sale.AmountOff <= 0:
+		return SeasonalSale{}, fmt.Errorf("seasonal sale needs a name, products and amount_off")
+	case !sale.EndsAt.After(sale.StartsAt):
+		return SeasonalSale{}, fmt.Errorf("seasonal sale must end after it starts")
+	case !sale.EndsAt.After(ss.bridge.clock.Now()):
+		return SeasonalSale{}, fmt.Errorf("seasonal sale has already ended")
+	}
+	if sale.ID == "" {
+		sale.ID = ss.bridge.ids.NewID()
+	}
+	if sale.Code == "" {
+		sale.Code = strings.ToUpper(strings.Join(strings.Fields(sale.Name), ""))
+	}
+	if sale.OfferType == "" {
+		sale.OfferType = "percent"
+	}
+	sale.Status = SeasonalScheduled
+	sale.Descriptions = nil
+	sale.Errors = nil
+
+	ss.mu.Lock()
+	ss.sales[sale.ID] = &sale
+	err := ss.saveLocked()
+	ss.mu.Unlock()
+	if err != nil {
+		return SeasonalSale{}, err
+	}
+
+	args := map[string]interface{}{"sale_id": sale.ID}
+	_, err = ss.scheduler.ScheduleOnce("seasonal:"+sale.ID+":start", "seasonal_sale_start", sale.StartsAt, args)
+	if err == nil {
+		_, err = ss.scheduler.ScheduleOnce("seasonal:"+sale.ID+":end", "seasonal_sale_end", sale.EndsAt, args)
+	}
+	if err != nil {
+		return SeasonalSale{}, err
+	}
+	fmt.Printf("🗓️ Scheduled %s: %s to %s on %d products\n", sale.Name, sale.StartsAt.Format(time.RFC3339), sale.EndsAt.Format(time.RFC3339), len(sale.Products))
+	return sale, nil
+}
+
+// start puts the sale live. Each product is handled on its own, so one
+// failing product is recorded on the sale instead of holding up the rest.
+func (ss *SeasonalSales) start(id string) error {
+	sale, err := ss.transition(id, SeasonalScheduled, SeasonalRunning)
+	if err != nil {
+		return err
+	}
+	if sale == nil {
+		return nil
+	}
+
+	descriptions := make(map[string]string)
+	var errs []string
+	for _, product := range sale.Products {
+		endsAt := sale.EndsAt
+		_, err := ss.offers.Create(OfferCode{
+			Product:   product,
+			Code:      sale.Code,
+			Campaign:  "seasonal-" + sale.ID,
+			AmountOff: sale.AmountOff,
+			OfferType: sale.OfferType,
+			ExpiresAt: &endsAt,
+			OnRetire:  OfferDelete,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: offer code: %v", product, err))
+			continue
+		}
+
+		current, err := ss.client.Product(product)
+		if err == nil {
+			var banner string
+			banner, err = ss.render("seasonal_sale_banner", product, sale)
+			if err == nil {
+				err = ss.client.UpdateProductDescription(product, banner+current.Description)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: description: %v", product, err))
+			continue
+		}
+		descriptions[product] = current.Description
+	}
+
+	ss.mu.Lock()
+	ss.sales[id].Descriptions = descriptions
+	ss.sales[id].Errors = append(ss.sales[id].Errors, errs...)
+	saveErr := ss.saveLocked()
+	ss.mu.Unlock()
+
+	ss.announce(sale, "seasonal_sale_start")
+	fmt.Printf("🎉 %s is live on %d products\n", sale.Name, len(sale.Products)-len(errs))
+	return joinErrors(saveErr, errs)
+}
+
+// end reverts the sale: codes deleted, descriptions restored
+func (ss *SeasonalSales) end(id string) error {
+	sale, err := ss.transition(id, SeasonalRunning, SeasonalFinished)
+	if err != nil {
+		return err
+	}
+	if sale == nil {
+		// A sale that never started only needs marking finished
+		ss.mu.Lock()
+		if pending := ss.sales[id]; pending != nil && pending.Status == SeasonalScheduled {
+			pending.Status = SeasonalFinished
+			ss.saveLocked()
+		}
+		ss.mu.Unlock()
+		return nil
+	}
+
+	var errs []string
+	for _, product := range sale.Products {
+		err := ss.offers.Retire(product, sale.Code)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: offer code: %v", product, err))
+		}
+		if original, ok := sale.Descriptions[product]; ok {
+			err = ss.client.UpdateProductDescription(product, original)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: description: %v", product, err))
+			}
+		}
+	}
+
+	ss.mu.Lock()
+	ss.sales[id].Errors = append(ss.sales[id].Errors, errs...)
+	saveErr := ss.saveLocked()
+	ss.mu.Unlock()
+
+	ss.announce(sale, "seasonal_sale_end")
+	fmt.Printf("🏁 %s has ended\n", sale.Name)
+	return joinErrors(saveErr, errs)
+}
+
+// transition moves a sale from one status to the next and returns a copy,
+// or nil when it is not in the from status (already run, say, after a restart)
+func (ss *SeasonalSales) transition(id, from, to string) (*SeasonalSale, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sale, ok := ss.sales[id]
+	if !ok {
+		return nil, fmt.Errorf("seasonal sale %s not found", id)
+	}
+	if sale.Status != from {
+		return nil, nil
+	}
+	sale.Status = to
+	snapshot := *sale
+	return &snapshot, ss.saveLocked()
+}
+
+// announce posts the named template to each of the sale's announce sinks
+func (ss *SeasonalSales) announce(sale *SeasonalSale, name string) {
+	for _, sinkName := range sale.Announce {
+		sink := ss.bridge.Sink(sinkName)
+		if sink == nil {
+			log.Printf("❌ Cannot announce %s: no sink named %s", sale.Name, sinkName)
+			continue
+		}
+		text, err := ss.render(name, "", sale)
+		if err == nil {
+			err = sink.Enqueue(ss.bridge.NewMessage(DataSync, "go", map[string]interface{}{"text": text}, FileSystem))
+		}
+		if err != nil {
+			log.Printf("❌ Error announcing %s on %s: %v", sale.Name, sinkName, err)
+		}
+	}
+}
+
+func (ss *SeasonalSales) render(name, product string, sale *SeasonalSale) (string, error) {
+	products := make([]interface{}, len(sale.Products))
+	for i, p := range sale.Products {
+		products[i] = p
+	}
+	return ss.templates.Render(name, product, map[string]interface{}{
+		"name":      sale.Name,
+		"code":      sale.Code,
+		"discount":  formatDiscount(sale.AmountOff, sale.OfferType),
+		"starts_at": sale.StartsAt.Format("2006-01-02 15:04 MST"),
+		"ends_at":   sale.EndsAt.Format("2006-01-02 15:04 MST"),
+		"products":  products,
+	})
+}
+
+// List returns every seasonal sale, soonest first
+func (ss *SeasonalSales) List() []SeasonalSale {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	list := make([]SeasonalSale, 0, len(ss.sales))
+	for _, sale := range ss.sales {
+		list = append(list, *sale)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartsAt.Before(list[j].StartsAt) })
+	return list
+}
+
+// Handler serves /seasonal-sales: GET lists the sales, POST schedules the
+// sale in the JSON body
+func (ss *SeasonalSales) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"sales": ss.List()})
+		case http.MethodPost:
+			var sale SeasonalSale
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&sale)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad seasonal sale: %v", err))
+				return
+			}
+			scheduled, err := ss.Schedule(sale)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeAPIJSON(w, http.StatusCreated, scheduled)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET or POST")
+		}
+	})
+}
+
+func (ss *SeasonalSales) saveLocked() error {
+	return writeJSONFile(ss.path, ss.sales)
+}
+
+// joinErrors folds a save error and per-product failures into one error
+func joinErrors(err error, errs []string) error {
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStoreAPI answers the product and offer code calls a seasonal sale
+// makes, for the products it has descriptions for
+type fakeStoreAPI struct {
+	mu           sync.Mutex
+	descriptions map[string]string // product → description
+	calls        []string
+	next         int
+}
+
+func (f *fakeStoreAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.ParseForm()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/products/"), "/")
+	description, known := f.descriptions[parts[0]]
+	switch {
+	case !known:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"success":false,"message":"not found"}`)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		fmt.Fprintf(w, `{"success":true,"product":{"id":%q,"description":%q}}`, parts[0], description)
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		f.descriptions[parts[0]] = r.Form.Get("description")
+		fmt.Fprint(w, `{"success":true}`)
+	case r.Method == http.MethodPost:
+		f.next++
+		fmt.Fprintf(w, `{"success":true,"offer_code":{"id":"oc%d","name":%q}}`, f.next, r.Form.Get("name"))
+	default:
+		fmt.Fprint(w, `{"success":true}`)
+	}
+}
+
+func (f *fakeStoreAPI) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeStoreAPI) description(product string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.descriptions[product]
+}
+
+func TestSeasonalSales(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	api := &fakeStoreAPI{descriptions: map[string]string{"ebook": "<p>The ebook</p>", "course": "<p>The course</p>"}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	client := NewGumroadClient()
+	client.BaseURL = server.URL
+	client.AccessToken = "token"
+	client.Retries = 0
+	offers, err := NewOfferCodes(client, filepath.Join(dir, "offers.json"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler("", clock)
+	path := filepath.Join(dir, "seasonal.json")
+	seasonal, err := NewSeasonalSales(gb, client, offers, NewTemplateStore("templates"), scheduler, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	announced := make(chan string, 10)
+	gb.AddSink("slack", SinkConfig{}, func(message *UniversalMessage) error {
+		announced <- stringArg(message.Payload, "text")
+		return nil
+	})
+	announcement := func() string {
+		t.Helper()
+		select {
+		case text := <-announced:
+			return text
+		case <-time.After(5 * time.Second):
+			t.Fatal("no announcement")
+			return ""
+		}
+	}
+
+	startsAt, endsAt := clock.Now().Add(24*time.Hour), clock.Now().Add(72*time.Hour)
+	invalid := []SeasonalSale{
+		{Products: []string{"ebook"}, AmountOff: 20, StartsAt: startsAt, EndsAt: endsAt},
+		{Name: "Spring", AmountOff: 20, StartsAt: startsAt, EndsAt: endsAt},
+		{Name: "Spring", Products: []string{"ebook"}, StartsAt: startsAt, EndsAt: endsAt},
+		{Name: "Spring", Products: []string{"ebook"}, AmountOff: 20, StartsAt: endsAt, EndsAt: startsAt},
+		{Name: "Spring", Products: []string{"ebook"}, AmountOff: 20, StartsAt: startsAt.Add(-96 * time.Hour), EndsAt: startsAt.Add(-48 * time.Hour)},
+	}
+	for _, sale := range invalid {
+		if _, err := seasonal.Schedule(sale); err == nil {
+			t.Errorf("scheduled %+v", sale)
+		}
+	}
+
+	sale, err := seasonal.Schedule(SeasonalSale{Name: "Spring Sale", Products: []string{"ebook", "course", "missing"}, AmountOff: 20,
+		StartsAt: startsAt, EndsAt: endsAt, Announce: []string{"slack", "nope"}, Status: SeasonalFinished, Errors: []string{"stale"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sale.ID != "go-000001" || sale.Code != "SPRINGSALE" || sale.OfferType != "percent" || sale.Status != SeasonalScheduled || sale.Errors != nil {
+		t.Errorf("scheduled %+v, want a generated ID and code, in percent, scheduled", sale)
+	}
+	jobs := scheduler.List()
+	if len(jobs) != 2 || jobs[0].ID != "seasonal:go-000001:end" || !jobs[0].RunAt.Equal(endsAt) || jobs[1].Handler != "seasonal_sale_start" || !jobs[1].RunAt.Equal(startsAt) {
+		t.Errorf("jobs %+v, want a one-shot start and end", jobs)
+	}
+
+	run := func(handler, id string) error {
+		return scheduler.handlers[handler](Job{Args: map[string]interface{}{"sale_id": id}})
+	}
+	clock.Advance(24 * time.Hour)
+	if err := run("seasonal_sale_start", sale.ID); err == nil || !strings.Contains(err.Error(), "missing: offer code") {
+		t.Errorf("start = %v, want the missing product's failure", err)
+	}
+	if got := api.description("ebook"); got != "<p><strong>Spring Sale: 20% off with code SPRINGSALE until 2026-03-04 09:00 UTC</strong></p>\n<p>The ebook</p>" {
+		t.Errorf("ebook description %q, want the banner ahead of the original", got)
+	}
+	if text := announcement(); !strings.Contains(text, "*Spring Sale* is live: 20% off 3 products with code *SPRINGSALE*") {
+		t.Errorf("start announcement %q", text)
+	}
+	list := seasonal.List()
+	if list[0].Status != SeasonalRunning || len(list[0].Errors) != 1 || list[0].Descriptions["course"] != "<p>The course</p>" {
+		t.Errorf("running sale %+v", list[0])
+	}
+	if codes := offers.Campaigns(); len(codes) != 1 || codes[0].Campaign != "seasonal-go-000001" || codes[0].Active != 2 {
+		t.Errorf("campaigns %+v, want two active codes", codes)
+	}
+	api.take()
+	if err := run("seasonal_sale_start", sale.ID); err != nil {
+		t.Errorf("second start = %v, want it skipped", err)
+	}
+	if calls := api.take(); len(calls) != 0 {
+		t.Errorf("second start called %v", calls)
+	}
+
+	clock.Advance(48 * time.Hour)
+	if err := run("seasonal_sale_end", sale.ID); err == nil || !strings.Contains(err.Error(), "missing: offer code") {
+		t.Errorf("end = %v, want the missing product's untracked code", err)
+	}
+	if got := api.take(); !reflect.DeepEqual(got, []string{
+		"DELETE /products/ebook/offer_codes/oc1", "PUT /products/ebook",
+		"DELETE /products/course/offer_codes/oc2", "PUT /products/course",
+	}) {
+		t.Errorf("end calls %v, want codes deleted and descriptions restored", got)
+	}
+	if got := api.description("ebook"); got != "<p>The ebook</p>" {
+		t.Errorf("ebook description %q after the sale", got)
+	}
+	if text := announcement(); !strings.Contains(text, "*Spring Sale* has ended; code SPRINGSALE") {
+		t.Errorf("end announcement %q", text)
+	}
+	if finished := seasonal.List()[0]; finished.Status != SeasonalFinished || len(finished.Errors) != 2 {
+		t.Errorf("finished sale %+v, want both failures kept", finished)
+	}
+
+	unstarted, err := seasonal.Schedule(SeasonalSale{Name: "Flash", Products: []string{"ebook"}, AmountOff: 500, OfferType: "cents", StartsAt: clock.Now(), EndsAt: clock.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run("seasonal_sale_end", unstarted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("seasonal_sale_start", unstarted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.take(); len(calls) != 0 {
+		t.Errorf("a sale ended before it started called %v", calls)
+	}
+	if err := run("seasonal_sale_start", "nope"); err == nil {
+		t.Error("started an unknown sale")
+	}
+
+	reopened, err := NewSeasonalSales(gb, client, offers, NewTemplateStore("templates"), NewScheduler("", clock), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(); !reflect.DeepEqual(got, seasonal.List()) || got[1].Status != SeasonalFinished {
+		t.Errorf("after reopening %+v", got)
+	}
+}
+
+func TestSeasonalSalesHandler(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	seasonal, err := NewSeasonalSales(gb, NewGumroadClient(), nil, nil, NewScheduler("", clock), filepath.Join(t.TempDir(), "seasonal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, body string
+		status       int
+		contains     string
+	}{
+		{http.MethodPost, `{"name": "Spring", "products": ["ebook"], "amount_off": 20, "starts_at": "2026-03-02T09:00:00Z", "ends_at": "2026-03-04T09:00:00Z"}`, http.StatusCreated, `"code":"SPRING"`},
+		{http.MethodPost, `{"name": "Spring", "products": ["ebook"], "amount_off": 20, "starts_at": "2026-03-04T09:00:00Z", "ends_at": "2026-03-02T09:00:00Z"}`, http.StatusBadRequest, "end after it starts"},
+		{http.MethodPost, `{"name": `, http.StatusBadRequest, "bad seasonal sale"},
+		{http.MethodGet, "", http.StatusOK, `"status":"scheduled"`},
+		{http.MethodDelete, "", http.StatusMethodNotAllowed, "GET or POST"},
+	}
+	for _, tt := range tests {
+		response := httptest.NewRecorder()
+		seasonal.Handler().ServeHTTP(response, httptest.NewRequest(tt.method, "/seasonal-sales", strings.NewReader(tt.body)))
+		if response.Code != tt.status || !strings.Contains(response.Body.String(), tt.contains) {
+			t.Errorf("%s %s = %d %s, want %d with %q", tt.method, tt.body, response.Code, response.Body.String(), tt.status, tt.contains)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// euCountries are the European Union's member states
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true, "DK": true, "EE": true, "ES": true,
+	"FI": true, "FR": true, "GR": true, "HR": true, "HU": true, "IE": true, "IT": true, "LT": true, "LU": true,
+	"LV": true, "MT": true, "NL": true, "PL": true, "PT": true, "RO": true, "SE": true, "SI": true, "SK": true,
+}
+
+// SegmentSet is a list of customer segments loaded from YAML. Conditions
+// are written as in rules and test the customer's facts: email, name,
+// locale, country, eu, domain_type, orders, refunds, products, ltv (major
+// units per currency, e.g. ltv.usd), first_purchase, last_purchase,
+// days_since_purchase, subscriptions (active), churned, lists, opted_out
+// and support_messages.
+//
+//	segments:
+//	  - name: whales
+//	    description: spent over $200
+//	    when:
+//	      - field: ltv.usd
+//	        gt: 200
+type SegmentSet struct {
+	Segments []Segment `yaml:"segments"`
+}
+
+// Segment is the customers whose facts satisfy all of its conditions
+type Segment struct {
+	Name        string          `yaml:"name"`
+	Description string          `yaml:"description"`
+	When        []RuleCondition `yaml:"when"`
+}
+
+// SegmentMember is a customer in a segment and since when
+type SegmentMember struct {
+	Email    string    `json:"email"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// SegmentSummary is a segment and its size
+type SegmentSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Members     int    `json:"members"`
+}
+
+// LoadSegments reads and validates a segments file
+func LoadSegments(path string) (*SegmentSet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segments: %v", err)
+	}
+
+	var set SegmentSet
+	err = yaml.Unmarshal(content, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse segments %s: %v", path, err)
+	}
+
+	err = set.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid segments %s: %v", path, err)
+	}
+	return &set, nil
+}
+
+// Validate checks every segment has a unique name and a condition
+func (ss *SegmentSet) Validate() error {
+	seen := make(map[string]bool)
+	for i, segment := range ss.Segments {
+		if !languageName.MatchString(segment.Name) {
+			return fmt.Errorf("segment %d: name %q is not a lowercase name such as whales", i+1, segment.Name)
+		}
+		if seen[segment.Name] {
+			return fmt.Errorf("segment %s is defined twice", segment.Name)
+		}
+		seen[segment.Name] = true
+		if len(segment.When) == 0 {
+			return fmt.Errorf("segment %s: needs at least one condition", segment.Name)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a customer's facts satisfy the segment
+func (s Segment) Matches(facts map[string]interface{}) bool {
+	for _, condition := range s.When {
+		if !condition.Matches(facts) {
+			return false
+		}
+	}
+	return true
+}
+
+// facts is what segment conditions test about a customer at now
+func (p CustomerProfile) facts(now time.Time) map[string]interface{} {
+	facts := p.summary()
+	orders, refunds := 0, 0
+	var first, last time.Time
+	for _, purchase := range p.Purchases {
+		// A bundle's components are part of its order
+		if purchase.BundleSaleID != "" {
+			continue
+		}
+		if purchase.Refunded {
+			refunds++
+			continue
+		}
+		orders++
+		if !purchase.CreatedAt.IsZero() && (first.IsZero() || purchase.CreatedAt.Before(first)) {
+			first = purchase.CreatedAt
+		}
+		if purchase.CreatedAt.After(last) {
+			last = purchase.CreatedAt
+		}
+	}
+	facts["orders"] = orders
+	facts["refunds"] = refunds
+	if !last.IsZero() {
+		facts["first_purchase"] = first.UTC().Format(time.RFC3339)
+		facts["last_purchase"] = last.UTC().Format(time.RFC3339)
+		facts["days_since_purchase"] = now.Sub(last).Hours() / 24
+	}
+
+	ltv := make(map[string]interface{}, len(p.Spend))
+	for _, total := range p.Spend.List() {
+		ltv[total.Currency] = total.Major()
+	}
+	facts["ltv"] = ltv
+
+	active, churned := 0, len(p.Subscriptions) > 0
+	for _, subscription := range p.Subscriptions {
+		if subscription.Status == MembershipActive {
+			active++
+			churned = false
+		}
+	}
+	facts["subscriptions"] = active
+	facts["churned"] = churned
+
+	// The latest purchase says where the customer is now
+	if len(p.Purchases) > 0 {
+		latest := p.Purchases[len(p.Purchases)-1].Fields
+		if country := BuyerCountry(latest); country != "" {
+			facts["country"] = country
+			facts["eu"] = euCountries[country]
+		}
+		if domainType, ok := lookupField(latest, "buyer.domain_type"); ok {
+			facts["domain_type"] = domainType
+		}
+	}
+	if _, ok := facts["eu"]; !ok {
+		facts["eu"] = false
+	}
+	return facts
+}
+
+// Segments keeps each customer's segment membership current. A customer is
+// evaluated whenever a message about them arrives, which then carries their
+// segments in its payload for rules to test, and every customer is
+// evaluated by the evaluate_segments job for conditions that change with
+// time. Joining or leaving a segment is announced with a data_sync message
+// of resource_name segment_joined or segment_left, which rules can send
+// emails on. Membership is persisted at path.
+type Segments struct {
+	bridge    *GoBridge
+	customers *Customers
+	set       *SegmentSet
+	path      string
+
+	mu      sync.Mutex
+	members map[string]map[string]time.Time // segment → email → joined at
+}
+
+// NewSegments opens the membership persisted at path. Register it after
+// NewCustomers, whose summary it reads, and before the rule engine.
+func NewSegments(gb *GoBridge, customers *Customers, set *SegmentSet, path string) (*Segments, error) {
+	s := &Segments{
+		bridge:    gb,
+		customers: customers,
+		set:       set,
+		path:      path,
+		members:   make(map[string]map[string]time.Time),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read segment members: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &s.members)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse segment members %s: %v", path, err)
+		}
+	}
+	// Segments taken out of the file are forgotten
+	defined := make(map[string]bool)
+	for _, segment := range set.Segments {
+		defined[segment.Name] = true
+		if s.members[segment.Name] == nil {
+			s.members[segment.Name] = make(map[string]time.Time)
+		}
+	}
+	for name := range s.members {
+		if !defined[name] {
+			delete(s.members, name)
+		}
+	}
+
+	gb.OnReceive(s.handleMessage)
+	fmt.Printf("🎯 Loaded %d segments\n", len(set.Segments))
+	return s, nil
+}
+
+func (s *Segments) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) || strings.HasPrefix(stringArg(message.Payload, "resource_name"), "segment_") {
+		return
+	}
+	// Customers has already worked out whose it is, even for subscription
+	// events that carry only an ID
+	email, _ := lookupField(message.Payload, "customer.email")
+	address, _ := email.(string)
+	if address == "" {
+		return
+	}
+	segments := s.Evaluate(address)
+	list := make([]interface{}, len(segments))
+	for i, name := range segments {
+		list[i] = name
+	}
+	message.Payload = mergePayload(message.Payload, map[string]interface{}{"segments": list})
+}
+
+// segmentChange is a customer joining or leaving a segment
+type segmentChange struct {
+	email   string
+	segment string
+	joined  bool
+}
+
+// Evaluate updates one customer's membership and returns their segments
+func (s *Segments) Evaluate(email string) []string {
+	email = NormalizeEmail(email)
+	profile, known := s.customers.Profile(email)
+	var facts map[string]interface{}
+	if known {
+		facts = profile.facts(s.bridge.clock.Now())
+	}
+
+	s.mu.Lock()
+	changes, in := s.applyLocked(email, facts)
+	if len(changes) > 0 {
+		s.saveLocked()
+	}
+	s.mu.Unlock()
+
+	s.announce(changes)
+	return in
+}
+
+// applyLocked moves the customer in or out of each segment; nil facts take
+// them out of all of them
+func (s *Segments) applyLocked(email string, facts map[string]interface{}) ([]segmentChange, []string) {
+	now := s.bridge.clock.Now()
+	var changes []segmentChange
+	in := []string{}
+	for _, segment := range s.set.Segments {
+		members := s.members[segment.Name]
+		_, member := members[email]
+		matches := facts != nil && segment.Matches(facts)
+		switch {
+		case matches && !member:
+			members[email] = now
+			changes = append(changes, segmentChange{email: email, segment: segment.Name, joined: true})
+		case !matches && member:
+			delete(members, email)
+			changes = append(changes, segmentChange{email: email, segment: segment.Name})
+		}
+		if matches {
+			in = append(in, segment.Name)
+		}
+	}
+	return changes, in
+}
+
+// Refresh evaluates every customer, for segments whose conditions change
+// with time alone
+func (s *Segments) Refresh() error {
+	now := s.bridge.clock.Now()
+	emails := s.customers.Emails()
+	facts := make(map[string]map[string]interface{}, len(emails))
+	for _, email := range emails {
+		if profile, ok := s.customers.Profile(email); ok {
+			facts[email] = profile.facts(now)
+		}
+	}
+
+	s.mu.Lock()
+	var changes []segmentChange
+	for email, customer := range facts {
+		changed, _ := s.applyLocked(email, customer)
+		changes = append(changes, changed...)
+	}
+	// Members who are no longer customers at all
+	for _, members := range s.members {
+		for email := range members {
+			if facts[email] == nil {
+				changed, _ := s.applyLocked(email, nil)
+				changes = append(changes, changed...)
+			}
+		}
+	}
+	var err error
+	if len(changes) > 0 {
+		err = s.saveLocked()
+	}
+	s.mu.Unlock()
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].segment != changes[j].segment {
+			return changes[i].segment < changes[j].segment
+		}
+		return changes[i].email < changes[j].email
+	})
+	s.announce(changes)
+	if len(changes) > 0 {
+		fmt.Printf("🎯 Segments refreshed: %d changes\n", len(changes))
+	}
+	return err
+}
+
+// announce runs a segment_joined or segment_left message through the
+// bridge for each change
+func (s *Segments) announce(changes []segmentChange) {
+	for _, change := range changes {
+		resource := "segment_left"
+		if change.joined {
+			resource = "segment_joined"
+		}
+		message := s.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+			"resource_name": resource,
+			"email":         change.email,
+			"segment":       change.segment,
+		}, FileSystem)
+		err := s.bridge.handleIncomingMessage(message)
+		if err != nil {
+			log.Printf("❌ Error announcing %s %s %s: %v", change.email, resource, change.segment, err)
+		}
+	}
+}
+
+// Contains reports whether the email is in the segment
+func (s *Segments) Contains(segment, email string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.members[segment][NormalizeEmail(email)]
+	return ok
+}
+
+// Members returns a segment's customers by email, reporting false for a
+// segment not defined
+func (s *Segments) Members(segment string) ([]SegmentMember, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.members[segment]
+	if !ok {
+		return nil, false
+	}
+	list := make([]SegmentMember, 0, len(members))
+	for email, joined := range members {
+		list = append(list, SegmentMember{Email: email, JoinedAt: joined})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Email < list[j].Email })
+	return list, true
+}
+
+// Summaries returns every segment in file order with its size
+func (s *Segments) Summaries() []SegmentSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make([]SegmentSummary, 0, len(s.set.Segments))
+	for _, segment := range s.set.Segments {
+		summaries = append(summaries, SegmentSummary{
+			Name:        segment.Name,
+			Description: segment.Description,
+			Members:     len(s.members[segment.Name]),
+		})
+	}
+	return summaries
+}
+
+// Handler serves GET /segments with every segment's size and
+// GET /segments/{name} with its members
+func (s *Segments) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+
+		rest := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/segments"), "/")
+		if rest == "" {
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"segments": s.Summaries()})
+			return
+		}
+		name, err := url.PathUnescape(rest)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "expected /segments/{name}")
+			return
+		}
+		members, ok := s.Members(name)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "no segment with that name")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"segment": name, "members": members})
+	})
+}
+
+func (s *Segments) saveLocked() error {
+	err := writeJSONFile(s.path, s.members)
+	if err != nil {
+		log.Printf("❌ Error saving segment members: %v", err)
+	}
+	return err
+}
+
+// RegisterSegmentJobs adds the evaluate_segments job handler, which
+// re-evaluates every customer
+func RegisterSegmentJobs(s *Scheduler, segments *Segments) {
+	s.Handle("evaluate_segments", func(job Job) error {
+		return segments.Refresh()
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSegments(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	customers, err := NewCustomers(gb, sales, filepath.Join(t.TempDir(), "customers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadSegments("segments.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Segments = append(set.Segments, Segment{Name: "lapsed", When: []RuleCondition{{Field: "days_since_purchase", Op: "gt", Value: 30}}})
+	path := filepath.Join(t.TempDir(), "members.json")
+	segments, err := NewSegments(gb, customers, set, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rules RuleSet
+	err = yaml.Unmarshal([]byte("rules:\n  - on: data_sync\n    when:\n      - segment: whales\n    do:\n      - log: whale\n"), &rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var whaleMessages []string
+	var events []string
+	gb.OnReceive(func(message *UniversalMessage) {
+		if rules.Rules[0].Matches(message) {
+			whaleMessages = append(whaleMessages, stringArg(message.Payload, "sale_id"))
+		}
+		if resource := stringArg(message.Payload, "resource_name"); resource == "segment_joined" || resource == "segment_left" {
+			events = append(events, resource+" "+stringArg(message.Payload, "email")+" "+stringArg(message.Payload, "segment"))
+		}
+	})
+
+	ids := NewSequentialIDs("py")
+	deliver := func(payload map[string]interface{}) {
+		t.Helper()
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+	sale := func(id, email string, price float64, country string) {
+		deliver(map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": "course", "email": email, "price": price, "currency": "usd", "ip_country": country, "sale_timestamp": clock.Now().Format(time.RFC3339)})
+	}
+
+	sale("s1", "ada@example.com", 150, "Germany")
+	sale("s2", "bo@example.com", 20, "United States")
+	sale("s3", "ada@example.com", 99, "Germany")
+	deliver(map[string]interface{}{"resource_name": "sale", "sale_id": "s4", "product_id": "club", "email": "cy@example.com", "price": 10, "subscription_id": "sub-1"})
+	deliver(map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub-1", "product_id": "club"})
+
+	if want := []string{"s3"}; !reflect.DeepEqual(whaleMessages, want) {
+		t.Errorf("whale rule matched %v, want %v", whaleMessages, want)
+	}
+	want := []string{
+		"segment_joined ada@example.com first_time_buyers",
+		"segment_joined ada@example.com eu_customers",
+		"segment_joined bo@example.com first_time_buyers",
+		"segment_left ada@example.com first_time_buyers",
+		"segment_joined ada@example.com whales",
+		"segment_joined cy@example.com first_time_buyers",
+		"segment_joined cy@example.com churned_subscribers",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events\n%v\nwant\n%v", events, want)
+	}
+
+	// Time alone moves customers once the job runs; cy's sale has no
+	// timestamp, so never lapses
+	events = nil
+	clock.Advance(31 * 24 * time.Hour)
+	if err := segments.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"segment_joined ada@example.com lapsed", "segment_joined bo@example.com lapsed"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("refresh events %v", events)
+	}
+	if !segments.Contains("lapsed", "Bo@Example.com") || segments.Contains("whales", "bo@example.com") {
+		t.Error("membership after refresh")
+	}
+
+	response := httptest.NewRecorder()
+	segments.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/segments/whales", nil))
+	var body struct {
+		Members []SegmentMember `json:"members"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("GET /segments/whales: %d %s", response.Code, response.Body)
+	}
+	if len(body.Members) != 1 || body.Members[0].Email != "ada@example.com" {
+		t.Errorf("whales %+v", body.Members)
+	}
+	response = httptest.NewRecorder()
+	segments.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/segments/vips", nil))
+	if response.Code != http.StatusNotFound {
+		t.Errorf("unknown segment: %d", response.Code)
+	}
+
+	// Membership survives a restart
+	reopened, err := NewSegments(gb, customers, set, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summaries := reopened.Summaries(); summaries[1].Name != "whales" || summaries[1].Members != 1 || summaries[4].Members != 2 {
+		t.Errorf("reopened %+v", summaries)
+	}
+}
+
+func TestInvalidSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		set  SegmentSet
+	}{
+		{"no name", SegmentSet{Segments: []Segment{{When: []RuleCondition{{Field: "orders", Op: "gt", Value: 0}}}}}},
+		{"twice", SegmentSet{Segments: []Segment{
+			{Name: "buyers", When: []RuleCondition{{Field: "orders", Op: "gt", Value: 0}}},
+			{Name: "buyers", When: []RuleCondition{{Field: "orders", Op: "gt", Value: 1}}},
+		}}},
+		{"no conditions", SegmentSet{Segments: []Segment{{Name: "everyone"}}}},
+	}
+	for _, test := range tests {
+		if err := test.set.Validate(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ResendRequest asks a peer to send the messages listed in its payload's
+// "missing" sequence numbers again
+const ResendRequest MessageType = "resend_request"
+
+// maxGapSize bounds how many sequence numbers one jump can mark missing, so
+// a corrupt or reset counter cannot fill memory
+const maxGapSize = 10000
+
+// Sequencer numbers sent messages per target language and watches the
+// numbers received from each source language. A number skipped on receipt,
+// as a file lost from a shared directory would be, is asked for again with a
+// ResendRequest once it has been missing longer than GapGrace; the sender
+// answers from the messages it keeps. Messages without a sequence, such as
+// everything from bridges that do not number theirs, are not tracked.
+type Sequencer struct {
+	// GapGrace is how long a number may be missing before it is requested;
+	// decoding in parallel reorders messages, so brief gaps are normal
+	GapGrace time.Duration
+	// History is how many sent messages are kept per target for resends
+	History int
+	// MaxRequests is how often a missing number is requested before it is
+	// reported lost
+	MaxRequests int
+
+	bridge *GoBridge
+
+	mu        sync.Mutex
+	last      map[string]uint64              // target → last number assigned
+	sent      map[string][]*UniversalMessage // target → recent sends by number
+	peers     map[string]*peerSequence       // source → what has arrived
+	stop      chan struct{}
+	stopOnce  sync.Once
+	lostTotal uint64
+}
+
+// peerSequence is what has arrived from one source
+type peerSequence struct {
+	highest uint64
+	channel CommunicationChannel // where the peer asked for replies
+	missing map[uint64]*missingSequence
+}
+
+type missingSequence struct {
+	noticed   time.Time
+	requested time.Time
+	requests  int
+}
+
+// GapStats is the receive state for one source
+type GapStats struct {
+	Source  string   `json:"source"`
+	Highest uint64   `json:"highest"`
+	Missing []uint64 `json:"missing"`
+}
+
+func newSequencer(gb *GoBridge) *Sequencer {
+	return &Sequencer{
+		GapGrace:    5 * time.Second,
+		History:     1000,
+		MaxRequests: 3,
+		bridge:      gb,
+		last:        make(map[string]uint64),
+		sent:        make(map[string][]*UniversalMessage),
+		peers:       make(map[string]*peerSequence),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Sequences returns the bridge's sequencer
+func (gb *GoBridge) Sequences() *Sequencer {
+	return gb.sequences
+}
+
+// assign numbers a message about to be sent and keeps it for resends. A
+// message that already has a number, being resent or retried, keeps it.
+func (s *Sequencer) assign(message *UniversalMessage) {
+	if message.Sequence != 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := message.TargetLanguage
+	s.last[target]++
+	message.Sequence = s.last[target]
+
+	history := append(s.sent[target], message)
+	if s.History > 0 && len(history) > s.History {
+		history = history[len(history)-s.History:]
+	}
+	s.sent[target] = history
+}
+
+// observe records a received message's number
+func (s *Sequencer) observe(message *UniversalMessage) {
+	if message.Sequence == 0 || message.SourceLanguage == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer := s.peers[message.SourceLanguage]
+	if peer == nil {
+		// Numbering starts wherever we join; earlier messages are not ours to ask for
+		peer = &peerSequence{highest: message.Sequence - 1, missing: make(map[uint64]*missingSequence)}
+		s.peers[message.SourceLanguage] = peer
+	}
+	peer.channel = message.ResponseChannel
+
+	seq := message.Sequence
+	switch {
+	case peer.missing[seq] != nil:
+		delete(peer.missing, seq)
+	case seq > peer.highest:
+		now := s.bridge.clock.Now()
+		from := peer.highest + 1
+		if seq-from > maxGapSize {
+			from = seq - maxGapSize
+		}
+		for missing := from; missing < seq; missing++ {
+			peer.missing[missing] = &missingSequence{noticed: now}
+		}
+		peer.highest = seq
+	case seq == 1:
+		// The peer restarted and numbers from the beginning again
+		peer.highest = 1
+		peer.missing = make(map[uint64]*missingSequence)
+	}
+}
+
+// CheckGaps requests every number missing longer than GapGrace, re-requesting
+// after another GapGrace, and reports numbers still missing after
+// MaxRequests requests as lost
+func (s *Sequencer) CheckGaps() {
+	now := s.bridge.clock.Now()
+	requests := make(map[string][]uint64)
+	channels := make(map[string]CommunicationChannel)
+
+	s.mu.Lock()
+	for source, peer := range s.peers {
+		var lost []uint64
+		for seq, missing := range peer.missing {
+			since := missing.noticed
+			if missing.requests > 0 {
+				since = missing.requested
+			}
+			if now.Sub(since) < s.GapGrace {
+				continue
+			}
+			if missing.requests >= s.MaxRequests {
+				lost = append(lost, seq)
+				delete(peer.missing, seq)
+				continue
+			}
+			missing.requests++
+			missing.requested = now
+			requests[source] = append(requests[source], seq)
+			channels[source] = peer.channel
+		}
+		if len(lost) > 0 {
+			sortSequences(lost)
+			s.lostTotal += uint64(len(lost))
+			log.Printf("❌ Lost %d messages from %s: sequences %v", len(lost), source, lost)
+		}
+	}
+	s.mu.Unlock()
+
+	for source, missing := range requests {
+		sortSequences(missing)
+		list := make([]interface{}, len(missing))
+		for i, seq := range missing {
+			list[i] = seq
+		}
+		request := s.bridge.NewMessage(ResendRequest, source, map[string]interface{}{"missing": list}, channels[source])
+		_, err := s.bridge.SendMessage(request)
+		if err != nil {
+			log.Printf("❌ Error requesting resend from %s: %v", source, err)
+			continue
+		}
+		fmt.Printf("🔁 Asked %s to resend %d messages\n", source, len(missing))
+	}
+}
+
+// handleResendRequest sends the requested messages to the peer again
+func (s *Sequencer) handleResendRequest(message *UniversalMessage) error {
+	list, _ := message.Payload["missing"].([]interface{})
+	wanted := make(map[uint64]bool, len(list))
+	for _, value := range list {
+		if number, ok := toNumber(value); ok && number > 0 {
+			wanted[uint64(number)] = true
+		}
+	}
+
+	s.mu.Lock()
+	var resend []*UniversalMessage
+	for _, sent := range s.sent[message.SourceLanguage] {
+		if wanted[sent.Sequence] {
+			resend = append(resend, sent)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(resend) < len(wanted) {
+		log.Printf("❌ %s asked for %d messages no longer kept", message.SourceLanguage, len(wanted)-len(resend))
+	}
+	for _, sent := range resend {
+		_, err := s.bridge.SendMessage(sent)
+		if err != nil {
+			return fmt.Errorf("resending %s: %v", sent.ID, err)
+		}
+	}
+	return nil
+}
+
+// Gaps returns the receive state of every source, by name
+func (s *Sequencer) Gaps() []GapStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]GapStats, 0, len(s.peers))
+	for source, peer := range s.peers {
+		missing := make([]uint64, 0, len(peer.missing))
+		for seq := range peer.missing {
+			missing = append(missing, seq)
+		}
+		sortSequences(missing)
+		stats = append(stats, GapStats{Source: source, Highest: peer.highest, Missing: missing})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Source < stats[j].Source })
+	return stats
+}
+
+// Lost returns how many messages were given up on
+func (s *Sequencer) Lost() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lostTotal
+}
+
+// Start checks for gaps every interval until Close, so a gap at the end of
+// a burst is requested without waiting for the next message
+func (s *Sequencer) Start(interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-s.bridge.clock.After(interval):
+				s.CheckGaps()
+			}
+		}
+	}()
+}
+
+// Close stops the gap checker
+func (s *Sequencer) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func sortSequences(list []uint64) {
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+}
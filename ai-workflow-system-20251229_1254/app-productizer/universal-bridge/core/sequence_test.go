@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSequenceBridge returns a bridge over a MemoryTransport and a function
+// that delivers a numbered message from the python bridge to it
+func newSequenceBridge(t *testing.T) (*GoBridge, *ManualClock, *MemoryTransport, func(seq uint64)) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	memory := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(memory), WithIDGenerator(NewSequentialIDs("seq")))
+	t.Cleanup(func() { gb.Close() })
+	ids := NewSequentialIDs("python")
+	receive := func(seq uint64) {
+		t.Helper()
+		message := newUniversalMessage(clock, ids, DataSync, "python", "go", nil, FileSystem)
+		message.Sequence = seq
+		if err := gb.handleIncomingMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gb, clock, memory, receive
+}
+
+func TestSequencerGaps(t *testing.T) {
+	tests := []struct {
+		name        string
+		arrivals    []uint64
+		wantHighest uint64
+		wantMissing []uint64
+	}{
+		{"in order", []uint64{1, 2, 3}, 3, []uint64{}},
+		{"one skipped", []uint64{1, 3}, 3, []uint64{2}},
+		{"late arrival fills the gap", []uint64{1, 4, 2}, 4, []uint64{3}},
+		{"joined part way", []uint64{7, 8}, 8, []uint64{}},
+		{"redelivered", []uint64{1, 2, 2}, 2, []uint64{}},
+		{"peer restarted", []uint64{1, 5, 1}, 1, []uint64{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gb, _, _, receive := newSequenceBridge(t)
+			for _, seq := range tt.arrivals {
+				receive(seq)
+			}
+			gaps := gb.Sequences().Gaps()
+			if len(gaps) != 1 || gaps[0].Source != "python" {
+				t.Fatalf("gaps %+v, want python's", gaps)
+			}
+			if gaps[0].Highest != tt.wantHighest || !reflect.DeepEqual(gaps[0].Missing, tt.wantMissing) {
+				t.Fatalf("highest %d missing %v, want %d and %v", gaps[0].Highest, gaps[0].Missing, tt.wantHighest, tt.wantMissing)
+			}
+		})
+	}
+
+	t.Run("jump is bounded", func(t *testing.T) {
+		gb, _, _, receive := newSequenceBridge(t)
+		receive(1)
+		receive(1 + 3*maxGapSize)
+		if missing := gb.Sequences().Gaps()[0].Missing; len(missing) != maxGapSize {
+			t.Fatalf("%d numbers missing, want %d", len(missing), maxGapSize)
+		}
+	})
+}
+
+func TestSequencerResendRequests(t *testing.T) {
+	gb, clock, memory, receive := newSequenceBridge(t)
+	sequences := gb.Sequences()
+	receive(1)
+	receive(3)
+
+	if sequences.CheckGaps(); len(memory.SentOfType(ResendRequest)) != 0 {
+		t.Fatal("a gap was requested before GapGrace passed")
+	}
+	for i := 1; i <= sequences.MaxRequests; i++ {
+		clock.Advance(sequences.GapGrace)
+		sequences.CheckGaps()
+		requests := memory.SentOfType(ResendRequest)
+		if len(requests) != i {
+			t.Fatalf("%d resend requests after %d grace periods", len(requests), i)
+		}
+		missing, _ := requests[i-1].Payload["missing"].([]interface{})
+		if requests[i-1].TargetLanguage != "python" || len(missing) != 1 {
+			t.Fatalf("resend request %+v, want python asked for 2", requests[i-1])
+		}
+	}
+
+	clock.Advance(sequences.GapGrace)
+	sequences.CheckGaps()
+	if len(memory.SentOfType(ResendRequest)) != sequences.MaxRequests || sequences.Lost() != 1 {
+		t.Fatalf("after MaxRequests: %d requests, %d lost", len(memory.SentOfType(ResendRequest)), sequences.Lost())
+	}
+	if missing := sequences.Gaps()[0].Missing; len(missing) != 0 {
+		t.Fatalf("lost number still missing: %v", missing)
+	}
+}
+
+func TestSequencerAnswersResendRequests(t *testing.T) {
+	gb, clock, memory, _ := newSequenceBridge(t)
+	for i := 0; i < 3; i++ {
+		if _, err := gb.SendMessage(gb.NewMessage(DataSync, "python", nil, FileSystem)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := memory.Sent()
+	for i, message := range sent {
+		if message.Sequence != uint64(i+1) {
+			t.Fatalf("message %d numbered %d", i, message.Sequence)
+		}
+	}
+
+	request := newUniversalMessage(clock, NewSequentialIDs("python"), ResendRequest, "python", "go", map[string]interface{}{
+		"missing": []interface{}{float64(2), float64(9)},
+	}, FileSystem)
+	if err := gb.handleIncomingMessage(request); err != nil {
+		t.Fatal(err)
+	}
+	resent := memory.Sent()[len(sent):]
+	if len(resent) != 1 || resent[0].ID != sent[1].ID || resent[0].Sequence != 2 {
+		t.Fatalf("resent %+v, want only message 2 under its own number", resent)
+	}
+}
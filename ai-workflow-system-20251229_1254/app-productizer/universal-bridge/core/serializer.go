@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// JSONContentType is the bridge's native encoding, shared with the Python
+// and JavaScript bridges
+const JSONContentType = "application/json"
+
+// Serializer encodes messages for a transport. Implementations must be safe
+// for concurrent use; Unmarshal need not verify the checksum, the bridge
+// does that for every decoded message.
+type Serializer interface {
+	Marshal(message *UniversalMessage) ([]byte, error)
+	Unmarshal(data []byte) (*UniversalMessage, error)
+	ContentType() string
+}
+
+// JSONSerializer is the default indented JSON encoding
+type JSONSerializer struct{}
+
+// Marshal returns the same bytes as ToJSON
+func (JSONSerializer) Marshal(message *UniversalMessage) ([]byte, error) {
+	jsonStr, err := message.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(jsonStr), nil
+}
+
+// Unmarshal decodes and checksum-verifies one JSON message
+func (JSONSerializer) Unmarshal(data []byte) (*UniversalMessage, error) {
+	return FromJSON(string(data))
+}
+
+// ContentType returns application/json
+func (JSONSerializer) ContentType() string {
+	return JSONContentType
+}
+
+type registeredSerializer struct {
+	serializer Serializer
+	extension  string
+}
+
+var (
+	serializersMu sync.RWMutex
+	serializers   = map[string]registeredSerializer{
+		JSONContentType:     {serializer: JSONSerializer{}, extension: ".json"},
+		ProtobufContentType: {serializer: ProtobufSerializer{}, extension: ".pb"},
+		MsgpackContentType:  {serializer: MsgpackSerializer{}, extension: ".msgpack"},
+	}
+
+	// encodingNames are the short names config accepts for the built-in
+	// encodings; any registered content type is accepted too
+	encodingNames = map[string]string{
+		"json":     JSONContentType,
+		"protobuf": ProtobufContentType,
+		"msgpack":  MsgpackContentType,
+	}
+)
+
+// RegisterSerializer makes an encoding available to transports by its
+// content type. extension names the files the file transport writes and
+// picks up in that encoding, e.g. ".avro"; it must not be one already taken.
+func RegisterSerializer(serializer Serializer, extension string) error {
+	contentType := serializer.ContentType()
+	if contentType == "" {
+		return fmt.Errorf("serializer has no content type")
+	}
+	if !strings.HasPrefix(extension, ".") || len(extension) < 2 {
+		return fmt.Errorf("serializer %s: extension %q must start with a dot", contentType, extension)
+	}
+
+	serializersMu.Lock()
+	defer serializersMu.Unlock()
+
+	if _, exists := serializers[contentType]; exists {
+		return fmt.Errorf("serializer %s is already registered", contentType)
+	}
+	for other, registered := range serializers {
+		if registered.extension == extension {
+			return fmt.Errorf("extension %s is already used by %s", extension, other)
+		}
+	}
+	serializers[contentType] = registeredSerializer{serializer: serializer, extension: extension}
+	return nil
+}
+
+// SerializerFor returns the serializer registered for a content type; an
+// empty content type is JSON
+func SerializerFor(contentType string) (Serializer, error) {
+	if contentType == "" {
+		contentType = JSONContentType
+	}
+
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+
+	registered, ok := serializers[contentType]
+	if !ok {
+		return nil, fmt.Errorf("no serializer registered for %s", contentType)
+	}
+	return registered.serializer, nil
+}
+
+// EncodingSerializer returns the serializer a channel's encoding setting
+// names: json, protobuf, msgpack or a registered content type. Empty is
+// JSON.
+func EncodingSerializer(encoding string) (Serializer, error) {
+	if contentType, ok := encodingNames[encoding]; ok {
+		encoding = contentType
+	}
+	serializer, err := SerializerFor(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q; use json, protobuf, msgpack or a registered content type", encoding)
+	}
+	return serializer, nil
+}
+
+// ContentTypes lists the registered content types
+func ContentTypes() []string {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+
+	types := make([]string, 0, len(serializers))
+	for contentType := range serializers {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// serializerExtension returns the file extension a content type was
+// registered with
+func serializerExtension(contentType string) (string, bool) {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+
+	registered, ok := serializers[contentType]
+	return registered.extension, ok
+}
+
+// contentTypeForFile returns the content type whose extension the file name
+// has, the longest one winning so ".enc.json" is not read as JSON
+func contentTypeForFile(name string) (string, bool) {
+	serializersMu.RLock()
+	defer serializersMu.RUnlock()
+
+	match, longest := "", 0
+	for contentType, registered := range serializers {
+		if strings.HasSuffix(name, registered.extension) && len(registered.extension) > longest {
+			match, longest = contentType, len(registered.extension)
+		}
+	}
+	return match, longest > 0
+}
+
+// decodeEnvelope decodes at most limit bytes from r with the serializer for
+// contentType. JSON is streamed through DecodeMessage; other encodings are
+// read whole and handed to Unmarshal.
+func decodeEnvelope(r io.Reader, contentType string, limit int64) (*UniversalMessage, error) {
+	serializer, err := SerializerFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if _, native := serializer.(JSONSerializer); native {
+		return DecodeMessage(r, limit)
+	}
+
+	if limit > 0 {
+		r = &limitedReader{r: r, remaining: limit}
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, errReadLimit) {
+			return nil, &PayloadTooLargeError{Size: -1, Limit: limit}
+		}
+		return nil, err
+	}
+	message, err := serializer.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", serializer.ContentType(), err)
+	}
+	err = decompressPayload(message, limit)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyChecksum(message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// verifyChecksum rejects a decoded message whose checksum does not match
+func verifyChecksum(message *UniversalMessage) error {
+	if message.Checksum != message.calculateChecksum() {
+		return fmt.Errorf("message checksum mismatch - data may be corrupted")
+	}
+	return nil
+}
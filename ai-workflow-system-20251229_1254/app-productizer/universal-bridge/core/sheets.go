@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sheetsAPIURL         = "https://sheets.googleapis.com/v4"
+	sheetsScope          = "https://www.googleapis.com/auth/spreadsheets"
+	googleTokenURL       = "https://oauth2.googleapis.com/token"
+	googleCredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	sheetsHTTPTimeout    = 30 * time.Second
+)
+
+// ServiceAccount is a Google service account key, as downloaded from the
+// Cloud console, exchanged for access tokens with a signed JWT
+type ServiceAccount struct {
+	ClientEmail string
+	TokenURL    string
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	tokens map[string]accessToken // by scope
+}
+
+type accessToken struct {
+	token   string
+	expires time.Time
+}
+
+// LoadServiceAccount reads a service account JSON key file
+func LoadServiceAccount(path string) (*ServiceAccount, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key: %v", err)
+	}
+	var file struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account key %s: %v", path, err)
+	}
+	if file.Type != "service_account" || file.ClientEmail == "" {
+		return nil, fmt.Errorf("%s is not a service account key", path)
+	}
+
+	block, _ := pem.Decode([]byte(file.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account key %s has no PEM private key", path)
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = parsed.(*rsa.PrivateKey)
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return nil, fmt.Errorf("failed to parse service account private key: %v", err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("service account private key is not RSA")
+	}
+
+	account := &ServiceAccount{ClientEmail: file.ClientEmail, TokenURL: file.TokenURI, key: key, tokens: make(map[string]accessToken)}
+	if account.TokenURL == "" {
+		account.TokenURL = googleTokenURL
+	}
+	return account, nil
+}
+
+// Token returns an access token for scope, reusing the last one for that
+// scope until a minute before it expires
+func (sa *ServiceAccount) Token(client *http.Client, scope string, now time.Time) (string, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	if cached, ok := sa.tokens[scope]; ok && now.Before(cached.expires.Add(-time.Minute)) {
+		return cached.token, nil
+	}
+
+	assertion, err := sa.assertion(scope, now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	response, err := client.PostForm(sa.TokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("service account token request failed: %v", err)
+	}
+	defer response.Body.Close()
+
+	var grant struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&grant)
+	if err != nil {
+		return "", fmt.Errorf("token endpoint returned %s: %v", response.Status, err)
+	}
+	if grant.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s %s", response.Status, grant.Error, grant.Description)
+	}
+
+	sa.tokens[scope] = accessToken{token: grant.AccessToken, expires: now.Add(time.Duration(grant.ExpiresIn) * time.Second)}
+	return grant.AccessToken, nil
+}
+
+// invalidate drops the scope's cached token after the API refused it
+func (sa *ServiceAccount) invalidate(scope string) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	delete(sa.tokens, scope)
+}
+
+// assertion is the RS256-signed JWT that requests a token for scope
+func (sa *ServiceAccount) assertion(scope string, now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": scope,
+		"aud":   sa.TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign service account assertion: %v", err)
+	}
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+// SheetsLogger appends a row per Gumroad sale to a Google spreadsheet. Rows
+// are buffered and appended in batches, once BatchSize rows are waiting or
+// every FlushInterval, so a burst of sales costs one API call rather than
+// one each. Appends that fail with a 429, a 5xx or a network error are
+// retried with exponential backoff; rows still not appended stay buffered
+// for the next flush. Register it after RecordSales so it logs validated
+// sales.
+type SheetsLogger struct {
+	SpreadsheetID string
+	// Range is the A1 range whose table rows are appended after
+	Range string
+	// BatchSize is how many buffered rows trigger an append
+	BatchSize int
+	// FlushInterval is the longest a row waits to be appended
+	FlushInterval time.Duration
+	// MaxBuffered caps rows kept while appends fail; the oldest are dropped
+	MaxBuffered int
+	// Retries is how many times a failed append is retried
+	Retries int
+	// Backoff is the delay before the first retry; each retry doubles it up
+	// to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BaseURL defaults to the public API
+	BaseURL    string
+	HTTPClient *http.Client
+
+	account *ServiceAccount
+	clock   Clock
+
+	mu     sync.Mutex
+	rows   [][]interface{}
+	logged map[string]bool // sale IDs already buffered, so redelivered sales log once
+
+	flushing sync.Mutex // keeps concurrent flushes from reordering rows
+	running  bool
+	kick     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSheetsLogger logs every sale the bridge receives to the spreadsheet,
+// authenticating as the service account, which must have edit access to it
+func NewSheetsLogger(gb *GoBridge, account *ServiceAccount, spreadsheetID string) *SheetsLogger {
+	sl := &SheetsLogger{
+		SpreadsheetID: spreadsheetID,
+		Range:         "Sales!A:F",
+		BatchSize:     50,
+		FlushInterval: 10 * time.Second,
+		MaxBuffered:   10000,
+		Retries:       5,
+		Backoff:       time.Second,
+		MaxBackoff:    time.Minute,
+		BaseURL:       sheetsAPIURL,
+		HTTPClient:    &http.Client{Timeout: sheetsHTTPTimeout},
+		account:       account,
+		clock:         gb.clock,
+		logged:        make(map[string]bool),
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	gb.OnReceive(sl.handleMessage)
+	return sl
+}
+
+func (sl *SheetsLogger) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) || stringArg(message.Payload, "resource_name") != "sale" {
+		return
+	}
+	sale, err := ParseSale(message.Payload)
+	if err != nil {
+		fmt.Printf("⚠️ Not logging sale from %s to Sheets: %v\n", message.ID, err)
+		return
+	}
+	sl.Add(sale)
+}
+
+// Add buffers a sale's row, once per sale ID
+func (sl *SheetsLogger) Add(sale Sale) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.logged[sale.ID] {
+		return
+	}
+	sl.logged[sale.ID] = true
+	sl.rows = append(sl.rows, sl.row(sale))
+	sl.trim()
+
+	if len(sl.rows) >= sl.BatchSize {
+		select {
+		case sl.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// row formats a sale as its columns: email, product, price, currency,
+// timestamp and license key. The price is sent as a number
+// so the sheet can sum it.
+func (sl *SheetsLogger) row(sale Sale) []interface{} {
+	product := sale.ProductName
+	if product == "" {
+		product = sale.Product
+	}
+	timestamp := sale.CreatedAt
+	if timestamp.IsZero() {
+		timestamp = sl.clock.Now()
+	}
+	return []interface{}{
+		sale.Email,
+		product,
+		json.Number(sale.Price.Decimal()),
+		strings.ToUpper(sale.Price.Currency),
+		timestamp.UTC().Format(time.RFC3339),
+		stringArg(sale.Fields, "license_key"),
+	}
+}
+
+// trim drops the oldest rows beyond MaxBuffered; the caller holds mu
+func (sl *SheetsLogger) trim() {
+	if sl.MaxBuffered <= 0 || len(sl.rows) <= sl.MaxBuffered {
+		return
+	}
+	dropped := len(sl.rows) - sl.MaxBuffered
+	sl.rows = sl.rows[dropped:]
+	log.Printf("❌ Sheets buffer full, dropped %d sale rows", dropped)
+}
+
+// Buffered returns how many rows are waiting to be appended
+func (sl *SheetsLogger) Buffered() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return len(sl.rows)
+}
+
+// Flush appends every buffered row in one call. On failure the rows go back
+// to the front of the buffer and the error is returned.
+func (sl *SheetsLogger) Flush() error {
+	sl.flushing.Lock()
+	defer sl.flushing.Unlock()
+
+	sl.mu.Lock()
+	rows := sl.rows
+	sl.rows = nil
+	sl.mu.Unlock()
+	if len(rows) == 0 {
+		return nil
+	}
+
+	err := sl.appendRows(rows)
+	if err != nil {
+		sl.mu.Lock()
+		sl.rows = append(rows, sl.rows...)
+		sl.trim()
+		sl.mu.Unlock()
+		return err
+	}
+	fmt.Printf("📊 Appended %d sales to spreadsheet %s\n", len(rows), sl.SpreadsheetID)
+	return nil
+}
+
+// appendRows posts the rows, retrying retryable failures
+func (sl *SheetsLogger) appendRows(rows [][]interface{}) error {
+	if sl.SpreadsheetID == "" {
+		return fmt.Errorf("no spreadsheet configured")
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": rows})
+	if err != nil {
+		return fmt.Errorf("failed to encode rows: %v", err)
+	}
+
+	retry := retrier{Retries: sl.Retries, Backoff: sl.Backoff, MaxBackoff: sl.MaxBackoff, Clock: sl.clock, Classify: retryGoogle}
+	err = retry.do("Sheets append", func() error {
+		return sl.post(body)
+	})
+	if err != nil {
+		return fmt.Errorf("appending %d rows to %s: %v", len(rows), sl.SpreadsheetID, err)
+	}
+	return nil
+}
+
+// post makes one append call
+func (sl *SheetsLogger) post(body []byte) error {
+	token, err := sl.account.Token(sl.HTTPClient, sheetsScope, sl.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	// RAW keeps a value such as an email starting with "=" from being read as
+	// a formula
+	endpoint := fmt.Sprintf("%s/spreadsheets/%s/values/%s:append?valueInputOption=RAW&insertDataOption=INSERT_ROWS",
+		strings.TrimSuffix(sl.BaseURL, "/"), url.PathEscape(sl.SpreadsheetID), url.PathEscape(sl.Range))
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return final(err)
+	}
+	request.Header.Set("Content-Type", JSONContentType)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := sl.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 300 {
+		return nil
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		// The token was revoked or expired early; the retry fetches a new one
+		sl.account.invalidate(sheetsScope)
+	}
+	return readAPIError("Sheets API", response)
+}
+
+// Start flushes every FlushInterval, and whenever BatchSize rows are
+// waiting, until Close
+func (sl *SheetsLogger) Start() {
+	sl.mu.Lock()
+	sl.running = true
+	sl.mu.Unlock()
+
+	go func() {
+		defer close(sl.done)
+		for {
+			select {
+			case <-sl.stop:
+				return
+			case <-sl.kick:
+			case <-sl.clock.After(sl.FlushInterval):
+			}
+			err := sl.Flush()
+			if err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}()
+}
+
+// Close stops the flusher and appends whatever is still buffered
+func (sl *SheetsLogger) Close() error {
+	sl.stopOnce.Do(func() { close(sl.stop) })
+
+	sl.mu.Lock()
+	running := sl.running
+	sl.mu.Unlock()
+	if running {
+		<-sl.done
+	}
+	return sl.Flush()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSheets is a Google token endpoint and the Sheets append call. It
+// checks the service account's signed assertions and the bearer tokens it
+// handed out.
+type fakeSheets struct {
+	server *httptest.Server
+	key    *rsa.PublicKey
+
+	mu       sync.Mutex
+	tokens   int   // tokens issued
+	statuses []int // answers to the next appends; 200 once used up
+	appends  []fakeAppend
+}
+
+type fakeAppend struct {
+	path  string
+	query string
+	rows  [][]interface{}
+}
+
+func newFakeSheets(t *testing.T) (*fakeSheets, *ServiceAccount) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &fakeSheets{key: &key.PublicKey}
+	fs.server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(fs.server.Close)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "bridge@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		"token_uri":    fs.server.URL + "/token",
+	})
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	if err := ioutil.WriteFile(path, keyFile, 0600); err != nil {
+		t.Fatal(err)
+	}
+	account, err := LoadServiceAccount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, account
+}
+
+func (fs *fakeSheets) serve(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r.URL.Path == "/token" {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if rsa.VerifyPKCS1v15(fs.key, crypto.SHA256, digest[:], signature) != nil || !strings.Contains(string(claims), `"scope":"`+sheetsScope+`"`) {
+			writeAPIError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		fs.tokens++
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"access_token": fmt.Sprintf("token-%d", fs.tokens), "expires_in": 3600})
+		return
+	}
+
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", fs.tokens) {
+		writeAPIError(w, http.StatusUnauthorized, "stale token")
+		return
+	}
+	status := http.StatusOK
+	if len(fs.statuses) > 0 {
+		status, fs.statuses = fs.statuses[0], fs.statuses[1:]
+	}
+	if status != http.StatusOK {
+		writeAPIError(w, status, http.StatusText(status))
+		return
+	}
+	var body struct {
+		Values [][]interface{} `json:"values"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	fs.appends = append(fs.appends, fakeAppend{path: r.URL.Path, query: r.URL.RawQuery, rows: body.Values})
+	w.Write([]byte(`{}`))
+}
+
+func TestSheetsLogger(t *testing.T) {
+	fs, account := newFakeSheets(t)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sheets := NewSheetsLogger(gb, account, "sheet1")
+	sheets.BaseURL = fs.server.URL
+	waits := &waitRecorder{ManualClock: clock}
+	sheets.clock = waits
+
+	ids := NewSequentialIDs("gumroad")
+	receive := func(payload map[string]interface{}) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := map[string]interface{}{"resource_name": "sale", "sale_id": "s1", "product_id": "p1", "product_name": "Ebook", "email": "Ada@example.com",
+		"price": "29.99", "currency": "usd", "sale_timestamp": "2026-01-15T09:00:00Z", "license_key": "KEY-1"}
+	receive(first)
+	receive(first) // redelivered
+	receive(map[string]interface{}{"resource_name": "refund", "sale_id": "s1", "price": "29.99"})
+	receive(map[string]interface{}{"resource_name": "sale", "sale_id": "s2", "product_permalink": "kit", "email": "=bob@example.com", "price": "1500", "currency": "jpy"})
+	if n := sheets.Buffered(); n != 2 {
+		t.Fatalf("buffered %d rows, want one per sale", n)
+	}
+
+	// A 503 and a revoked token are retried, with a new token for the latter
+	fs.statuses = []int{http.StatusServiceUnavailable}
+	if err := sheets.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.appends) != 1 || fs.tokens != 1 || len(waits.waits) != 1 {
+		t.Fatalf("appends %+v with %d tokens after waits %v, want one append after one retry", fs.appends, fs.tokens, waits.waits)
+	}
+	got := fs.appends[0]
+	if got.path != "/spreadsheets/sheet1/values/Sales!A:F:append" || !strings.Contains(got.query, "valueInputOption=RAW") {
+		t.Errorf("appended to %s?%s", got.path, got.query)
+	}
+	want := [][]interface{}{
+		{"ada@example.com", "Ebook", 29.99, "USD", "2026-01-15T09:00:00Z", "KEY-1"},
+		{"=bob@example.com", "kit", float64(1500), "JPY", "2026-01-15T09:30:00Z", ""},
+	}
+	if fmt.Sprint(got.rows) != fmt.Sprint(want) {
+		t.Errorf("rows %v, want %v", got.rows, want)
+	}
+
+	sheets.Add(Sale{ID: "s3", Product: "p3", Price: NewMoney(500, "usd")})
+	fs.tokens++ // the endpoint forgets the token it gave, as if it were revoked
+	if err := sheets.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.appends) != 2 || fs.tokens != 3 {
+		t.Errorf("%d appends with %d tokens, want a second append after fetching a new token", len(fs.appends), fs.tokens)
+	}
+
+	// A rejected append keeps its rows, in order, for the next flush
+	sheets.Add(Sale{ID: "s4", Product: "p4", Price: NewMoney(100, "usd")})
+	sheets.Add(Sale{ID: "s5", Product: "p5", Price: NewMoney(200, "usd")})
+	fs.statuses = []int{http.StatusBadRequest}
+	if err := sheets.Flush(); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("Flush = %v, want the 400", err)
+	}
+	if n := sheets.Buffered(); n != 2 {
+		t.Fatalf("buffered %d rows after a failed append, want 2", n)
+	}
+	if err := sheets.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if last := fs.appends[len(fs.appends)-1].rows; len(last) != 2 || last[0][1] != "p4" || last[1][1] != "p5" || sheets.Buffered() != 0 {
+		t.Errorf("last append %v, want p4 then p5", last)
+	}
+}
+
+func TestSheetsLoggerDropsOldestRows(t *testing.T) {
+	fs, account := newFakeSheets(t)
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	sheets := NewSheetsLogger(gb, account, "sheet1")
+	sheets.BaseURL = fs.server.URL
+	sheets.MaxBuffered = 2
+	for _, id := range []string{"s1", "s2", "s3"} {
+		sheets.Add(Sale{ID: id, Product: id, Price: NewMoney(100, "usd")})
+	}
+	if err := sheets.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if rows := fs.appends[0].rows; len(rows) != 2 || rows[0][1] != "s2" || rows[1][1] != "s3" {
+		t.Errorf("appended %v, want s2 and s3", rows)
+	}
+}
+
+func TestLoadServiceAccountRejectsOtherKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{"not JSON", "{", "failed to parse"},
+		{"user credentials", `{"type": "authorized_user", "client_email": "a@example.com"}`, "not a service account key"},
+		{"no private key", `{"type": "service_account", "client_email": "a@example.com", "private_key": "none"}`, "no PEM private key"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "key.json")
+		if err := ioutil.WriteFile(path, []byte(tt.file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadServiceAccount(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: LoadServiceAccount = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
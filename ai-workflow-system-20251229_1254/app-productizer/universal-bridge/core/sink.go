@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Enqueue errors
+var (
+	ErrSinkFull   = errors.New("sink queue full")
+	ErrSinkClosed = errors.New("sink closed")
+)
+
+// CircuitState is the state of a sink's circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // deliveries flow normally
+	CircuitOpen     CircuitState = "open"      // deliveries wait for the cooldown
+	CircuitHalfOpen CircuitState = "half_open" // one trial delivery decides
+)
+
+// SinkConfig tunes a sink's bulkhead and circuit breaker
+type SinkConfig struct {
+	Types            []MessageType // routed automatically; without any, only rules and Enqueue feed the sink
+	Workers          int
+	QueueSize        int           // messages beyond this are rejected
+	FailureThreshold int           // consecutive failures that trip the breaker
+	Cooldown         time.Duration // how long the breaker stays open
+	MaxAttempts      int           // failed deliveries before a message is dropped
+}
+
+// DefaultSinkConfig returns the settings used for zero fields
+func DefaultSinkConfig() SinkConfig {
+	return SinkConfig{
+		Workers:          1,
+		QueueSize:        1000,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+		MaxAttempts:      3,
+	}
+}
+
+// SinkStats is a snapshot of one sink's counters
+type SinkStats struct {
+	Name      string
+	State     CircuitState
+	Queued    int
+	Delivered uint64
+	Failed    uint64 // failed attempts, including retried ones
+	Dropped   uint64 // messages given up on after MaxAttempts
+	Rejected  uint64 // messages refused because the queue was full
+}
+
+// Sink delivers messages to one downstream service, such as Sheets, Slack
+// or email. Each sink has its own queue, workers and circuit breaker, so an
+// outage in one service only backs up that sink's queue.
+type Sink struct {
+	name    string
+	config  SinkConfig
+	handler func(*UniversalMessage) error
+	clock   Clock
+	// transform returns the message the sink delivers in place of the one
+	// enqueued
+	transform func(*UniversalMessage) *UniversalMessage
+	// hold keeps back the messages it returns true for, such as ones that
+	// wait for approval
+	hold    func(*UniversalMessage) bool
+	queue   chan *UniversalMessage
+	breaker *circuitBreaker
+
+	mu     sync.Mutex
+	stats  SinkStats
+	closed bool
+
+	stopOnce sync.Once
+	stop     chan struct{} // closed when a drain times out
+	wg       sync.WaitGroup
+}
+
+// NewSink creates a sink and starts its workers
+func NewSink(name string, config SinkConfig, clock Clock, handler func(*UniversalMessage) error) *Sink {
+	defaults := DefaultSinkConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaults.Cooldown
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if clock == nil {
+		clock = defaultClock
+	}
+
+	s := &Sink{
+		name:    name,
+		config:  config,
+		handler: handler,
+		clock:   clock,
+		queue:   make(chan *UniversalMessage, config.QueueSize),
+		breaker: &circuitBreaker{name: name, threshold: config.FailureThreshold, cooldown: config.Cooldown, state: CircuitClosed},
+		stats:   SinkStats{Name: name},
+		stop:    make(chan struct{}),
+	}
+
+	for w := 0; w < config.Workers; w++ {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return s
+}
+
+// Name returns the sink's name
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Accepts reports whether messages of the given type are routed to the sink
+func (s *Sink) Accepts(messageType MessageType) bool {
+	for _, t := range s.config.Types {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTransform makes the sink deliver transform's result for each message
+// enqueued. It must return a copy rather than change the message, which
+// the handler and other sinks also get.
+func (s *Sink) SetTransform(transform func(*UniversalMessage) *UniversalMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transform = transform
+}
+
+// SetHold keeps back every message enqueued, after any transform, that hold
+// returns true for; it takes charge of them, and can queue them later
+// with release
+func (s *Sink) SetHold(hold func(*UniversalMessage) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold = hold
+}
+
+// Enqueue queues a message without blocking; it fails when the queue is full
+// or the sink is closed
+func (s *Sink) Enqueue(message *UniversalMessage) error {
+	s.mu.Lock()
+	transform, hold := s.transform, s.hold
+	s.mu.Unlock()
+	if transform != nil {
+		message = transform(message)
+	}
+	if hold != nil && hold(message) {
+		return nil
+	}
+	return s.release(message)
+}
+
+// release queues a message as it is, past the transform and the hold
+func (s *Sink) release(message *UniversalMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+	select {
+	case s.queue <- message:
+		return nil
+	default:
+		s.stats.Rejected++
+		return ErrSinkFull
+	}
+}
+
+// Stats returns a snapshot of the sink's counters and breaker state
+func (s *Sink) Stats() SinkStats {
+	s.mu.Lock()
+	snapshot := s.stats
+	s.mu.Unlock()
+	snapshot.State = s.breaker.current(s.clock.Now())
+	snapshot.Queued = len(s.queue)
+	return snapshot
+}
+
+// Drain stops accepting messages and waits up to timeout for the queue to
+// empty. Messages still queued when the timeout expires are abandoned.
+func (s *Sink) Drain(timeout time.Duration) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return
+	case <-s.clock.After(timeout):
+		if remaining := len(s.queue); remaining > 0 {
+			log.Printf("❌ Sink %s abandoning %d queued messages", s.name, remaining)
+		}
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-finished
+}
+
+// Close stops the workers without waiting for queued messages
+func (s *Sink) Close() {
+	s.Drain(0)
+}
+
+func (s *Sink) run() {
+	defer s.wg.Done()
+
+	for message := range s.queue {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		s.deliver(message)
+	}
+}
+
+// deliver calls the handler until it succeeds or MaxAttempts is reached,
+// waiting out the cooldown whenever the breaker is open
+func (s *Sink) deliver(message *UniversalMessage) {
+	attempts := 0
+
+	for {
+		wait, ok := s.breaker.allow(s.clock.Now())
+		if !ok {
+			select {
+			case <-s.stop:
+				return
+			case <-s.clock.After(wait):
+				continue
+			}
+		}
+
+		err := s.call(message)
+		s.breaker.record(err == nil, s.clock.Now())
+		if err == nil {
+			s.mu.Lock()
+			s.stats.Delivered++
+			s.mu.Unlock()
+			return
+		}
+
+		attempts++
+		s.mu.Lock()
+		s.stats.Failed++
+		if attempts >= s.config.MaxAttempts {
+			s.stats.Dropped++
+		}
+		s.mu.Unlock()
+
+		if attempts >= s.config.MaxAttempts {
+			log.Printf("❌ Sink %s dropping message %s after %d attempts: %v", s.name, message.ID, attempts, err)
+			return
+		}
+		log.Printf("❌ Sink %s failed message %s (attempt %d): %v", s.name, message.ID, attempts, err)
+	}
+}
+
+// call runs the handler, turning a panic into an error so it cannot take
+// down the worker
+func (s *Sink) call(message *UniversalMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return s.handler(message)
+}
+
+// circuitBreaker trips open after consecutive failures and lets a single
+// trial through once the cooldown has passed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	trial     bool // a half-open trial is in progress
+}
+
+// allow reports whether a delivery may proceed, or how long to wait
+func (cb *circuitBreaker) allow(now time.Time) (time.Duration, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		remaining := cb.openedAt.Add(cb.cooldown).Sub(now)
+		if remaining > 0 {
+			return remaining, false
+		}
+		fmt.Printf("🔁 Sink %s circuit half-open, sending trial delivery\n", cb.name)
+		cb.state = CircuitHalfOpen
+		cb.trial = true
+		return 0, true
+	case CircuitHalfOpen:
+		if cb.trial {
+			return cb.cooldown, false
+		}
+		cb.trial = true
+		return 0, true
+	}
+	return 0, true
+}
+
+// record updates the breaker with a delivery outcome
+func (cb *circuitBreaker) record(success bool, now time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if success {
+		if cb.state != CircuitClosed {
+			fmt.Printf("✅ Sink %s circuit closed\n", cb.name)
+		}
+		cb.state = CircuitClosed
+		cb.failures = 0
+		cb.trial = false
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		if cb.state != CircuitOpen {
+			fmt.Printf("🚫 Sink %s circuit open after %d failures, cooling down for %s\n", cb.name, cb.failures, cb.cooldown)
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = now
+		cb.trial = false
+	}
+}
+
+// current returns the state as seen at now, reporting an expired open
+// breaker as half-open
+func (cb *circuitBreaker) current(now time.Time) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && !now.Before(cb.openedAt.Add(cb.cooldown)) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitSinkStats waits until the sink's counters satisfy done
+func waitSinkStats(t *testing.T, s *Sink, done func(SinkStats) bool) SinkStats {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		stats := s.Stats()
+		if done(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sink stats %+v", stats)
+		}
+	}
+}
+
+// waitTimers waits until n timers are pending on the clock
+func waitTimers(t *testing.T, clock *ManualClock, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); clock.Pending() < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers pending, want %d", clock.Pending(), n)
+		}
+	}
+}
+
+func testMessage(n int) *UniversalMessage {
+	return &UniversalMessage{ID: fmt.Sprintf("m%d", n), MessageType: DataSync, Payload: map[string]interface{}{"n": n}}
+}
+
+func TestSinkRejectsWhenSaturated(t *testing.T) {
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	s := NewSink("slow", SinkConfig{Workers: 1, QueueSize: 2}, nil, func(message *UniversalMessage) error {
+		started <- message.ID
+		<-release
+		return nil
+	})
+
+	if err := s.Enqueue(testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-started // the worker is busy with m1
+	for n := 2; n <= 3; n++ {
+		if err := s.Enqueue(testMessage(n)); err != nil {
+			t.Fatalf("m%d: %v", n, err)
+		}
+	}
+	if err := s.Enqueue(testMessage(4)); !errors.Is(err, ErrSinkFull) {
+		t.Fatalf("Enqueue past the queue = %v, want ErrSinkFull", err)
+	}
+	if stats := s.Stats(); stats.Queued != 2 || stats.Rejected != 1 || stats.State != CircuitClosed {
+		t.Errorf("stats %+v, want 2 queued and 1 rejected", stats)
+	}
+
+	// Draining delivers what was queued, then refuses more
+	close(release)
+	s.Drain(5 * time.Second)
+	if stats := s.Stats(); stats.Delivered != 3 || stats.Queued != 0 {
+		t.Errorf("stats after draining %+v, want 3 delivered", stats)
+	}
+	if err := s.Enqueue(testMessage(5)); !errors.Is(err, ErrSinkClosed) {
+		t.Errorf("Enqueue after Drain = %v, want ErrSinkClosed", err)
+	}
+	s.Close()
+}
+
+func TestSinkDrainAbandonsAfterTimeout(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	s := NewSink("stuck", SinkConfig{Workers: 1}, clock, func(message *UniversalMessage) error {
+		started <- message.ID
+		<-release
+		return nil
+	})
+	for n := 1; n <= 3; n++ {
+		if err := s.Enqueue(testMessage(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		s.Drain(time.Minute)
+		close(drained)
+	}()
+	waitTimers(t, clock, 1)
+	clock.Advance(time.Minute)
+	select {
+	case <-s.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not time out")
+	}
+	// The delivery in progress finishes; the two still queued are abandoned
+	close(release)
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return after its timeout")
+	}
+	if stats := s.Stats(); stats.Delivered != 1 {
+		t.Errorf("stats %+v, want only the delivery in progress finished", stats)
+	}
+}
+
+func TestSinkCircuitBreaker(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	var failing atomic.Bool
+	failing.Store(true)
+	var calls atomic.Int32
+	flaky := NewSink("flaky", SinkConfig{FailureThreshold: 2, Cooldown: 30 * time.Second, MaxAttempts: 5}, clock, func(*UniversalMessage) error {
+		calls.Add(1)
+		if failing.Load() {
+			return errors.New("service down")
+		}
+		return nil
+	})
+	defer flaky.Close()
+	healthy := NewSink("healthy", SinkConfig{}, clock, func(*UniversalMessage) error { return nil })
+	defer healthy.Close()
+
+	if err := flaky.Enqueue(testMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitTimers(t, clock, 1) // waiting out the cooldown
+	if stats := flaky.Stats(); stats.State != CircuitOpen || stats.Failed != 2 || calls.Load() != 2 {
+		t.Fatalf("stats %+v after %d calls, want open after 2 failures", stats, calls.Load())
+	}
+
+	// The outage backs up only its own sink
+	if err := healthy.Enqueue(testMessage(2)); err != nil {
+		t.Fatal(err)
+	}
+	waitSinkStats(t, healthy, func(s SinkStats) bool { return s.Delivered == 1 })
+
+	// Past the cooldown a failed trial opens the breaker again, and a
+	// successful one closes it
+	clock.Advance(30 * time.Second)
+	waitTimers(t, clock, 1)
+	if stats := flaky.Stats(); stats.State != CircuitOpen || calls.Load() != 3 {
+		t.Fatalf("stats %+v after %d calls, want open again after the failed trial", stats, calls.Load())
+	}
+	failing.Store(false)
+	clock.Advance(29 * time.Second)
+	if calls.Load() != 3 {
+		t.Errorf("called during the cooldown")
+	}
+	clock.Advance(time.Second)
+	stats := waitSinkStats(t, flaky, func(s SinkStats) bool { return s.Delivered == 1 })
+	if stats.State != CircuitClosed || stats.Failed != 3 || stats.Dropped != 0 {
+		t.Errorf("stats %+v, want closed after the trial delivered", stats)
+	}
+}
+
+func TestSinkDropsAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	s := NewSink("broken", SinkConfig{MaxAttempts: 3, FailureThreshold: 10}, nil, func(message *UniversalMessage) error {
+		calls.Add(1)
+		if message.Payload["n"] == 1 {
+			panic("bug in the handler")
+		}
+		return errors.New("rejected")
+	})
+	defer s.Close()
+	for n := 1; n <= 2; n++ {
+		if err := s.Enqueue(testMessage(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := waitSinkStats(t, s, func(s SinkStats) bool { return s.Dropped == 2 })
+	if stats.Failed != 6 || stats.Delivered != 0 || calls.Load() != 6 {
+		t.Errorf("stats %+v after %d calls, want each message tried 3 times, panics included", stats, calls.Load())
+	}
+}
+
+func TestSinkTransformAndHold(t *testing.T) {
+	delivered := make(chan *UniversalMessage, 10)
+	s := NewSink("email", SinkConfig{Types: []MessageType{SaleEvent}}, nil, func(message *UniversalMessage) error {
+		delivered <- message
+		return nil
+	})
+	defer s.Close()
+	if !s.Accepts(SaleEvent) || s.Accepts(DataSync) {
+		t.Errorf("sink for sale events accepts the wrong types")
+	}
+	s.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		copied := *message
+		copied.Payload = mergePayload(message.Payload, map[string]interface{}{"text": "hello"})
+		return &copied
+	})
+	var held []*UniversalMessage
+	s.SetHold(func(message *UniversalMessage) bool {
+		if message.Payload["n"] == 1 {
+			held = append(held, message)
+			return true
+		}
+		return false
+	})
+
+	original := testMessage(1)
+	for _, message := range []*UniversalMessage{original, testMessage(2)} {
+		if err := s.Enqueue(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := <-delivered; got.ID != "m2" || got.Payload["text"] != "hello" {
+		t.Errorf("delivered %+v, want m2 transformed", got)
+	}
+	if len(held) != 1 || held[0].Payload["text"] != "hello" || original.Payload["text"] != nil {
+		t.Fatalf("held %+v, want the transformed copy of m1", held)
+	}
+	if err := s.release(held[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-delivered; got.ID != "m1" {
+		t.Errorf("released %s, want m1", got.ID)
+	}
+}
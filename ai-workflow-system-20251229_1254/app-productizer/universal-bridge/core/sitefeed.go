@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	awsAccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	awsSecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+)
+
+// Testimonial is a buyer's quote the seller chose to show
+type Testimonial struct {
+	Name    string `yaml:"name" json:"name"`
+	Product string `yaml:"product" json:"product,omitempty"`
+	Quote   string `yaml:"quote" json:"quote"`
+	URL     string `yaml:"url" json:"url,omitempty"`
+}
+
+// GitFeedTarget publishes into a checkout of the site's repository
+type GitFeedTarget struct {
+	Dir    string `yaml:"dir"`
+	Path   string `yaml:"path"`
+	Branch string `yaml:"branch"`
+	Push   bool   `yaml:"push"`
+}
+
+// S3FeedTarget publishes to an S3 bucket, or an S3-compatible store at
+// Endpoint
+type S3FeedTarget struct {
+	Bucket   string `yaml:"bucket"`
+	Region   string `yaml:"region"`
+	Prefix   string `yaml:"prefix"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// SiteFeedConfig says what social proof a static site gets and where it is
+// published, loaded from YAML:
+//
+//	schedule: "*/15 * * * *"        # the default
+//	products: [p1, go-course]       # by ID or permalink; the default is every product sold
+//	min_sold: 10                    # products with fewer sales get no counter yet
+//	testimonials: testimonials.yaml # a list of name, product, quote and url
+//	git:
+//	  dir: ../my-site               # a checkout of the site's repository
+//	  path: data/gumroad            # directory in it, the default
+//	  branch: main
+//	  push: true
+//	s3:
+//	  bucket: my-site
+//	  region: us-east-1
+//	  prefix: data/gumroad/
+//	  endpoint: https://minio.example.com  # for S3-compatible stores
+//
+// At least one of git and s3 is needed. S3 takes its keys from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type SiteFeedConfig struct {
+	Schedule     string         `yaml:"schedule"`
+	Products     []string       `yaml:"products"`
+	MinSold      int            `yaml:"min_sold"`
+	Testimonials string         `yaml:"testimonials"`
+	Git          *GitFeedTarget `yaml:"git"`
+	S3           *S3FeedTarget  `yaml:"s3"`
+
+	testimonials []Testimonial
+}
+
+// LoadSiteFeedConfig reads the feed settings and the testimonials they name
+func LoadSiteFeedConfig(configPath string) (SiteFeedConfig, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return SiteFeedConfig{}, fmt.Errorf("failed to read site feed config: %v", err)
+	}
+
+	var config SiteFeedConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return SiteFeedConfig{}, fmt.Errorf("failed to parse site feed config %s: %v", configPath, err)
+	}
+	invalid := func(format string, args ...interface{}) (SiteFeedConfig, error) {
+		return SiteFeedConfig{}, fmt.Errorf("invalid site feed config %s: %s", configPath, fmt.Sprintf(format, args...))
+	}
+
+	if config.Schedule == "" {
+		config.Schedule = "*/15 * * * *"
+	}
+	if _, err := ParseCron(config.Schedule); err != nil {
+		return invalid("schedule: %v", err)
+	}
+	if config.Git == nil && config.S3 == nil {
+		return invalid("no git or s3 target")
+	}
+	if config.Git != nil {
+		if config.Git.Dir == "" {
+			return invalid("git: no dir")
+		}
+		if config.Git.Path == "" {
+			config.Git.Path = "data/gumroad"
+		}
+		if filepath.IsAbs(config.Git.Path) || strings.HasPrefix(filepath.Clean(config.Git.Path), "..") {
+			return invalid("git: path %q is not inside the repository", config.Git.Path)
+		}
+	}
+	if config.S3 != nil && (config.S3.Bucket == "" || config.S3.Region == "") {
+		return invalid("s3: a bucket and region are needed")
+	}
+
+	if config.Testimonials != "" {
+		// Relative to the config file, like the site it describes
+		testimonialsPath := config.Testimonials
+		if !filepath.IsAbs(testimonialsPath) {
+			testimonialsPath = filepath.Join(filepath.Dir(configPath), testimonialsPath)
+		}
+		content, err := ioutil.ReadFile(testimonialsPath)
+		if err != nil {
+			return invalid("testimonials: %v", err)
+		}
+		err = yaml.Unmarshal(content, &config.testimonials)
+		if err != nil {
+			return invalid("testimonials %s: %v", testimonialsPath, err)
+		}
+		for i, testimonial := range config.testimonials {
+			if testimonial.Name == "" || testimonial.Quote == "" {
+				return invalid("testimonial %d needs a name and a quote", i+1)
+			}
+		}
+	}
+	return config, nil
+}
+
+// FeedProduct is one product's counter in the feed
+type FeedProduct struct {
+	ID         string     `json:"id"`
+	Permalink  string     `json:"permalink,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Sold       int        `json:"sold"`
+	SoldLabel  string     `json:"sold_label"`
+	LastSaleAt *time.Time `json:"last_sale_at,omitempty"`
+}
+
+// SitePublisher puts the feed's files where the site reads them
+type SitePublisher interface {
+	Publish(files map[string][]byte) error
+}
+
+// SiteFeed writes the data files a static site shows social proof from:
+// sales.json with copies sold per product, badges/<product>.json in the
+// shields.io endpoint format, testimonials.json, and social-proof.md with
+// the same counts as front matter for Jekyll or Hugo. Files only change
+// when a sale does, so a Git target is not given an empty commit each run.
+// Refunded sales and a bundle's component sales are not counted.
+type SiteFeed struct {
+	Config     SiteFeedConfig
+	Publishers []SitePublisher
+
+	sales *SalesStore
+
+	mu        sync.Mutex
+	published map[string]string // file → hash of what was last published
+}
+
+// NewSiteFeed builds the feed from the sales store and publishes it to the
+// config's targets; S3 needs the access keys
+func NewSiteFeed(config SiteFeedConfig, sales *SalesStore, clock Clock, accessKey, secretKey string) (*SiteFeed, error) {
+	sf := &SiteFeed{Config: config, sales: sales, published: make(map[string]string)}
+	if config.Git != nil {
+		sf.Publishers = append(sf.Publishers, NewGitPublisher(*config.Git))
+	}
+	if config.S3 != nil {
+		if accessKey == "" || secretKey == "" {
+			return nil, fmt.Errorf("S3 needs %s and %s", awsAccessKeyEnv, awsSecretKeyEnv)
+		}
+		sf.Publishers = append(sf.Publishers, NewS3Publisher(*config.S3, accessKey, secretKey, clock))
+	}
+	return sf, nil
+}
+
+// Products counts copies sold of each product the feed covers, most sold
+// first
+func (sf *SiteFeed) Products() []FeedProduct {
+	wanted := make(map[string]bool)
+	for _, product := range sf.Config.Products {
+		wanted[product] = true
+	}
+
+	byID := make(map[string]*FeedProduct)
+	for _, sale := range sf.sales.All() {
+		if sale.Refunded || sale.BundleSaleID != "" {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[sale.Product] && !wanted[sale.Permalink] {
+			continue
+		}
+		product, ok := byID[sale.Product]
+		if !ok {
+			product = &FeedProduct{ID: sale.Product}
+			byID[sale.Product] = product
+		}
+		product.Sold++
+		if sale.Permalink != "" {
+			product.Permalink = sale.Permalink
+		}
+		if sale.ProductName != "" {
+			product.Name = sale.ProductName
+		}
+		if !sale.CreatedAt.IsZero() && (product.LastSaleAt == nil || sale.CreatedAt.After(*product.LastSaleAt)) {
+			created := sale.CreatedAt
+			product.LastSaleAt = &created
+		}
+	}
+
+	products := make([]FeedProduct, 0, len(byID))
+	for _, product := range byID {
+		if product.Sold < sf.Config.MinSold {
+			continue
+		}
+		product.SoldLabel = soldLabel(product.Sold)
+		products = append(products, *product)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Sold != products[j].Sold {
+			return products[i].Sold > products[j].Sold
+		}
+		return products[i].ID < products[j].ID
+	})
+	return products
+}
+
+// soldLabel rounds a count for display: 950, 1.2k, 12k
+func soldLabel(sold int) string {
+	switch {
+	case sold < 1000:
+		return fmt.Sprint(sold)
+	case sold < 10000:
+		label := fmt.Sprintf("%.1f", float64(sold/100)/10)
+		return strings.TrimSuffix(label, ".0") + "k"
+	}
+	return fmt.Sprintf("%dk", sold/1000)
+}
+
+// Files renders the feed's files by path
+func (sf *SiteFeed) Files() (map[string][]byte, error) {
+	products := sf.Products()
+	total := 0
+	for _, product := range products {
+		total += product.Sold
+	}
+	testimonials := sf.Config.testimonials
+	if testimonials == nil {
+		testimonials = []Testimonial{}
+	}
+
+	files := make(map[string][]byte)
+	encode := func(name string, value interface{}) error {
+		content, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		files[name] = append(content, '\n')
+		return nil
+	}
+	err := encode("sales.json", map[string]interface{}{
+		"total_sold":       total,
+		"total_sold_label": soldLabel(total),
+		"products":         products,
+	})
+	if err == nil {
+		err = encode("testimonials.json", testimonials)
+	}
+	for _, product := range products {
+		if err != nil {
+			break
+		}
+		// shields.io renders these with https://img.shields.io/endpoint?url=
+		err = encode("badges/"+badgeName(product)+".json", map[string]interface{}{
+			"schemaVersion": 1,
+			"label":         "copies sold",
+			"message":       product.SoldLabel,
+			"color":         "brightgreen",
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	frontMatter, err := yaml.Marshal(map[string]interface{}{
+		"total_sold":       total,
+		"total_sold_label": soldLabel(total),
+		"products":         products,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode social-proof.md: %v", err)
+	}
+	var markdown bytes.Buffer
+	markdown.WriteString("---\n")
+	markdown.Write(frontMatter)
+	markdown.WriteString("---\n\n")
+	for _, product := range products {
+		name := product.Name
+		if name == "" {
+			name = product.ID
+		}
+		fmt.Fprintf(&markdown, "- **%s**: %s copies sold\n", name, product.SoldLabel)
+	}
+	for _, testimonial := range testimonials {
+		fmt.Fprintf(&markdown, "\n> %s\n>\n> — %s\n", strings.ReplaceAll(strings.TrimSpace(testimonial.Quote), "\n", "\n> "), testimonial.Name)
+	}
+	files["social-proof.md"] = markdown.Bytes()
+	return files, nil
+}
+
+// badgeName is a product's badge file name, its permalink when it has one
+func badgeName(product FeedProduct) string {
+	name := product.Permalink
+	if name == "" {
+		name = product.ID
+	}
+	return strings.NewReplacer("/", "-", "\\", "-", "..", "-").Replace(name)
+}
+
+// Publish renders the feed and hands the files that changed since the last
+// publish to every publisher
+func (sf *SiteFeed) Publish() error {
+	files, err := sf.Files()
+	if err != nil {
+		return err
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	changed := make(map[string][]byte)
+	hashes := make(map[string]string)
+	for name, content := range files {
+		sum := sha256.Sum256(content)
+		hashes[name] = hex.EncodeToString(sum[:])
+		if sf.published[name] != hashes[name] {
+			changed[name] = content
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	for _, publisher := range sf.Publishers {
+		err = publisher.Publish(changed)
+		if err != nil {
+			return err
+		}
+	}
+	for name, hash := range hashes {
+		sf.published[name] = hash
+	}
+	fmt.Printf("🌐 Published %d site feed files\n", len(changed))
+	return nil
+}
+
+// RegisterSiteFeedJobs adds the site_feed job handler, which publishes the
+// feed
+func RegisterSiteFeedJobs(s *Scheduler, feed *SiteFeed) {
+	s.Handle("site_feed", func(job Job) error {
+		return feed.Publish()
+	})
+}
+
+// GitPublisher writes the files into a checkout and commits them, pushing
+// the branch when Push is set
+type GitPublisher struct {
+	GitFeedTarget
+
+	run func(dir string, args ...string) ([]byte, error)
+}
+
+// NewGitPublisher publishes into the target's checkout with the git command
+func NewGitPublisher(target GitFeedTarget) *GitPublisher {
+	return &GitPublisher{GitFeedTarget: target, run: runGit}
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+func (gp *GitPublisher) Publish(files map[string][]byte) error {
+	if gp.Branch != "" {
+		if _, err := gp.run(gp.Dir, "checkout", gp.Branch); err != nil {
+			return err
+		}
+	}
+	if gp.Push {
+		// Someone may have pushed to the site since the last run
+		if _, err := gp.run(gp.Dir, "pull", "--ff-only"); err != nil {
+			return err
+		}
+	}
+	for name, content := range files {
+		target := filepath.Join(gp.Dir, gp.Path, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(target, content, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", target, err)
+		}
+	}
+	if _, err := gp.run(gp.Dir, "add", "--", gp.Path); err != nil {
+		return err
+	}
+	status, err := gp.run(gp.Dir, "status", "--porcelain", "--", gp.Path)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(status)) == 0 {
+		return nil
+	}
+	if _, err := gp.run(gp.Dir, "commit", "-m", "Update Gumroad sales data", "--", gp.Path); err != nil {
+		return err
+	}
+	if gp.Push {
+		_, err = gp.run(gp.Dir, "push")
+	}
+	return err
+}
+
+// S3Publisher uploads the files as public-readable objects
+type S3Publisher struct {
+	S3FeedTarget
+	AccessKey  string
+	SecretKey  string
+	HTTPClient *http.Client
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	clock Clock
+}
+
+// NewS3Publisher uploads to the target's bucket with the access keys
+func NewS3Publisher(target S3FeedTarget, accessKey, secretKey string, clock Clock) *S3Publisher {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &S3Publisher{
+		S3FeedTarget: target,
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Retries:      3,
+		Backoff:      time.Second,
+		MaxBackoff:   30 * time.Second,
+		clock:        clock,
+	}
+}
+
+func (sp *S3Publisher) Publish(files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := sp.put(path.Join(sp.Prefix, name), files[name])
+		if err != nil {
+			return fmt.Errorf("uploading %s to %s: %v", name, sp.Bucket, err)
+		}
+	}
+	return nil
+}
+
+// objectURL is where an object is PUT: virtual-hosted on AWS, path-style
+// on an S3-compatible endpoint
+func (sp *S3Publisher) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	escaped := strings.Join(segments, "/")
+	if sp.Endpoint != "" {
+		return strings.TrimSuffix(sp.Endpoint, "/") + "/" + awsEscape(sp.Bucket) + "/" + escaped
+	}
+	return "https://" + sp.Bucket + ".s3." + sp.Region + ".amazonaws.com/" + escaped
+}
+
+func (sp *S3Publisher) put(key string, content []byte) error {
+	contentType := "application/json"
+	if strings.HasSuffix(key, ".md") {
+		contentType = "text/markdown; charset=utf-8"
+	}
+	retry := retrier{Retries: sp.Retries, Backoff: sp.Backoff, MaxBackoff: sp.MaxBackoff, Clock: sp.clock, Classify: retryTransient}
+	return retry.do("S3 upload", func() error {
+		request, err := http.NewRequest(http.MethodPut, sp.objectURL(key), bytes.NewReader(content))
+		if err != nil {
+			return final(err)
+		}
+		request.Header.Set("Content-Type", contentType)
+		// The site reads the feed often; a short cache keeps it live-ish
+		request.Header.Set("Cache-Control", "public, max-age=300")
+		sum := sha256.Sum256(content)
+		signAWS(request, hex.EncodeToString(sum[:]), sp.Region, "s3", sp.AccessKey, sp.SecretKey, sp.clock.Now())
+
+		response, err := sp.HTTPClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			return readAPIError("S3", response)
+		}
+		return nil
+	})
+}
+
+// signAWS signs a request with AWS Signature Version 4, setting its
+// x-amz-date, x-amz-content-sha256 and Authorization headers. Every header
+// already on the request is signed, with the host.
+func signAWS(request *http.Request, payloadHash, region, service, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := request.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+
+	canonicalPath := request.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalPath,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := mac(mac(mac(mac([]byte("AWS4"+secretKey), day), region), service), "aws4_request")
+	signature := hex.EncodeToString(mac(signingKey, stringToSign))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsEscape percent-encodes everything but the characters AWS leaves alone
+func awsEscape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	twilioAPIURL        = "https://api.twilio.com/2010-04-01"
+	twilioAccountSIDEnv = "TWILIO_ACCOUNT_SID"
+	twilioAuthTokenEnv  = "TWILIO_AUTH_TOKEN"
+	maxSMSLength        = 320
+)
+
+// SMS event types recipients opt in to
+const (
+	SMSBigSale    = "big_sale"
+	SMSDispute    = "dispute"
+	SMSSystemDown = "system_down"
+)
+
+var smsEvents = map[string]bool{SMSBigSale: true, SMSDispute: true, SMSSystemDown: true}
+
+// QuietHours is a daily window in which only urgent texts are sent. A
+// window whose end is before its start runs past midnight.
+type QuietHours struct {
+	Start    string `yaml:"start"` // "22:00"
+	End      string `yaml:"end"`   // "07:00"
+	Timezone string `yaml:"timezone"`
+
+	start, end int // minutes after midnight
+	location   *time.Location
+}
+
+// parse checks the window and resolves its timezone
+func (q *QuietHours) parse() error {
+	for _, field := range []struct {
+		value  string
+		minute *int
+	}{{q.Start, &q.start}, {q.End, &q.end}} {
+		clock, err := time.Parse("15:04", field.value)
+		if err != nil {
+			return fmt.Errorf("bad quiet hours time %q, want HH:MM", field.value)
+		}
+		*field.minute = clock.Hour()*60 + clock.Minute()
+	}
+	location, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return fmt.Errorf("bad quiet hours timezone %q: %v", q.Timezone, err)
+	}
+	q.location = location
+	return nil
+}
+
+// Until returns when the quiet window around t ends, or t itself when t
+// is outside it
+func (q *QuietHours) Until(t time.Time) time.Time {
+	local := t.In(q.location)
+	minute := local.Hour()*60 + local.Minute()
+	quiet := false
+	if q.start <= q.end {
+		quiet = minute >= q.start && minute < q.end
+	} else {
+		quiet = minute >= q.start || minute < q.end
+	}
+	if !quiet {
+		return t
+	}
+
+	end := time.Date(local.Year(), local.Month(), local.Day(), q.end/60, q.end%60, 0, 0, q.location)
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// SMSRecipient is a phone number and the events it is texted about
+type SMSRecipient struct {
+	Name   string   `yaml:"name"`
+	Phone  string   `yaml:"phone"` // E.164, e.g. +15551234567
+	Events []string `yaml:"events"`
+	// QuietHours overrides the config's quiet hours for this recipient
+	QuietHours *QuietHours `yaml:"quiet_hours"`
+}
+
+// wants reports whether the recipient opted in to the event
+func (r SMSRecipient) wants(event string) bool {
+	for _, e := range r.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SMSConfig says who is texted about what, loaded from YAML:
+//
+//	from: "+15550001111"         # the Twilio number texts come from
+//	big_sale:                    # the smallest sale, per currency, that is big
+//	  usd: "100.00"
+//	  eur: "90.00"
+//	quiet_hours:                 # non-urgent texts wait until the end
+//	  start: "22:00"
+//	  end: "07:00"
+//	  timezone: America/New_York
+//	urgent: [system_down]        # sent during quiet hours too; the default
+//	recipients:
+//	  - name: owner
+//	    phone: "+15551234567"
+//	    events: [big_sale, dispute, system_down]
+//	  - name: on-call
+//	    phone: "+15557654321"
+//	    events: [system_down]
+type SMSConfig struct {
+	From       string            `yaml:"from"`
+	BigSale    map[string]string `yaml:"big_sale"`
+	QuietHours *QuietHours       `yaml:"quiet_hours"`
+	Urgent     []string          `yaml:"urgent"`
+	Recipients []SMSRecipient    `yaml:"recipients"`
+
+	bigSale map[string]Money
+}
+
+// LoadSMSConfig reads the SMS recipients and thresholds
+func LoadSMSConfig(path string) (SMSConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SMSConfig{}, fmt.Errorf("failed to read SMS config: %v", err)
+	}
+
+	var config SMSConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return SMSConfig{}, fmt.Errorf("failed to parse SMS config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (SMSConfig, error) {
+		return SMSConfig{}, fmt.Errorf("invalid SMS config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	if !isPhoneNumber(config.From) {
+		return invalid("from %q is not a +E.164 number", config.From)
+	}
+	config.bigSale = make(map[string]Money)
+	for currency, amount := range config.BigSale {
+		threshold, err := ParseMoney(amount, currency)
+		if err != nil {
+			return invalid("big_sale %s: %v", currency, err)
+		}
+		config.bigSale[threshold.Currency] = threshold
+	}
+	if config.QuietHours != nil {
+		if err := config.QuietHours.parse(); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	if config.Urgent == nil {
+		config.Urgent = []string{SMSSystemDown}
+	}
+	for _, event := range config.Urgent {
+		if !smsEvents[event] {
+			return invalid("unknown urgent event %q", event)
+		}
+	}
+	if len(config.Recipients) == 0 {
+		return invalid("no recipients")
+	}
+	for i, recipient := range config.Recipients {
+		if !isPhoneNumber(recipient.Phone) {
+			return invalid("recipient %d phone %q is not a +E.164 number", i+1, recipient.Phone)
+		}
+		for _, event := range recipient.Events {
+			if !smsEvents[event] {
+				return invalid("recipient %s: unknown event %q", recipient.Phone, event)
+			}
+		}
+		if recipient.QuietHours != nil {
+			if err := recipient.QuietHours.parse(); err != nil {
+				return invalid("recipient %s: %v", recipient.Phone, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// isPhoneNumber reports whether s is a +E.164 number
+func isPhoneNumber(s string) bool {
+	if len(s) < 8 || len(s) > 16 || s[0] != '+' {
+		return false
+	}
+	return strings.Trim(s[1:], "0123456789") == ""
+}
+
+// HeldSMS is a text waiting for its recipient's quiet hours to end
+type HeldSMS struct {
+	To        string    `json:"to"`
+	Event     string    `json:"event"`
+	Body      string    `json:"body"`
+	DeliverAt time.Time `json:"deliver_at"`
+}
+
+// SMSNotifier texts the seller about high-value events through Twilio: a
+// sale at or above the big_sale threshold for its currency, a dispute, and
+// a system going down, meaning a health_check whose status is down,
+// unhealthy or critical, or an error message with severity critical. Each
+// recipient gets the events it opted in to; outside the urgent ones, texts
+// that fall in quiet hours are held until they end. Texts go out through
+// the "sms" sink, so Twilio outages are retried behind its circuit breaker.
+type SMSNotifier struct {
+	Config     SMSConfig
+	AccountSID string
+	AuthToken  string
+	// APIURL defaults to the public API
+	APIURL     string
+	HTTPClient *http.Client
+
+	bridge *GoBridge
+	clock  Clock
+	sink   *Sink
+	path   string
+
+	mu       sync.Mutex
+	held     []HeldSMS
+	notified map[string]bool // sale and dispute IDs already texted, so redelivered pings text once
+
+	running  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSMSNotifier texts the config's recipients about the events the bridge
+// receives, authenticating with the Twilio account SID and auth token.
+// Held texts are persisted at path.
+func NewSMSNotifier(gb *GoBridge, config SMSConfig, accountSID, authToken, path string) (*SMSNotifier, error) {
+	if accountSID == "" || authToken == "" {
+		return nil, fmt.Errorf("SMS needs %s and %s", twilioAccountSIDEnv, twilioAuthTokenEnv)
+	}
+	sn := &SMSNotifier{
+		Config:     config,
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+		APIURL:     twilioAPIURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		bridge:     gb,
+		clock:      gb.clock,
+		path:       path,
+		notified:   make(map[string]bool),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read held texts: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &sn.held)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse held texts %s: %v", path, err)
+		}
+	}
+
+	sn.sink = gb.AddSink("sms", SinkConfig{}, sn.deliver)
+	gb.OnReceive(sn.handleMessage)
+	return sn, nil
+}
+
+func (sn *SMSNotifier) handleMessage(message *UniversalMessage) {
+	event, body := sn.classify(message)
+	if event == "" {
+		return
+	}
+	sn.Notify(event, body)
+}
+
+// classify names the SMS event a message is, if any, and its text
+func (sn *SMSNotifier) classify(message *UniversalMessage) (event, body string) {
+	payload := message.Payload
+	switch {
+	case isGumroadEvent(message) && stringArg(payload, "resource_name") == "sale":
+		sale, err := ParseSale(payload)
+		if err != nil || sale.Refunded {
+			return "", ""
+		}
+		threshold, ok := sn.Config.bigSale[sale.Price.Currency]
+		if cmp, err := sale.Price.Cmp(threshold); !ok || err != nil || cmp < 0 {
+			return "", ""
+		}
+		if !sn.first("sale:" + sale.ID) {
+			return "", ""
+		}
+		product := sale.ProductName
+		if product == "" {
+			product = sale.Product
+		}
+		return SMSBigSale, fmt.Sprintf("Big sale: %s bought %s for %s", sale.Email, product, sale.Price)
+
+	case isGumroadEvent(message) && stringArg(payload, "resource_name") == "dispute":
+		id := stringArg(payload, "sale_id")
+		if !sn.first("dispute:" + id) {
+			return "", ""
+		}
+		product := stringArg(payload, "product_name")
+		if product == "" {
+			product = productKey(payload)
+		}
+		return SMSDispute, fmt.Sprintf("Dispute opened on sale %s of %s by %s", id, product, stringArg(payload, "email"))
+
+	default:
+		if text := outageText(message); text != "" {
+			return SMSSystemDown, text
+		}
+	}
+	return "", ""
+}
+
+// outageText describes a system going down: a health_check whose status is
+// down, unhealthy or critical, or an error message with severity critical.
+// It is empty for any other message.
+func outageText(message *UniversalMessage) string {
+	payload := message.Payload
+	switch {
+	case message.MessageType == HealthCheck:
+		status := strings.ToLower(stringArg(payload, "status"))
+		if status != "down" && status != "unhealthy" && status != "critical" {
+			return ""
+		}
+		detail := stringArg(payload, "message")
+		if detail == "" {
+			detail = stringArg(payload, "error")
+		}
+		return strings.TrimSpace(fmt.Sprintf("System down: %s bridge reports %s. %s", message.SourceLanguage, status, detail))
+
+	case message.MessageType == Error && strings.ToLower(stringArg(payload, "severity")) == "critical":
+		detail := stringArg(payload, "error")
+		if detail == "" {
+			detail = stringArg(payload, "message")
+		}
+		return fmt.Sprintf("Critical error from %s bridge: %s", message.SourceLanguage, detail)
+	}
+	return ""
+}
+
+// first reports whether key has not been texted about before
+func (sn *SMSNotifier) first(key string) bool {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	if sn.notified[key] {
+		return false
+	}
+	sn.notified[key] = true
+	return true
+}
+
+// urgent reports whether the event is sent during quiet hours
+func (sn *SMSNotifier) urgent(event string) bool {
+	for _, e := range sn.Config.Urgent {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify texts every recipient that opted in to the event, holding the
+// text for those in quiet hours unless the event is urgent
+func (sn *SMSNotifier) Notify(event, body string) {
+	if runes := []rune(body); len(runes) > maxSMSLength {
+		body = string(runes[:maxSMSLength-3]) + "..."
+	}
+	now := sn.clock.Now()
+	held := false
+
+	for _, recipient := range sn.Config.Recipients {
+		if !recipient.wants(event) {
+			continue
+		}
+		quiet := recipient.QuietHours
+		if quiet == nil {
+			quiet = sn.Config.QuietHours
+		}
+		if quiet != nil && !sn.urgent(event) {
+			if until := quiet.Until(now); until.After(now) {
+				sn.mu.Lock()
+				sn.held = append(sn.held, HeldSMS{To: recipient.Phone, Event: event, Body: body, DeliverAt: until})
+				sn.mu.Unlock()
+				held = true
+				fmt.Printf("🌙 Holding %s text to %s until %s\n", event, recipient.Phone, until.Format(time.RFC3339))
+				continue
+			}
+		}
+		sn.enqueue(HeldSMS{To: recipient.Phone, Event: event, Body: body})
+	}
+
+	if held {
+		sn.save()
+	}
+}
+
+// enqueue queues a text on the sms sink
+func (sn *SMSNotifier) enqueue(text HeldSMS) {
+	message := sn.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+		"to":    text.To,
+		"event": text.Event,
+		"body":  text.Body,
+	}, FileSystem)
+	err := sn.sink.Enqueue(message)
+	if err != nil {
+		log.Printf("❌ Error queueing %s text to %s: %v", text.Event, text.To, err)
+	}
+}
+
+// Held returns the texts waiting for quiet hours to end
+func (sn *SMSNotifier) Held() []HeldSMS {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	return append([]HeldSMS(nil), sn.held...)
+}
+
+// release queues the held texts that are due
+func (sn *SMSNotifier) release() {
+	now := sn.clock.Now()
+	sn.mu.Lock()
+	var due, waiting []HeldSMS
+	for _, text := range sn.held {
+		if text.DeliverAt.After(now) {
+			waiting = append(waiting, text)
+		} else {
+			due = append(due, text)
+		}
+	}
+	sn.held = waiting
+	sn.mu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+	for _, text := range due {
+		sn.enqueue(text)
+	}
+	sn.save()
+}
+
+func (sn *SMSNotifier) save() {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	held := sn.held
+	if held == nil {
+		held = []HeldSMS{}
+	}
+	err := writeJSONFile(sn.path, held)
+	if err != nil {
+		log.Printf("❌ Error saving held texts: %v", err)
+	}
+}
+
+// deliver sends one queued text through the Twilio Messages API
+func (sn *SMSNotifier) deliver(message *UniversalMessage) error {
+	to := stringArg(message.Payload, "to")
+	body := stringArg(message.Payload, "body")
+	if to == "" || body == "" {
+		return fmt.Errorf("text %s has no to or body", message.ID)
+	}
+
+	form := url.Values{"To": {to}, "From": {sn.Config.From}, "Body": {body}}
+	endpoint := strings.TrimSuffix(sn.APIURL, "/") + "/Accounts/" + url.PathEscape(sn.AccountSID) + "/Messages.json"
+	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(sn.AccountSID, sn.AuthToken)
+
+	response, err := sn.HTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("texting %s: %v", to, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("texting %s: Twilio answered %s: %s", to, response.Status, strings.TrimSpace(string(detail)))
+	}
+	fmt.Printf("📱 Texted %s about %s\n", to, stringArg(message.Payload, "event"))
+	return nil
+}
+
+// Start releases held texts once their quiet hours end, checking every
+// minute, until Close
+func (sn *SMSNotifier) Start() {
+	sn.mu.Lock()
+	sn.running = true
+	sn.mu.Unlock()
+
+	go func() {
+		defer close(sn.done)
+		for {
+			sn.release()
+			select {
+			case <-sn.stop:
+				return
+			case <-sn.clock.After(time.Minute):
+			}
+		}
+	}()
+}
+
+// Close stops releasing held texts; they are kept for the next start
+func (sn *SMSNotifier) Close() {
+	sn.stopOnce.Do(func() { close(sn.stop) })
+	sn.mu.Lock()
+	running := sn.running
+	sn.mu.Unlock()
+	if running {
+		<-sn.done
+	}
+}
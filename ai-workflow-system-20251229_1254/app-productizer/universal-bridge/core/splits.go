@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Statement line kinds
+const (
+	SplitSaleLine   = "sale"
+	SplitRefundLine = "refund"
+)
+
+// splitMonth is the layout statement months are named in
+const splitMonth = "2006-01"
+
+// SplitConfig is how products' revenue is shared with collaborators, loaded
+// from YAML:
+//
+//	timezone: America/New_York  # months are counted in it; UTC if unset
+//	fees:                       # taken from each sale before it is split
+//	  percent: 10
+//	  fixed: {usd: "0.50"}
+//	collaborators:              # statements are made for each
+//	  me: {}
+//	  ana: {name: Ana Ruiz, email: ana@example.com}  # emailed her statement
+//	products:                   # by product ID or permalink
+//	  course:
+//	    shares: {me: 70, ana: 30}
+//	  templates:
+//	    shares: {me: 50, ana: 50}
+//	    fees: {percent: 5}      # instead of the default fees
+type SplitConfig struct {
+	Timezone      string                       `yaml:"timezone"`
+	Fees          SplitFees                    `yaml:"fees"`
+	Collaborators map[string]SplitCollaborator `yaml:"collaborators"`
+	Products      map[string]SplitProduct      `yaml:"products"`
+
+	location *time.Location
+}
+
+// SplitFees is what Gumroad keeps of a sale: a percentage of the price plus a
+// fixed amount in the sale's currency
+type SplitFees struct {
+	Percent float64           `yaml:"percent"`
+	Fixed   map[string]string `yaml:"fixed"`
+
+	fixed map[string]Money
+}
+
+// SplitCollaborator is someone a product's revenue is shared with
+type SplitCollaborator struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+// SplitProduct is how one product's revenue is shared. Shares are
+// percentages of what is left after fees and add up to 100.
+type SplitProduct struct {
+	Shares map[string]float64 `yaml:"shares"`
+	Fees   *SplitFees         `yaml:"fees"`
+
+	collaborators []string // sorted, so rounding is the same every time
+}
+
+// SplitLine is one collaborator's part of a sale, or of a refund, which
+// takes back the refunded part of what the sale paid
+type SplitLine struct {
+	SaleID      string    `json:"sale_id"`
+	Kind        string    `json:"kind"`
+	At          time.Time `json:"at"`
+	Product     string    `json:"product"`
+	ProductName string    `json:"product_name,omitempty"`
+	Gross       Money     `json:"gross"` // negative for a refund
+	Fees        Money     `json:"fees"`
+	Net         Money     `json:"net"`
+	Percent     float64   `json:"percent"`
+	Share       Money     `json:"share"`
+}
+
+// SplitStatement is what a collaborator earned in a month. Totals are per
+// currency.
+type SplitStatement struct {
+	Collaborator string      `json:"collaborator"`
+	Name         string      `json:"name,omitempty"`
+	Month        string      `json:"month"` // e.g. 2026-01
+	Sales        int         `json:"sales"`
+	Refunds      int         `json:"refunds"`
+	Gross        []Money     `json:"gross"`
+	Fees         []Money     `json:"fees"`
+	Net          []Money     `json:"net"`
+	Share        []Money     `json:"share"`
+	Lines        []SplitLine `json:"lines"`
+}
+
+// LoadSplitConfig reads and validates the revenue splits file
+func LoadSplitConfig(path string) (SplitConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SplitConfig{}, fmt.Errorf("failed to read splits config: %v", err)
+	}
+
+	var config SplitConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return SplitConfig{}, fmt.Errorf("failed to parse splits config %s: %v", path, err)
+	}
+	err = config.Validate()
+	if err != nil {
+		return SplitConfig{}, fmt.Errorf("invalid splits config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate resolves the timezone and fees and checks every product's shares
+// name known collaborators and add up to 100
+func (c *SplitConfig) Validate() error {
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("bad timezone %q: %v", c.Timezone, err)
+	}
+	c.location = location
+	if err := c.Fees.parse(); err != nil {
+		return fmt.Errorf("fees: %v", err)
+	}
+	if len(c.Products) == 0 {
+		return fmt.Errorf("no products are split")
+	}
+	for name, collaborator := range c.Collaborators {
+		if !languageName.MatchString(name) {
+			return fmt.Errorf("collaborator %q is not a lowercase name", name)
+		}
+		if collaborator.Email != "" && !strings.Contains(collaborator.Email, "@") {
+			return fmt.Errorf("collaborator %s has an invalid email %q", name, collaborator.Email)
+		}
+	}
+
+	for key, product := range c.Products {
+		if len(product.Shares) == 0 {
+			return fmt.Errorf("product %s has no shares", key)
+		}
+		total := 0.0
+		product.collaborators = product.collaborators[:0]
+		for name, share := range product.Shares {
+			if _, ok := c.Collaborators[name]; !ok {
+				return fmt.Errorf("product %s shares with unknown collaborator %s", key, name)
+			}
+			if share <= 0 || math.IsNaN(share) || math.IsInf(share, 0) {
+				return fmt.Errorf("product %s: %s's share must be positive", key, name)
+			}
+			total += share
+			product.collaborators = append(product.collaborators, name)
+		}
+		if math.Abs(total-100) > 1e-9 {
+			return fmt.Errorf("product %s's shares add up to %v, not 100", key, total)
+		}
+		sort.Strings(product.collaborators)
+		if product.Fees != nil {
+			if err := product.Fees.parse(); err != nil {
+				return fmt.Errorf("product %s fees: %v", key, err)
+			}
+		}
+		c.Products[key] = product
+	}
+	return nil
+}
+
+func (f *SplitFees) parse() error {
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+	f.fixed = make(map[string]Money)
+	for currency, amount := range f.Fixed {
+		fixed, err := ParseMoney(amount, currency)
+		if err != nil || fixed.Amount < 0 {
+			return fmt.Errorf("fixed %s %q is not an amount", currency, amount)
+		}
+		f.fixed[fixed.Currency] = fixed
+	}
+	return nil
+}
+
+// on returns the fees taken from a sale at price, never more than the price
+func (f SplitFees) on(price Money) (Money, error) {
+	parts, err := price.Allocate([]float64{f.Percent, 100 - f.Percent})
+	if err != nil {
+		return Money{}, err
+	}
+	fee := parts[0]
+	if fixed, ok := f.fixed[price.Currency]; ok {
+		fee.Amount += fixed.Amount
+	}
+	if fee.Amount > price.Amount {
+		fee.Amount = price.Amount
+	}
+	return fee, nil
+}
+
+// RevenueSplits makes collaborators' monthly statements from the sales
+// store, so they always match the ledger: a sale counts in the month it was
+// made, and a refund in the month it was made takes back the refunded part
+// of the sale's net, fees and all, as Gumroad returns its fee. Sales without
+// a sale_timestamp and bundles, whose components are split instead, are not
+// counted. IssueDue writes each month's statements once it has ended, and
+// emails collaborators with an address theirs.
+type RevenueSplits struct {
+	bridge *GoBridge
+	sales  *SalesStore
+	Config SplitConfig
+	dir    string // statements are written to dir/<month>/
+}
+
+// NewRevenueSplits makes statements for the config's collaborators
+func NewRevenueSplits(gb *GoBridge, sales *SalesStore, config SplitConfig, dir string) *RevenueSplits {
+	return &RevenueSplits{bridge: gb, sales: sales, Config: config, dir: dir}
+}
+
+// month returns the bounds of a month, named like 2026-01, in the config's
+// timezone
+func (rs *RevenueSplits) month(name string) (start, end time.Time, err error) {
+	start, err = time.ParseInLocation(splitMonth, name, rs.Config.location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("month %q is not like 2026-01", name)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Statements returns every collaborator's statement for the month, those of
+// the current month so far
+func (rs *RevenueSplits) Statements(month string) ([]SplitStatement, error) {
+	start, end, err := rs.month(month)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make(map[string]*SplitStatement)
+	totals := make(map[string]*[4]MoneyTotals)
+	for name, collaborator := range rs.Config.Collaborators {
+		statements[name] = &SplitStatement{Collaborator: name, Name: collaborator.Name, Month: month,
+			Gross: []Money{}, Fees: []Money{}, Net: []Money{}, Share: []Money{}, Lines: []SplitLine{}}
+		totals[name] = &[4]MoneyTotals{{}, {}, {}, {}}
+	}
+	in := func(at time.Time) bool { return !at.Before(start) && at.Before(end) }
+
+	for _, sale := range rs.sales.All() {
+		product, ok := rs.Config.Products[sale.Product]
+		if !ok && sale.Permalink != "" {
+			product, ok = rs.Config.Products[sale.Permalink]
+		}
+		if !ok || sale.IsBundle() || sale.CreatedAt.IsZero() || sale.Price.Amount <= 0 {
+			continue
+		}
+		fees := rs.Config.Fees
+		if product.Fees != nil {
+			fees = *product.Fees
+		}
+		fee, err := fees.on(sale.Price)
+		if err != nil {
+			return nil, fmt.Errorf("sale %s: %v", sale.ID, err)
+		}
+		net, err := sale.Price.Sub(fee)
+		if err != nil {
+			return nil, fmt.Errorf("sale %s: %v", sale.ID, err)
+		}
+
+		base := SplitLine{SaleID: sale.ID, Product: sale.Product, ProductName: sale.ProductName}
+		var entries []SplitLine
+		if in(sale.CreatedAt) {
+			line := base
+			line.Kind, line.At, line.Gross, line.Fees, line.Net = SplitSaleLine, sale.CreatedAt, sale.Price, fee, net
+			entries = append(entries, line)
+		}
+		if refunded, at, ok := refundOf(sale); ok && in(at) {
+			// The refunded part of the net, rounded as Allocate rounds
+			parts, err := net.Allocate([]float64{float64(refunded.Amount), float64(sale.Price.Amount - refunded.Amount)})
+			if err != nil {
+				return nil, fmt.Errorf("sale %s: %v", sale.ID, err)
+			}
+			line := base
+			line.Kind, line.At = SplitRefundLine, at
+			line.Gross = Money{Amount: -refunded.Amount, Currency: refunded.Currency}
+			line.Net = Money{Amount: -parts[0].Amount, Currency: refunded.Currency}
+			line.Fees = Money{Amount: line.Gross.Amount - line.Net.Amount, Currency: refunded.Currency}
+			entries = append(entries, line)
+		}
+
+		weights := make([]float64, len(product.collaborators))
+		for i, name := range product.collaborators {
+			weights[i] = product.Shares[name]
+		}
+		for _, entry := range entries {
+			shares, err := entry.Net.Allocate(weights)
+			if err != nil {
+				return nil, fmt.Errorf("sale %s: %v", sale.ID, err)
+			}
+			for i, name := range product.collaborators {
+				line := entry
+				line.Percent, line.Share = weights[i], shares[i]
+				statement := statements[name]
+				statement.Lines = append(statement.Lines, line)
+				if line.Kind == SplitSaleLine {
+					statement.Sales++
+				} else {
+					statement.Refunds++
+				}
+				for j, amount := range []Money{line.Gross, line.Fees, line.Net, line.Share} {
+					totals[name][j].Add(amount)
+				}
+			}
+		}
+	}
+
+	list := make([]SplitStatement, 0, len(statements))
+	for name, statement := range statements {
+		sort.SliceStable(statement.Lines, func(i, j int) bool { return statement.Lines[i].At.Before(statement.Lines[j].At) })
+		statement.Gross = totals[name][0].List()
+		statement.Fees = totals[name][1].List()
+		statement.Net = totals[name][2].List()
+		statement.Share = totals[name][3].List()
+		list = append(list, *statement)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Collaborator < list[j].Collaborator })
+	return list, nil
+}
+
+// refundOf returns how much of a sale was refunded and when; a sale refunded
+// without a refunded_at is taken to be refunded when it was made
+func refundOf(sale Sale) (Money, time.Time, bool) {
+	refunded := sale.Price
+	if !sale.Refunded {
+		// An int64 as SalesStore.Refund records it, a float64 once reloaded
+		var amount int64
+		switch cents := sale.Fields["amount_refunded_cents"].(type) {
+		case int64:
+			amount = cents
+		case float64:
+			amount = int64(cents)
+		}
+		if amount <= 0 {
+			return Money{}, time.Time{}, false
+		}
+		if amount < sale.Price.Amount {
+			refunded.Amount = amount
+		}
+	}
+	at, err := time.Parse(time.RFC3339, stringArg(sale.Fields, "refunded_at"))
+	if err != nil {
+		at = sale.CreatedAt
+	}
+	return refunded, at, true
+}
+
+// IssueDue writes the statements of the month that last ended, in the
+// config's timezone, unless they were written already, and emails them. Run
+// daily, so a month is issued however far the timezone is from the
+// scheduler's.
+func (rs *RevenueSplits) IssueDue() error {
+	now := rs.bridge.clock.Now().In(rs.Config.location)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, rs.Config.location).AddDate(0, -1, 0).Format(splitMonth)
+	dir := filepath.Join(rs.dir, month)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	statements, err := rs.Statements(month)
+	if err != nil {
+		return err
+	}
+
+	// Written to a temporary directory first, so a failure is retried whole
+	partial := dir + ".partial"
+	err = os.MkdirAll(partial, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create statements directory: %v", err)
+	}
+	for _, statement := range statements {
+		err = writeJSONFile(filepath.Join(partial, statement.Collaborator+".json"), statement)
+		if err == nil {
+			err = writeStatementCSVFile(filepath.Join(partial, statement.Collaborator+".csv"), statement)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s's statement: %v", statement.Collaborator, err)
+		}
+	}
+	err = os.Rename(partial, dir)
+	if err != nil {
+		return fmt.Errorf("failed to write statements: %v", err)
+	}
+	fmt.Printf("🧾 Issued %d revenue statements for %s\n", len(statements), month)
+
+	sink := rs.bridge.Sink("email")
+	for _, statement := range statements {
+		collaborator := rs.Config.Collaborators[statement.Collaborator]
+		if collaborator.Email == "" {
+			continue
+		}
+		if sink == nil {
+			log.Printf("❌ Not emailing %s's statement: no email sink registered", statement.Collaborator)
+			continue
+		}
+		err := sink.Enqueue(rs.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+			"to":   collaborator.Email,
+			"text": statementEmail(statement),
+		}, FileSystem))
+		if err != nil {
+			log.Printf("❌ Error emailing %s's statement: %v", statement.Collaborator, err)
+		}
+	}
+	return nil
+}
+
+// statementEmail is the plain-text email a collaborator's statement is sent as
+func statementEmail(statement SplitStatement) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subject: Revenue statement for %s\n\n", statement.Month)
+	if statement.Name != "" {
+		fmt.Fprintf(&b, "Hi %s,\n\n", statement.Name)
+	}
+	fmt.Fprintf(&b, "Your share of %d sales and %d refunds in %s:\n\n", statement.Sales, statement.Refunds, statement.Month)
+	if len(statement.Share) == 0 {
+		b.WriteString("  nothing this month\n")
+	}
+	for _, share := range statement.Share {
+		fmt.Fprintf(&b, "  %s\n", share)
+	}
+	b.WriteString("\nEach sale's share is of its price after Gumroad's fees; refunds take back their part.\n")
+	return b.String()
+}
+
+// writeStatementCSV writes a statement's lines as CSV, one per sale or refund
+func writeStatementCSV(w io.Writer, statement SplitStatement) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "sale_id", "kind", "product", "currency", "gross", "fees", "net", "percent", "share"})
+	for _, line := range statement.Lines {
+		out.Write([]string{line.At.Format(time.RFC3339), line.SaleID, line.Kind, line.Product, strings.ToUpper(line.Gross.Currency),
+			line.Gross.Decimal(), line.Fees.Decimal(), line.Net.Decimal(), strconv.FormatFloat(line.Percent, 'f', -1, 64), line.Share.Decimal()})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeStatementCSVFile(path string, statement SplitStatement) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = writeStatementCSV(file, statement)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Handler serves GET /splits/statements?month=2026-01 with every
+// collaborator's statement, and GET /splits/statements/{collaborator} with
+// one, as CSV with format=csv. The month defaults to the current one.
+func (rs *RevenueSplits) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = rs.bridge.clock.Now().In(rs.Config.location).Format(splitMonth)
+		}
+		statements, err := rs.Statements(month)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/splits/statements"), "/")
+		if name == "" {
+			writeAPIJSON(w, http.StatusOK, map[string]interface{}{"month": month, "statements": statements})
+			return
+		}
+		for _, statement := range statements {
+			if statement.Collaborator != name {
+				continue
+			}
+			if r.URL.Query().Get("format") == "csv" {
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"-"+month+".csv"))
+				if err := writeStatementCSV(w, statement); err != nil {
+					log.Printf("❌ Error writing %s's statement: %v", name, err)
+				}
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, statement)
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "no collaborator "+name)
+	})
+}
+
+// RegisterSplitJobs adds the split_statements job handler, which issues the
+// statements of the month that last ended
+func RegisterSplitJobs(s *Scheduler, splits *RevenueSplits) {
+	s.Handle("split_statements", func(job Job) error {
+		return splits.IssueDue()
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRevenueSplits(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 2, 1, 6, 0, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var mu sync.Mutex
+	var emails []string
+	sink := gb.AddSink("email", SinkConfig{}, func(message *UniversalMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		emails = append(emails, stringArg(message.Payload, "to")+": "+strings.SplitN(stringArg(message.Payload, "text"), "\n", 2)[0])
+		return nil
+	})
+
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []struct {
+		id, product string
+		price       float64
+		at          string
+	}{
+		{"s1", "course", 100, "2026-01-10T12:00:00Z"},
+		{"s2", "templates", 20, "2026-01-20T12:00:00Z"},
+		{"s3", "course", 50, "2026-02-01T04:30:00Z"}, // January 31 in New York
+		{"s4", "ebook", 15, "2026-01-12T12:00:00Z"},  // not split
+		{"s5", "course", 100, "2026-02-03T12:00:00Z"},
+	} {
+		_, err := sales.Record(map[string]interface{}{"sale_id": sale.id, "product_id": sale.product, "price": sale.price,
+			"currency": "usd", "email": sale.id + "@example.com", "sale_timestamp": sale.at})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	refunds := []Refund{
+		{SaleID: "s1", Amount: NewMoney(10000, "usd"), RefundedAt: time.Date(2026, 2, 5, 12, 0, 0, 0, time.UTC)},
+		{SaleID: "s3", Amount: NewMoney(2500, "usd"), RefundedAt: time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, refund := range refunds {
+		if err := sales.Refund(refund); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := LoadSplitConfig("splits.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	splits := NewRevenueSplits(gb, sales, config, dir)
+
+	usd := func(cents ...int64) []Money {
+		list := make([]Money, len(cents))
+		for i, amount := range cents {
+			list[i] = NewMoney(amount, "usd")
+		}
+		return list
+	}
+	tests := []struct {
+		month        string
+		collaborator string
+		sales        int
+		refunds      int
+		share        []Money
+	}{
+		// Course nets 89.50 of 100 and 44.50 of 50 after 10% and 0.50;
+		// templates 19.00 of 20 after their own 5%
+		{"2026-01", "ana", 3, 0, usd(2685 + 950 + 1335)},
+		{"2026-01", "me", 3, 0, usd(6265 + 950 + 3115)},
+		// Refunds take back the refunded part of the net
+		{"2026-02", "ana", 1, 2, usd(2685 - 2685 - 668)},
+		{"2026-02", "me", 1, 2, usd(6265 - 6265 - 1557)},
+		{"2026-03", "me", 0, 0, usd()},
+	}
+	for _, tt := range tests {
+		statements, err := splits.Statements(tt.month)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, statement := range statements {
+			if statement.Collaborator != tt.collaborator {
+				continue
+			}
+			if statement.Sales != tt.sales || statement.Refunds != tt.refunds || !reflect.DeepEqual(statement.Share, tt.share) {
+				t.Errorf("%s %s: %d sales, %d refunds, share %v; want %d, %d, %v", tt.month, tt.collaborator,
+					statement.Sales, statement.Refunds, statement.Share, tt.sales, tt.refunds, tt.share)
+			}
+		}
+	}
+	if _, err := splits.Statements("January"); err == nil {
+		t.Error("made statements for a month that is not like 2026-01")
+	}
+
+	// The first of February in New York closes January, once
+	for i := 0; i < 2; i++ {
+		if err := splits.IssueDue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Drain(time.Second)
+	if want := []string{"ana@example.com: Subject: Revenue statement for 2026-01"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("emailed %q, want %q", emails, want)
+	}
+	for _, name := range []string{"ana.json", "ana.csv", "me.json", "me.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, "2026-01", name)); err != nil {
+			t.Errorf("statement %s: %v", name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+		contains     string
+	}{
+		{http.MethodGet, "/splits/statements?month=2026-01", http.StatusOK, `"collaborator":"ana"`},
+		{http.MethodGet, "/splits/statements/ana?month=2026-01&format=csv", http.StatusOK, "2026-01-10T12:00:00Z,s1,sale,course,USD,100.00,10.50,89.50,30,26.85"},
+		{http.MethodGet, "/splits/statements/me", http.StatusOK, `"month":"2026-02"`},
+		{http.MethodGet, "/splits/statements/bob", http.StatusNotFound, "bob"},
+		{http.MethodGet, "/splits/statements?month=soon", http.StatusBadRequest, "month"},
+		{http.MethodPost, "/splits/statements", http.StatusMethodNotAllowed, "GET"},
+	} {
+		recorder := httptest.NewRecorder()
+		splits.Handler().ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+		if recorder.Code != test.status || !strings.Contains(recorder.Body.String(), test.contains) {
+			t.Errorf("%s %s answered %d, want %d with %q: %s", test.method, test.path, recorder.Code, test.status, test.contains, recorder.Body)
+		}
+	}
+}
+
+func TestInvalidSplitConfig(t *testing.T) {
+	collaborators := map[string]SplitCollaborator{"me": {}, "ana": {}}
+	tests := []struct {
+		name   string
+		config SplitConfig
+	}{
+		{"no products", SplitConfig{Collaborators: collaborators}},
+		{"timezone", SplitConfig{Timezone: "Mars/Olympus", Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 100}}}}},
+		{"shares under 100", SplitConfig{Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 70, "ana": 20}}}}},
+		{"unknown collaborator", SplitConfig{Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 70, "bob": 30}}}}},
+		{"negative share", SplitConfig{Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 110, "ana": -10}}}}},
+		{"fee percent", SplitConfig{Fees: SplitFees{Percent: 110}, Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 100}}}}},
+		{"fixed fee", SplitConfig{Fees: SplitFees{Fixed: map[string]string{"usd": "fifty cents"}}, Collaborators: collaborators, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"me": 100}}}}},
+		{"collaborator name", SplitConfig{Collaborators: map[string]SplitCollaborator{"Ana Ruiz": {}}, Products: map[string]SplitProduct{"course": {Shares: map[string]float64{"Ana Ruiz": 100}}}}},
+	}
+	for _, test := range tests {
+		if err := test.config.Validate(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
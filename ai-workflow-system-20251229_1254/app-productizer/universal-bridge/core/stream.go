@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// StreamChunk carries part of a streamed reply. Its payload names the
+// request in original_message_id, numbers the chunk from 0 in seq and holds
+// the text in delta; the last chunk has done set, and error when the stream
+// failed part way.
+const StreamChunk MessageType = "stream_chunk"
+
+// maxStreamChunks bounds how far ahead of the next expected chunk one may
+// be numbered, so a corrupt seq cannot make a stream buffer without limit
+const maxStreamChunks = 100000
+
+// ErrStreamIncomplete is returned when a stream ends with chunks missing
+var ErrStreamIncomplete = errors.New("stream ended with chunks missing")
+
+// AIChunk is one piece of a streamed reply, in order. The last has Done
+// set; Err is set on it when the stream failed.
+type AIChunk struct {
+	Seq   int
+	Delta string
+	Done  bool
+	Err   error
+}
+
+// AIStream is a streamed AI reply being reassembled. Chunks may arrive in
+// any order, as parallel dispatch and shared directories deliver them; they
+// are handed out in seq order.
+type AIStream struct {
+	// ID is the request's message ID
+	ID string
+
+	bridge *GoBridge
+
+	mu       sync.Mutex
+	next     int             // seq of the next chunk in order
+	early    map[int]AIChunk // chunks that arrived ahead of next
+	last     int             // seq of the done chunk, or -1 until it arrives
+	ordered  []AIChunk       // chunks in order not yet read from Chunks
+	text     strings.Builder
+	err      error
+	finished bool
+	chunks   chan AIChunk
+	wake     chan struct{}
+	complete chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// streamRegistry routes received chunks to the streams waiting for them
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*AIStream // request ID → stream
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: make(map[string]*AIStream)}
+}
+
+func (sr *streamRegistry) add(stream *AIStream) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.streams[stream.ID] = stream
+}
+
+func (sr *streamRegistry) get(id string) *AIStream {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.streams[id]
+}
+
+func (sr *streamRegistry) remove(id string) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	delete(sr.streams, id)
+}
+
+// handleChunk is the StreamChunk handler
+func (sr *streamRegistry) handleChunk(message *UniversalMessage) error {
+	stream := sr.get(stringArg(message.Payload, "original_message_id"))
+	if stream == nil {
+		fmt.Printf("⚠️ Chunk %s is for no open stream\n", message.ID)
+		return nil
+	}
+	seq, ok := toNumber(message.Payload["seq"])
+	if !ok || seq < 0 || seq != float64(int(seq)) {
+		return fmt.Errorf("chunk %s has a bad seq %v", message.ID, message.Payload["seq"])
+	}
+	chunk := AIChunk{
+		Seq:   int(seq),
+		Delta: stringArg(message.Payload, "delta"),
+		Done:  flagArg(message.Payload, "done"),
+	}
+	if text := stringArg(message.Payload, "error"); text != "" && chunk.Done {
+		chunk.Err = errors.New(text)
+	}
+	stream.add(chunk)
+	return nil
+}
+
+// observe ends a stream whose peer answered with a whole reply instead,
+// as bridges that do not stream do, or whose request failed or timed out
+func (sr *streamRegistry) observe(message *UniversalMessage) {
+	if message.MessageType != AIResponse && message.MessageType != Error {
+		return
+	}
+	stream := sr.get(stringArg(message.Payload, "original_message_id"))
+	if stream == nil {
+		return
+	}
+	if message.MessageType == Error {
+		stream.fail(fmt.Errorf("%s", stringArg(message.Payload, "error")))
+		return
+	}
+	text := stringArg(message.Payload, "content")
+	if text == "" {
+		text = stringArg(message.Payload, "ai_result")
+	}
+	stream.add(AIChunk{Seq: 0, Delta: text, Done: true})
+}
+
+// RequestAIStream sends an AI request asking for the reply to be streamed.
+// Chunks are read from the stream as they arrive; Wait returns the whole
+// text. With a PendingRequests tracker, each chunk gives the request
+// another Timeout, so a stream fails once it goes quiet for that long.
+func (gb *GoBridge) RequestAIStream(prompt, instructions string, context map[string]interface{}) (*AIStream, error) {
+	if context == nil {
+		context = make(map[string]interface{})
+	}
+	payload := map[string]interface{}{
+		"action":       "generate_content",
+		"prompt":       prompt,
+		"instructions": instructions,
+		"context":      context,
+		"stream":       true,
+	}
+	message := gb.NewMessage(AIRequest, "universal", payload, FileSystem)
+
+	stream := &AIStream{
+		ID:       message.ID,
+		bridge:   gb,
+		early:    make(map[int]AIChunk),
+		last:     -1,
+		wake:     make(chan struct{}, 1),
+		complete: make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	// Registered before sending, so a quick first chunk is not missed
+	gb.streams.add(stream)
+	_, err := gb.SendMessage(message)
+	if err != nil {
+		gb.streams.remove(stream.ID)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// add takes a received chunk, releasing it and any that were waiting on it
+func (s *AIStream) add(chunk AIChunk) {
+	s.mu.Lock()
+	if s.finished || chunk.Seq < s.next || (s.last >= 0 && chunk.Seq > s.last) {
+		// Already finished, a redelivery, or numbered past the end
+		s.mu.Unlock()
+		return
+	}
+	if chunk.Seq-s.next > maxStreamChunks {
+		s.mu.Unlock()
+		s.fail(fmt.Errorf("chunk %d is too far ahead of %d", chunk.Seq, s.next))
+		return
+	}
+	s.early[chunk.Seq] = chunk
+	if chunk.Done {
+		s.last = chunk.Seq
+		for seq := range s.early {
+			if seq > s.last {
+				delete(s.early, seq)
+			}
+		}
+	}
+	for {
+		next, ok := s.early[s.next]
+		if !ok {
+			break
+		}
+		delete(s.early, s.next)
+		s.next++
+		s.text.WriteString(next.Delta)
+		s.ordered = append(s.ordered, next)
+		if next.Done {
+			s.err = next.Err
+			s.finishLocked()
+			break
+		}
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// fail ends the stream with err unless it already finished. A stream whose
+// done chunk arrived is failed as ErrStreamIncomplete, since it was only
+// waiting on missing chunks.
+func (s *AIStream) fail(err error) {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	if s.last >= 0 {
+		err = fmt.Errorf("%w: got %d of %d: %v", ErrStreamIncomplete, s.next, s.last+1, err)
+	}
+	s.err = err
+	s.ordered = append(s.ordered, AIChunk{Seq: s.next, Done: true, Err: err})
+	s.finishLocked()
+	s.mu.Unlock()
+	s.notify()
+}
+
+// finishLocked marks the stream done, stops routing chunks to it and stops
+// the request's timeout
+func (s *AIStream) finishLocked() {
+	s.finished = true
+	close(s.complete)
+	s.bridge.streams.remove(s.ID)
+
+	s.bridge.mu.RLock()
+	pending := s.bridge.pending
+	s.bridge.mu.RUnlock()
+	if pending != nil {
+		pending.forget(s.ID)
+	}
+}
+
+func (s *AIStream) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Chunks returns a channel that receives the chunks in order as they
+// arrive, and is closed after the last. Reading is optional; Wait works
+// either way. A reader that stops early calls Stop, so the goroutine
+// feeding the channel exits.
+func (s *AIStream) Chunks() <-chan AIChunk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chunks != nil {
+		return s.chunks
+	}
+	s.chunks = make(chan AIChunk)
+	go func() {
+		defer close(s.chunks)
+		for {
+			s.mu.Lock()
+			ready := s.ordered
+			s.ordered = nil
+			finished := s.finished
+			s.mu.Unlock()
+
+			for _, chunk := range ready {
+				select {
+				case s.chunks <- chunk:
+				case <-s.stop:
+					return
+				}
+			}
+			if len(ready) == 0 && finished {
+				return
+			}
+			if len(ready) == 0 {
+				select {
+				case <-s.wake:
+				case <-s.stop:
+					return
+				}
+			}
+		}
+	}()
+	return s.chunks
+}
+
+// Wait blocks until the stream finishes and returns the reassembled text,
+// with the error that ended it, if any
+func (s *AIStream) Wait() (string, error) {
+	<-s.complete
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.text.String(), s.err
+}
+
+// Stop closes the Chunks channel early, for a reader that no longer wants
+// the chunks. The stream is still reassembled for Wait.
+func (s *AIStream) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Done returns a channel closed when the stream finishes
+func (s *AIStream) Done() <-chan struct{} {
+	return s.complete
+}
+
+// StreamWriter sends a reply to a request as a stream of chunks, for Go
+// handlers answering a peer's streamed AI request
+type StreamWriter struct {
+	bridge  *GoBridge
+	request *UniversalMessage
+
+	mu     sync.Mutex
+	seq    int
+	closed bool
+}
+
+// StreamReply starts a streamed reply to the request
+func (gb *GoBridge) StreamReply(request *UniversalMessage) *StreamWriter {
+	return &StreamWriter{bridge: gb, request: request}
+}
+
+// Write sends the next chunk of text
+func (sw *StreamWriter) Write(delta string) error {
+	return sw.send(delta, false, nil)
+}
+
+// Close sends the last chunk, with a final piece of text that may be empty
+func (sw *StreamWriter) Close(delta string) error {
+	return sw.send(delta, true, nil)
+}
+
+// Fail ends the stream with an error the receiver's Wait returns
+func (sw *StreamWriter) Fail(err error) error {
+	return sw.send("", true, err)
+}
+
+func (sw *StreamWriter) send(delta string, done bool, streamErr error) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed {
+		return fmt.Errorf("stream reply to %s already closed", sw.request.ID)
+	}
+	payload := map[string]interface{}{
+		"seq":   sw.seq,
+		"delta": delta,
+		"done":  done,
+	}
+	if streamErr != nil {
+		payload["error"] = streamErr.Error()
+	}
+	_, err := sw.bridge.Reply(sw.request, StreamChunk, payload)
+	if err != nil {
+		return err
+	}
+	sw.seq++
+	sw.closed = done
+	return nil
+}
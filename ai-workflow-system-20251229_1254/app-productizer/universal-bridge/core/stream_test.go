@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newStreamBridge returns a bridge over a MemoryTransport with a pending
+// request tracker, for stream tests
+func newStreamBridge(t *testing.T) (*GoBridge, *ManualClock, *MemoryTransport, *PendingRequests) {
+	t.Helper()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("stream")))
+	t.Cleanup(func() { gb.Close() })
+	return gb, clock, transport, NewPendingRequests(gb, 10*time.Second)
+}
+
+// sendChunk delivers a chunk of the stream's reply as if the peer sent it
+func sendChunk(t *testing.T, gb *GoBridge, id string, seq int, delta string, done bool) {
+	t.Helper()
+	chunk := gb.NewMessage(StreamChunk, "go", map[string]interface{}{
+		"original_message_id": id,
+		"seq":                 seq,
+		"delta":               delta,
+		"done":                done,
+	}, FileSystem)
+	if err := gb.handleIncomingMessage(chunk); err != nil {
+		t.Fatalf("handling chunk %d: %v", seq, err)
+	}
+}
+
+// waitStream returns the stream's result, failing the test if it never ends
+func waitStream(t *testing.T, stream *AIStream) (string, error) {
+	t.Helper()
+	select {
+	case <-stream.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream %s never finished", stream.ID)
+	}
+	return stream.Wait()
+}
+
+func TestAIStreamReassembly(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []AIChunk // as delivered
+		want   string
+	}{
+		{"in order", []AIChunk{{0, "A", false, nil}, {1, "B", false, nil}, {2, "C", true, nil}}, "ABC"},
+		{"reversed", []AIChunk{{2, "C", true, nil}, {1, "B", false, nil}, {0, "A", false, nil}}, "ABC"},
+		{"redelivered", []AIChunk{{0, "A", false, nil}, {0, "A", false, nil}, {1, "B", true, nil}, {1, "B", true, nil}}, "AB"},
+		{"past the end", []AIChunk{{1, "B", true, nil}, {2, "X", false, nil}, {0, "A", false, nil}}, "AB"},
+		{"single", []AIChunk{{0, "whole", true, nil}}, "whole"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gb, _, _, pending := newStreamBridge(t)
+			stream, err := gb.RequestAIStream("prompt", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks := stream.Chunks()
+			for _, chunk := range tt.chunks {
+				sendChunk(t, gb, stream.ID, chunk.Seq, chunk.Delta, chunk.Done)
+			}
+
+			var got string
+			next := 0
+			for chunk := range chunks {
+				if chunk.Seq != next {
+					t.Fatalf("chunk %d read where %d was next", chunk.Seq, next)
+				}
+				next++
+				got += chunk.Delta
+			}
+			if got != tt.want {
+				t.Fatalf("chunks read %q, want %q", got, tt.want)
+			}
+			text, err := waitStream(t, stream)
+			if text != tt.want || err != nil {
+				t.Fatalf("Wait = %q, %v; want %q", text, err, tt.want)
+			}
+			if n := len(pending.Pending()); n != 0 {
+				t.Fatalf("%d requests still pending after the stream finished", n)
+			}
+		})
+	}
+}
+
+func TestAIStreamTimeouts(t *testing.T) {
+	t.Run("chunks extend the deadline", func(t *testing.T) {
+		gb, clock, _, pending := newStreamBridge(t)
+		stream, _ := gb.RequestAIStream("prompt", "", nil)
+		sendChunk(t, gb, stream.ID, 0, "A", false)
+		clock.Advance(8 * time.Second)
+		sendChunk(t, gb, stream.ID, 1, "B", false)
+		clock.Advance(8 * time.Second)
+		if n := pending.Sweep(); n != 0 {
+			t.Fatalf("Sweep timed out %d requests still streaming", n)
+		}
+		sendChunk(t, gb, stream.ID, 2, "C", true)
+		if text, err := waitStream(t, stream); text != "ABC" || err != nil {
+			t.Fatalf("Wait = %q, %v", text, err)
+		}
+	})
+
+	t.Run("quiet stream fails", func(t *testing.T) {
+		gb, clock, _, pending := newStreamBridge(t)
+		stream, _ := gb.RequestAIStream("prompt", "", nil)
+		sendChunk(t, gb, stream.ID, 0, "A", false)
+		clock.Advance(11 * time.Second)
+		pending.Sweep()
+		text, err := waitStream(t, stream)
+		if err == nil || errors.Is(err, ErrStreamIncomplete) {
+			t.Fatalf("Wait = %q, %v; want a timeout error", text, err)
+		}
+		if text != "A" {
+			t.Fatalf("Wait text = %q, want the chunks before the timeout", text)
+		}
+	})
+
+	t.Run("done with a gap fails incomplete", func(t *testing.T) {
+		gb, clock, _, pending := newStreamBridge(t)
+		stream, _ := gb.RequestAIStream("prompt", "", nil)
+		sendChunk(t, gb, stream.ID, 0, "A", false)
+		sendChunk(t, gb, stream.ID, 2, "C", true)
+		clock.Advance(11 * time.Second)
+		if n := pending.Sweep(); n != 1 {
+			t.Fatalf("Sweep timed out %d requests, want 1", n)
+		}
+		if _, err := waitStream(t, stream); !errors.Is(err, ErrStreamIncomplete) {
+			t.Fatalf("Wait error = %v, want ErrStreamIncomplete", err)
+		}
+	})
+}
+
+func TestAIStreamWholeReply(t *testing.T) {
+	gb, _, _, _ := newStreamBridge(t)
+	stream, _ := gb.RequestAIStream("prompt", "", nil)
+	reply := gb.NewMessage(AIResponse, "go", map[string]interface{}{
+		"original_message_id": stream.ID,
+		"ai_result":           "whole",
+	}, FileSystem)
+	if err := gb.handleIncomingMessage(reply); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := waitStream(t, stream); text != "whole" || err != nil {
+		t.Fatalf("Wait = %q, %v; want the whole reply", text, err)
+	}
+}
+
+func TestAIStreamStop(t *testing.T) {
+	gb, _, _, _ := newStreamBridge(t)
+	stream, _ := gb.RequestAIStream("prompt", "", nil)
+	chunks := stream.Chunks()
+	sendChunk(t, gb, stream.ID, 0, "A", false)
+	sendChunk(t, gb, stream.ID, 1, "B", false)
+	<-chunks
+	stream.Stop()
+
+	// The feeding goroutine exits and closes the channel without B being read
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-chunks:
+			if !ok {
+				sendChunk(t, gb, stream.ID, 2, "C", true)
+				if text, err := waitStream(t, stream); text != "ABC" || err != nil {
+					t.Fatalf("Wait after Stop = %q, %v", text, err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("Chunks channel not closed after Stop")
+		}
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	gb, _, transport, _ := newStreamBridge(t)
+	request := gb.NewMessage(AIRequest, "python", map[string]interface{}{"stream": true}, FileSystem)
+	writer := gb.StreamReply(request)
+	if err := writer.Write("Hello, "); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Fail(errors.New("model overloaded")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write("more"); err == nil {
+		t.Fatal("Write after Fail succeeded")
+	}
+
+	sent := transport.SentOfType(StreamChunk)
+	if len(sent) != 2 {
+		t.Fatalf("sent %d chunks, want 2", len(sent))
+	}
+	for i, chunk := range sent {
+		seq, _ := toNumber(chunk.Payload["seq"])
+		if int(seq) != i || stringArg(chunk.Payload, "original_message_id") != request.ID {
+			t.Fatalf("chunk %d payload %v", i, chunk.Payload)
+		}
+	}
+	last := sent[1].Payload
+	if !flagArg(last, "done") || stringArg(last, "error") != "model overloaded" {
+		t.Fatalf("last chunk payload %v", last)
+	}
+}
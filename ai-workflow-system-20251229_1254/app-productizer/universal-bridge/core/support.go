@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	imapUsernameEnv = "IMAP_USERNAME"
+	imapPasswordEnv = "IMAP_PASSWORD"
+	imapTimeout     = time.Minute
+	maxSupportEmail = 25 << 20 // largest email fetched, attachments included
+	maxSupportText  = 64 << 10 // longest body text put in a ticket
+)
+
+// SupportInbox polls an IMAP mailbox for customer emails and delivers each
+// one into the bridge as a data_sync message with resource_name
+// support_message, carrying the sender's purchases from the sales store.
+// Customers records it on the buyer's profile, and sinks and peers such as
+// the reply drafter see it like any other event. Emails are marked seen
+// once delivered, so one that fails is picked up again by the next poll;
+// its message ID comes from its Message-ID, so a redelivery is dropped.
+type SupportInbox struct {
+	// Addr is the IMAP server, as host:port; it is dialed over TLS
+	Addr     string
+	Username string
+	Password string
+	// Mailbox is the folder polled; defaults to INBOX
+	Mailbox string
+	// BatchSize bounds how many unseen emails one poll takes
+	BatchSize int
+
+	bridge *GoBridge
+	sales  *SalesStore
+	dial   func() (net.Conn, error)
+}
+
+// NewSupportInbox creates an inbox polling the IMAP server at addr
+func NewSupportInbox(gb *GoBridge, sales *SalesStore, addr, username, password string) (*SupportInbox, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("IMAP server %q must be host:port: %v", addr, err)
+	}
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("set %s and %s to log in to %s", imapUsernameEnv, imapPasswordEnv, addr)
+	}
+	si := &SupportInbox{
+		Addr:      addr,
+		Username:  username,
+		Password:  password,
+		Mailbox:   "INBOX",
+		BatchSize: 50,
+		bridge:    gb,
+		sales:     sales,
+	}
+	si.dial = func() (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		return tls.DialWithDialer(dialer, "tcp", si.Addr, &tls.Config{ServerName: host})
+	}
+	return si, nil
+}
+
+// RegisterSupportJobs registers the "support_inbox" job, which polls the inbox
+func RegisterSupportJobs(s *Scheduler, inbox *SupportInbox) {
+	s.Handle("support_inbox", func(job Job) error {
+		_, err := inbox.Poll()
+		return err
+	})
+}
+
+// Poll delivers the mailbox's unseen emails, returning how many became tickets
+func (si *SupportInbox) Poll() (int, error) {
+	conn, err := si.dial()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %v", si.Addr, err)
+	}
+	client := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	defer client.close()
+
+	greeting, err := client.read()
+	if err == nil && !strings.HasPrefix(greeting.text, "* OK") {
+		err = fmt.Errorf("unexpected greeting %q", greeting.text)
+	}
+	if err == nil {
+		_, err = client.command("LOGIN " + imapQuote(si.Username) + " " + imapQuote(si.Password))
+	}
+	if err == nil {
+		_, err = client.command("SELECT " + imapQuote(si.Mailbox))
+	}
+	var uids []string
+	if err == nil {
+		uids, err = client.searchUnseen()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("IMAP %s: %v", si.Addr, err)
+	}
+	if len(uids) > si.BatchSize {
+		uids = uids[:si.BatchSize]
+	}
+
+	tickets := 0
+	for _, uid := range uids {
+		raw, err := client.fetch(uid)
+		if err != nil {
+			return tickets, fmt.Errorf("failed to fetch email %s: %v", uid, err)
+		}
+		message, ok, err := si.ticket(raw)
+		if err != nil {
+			// It will not parse next time either, so it is still marked seen
+			log.Printf("❌ Skipping support email %s: %v", uid, err)
+		}
+		if ok {
+			err = si.bridge.Deliver(message, FileSystem)
+			if err != nil {
+				return tickets, fmt.Errorf("failed to deliver support email %s: %v", uid, err)
+			}
+			tickets++
+		}
+		_, err = client.command("UID STORE " + uid + ` +FLAGS.SILENT (\Seen)`)
+		if err != nil {
+			return tickets, fmt.Errorf("failed to mark email %s seen: %v", uid, err)
+		}
+	}
+	if tickets > 0 {
+		fmt.Printf("📬 %d support emails from %s\n", tickets, si.Mailbox)
+	}
+	return tickets, nil
+}
+
+// ticket turns a raw email into a support_message, or reports false for
+// automatic mail such as out-of-office replies and bounces
+func (si *SupportInbox) ticket(raw []byte) (*UniversalMessage, bool, error) {
+	email, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, false, err
+	}
+	header := email.Header
+	if auto := strings.ToLower(header.Get("Auto-Submitted")); auto != "" && auto != "no" {
+		return nil, false, nil
+	}
+	switch strings.ToLower(header.Get("Precedence")) {
+	case "bulk", "junk", "list":
+		return nil, false, nil
+	}
+	from, err := mail.ParseAddress(header.Get("From"))
+	if err != nil {
+		return nil, false, fmt.Errorf("bad From: %v", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		subject = header.Get("Subject")
+	}
+	text, err := emailText(header, email.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	receivedAt, err := header.Date()
+	if err != nil {
+		receivedAt = si.bridge.clock.Now()
+	}
+
+	address := NormalizeEmail(from.Address)
+	var purchases []interface{}
+	for _, sale := range si.sales.ByEmail(address) {
+		purchases = append(purchases, map[string]interface{}{
+			"sale_id":      sale.ID,
+			"product":      sale.Product,
+			"product_name": sale.ProductName,
+			"refunded":     sale.Refunded,
+		})
+	}
+	messageID := strings.TrimSpace(header.Get("Message-Id"))
+	payload := map[string]interface{}{
+		"resource_name": "support_message",
+		"channel":       "email",
+		"email":         address,
+		"name":          from.Name,
+		"subject":       subject,
+		"text":          text,
+		"message_id":    messageID,
+		"in_reply_to":   strings.TrimSpace(header.Get("In-Reply-To")),
+		"received_at":   receivedAt.UTC().Format(time.RFC3339),
+		"customer":      len(purchases) > 0,
+		"purchases":     purchases,
+	}
+	if messageID == "" {
+		messageID = string(raw)
+	}
+	return newUniversalMessage(si.bridge.clock, supportID(messageID), DataSync, "imap", "go", payload, FileSystem), true, nil
+}
+
+// supportID names a ticket by its email's Message-ID, so an email delivered
+// twice keeps its message ID and the pipeline drops the duplicate
+type supportID string
+
+func (id supportID) NewID() string {
+	hash := sha256.Sum256([]byte(id))
+	return "support-" + hex.EncodeToString(hash[:16])
+}
+
+// emailText returns the first text/plain part of an email body, decoded
+func emailText(header map[string][]string, body io.Reader) (string, error) {
+	contentType := mail.Header(header).Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" || err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(mail.Header(header).Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("bad multipart body: %v", err)
+			}
+			text, err := emailText(part.Header, part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+	text, err := ioutil.ReadAll(io.LimitReader(body, maxSupportText))
+	if err != nil {
+		return "", fmt.Errorf("bad body: %v", err)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(text), "\r\n", "\n")), nil
+}
+
+// imapClient speaks the few IMAP4rev1 commands the inbox needs
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is one server response line, with any literals it carried
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+// command sends a command and returns its untagged responses, failing
+// unless the server answers OK
+func (c *imapClient) command(command string) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	_, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, command)
+	if err != nil {
+		return nil, err
+	}
+
+	var untagged []imapResponse
+	for {
+		response, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(response.text, tag+" ") {
+			status := strings.TrimPrefix(response.text, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("%s: %s", strings.Fields(command)[0], status)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, response)
+	}
+}
+
+// read reads one response, following the literals it announces
+func (c *imapClient) read() (imapResponse, error) {
+	var response imapResponse
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return response, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		response.text += line
+
+		open := strings.LastIndex(line, "{")
+		if open < 0 || !strings.HasSuffix(line, "}") {
+			return response, nil
+		}
+		size, err := strconv.Atoi(line[open+1 : len(line)-1])
+		if err != nil || size < 0 || size > maxSupportEmail {
+			return response, fmt.Errorf("bad literal in %q", line)
+		}
+		literal := make([]byte, size)
+		_, err = io.ReadFull(c.r, literal)
+		if err != nil {
+			return response, err
+		}
+		response.literals = append(response.literals, literal)
+	}
+}
+
+// searchUnseen returns the UIDs of unseen emails, oldest first
+func (c *imapClient) searchUnseen() ([]string, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, response := range responses {
+		if fields := strings.Fields(response.text); len(fields) > 1 && fields[0] == "*" && strings.EqualFold(fields[1], "SEARCH") {
+			uids = append(uids, fields[2:]...)
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns an email's raw message without marking it seen
+func (c *imapClient) fetch(uid string) ([]byte, error) {
+	responses, err := c.command("UID FETCH " + uid + " (BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		if strings.Contains(response.text, " FETCH ") && len(response.literals) > 0 {
+			return response.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("no such email")
+}
+
+func (c *imapClient) close() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
+
+// imapQuote quotes an IMAP string argument
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIMAP serves one mailbox over the commands SupportInbox sends
+type fakeIMAP struct {
+	mu     sync.Mutex
+	emails map[string]string // UID → raw email
+	seen   map[string]bool
+}
+
+func (f *fakeIMAP) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go f.serve(server)
+	return client, nil
+}
+
+func (f *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		tag, command := fields[0], strings.Join(fields[1:], " ")
+
+		f.mu.Lock()
+		switch {
+		case strings.HasPrefix(command, "LOGIN "):
+			if command != `LOGIN "support@example.com" "p\"w"` {
+				fmt.Fprintf(conn, "%s NO bad login %s\r\n", tag, command)
+				f.mu.Unlock()
+				continue
+			}
+		case command == "UID SEARCH UNSEEN":
+			fmt.Fprint(conn, "* SEARCH")
+			for _, uid := range []string{"1", "2", "3"} {
+				if _, ok := f.emails[uid]; ok && !f.seen[uid] {
+					fmt.Fprint(conn, " "+uid)
+				}
+			}
+			fmt.Fprint(conn, "\r\n")
+		case strings.HasPrefix(command, "UID FETCH "):
+			uid := fields[3]
+			fmt.Fprintf(conn, "* %s FETCH (UID %s BODY[] {%d}\r\n%s)\r\n", uid, uid, len(f.emails[uid]), f.emails[uid])
+		case strings.HasPrefix(command, "UID STORE "):
+			f.seen[fields[3]] = true
+		}
+		f.mu.Unlock()
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		if command == "LOGOUT" {
+			return
+		}
+	}
+}
+
+func TestSupportInboxPoll(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var tickets []map[string]interface{}
+	gb.OnMessage(DataSync, func(message *UniversalMessage) error {
+		tickets = append(tickets, message.Payload)
+		return nil
+	})
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sales.Record(map[string]interface{}{"sale_id": "s1", "product_id": "ebook", "product_name": "The Automation Handbook", "email": "ada@example.com", "price": 29}); err != nil {
+		t.Fatal(err)
+	}
+
+	imap := &fakeIMAP{
+		emails: map[string]string{
+			"1": "From: Ada <Ada@Example.com>\r\nSubject: =?utf-8?q?Download_l=C3=A4uft_nicht?=\r\nMessage-ID: <a1@example.com>\r\n" +
+				"Date: Thu, 15 Jan 2026 09:00:00 +0000\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+				"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nThe download link gives me a 40=\r\n4.\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>The download link gives me a 404.</p>\r\n--b--\r\n",
+			"2": "From: Bo <bo@example.com>\r\nSubject: Out of office\r\nAuto-Submitted: auto-replied\r\n\r\nBack Monday.\r\n",
+			"3": "From: Cy <cy@example.com>\r\nSubject: Pre-sale question\r\nMessage-ID: <c3@example.com>\r\n\r\nDoes it cover webhooks?\r\n",
+		},
+		seen: make(map[string]bool),
+	}
+	inbox, err := NewSupportInbox(gb, sales, "imap.example.com:993", "support@example.com", `p"w`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox.dial = imap.dial
+
+	count, err := inbox.Poll()
+	if err != nil || count != 2 {
+		t.Fatalf("Poll = %d, %v, want 2 tickets", count, err)
+	}
+	if len(imap.seen) != 3 {
+		t.Fatalf("seen %v, want all three emails marked", imap.seen)
+	}
+	if len(tickets) != 2 {
+		t.Fatalf("tickets %v", tickets)
+	}
+	ada, cy := tickets[0], tickets[1]
+	if stringArg(ada, "email") != "ada@example.com" || stringArg(ada, "subject") != "Download läuft nicht" ||
+		stringArg(ada, "text") != "The download link gives me a 404." || stringArg(ada, "received_at") != "2026-01-15T09:00:00Z" {
+		t.Fatalf("ticket %v", ada)
+	}
+	purchases, _ := ada["purchases"].([]interface{})
+	if ada["customer"] != true || len(purchases) != 1 || stringArg(purchases[0].(map[string]interface{}), "sale_id") != "s1" {
+		t.Fatalf("ticket purchases %v", ada)
+	}
+	if cy["customer"] != false || stringArg(cy, "resource_name") != "support_message" {
+		t.Fatalf("ticket %v", cy)
+	}
+
+	count, err = inbox.Poll()
+	if err != nil || count != 0 {
+		t.Fatalf("second Poll = %d, %v, want nothing new", count, err)
+	}
+}
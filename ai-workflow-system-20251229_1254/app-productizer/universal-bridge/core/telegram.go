@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	telegramAPIURL   = "https://api.telegram.org"
+	telegramTokenEnv = "TELEGRAM_BOT_TOKEN"
+	// telegramPollTimeout is how long a getUpdates call waits for a click
+	telegramPollTimeout = 30 * time.Second
+)
+
+// telegramUpdate is the part of a getUpdates update the approver reads
+type telegramUpdate struct {
+	UpdateID      int64 `json:"update_id"`
+	CallbackQuery *struct {
+		ID   string `json:"id"`
+		Data string `json:"data"`
+		From struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+		} `json:"from"`
+		Message *struct {
+			MessageID int64  `json:"message_id"`
+			Text      string `json:"text"`
+			Chat      struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+	} `json:"callback_query"`
+}
+
+// TelegramApprover posts every approval request to a Telegram chat with
+// Approve and Reject buttons, and decides the approval when one is clicked.
+// Clicks are read by long polling getUpdates, so the bridge needs no public
+// address, and only clicks in the chat count: anyone who can post there can
+// approve.
+type TelegramApprover struct {
+	Token  string
+	ChatID string
+	// APIURL defaults to the public Bot API
+	APIURL     string
+	HTTPClient *http.Client
+
+	approvals *Approvals
+	clock     Clock
+	offset    int64 // the next update to fetch
+
+	running bool
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewTelegramApprover asks the chat about every request of the approvals,
+// as the bot with the token
+func NewTelegramApprover(a *Approvals, token, chatID string) (*TelegramApprover, error) {
+	if token == "" {
+		return nil, fmt.Errorf("Telegram needs %s", telegramTokenEnv)
+	}
+	if chatID == "" {
+		return nil, fmt.Errorf("Telegram needs a chat to ask")
+	}
+	ta := &TelegramApprover{
+		Token:      token,
+		ChatID:     chatID,
+		APIURL:     telegramAPIURL,
+		HTTPClient: &http.Client{Timeout: telegramPollTimeout + 30*time.Second},
+		approvals:  a,
+		clock:      a.bridge.clock,
+		done:       make(chan struct{}),
+	}
+	a.OnRequest(ta.ask)
+	return ta, nil
+}
+
+// ask posts an approval request to the chat
+func (ta *TelegramApprover) ask(approval Approval) {
+	buttons := [][]map[string]string{{
+		{"text": "✅ Approve", "callback_data": "approve:" + approval.ID},
+		{"text": "🚫 Reject", "callback_data": "reject:" + approval.ID},
+	}}
+	if approval.Link != "" {
+		buttons = append(buttons, []map[string]string{{"text": "Open dashboard", "url": approval.Link}})
+	}
+	err := ta.call(context.Background(), "sendMessage", map[string]interface{}{
+		"chat_id":      ta.ChatID,
+		"text":         "⏸️ Waiting for approval: " + approval.Summary,
+		"reply_markup": map[string]interface{}{"inline_keyboard": buttons},
+	}, nil)
+	if err != nil {
+		log.Printf("❌ Error asking Telegram about approval %s: %v", approval.ID, err)
+	}
+}
+
+// poll fetches the clicks waiting, or waits for one, and decides their
+// approvals
+func (ta *TelegramApprover) poll(ctx context.Context) error {
+	var updates []telegramUpdate
+	err := ta.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          ta.offset,
+		"timeout":         int(telegramPollTimeout / time.Second),
+		"allowed_updates": []string{"callback_query"},
+	}, &updates)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if update.UpdateID >= ta.offset {
+			ta.offset = update.UpdateID + 1
+		}
+		ta.handleUpdate(ctx, update)
+	}
+	return nil
+}
+
+// handleUpdate decides the approval a button click is for
+func (ta *TelegramApprover) handleUpdate(ctx context.Context, update telegramUpdate) {
+	click := update.CallbackQuery
+	if click == nil {
+		return
+	}
+	if click.Message == nil || strconv.FormatInt(click.Message.Chat.ID, 10) != ta.ChatID {
+		ta.answer(ctx, click.ID, "This chat does not approve anything")
+		return
+	}
+	verb, id, _ := strings.Cut(click.Data, ":")
+	by := "Telegram user " + strconv.FormatInt(click.From.ID, 10)
+	if click.From.Username != "" {
+		by = "@" + click.From.Username
+	}
+
+	var err error
+	var outcome string
+	switch verb {
+	case "approve":
+		_, err = ta.approvals.Approve(id, by)
+		outcome = "✅ Approved by " + by
+	case "reject":
+		_, err = ta.approvals.Reject(id, by)
+		outcome = "🚫 Rejected by " + by
+	default:
+		ta.answer(ctx, click.ID, "Unknown button")
+		return
+	}
+	if err != nil {
+		// A failed action stays pending, with its buttons, to be tried again
+		ta.answer(ctx, click.ID, err.Error())
+		return
+	}
+	ta.answer(ctx, click.ID, outcome)
+	err = ta.call(ctx, "editMessageText", map[string]interface{}{
+		"chat_id":    ta.ChatID,
+		"message_id": click.Message.MessageID,
+		"text":       click.Message.Text + "\n" + outcome,
+	}, nil)
+	if err != nil {
+		log.Printf("❌ Error updating Telegram about approval %s: %v", id, err)
+	}
+}
+
+// answer shows text on the clicker's screen, as Telegram expects every
+// click to be answered
+func (ta *TelegramApprover) answer(ctx context.Context, clickID, text string) {
+	if runes := []rune(text); len(runes) > 200 {
+		text = string(runes[:197]) + "..."
+	}
+	err := ta.call(ctx, "answerCallbackQuery", map[string]interface{}{"callback_query_id": clickID, "text": text}, nil)
+	if err != nil {
+		log.Printf("❌ Error answering Telegram click: %v", err)
+	}
+}
+
+// call makes a Bot API request, decoding its result into result if not nil
+func (ta *TelegramApprover) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(ta.APIURL, "/") + "/bot" + ta.Token + "/" + method
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := ta.HTTPClient.Do(request)
+	if err != nil {
+		// The error names the URL, which holds the token
+		return fmt.Errorf("Telegram %s failed: %v", method, strings.ReplaceAll(err.Error(), ta.Token, "<token>"))
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("Telegram %s failed: %v", method, err)
+	}
+	var answer struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	err = json.Unmarshal(content, &answer)
+	if err != nil || !answer.OK {
+		return fmt.Errorf("Telegram %s answered %s: %s", method, response.Status, answer.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(answer.Result, result)
+	}
+	return nil
+}
+
+// Start reads button clicks until Close, backing off after errors
+func (ta *TelegramApprover) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ta.mu.Lock()
+	ta.running = true
+	ta.cancel = cancel
+	ta.mu.Unlock()
+
+	go func() {
+		defer close(ta.done)
+		for ctx.Err() == nil {
+			err := ta.poll(ctx)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+			log.Printf("❌ Error reading Telegram approvals: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-ta.clock.After(10 * time.Second):
+			}
+		}
+	}()
+	fmt.Printf("📨 Asking Telegram chat %s for approvals\n", ta.ChatID)
+}
+
+// Close stops reading clicks
+func (ta *TelegramApprover) Close() {
+	ta.mu.Lock()
+	running, cancel := ta.running, ta.cancel
+	ta.mu.Unlock()
+	if running {
+		cancel()
+		<-ta.done
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTelegramApprover(t *testing.T) {
+	gb := NewGoBridge("", WithClock(NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	approvals, err := NewApprovals(gb, filepath.Join(t.TempDir(), "pending.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	approvals.DashboardURL = "https://admin.example.com/dashboard/"
+	var ran []string
+	approvals.Handle("license", func(args map[string]interface{}) error {
+		ran = append(ran, stringArg(args, "email"))
+		return nil
+	})
+
+	var mu sync.Mutex
+	calls := map[string][]map[string]interface{}{}
+	var updates []string // JSON of the updates the next getUpdates returns
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+		method := strings.TrimPrefix(r.URL.Path, "/botTOKEN/")
+		mu.Lock()
+		defer mu.Unlock()
+		calls[method] = append(calls[method], params)
+		result := "true"
+		if method == "getUpdates" {
+			result = "[" + strings.Join(updates, ",") + "]"
+			updates = nil
+		}
+		fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+	}))
+	defer server.Close()
+
+	telegram, err := NewTelegramApprover(approvals, "TOKEN", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	telegram.APIURL = server.URL
+	approval, err := approvals.Request("license", "issue an enterprise license", map[string]interface{}{"email": "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asked := calls["sendMessage"]
+	if len(asked) != 1 || asked[0]["chat_id"] != "42" || !strings.Contains(fmt.Sprint(asked[0]["reply_markup"]), "approve:"+approval.ID) || !strings.Contains(fmt.Sprint(asked[0]["reply_markup"]), approval.Link) {
+		t.Fatalf("asked %v, want buttons for %s in chat 42", asked, approval.ID)
+	}
+
+	click := func(update int, chat int, data string) string {
+		return fmt.Sprintf(`{"update_id": %d, "callback_query": {"id": "c%d", "data": %q, "from": {"id": 7, "username": "ada"}, "message": {"message_id": 3, "text": "⏸️ Waiting", "chat": {"id": %d}}}}`, update, update, data, chat)
+	}
+	tests := []struct {
+		name       string
+		update     string
+		wantAnswer string
+		wantRan    int
+	}{
+		{"click in another chat", click(1, 99, "approve:"+approval.ID), "This chat does not approve anything", 0},
+		{"unknown button", click(2, 42, "maybe:"+approval.ID), "Unknown button", 0},
+		{"approve", click(3, 42, "approve:"+approval.ID), "✅ Approved by @ada", 1},
+		{"approve again", click(4, 42, "approve:"+approval.ID), "no such approval", 1},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		updates = []string{tt.update}
+		calls["answerCallbackQuery"] = nil
+		mu.Unlock()
+		if err := telegram.poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		answers := calls["answerCallbackQuery"]
+		if len(answers) != 1 || !strings.Contains(stringArg(answers[0], "text"), tt.wantAnswer) {
+			t.Errorf("%s: answered %v, want %q", tt.name, answers, tt.wantAnswer)
+		}
+		if len(ran) != tt.wantRan {
+			t.Errorf("%s: ran %v, want %d runs", tt.name, ran, tt.wantRan)
+		}
+	}
+	if edits := calls["editMessageText"]; len(edits) != 1 || stringArg(edits[0], "text") != "⏸️ Waiting\n✅ Approved by @ada" {
+		t.Errorf("edited %v, want the approval marked on the message", edits)
+	}
+	if offset := calls["getUpdates"][len(tests)-1]["offset"]; offset != float64(4) {
+		t.Errorf("last poll from offset %v, want 4", offset)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateExt is the file extension of every template in the store
+const templateExt = ".tmpl"
+
+// TemplateStore holds all customer-facing text: emails, Slack messages,
+// webhook bodies and receipts. Templates live in a directory as
+// <name>.tmpl, and a product can override any of them with
+// products/<product>/<name>.tmpl. Translations sit beside each file as
+// <name>.<locale>.tmpl, e.g. sale_receipt.de.tmpl or sale_receipt.pt-br.tmpl. Data comes from the message payload, so
+// templates read fields such as {{.product}} or {{get . "customer.name" "there"}}.
+type TemplateStore struct {
+	dir   string
+	mu    sync.Mutex
+	cache map[string]*template.Template
+}
+
+// templateFuncs are available to every template
+var templateFuncs = template.FuncMap{
+	"get": func(data interface{}, path string, fallback interface{}) interface{} {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return fallback
+		}
+		if value, ok := lookupField(object, path); ok && value != nil {
+			return value
+		}
+		return fallback
+	},
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"title": func(s string) string {
+		if s == "" {
+			return s
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+}
+
+// NewTemplateStore creates a store reading templates from dir
+func NewTemplateStore(dir string) *TemplateStore {
+	return &TemplateStore{
+		dir:   dir,
+		cache: make(map[string]*template.Template),
+	}
+}
+
+// Render executes the named template, preferring the product's override
+func (ts *TemplateStore) Render(name, product string, data interface{}) (string, error) {
+	return ts.RenderLocale(name, product, "", data)
+}
+
+// RenderLocale executes the best variant of the named template for the
+// locale, falling back to the base language and then to the untranslated
+// template. A translation is preferred over an untranslated product override.
+func (ts *TemplateStore) RenderLocale(name, product, locale string, data interface{}) (string, error) {
+	tmpl, err := ts.lookup(name, product, locale)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template %s: %v", name, err)
+	}
+	return out.String(), nil
+}
+
+// Preview renders the named template against the sample data in
+// preview.yaml, so copy can be checked before any real customer sees it.
+// Without a locale, it is detected from the sample as for a real sale.
+func (ts *TemplateStore) Preview(name, product, locale string) (string, error) {
+	var samples map[string]map[string]interface{}
+	content, err := ioutil.ReadFile(filepath.Join(ts.dir, "preview.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read preview data: %v", err)
+	}
+	err = yaml.Unmarshal(content, &samples)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse preview data: %v", err)
+	}
+
+	// Use the template's own sample when there is one, otherwise the default
+	data, ok := samples[name]
+	if !ok {
+		data = samples["default"]
+	}
+	if product != "" {
+		data = mergePayload(data, map[string]interface{}{"product": product})
+	}
+	if locale == "" {
+		locale = DetectLocale(data)
+	}
+	return ts.RenderLocale(name, product, locale, normalizeJSON(data))
+}
+
+// List returns the names of the base templates
+func (ts *TemplateStore) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(ts.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), templateExt)
+		// Skip translations; they are variants of a base template
+		if !entry.IsDir() && base != entry.Name() && !strings.Contains(base, ".") {
+			names = append(names, base)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// HasProductOverride reports whether the product has its own variant of
+// the named template, in any locale
+func (ts *TemplateStore) HasProductOverride(name, product string) bool {
+	if product == "" || strings.ContainsAny(product, `/\`) || product == ".." {
+		return false
+	}
+	dir := filepath.Join(ts.dir, "products", product)
+	if _, err := os.Stat(filepath.Join(dir, name+templateExt)); err == nil {
+		return true
+	}
+	translations, _ := filepath.Glob(filepath.Join(dir, name+".*"+templateExt))
+	return len(translations) > 0
+}
+
+// Reload drops every parsed template so edited files are read again
+func (ts *TemplateStore) Reload() {
+	ts.mu.Lock()
+	ts.cache = make(map[string]*template.Template)
+	ts.mu.Unlock()
+}
+
+// lookup parses and caches a template, trying each locale variant of the
+// product override and base template before the untranslated ones
+func (ts *TemplateStore) lookup(name, product, locale string) (*template.Template, error) {
+	dirs := []string{ts.dir}
+	// Product IDs come from payloads, so never let one escape the directory
+	if product != "" && !strings.ContainsAny(product, `/\`) && product != ".." {
+		dirs = append([]string{filepath.Join(ts.dir, "products", product)}, dirs...)
+	}
+
+	var paths []string
+	for _, variant := range LocaleFallbacks(locale) {
+		for _, dir := range dirs {
+			paths = append(paths, filepath.Join(dir, name+"."+variant+templateExt))
+		}
+	}
+	for _, dir := range dirs {
+		paths = append(paths, filepath.Join(dir, name+templateExt))
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for _, path := range paths {
+		if tmpl, ok := ts.cache[path]; ok {
+			return tmpl, nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %v", path, err)
+		}
+
+		tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %v", path, err)
+		}
+		ts.cache[path] = tmpl
+		return tmpl, nil
+	}
+	return nil, fmt.Errorf("no template named %s", name)
+}
+
+// mergePayload returns a copy of base with extra's keys added on top
+func mergePayload(base, extra map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(extra))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
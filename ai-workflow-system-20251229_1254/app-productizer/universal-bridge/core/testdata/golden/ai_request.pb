@@ -0,0 +1,15 @@
+
+golden-0000012026-01-15T09:30:01Z
+ai_request"go*	universal2
+
+actiongenerate_content
+?
+context4*2
+
+priorityhigh
+
+projectApp Productizer
+A
+instructions1/Use standard library and include error handling
+?
+prompt53Create a Go function that validates email addresses:file_systemB 3001d916abb9a6ffabea0cc2c688432b
\ No newline at end of file
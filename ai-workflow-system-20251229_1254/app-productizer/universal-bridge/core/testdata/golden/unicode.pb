@@ -0,0 +1,5 @@
+
+golden-0000042026-01-15T09:30:04Zai_response"go*
+javascript2&
+$
+contentCafé ☕ — déjà vu:file_systemB 9fc330fa75893e74d3fcd8a70e7ae796
\ No newline at end of file
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpEndpointEnv   = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otelServiceEnv    = "OTEL_SERVICE_NAME"
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// SpanContext identifies a span across bridge hops. Messages carry it in
+// their traceparent header in the W3C Trace Context format, so a peer's
+// spans join the trace of the message it is handling.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the context as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads a traceparent header value, reporting false for
+// one that is malformed or all zeros
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	flags, err3 := strconv.ParseUint(parts[3], 16, 8)
+	if err1 != nil || err2 != nil || err3 != nil || len(traceID) != 16 || len(spanID) != 8 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags&1 == 1
+	return sc, sc.IsValid()
+}
+
+// traceContext reads the span context the message carries
+func (m *UniversalMessage) traceContext() SpanContext {
+	sc, _ := ParseTraceparent(m.Headers[traceparentHeader])
+	return sc
+}
+
+// setTraceContext makes the message carry the span context
+func (m *UniversalMessage) setTraceContext(sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[traceparentHeader] = sc.Traceparent()
+}
+
+// SpanKind is the OpenTelemetry span kind
+type SpanKind int
+
+const (
+	SpanInternal SpanKind = 1
+	SpanClient   SpanKind = 3
+	SpanProducer SpanKind = 4
+	SpanConsumer SpanKind = 5
+)
+
+// Span is one timed operation. A nil *Span, as a nil *Tracer starts, does
+// nothing, so code is instrumented without checking that tracing is on.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     [8]byte // zero for a trace's root span
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        string
+
+	tracer *Tracer
+}
+
+// SpanContext returns the span's context, or the zero context for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// SetAttribute records a string, bool or number about the operation
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, marking it failed when err is set, and exports it
+// if its trace is sampled
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = s.tracer.clock.Now()
+	if err != nil {
+		s.Err = err.Error()
+	}
+	if s.Context.Sampled {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// SpanExporter receives finished spans
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// Tracer starts spans and hands them to an exporter when they finish
+type Tracer struct {
+	clock    Clock
+	exporter SpanExporter
+}
+
+// NewTracer creates a tracer timing spans with the clock
+func NewTracer(clock Clock, exporter SpanExporter) *Tracer {
+	if clock == nil {
+		clock = defaultClock
+	}
+	return &Tracer{clock: clock, exporter: exporter}
+}
+
+// Start begins a span as a child of parent, or as the root of a new sampled
+// trace when parent is not valid
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      t.clock.Now(),
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanID[:])
+	return span
+}
+
+// WithTracer traces sends, file I/O and handlers
+func WithTracer(tracer *Tracer) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.tracer = tracer
+	}
+}
+
+// OTLPExporter batches spans and posts them to an OpenTelemetry collector
+// over OTLP/HTTP in its JSON encoding. Spans are sent every Interval, or as
+// soon as BatchSize are waiting; while the collector is down, at most
+// MaxQueued are kept and newer spans are dropped.
+type OTLPExporter struct {
+	// Endpoint is the collector's base URL, e.g. http://localhost:4318
+	Endpoint string
+	// Service is the service.name spans are reported under
+	Service    string
+	BatchSize  int
+	MaxQueued  int
+	Interval   time.Duration
+	Retries    int
+	Backoff    time.Duration
+	HTTPClient *http.Client
+
+	clock Clock
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+	flush   chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewOTLPExporter creates an exporter posting to the collector at endpoint
+func NewOTLPExporter(endpoint, service string, clock Clock) *OTLPExporter {
+	if clock == nil {
+		clock = defaultClock
+	}
+	if service == "" {
+		service = "universal-bridge-go"
+	}
+	return &OTLPExporter{
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		Service:    service,
+		BatchSize:  512,
+		MaxQueued:  4096,
+		Interval:   5 * time.Second,
+		Retries:    2,
+		Backoff:    time.Second,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		clock:      clock,
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// ExportSpan queues a finished span
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= e.MaxQueued {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, span)
+	if len(e.queue) >= e.BatchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start sends queued spans in the background until Close
+func (e *OTLPExporter) Start() {
+	fmt.Printf("🔭 Exporting traces to %s\n", e.Endpoint)
+	go func() {
+		defer close(e.stopped)
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-e.flush:
+			case <-e.clock.After(e.Interval):
+			}
+			if err := e.Flush(); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}()
+}
+
+// Close stops the background sends and sends what is still queued
+func (e *OTLPExporter) Close() error {
+	close(e.stop)
+	<-e.stopped
+	return e.Flush()
+}
+
+// Flush sends every queued span, a batch at a time
+func (e *OTLPExporter) Flush() error {
+	for {
+		e.mu.Lock()
+		batch := e.queue
+		if len(batch) > e.BatchSize {
+			batch = batch[:e.BatchSize]
+		}
+		e.queue = e.queue[len(batch):]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			log.Printf("❌ Dropped %d spans while the collector was behind", dropped)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		err := retrier{Retries: e.Retries, Backoff: e.Backoff, Clock: e.clock, Classify: retryTransient}.do("Trace export", func() error {
+			return e.post(batch)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export %d spans: %v", len(batch), err)
+		}
+	}
+}
+
+func (e *OTLPExporter) post(batch []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.Service, batch))
+	if err != nil {
+		return final(err)
+	}
+	request, err := http.NewRequest(http.MethodPost, e.Endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return final(err)
+	}
+	request.Header.Set("Content-Type", JSONContentType)
+	response, err := e.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return readAPIError("Collector", response)
+	}
+	return nil
+}
+
+// otlpRequest builds an ExportTraceServiceRequest in OTLP's JSON encoding,
+// where IDs are hex and 64-bit integers are strings
+func otlpRequest(service string, spans []*Span) map[string]interface{} {
+	encoded := make([]interface{}, 0, len(spans))
+	for _, span := range spans {
+		entry := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.Context.TraceID[:]),
+			"spanId":            hex.EncodeToString(span.Context.SpanID[:]),
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.Parent != [8]byte{} {
+			entry["parentSpanId"] = hex.EncodeToString(span.Parent[:])
+		}
+		if span.Err != "" {
+			entry["status"] = map[string]interface{}{"code": 2, "message": span.Err}
+		}
+		encoded = append(encoded, entry)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "universalbridge"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoded := make([]interface{}, 0, len(attributes))
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch typed := attributes[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": typed}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(typed)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(typed, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": typed}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(typed)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": value})
+	}
+	return encoded
+}
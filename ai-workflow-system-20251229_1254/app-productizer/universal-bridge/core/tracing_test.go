@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.wantOK || (ok && sc.Sampled != tt.wantSampled) {
+			t.Errorf("ParseTraceparent(%q) = %+v, %v", tt.value, sc, ok)
+		}
+		if ok && tt.value[:2] == "00" && sc.Traceparent() != tt.value {
+			t.Errorf("Traceparent() = %q, want %q", sc.Traceparent(), tt.value)
+		}
+	}
+}
+
+// spanRecorder keeps every exported span
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (r *spanRecorder) ExportSpan(span *Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) named(name string) *Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestTracingFollowsARequestAcrossHops(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	spans := &spanRecorder{}
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")), WithTracer(NewTracer(clock, spans)))
+	t.Cleanup(func() { gb.Close() })
+	NewAIServer(gb, &stubProvider{name: "anthropic"})
+
+	// The Python peer sent the request under its own span
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	request := newUniversalMessage(clock, NewSequentialIDs("py"), AIRequest, "python", "go", map[string]interface{}{"prompt": "hi"}, SharedMemory)
+	request.Headers = map[string]string{traceparentHeader: incoming, tracestateHeader: "vendor=1"}
+	if reason := transport.Inject(request); reason != nil {
+		t.Fatal(reason)
+	}
+
+	peer, _ := ParseTraceparent(incoming)
+	handle := spans.named("bridge.handle ai_request")
+	generate := spans.named("ai.generate")
+	send := spans.named("bridge.send ai_response")
+	if handle == nil || generate == nil || send == nil {
+		t.Fatalf("spans %v", spans.spans)
+	}
+	for _, link := range []struct {
+		name   string
+		span   *Span
+		parent SpanContext
+	}{
+		{"handler", handle, peer},
+		{"AI call", generate, handle.Context},
+		{"reply send", send, handle.Context},
+	} {
+		if link.span.Context.TraceID != peer.TraceID || link.span.Parent != link.parent.SpanID {
+			t.Errorf("%s span %x/%x has parent %x, want %x in trace %x", link.name, link.span.Context.TraceID, link.span.Context.SpanID, link.span.Parent, link.parent.SpanID, peer.TraceID)
+		}
+	}
+	if generate.Kind != SpanClient || generate.Attributes["gen_ai.system"] != "anthropic" {
+		t.Errorf("AI span %+v", generate)
+	}
+
+	replies := transport.SentOfType(AIResponse)
+	if len(replies) != 1 {
+		t.Fatalf("replies %v", transport.Sent())
+	}
+	if got := replies[0].Headers[traceparentHeader]; got != send.Context.Traceparent() {
+		t.Errorf("reply traceparent %q, want the send span's %q", got, send.Context.Traceparent())
+	}
+	if got := replies[0].Headers[tracestateHeader]; got != "vendor=1" {
+		t.Errorf("reply tracestate %q", got)
+	}
+}
+
+func TestUntracedBridgePassesTraceContextOn(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	NewAIServer(gb, &stubProvider{name: "anthropic"})
+
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	request := newUniversalMessage(clock, NewSequentialIDs("py"), AIRequest, "python", "go", map[string]interface{}{"prompt": "hi"}, SharedMemory)
+	request.Headers = map[string]string{traceparentHeader: incoming}
+	if reason := transport.Inject(request); reason != nil {
+		t.Fatal(reason)
+	}
+	replies := transport.SentOfType(AIResponse)
+	if len(replies) != 1 || replies[0].Headers[traceparentHeader] != incoming {
+		t.Fatalf("replies %v, want traceparent %s", replies, incoming)
+	}
+}
+
+func TestOTLPExporterPostsSpans(t *testing.T) {
+	var got map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer collector.Close()
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	exporter := NewOTLPExporter(collector.URL+"/", "shop-bridge", clock)
+	tracer := NewTracer(clock, exporter)
+	root := tracer.Start("bridge.send data_sync", SpanProducer, SpanContext{})
+	root.SetAttribute("bridge.channel", "file_system")
+	child := tracer.Start("file.write", SpanInternal, root.SpanContext())
+	clock.Advance(3 * time.Millisecond)
+	child.Finish(nil)
+	root.Finish(errors.New("disk full"))
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	resource := got["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["value"].(map[string]interface{})["stringValue"] != "shop-bridge" {
+		t.Fatalf("resource %v", resource["resource"])
+	}
+	exported := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(exported) != 2 {
+		t.Fatalf("spans %v", exported)
+	}
+	write, send := exported[0].(map[string]interface{}), exported[1].(map[string]interface{})
+	if write["parentSpanId"] != send["spanId"] || write["traceId"] != send["traceId"] || send["parentSpanId"] != nil {
+		t.Errorf("write %v is not a child of send %v", write, send)
+	}
+	if write["startTimeUnixNano"] != "1768469400000000000" || write["endTimeUnixNano"] != "1768469400003000000" {
+		t.Errorf("write timed %v to %v", write["startTimeUnixNano"], write["endTimeUnixNano"])
+	}
+	if status, _ := send["status"].(map[string]interface{}); status["code"] != 2.0 {
+		t.Errorf("failed send has status %v", send["status"])
+	}
+}
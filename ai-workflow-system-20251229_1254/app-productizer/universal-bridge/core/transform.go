@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TransformSet is a set of named pipelines loaded from YAML, applied to
+// received messages before their handlers run and to the copies queued on
+// sinks, so one pipeline can shape the rows of several sinks:
+//
+//	products:                 # metadata enrich adds, by product ID or permalink
+//	  icons:
+//	    name: Icon Pack
+//	    category: design
+//	rates:                    # what one unit of a common base is worth in each currency
+//	  usd: 1
+//	  eur: 0.92
+//	pipelines:
+//	  normalize:
+//	    - rename: {product_permalink: product}
+//	    - enrich: product_info
+//	    - convert: {fields: [price], to: usd}
+//	  sheet-row:
+//	    - drop: [ip_country, referrer]
+//	    - set: {deal_size: 'price_usd >= 100 ? "large" : "small"'}
+//	apply:
+//	  - on: sale_event
+//	    pipeline: normalize
+//	sinks:
+//	  sheets: sheet-row
+//	  slack: sheet-row
+//
+// Each step does one of rename, drop, set, enrich or convert. Fields may be
+// dotted paths such as "customer.email".
+type TransformSet struct {
+	Products  map[string]map[string]interface{} `yaml:"products"`
+	Rates     map[string]float64                `yaml:"rates"`
+	Pipelines map[string][]TransformStep        `yaml:"pipelines"`
+	Apply     []TransformBinding                `yaml:"apply"`
+	Sinks     map[string]string                 `yaml:"sinks"` // sink name → pipeline
+}
+
+// TransformBinding runs a pipeline on received messages of type On, every
+// type when empty
+type TransformBinding struct {
+	On       MessageType `yaml:"on"`
+	Pipeline string      `yaml:"pipeline"`
+}
+
+// TransformStep is one stage of a pipeline
+type TransformStep struct {
+	Rename  map[string]string   `yaml:"rename"` // old field → new field
+	Drop    []string            `yaml:"drop"`
+	Set     ScriptAssignments   `yaml:"set"`
+	Enrich  string              `yaml:"enrich"` // field the product's metadata is added under
+	Convert *CurrencyConversion `yaml:"convert"`
+}
+
+// CurrencyConversion adds <field>_<to> for each amount field, converted from
+// the payload's currency at the set's rates and written in major units like
+// Gumroad's prices
+type CurrencyConversion struct {
+	Fields []string `yaml:"fields"` // price when empty
+	To     string   `yaml:"to"`
+}
+
+// LoadTransforms reads a transforms file and checks every pipeline in it
+func LoadTransforms(path string) (*TransformSet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transforms: %v", err)
+	}
+
+	var transforms TransformSet
+	err = yaml.Unmarshal(content, &transforms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transforms %s: %v", path, err)
+	}
+
+	err = transforms.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid transforms %s: %v", path, err)
+	}
+	return &transforms, nil
+}
+
+// Compile checks each step does exactly one thing, that the pipelines
+// named exist, and compiles the set scripts
+func (ts *TransformSet) Compile() error {
+	rates := make(map[string]float64, len(ts.Rates))
+	for code, rate := range ts.Rates {
+		currency, err := parseCurrency(code)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("rate %s: needs a currency code and a positive rate", code)
+		}
+		rates[currency] = rate
+	}
+	ts.Rates = rates
+	for key, metadata := range ts.Products {
+		normalized, _ := normalizeJSON(metadata).(map[string]interface{})
+		ts.Products[key] = normalized
+	}
+
+	for name, steps := range ts.Pipelines {
+		if len(steps) == 0 {
+			return fmt.Errorf("pipeline %s has no steps", name)
+		}
+		for i := range steps {
+			if err := ts.compileStep(&steps[i]); err != nil {
+				return fmt.Errorf("pipeline %s step %d: %v", name, i+1, err)
+			}
+		}
+	}
+	for i, binding := range ts.Apply {
+		if _, ok := ts.Pipelines[binding.Pipeline]; !ok {
+			return fmt.Errorf("apply %d: no pipeline named %q", i+1, binding.Pipeline)
+		}
+	}
+	for sink, pipeline := range ts.Sinks {
+		if _, ok := ts.Pipelines[pipeline]; !ok {
+			return fmt.Errorf("sink %s: no pipeline named %q", sink, pipeline)
+		}
+	}
+	return nil
+}
+
+func (ts *TransformSet) compileStep(step *TransformStep) error {
+	actions := 0
+	for _, set := range []bool{len(step.Rename) > 0, len(step.Drop) > 0, len(step.Set) > 0, step.Enrich != "", step.Convert != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("needs exactly one of rename, drop, set, enrich or convert")
+	}
+
+	fields := append([]string(nil), step.Drop...)
+	for from, to := range step.Rename {
+		fields = append(fields, from, to)
+	}
+	if step.Enrich != "" {
+		fields = append(fields, step.Enrich)
+	}
+	if step.Convert != nil {
+		if len(step.Convert.Fields) == 0 {
+			step.Convert.Fields = []string{"price"}
+		}
+		currency, err := parseCurrency(step.Convert.To)
+		if err != nil || step.Convert.To == "" {
+			return fmt.Errorf("convert needs a currency to convert to")
+		}
+		if _, ok := ts.Rates[currency]; !ok {
+			return fmt.Errorf("convert: no rate for %s", currency)
+		}
+		step.Convert.To = currency
+		fields = append(fields, step.Convert.Fields...)
+	}
+	for _, field := range fields {
+		if !scriptFieldPath.MatchString(field) {
+			return fmt.Errorf("%q is not a field name or dotted path", field)
+		}
+	}
+
+	for i := range step.Set {
+		assignment := &step.Set[i]
+		if !scriptFieldPath.MatchString(assignment.Field) {
+			return fmt.Errorf("cannot set %q; use a field name or dotted path", assignment.Field)
+		}
+		var err error
+		if assignment.compiled, err = CompileScript(assignment.Script); err != nil {
+			return fmt.Errorf("set %s: %v", assignment.Field, err)
+		}
+	}
+	return nil
+}
+
+// Transforms applies a transform set to a bridge's received messages and
+// sinks
+type Transforms struct {
+	set *TransformSet
+}
+
+// NewTransforms attaches the apply pipelines to the bridge's receive
+// filters and the sink pipelines to their sinks, which must be registered
+func NewTransforms(gb *GoBridge, set *TransformSet) (*Transforms, error) {
+	transforms := &Transforms{set: set}
+	sinks := make([]string, 0, len(set.Sinks))
+	for name := range set.Sinks {
+		sinks = append(sinks, name)
+	}
+	sort.Strings(sinks)
+	for _, name := range sinks {
+		sink := gb.Sink(name)
+		if sink == nil {
+			return nil, fmt.Errorf("transforms: no sink named %s", name)
+		}
+		pipeline := set.Sinks[name]
+		sink.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+			copied := *message
+			copied.Payload = copyPayload(message.Payload)
+			transforms.Run(pipeline, &copied)
+			return &copied
+		})
+	}
+	gb.OnFilter(transforms.Apply)
+	fmt.Printf("📝 Loaded %d transform pipelines\n", len(set.Pipelines))
+	return transforms, nil
+}
+
+// Apply runs the pipelines bound to the message's type, in order. It never
+// drops a message.
+func (t *Transforms) Apply(message *UniversalMessage) bool {
+	for _, binding := range t.set.Apply {
+		if binding.On == "" || binding.On == message.MessageType {
+			t.Run(binding.Pipeline, message)
+		}
+	}
+	return true
+}
+
+// Run transforms the message's payload in place. A step that fails is
+// logged and skipped, and the steps after it still run, so a missing rate
+// never loses a sale.
+func (t *Transforms) Run(pipeline string, message *UniversalMessage) {
+	if message.Payload == nil {
+		message.Payload = make(map[string]interface{})
+	}
+	for i, step := range t.set.Pipelines[pipeline] {
+		if err := t.run(step, message); err != nil {
+			log.Printf("❌ Transform %s step %d failed for %s: %v", pipeline, i+1, message.ID, err)
+		}
+	}
+}
+
+func (t *Transforms) run(step TransformStep, message *UniversalMessage) error {
+	payload := message.Payload
+	switch {
+	case len(step.Rename) > 0:
+		// Every field is read before any is written, so renames can swap
+		moved := make(map[string]interface{}, len(step.Rename))
+		for from := range step.Rename {
+			if value, ok := lookupField(payload, from); ok {
+				moved[from] = value
+				removeField(payload, from)
+			}
+		}
+		for from, value := range moved {
+			if err := setField(payload, step.Rename[from], value); err != nil {
+				return fmt.Errorf("rename %s: %v", from, err)
+			}
+		}
+	case len(step.Drop) > 0:
+		for _, field := range step.Drop {
+			removeField(payload, field)
+		}
+	case len(step.Set) > 0:
+		vars := scriptVars(message)
+		for _, assignment := range step.Set {
+			value, err := assignment.compiled.Eval(vars)
+			if err != nil {
+				return fmt.Errorf("set %s: %v", assignment.Field, err)
+			}
+			if err = setField(payload, assignment.Field, value); err != nil {
+				return fmt.Errorf("set %s: %v", assignment.Field, err)
+			}
+			top := strings.SplitN(assignment.Field, ".", 2)[0]
+			vars[top] = payload[top]
+		}
+	case step.Enrich != "":
+		product := productKey(payload)
+		metadata, ok := t.set.Products[product]
+		if !ok {
+			if product == "" {
+				return fmt.Errorf("enrich: no product_id or product_permalink")
+			}
+			return fmt.Errorf("enrich: no metadata for product %s", product)
+		}
+		return setField(payload, step.Enrich, copyPayload(metadata))
+	case step.Convert != nil:
+		return t.convert(step.Convert, payload)
+	}
+	return nil
+}
+
+// convert writes every converted amount or, if one fails, none of them
+func (t *Transforms) convert(conversion *CurrencyConversion, payload map[string]interface{}) error {
+	from, err := parseCurrency(stringArg(payload, "currency"))
+	if err != nil {
+		return fmt.Errorf("convert: %v", err)
+	}
+	fromRate, ok := t.set.Rates[from]
+	if !ok {
+		return fmt.Errorf("convert: no rate for %s", from)
+	}
+	rate := new(big.Rat).Quo(ratFromFloat(t.set.Rates[conversion.To]), ratFromFloat(fromRate))
+
+	converted := make(map[string]string, len(conversion.Fields))
+	for _, field := range conversion.Fields {
+		value, present := lookupField(payload, field)
+		if !present || value == nil {
+			continue
+		}
+		amount, err := moneyArg(value, from)
+		if err != nil {
+			return fmt.Errorf("convert %s: %v", field, err)
+		}
+		result, err := convertMoney(amount, rate, conversion.To)
+		if err != nil {
+			return fmt.Errorf("convert %s: %v", field, err)
+		}
+		converted[field+"_"+conversion.To] = result.Decimal()
+	}
+	for field, amount := range converted {
+		if err := setField(payload, field, amount); err != nil {
+			return fmt.Errorf("convert: %v", err)
+		}
+	}
+	return nil
+}
+
+// convertMoney multiplies an amount by a rate exactly, rounding once to the
+// target currency's minor unit
+func convertMoney(amount Money, rate *big.Rat, currency string) (Money, error) {
+	value := new(big.Rat).SetFrac(big.NewInt(amount.Amount), pow10(currencyExponent(amount.Currency)))
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetInt(pow10(currencyExponent(currency))))
+	minor, err := roundRat(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(minor, currency), nil
+}
+
+// ratFromFloat reads a rate through its shortest decimal form, so 0.92 is
+// exactly 92/100
+func ratFromFloat(f float64) *big.Rat {
+	rate, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return rate
+}
+
+// removeField deletes the field at a dotted path, if it is there
+func removeField(payload map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	object := payload
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := object[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		object = nested
+	}
+	delete(object, keys[len(keys)-1])
+}
+
+// copyPayload copies nested objects and lists, so transforming the copy
+// leaves the original as it was
+func copyPayload(payload map[string]interface{}) map[string]interface{} {
+	if payload == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(payload))
+	for key, value := range payload {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyPayload(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
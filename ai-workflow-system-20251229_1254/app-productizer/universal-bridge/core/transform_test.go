@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTransforms(t *testing.T, content string) (*TransformSet, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "transforms.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadTransforms(path)
+}
+
+func TestTransforms(t *testing.T) {
+	transforms, err := writeTransforms(t, `products:
+  icons:
+    name: Icon Pack
+    category: design
+    tags: [svg, figma]
+rates:
+  usd: 1
+  eur: 0.92
+  jpy: 150
+pipelines:
+  normalize:
+    - rename: {product_permalink: product_id, purchaser.email: email}
+    - enrich: product_info
+    - convert: {fields: [price, gumroad_fee], to: usd}
+  row:
+    - drop: [ip_country, product_info.tags]
+    - set:
+        deal_size: 'price_usd >= 100 ? "large" : "small"'
+apply:
+  - on: sale_event
+    pipeline: normalize
+sinks:
+  sheets: row
+  slack: row
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	delivered := make(chan map[string]interface{}, 4)
+	for _, name := range []string{"sheets", "slack"} {
+		gb.AddSink(name, SinkConfig{Types: []MessageType{SaleEvent}}, func(message *UniversalMessage) error {
+			delivered <- message.Payload
+			return nil
+		})
+	}
+	if _, err := NewTransforms(gb, transforms); err != nil {
+		t.Fatal(err)
+	}
+	var handled map[string]interface{}
+	gb.OnMessage(SaleEvent, func(message *UniversalMessage) error {
+		handled = message.Payload
+		return nil
+	})
+
+	ids := NewSequentialIDs("gumroad")
+	payload := map[string]interface{}{
+		"sale_id": "s1", "product_permalink": "icons", "price": "92.00", "gumroad_fee": 9.2, "currency": "eur",
+		"ip_country": "DE", "purchaser": map[string]interface{}{"email": "ada@example.com"},
+	}
+	if reason := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", payload, HTTP)); reason != nil {
+		t.Fatal(reason)
+	}
+
+	want := map[string]interface{}{
+		"sale_id": "s1", "product_id": "icons", "price": "92.00", "gumroad_fee": 9.2, "currency": "eur",
+		"ip_country": "DE", "purchaser": map[string]interface{}{}, "email": "ada@example.com",
+		"product_info": map[string]interface{}{"name": "Icon Pack", "category": "design", "tags": []interface{}{"svg", "figma"}},
+		"price_usd":    "100.00", "gumroad_fee_usd": "10.00",
+	}
+	if !reflect.DeepEqual(handled, want) {
+		t.Fatalf("handled %v\nwant %v", handled, want)
+	}
+
+	// Both sinks get the row pipeline's copy; the handler's payload is untouched
+	for i := 0; i < 2; i++ {
+		select {
+		case row := <-delivered:
+			if _, kept := row["ip_country"]; kept || row["deal_size"] != "large" || row["email"] != "ada@example.com" {
+				t.Errorf("sink row %v", row)
+			}
+			if info := row["product_info"].(map[string]interface{}); info["tags"] != nil || info["name"] != "Icon Pack" {
+				t.Errorf("sink product_info %v", info)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("sinks were not delivered to")
+		}
+	}
+	if handled["ip_country"] != "DE" || handled["deal_size"] != nil || handled["product_info"].(map[string]interface{})["tags"] == nil {
+		t.Errorf("sink pipeline changed the handler's payload: %v", handled)
+	}
+}
+
+func TestTransformConvert(t *testing.T) {
+	transforms, err := writeTransforms(t, `rates: {usd: 1, eur: 0.92, jpy: 150}
+pipelines:
+  usd:
+    - convert: {to: usd}
+  jpy:
+    - convert: {to: jpy}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &Transforms{set: transforms}
+	tests := []struct {
+		pipeline string
+		payload  map[string]interface{}
+		field    string
+		want     interface{}
+	}{
+		{"usd", map[string]interface{}{"price": "10", "currency": "eur"}, "price_usd", "10.87"},
+		{"usd", map[string]interface{}{"price": 29.99}, "price_usd", "29.99"},
+		{"usd", map[string]interface{}{"price": "1500", "currency": "jpy"}, "price_usd", "10.00"},
+		{"jpy", map[string]interface{}{"price": "0.92", "currency": "eur"}, "price_jpy", "150"},
+		{"usd", map[string]interface{}{"price": "10", "currency": "gbp"}, "price_usd", nil},
+		{"usd", map[string]interface{}{"price": "ten"}, "price_usd", nil},
+	}
+	for _, tt := range tests {
+		message := &UniversalMessage{ID: "m1", MessageType: SaleEvent, Payload: tt.payload}
+		run.Run(tt.pipeline, message)
+		if got := message.Payload[tt.field]; got != tt.want {
+			t.Errorf("%s of %v: %s = %v, want %v", tt.pipeline, tt.payload, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTransforms(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"two actions", "pipelines:\n  p:\n    - drop: [a]\n      enrich: b\n", "pipeline p step 1: needs exactly one of"},
+		{"no steps", "pipelines:\n  p: []\n", "pipeline p has no steps"},
+		{"unknown pipeline", "pipelines:\n  p: [{drop: [a]}]\napply:\n  - pipeline: q\n", `apply 1: no pipeline named "q"`},
+		{"unknown sink pipeline", "pipelines:\n  p: [{drop: [a]}]\nsinks:\n  sheets: q\n", `sink sheets: no pipeline named "q"`},
+		{"no rate", "rates: {usd: 1}\npipelines:\n  p: [{convert: {to: eur}}]\n", "convert: no rate for eur"},
+		{"bad rate", "rates: {usd: 0}\n", "rate usd: needs a currency code and a positive rate"},
+		{"bad field", "pipelines:\n  p: [{rename: {'a b': c}}]\n", `"a b" is not a field name`},
+		{"bad script", "pipelines:\n  p: [{set: {x: 'price +'}}]\n", "set x: col 8: unexpected end of script"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := writeTransforms(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Envelope is a raw inbound message as read from a transport, before decoding
+type Envelope struct {
+	Channel CommunicationChannel
+	Source  string // file path, remote address, etc. used in log lines
+	Data    []byte // nil for streamed envelopes until Bytes is called
+	Size    int64  // encoded size in bytes, -1 if unknown
+
+	// ContentType selects the registered Serializer that decodes Data; empty
+	// is JSON
+	ContentType string
+
+	open func() (io.ReadCloser, error)
+	once sync.Once
+	ack  func() error
+	nack func(error)
+}
+
+// NewEnvelope wraps raw message data with the transport's ack and nack callbacks
+func NewEnvelope(channel CommunicationChannel, source string, data []byte, ack func() error, nack func(error)) *Envelope {
+	return &Envelope{
+		Channel: channel,
+		Source:  source,
+		Data:    data,
+		Size:    int64(len(data)),
+		ack:     ack,
+		nack:    nack,
+	}
+}
+
+// NewStreamEnvelope creates an envelope whose data is read lazily from open,
+// so large messages can be decoded without buffering the whole file
+func NewStreamEnvelope(channel CommunicationChannel, source string, size int64, open func() (io.ReadCloser, error), ack func() error, nack func(error)) *Envelope {
+	return &Envelope{
+		Channel: channel,
+		Source:  source,
+		Size:    size,
+		open:    open,
+		ack:     ack,
+		nack:    nack,
+	}
+}
+
+// Open returns a reader over the envelope's encoded message
+func (e *Envelope) Open() (io.ReadCloser, error) {
+	if e.Data == nil && e.open != nil {
+		return e.open()
+	}
+	return ioutil.NopCloser(bytes.NewReader(e.Data)), nil
+}
+
+// Bytes returns the encoded message, reading a streamed envelope fully
+func (e *Envelope) Bytes() ([]byte, error) {
+	if e.Data != nil || e.open == nil {
+		return e.Data, nil
+	}
+
+	r, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	e.Data = data
+	e.Size = int64(len(data))
+	return data, nil
+}
+
+// Ack tells the transport the message was handled and can be discarded.
+// Only the first Ack or Nack call has any effect.
+func (e *Envelope) Ack() error {
+	var err error
+	e.once.Do(func() {
+		if e.ack != nil {
+			err = e.ack()
+		}
+	})
+	return err
+}
+
+// Nack tells the transport the message could not be handled
+func (e *Envelope) Nack(reason error) {
+	e.once.Do(func() {
+		if e.nack != nil {
+			e.nack(reason)
+		}
+	})
+}
+
+// Transport moves encoded messages between the bridge and its peers
+type Transport interface {
+	// Channel identifies the communication channel this transport implements
+	Channel() CommunicationChannel
+	// Start begins receiving; deliver is called once per inbound message
+	Start(deliver func(*Envelope)) error
+	// Send delivers a message to its target language
+	Send(message *UniversalMessage) error
+	// Close stops receiving and releases resources
+	Close() error
+}
+
+// ReceiveStopper is a Transport that can stop receiving before it closes.
+// GoBridge.Close stops it, drains the pipeline, then closes it, so messages
+// acked during the drain are still recorded as handled.
+type ReceiveStopper interface {
+	StopReceiving()
+}
+
+// LagReporter is a Transport that can tell how long the last message it
+// picked up had been waiting for it
+type LagReporter interface {
+	Lag() time.Duration
+}
+
+// WithTransport replaces the default filesystem transport
+func WithTransport(transport Transport) BridgeOption {
+	return func(gb *GoBridge) {
+		gb.transport = transport
+	}
+}
+
+// WithChannelTransport adds a transport alongside the default one. It
+// receives like any other; SendMessage uses it for messages whose
+// ResponseChannel is its channel.
+func WithChannelTransport(transport Transport) BridgeOption {
+	return func(gb *GoBridge) {
+		if gb.channels == nil {
+			gb.channels = make(map[CommunicationChannel]Transport)
+		}
+		gb.channels[transport.Channel()] = transport
+	}
+}
+
+// transports returns the default transport followed by the extra ones
+func (gb *GoBridge) transports() []Transport {
+	all := []Transport{gb.transport}
+	for _, transport := range gb.channels {
+		if transport != gb.transport {
+			all = append(all, transport)
+		}
+	}
+	return all
+}
+
+// transportFor picks the transport a message is sent on
+func (gb *GoBridge) transportFor(message *UniversalMessage) Transport {
+	if transport, ok := gb.channelTransport(message.ResponseChannel); ok {
+		return transport
+	}
+	return gb.transport
+}
+
+// channelTransport returns the bridge's transport for a channel, if it has one
+func (gb *GoBridge) channelTransport(channel CommunicationChannel) (Transport, bool) {
+	if transport, ok := gb.channels[channel]; ok {
+		return transport, true
+	}
+	if gb.transport.Channel() == channel {
+		return gb.transport, true
+	}
+	return nil, false
+}
+
+// Deliver runs a message built in-process, such as a converted webhook,
+// through the inbound pipeline as if a transport on channel had received it,
+// and waits until it is acked or nacked. Redeliveries of the same message ID
+// are deduplicated as usual.
+func (gb *GoBridge) Deliver(message *UniversalMessage, channel CommunicationChannel) error {
+	jsonStr, err := message.ToJSON()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	gb.pipeline.Submit(NewEnvelope(channel, message.ID, []byte(jsonStr), func() error {
+		done <- nil
+		return nil
+	}, func(reason error) {
+		if reason == nil {
+			reason = fmt.Errorf("message %s rejected", message.ID)
+		}
+		done <- reason
+	}))
+	return <-done
+}
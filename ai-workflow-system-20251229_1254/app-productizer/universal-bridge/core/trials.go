@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxTrials bounds how many trials are tracked at once
+const DefaultMaxTrials = 50000
+
+// ErrTooManyTrials is returned by StartTrial when MaxTrials are tracked
+var ErrTooManyTrials = errors.New("too many trials tracked")
+
+// TrialStart is a trial of a product beginning, either from lead capture,
+// which knows the email, or from an unlicensed app's usage ping, which only
+// knows its install ID
+type TrialStart struct {
+	Product   string // product ID or custom permalink
+	Email     string
+	InstallID string
+	Source    string // e.g. "telemetry" or the landing page's name
+}
+
+// Trial is one trial and the purchase it led to, if any
+type Trial struct {
+	Product     string     `json:"product"`
+	Email       string     `json:"email,omitempty"`
+	InstallID   string     `json:"install_id,omitempty"`
+	Source      string     `json:"source,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty"`
+	SaleID      string     `json:"sale_id,omitempty"`
+}
+
+// TrialStats is how a product's trials converted. Trials still inside
+// Window are Active and count in neither Converted nor Lapsed.
+type TrialStats struct {
+	Product              string         `json:"product"`
+	Trials               int            `json:"trials"`
+	Converted            int            `json:"converted"`
+	Lapsed               int            `json:"lapsed"`
+	Active               int            `json:"active"`
+	Rate                 float64        `json:"conversion_rate"` // converted over converted and lapsed
+	MedianDaysToPurchase float64        `json:"median_days_to_purchase"`
+	ConvertedBySource    map[string]int `json:"converted_by_source,omitempty"`
+	TrialsBySource       map[string]int `json:"trials_by_source,omitempty"`
+}
+
+// Trials records trial starts and matches them to purchases: by email and
+// product for lead capture, and through UsageTelemetry for installs that
+// later ping with a license key. A trial not converted within Window has
+// lapsed, though a later purchase still converts it.
+type Trials struct {
+	// Window is how long a trial runs before it counts as lapsed
+	Window time.Duration
+	// MaxTrials bounds the trials tracked; starts that would add more are
+	// refused
+	MaxTrials int
+	// SaveInterval is how often starts are written to disk; Flush writes the
+	// rest
+	SaveInterval time.Duration
+
+	sales *SalesStore
+	clock Clock
+	path  string
+
+	mu        sync.Mutex
+	trials    map[string]*Trial // product + email or install → trial
+	dirty     bool
+	lastSaved time.Time
+}
+
+// NewTrials opens the trials persisted at path. It listens for data_sync
+// messages with resource_name trial_start, and for sales.
+func NewTrials(gb *GoBridge, sales *SalesStore, path string) (*Trials, error) {
+	tr := &Trials{
+		Window:       14 * 24 * time.Hour,
+		MaxTrials:    DefaultMaxTrials,
+		SaveInterval: time.Minute,
+		sales:        sales,
+		clock:        gb.clock,
+		path:         path,
+		trials:       make(map[string]*Trial),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read trials: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &tr.trials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trials %s: %v", path, err)
+		}
+	}
+
+	gb.OnReceive(tr.handleMessage)
+	return tr, nil
+}
+
+func (tr *Trials) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+
+	payload := message.Payload
+	switch stringArg(payload, "resource_name") {
+	case "trial_start":
+		err := tr.StartTrial(TrialStart{
+			Product:   productKey(payload),
+			Email:     stringArg(payload, "email"),
+			InstallID: stringArg(payload, "install_id"),
+			Source:    stringArg(payload, "source"),
+		})
+		if err != nil {
+			log.Printf("❌ Error tracking trial start from %s: %v", message.ID, err)
+		}
+	case "sale":
+		if sale, err := ParseSale(payload); err == nil && !sale.Refunded {
+			tr.recordSale(sale)
+		}
+	}
+}
+
+// trialKey names a lead-capture trial by email, or a telemetry one by install
+func trialKey(product, email, installID string) string {
+	if email != "" {
+		return visitKey(product, email)
+	}
+	return visitKey(product, "install:"+installID)
+}
+
+// StartTrial records a trial beginning. A repeat start of the same trial,
+// or a trial by an email that already bought the product, changes nothing.
+func (tr *Trials) StartTrial(start TrialStart) error {
+	email := NormalizeEmail(start.Email)
+	if start.Product == "" || (!strings.Contains(email, "@") && !usageToken.MatchString(start.InstallID)) {
+		return fmt.Errorf("trial start needs a product and an email or install ID")
+	}
+	if email != "" {
+		for _, sale := range tr.sales.ByEmail(email) {
+			if !sale.Refunded && (sale.Product == start.Product || sale.Permalink == start.Product) {
+				return nil
+			}
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	key := trialKey(start.Product, email, start.InstallID)
+	if _, started := tr.trials[key]; started {
+		return nil
+	}
+	if tr.MaxTrials > 0 && len(tr.trials) >= tr.MaxTrials {
+		return ErrTooManyTrials
+	}
+	now := tr.clock.Now()
+	tr.trials[key] = &Trial{
+		Product:   start.Product,
+		Email:     email,
+		InstallID: start.InstallID,
+		Source:    start.Source,
+		StartedAt: now,
+	}
+
+	tr.dirty = true
+	if now.Sub(tr.lastSaved) < tr.SaveInterval {
+		return nil
+	}
+	return tr.saveLocked()
+}
+
+// Flush writes trial starts not yet saved
+func (tr *Trials) Flush() error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if !tr.dirty {
+		return nil
+	}
+	return tr.saveLocked()
+}
+
+// recordSale converts the buyer's trial of the product
+func (tr *Trials) recordSale(sale Sale) {
+	tr.convert(sale, visitKey(sale.Product, sale.Email), visitKey(sale.Permalink, sale.Email))
+}
+
+// convertInstall converts the install's trial of the product once it pings
+// with the license key of sale
+func (tr *Trials) convertInstall(sale Sale, installID string) {
+	tr.convert(sale, trialKey(sale.Product, "", installID), trialKey(sale.Permalink, "", installID))
+}
+
+func (tr *Trials) convert(sale Sale, keys ...string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	for _, key := range keys {
+		trial := tr.trials[key]
+		if trial == nil || trial.ConvertedAt != nil {
+			continue
+		}
+		// A purchase before the trial did not come from it
+		if !sale.CreatedAt.IsZero() && sale.CreatedAt.Before(trial.StartedAt) {
+			continue
+		}
+		convertedAt := tr.clock.Now()
+		if !sale.CreatedAt.IsZero() {
+			convertedAt = sale.CreatedAt
+		}
+		trial.ConvertedAt = &convertedAt
+		trial.SaleID = sale.ID
+		err := tr.saveLocked()
+		if err != nil {
+			log.Printf("❌ Error saving trials: %v", err)
+		}
+		return
+	}
+}
+
+// Stats returns each product's trial conversion, sorted by product.
+// Trials of a product's permalink count under the product when a sale has
+// tied the two together.
+func (tr *Trials) Stats() []TrialStats {
+	now := tr.clock.Now()
+	products := make(map[string]string) // permalink → product ID
+	for _, sale := range tr.sales.All() {
+		if sale.Permalink != "" {
+			products[sale.Permalink] = sale.Product
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	totals := make(map[string]*TrialStats)
+	days := make(map[string][]float64)
+	for _, trial := range tr.trials {
+		product := trial.Product
+		if id, ok := products[product]; ok {
+			product = id
+		}
+		stats := totals[product]
+		if stats == nil {
+			stats = &TrialStats{Product: product, ConvertedBySource: make(map[string]int), TrialsBySource: make(map[string]int)}
+			totals[product] = stats
+		}
+		stats.Trials++
+		stats.TrialsBySource[trial.Source]++
+		switch {
+		case trial.ConvertedAt != nil:
+			stats.Converted++
+			stats.ConvertedBySource[trial.Source]++
+			days[product] = append(days[product], trial.ConvertedAt.Sub(trial.StartedAt).Hours()/24)
+		case now.Sub(trial.StartedAt) < tr.Window:
+			stats.Active++
+		default:
+			stats.Lapsed++
+		}
+	}
+
+	all := make([]TrialStats, 0, len(totals))
+	for product, stats := range totals {
+		if decided := stats.Converted + stats.Lapsed; decided > 0 {
+			stats.Rate = float64(stats.Converted) / float64(decided)
+		}
+		stats.MedianDaysToPurchase = medianDays(days[product])
+		all = append(all, *stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Product < all[j].Product })
+	return all
+}
+
+// Handler serves GET /metrics/trials with each product's trial conversion
+func (tr *Trials) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"products": tr.Stats()})
+	})
+}
+
+func (tr *Trials) saveLocked() error {
+	err := writeJSONFile(tr.path, tr.trials)
+	if err != nil {
+		return err
+	}
+	tr.dirty = false
+	tr.lastSaved = tr.clock.Now()
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrialConversion(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	path := filepath.Join(t.TempDir(), "trials.json")
+	trials, err := NewTrials(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := NewUsageTelemetry(gb, sales, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage.Trials = trials
+
+	ids := NewSequentialIDs("py")
+	deliver := func(payload map[string]interface{}) {
+		t.Helper()
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+	sale := func(id, email, key string) {
+		deliver(map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": "app", "product_permalink": "shotkit", "email": email, "price": 29, "license_key": key, "sale_timestamp": clock.Now().Format(time.RFC3339)})
+	}
+
+	sale("s0", "old@example.com", "KEY-0")
+	deliver(map[string]interface{}{"resource_name": "trial_start", "product_permalink": "shotkit", "email": "Ada@Example.com", "source": "landing"})
+	deliver(map[string]interface{}{"resource_name": "trial_start", "product_id": "app", "email": "bo@example.com", "source": "landing"})
+	deliver(map[string]interface{}{"resource_name": "trial_start", "product_id": "app", "email": "old@example.com", "source": "landing"})
+	if err := usage.Ping(UsagePing{Product: "app", InstallID: "mac-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := usage.Ping(UsagePing{Product: "app", InstallID: "mac-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(3 * 24 * time.Hour)
+	sale("s1", "ada@example.com", "KEY-1")
+	clock.Advance(24 * time.Hour)
+	sale("s2", "cy@example.com", "KEY-2")
+	if err := usage.Ping(UsagePing{Product: "app", LicenseKey: "KEY-2", InstallID: "mac-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := trials.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewTrials(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := reopened.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	app := stats[0]
+	if app.Product != "app" || app.Trials != 4 || app.Converted != 2 || app.Active != 2 || app.Lapsed != 0 {
+		t.Fatalf("stats %+v", app)
+	}
+	if app.MedianDaysToPurchase != 3.5 || app.ConvertedBySource["landing"] != 1 || app.ConvertedBySource["telemetry"] != 1 {
+		t.Errorf("stats %+v", app)
+	}
+
+	clock.Advance(14 * 24 * time.Hour)
+	app = reopened.Stats()[0]
+	if app.Lapsed != 2 || app.Rate != 0.5 {
+		t.Errorf("after the window %+v", app)
+	}
+}
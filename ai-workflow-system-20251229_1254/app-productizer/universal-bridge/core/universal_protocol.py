@@ -5,12 +5,13 @@ Translates between any programming language and the AI Neural Spine
 """
 
 import json
+import gzip
 import struct
 import base64
 import hashlib
 import time
 import uuid
-from datetime import datetime
+from datetime import datetime, timezone
 from typing import Dict, Any, List, Optional, Union
 from enum import Enum
 import socket
@@ -28,6 +29,9 @@ class MessageType(Enum):
     DATA_SYNC = "data_sync"
     HEALTH_CHECK = "health_check"
     ERROR = "error"
+    STREAM_CHUNK = "stream_chunk"
+    ACK = "ack"
+    NACK = "nack"
 
 class CommunicationChannel(Enum):
     """Communication channels between languages"""
@@ -37,6 +41,13 @@ class CommunicationChannel(Enum):
     DATABASE = "database"
     BINARY_SOCKET = "binary_socket"
     SHARED_MEMORY = "shared_memory"
+    NATS = "nats"
+    GRPC = "grpc"
+
+def canonical_payload(payload: Dict[str, Any]) -> str:
+    """Serialize a payload the way every bridge checksums it: compact JSON,
+    keys sorted at every level, non-ASCII as raw UTF-8"""
+    return json.dumps(payload, sort_keys=True, separators=(',', ':'), ensure_ascii=False)
 
 class UniversalMessage:
     """Universal message format that any language can understand"""
@@ -55,16 +66,21 @@ class UniversalMessage:
         self.target_language = target_language
         self.payload = payload or {}
         self.response_channel = response_channel
+        self.headers: Dict[str, str] = {}  # hop metadata such as traceparent; not checksummed
+        self.expires_at: Optional[str] = None  # RFC 3339; not handled after it; not checksummed
+        self.priority: Optional[str] = None  # high, normal or low; the type's own when None; not checksummed
+        self.idempotency_key: Optional[str] = None  # copies with the same key are handled once; not checksummed
+        self.deliver_at: Optional[str] = None  # RFC 3339; not handled before it; not checksummed
         self.checksum = self._calculate_checksum()
     
     def _calculate_checksum(self) -> str:
         """Calculate message checksum for integrity"""
-        content = f"{self.id}{self.timestamp}{self.message_type.value}{json.dumps(self.payload, sort_keys=True)}"
-        return hashlib.md5(content.encode()).hexdigest()
+        content = f"{self.id}{self.timestamp}{self.message_type.value}{canonical_payload(self.payload)}"
+        return hashlib.md5(content.encode('utf-8')).hexdigest()
     
     def to_json(self) -> str:
         """Convert to JSON format"""
-        return json.dumps({
+        data = {
             "id": self.id,
             "timestamp": self.timestamp,
             "message_type": self.message_type.value,
@@ -73,7 +89,38 @@ class UniversalMessage:
             "payload": self.payload,
             "response_channel": self.response_channel.value,
             "checksum": self.checksum
-        }, indent=2)
+        }
+        if self.headers:
+            data["headers"] = self.headers
+        if self.expires_at:
+            data["expires_at"] = self.expires_at
+        if self.priority:
+            data["priority"] = self.priority
+        if self.idempotency_key:
+            data["idempotency_key"] = self.idempotency_key
+        if self.deliver_at:
+            data["deliver_at"] = self.deliver_at
+        return json.dumps(data, indent=2)
+    
+    def expired(self) -> bool:
+        """Whether expires_at has passed, so the message must not be handled"""
+        if not self.expires_at:
+            return False
+        expires_at = datetime.fromisoformat(self.expires_at.replace('Z', '+00:00'))
+        return datetime.now(timezone.utc) >= expires_at
+    
+    def due(self) -> bool:
+        """Whether deliver_at has come, so the message may be handled"""
+        if not self.deliver_at:
+            return True
+        deliver_at = datetime.fromisoformat(self.deliver_at.replace('Z', '+00:00'))
+        return datetime.now(timezone.utc) >= deliver_at
+    
+    def continue_trace(self, request: 'UniversalMessage'):
+        """Carry the request's trace context, so a reply joins its trace"""
+        for header in ('traceparent', 'tracestate'):
+            if header in request.headers:
+                self.headers[header] = request.headers[header]
     
     def to_binary(self) -> bytes:
         """Convert to binary format for performance"""
@@ -87,31 +134,41 @@ class UniversalMessage:
     def from_json(cls, json_str: str) -> 'UniversalMessage':
         """Create from JSON string"""
         data = json.loads(json_str)
+        payload = data['payload']
+        if data.get('content_encoding') == 'gzip':
+            # The checksum covers the payload as it was before compression
+            payload = json.loads(gzip.decompress(base64.b64decode(payload['data'])))
+        elif data.get('content_encoding'):
+            raise ValueError(f"Unsupported content encoding {data['content_encoding']}")
         
         msg = cls(
             MessageType(data['message_type']),
             data['source_language'],
             data['target_language'],
-            data['payload'],
+            payload,
             CommunicationChannel(data['response_channel'])
         )
         
         msg.id = data['id']
         msg.timestamp = data['timestamp']
+        msg.headers = data.get('headers') or {}
+        msg.expires_at = data.get('expires_at')
+        msg.priority = data.get('priority')
+        msg.idempotency_key = data.get('idempotency_key')
+        msg.deliver_at = data.get('deliver_at')
         msg.checksum = data['checksum']  # Use the stored checksum
         
         # Verify checksum by recalculating
         expected_checksum = msg._calculate_checksum_from_data()
         if expected_checksum != data['checksum']:
-            print(f"⚠️ Warning: Checksum mismatch (expected: {expected_checksum}, got: {data['checksum']})")
-            # Don't fail on checksum mismatch for now - just warn
+            raise ValueError(f"Message checksum mismatch (expected: {expected_checksum}, got: {data['checksum']}) - data may be corrupted")
         
         return msg
     
     def _calculate_checksum_from_data(self) -> str:
         """Calculate checksum from current data"""
-        content = f"{self.id}{self.timestamp}{self.message_type.value}{json.dumps(self.payload, sort_keys=True)}"
-        return hashlib.md5(content.encode()).hexdigest()
+        content = f"{self.id}{self.timestamp}{self.message_type.value}{canonical_payload(self.payload)}"
+        return hashlib.md5(content.encode('utf-8')).hexdigest()
     
     @classmethod
     def from_binary(cls, binary_data: bytes) -> 'UniversalMessage':
@@ -342,6 +399,7 @@ class UniversalBridge:
                 (message.id,)
             )
             self.db.commit()
+            self._send_receipt(message)
             
         except Exception as e:
             print(f"❌ Error handling message {message.id}: {e}")
@@ -350,6 +408,27 @@ class UniversalBridge:
                 (message.id,)
             )
             self.db.commit()
+            self._send_receipt(message, e)
+    
+    def _send_receipt(self, message: UniversalMessage, error: Exception = None):
+        """Answer a message whose ack header is "requested": an ack once
+        handled, a nack with the error once it failed"""
+        
+        if message.headers.get('ack') != 'requested' or message.message_type in (MessageType.ACK, MessageType.NACK):
+            return
+        payload = {'original_message_id': message.id}
+        if error is not None:
+            payload['error'] = str(error)
+        receipt = UniversalMessage(
+            MessageType.NACK if error is not None else MessageType.ACK,
+            "python",
+            message.source_language,
+            payload
+        )
+        try:
+            self._send_response(receipt, message.response_channel, message)
+        except Exception as e:
+            print(f"❌ Error sending receipt for {message.id}: {e}")
     
     def _handle_code_translation(self, message: UniversalMessage):
         """Handle code translation between languages"""
@@ -382,7 +461,7 @@ class UniversalBridge:
             }
         )
         
-        self._send_response(response, message.response_channel)
+        self._send_response(response, message.response_channel, message)
         
         print(f"🔄 Code translated: {from_lang} → {to_lang}")
     
@@ -393,9 +472,16 @@ class UniversalBridge:
         payload = message.payload
         
         # Simulate AI processing
+        ai_result = f"AI processed request from {message.source_language}"
+        
+        if payload.get('stream'):
+            self._stream_response(message, ai_result)
+            print(f"🤖 AI request streamed for {message.source_language}")
+            return
+        
         ai_response = {
             'original_message_id': message.id,
-            'ai_result': f"AI processed request from {message.source_language}",
+            'ai_result': ai_result,
             'data': payload,
             'timestamp': datetime.now().isoformat()
         }
@@ -407,10 +493,36 @@ class UniversalBridge:
             ai_response
         )
         
-        self._send_response(response, message.response_channel)
+        self._send_response(response, message.response_channel, message)
         
         print(f"🤖 AI request processed for {message.source_language}")
     
+    def _stream_response(self, message: UniversalMessage, text: str, chunk_words: int = 4):
+        """Send text back as stream_chunk messages, a few words at a time.
+        
+        Each chunk names the request in original_message_id and is numbered
+        from 0 in seq; the last has done set, so the receiver can reassemble
+        chunks that arrive out of order.
+        """
+        
+        words = text.split(' ')
+        pieces = [' '.join(words[i:i + chunk_words]) for i in range(0, len(words), chunk_words)]
+        pieces = [piece + ' ' for piece in pieces[:-1]] + pieces[-1:]
+        
+        for seq, piece in enumerate(pieces):
+            chunk = UniversalMessage(
+                MessageType.STREAM_CHUNK,
+                "ai_neural_spine",
+                message.source_language,
+                {
+                    'original_message_id': message.id,
+                    'seq': seq,
+                    'delta': piece,
+                    'done': seq == len(pieces) - 1
+                }
+            )
+            self._send_response(chunk, message.response_channel, message)
+    
     def _handle_function_call(self, message: UniversalMessage):
         """Handle function call between languages"""
         
@@ -437,7 +549,7 @@ class UniversalBridge:
                     }
                 )
                 
-                self._send_response(response, message.response_channel)
+                self._send_response(response, message.response_channel, message)
                 
             except Exception as e:
                 error_response = UniversalMessage(
@@ -451,13 +563,16 @@ class UniversalBridge:
                     }
                 )
                 
-                self._send_response(error_response, message.response_channel)
+                self._send_response(error_response, message.response_channel, message)
         
         print(f"📞 Function call: {function_name} ({message.source_language} → {message.target_language})")
     
-    def _send_response(self, response: UniversalMessage, channel: CommunicationChannel):
+    def _send_response(self, response: UniversalMessage, channel: CommunicationChannel, request: UniversalMessage = None):
         """Send response through specified channel"""
         
+        if request is not None:
+            response.continue_trace(request)
+        
         if channel == CommunicationChannel.FILE_SYSTEM:
             self._send_via_file_system(response)
         elif channel == CommunicationChannel.DATABASE:
@@ -513,10 +628,28 @@ class UniversalBridge:
             try:
                 for file_path in watch_dir.glob("*.json"):
                     try:
-                        with open(file_path, 'r') as f:
+                        with open(file_path, 'r', encoding='utf-8') as f:
                             message_data = f.read()
                         
-                        message = UniversalMessage.from_json(message_data)
+                        try:
+                            message = UniversalMessage.from_json(message_data)
+                        except ValueError as e:
+                            # A corrupt message would fail the same way on every scan
+                            print(f"❌ Rejecting {file_path}: {e}")
+                            rejected_dir = watch_dir / "rejected"
+                            rejected_dir.mkdir(exist_ok=True)
+                            file_path.rename(rejected_dir / file_path.name)
+                            continue
+                        if message.expired():
+                            # Stale, such as an AI request left while this bridge was down
+                            print(f"⌛ Skipping {file_path}, which expired at {message.expires_at}")
+                            expired_dir = watch_dir / "expired"
+                            expired_dir.mkdir(exist_ok=True)
+                            file_path.rename(expired_dir / file_path.name)
+                            continue
+                        if not message.due():
+                            # Left in the inbox, which keeps it across restarts, until due
+                            continue
                         self.message_queue.put(message)
                         
                         # Move processed file
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxUsageInstalls bounds how many app installs are tracked at once
+const DefaultMaxUsageInstalls = 50000
+
+const maxUsageEvents = 32 // distinct events counted per install
+
+// Errors Ping returns for pings it refuses
+var (
+	ErrUnknownLicense  = errors.New("license key not sold for this product")
+	ErrTooManyInstalls = errors.New("too many installs tracked")
+)
+
+// usageToken is what install IDs, event names and versions may look like
+var usageToken = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// UsagePing is one report from a sold app. InstallID is a random ID the app
+// makes once per machine; nothing else about the machine or its user is
+// sent or kept.
+type UsagePing struct {
+	Product    string // product ID or custom permalink
+	LicenseKey string
+	InstallID  string
+	Event      string // e.g. launch or export; empty counts as launch
+	Version    string
+}
+
+// UsageInstall is one install's activity. It is tied to the sale its
+// license key belongs to; the key itself is not kept.
+type UsageInstall struct {
+	Product    string         `json:"product"`
+	SaleID     string         `json:"sale_id"`
+	InstallID  string         `json:"install_id"`
+	Version    string         `json:"version,omitempty"`
+	FirstSeen  time.Time      `json:"first_seen"`
+	LastSeen   time.Time      `json:"last_seen"`
+	DaysActive int            `json:"days_active"`
+	Pings      int            `json:"pings"`
+	Events     map[string]int `json:"events,omitempty"`
+}
+
+// UsageStats is how a product's buyers use it. Licenses counts unrefunded
+// sales with a license key, and Activated those with at least one install.
+// An install is active over a window if it pinged within it; Stickiness is
+// daily over monthly active installs.
+type UsageStats struct {
+	Product              string         `json:"product"`
+	Licenses             int            `json:"licenses"`
+	Activated            int            `json:"activated"`
+	ActivationRate       float64        `json:"activation_rate"`
+	MedianDaysToActivate float64        `json:"median_days_to_activate"`
+	Installs             int            `json:"installs"`
+	DailyActive          int            `json:"daily_active"`
+	WeeklyActive         int            `json:"weekly_active"`
+	MonthlyActive        int            `json:"monthly_active"`
+	Stickiness           float64        `json:"stickiness"`
+	AverageDaysActive    float64        `json:"average_days_active"`
+	Pings                int            `json:"pings"`
+	Events               map[string]int `json:"events,omitempty"`
+	Versions             map[string]int `json:"versions,omitempty"` // installs by the version they last reported
+}
+
+// UsageTelemetry records usage pings from the apps the seller sells and
+// correlates them with the sales store. Only pings whose license key was
+// sold for the product are kept, at most MaxInstalls installs of them, and
+// they are saved at most every SaveInterval.
+type UsageTelemetry struct {
+	// MaxInstalls bounds the installs tracked; pings from new installs
+	// beyond it are refused
+	MaxInstalls int
+	// SaveInterval is how often pings are written to disk; Flush writes the
+	// rest
+	SaveInterval time.Duration
+	// Trials, when set, counts pings without a license key as trial starts
+	// of the install, converted once it pings with a key sold for the product
+	Trials *Trials
+
+	sales *SalesStore
+	clock Clock
+	path  string
+
+	mu        sync.Mutex
+	installs  map[string]*UsageInstall // product + install ID → install
+	licenses  map[string]string        // product + license key → sale ID
+	dirty     bool
+	lastSaved time.Time
+}
+
+// NewUsageTelemetry opens the installs persisted at path
+func NewUsageTelemetry(gb *GoBridge, sales *SalesStore, path string) (*UsageTelemetry, error) {
+	ut := &UsageTelemetry{
+		MaxInstalls:  DefaultMaxUsageInstalls,
+		SaveInterval: time.Minute,
+		sales:        sales,
+		clock:        gb.clock,
+		path:         path,
+		installs:     make(map[string]*UsageInstall),
+		licenses:     make(map[string]string),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read usage installs: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &ut.installs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse usage installs %s: %v", path, err)
+		}
+	}
+	return ut, nil
+}
+
+// Ping records a report from an install
+func (ut *UsageTelemetry) Ping(ping UsagePing) error {
+	if ping.LicenseKey == "" && ut.Trials != nil {
+		return ut.Trials.StartTrial(TrialStart{Product: ping.Product, InstallID: ping.InstallID, Source: "telemetry"})
+	}
+	if ping.Product == "" || ping.LicenseKey == "" || !usageToken.MatchString(ping.InstallID) {
+		return fmt.Errorf("usage ping needs a product, a license key and an install ID of up to 64 letters, digits or ._:-")
+	}
+	event := ping.Event
+	if event == "" {
+		event = "launch"
+	}
+	if !usageToken.MatchString(event) || (ping.Version != "" && !usageToken.MatchString(ping.Version)) {
+		return fmt.Errorf("usage ping event and version must be up to 64 letters, digits or ._:-")
+	}
+	sale, ok := ut.licensedSale(ping.Product, ping.LicenseKey)
+	if !ok {
+		return ErrUnknownLicense
+	}
+	if ut.Trials != nil {
+		ut.Trials.convertInstall(sale, ping.InstallID)
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	now := ut.clock.Now()
+	key := visitKey(sale.Product, ping.InstallID)
+	install := ut.installs[key]
+	if install == nil {
+		if ut.MaxInstalls > 0 && len(ut.installs) >= ut.MaxInstalls {
+			return ErrTooManyInstalls
+		}
+		install = &UsageInstall{Product: sale.Product, InstallID: ping.InstallID, FirstSeen: now}
+		ut.installs[key] = install
+	}
+	if install.Pings == 0 || usageDay(install.LastSeen) != usageDay(now) {
+		install.DaysActive++
+	}
+	install.SaleID = sale.ID
+	if ping.Version != "" {
+		install.Version = ping.Version
+	}
+	install.LastSeen = now
+	install.Pings++
+	if install.Events == nil {
+		install.Events = make(map[string]int)
+	}
+	if _, counted := install.Events[event]; counted || len(install.Events) < maxUsageEvents {
+		install.Events[event]++
+	}
+
+	ut.dirty = true
+	if now.Sub(ut.lastSaved) < ut.SaveInterval {
+		return nil
+	}
+	return ut.saveLocked()
+}
+
+// licensedSale finds the sale the license key was sold with, remembering
+// keys it found so repeat pings do not scan the sales store
+func (ut *UsageTelemetry) licensedSale(product, licenseKey string) (Sale, bool) {
+	key := product + "\x00" + licenseKey
+	ut.mu.Lock()
+	saleID, known := ut.licenses[key]
+	ut.mu.Unlock()
+	if known {
+		return ut.sales.Sale(saleID)
+	}
+
+	matches := ut.sales.Sales(func(sale Sale) bool {
+		return (sale.Product == product || sale.Permalink == product) && stringArg(sale.Fields, "license_key") == licenseKey
+	})
+	if len(matches) == 0 {
+		return Sale{}, false
+	}
+	ut.mu.Lock()
+	ut.licenses[key] = matches[0].ID
+	ut.mu.Unlock()
+	return matches[0], true
+}
+
+// Flush writes pings not yet saved
+func (ut *UsageTelemetry) Flush() error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if !ut.dirty {
+		return nil
+	}
+	return ut.saveLocked()
+}
+
+// Stats returns each licensed product's activation and engagement, sorted
+// by product
+func (ut *UsageTelemetry) Stats() []UsageStats {
+	now := ut.clock.Now()
+	totals := make(map[string]*UsageStats)
+	statsFor := func(product string) *UsageStats {
+		stats := totals[product]
+		if stats == nil {
+			stats = &UsageStats{Product: product, Events: make(map[string]int), Versions: make(map[string]int)}
+			totals[product] = stats
+		}
+		return stats
+	}
+
+	licensed := make(map[string]Sale)
+	for _, sale := range ut.sales.Sales(func(sale Sale) bool {
+		return !sale.Refunded && stringArg(sale.Fields, "license_key") != ""
+	}) {
+		licensed[sale.ID] = sale
+		statsFor(sale.Product).Licenses++
+	}
+
+	ut.mu.Lock()
+	firstSeen := make(map[string]time.Time) // sale ID → its first install's first ping
+	daysActive := make(map[string]int)
+	for _, install := range ut.installs {
+		stats := statsFor(install.Product)
+		stats.Installs++
+		stats.Pings += install.Pings
+		daysActive[install.Product] += install.DaysActive
+		for event, count := range install.Events {
+			stats.Events[event] += count
+		}
+		if install.Version != "" {
+			stats.Versions[install.Version]++
+		}
+		idle := now.Sub(install.LastSeen)
+		if idle < 24*time.Hour {
+			stats.DailyActive++
+		}
+		if idle < 7*24*time.Hour {
+			stats.WeeklyActive++
+		}
+		if idle < 30*24*time.Hour {
+			stats.MonthlyActive++
+		}
+		if first, ok := firstSeen[install.SaleID]; !ok || install.FirstSeen.Before(first) {
+			firstSeen[install.SaleID] = install.FirstSeen
+		}
+	}
+	ut.mu.Unlock()
+
+	daysToActivate := make(map[string][]float64)
+	for saleID, first := range firstSeen {
+		sale, ok := licensed[saleID]
+		if !ok {
+			continue
+		}
+		statsFor(sale.Product).Activated++
+		if !sale.CreatedAt.IsZero() && !first.Before(sale.CreatedAt) {
+			daysToActivate[sale.Product] = append(daysToActivate[sale.Product], first.Sub(sale.CreatedAt).Hours()/24)
+		}
+	}
+
+	all := make([]UsageStats, 0, len(totals))
+	for product, stats := range totals {
+		if stats.Licenses > 0 {
+			stats.ActivationRate = float64(stats.Activated) / float64(stats.Licenses)
+		}
+		if stats.MonthlyActive > 0 {
+			stats.Stickiness = float64(stats.DailyActive) / float64(stats.MonthlyActive)
+		}
+		if stats.Installs > 0 {
+			stats.AverageDaysActive = float64(daysActive[product]) / float64(stats.Installs)
+		}
+		stats.MedianDaysToActivate = medianDays(daysToActivate[product])
+		all = append(all, *stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Product < all[j].Product })
+	return all
+}
+
+// Handler serves GET /metrics/usage with each product's usage
+func (ut *UsageTelemetry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"products": ut.Stats()})
+	})
+}
+
+// PingHandler serves POST /usage for sold apps: product_id, license_key,
+// install_id and optionally event and version, as form parameters or a JSON
+// object. It answers 204, or 404 for a key not sold for the product. With
+// Trials set, pings without a license_key start the install's trial.
+func (ut *UsageTelemetry) PingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		var ping UsagePing
+		if strings.HasPrefix(r.Header.Get("Content-Type"), JSONContentType) {
+			var body map[string]interface{}
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&body)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "bad JSON body")
+				return
+			}
+			ping = UsagePing{
+				Product:    stringArg(body, "product_id"),
+				LicenseKey: stringArg(body, "license_key"),
+				InstallID:  stringArg(body, "install_id"),
+				Event:      stringArg(body, "event"),
+				Version:    stringArg(body, "version"),
+			}
+		} else {
+			r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+			ping = UsagePing{
+				Product:    r.FormValue("product_id"),
+				LicenseKey: r.FormValue("license_key"),
+				InstallID:  r.FormValue("install_id"),
+				Event:      r.FormValue("event"),
+				Version:    r.FormValue("version"),
+			}
+		}
+
+		err := ut.Ping(ping)
+		switch {
+		case errors.Is(err, ErrUnknownLicense):
+			writeAPIError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrTooManyInstalls), errors.Is(err, ErrTooManyTrials):
+			writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		case err != nil:
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+func (ut *UsageTelemetry) saveLocked() error {
+	err := writeJSONFile(ut.path, ut.installs)
+	if err != nil {
+		return err
+	}
+	ut.dirty = false
+	ut.lastSaved = ut.clock.Now()
+	return nil
+}
+
+// usageDay is the UTC date an install was active on
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// medianDays returns the median of the values, or 0 for none
+func medianDays(days []float64) float64 {
+	if len(days) == 0 {
+		return 0
+	}
+	sort.Float64s(days)
+	middle := len(days) / 2
+	if len(days)%2 == 1 {
+		return days[middle]
+	}
+	return (days[middle-1] + days[middle]) / 2
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageTelemetry(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sale := range []map[string]interface{}{
+		{"sale_id": "s1", "product_id": "app", "product_permalink": "shotkit", "email": "ada@example.com", "price": 29, "license_key": "KEY-1", "sale_timestamp": "2026-01-13T09:30:00Z"},
+		{"sale_id": "s2", "product_id": "app", "email": "bo@example.com", "price": 29, "license_key": "KEY-2", "sale_timestamp": "2026-01-14T09:30:00Z"},
+		{"sale_id": "s3", "product_id": "app", "email": "cy@example.com", "price": 29, "license_key": "KEY-3"},
+		{"sale_id": "s4", "product_id": "app", "email": "di@example.com", "price": 29, "license_key": "KEY-4", "refunded": true},
+		{"sale_id": "s5", "product_id": "ebook", "email": "ada@example.com", "price": 9},
+	} {
+		if _, err := sales.Record(sale); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "installs.json")
+	usage, err := NewUsageTelemetry(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := usage.PingHandler()
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        int
+	}{
+		{"form", url.Values{"product_id": {"shotkit"}, "license_key": {"KEY-1"}, "install_id": {"mac-1"}, "version": {"1.2.0"}}.Encode(), "application/x-www-form-urlencoded", http.StatusNoContent},
+		{"JSON", `{"product_id":"app","license_key":"KEY-1","install_id":"win-1","event":"export","version":"1.1.0"}`, JSONContentType, http.StatusNoContent},
+		{"second key", `{"product_id":"app","license_key":"KEY-2","install_id":"mac-2"}`, JSONContentType, http.StatusNoContent},
+		{"key for another product", `{"product_id":"ebook","license_key":"KEY-1","install_id":"mac-1"}`, JSONContentType, http.StatusNotFound},
+		{"made-up key", `{"product_id":"app","license_key":"KEY-9","install_id":"mac-9"}`, JSONContentType, http.StatusNotFound},
+		{"bad install ID", `{"product_id":"app","license_key":"KEY-1","install_id":"<script>"}`, JSONContentType, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/usage", strings.NewReader(tt.body))
+		request.Header.Set("Content-Type", tt.contentType)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, recorder.Code, tt.want, recorder.Body)
+		}
+	}
+
+	// mac-1 keeps being used; mac-2 goes quiet
+	for day := 1; day <= 3; day++ {
+		clock.Advance(24 * time.Hour)
+		if err := usage.Ping(UsagePing{Product: "app", LicenseKey: "KEY-1", InstallID: "mac-1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := usage.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewUsageTelemetry(gb, sales, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := reopened.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	app := stats[0]
+	if app.Product != "app" || app.Licenses != 3 || app.Activated != 2 || app.Installs != 3 || app.Pings != 6 {
+		t.Fatalf("stats %+v", app)
+	}
+	if app.ActivationRate != 2.0/3 || app.MedianDaysToActivate != 1.5 {
+		t.Errorf("activation rate %v after a median %v days, want 2/3 after 1.5", app.ActivationRate, app.MedianDaysToActivate)
+	}
+	if app.DailyActive != 1 || app.WeeklyActive != 3 || app.MonthlyActive != 3 || app.AverageDaysActive != 2 {
+		t.Errorf("engagement %+v", app)
+	}
+	if app.Events["launch"] != 5 || app.Events["export"] != 1 || app.Versions["1.2.0"] != 1 || app.Versions["1.1.0"] != 1 {
+		t.Errorf("events %v versions %v", app.Events, app.Versions)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WaitlistEntry is one signup for a product that has not launched yet
+type WaitlistEntry struct {
+	Email       string     `json:"email"`
+	Locale      string     `json:"locale,omitempty"`
+	JoinedAt    time.Time  `json:"joined_at"`
+	NotifiedAt  *time.Time `json:"notified_at,omitempty"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty"`
+	SaleID      string     `json:"sale_id,omitempty"`
+	UsedCode    bool       `json:"used_code,omitempty"` // bought with the launch code
+}
+
+// Waitlist is a product's signups and, once launched, its launch offer
+type Waitlist struct {
+	Product     string                    `json:"product"`
+	ProductName string                    `json:"product_name,omitempty"`
+	Entries     map[string]*WaitlistEntry `json:"entries"` // email → entry
+	LaunchedAt  *time.Time                `json:"launched_at,omitempty"`
+	Code        string                    `json:"code,omitempty"`
+	CodeExpires *time.Time                `json:"code_expires,omitempty"`
+}
+
+// WaitlistStats is how a waitlist converted to buyers
+type WaitlistStats struct {
+	Product        string     `json:"product"`
+	Signups        int        `json:"signups"`
+	Notified       int        `json:"notified"`
+	Converted      int        `json:"converted"`
+	UsedCode       int        `json:"used_code"`
+	ConversionRate float64    `json:"conversion_rate"` // converted / signups
+	LaunchedAt     *time.Time `json:"launched_at,omitempty"`
+}
+
+// Launch describes a product launch to the waitlist: the discount and how
+// long the launch code stays valid
+type Launch struct {
+	Product     string
+	ProductName string
+	URL         string // product page; the code is appended to it
+	AmountOff   float64
+	OfferType   string // "cents" or "percent"
+	ExpiresIn   time.Duration
+}
+
+// Waitlists collects signups per product and, at launch, emails everyone a
+// time-limited offer code, then tracks which signups go on to buy
+type Waitlists struct {
+	bridge    *GoBridge
+	templates *TemplateStore
+	offers    *OfferCodes
+	optOuts   *OptOutList
+	path      string
+
+	mu    sync.Mutex
+	lists map[string]*Waitlist
+}
+
+// NewWaitlists opens the waitlists persisted at path. It listens for
+// data_sync messages with resource_name waitlist_signup and product_launch,
+// and for sales from waitlisted buyers.
+func NewWaitlists(gb *GoBridge, templates *TemplateStore, offers *OfferCodes, optOuts *OptOutList, path string) (*Waitlists, error) {
+	w := &Waitlists{
+		bridge:    gb,
+		templates: templates,
+		offers:    offers,
+		optOuts:   optOuts,
+		path:      path,
+		lists:     make(map[string]*Waitlist),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read waitlists: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &w.lists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse waitlists %s: %v", path, err)
+		}
+	}
+
+	gb.OnReceive(w.handleMessage)
+	return w, nil
+}
+
+func (w *Waitlists) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+
+	payload := message.Payload
+	switch stringArg(payload, "resource_name") {
+	case "waitlist_signup":
+		err := w.Join(productKey(payload), stringArg(payload, "email"), DetectLocale(payload))
+		if err != nil {
+			log.Printf("❌ Error adding waitlist signup from %s: %v", message.ID, err)
+		}
+	case "product_launch":
+		launch, err := launchFromArgs(payload)
+		if err == nil {
+			err = w.Launch(launch)
+		}
+		if err != nil {
+			log.Printf("❌ Error launching to waitlist from %s: %v", message.ID, err)
+		}
+	case "sale":
+		if sale, err := ParseSale(payload); err == nil {
+			w.recordSale(sale)
+		}
+	}
+}
+
+// Join adds an email to a product's waitlist; joining twice is harmless
+func (w *Waitlists) Join(product, email, locale string) error {
+	email = NormalizeEmail(email)
+	if product == "" || !strings.Contains(email, "@") {
+		return fmt.Errorf("waitlist signup needs a product and an email")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := w.lists[product]
+	if list == nil {
+		list = &Waitlist{Product: product, Entries: make(map[string]*WaitlistEntry)}
+		w.lists[product] = list
+	}
+	if _, joined := list.Entries[email]; joined {
+		return nil
+	}
+	list.Entries[email] = &WaitlistEntry{Email: email, Locale: locale, JoinedAt: w.bridge.clock.Now()}
+	fmt.Printf("📋 %s joined the %s waitlist (%d signups)\n", email, product, len(list.Entries))
+	return w.saveLocked()
+}
+
+// Launch creates the launch offer code and emails it to the waitlist in the
+// background. A product launches once; later calls return an error.
+func (w *Waitlists) Launch(launch Launch) error {
+	if w.bridge.Sink("email") == nil {
+		return fmt.Errorf("no email sink registered")
+	}
+
+	w.mu.Lock()
+	list := w.lists[launch.Product]
+	switch {
+	case list == nil:
+		w.mu.Unlock()
+		return fmt.Errorf("no waitlist for %s", launch.Product)
+	case list.LaunchedAt != nil:
+		w.mu.Unlock()
+		return fmt.Errorf("%s already launched", launch.Product)
+	}
+	now := w.bridge.clock.Now()
+	list.LaunchedAt = &now
+	list.ProductName = launch.ProductName
+	w.saveLocked()
+	w.mu.Unlock()
+
+	expires := now.Add(launch.ExpiresIn)
+	offer, err := w.offers.Create(OfferCode{
+		Product:   launch.Product,
+		Code:      "EARLY-" + strings.ToUpper(randomToken()[:8]),
+		Campaign:  "waitlist-" + launch.Product,
+		AmountOff: launch.AmountOff,
+		OfferType: launch.OfferType,
+		ExpiresAt: &expires,
+		OnRetire:  OfferDelete,
+	})
+	if err != nil {
+		w.mu.Lock()
+		list.LaunchedAt = nil
+		w.saveLocked()
+		w.mu.Unlock()
+		return err
+	}
+
+	w.mu.Lock()
+	list.Code = offer.Code
+	list.CodeExpires = &expires
+	var pending []WaitlistEntry
+	for _, entry := range list.Entries {
+		if entry.NotifiedAt == nil {
+			pending = append(pending, *entry)
+		}
+	}
+	w.saveLocked()
+	w.mu.Unlock()
+
+	sort.Slice(pending, func(i, j int) bool { return pending[i].JoinedAt.Before(pending[j].JoinedAt) })
+	fmt.Printf("🚀 Launching %s to %d waitlist signups with code %s\n", launch.Product, len(pending), offer.Code)
+	go w.send(launch, offer, pending)
+	return nil
+}
+
+// send queues one launch email per signup, pacing on a full email sink
+func (w *Waitlists) send(launch Launch, offer OfferCode, entries []WaitlistEntry) {
+	sink := w.bridge.Sink("email")
+	offerURL := ""
+	if launch.URL != "" {
+		offerURL = strings.TrimSuffix(launch.URL, "/") + "/" + offer.Code
+	}
+
+	for _, entry := range entries {
+		if w.optOuts != nil && w.optOuts.Contains(entry.Email) {
+			continue
+		}
+
+		data := map[string]interface{}{
+			"email":        entry.Email,
+			"product":      launch.Product,
+			"product_name": launch.ProductName,
+			"url":          launch.URL,
+			"offer_url":    offerURL,
+			"code":         offer.Code,
+			"discount":     formatDiscount(offer.AmountOff, offer.OfferType),
+			"expires_at":   offer.ExpiresAt.Format("2006-01-02 15:04 MST"),
+		}
+		text, err := w.templates.RenderLocale("waitlist_launch", launch.Product, entry.Locale, data)
+		if err == nil {
+			message := w.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+				"to":     entry.Email,
+				"text":   text,
+				"locale": entry.Locale,
+			}, FileSystem)
+			err = sink.Enqueue(message)
+			for err == ErrSinkFull {
+				<-w.bridge.clock.After(time.Second)
+				err = sink.Enqueue(message)
+			}
+		}
+		if err != nil {
+			log.Printf("❌ Launch email for %s to %s failed: %v", launch.Product, entry.Email, err)
+			continue
+		}
+
+		w.mu.Lock()
+		now := w.bridge.clock.Now()
+		w.lists[launch.Product].Entries[entry.Email].NotifiedAt = &now
+		w.mu.Unlock()
+	}
+
+	w.mu.Lock()
+	err := w.saveLocked()
+	w.mu.Unlock()
+	if err != nil {
+		log.Printf("❌ Error saving waitlists: %v", err)
+	}
+	fmt.Printf("✅ Launch emails for %s queued\n", launch.Product)
+}
+
+// recordSale marks a waitlisted buyer as converted
+func (w *Waitlists) recordSale(sale Sale) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := w.lists[sale.Product]
+	if list == nil {
+		list = w.lists[sale.Permalink]
+	}
+	if list == nil {
+		return
+	}
+	entry := list.Entries[sale.Email]
+	if entry == nil || entry.ConvertedAt != nil {
+		return
+	}
+	now := w.bridge.clock.Now()
+	entry.ConvertedAt = &now
+	entry.SaleID = sale.ID
+	entry.UsedCode = list.Code != "" && strings.EqualFold(sale.OfferCode, list.Code)
+	err := w.saveLocked()
+	if err != nil {
+		log.Printf("❌ Error saving waitlists: %v", err)
+	}
+}
+
+// Stats returns every waitlist's conversion, by product
+func (w *Waitlists) Stats() []WaitlistStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	all := make([]WaitlistStats, 0, len(w.lists))
+	for _, list := range w.lists {
+		stats := WaitlistStats{Product: list.Product, Signups: len(list.Entries), LaunchedAt: list.LaunchedAt}
+		for _, entry := range list.Entries {
+			if entry.NotifiedAt != nil {
+				stats.Notified++
+			}
+			if entry.ConvertedAt != nil {
+				stats.Converted++
+			}
+			if entry.UsedCode {
+				stats.UsedCode++
+			}
+		}
+		if stats.Signups > 0 {
+			stats.ConversionRate = float64(stats.Converted) / float64(stats.Signups)
+		}
+		all = append(all, stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Product < all[j].Product })
+	return all
+}
+
+// Joined returns the products whose waitlist the email is on, sorted
+func (w *Waitlists) Joined(email string) []string {
+	email = NormalizeEmail(email)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var products []string
+	for product, list := range w.lists {
+		if _, ok := list.Entries[email]; ok {
+			products = append(products, product)
+		}
+	}
+	sort.Strings(products)
+	return products
+}
+
+// Emails returns every email on any waitlist
+func (w *Waitlists) Emails() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var emails []string
+	for _, list := range w.lists {
+		for email := range list.Entries {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// Handler serves GET /metrics/waitlists with each waitlist's conversion
+func (w *Waitlists) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(rw, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(rw, http.StatusOK, map[string]interface{}{"waitlists": w.Stats()})
+	})
+}
+
+func (w *Waitlists) saveLocked() error {
+	return writeJSONFile(w.path, w.lists)
+}
+
+// RegisterWaitlistJobs adds the launch_waitlist job handler, so a launch can
+// be scheduled ahead of time. Its args are those of a product_launch message:
+// product_id, product_name, url, amount_off, offer_type and expires_in.
+func RegisterWaitlistJobs(s *Scheduler, waitlists *Waitlists) {
+	s.Handle("launch_waitlist", func(job Job) error {
+		launch, err := launchFromArgs(job.Args)
+		if err != nil {
+			return err
+		}
+		return waitlists.Launch(launch)
+	})
+}
+
+// launchFromArgs reads a launch; the code is valid for 72h unless expires_in says otherwise
+func launchFromArgs(args map[string]interface{}) (Launch, error) {
+	launch := Launch{
+		Product:     productKey(args),
+		ProductName: stringArg(args, "product_name"),
+		URL:         stringArg(args, "url"),
+		OfferType:   stringArg(args, "offer_type"),
+		ExpiresIn:   72 * time.Hour,
+	}
+	if launch.Product == "" {
+		launch.Product = stringArg(args, "product")
+	}
+	if launch.ProductName == "" {
+		launch.ProductName = launch.Product
+	}
+	launch.AmountOff, _ = toNumber(args["amount_off"])
+	if value := stringArg(args, "expires_in"); value != "" {
+		expiresIn, err := time.ParseDuration(value)
+		if err != nil {
+			return Launch{}, fmt.Errorf("bad expires_in: %v", err)
+		}
+		launch.ExpiresIn = expiresIn
+	}
+	if launch.Product == "" || launch.AmountOff <= 0 {
+		return Launch{}, fmt.Errorf("launch needs a product and amount_off")
+	}
+	return launch, nil
+}
+
+// formatDiscount renders an offer's amount off for emails: "20%" or "5.00"
+func formatDiscount(amountOff float64, offerType string) string {
+	if offerType == "percent" {
+		return fmt.Sprintf("%g%%", amountOff)
+	}
+	return NewMoney(int64(math.Round(amountOff)), defaultCurrency).Decimal()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitNotified waits until n signups on the product's waitlist are notified
+func waitNotified(t *testing.T, w *Waitlists, product string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		for _, stats := range w.Stats() {
+			if stats.Product == product && stats.Notified >= n {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waitlists %+v, want %d notified for %s", w.Stats(), n, product)
+		}
+	}
+}
+
+func TestWaitlistJoin(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	waitlists, err := NewWaitlists(gb, nil, nil, nil, filepath.Join(t.TempDir(), "waitlists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range [][2]string{{"", "ada@example.com"}, {"course", "ada"}, {"course", ""}} {
+		if err := waitlists.Join(invalid[0], invalid[1], ""); err == nil {
+			t.Errorf("joined %q to %q", invalid[1], invalid[0])
+		}
+	}
+	for _, signup := range [][2]string{{"course", "Ada@example.com"}, {"course", "ada@example.com"}, {"ebook", "ada@example.com"}, {"course", "bob@example.com"}} {
+		if err := waitlists.Join(signup[0], signup[1], ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := waitlists.Joined(" ADA@example.com"); !reflect.DeepEqual(got, []string{"course", "ebook"}) {
+		t.Errorf("ada joined %v, want course and ebook", got)
+	}
+	emails := waitlists.Emails()
+	sort.Strings(emails)
+	if !reflect.DeepEqual(emails, []string{"ada@example.com", "ada@example.com", "bob@example.com"}) {
+		t.Errorf("emails %v, want one per signup", emails)
+	}
+	if stats := waitlists.Stats(); len(stats) != 2 || stats[0].Product != "course" || stats[0].Signups != 2 || stats[1].Signups != 1 {
+		t.Errorf("stats %+v, want two course signups and one ebook", stats)
+	}
+}
+
+func TestWaitlistLaunch(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	offers, api := newTestOfferCodes(t, filepath.Join(dir, "offers.json"), clock)
+	optOuts, err := NewOptOutList(filepath.Join(dir, "optouts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "waitlists.json")
+	waitlists, err := NewWaitlists(gb, NewTemplateStore("templates"), offers, optOuts, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := NewSequentialIDs("gumroad")
+	receive := func(payload map[string]interface{}) {
+		t.Helper()
+		if err := transport.Inject(newUniversalMessage(clock, ids, DataSync, "gumroad", "go", payload, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive(map[string]interface{}{"resource_name": "waitlist_signup", "product_id": "course", "email": "ada@example.com", "locale": "de"})
+	clock.Advance(time.Minute)
+	receive(map[string]interface{}{"resource_name": "waitlist_signup", "product_id": "course", "email": "bob@example.com"})
+	receive(map[string]interface{}{"resource_name": "waitlist_signup", "product_id": "course", "email": "carol@example.com"})
+	receive(map[string]interface{}{"resource_name": "waitlist_signup", "product_id": "course"}) // no email
+	if err := optOuts.Add("carol@example.com", "unsubscribed"); err != nil {
+		t.Fatal(err)
+	}
+
+	launch := Launch{Product: "course", ProductName: "The Course", URL: "https://example.gumroad.com/l/course/", AmountOff: 20, OfferType: "percent", ExpiresIn: 48 * time.Hour}
+	if err := waitlists.Launch(launch); err == nil || !strings.Contains(err.Error(), "email sink") {
+		t.Errorf("launched without an email sink: %v", err)
+	}
+
+	emails := make(chan map[string]interface{}, 10)
+	gb.AddSink("email", SinkConfig{}, func(message *UniversalMessage) error {
+		emails <- message.Payload
+		return nil
+	})
+	if err := waitlists.Launch(Launch{Product: "ebook", AmountOff: 5}); err == nil {
+		t.Error("launched a product with no waitlist")
+	}
+	api.set(func() { api.down = true })
+	if err := waitlists.Launch(launch); err == nil {
+		t.Error("launched when the offer code could not be created")
+	}
+	api.set(func() { api.down = false })
+	receive(map[string]interface{}{"resource_name": "product_launch", "product_id": "course", "product_name": "The Course",
+		"url": "https://example.gumroad.com/l/course/", "amount_off": 20, "offer_type": "percent", "expires_in": "48h"})
+	waitNotified(t, waitlists, "course", 2)
+
+	waitlists.mu.Lock()
+	code, expires := waitlists.lists["course"].Code, *waitlists.lists["course"].CodeExpires
+	waitlists.mu.Unlock()
+	if !strings.HasPrefix(code, "EARLY-") || !expires.Equal(clock.Now().Add(48*time.Hour)) {
+		t.Errorf("launch code %s expiring %s, want EARLY- valid for 48h", code, expires)
+	}
+	if created := offers.List(); len(created) != 1 || created[0].Code != code || created[0].Campaign != "waitlist-course" || created[0].OnRetire != OfferDelete {
+		t.Errorf("offer codes %+v, want the launch code deleted on retire", created)
+	}
+
+	sent := make(map[string]map[string]interface{})
+	for len(sent) < 2 {
+		select {
+		case email := <-emails:
+			sent[stringArg(email, "to")] = email
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sent %v, want emails to ada and bob", sent)
+		}
+	}
+	ada, bob := stringArg(sent["ada@example.com"], "text"), stringArg(sent["bob@example.com"], "text")
+	if !strings.Contains(ada, "Warteliste") || !strings.Contains(ada, "20% Rabatt mit dem Code "+code) {
+		t.Errorf("ada's email %q, want German with the code", ada)
+	}
+	if !strings.Contains(bob, "with code "+code) || !strings.Contains(bob, "https://example.gumroad.com/l/course/"+code) || !strings.Contains(bob, "2026-01-17 09:31 UTC") {
+		t.Errorf("bob's email %q, want the code, offer URL and expiry", bob)
+	}
+	if _, ok := sent["carol@example.com"]; ok {
+		t.Error("emailed an opted-out signup")
+	}
+	if err := waitlists.Launch(launch); err == nil || !strings.Contains(err.Error(), "already launched") {
+		t.Errorf("launched twice: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	for _, sale := range []map[string]interface{}{
+		{"resource_name": "sale", "sale_id": "s1", "product_id": "course", "email": "ada@example.com", "offer_code": strings.ToLower(code), "price": "40"},
+		{"resource_name": "sale", "sale_id": "s2", "product_id": "course", "email": "bob@example.com", "price": "50"},
+		{"resource_name": "sale", "sale_id": "s3", "product_id": "course", "email": "bob@example.com", "price": "50"},
+		{"resource_name": "sale", "sale_id": "s4", "product_id": "course", "email": "dave@example.com", "price": "50"},
+	} {
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", sale, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := WaitlistStats{Product: "course", Signups: 3, Notified: 2, Converted: 2, UsedCode: 1, ConversionRate: 2.0 / 3}
+	stats := waitlists.Stats()
+	if len(stats) != 1 || stats[0].LaunchedAt == nil {
+		t.Fatalf("stats %+v, want the launched course", stats)
+	}
+	want.LaunchedAt = stats[0].LaunchedAt
+	if !reflect.DeepEqual(stats[0], want) {
+		t.Errorf("stats %+v, want %+v", stats[0], want)
+	}
+	waitlists.mu.Lock()
+	bobEntry := *waitlists.lists["course"].Entries["bob@example.com"]
+	waitlists.mu.Unlock()
+	if bobEntry.SaleID != "s2" || bobEntry.UsedCode {
+		t.Errorf("bob %+v, want converted by his first sale, without the code", bobEntry)
+	}
+
+	for _, tt := range []struct {
+		method string
+		status int
+	}{{http.MethodGet, http.StatusOK}, {http.MethodPost, http.StatusMethodNotAllowed}} {
+		response := httptest.NewRecorder()
+		waitlists.Handler().ServeHTTP(response, httptest.NewRequest(tt.method, "/metrics/waitlists", nil))
+		if response.Code != tt.status {
+			t.Errorf("%s = %d, want %d", tt.method, response.Code, tt.status)
+		}
+		if response.Code != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Waitlists []WaitlistStats `json:"waitlists"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || len(body.Waitlists) != 1 || body.Waitlists[0].UsedCode != 1 {
+			t.Errorf("metrics %s (%v)", response.Body.String(), err)
+		}
+	}
+
+	reopened, err := NewWaitlists(gb, nil, nil, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Stats(); len(got) != 1 || got[0].Converted != 2 || got[0].Notified != 2 || got[0].LaunchedAt == nil {
+		t.Errorf("after reopening %+v", got)
+	}
+}
+
+func TestRegisterWaitlistJobs(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	gb := NewGoBridge("", WithClock(clock), WithTransport(NewMemoryTransport()), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	waitlists, err := NewWaitlists(gb, nil, nil, nil, filepath.Join(t.TempDir(), "waitlists.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler("", clock)
+	RegisterWaitlistJobs(scheduler, waitlists)
+
+	launch := scheduler.handlers["launch_waitlist"]
+	if err := launch(Job{Args: map[string]interface{}{"product": "course"}}); err == nil || !strings.Contains(err.Error(), "amount_off") {
+		t.Errorf("launch without amount_off: %v", err)
+	}
+	if err := launch(Job{Args: map[string]interface{}{"product": "course", "amount_off": 500}}); err == nil || !strings.Contains(err.Error(), "email sink") {
+		t.Errorf("launch without an email sink: %v", err)
+	}
+}
+
+func TestLaunchFromArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    Launch
+		wantErr bool
+	}{
+		{"defaults", map[string]interface{}{"product_id": "course", "amount_off": 500},
+			Launch{Product: "course", ProductName: "course", AmountOff: 500, ExpiresIn: 72 * time.Hour}, false},
+		{"everything", map[string]interface{}{"product": "course", "product_name": "The Course", "url": "https://example.gumroad.com/l/course", "amount_off": "20", "offer_type": "percent", "expires_in": "24h"},
+			Launch{Product: "course", ProductName: "The Course", URL: "https://example.gumroad.com/l/course", AmountOff: 20, OfferType: "percent", ExpiresIn: 24 * time.Hour}, false},
+		{"no product", map[string]interface{}{"amount_off": 500}, Launch{}, true},
+		{"no discount", map[string]interface{}{"product": "course"}, Launch{}, true},
+		{"bad expires_in", map[string]interface{}{"product": "course", "amount_off": 500, "expires_in": "soon"}, Launch{}, true},
+	}
+	for _, tt := range tests {
+		got, err := launchFromArgs(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: launchFromArgs = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatDiscount(t *testing.T) {
+	tests := []struct {
+		amountOff float64
+		offerType string
+		want      string
+	}{
+		{20, "percent", "20%"},
+		{12.5, "percent", "12.5%"},
+		{500, "cents", "5.00"},
+		{1999, "", "19.99"},
+	}
+	for _, tt := range tests {
+		if got := formatDiscount(tt.amountOff, tt.offerType); got != tt.want {
+			t.Errorf("formatDiscount(%g, %q) = %q, want %q", tt.amountOff, tt.offerType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	gumroadSellerEnv        = "GUMROAD_SELLER_ID"
+	gumroadWebhookSecretEnv = "GUMROAD_WEBHOOK_SECRET"
+	gumroadSignatureHeader  = "X-Gumroad-Signature"
+	maxWebhookBody          = 1 << 20
+)
+
+// gumroadResources are the ping resource_names the webhook accepts
+var gumroadResources = map[string]bool{
+	"sale":                   true,
+	"refund":                 true,
+	"dispute":                true,
+	"dispute_won":            true,
+	"cancellation":           true,
+	"subscription_updated":   true,
+	"subscription_ended":     true,
+	"subscription_restarted": true,
+}
+
+// GumroadWebhook receives Gumroad ping POSTs at /webhook/gumroad and
+// dispatches each one into the bridge as a SaleEvent message, so the same
+// handlers that process relayed data_sync sales see it
+type GumroadWebhook struct {
+	// SellerID, when set, must match the ping's seller_id
+	SellerID string
+	// Secret must be sent as the ping URL's secret query parameter, or used
+	// as the HMAC-SHA256 key of an X-Gumroad-Signature body signature. Pings
+	// are refused while it is empty, since they grant buyers access.
+	Secret string
+
+	bridge *GoBridge
+	server *http.Server
+}
+
+// NewGumroadWebhook creates the receiver with the seller and secret from the
+// environment
+func NewGumroadWebhook(gb *GoBridge) *GumroadWebhook {
+	return &GumroadWebhook{
+		SellerID: os.Getenv(gumroadSellerEnv),
+		Secret:   os.Getenv(gumroadWebhookSecretEnv),
+		bridge:   gb,
+	}
+}
+
+// Handler serves POST /webhook/gumroad
+func (wh *GumroadWebhook) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook/gumroad", wh.handlePing)
+	return mux
+}
+
+// Serve serves the webhook on the listener until Close
+func (wh *GumroadWebhook) Serve(listener net.Listener) {
+	wh.server = &http.Server{Handler: wh.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	fmt.Printf("🌐 Gumroad webhook on %s\n", listener.Addr())
+
+	go func() {
+		err := wh.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ Webhook stopped: %v", err)
+		}
+	}()
+}
+
+// Close waits up to timeout for in-flight pings, then stops the webhook
+func (wh *GumroadWebhook) Close(timeout time.Duration) error {
+	if wh.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return wh.server.Shutdown(ctx)
+}
+
+// handlePing answers 200 once the event is handled, so Gumroad retries
+// pings the bridge failed to process; retries carry the same message ID and
+// are deduplicated
+func (wh *GumroadWebhook) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "ping body too large")
+		return
+	}
+	if !wh.authentic(r, body) {
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid signature")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("bad form body: %v", err))
+		return
+	}
+	if wh.SellerID != "" && form.Get("seller_id") != wh.SellerID {
+		writeAPIError(w, http.StatusForbidden, "ping is for another seller")
+		return
+	}
+
+	message, err := wh.convert(form, body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fmt.Printf("🛒 Gumroad %s ping %s\n", message.Payload["resource_name"], message.ID)
+	err = wh.bridge.Deliver(message, HTTP)
+	if err != nil {
+		log.Printf("❌ Error handling Gumroad ping %s: %v", message.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "ping not processed")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"id": message.ID})
+}
+
+// authentic checks the secret, accepting a body signature or the URL secret
+func (wh *GumroadWebhook) authentic(r *http.Request, body []byte) bool {
+	if wh.Secret == "" {
+		return false
+	}
+	if signature := r.Header.Get(gumroadSignatureHeader); signature != "" {
+		mac := hmac.New(sha256.New, []byte(wh.Secret))
+		mac.Write(body)
+		want := hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(strings.ToLower(signature)), []byte(want))
+	}
+	secret := r.URL.Query().Get("secret")
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(wh.Secret)) == 1
+}
+
+// convert turns a ping form into a SaleEvent message. Bracketed keys such
+// as variants[Tier] become nested objects, which win over a flat field of
+// the same name; "true" and "false" become booleans; and price, which pings
+// give in cents, is written in major units as the rest of the bridge reads it.
+func (wh *GumroadWebhook) convert(form url.Values, body []byte) (*UniversalMessage, error) {
+	payload := make(map[string]interface{})
+	for key, values := range form {
+		if len(values) == 0 {
+			continue
+		}
+		// JSON would replace invalid bytes, so the checksum would not match
+		if !utf8.ValidString(key) || !utf8.ValidString(values[len(values)-1]) {
+			return nil, fmt.Errorf("ping field %q is not valid UTF-8", key)
+		}
+		value := formValue(values[len(values)-1])
+		if open := strings.Index(key, "["); open > 0 && strings.HasSuffix(key, "]") {
+			name, field := key[:open], key[open+1:len(key)-1]
+			nested, _ := payload[name].(map[string]interface{})
+			if nested == nil {
+				nested = make(map[string]interface{})
+				payload[name] = nested
+			}
+			nested[field] = value
+			continue
+		}
+		if _, nested := payload[key].(map[string]interface{}); nested {
+			continue
+		}
+		payload[key] = value
+	}
+
+	resource := stringArg(payload, "resource_name")
+	if resource == "" {
+		resource = "sale" // the plain Ping setting sends sales without one
+	}
+	if !gumroadResources[resource] {
+		return nil, fmt.Errorf("unsupported resource_name %q", resource)
+	}
+	payload["resource_name"] = resource
+
+	if cents := form.Get("price"); cents != "" {
+		amount, err := strconv.ParseInt(cents, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q", cents)
+		}
+		currency, err := parseCurrency(form.Get("currency"))
+		if err != nil {
+			return nil, err
+		}
+		payload["price"] = NewMoney(amount, currency).Decimal()
+	}
+
+	message := newUniversalMessage(wh.bridge.clock, webhookID(body), SaleEvent, "gumroad", "go", payload, HTTP)
+	return message, nil
+}
+
+// formValue reads a ping's booleans as booleans
+func formValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
+
+// webhookID names a ping by its body, so Gumroad's retries of one ping share
+// a message ID and the pipeline drops the duplicates
+type webhookID []byte
+
+func (body webhookID) NewID() string {
+	hash := sha256.Sum256(body)
+	return "gumroad-" + hex.EncodeToString(hash[:16])
+}
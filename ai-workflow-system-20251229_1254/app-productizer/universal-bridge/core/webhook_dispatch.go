@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Headers on every outgoing webhook POST
+const (
+	webhookEventHeader     = "X-Bridge-Event"     // the message type
+	webhookDeliveryHeader  = "X-Bridge-Delivery"  // the message ID, the same on every retry
+	webhookTimestampHeader = "X-Bridge-Timestamp" // Unix seconds, part of what is signed
+	webhookSignatureHeader = "X-Bridge-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">
+)
+
+// Which messages an endpoint is sent
+const (
+	WebhookReceived = "received"
+	WebhookSent     = "sent"
+	WebhookBoth     = "both"
+)
+
+// defaultWebhookAttempts is how often a POST is tried before it is dropped
+const defaultWebhookAttempts = 5
+
+// webhookTypes are the message types an endpoint may select; receipts and
+// other bridge plumbing are not forwarded
+var webhookTypes = append([]MessageType{CodeTranslation}, automationTypes...)
+
+// WebhookEndpoint is an HTTPS URL selected messages are POSTed to
+type WebhookEndpoint struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Secret is the HMAC-SHA256 key of the X-Bridge-Signature header,
+	// and may name environment variables as ${NAME}; without one the POSTs
+	// are unsigned
+	Secret string `yaml:"secret"`
+	// Types defaults to sales, AI responses and errors
+	Types []MessageType `yaml:"types"`
+	// Direction is received (the default), sent or both
+	Direction string `yaml:"direction"`
+	// When holds rule conditions on the payload, all of which must match
+	When        []RuleCondition `yaml:"when"`
+	MaxAttempts int             `yaml:"max_attempts"`
+}
+
+// wants reports whether the endpoint is sent the message
+func (e WebhookEndpoint) wants(message *UniversalMessage, direction string) bool {
+	if e.Direction != WebhookBoth && e.Direction != direction {
+		return false
+	}
+	accepted := false
+	for _, t := range e.Types {
+		accepted = accepted || t == message.MessageType
+	}
+	if !accepted {
+		return false
+	}
+	for _, condition := range e.When {
+		if !condition.Matches(message.Payload) {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookConfig lists the endpoints messages are fanned out to, loaded
+// from YAML:
+//
+//	endpoints:
+//	  - name: zapier
+//	    url: https://hooks.zapier.com/hooks/catch/123/abc
+//	    secret: ${ZAPIER_WEBHOOK_SECRET}
+//	    types: [sale_event]          # the default is sale_event, ai_response, error
+//	    direction: received          # or sent, or both
+//	    when:
+//	      - field: product_permalink
+//	        in: [course-pro]
+//	    max_attempts: 5              # the default
+type WebhookConfig struct {
+	Endpoints []WebhookEndpoint `yaml:"endpoints"`
+}
+
+// LoadWebhookConfig reads the endpoints, expanding environment variables
+// in their secrets
+func LoadWebhookConfig(path string) (WebhookConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return WebhookConfig{}, fmt.Errorf("failed to read webhook config: %v", err)
+	}
+
+	var config WebhookConfig
+	err = yaml.Unmarshal(content, &config)
+	if err != nil {
+		return WebhookConfig{}, fmt.Errorf("failed to parse webhook config %s: %v", path, err)
+	}
+	invalid := func(format string, args ...interface{}) (WebhookConfig, error) {
+		return WebhookConfig{}, fmt.Errorf("invalid webhook config %s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	if len(config.Endpoints) == 0 {
+		return invalid("no endpoints")
+	}
+	names := make(map[string]bool)
+	for i := range config.Endpoints {
+		endpoint := &config.Endpoints[i]
+		if endpoint.Name == "" {
+			endpoint.Name = fmt.Sprintf("endpoint-%d", i+1)
+		}
+		if names[endpoint.Name] {
+			return invalid("two endpoints are named %s", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+		if parsed, err := url.Parse(endpoint.URL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return invalid("%s: url %q is not an https URL", endpoint.Name, endpoint.URL)
+		}
+		if secret := endpoint.Secret; secret != "" {
+			endpoint.Secret = os.ExpandEnv(secret)
+			if endpoint.Secret == "" {
+				return invalid("%s: secret %s is empty", endpoint.Name, secret)
+			}
+		}
+		if endpoint.Types == nil {
+			endpoint.Types = []MessageType{SaleEvent, AIResponse, Error}
+		}
+		for _, t := range endpoint.Types {
+			if !hasMessageType(webhookTypes, t) {
+				return invalid("%s: unknown message type %q", endpoint.Name, t)
+			}
+		}
+		switch endpoint.Direction {
+		case "":
+			endpoint.Direction = WebhookReceived
+		case WebhookReceived, WebhookSent, WebhookBoth:
+		default:
+			return invalid("%s: direction %q is not received, sent or both", endpoint.Name, endpoint.Direction)
+		}
+		if endpoint.MaxAttempts < 0 {
+			return invalid("%s: max_attempts is negative", endpoint.Name)
+		}
+		if endpoint.MaxAttempts == 0 {
+			endpoint.MaxAttempts = defaultWebhookAttempts
+		}
+	}
+	return config, nil
+}
+
+// hasMessageType reports whether types holds t
+func hasMessageType(types []MessageType, t MessageType) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDispatcher POSTs the messages each endpoint selected, as JSON, to
+// tools such as Zapier and Make. Each endpoint has its own "webhook:<name>"
+// sink, so a slow or failing one is retried behind its own circuit breaker
+// without holding up the others. A signed POST carries
+//
+//	X-Bridge-Signature: sha256=<hex HMAC-SHA256 of "<X-Bridge-Timestamp>.<body>">
+//
+// and receivers should refuse timestamps more than a few minutes old.
+type WebhookDispatcher struct {
+	Config     WebhookConfig
+	HTTPClient *http.Client
+
+	bridge *GoBridge
+	clock  Clock
+	sinks  map[string]*Sink
+	// sent skips the copies of a sent message a resend would forward again
+	sent *dedupeCache
+}
+
+// NewWebhookDispatcher forwards the messages the bridge receives and sends
+func NewWebhookDispatcher(gb *GoBridge, config WebhookConfig) *WebhookDispatcher {
+	wd := &WebhookDispatcher{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		bridge:     gb,
+		clock:      gb.clock,
+		sinks:      make(map[string]*Sink),
+		sent:       newDedupeCache(gb.clock, 24*time.Hour, 10000, ""),
+	}
+	for _, endpoint := range config.Endpoints {
+		endpoint := endpoint
+		wd.sinks[endpoint.Name] = gb.AddSink("webhook:"+endpoint.Name, SinkConfig{MaxAttempts: endpoint.MaxAttempts}, func(message *UniversalMessage) error {
+			return wd.deliver(endpoint, message)
+		})
+	}
+	gb.OnReceive(func(message *UniversalMessage) { wd.dispatch(message, WebhookReceived) })
+	gb.OnSend(func(message *UniversalMessage) {
+		if wd.sent.begin(message.ID) == dedupeNew {
+			wd.sent.complete(message.ID)
+			wd.dispatch(message, WebhookSent)
+		}
+	})
+	return wd
+}
+
+// dispatch queues the message for every endpoint that selected it. The
+// body is encoded now, as later hooks may still change the message.
+func (wd *WebhookDispatcher) dispatch(message *UniversalMessage, direction string) {
+	var body []byte
+	for _, endpoint := range wd.Config.Endpoints {
+		if !endpoint.wants(message, direction) {
+			continue
+		}
+		if body == nil {
+			var err error
+			body, err = json.Marshal(map[string]interface{}{
+				"id":        message.ID,
+				"type":      message.MessageType,
+				"direction": direction,
+				"source":    message.SourceLanguage,
+				"target":    message.TargetLanguage,
+				"timestamp": message.Timestamp,
+				"data":      message.Payload,
+			})
+			if err != nil {
+				log.Printf("❌ Error encoding %s for webhooks: %v", message.ID, err)
+				return
+			}
+		}
+		post := wd.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+			"message_id":   message.ID,
+			"message_type": string(message.MessageType),
+			"body":         string(body),
+		}, FileSystem)
+		if err := wd.sinks[endpoint.Name].Enqueue(post); err != nil {
+			log.Printf("❌ Error queueing %s for webhook %s: %v", message.ID, endpoint.Name, err)
+		}
+	}
+}
+
+// deliver POSTs one queued message to the endpoint, signed when it has a
+// secret; any status outside 2xx is an error, so the sink retries
+func (wd *WebhookDispatcher) deliver(endpoint WebhookEndpoint, post *UniversalMessage) error {
+	id := stringArg(post.Payload, "message_id")
+	body := []byte(stringArg(post.Payload, "body"))
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "universal-bridge-webhooks")
+	request.Header.Set(webhookEventHeader, stringArg(post.Payload, "message_type"))
+	request.Header.Set(webhookDeliveryHeader, id)
+	timestamp := strconv.FormatInt(wd.clock.Now().Unix(), 10)
+	request.Header.Set(webhookTimestampHeader, timestamp)
+	if endpoint.Secret != "" {
+		request.Header.Set(webhookSignatureHeader, webhookSignature(endpoint.Secret, timestamp, body))
+	}
+
+	response, err := wd.HTTPClient.Do(request)
+	if err != nil {
+		// Not the whole error, which names the URL and any token in it
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting %s to %s: %v", id, endpoint.Name, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("posting %s to %s: answered %s: %s", id, endpoint.Name, response.Status, strings.TrimSpace(string(detail)))
+	}
+	fmt.Printf("🪝 Posted %s (%s) to webhook %s\n", id, stringArg(post.Payload, "message_type"), endpoint.Name)
+	return nil
+}
+
+// webhookSignature signs a POST body as the X-Bridge-Signature header
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
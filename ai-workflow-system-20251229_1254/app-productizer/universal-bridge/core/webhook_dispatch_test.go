@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadWebhookConfig(t *testing.T) {
+	t.Setenv("ZAPIER_SECRET", "s3cret")
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"defaults", "endpoints:\n  - url: https://hooks.zapier.com/hooks/catch/1/a\n    secret: ${ZAPIER_SECRET}\n", ""},
+		{"no endpoints", "endpoints: []\n", "no endpoints"},
+		{"plain http", "endpoints:\n  - url: http://hooks.zapier.com/hooks/catch/1/a\n", "is not an https URL"},
+		{"same name", "endpoints:\n  - name: a\n    url: https://a.example.com\n  - name: a\n    url: https://b.example.com\n", "two endpoints are named a"},
+		{"unset secret", "endpoints:\n  - url: https://a.example.com\n    secret: ${NO_SUCH_SECRET}\n", "secret ${NO_SUCH_SECRET} is empty"},
+		{"unknown type", "endpoints:\n  - url: https://a.example.com\n    types: [sale]\n", `unknown message type "sale"`},
+		{"receipts", "endpoints:\n  - url: https://a.example.com\n    types: [ack]\n", `unknown message type "ack"`},
+		{"unknown direction", "endpoints:\n  - url: https://a.example.com\n    direction: out\n", `direction "out" is not received, sent or both`},
+		{"bad condition", "endpoints:\n  - url: https://a.example.com\n    when:\n      - field: price\n        above: 10\n", `unknown operator "above"`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "webhooks.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadWebhookConfig(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		endpoint := config.Endpoints[0]
+		if endpoint.Name != "endpoint-1" || endpoint.Secret != "s3cret" || endpoint.Direction != WebhookReceived || len(endpoint.Types) != 3 || endpoint.MaxAttempts != defaultWebhookAttempts {
+			t.Errorf("%s: got %+v, want the defaults", tt.name, endpoint)
+		}
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	type post struct {
+		path, event, delivery, body string
+		signed                      bool
+	}
+	var mu sync.Mutex
+	var posts []post
+	failed := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/flaky" && !failed {
+			failed = true
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		signature := webhookSignature("s3cret", r.Header.Get(webhookTimestampHeader), body)
+		posts = append(posts, post{r.URL.Path, r.Header.Get(webhookEventHeader), r.Header.Get(webhookDeliveryHeader), string(body), r.Header.Get(webhookSignatureHeader) == signature})
+	}))
+	// Closed after the bridge, which waits for the posts to go out
+	t.Cleanup(server.Close)
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	var course []RuleCondition
+	if err := yaml.Unmarshal([]byte("- field: product_permalink\n  equals: course\n"), &course); err != nil {
+		t.Fatal(err)
+	}
+	wd := NewWebhookDispatcher(gb, WebhookConfig{Endpoints: []WebhookEndpoint{
+		{Name: "zapier", URL: server.URL + "/zapier", Secret: "s3cret", Types: []MessageType{SaleEvent}, Direction: WebhookReceived, When: course, MaxAttempts: 3},
+		{Name: "errors", URL: server.URL + "/flaky", Types: []MessageType{Error, SaleEvent}, Direction: WebhookSent, MaxAttempts: 3},
+	}})
+	wd.HTTPClient = server.Client()
+
+	ids := NewSequentialIDs("gumroad")
+	for _, product := range []string{"course", "ebook"} {
+		if err := transport.Inject(newUniversalMessage(clock, ids, SaleEvent, "gumroad", "go", map[string]interface{}{"resource_name": "sale", "product_permalink": product}, HTTP)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failure := gb.NewMessage(Error, "python", map[string]interface{}{"error": "license server down"}, FileSystem)
+	for i := 0; i < 2; i++ {
+		// The resend is not posted again
+		if _, err := gb.SendMessage(failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gb.SendMessage(gb.NewMessage(DataSync, "python", map[string]interface{}{"text": "not selected"}, FileSystem)); err != nil {
+		t.Fatal(err)
+	}
+	gb.Close()
+
+	sort.Slice(posts, func(i, j int) bool { return posts[i].path > posts[j].path })
+	if len(posts) != 2 {
+		t.Fatalf("posted %+v, want the course sale and the error", posts)
+	}
+	tests := []struct {
+		got        post
+		wantPath   string
+		wantEvent  MessageType
+		wantID     string
+		wantSigned bool
+	}{
+		{posts[0], "/zapier", SaleEvent, "gumroad-000001", true},
+		{posts[1], "/flaky", Error, failure.ID, false},
+	}
+	for _, tt := range tests {
+		var body map[string]interface{}
+		json.Unmarshal([]byte(tt.got.body), &body)
+		if tt.got.path != tt.wantPath || tt.got.event != string(tt.wantEvent) || tt.got.delivery != tt.wantID || body["id"] != tt.wantID || body["type"] != string(tt.wantEvent) || tt.got.signed != tt.wantSigned {
+			t.Errorf("posted %+v, want %s of %s to %s, signed %v", tt.got, tt.wantEvent, tt.wantID, tt.wantPath, tt.wantSigned)
+		}
+	}
+	var sale map[string]interface{}
+	json.Unmarshal([]byte(posts[0].body), &sale)
+	if data, _ := sale["data"].(map[string]interface{}); sale["direction"] != WebhookReceived || data["product_permalink"] != "course" {
+		t.Errorf("posted body %s, want the payload as data", posts[0].body)
+	}
+	for _, stats := range gb.SinkStats() {
+		if stats.Name == "webhook:errors" && (stats.Failed != 1 || stats.Delivered != 1) {
+			t.Errorf("errors endpoint %+v, want one retried failure", stats)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebhookAuthentication(t *testing.T) {
+	const body = "sale_id=s1&email=a%40example.com&price=2999&currency=usd"
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name       string
+		secret     string
+		query      string
+		signature  string
+		wantStatus int
+	}{
+		{"no secret configured", "", "", "", http.StatusUnauthorized},
+		{"no secret configured, empty URL secret", "", "?secret=", "", http.StatusUnauthorized},
+		{"URL secret", "shh", "?secret=shh", "", http.StatusOK},
+		{"wrong URL secret", "shh", "?secret=guess", "", http.StatusUnauthorized},
+		{"body signature", "shh", "", signature, http.StatusOK},
+		{"upper-case signature", "shh", "", strings.ToUpper(signature), http.StatusOK},
+		{"bad signature", "shh", "?secret=shh", strings.Repeat("0", 64), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+			t.Cleanup(func() { gb.Close() })
+			wh := NewGumroadWebhook(gb)
+			wh.SellerID = ""
+			wh.Secret = tt.secret
+
+			request := httptest.NewRequest(http.MethodPost, "/webhook/gumroad"+tt.query, strings.NewReader(body))
+			if tt.signature != "" {
+				request.Header.Set(gumroadSignatureHeader, tt.signature)
+			}
+			response := httptest.NewRecorder()
+			wh.Handler().ServeHTTP(response, request)
+			if response.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", response.Code, tt.wantStatus, response.Body)
+			}
+		})
+	}
+}
+
+func TestWebhookConvertKeepsBracketedFields(t *testing.T) {
+	gb := NewGoBridge("", WithTransport(NewMemoryTransport()))
+	t.Cleanup(func() { gb.Close() })
+	wh := NewGumroadWebhook(gb)
+
+	form := url.Values{
+		"sale_id":                {"s1"},
+		"price":                  {"2999"},
+		"currency":               {"usd"},
+		"test":                   {"true"},
+		"custom_fields":          {"github_username=octocat"},
+		"custom_fields[GitHub]":  {"octocat"},
+		"custom_fields[Discord]": {"octo#1"},
+		"variants[Tier]":         {"Pro"},
+	}
+	// form is a map, so repeat to cover both orders of the flat and
+	// bracketed custom_fields keys
+	for i := 0; i < 50; i++ {
+		message, err := wh.convert(form, []byte("body"))
+		if err != nil {
+			t.Fatalf("convert: %v", err)
+		}
+		fields := mapArg(message.Payload, "custom_fields")
+		if stringArg(fields, "GitHub") != "octocat" || stringArg(fields, "Discord") != "octo#1" || len(fields) != 2 {
+			t.Fatalf("custom_fields = %#v, want the bracketed GitHub and Discord fields", message.Payload["custom_fields"])
+		}
+		if tier := stringArg(mapArg(message.Payload, "variants"), "Tier"); tier != "Pro" {
+			t.Fatalf("variants[Tier] = %q, want Pro", tier)
+		}
+		if message.Payload["test"] != true || message.Payload["price"] != "29.99" {
+			t.Fatalf("test = %#v, price = %#v, want true and 29.99", message.Payload["test"], message.Payload["price"])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client's key to prove the server speaks
+// WebSocket (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseProtocol  = 1002
+	wsCloseTooBig    = 1009
+	wsCloseTryAgain  = 1013
+)
+
+const (
+	// maxWebSocketFrame bounds what a client may send in one frame
+	maxWebSocketFrame = 64 << 10
+	wsWriteTimeout    = 10 * time.Second
+)
+
+var (
+	// errWebSocketProtocol is a frame no conforming client sends
+	errWebSocketProtocol = errors.New("websocket protocol error")
+	// errWebSocketTooBig is a frame over maxWebSocketFrame
+	errWebSocketTooBig = errors.New("websocket frame too big")
+)
+
+// webSocket is the server end of a WebSocket connection. Writes may come
+// from several goroutines; reads from one.
+type webSocket struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+// upgradeWebSocket completes the opening handshake. When the request is not
+// a WebSocket handshake it answers with an error and returns one, so the
+// handler need only return.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+		return nil, fmt.Errorf("websocket handshake with %s", r.Method)
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		writeAPIError(w, http.StatusUpgradeRequired, "connect with a WebSocket client")
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeAPIError(w, http.StatusBadRequest, "unsupported WebSocket version")
+		return nil, fmt.Errorf("websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		writeAPIError(w, http.StatusBadRequest, "bad Sec-WebSocket-Key")
+		return nil, fmt.Errorf("bad websocket key %q", key)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "the server cannot upgrade this connection")
+		return nil, fmt.Errorf("response writer cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over the connection: %v", err)
+	}
+
+	// The server's read and write timeouts no longer apply
+	conn.SetDeadline(time.Time{})
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err = conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to answer the websocket handshake: %v", err)
+	}
+	return &webSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header lists the token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends a text message in a single frame
+func (ws *webSocket) WriteText(text []byte) error {
+	return ws.writeFrame(wsText, text)
+}
+
+// writeFrame sends one unmasked, unfragmented frame, as servers do
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// ReadFrame reads one frame. Fragments of a message come back as separate
+// frames, the later ones with wsContinuation.
+func (ws *webSocket) ReadFrame() (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+		return 0, nil, err
+	}
+	final := head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	length := uint64(head[1] & 0x7F)
+	// No extensions are negotiated, so the reserved bits must be clear, and
+	// every client frame must be masked
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		return 0, nil, errWebSocketProtocol
+	}
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+	case wsClose, wsPing, wsPong:
+		if !final || length > 125 {
+			return 0, nil, errWebSocketProtocol
+		}
+	default:
+		return 0, nil, errWebSocketProtocol
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err = io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err = io.ReadFull(ws.reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxWebSocketFrame {
+		return 0, nil, errWebSocketTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Close sends a close frame with the code and reason, then closes the
+// connection without waiting for the client's reply
+func (ws *webSocket) Close(code uint16, reason string) {
+	ws.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, code)
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		ws.writeFrame(wsClose, append(payload, reason...))
+		ws.conn.Close()
+	})
+}
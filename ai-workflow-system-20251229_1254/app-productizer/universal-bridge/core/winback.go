@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Win-back triggers
+const (
+	WinBackChurned  = "churned"  // a membership ended
+	WinBackRefunded = "refunded" // a purchase was refunded
+)
+
+// DefaultMaxEnrollments bounds how many win-back enrollments are kept
+const DefaultMaxEnrollments = 50000
+
+// ErrTooManyEnrollments is returned by Enroll when MaxEnrollments are kept
+var ErrTooManyEnrollments = errors.New("too many win-back enrollments")
+
+// WinBackSet is a list of win-back campaigns loaded from YAML. A campaign's
+// steps are sent after its cooling-off, counted from when the customer
+// churned or was refunded; a purchase within window of the last step
+// counts as won back.
+//
+//	campaigns:
+//	  - name: lapsed_members
+//	    trigger: churned
+//	    products: [club]
+//	    cooling_off: 336h
+//	    offer_code: COMEBACK20
+//	    steps:
+//	      - after: 0s
+//	        template: winback
+//	      - after: 168h
+//	        template: winback
+type WinBackSet struct {
+	Campaigns []WinBackCampaign `yaml:"campaigns"`
+}
+
+// WinBackCampaign is one win-back flow
+type WinBackCampaign struct {
+	Name    string `yaml:"name"`
+	Trigger string `yaml:"trigger"`
+	// Products limits the campaign to churn or refunds of these product IDs
+	// or permalinks; empty is every product
+	Products   []string      `yaml:"products"`
+	CoolingOff time.Duration `yaml:"cooling_off"`
+	Steps      []WinBackStep `yaml:"steps"`
+	// OfferCode is passed to the templates as offer_code
+	OfferCode string `yaml:"offer_code"`
+	// Window is how long after the last step a purchase still counts; 30
+	// days if unset
+	Window time.Duration `yaml:"window"`
+}
+
+// WinBackStep is one email of a campaign, sent After the cooling-off ends
+type WinBackStep struct {
+	After    time.Duration `yaml:"after"`
+	Template string        `yaml:"template"`
+}
+
+// WinBackEnrollment is a customer in a campaign and how far they got
+type WinBackEnrollment struct {
+	Campaign    string     `json:"campaign"`
+	Email       string     `json:"email"`
+	Product     string     `json:"product,omitempty"`
+	ProductName string     `json:"product_name,omitempty"`
+	Locale      string     `json:"locale,omitempty"`
+	TriggeredAt time.Time  `json:"triggered_at"`
+	Steps       int        `json:"steps"` // emails sent so far
+	OptedOut    bool       `json:"opted_out,omitempty"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty"`
+	SaleID      string     `json:"sale_id,omitempty"`
+	Revenue     *Money     `json:"revenue,omitempty"`
+}
+
+// WinBackStats is how a campaign's enrollments turned out. Won counts
+// customers who came back after an email, Returned those who came back
+// during the cooling-off, before any; Active ones can still do either.
+type WinBackStats struct {
+	Campaign string      `json:"campaign"`
+	Enrolled int         `json:"enrolled"`
+	Messaged int         `json:"messaged"`
+	Won      int         `json:"won"`
+	Returned int         `json:"returned"`
+	Active   int         `json:"active"`
+	Lost     int         `json:"lost"`
+	Rate     float64     `json:"conversion_rate"` // won over messaged
+	Revenue  MoneyTotals `json:"revenue"`         // of the won purchases
+}
+
+// LoadWinBack reads and validates a win-back campaigns file
+func LoadWinBack(path string) (*WinBackSet, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read win-back campaigns: %v", err)
+	}
+	var set WinBackSet
+	err = yaml.Unmarshal(content, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse win-back campaigns %s: %v", path, err)
+	}
+	err = set.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &set, nil
+}
+
+// Validate reports the first campaign that cannot run
+func (set *WinBackSet) Validate() error {
+	seen := make(map[string]bool)
+	for i, campaign := range set.Campaigns {
+		if !languageName.MatchString(campaign.Name) {
+			return fmt.Errorf("campaign %d: name %q is not a lowercase name such as lapsed_members", i+1, campaign.Name)
+		}
+		if seen[campaign.Name] {
+			return fmt.Errorf("campaign %s is defined twice", campaign.Name)
+		}
+		seen[campaign.Name] = true
+		if campaign.Trigger != WinBackChurned && campaign.Trigger != WinBackRefunded {
+			return fmt.Errorf("campaign %s: trigger %q is not %s or %s", campaign.Name, campaign.Trigger, WinBackChurned, WinBackRefunded)
+		}
+		if campaign.CoolingOff < 0 || campaign.Window < 0 {
+			return fmt.Errorf("campaign %s: cooling_off and window must not be negative", campaign.Name)
+		}
+		if len(campaign.Steps) == 0 {
+			return fmt.Errorf("campaign %s has no steps", campaign.Name)
+		}
+		for j, step := range campaign.Steps {
+			if step.Template == "" {
+				return fmt.Errorf("campaign %s: step %d has no template", campaign.Name, j+1)
+			}
+			if step.After < 0 || (j > 0 && step.After < campaign.Steps[j-1].After) {
+				return fmt.Errorf("campaign %s: step %d is sent before the one ahead of it", campaign.Name, j+1)
+			}
+		}
+	}
+	return nil
+}
+
+// due returns when the enrollment's next step is sent
+func (campaign WinBackCampaign) due(enrollment *WinBackEnrollment) time.Time {
+	return enrollment.TriggeredAt.Add(campaign.CoolingOff + campaign.Steps[enrollment.Steps].After)
+}
+
+// deadline returns the last moment a purchase still wins the customer back
+func (campaign WinBackCampaign) deadline(enrollment *WinBackEnrollment) time.Time {
+	window := campaign.Window
+	if window == 0 {
+		window = 30 * 24 * time.Hour
+	}
+	last := campaign.Steps[len(campaign.Steps)-1].After
+	return enrollment.TriggeredAt.Add(campaign.CoolingOff + last + window)
+}
+
+func (campaign WinBackCampaign) covers(products ...string) bool {
+	if len(campaign.Products) == 0 {
+		return true
+	}
+	for _, wanted := range campaign.Products {
+		for _, product := range products {
+			if product != "" && product == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WinBack enrolls customers whose membership ended or who were refunded in
+// the campaigns for it, emails each step once the cooling-off is over and
+// counts who buys again. It needs the customer on subscription events, so
+// create it after Customers.
+type WinBack struct {
+	// MaxEnrollments bounds the enrollments kept; triggers that would add
+	// more are dropped
+	MaxEnrollments int
+
+	bridge    *GoBridge
+	sales     *SalesStore
+	templates *TemplateStore
+	optOuts   *OptOutList
+	campaigns map[string]WinBackCampaign
+	names     []string
+	path      string
+
+	mu          sync.Mutex
+	enrollments map[string][]*WinBackEnrollment // email → enrollments, oldest first
+}
+
+// NewWinBack opens the enrollments persisted at path. It listens for
+// subscription_ended, refund and sale messages.
+func NewWinBack(gb *GoBridge, sales *SalesStore, templates *TemplateStore, optOuts *OptOutList, set *WinBackSet, path string) (*WinBack, error) {
+	wb := &WinBack{
+		MaxEnrollments: DefaultMaxEnrollments,
+		bridge:         gb,
+		sales:          sales,
+		templates:      templates,
+		optOuts:        optOuts,
+		campaigns:      make(map[string]WinBackCampaign, len(set.Campaigns)),
+		path:           path,
+		enrollments:    make(map[string][]*WinBackEnrollment),
+	}
+	for _, campaign := range set.Campaigns {
+		wb.campaigns[campaign.Name] = campaign
+		wb.names = append(wb.names, campaign.Name)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read win-back enrollments: %v", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &wb.enrollments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse win-back enrollments %s: %v", path, err)
+		}
+	}
+
+	gb.OnReceive(wb.handleMessage)
+	return wb, nil
+}
+
+func (wb *WinBack) handleMessage(message *UniversalMessage) {
+	if !isGumroadEvent(message) {
+		return
+	}
+
+	payload := message.Payload
+	now := wb.bridge.clock.Now()
+	var err error
+	switch stringArg(payload, "resource_name") {
+	case "subscription_ended":
+		email, _ := lookupField(payload, "customer.email")
+		address, _ := email.(string)
+		if address == "" {
+			address = customerEmail(payload)
+		}
+		err = wb.Enroll(WinBackChurned, address, now, payload, productKey(payload))
+	case "subscription_restarted":
+		email, _ := lookupField(payload, "customer.email")
+		address, _ := email.(string)
+		wb.convert(address, now, "", nil, WinBackChurned, productKey(payload))
+	case "refund":
+		// RecordSales has already marked the stored sale refunded
+		sale, ok := wb.sales.Sale(stringArg(payload, "sale_id"))
+		if ok {
+			err = wb.Enroll(WinBackRefunded, sale.Email, now, sale.Fields, sale.Product, sale.Permalink)
+		}
+	case "sale":
+		sale, parseErr := ParseSale(payload)
+		switch {
+		case parseErr != nil || sale.BundleSaleID != "":
+		case sale.Refunded:
+			err = wb.Enroll(WinBackRefunded, sale.Email, now, sale.Fields, sale.Product, sale.Permalink)
+		case wb.refunded(sale.ID):
+			// A replayed ping of a sale refunded since is not a purchase
+		default:
+			at := now
+			if !sale.CreatedAt.IsZero() {
+				at = sale.CreatedAt
+			}
+			price := sale.Price
+			wb.convert(sale.Email, at, sale.ID, &price, "", sale.Product, sale.Permalink)
+		}
+	}
+	if err != nil {
+		log.Printf("❌ Error enrolling %s in win-back: %v", message.ID, err)
+	}
+}
+
+func (wb *WinBack) refunded(saleID string) bool {
+	stored, ok := wb.sales.Sale(saleID)
+	return ok && stored.Refunded && saleID != ""
+}
+
+// Enroll starts every campaign for the trigger that covers one of the
+// products, unless the customer is already in it. fields are the event's
+// payload, from which the product name and locale are taken.
+func (wb *WinBack) Enroll(trigger, email string, at time.Time, fields map[string]interface{}, products ...string) error {
+	email = NormalizeEmail(email)
+	if email == "" {
+		return nil
+	}
+
+	productName := stringArg(fields, "product_name")
+	if productName == "" {
+		// Membership events carry only the product's ID
+		for _, sale := range wb.sales.ByEmail(email) {
+			if sale.ProductName != "" && (sale.Product == products[0] || sale.Permalink == products[0]) {
+				productName = sale.ProductName
+			}
+		}
+	}
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	enrolled := 0
+	for _, name := range wb.names {
+		campaign := wb.campaigns[name]
+		if campaign.Trigger != trigger || !campaign.covers(products...) || wb.openLocked(email, name, at) != nil {
+			continue
+		}
+		if wb.MaxEnrollments > 0 && wb.countLocked() >= wb.MaxEnrollments {
+			return ErrTooManyEnrollments
+		}
+		wb.enrollments[email] = append(wb.enrollments[email], &WinBackEnrollment{
+			Campaign:    name,
+			Email:       email,
+			Product:     products[0],
+			ProductName: productName,
+			Locale:      DetectLocale(fields),
+			TriggeredAt: at,
+		})
+		enrolled++
+	}
+	if enrolled == 0 {
+		return nil
+	}
+	fmt.Printf("🔁 Enrolled %s in %d win-back campaigns\n", email, enrolled)
+	return wb.saveLocked()
+}
+
+// openLocked returns the customer's enrollment in the campaign that can
+// still be won back at the time
+func (wb *WinBack) openLocked(email, name string, at time.Time) *WinBackEnrollment {
+	for _, enrollment := range wb.enrollments[email] {
+		if enrollment.Campaign == name && enrollment.ConvertedAt == nil && !at.After(wb.campaigns[name].deadline(enrollment)) {
+			return enrollment
+		}
+	}
+	return nil
+}
+
+func (wb *WinBack) countLocked() int {
+	count := 0
+	for _, enrollments := range wb.enrollments {
+		count += len(enrollments)
+	}
+	return count
+}
+
+// convert marks the customer's open enrollments won back by a purchase or,
+// for a sale ID of "", a restarted membership. trigger limits it to
+// campaigns of one trigger; products to campaigns that cover them.
+func (wb *WinBack) convert(email string, at time.Time, saleID string, price *Money, trigger string, products ...string) {
+	email = NormalizeEmail(email)
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	converted := false
+	for _, enrollment := range wb.enrollments[email] {
+		campaign, ok := wb.campaigns[enrollment.Campaign]
+		if !ok || (trigger != "" && (campaign.Trigger != trigger || !campaign.covers(products...))) {
+			continue
+		}
+		if enrollment.ConvertedAt != nil || at.Before(enrollment.TriggeredAt) || at.After(campaign.deadline(enrollment)) {
+			continue
+		}
+		convertedAt := at
+		enrollment.ConvertedAt = &convertedAt
+		enrollment.SaleID = saleID
+		enrollment.Revenue = price
+		converted = true
+	}
+	if !converted {
+		return
+	}
+	err := wb.saveLocked()
+	if err != nil {
+		log.Printf("❌ Error saving win-back enrollments: %v", err)
+	}
+}
+
+// SendDue emails each enrollment whose next step is due, skipping opted-out
+// addresses. A full email sink leaves the rest for the next run.
+func (wb *WinBack) SendDue() error {
+	sink := wb.bridge.Sink("email")
+	if sink == nil {
+		return fmt.Errorf("no email sink registered")
+	}
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	now := wb.bridge.clock.Now()
+	sent, changed := 0, false
+send:
+	for _, enrollments := range wb.enrollments {
+		for _, enrollment := range enrollments {
+			campaign, ok := wb.campaigns[enrollment.Campaign]
+			if !ok || enrollment.ConvertedAt != nil || enrollment.OptedOut || enrollment.Steps >= len(campaign.Steps) {
+				continue
+			}
+			if now.Before(campaign.due(enrollment)) {
+				continue
+			}
+			if wb.optOuts != nil && wb.optOuts.Contains(enrollment.Email) {
+				enrollment.OptedOut = true
+				changed = true
+				continue
+			}
+
+			data := map[string]interface{}{
+				"email":        enrollment.Email,
+				"campaign":     enrollment.Campaign,
+				"trigger":      campaign.Trigger,
+				"product":      enrollment.Product,
+				"product_name": enrollment.ProductName,
+				"offer_code":   campaign.OfferCode,
+				"step":         enrollment.Steps + 1,
+				"steps":        len(campaign.Steps),
+			}
+			if enrollment.ProductName == "" {
+				data["product_name"] = enrollment.Product
+			}
+			template := campaign.Steps[enrollment.Steps].Template
+			text, err := wb.templates.RenderLocale(template, enrollment.Product, enrollment.Locale, data)
+			if err == nil {
+				err = sink.Enqueue(wb.bridge.NewMessage(DataSync, "go", map[string]interface{}{
+					"to":   enrollment.Email,
+					"text": text,
+				}, FileSystem))
+			}
+			if err == ErrSinkFull {
+				break send
+			}
+			if err != nil {
+				log.Printf("❌ Win-back email to %s failed: %v", enrollment.Email, err)
+				continue
+			}
+			enrollment.Steps++
+			sent++
+		}
+	}
+
+	if sent > 0 {
+		fmt.Printf("🔁 Sent %d win-back emails\n", sent)
+	}
+	if sent == 0 && !changed {
+		return nil
+	}
+	return wb.saveLocked()
+}
+
+// Enrollments returns the customer's enrollments, oldest first
+func (wb *WinBack) Enrollments(email string) []WinBackEnrollment {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	var list []WinBackEnrollment
+	for _, enrollment := range wb.enrollments[NormalizeEmail(email)] {
+		list = append(list, *enrollment)
+	}
+	return list
+}
+
+// Stats returns each campaign's outcomes in the order they are defined
+func (wb *WinBack) Stats() []WinBackStats {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	now := wb.bridge.clock.Now()
+	totals := make(map[string]*WinBackStats, len(wb.names))
+	for _, name := range wb.names {
+		totals[name] = &WinBackStats{Campaign: name, Revenue: MoneyTotals{}}
+	}
+	for _, enrollments := range wb.enrollments {
+		for _, enrollment := range enrollments {
+			stats := totals[enrollment.Campaign]
+			if stats == nil {
+				continue
+			}
+			stats.Enrolled++
+			if enrollment.Steps > 0 {
+				stats.Messaged++
+			}
+			switch {
+			case enrollment.ConvertedAt != nil && enrollment.Steps > 0:
+				stats.Won++
+				if enrollment.Revenue != nil {
+					stats.Revenue.Add(*enrollment.Revenue)
+				}
+			case enrollment.ConvertedAt != nil:
+				stats.Returned++
+			case !enrollment.OptedOut && !now.After(wb.campaigns[enrollment.Campaign].deadline(enrollment)):
+				stats.Active++
+			default:
+				stats.Lost++
+			}
+		}
+	}
+
+	all := make([]WinBackStats, 0, len(wb.names))
+	for _, name := range wb.names {
+		stats := totals[name]
+		if stats.Messaged > 0 {
+			stats.Rate = float64(stats.Won) / float64(stats.Messaged)
+		}
+		all = append(all, *stats)
+	}
+	return all
+}
+
+// Handler serves GET /metrics/winback with each campaign's conversion
+func (wb *WinBack) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{"campaigns": wb.Stats()})
+	})
+}
+
+func (wb *WinBack) saveLocked() error {
+	return writeJSONFile(wb.path, wb.enrollments)
+}
+
+// RegisterWinBackJobs adds the winback_emails job handler, which sends
+// whichever win-back steps are due
+func RegisterWinBackJobs(s *Scheduler, winBack *WinBack) {
+	s.Handle("winback_emails", func(job Job) error {
+		return winBack.SendDue()
+	})
+}
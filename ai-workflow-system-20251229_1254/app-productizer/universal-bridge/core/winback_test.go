@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWinBack(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 1, 15, 9, 30, 0, 0, time.UTC))
+	transport := NewMemoryTransport()
+	gb := NewGoBridge("", WithClock(clock), WithTransport(transport), WithIDGenerator(NewSequentialIDs("go")))
+	t.Cleanup(func() { gb.Close() })
+	// Recorded as they are queued, since the sink delivers in the background
+	var emails []string
+	sink := gb.AddSink("email", SinkConfig{}, func(*UniversalMessage) error { return nil })
+	sink.SetTransform(func(message *UniversalMessage) *UniversalMessage {
+		emails = append(emails, stringArg(message.Payload, "to")+" "+strings.SplitN(stringArg(message.Payload, "text"), "\n", 2)[0])
+		return message
+	})
+
+	sales, err := NewSalesStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordSales(gb, sales)
+	if _, err := NewCustomers(gb, sales, filepath.Join(t.TempDir(), "customers.json")); err != nil {
+		t.Fatal(err)
+	}
+	optOuts, err := NewOptOutList(filepath.Join(t.TempDir(), "optouts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadWinBack("winback.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Campaigns[0].Products = []string{"club"}
+	path := filepath.Join(t.TempDir(), "enrollments.json")
+	winBack, err := NewWinBack(gb, sales, NewTemplateStore("templates"), optOuts, set, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := NewSequentialIDs("py")
+	deliver := func(payload map[string]interface{}) {
+		t.Helper()
+		if reason := transport.Inject(newUniversalMessage(clock, ids, DataSync, "python", "go", payload, SharedMemory)); reason != nil {
+			t.Fatal(reason)
+		}
+	}
+	sale := func(id, email, product, subscription string) {
+		deliver(map[string]interface{}{"resource_name": "sale", "sale_id": id, "product_id": product, "product_name": "The Club", "email": email, "price": 20, "currency": "usd", "subscription_id": subscription, "sale_timestamp": clock.Now().Format(time.RFC3339)})
+	}
+	sendDue := func() []string {
+		t.Helper()
+		emails = nil
+		if err := winBack.SendDue(); err != nil {
+			t.Fatal(err)
+		}
+		return emails
+	}
+
+	sale("s1", "ada@example.com", "club", "sub-1")
+	sale("s2", "bo@example.com", "course", "")
+	sale("s3", "cy@example.com", "club", "sub-2")
+	sale("s4", "dee@example.com", "club", "sub-3")
+	deliver(map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub-1", "product_id": "club"})
+	deliver(map[string]interface{}{"resource_name": "refund", "sale_id": "s2", "product_id": "course", "email": "bo@example.com", "price": 20})
+	deliver(map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub-2", "product_id": "club"})
+	deliver(map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub-3", "product_id": "club"})
+	if err := optOuts.Add("dee@example.com", "unsubscribed"); err != nil {
+		t.Fatal(err)
+	}
+	// Ending twice does not enroll twice
+	deliver(map[string]interface{}{"resource_name": "subscription_ended", "subscription_id": "sub-1", "product_id": "club"})
+
+	if got := sendDue(); len(got) != 0 {
+		t.Errorf("sent during the cooling-off: %v", got)
+	}
+	clock.Advance(24 * time.Hour)
+	sale("s5", "cy@example.com", "club", "sub-4")
+
+	clock.Advance(13 * 24 * time.Hour)
+	want := []string{"ada@example.com Subject: We'd love to have you back at The Club"}
+	if got := sendDue(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after the cooling-off sent %v, want %v", got, want)
+	}
+	clock.Advance(7 * 24 * time.Hour)
+	want = []string{"ada@example.com Subject: Last call: We'd love to have you back at The Club"}
+	if got := sendDue(); !reflect.DeepEqual(got, want) {
+		t.Errorf("second step sent %v, want %v", got, want)
+	}
+	sale("s6", "ada@example.com", "course", "")
+
+	clock.Advance(9 * 24 * time.Hour)
+	if got := sendDue(); len(got) != 1 || !strings.HasPrefix(got[0], "bo@example.com") {
+		t.Errorf("refund campaign sent %v", got)
+	}
+	if got := sendDue(); len(got) != 0 {
+		t.Errorf("sent again: %v", got)
+	}
+
+	stats := winBack.Stats()
+	lapsed := WinBackStats{Campaign: "lapsed_members", Enrolled: 3, Messaged: 1, Won: 1, Returned: 1, Lost: 1, Rate: 1, Revenue: MoneyTotals{"usd": 2000}}
+	refunded := WinBackStats{Campaign: "refunded_buyers", Enrolled: 1, Messaged: 1, Active: 1, Revenue: MoneyTotals{}}
+	if !reflect.DeepEqual(stats, []WinBackStats{lapsed, refunded}) {
+		t.Errorf("stats %+v", stats)
+	}
+	if enrollments := winBack.Enrollments("Ada@Example.com"); len(enrollments) != 1 || enrollments[0].SaleID != "s6" || enrollments[0].Steps != 2 {
+		t.Errorf("ada's enrollments %+v", enrollments)
+	}
+
+	// Past its window the refund campaign has lost bo
+	clock.Advance(61 * 24 * time.Hour)
+	refunded.Active, refunded.Lost = 0, 1
+	reopened, err := NewWinBack(gb, sales, NewTemplateStore("templates"), optOuts, set, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := reopened.Stats(); !reflect.DeepEqual(stats[1], refunded) {
+		t.Errorf("reopened %+v", stats[1])
+	}
+}
+
+func TestInvalidWinBack(t *testing.T) {
+	step := []WinBackStep{{Template: "winback"}}
+	tests := []struct {
+		name     string
+		campaign WinBackCampaign
+	}{
+		{"no name", WinBackCampaign{Trigger: WinBackChurned, Steps: step}},
+		{"trigger", WinBackCampaign{Name: "lapsed", Trigger: "cancelled", Steps: step}},
+		{"no steps", WinBackCampaign{Name: "lapsed", Trigger: WinBackChurned}},
+		{"no template", WinBackCampaign{Name: "lapsed", Trigger: WinBackChurned, Steps: []WinBackStep{{}}}},
+		{"out of order", WinBackCampaign{Name: "lapsed", Trigger: WinBackChurned, Steps: []WinBackStep{{After: time.Hour, Template: "winback"}, {Template: "winback"}}}},
+		{"negative cooling-off", WinBackCampaign{Name: "lapsed", Trigger: WinBackRefunded, CoolingOff: -time.Hour, Steps: step}},
+	}
+	for _, test := range tests {
+		set := WinBackSet{Campaigns: []WinBackCampaign{test.campaign}}
+		if err := set.Validate(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
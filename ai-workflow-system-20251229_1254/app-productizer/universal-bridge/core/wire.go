@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxBinaryFrameSize caps the length prefix accepted from the binary wire format
+const MaxBinaryFrameSize = 64 << 20
+
+// ToBinary encodes the message in the binary wire format shared with the
+// Python bridge: [length:4 big-endian][json:length]
+func (m *UniversalMessage) ToBinary() ([]byte, error) {
+	jsonStr, err := m.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	if len(jsonStr) > MaxBinaryFrameSize {
+		return nil, &PayloadTooLargeError{MessageID: m.ID, Channel: BinarySocket, Size: int64(len(jsonStr)), Limit: MaxBinaryFrameSize}
+	}
+
+	frame := make([]byte, 4+len(jsonStr))
+	binary.BigEndian.PutUint32(frame, uint32(len(jsonStr)))
+	copy(frame[4:], jsonStr)
+	return frame, nil
+}
+
+// FromBinary decodes a single binary frame
+func FromBinary(data []byte) (*UniversalMessage, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid binary data - too short")
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if length > MaxBinaryFrameSize {
+		return nil, &PayloadTooLargeError{Channel: BinarySocket, Size: int64(length), Limit: MaxBinaryFrameSize}
+	}
+	if uint64(len(data)-4) < uint64(length) {
+		return nil, fmt.Errorf("binary frame truncated: want %d bytes, have %d", length, len(data)-4)
+	}
+
+	return FromJSON(string(data[4 : 4+length]))
+}
+
+// ReadBinaryMessage reads one binary frame from a stream such as a socket
+func ReadBinaryMessage(r io.Reader) (*UniversalMessage, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MaxBinaryFrameSize {
+		return nil, &PayloadTooLargeError{Channel: BinarySocket, Size: int64(length), Limit: MaxBinaryFrameSize}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading binary frame: %v", err)
+	}
+	return FromJSON(string(body))
+}